@@ -12,6 +12,7 @@ import (
 	"devops-manager/agent/pkg/config"
 	"devops-manager/agent/pkg/controller"
 	"devops-manager/agent/pkg/service"
+	"devops-manager/agent/pkg/utils"
 
 	"github.com/gin-gonic/gin"
 	"google.golang.org/grpc"
@@ -25,10 +26,10 @@ var (
 	grpcPort   = flag.String("grpc-port", ":50052", "gRPC server port for receiving commands")
 )
 
-const (
-	AppName    = "DevOps Manager Agent"
-	AppVersion = "1.0.0"
-)
+const AppName = "DevOps Manager Agent"
+
+// AppVersion Agent 版本，发布时通过 -ldflags "-X main.AppVersion=<version>" 设置
+var AppVersion = "1.0.0"
 
 func main() {
 	flag.Parse()
@@ -44,8 +45,47 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	// 自升级：先检查上次升级的状态，新版本未能按期连上 Server 时在此回滚
+	if !cfg.Upgrade.Disabled {
+		upgrader, err := service.NewAgentUpgrader(cfg.Upgrade, AppVersion)
+		if err != nil {
+			log.Printf("Warning: agent upgrade disabled: %v", err)
+		} else {
+			upgrader.Recover()
+			service.SetAgentUpgrader(upgrader)
+		}
+	}
+
+	// 加载命令执行策略
+	policy, err := service.NewCommandPolicy(cfg.Policy, cfg.Agent.Tags)
+	if err != nil {
+		log.Fatalf("Failed to load command policy: %v", err)
+	}
+	service.SetCommandPolicy(policy)
+	if cfg.Policy.DryRun {
+		log.Println("Command policy running in dry-run mode, rejections are only logged")
+	}
+
+	// 设置命令输出上限
+	utils.SetMaxOutputBytes(cfg.Output.MaxBytes)
+	utils.SetKillGracePeriod(cfg.Execution.KillGracePeriod)
+	runAsPolicy, err := service.NewRunAsPolicy(cfg.Execution)
+	if err != nil {
+		log.Fatalf("Failed to load run as policy: %v", err)
+	}
+	service.SetRunAsPolicy(runAsPolicy)
+	service.SetFileReceiver(service.NewFileReceiver(cfg.Files, cfg.Peer, cfg.Server.TLS))
+	service.SetFileCollector(service.NewFileCollector(cfg.Files))
+	// 分片缓存不可用时对等分发的分片每次都重新获取，也不向其他 Agent 提供分片
+	if cache, err := service.NewChunkCache(cfg.Peer.CacheDir, cfg.Peer.CacheMaxBytes); err != nil {
+		log.Printf("Warning: chunk cache disabled: %v", err)
+	} else {
+		service.SetChunkCache(cache)
+	}
+	service.SetExecutionQueue(service.NewExecutionQueue(cfg.Agent.MaxConcurrentCommands, cfg.Agent.CommandQueueSize))
+
 	// 创建主机代理服务
-	hostAgent := service.NewHostAgent(cfg)
+	hostAgent := service.NewHostAgent(cfg, AppVersion)
 
 	if *enableWeb {
 		// 启动带Web界面的模式
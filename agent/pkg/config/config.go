@@ -9,21 +9,129 @@ import (
 )
 
 type Config struct {
-	Server ServerConfig `yaml:"server"`
-	Agent  AgentConfig  `yaml:"agent"`
-	Log    LogConfig    `yaml:"logging"`
+	Server    ServerConfig    `yaml:"server"`
+	Agent     AgentConfig     `yaml:"agent"`
+	Policy    PolicyConfig    `yaml:"policy"`
+	Output    OutputConfig    `yaml:"output"`
+	Execution ExecutionConfig `yaml:"execution"`
+	Outbox    OutboxConfig    `yaml:"outbox"`
+	Delivery  DeliveryConfig  `yaml:"delivery"`
+	Files     FilesConfig     `yaml:"files"`
+	Peer      PeerConfig      `yaml:"peer"`
+	Upgrade   UpgradeConfig   `yaml:"upgrade"`
+	Log       LogConfig       `yaml:"logging"`
 }
 
 type ServerConfig struct {
 	Address       string        `yaml:"address"`
 	Timeout       time.Duration `yaml:"timeout"`
 	RetryInterval time.Duration `yaml:"retry_interval"`
+	AuthToken     string        `yaml:"auth_token"` // 命令流握手认证令牌
+	TLS           TLSConfig     `yaml:"tls"`
+
+	// 命令流发送队列：缓冲消息数，以及入队等待和单条消息写入的超时，写入超时视为连接阻塞并重连
+	SendQueueSize int           `yaml:"send_queue_size"`
+	SendTimeout   time.Duration `yaml:"send_timeout"`
+}
+
+// TLSConfig 与 Server 之间的双向 TLS 配置
+type TLSConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	CertFile   string `yaml:"cert_file"`   // Agent 证书，CN 须与主机ID一致
+	KeyFile    string `yaml:"key_file"`    // Agent 私钥
+	CAFile     string `yaml:"ca_file"`     // 用于校验 Server 证书的 CA
+	ServerName string `yaml:"server_name"` // Server 证书名称，为空时使用连接地址中的主机名
+
+	// 通过 Server 内置 CA 入网：证书不存在时携带 CSR 注册，准入后自动保存 Server 签发的证书
+	Enroll      bool          `yaml:"enroll"`
+	RenewBefore time.Duration `yaml:"renew_before"` // 证书到期前多久申请轮换
 }
 
 type AgentConfig struct {
-	ReportInterval time.Duration     `yaml:"report_interval"`
-	AgentID        string            `yaml:"agent_id"`
-	Tags           map[string]string `yaml:"tags"`
+	ReportInterval    time.Duration     `yaml:"report_interval"`
+	HeartbeatInterval time.Duration     `yaml:"heartbeat_interval"` // 命令流心跳间隔
+	AgentID           string            `yaml:"agent_id"`
+	Tags              map[string]string `yaml:"tags"`
+
+	// 命令执行队列：最多同时执行 MaxConcurrentCommands 条命令，其余按 FIFO 排队，排满后拒绝
+	MaxConcurrentCommands int `yaml:"max_concurrent_commands"`
+	CommandQueueSize      int `yaml:"command_queue_size"`
+}
+
+// PolicyConfig 命令执行策略
+type PolicyConfig struct {
+	DryRun bool         `yaml:"dry_run"` // 只记录将被拒绝的命令，不实际拦截
+	Rules  []PolicyRule `yaml:"rules"`   // 未配置时使用 DefaultPolicyRules
+}
+
+// PolicyRule 命令策略规则，regex 和 glob 二选一
+// 命令匹配任一 deny 规则即被拒绝；存在生效的 allow 规则时，命令必须至少匹配其中一条
+type PolicyRule struct {
+	Name   string            `yaml:"name"`
+	Action string            `yaml:"action"` // allow 或 deny
+	Regex  string            `yaml:"regex"`  // 正则表达式，匹配命令中的任意位置
+	Glob   string            `yaml:"glob"`   // 通配符，匹配整条命令，* 匹配任意字符，? 匹配单个字符
+	Tags   map[string]string `yaml:"tags"`   // 仅在 Agent 标签全部匹配时生效
+	Users  []string          `yaml:"users"`  // 仅对这些用户发起的命令生效，为空时对所有用户生效
+}
+
+// DefaultPolicyRules 默认拒绝规则：删除根目录、格式化磁盘和关机重启
+func DefaultPolicyRules() []PolicyRule {
+	return []PolicyRule{
+		{Name: "deny-rm-root", Action: "deny", Regex: `(^|[\s;&|(])rm\s+(-\S+\s+)*/\*?(\s|$)`},
+		{Name: "deny-format-disk", Action: "deny", Regex: `(?i)(^|[\s;&|(])(mkfs(\.\w+)?\s|format\s+[a-z]:)`},
+		{Name: "deny-del-recursive", Action: "deny", Regex: `(?i)(^|[\s;&|(])del\s+(/\w\s+)*/s\b`},
+		{Name: "deny-shutdown", Action: "deny", Regex: `(^|[\s;&|(])(shutdown|reboot|halt|poweroff|init\s+[06])(\s|$)`},
+	}
+}
+
+// OutputConfig 命令输出配置
+type OutputConfig struct {
+	MaxBytes int `yaml:"max_bytes"` // 执行结果中每个输出流保留的最大字节数，超出时保留首尾各一半
+}
+
+// ExecutionConfig 命令执行配置
+type ExecutionConfig struct {
+	KillGracePeriod time.Duration `yaml:"kill_grace_period"` // 超时或取消时发送 SIGTERM 后等待多久再发送 SIGKILL
+	DefaultUser     string        `yaml:"default_user"`      // 命令未指定执行用户时使用的用户，为空时以 Agent 运行用户执行，Agent 以 root 运行时必须配置
+	AllowedUsers    []string      `yaml:"allowed_users"`     // 允许指定的执行用户，为空时只允许默认执行用户，默认执行用户总是允许
+	AllowedGroups   []string      `yaml:"allowed_groups"`    // 额外允许指定的执行用户组，执行用户自身的主组和附加组总是允许
+}
+
+// OutboxConfig 结果发件箱配置，命令流断开期间的执行结果保存在本地，重连后重发
+type OutboxConfig struct {
+	Dir      string `yaml:"dir"`       // 发件箱目录
+	MaxBytes int64  `yaml:"max_bytes"` // 磁盘占用上限，超出时丢弃最早的未确认结果
+}
+
+// DeliveryConfig 命令投递去重配置，保留窗口内重复投递的同一命令不再执行
+type DeliveryConfig struct {
+	DedupeFile   string        `yaml:"dedupe_file"`   // 投递记录文件
+	DedupeWindow time.Duration `yaml:"dedupe_window"` // 投递记录保留时长
+}
+
+// FilesConfig 文件分发配置
+type FilesConfig struct {
+	AllowedDirs      []string      `yaml:"allowed_dirs"`       // 允许写入的目录，为空时拒绝所有文件分发
+	FetchAllowedDirs []string      `yaml:"fetch_allowed_dirs"` // 允许收集的目录，为空时拒绝所有文件收集
+	IdleTimeout      time.Duration `yaml:"idle_timeout"`       // 超过该时长未收到文件分片时中止接收
+	ResumeTimeout    time.Duration `yaml:"resume_timeout"`     // 按分片接收时命令流断开后等待重连继续的时长，超过后中止接收
+}
+
+// PeerConfig 对等分发配置，Agent 缓存已接收的文件分片，并可提供给同区域的其他 Agent
+type PeerConfig struct {
+	Listen        string        `yaml:"listen"`          // 分片服务监听地址，如 ":9100"，需启用 server.tls，为空时不向其他 Agent 提供分片
+	AdvertiseAddr string        `yaml:"advertise_addr"`  // 告知 Server 的分片服务地址，为空时使用本机 IP 和监听端口
+	CacheDir      string        `yaml:"cache_dir"`       // 分片缓存目录，按分片 SHA-256 保存
+	CacheMaxBytes int64         `yaml:"cache_max_bytes"` // 缓存占用上限，超出时删除最久未使用的分片
+	FetchTimeout  time.Duration `yaml:"fetch_timeout"`   // 从其他 Agent 获取一个分片的超时
+}
+
+// UpgradeConfig Agent 自升级配置，新版本二进制由 Server 按升级计划下发
+type UpgradeConfig struct {
+	Disabled      bool   `yaml:"disabled"`        // 不接受 Server 下发的升级
+	PublicKeyFile string `yaml:"public_key_file"` // 校验新版本签名的 Ed25519 公钥（PEM），配置后拒绝未签名的升级
+	StateFile     string `yaml:"state_file"`      // 升级状态文件，新版本启动后据此确认升级或回滚
 }
 
 type LogConfig struct {
@@ -63,15 +171,52 @@ func getDefaultConfig() *Config {
 			Address:       "localhost:50051",
 			Timeout:       10 * time.Second,
 			RetryInterval: 5 * time.Second,
+			SendQueueSize: 256,
+			SendTimeout:   10 * time.Second,
+			TLS: TLSConfig{
+				RenewBefore: 7 * 24 * time.Hour,
+			},
 		},
 		Agent: AgentConfig{
-			ReportInterval: 30 * time.Second,
-			AgentID:        "",
+			ReportInterval:    30 * time.Second,
+			HeartbeatInterval: 30 * time.Second,
+			AgentID:           "",
 			Tags: map[string]string{
 				"role":    "agent",
 				"env":     "production",
 				"version": "1.0.0",
 			},
+			MaxConcurrentCommands: 4,
+			CommandQueueSize:      100,
+		},
+		Policy: PolicyConfig{
+			Rules: DefaultPolicyRules(),
+		},
+		Output: OutputConfig{
+			MaxBytes: 1024 * 1024,
+		},
+		Execution: ExecutionConfig{
+			KillGracePeriod: 5 * time.Second,
+		},
+		Outbox: OutboxConfig{
+			Dir:      filepath.Join("agent", "data", "outbox"),
+			MaxBytes: 64 * 1024 * 1024,
+		},
+		Delivery: DeliveryConfig{
+			DedupeFile:   filepath.Join("agent", "data", "delivered.log"),
+			DedupeWindow: 7 * 24 * time.Hour,
+		},
+		Files: FilesConfig{
+			IdleTimeout:   60 * time.Second,
+			ResumeTimeout: 10 * time.Minute,
+		},
+		Peer: PeerConfig{
+			CacheDir:      filepath.Join("agent", "data", "chunks"),
+			CacheMaxBytes: 2 * 1024 * 1024 * 1024,
+			FetchTimeout:  30 * time.Second,
+		},
+		Upgrade: UpgradeConfig{
+			StateFile: filepath.Join("agent", "data", "upgrade.json"),
 		},
 		Log: LogConfig{
 			Level:  "info",
@@ -92,12 +237,69 @@ func mergeDefaults(config *Config) {
 	if config.Server.RetryInterval == 0 {
 		config.Server.RetryInterval = defaults.Server.RetryInterval
 	}
+	if config.Server.SendQueueSize <= 0 {
+		config.Server.SendQueueSize = defaults.Server.SendQueueSize
+	}
+	if config.Server.SendTimeout <= 0 {
+		config.Server.SendTimeout = defaults.Server.SendTimeout
+	}
+	if config.Server.TLS.RenewBefore == 0 {
+		config.Server.TLS.RenewBefore = defaults.Server.TLS.RenewBefore
+	}
 	if config.Agent.ReportInterval == 0 {
 		config.Agent.ReportInterval = defaults.Agent.ReportInterval
 	}
+	if config.Agent.HeartbeatInterval == 0 {
+		config.Agent.HeartbeatInterval = defaults.Agent.HeartbeatInterval
+	}
 	if config.Agent.Tags == nil {
 		config.Agent.Tags = defaults.Agent.Tags
 	}
+	if config.Agent.MaxConcurrentCommands <= 0 {
+		config.Agent.MaxConcurrentCommands = defaults.Agent.MaxConcurrentCommands
+	}
+	if config.Agent.CommandQueueSize <= 0 {
+		config.Agent.CommandQueueSize = defaults.Agent.CommandQueueSize
+	}
+	if config.Policy.Rules == nil {
+		config.Policy.Rules = defaults.Policy.Rules
+	}
+	if config.Output.MaxBytes <= 0 {
+		config.Output.MaxBytes = defaults.Output.MaxBytes
+	}
+	if config.Execution.KillGracePeriod <= 0 {
+		config.Execution.KillGracePeriod = defaults.Execution.KillGracePeriod
+	}
+	if config.Outbox.Dir == "" {
+		config.Outbox.Dir = defaults.Outbox.Dir
+	}
+	if config.Outbox.MaxBytes <= 0 {
+		config.Outbox.MaxBytes = defaults.Outbox.MaxBytes
+	}
+	if config.Delivery.DedupeFile == "" {
+		config.Delivery.DedupeFile = defaults.Delivery.DedupeFile
+	}
+	if config.Delivery.DedupeWindow <= 0 {
+		config.Delivery.DedupeWindow = defaults.Delivery.DedupeWindow
+	}
+	if config.Files.IdleTimeout <= 0 {
+		config.Files.IdleTimeout = defaults.Files.IdleTimeout
+	}
+	if config.Files.ResumeTimeout <= 0 {
+		config.Files.ResumeTimeout = defaults.Files.ResumeTimeout
+	}
+	if config.Peer.CacheDir == "" {
+		config.Peer.CacheDir = defaults.Peer.CacheDir
+	}
+	if config.Peer.CacheMaxBytes <= 0 {
+		config.Peer.CacheMaxBytes = defaults.Peer.CacheMaxBytes
+	}
+	if config.Peer.FetchTimeout <= 0 {
+		config.Peer.FetchTimeout = defaults.Peer.FetchTimeout
+	}
+	if config.Upgrade.StateFile == "" {
+		config.Upgrade.StateFile = defaults.Upgrade.StateFile
+	}
 	if config.Log.Level == "" {
 		config.Log.Level = defaults.Log.Level
 	}
@@ -6,6 +6,7 @@ import (
 
 	"devops-manager/agent/pkg/service"
 	"devops-manager/api/protobuf"
+	"devops-manager/api/sendqueue"
 )
 
 // TaskGRPCController 任务gRPC业务控制器
@@ -27,70 +28,64 @@ func (tgc *TaskGRPCController) ConnectForCommands(stream protobuf.CommandService
 
 	log.Println("Command stream established with server")
 
+	// 多个命令并发执行，结果经由发送队列串行写入流
+	sender := sendqueue.New(stream.Send, func(err error) {
+		log.Printf("Error sending on command stream: %v", err)
+	}, 0, 0)
+	defer sender.Close()
+
+	messages := make(chan *protobuf.CommandMessage)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			// 接收来自Server的命令
+			msg, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case messages <- msg:
+			case <-sender.Done():
+				return
+			}
+		}
+	}()
+
 	for {
-		// 接收来自Server的命令
-		msg, err := stream.Recv()
-		if err != nil {
+		select {
+		case <-sender.Done():
+			return sender.Err()
+		case err := <-recvErr:
 			log.Printf("Error receiving command: %v", err)
 			return err
-		}
-
-		// 处理命令
-		if commandContent := msg.GetCommandContent(); commandContent != nil {
-			go tgc.handleCommand(stream, commandContent)
+		case msg := <-messages:
+			// 处理命令
+			if commandContent := msg.GetCommandContent(); commandContent != nil {
+				go tgc.handleCommand(sender, commandContent)
+			}
 		}
 	}
 }
 
 // handleCommand 处理单个命令
-func (tgc *TaskGRPCController) handleCommand(stream protobuf.CommandService_ConnectForCommandsServer, cmd *protobuf.CommandContent) {
+func (tgc *TaskGRPCController) handleCommand(sender *sendqueue.Queue, cmd *protobuf.CommandContent) {
 	LogGRPCRequest("HandleCommand", cmd.CommandId)
 
-	log.Printf("Executing command: %s", cmd.Command)
-
-	// 设置超时时间
-	timeout := 30 * time.Second
-	if cmd.Timeout != nil {
-		timeout = cmd.Timeout.AsDuration()
-	}
-
 	// 执行命令
-	result, err := tgc.taskService.ExecuteTask(cmd.CommandId, cmd.Command, timeout)
-
-	// 构建响应
-	var commandResult *protobuf.CommandResult
-	if err != nil {
-		commandResult = &protobuf.CommandResult{
-			CommandId:    cmd.CommandId,
-			HostId:       cmd.HostId,
-			Stdout:       "",
-			Stderr:       err.Error(),
-			ExitCode:     -1,
-			ErrorMessage: err.Error(),
-		}
-	} else {
-		commandResult = &protobuf.CommandResult{
-			CommandId:    cmd.CommandId,
-			HostId:       cmd.HostId,
-			Stdout:       result.Stdout,
-			Stderr:       result.Stderr,
-			ExitCode:     int32(result.ExitCode),
-			ErrorMessage: result.Error,
-		}
-	}
+	commandResult := tgc.taskService.HandleCommand(cmd, nil)
 
 	// 发送结果
 	response := &protobuf.CommandMessage{
-		CommandResult: commandResult,
+		Payload: &protobuf.CommandMessage_CommandResult{CommandResult: commandResult},
 	}
 
-	if err := stream.Send(response); err != nil {
+	if err := sender.Send(response); err != nil {
 		log.Printf("Error sending command result: %v", err)
 		return
 	}
 
-	LogGRPCResponse("HandleCommand", result.ExitCode == 0, "Command executed")
-	log.Printf("Command %s completed with exit code: %d", cmd.CommandId, result.ExitCode)
+	LogGRPCResponse("HandleCommand", commandResult.ExitCode == 0, "Command executed")
 }
 
 // GetTaskStatus 获取任务状态（内部方法）
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -43,9 +44,14 @@ func (thc *TaskHTTPController) ExecuteTask(c *gin.Context) {
 		timeout = time.Duration(req.Timeout) * time.Second
 	}
 
-	// 执行任务
-	result, err := thc.taskService.ExecuteTask(req.TaskID, req.Command, timeout)
+	// 执行任务，本地 Web 接口没有发起用户，只匹配不限用户的策略规则
+	result, err := thc.taskService.ExecuteTask(req.TaskID, req.Command, "", nil, nil, timeout, nil)
 	if err != nil {
+		var violation *service.PolicyViolation
+		if errors.As(err, &violation) {
+			ErrorResponse(c, http.StatusForbidden, err.Error())
+			return
+		}
 		ErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
 	}
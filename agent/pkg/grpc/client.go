@@ -8,34 +8,181 @@ import (
 	"time"
 
 	"devops-manager/api/protobuf"
+	"devops-manager/api/sendqueue"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// maxStreamRetryInterval 命令流重连退避的最大间隔
+const maxStreamRetryInterval = time.Minute
+
+// errDeliveredBefore 重复投递的命令此前已执行且结果不可用，为避免重复执行以该错误上报
+const errDeliveredBefore = "command was already delivered to this agent and its result is no longer available"
+
+// OutputSink 接收命令执行过程中产生的输出分片
+type OutputSink func(chunk *protobuf.CommandOutputChunk)
+
+// CommandHandler 处理 Server 下发的命令并返回执行结果，执行过程中的输出通过 sink 增量发送
+type CommandHandler func(content *protobuf.CommandContent, sink OutputSink) *protobuf.CommandResult
+
+// ControlHandler 处理 Server 下发的命令控制请求并返回确认
+type ControlHandler func(req *protobuf.ControlRequest) *protobuf.ControlAck
+
+// FileHandler 接收 Server 分发的文件并返回执行结果
+type FileHandler func(content *protobuf.CommandContent, stream *FileStream) *protobuf.CommandResult
+
+// FileStream 文件分发命令的分片通道
+// Server 推送的文件分片按顺序经由 Chunks 传递，命令流断开时 Chunks 被关闭；
+// 带分片清单的文件通过 Request 逐个请求分片，Server 的回复同样经由 Chunks 传递，
+// 命令流断开时 Chunks 保持打开并向 Interrupted 发送信号，重连后可以继续请求未完成的分片
+type FileStream struct {
+	Chunks      <-chan *protobuf.FileChunk
+	Interrupted <-chan struct{}
+	Request     func(*protobuf.FileChunkRequest) error
+}
+
+// UpgradeHandler 接收并校验新版本 Agent 二进制，返回执行结果；校验通过时同时返回切换到新版本的 restart，
+// restart 在执行结果被 Server 确认（或等待超时）后调用
+type UpgradeHandler func(content *protobuf.CommandContent, stream *FileStream) (*protobuf.CommandResult, func())
+
+// upgradeAckTimeout 切换到新版本前等待 Server 确认升级命令结果的最长时间，未确认的结果由新版本重发
+const upgradeAckTimeout = 10 * time.Second
+
+// FetchHandler 收集匹配的文件并通过 send 回传给 Server，返回执行结果；send 返回错误时应停止回传
+type FetchHandler func(content *protobuf.CommandContent, send func(*protobuf.FetchChunk) error) *protobuf.CommandResult
+
+// PeerGrantHandler 处理 Server 下发的对等分发授权
+type PeerGrantHandler func(grant *protobuf.PeerGrant)
+
+// RevocationHandler 处理 Server 下发的已吊销证书列表
+type RevocationHandler func(list *protobuf.RevocationList)
+
+// fileChunkBuffer 每个文件传输缓冲的分片数
+const fileChunkBuffer = 16
+
+// fileTransfer 正在接收的文件
+type fileTransfer struct {
+	chunks      chan *protobuf.FileChunk
+	done        chan struct{} // 接收结束时关闭，之后到达的分片被丢弃
+	resumable   bool          // 按分片请求，命令流断开后不中止
+	interrupted chan struct{}
+}
+
 type Agent struct {
 	serverAddr    string
 	timeout       time.Duration
 	retryInterval time.Duration
 	conn          *grpc.ClientConn
 	client        protobuf.HostServiceClient
+	commandClient protobuf.CommandServiceClient
+	tlsFiles      *TLSFiles
 	mutex         sync.RWMutex
 	connected     bool
 	ctx           context.Context
 	cancel        context.CancelFunc
+
+	// 命令流（Agent 主动连接 Server 建立的双向流），所有消息经由发送队列串行写入
+	sender        *sendqueue.Queue
+	streamMutex   sync.RWMutex
+	streamActive  bool
+	sendQueueSize int
+	sendTimeout   time.Duration
+
+	// 结果发件箱，为 nil 时结果只发送一次
+	outbox *ResultOutbox
+
+	// 命令投递记录，为 nil 时只在命令执行期间去重
+	deliveries *DeliveryLog
+
+	// 已接收、结果尚未发送的命令，重连时上报给 Server 对账
+	inflight      map[string]struct{}
+	inflightMutex sync.Mutex
+
+	// 文件分发处理器及正在接收的文件，按命令ID索引
+	fileHandler    FileHandler
+	transfers      map[string]*fileTransfer
+	transfersMutex sync.Mutex
+
+	// 文件收集处理器
+	fetchHandler FetchHandler
+
+	// 自升级处理器
+	upgradeHandler UpgradeHandler
+
+	// 对等分发授权处理器
+	peerGrantHandler PeerGrantHandler
+
+	// 已吊销证书列表处理器
+	revocationHandler RevocationHandler
+
+	// 命令流建立后的回调
+	connectedHandler func()
 }
 
-func NewAgent(serverAddr string, timeout, retryInterval time.Duration) *Agent {
+// NewAgent 创建 gRPC 客户端，tlsFiles 为 nil 时使用明文连接
+func NewAgent(serverAddr string, timeout, retryInterval time.Duration, tlsFiles *TLSFiles) *Agent {
 	return &Agent{
 		serverAddr:    serverAddr,
 		timeout:       timeout,
 		retryInterval: retryInterval,
+		tlsFiles:      tlsFiles,
 		connected:     false,
+		sendQueueSize: sendqueue.DefaultSize,
+		sendTimeout:   sendqueue.DefaultSendTimeout,
+		inflight:      make(map[string]struct{}),
+		transfers:     make(map[string]*fileTransfer),
 	}
 }
 
+// SetSendQueue 设置命令流发送队列的缓冲消息数和超时，应在 RunCommandStream 之前调用
+func (c *Agent) SetSendQueue(size int, timeout time.Duration) {
+	c.sendQueueSize = size
+	c.sendTimeout = timeout
+}
+
+// SetOutbox 设置结果发件箱，应在 RunCommandStream 之前调用
+func (c *Agent) SetOutbox(outbox *ResultOutbox) {
+	c.outbox = outbox
+}
+
+// SetDeliveryLog 设置命令投递记录，应在 RunCommandStream 之前调用
+func (c *Agent) SetDeliveryLog(deliveries *DeliveryLog) {
+	c.deliveries = deliveries
+}
+
+// SetFileHandler 设置文件分发处理器，为 nil 时文件分发命令以失败上报，应在 RunCommandStream 之前调用
+func (c *Agent) SetFileHandler(handler FileHandler) {
+	c.fileHandler = handler
+}
+
+// SetFetchHandler 设置文件收集处理器，为 nil 时文件收集命令以失败上报，应在 RunCommandStream 之前调用
+func (c *Agent) SetFetchHandler(handler FetchHandler) {
+	c.fetchHandler = handler
+}
+
+// SetUpgradeHandler 设置自升级处理器，为 nil 时升级命令以失败上报，应在 RunCommandStream 之前调用
+func (c *Agent) SetUpgradeHandler(handler UpgradeHandler) {
+	c.upgradeHandler = handler
+}
+
+// SetPeerGrantHandler 设置对等分发授权处理器，为 nil 时忽略授权，应在 RunCommandStream 之前调用
+func (c *Agent) SetPeerGrantHandler(handler PeerGrantHandler) {
+	c.peerGrantHandler = handler
+}
+
+// SetRevocationHandler 设置已吊销证书列表处理器，为 nil 时忽略列表，应在 RunCommandStream 之前调用
+func (c *Agent) SetRevocationHandler(handler RevocationHandler) {
+	c.revocationHandler = handler
+}
+
+// SetConnectedHandler 设置命令流握手成功后的回调，每次建立命令流时调用，应在 RunCommandStream 之前调用
+func (c *Agent) SetConnectedHandler(handler func()) {
+	c.connectedHandler = handler
+}
+
 func (c *Agent) Start(ctx context.Context) error {
 	c.ctx, c.cancel = context.WithCancel(ctx)
 
@@ -148,9 +295,14 @@ func (c *Agent) connect() error {
 	if c.conn != nil {
 		c.conn.Close()
 	}
+	creds, err := c.transportCredentials()
+	if err != nil {
+		return err
+	}
+
 	// 创建新连接
 	conn, err := grpc.Dial(c.serverAddr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithTransportCredentials(creds),
 	)
 	if err != nil {
 		return err
@@ -158,6 +310,7 @@ func (c *Agent) connect() error {
 
 	c.conn = conn
 	c.client = protobuf.NewHostServiceClient(conn)
+	c.commandClient = protobuf.NewCommandServiceClient(conn)
 	c.connected = true
 
 	return nil
@@ -168,3 +321,609 @@ func (c *Agent) markDisconnected() {
 	c.connected = false
 	c.mutex.Unlock()
 }
+
+// Reconnect 断开当前连接，由连接管理器重新读取证书后重连
+func (c *Agent) Reconnect() {
+	c.markDisconnected()
+}
+
+// IsStreamActive 检查命令流是否已建立
+func (c *Agent) IsStreamActive() bool {
+	c.streamMutex.RLock()
+	defer c.streamMutex.RUnlock()
+	return c.streamActive
+}
+
+// RunCommandStream 主动连接 Server 的 CommandService 并保持双向流
+// 流断开后按指数退避重连，直到 Agent 停止。该方法会阻塞，应在 goroutine 中调用
+func (c *Agent) RunCommandStream(hello *protobuf.AgentHello, heartbeatInterval time.Duration, handler CommandHandler, control ControlHandler) {
+	backoff := c.retryInterval
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		default:
+		}
+
+		established, err := c.serveCommandStream(hello, heartbeatInterval, handler, control)
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		// 流成功建立过则重置退避时间
+		if established {
+			backoff = c.retryInterval
+		}
+		log.Printf("Command stream closed: %v, reconnecting in %v", err, backoff)
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxStreamRetryInterval {
+			backoff = maxStreamRetryInterval
+		}
+	}
+}
+
+// SendCommandMessage 将消息放入命令流的发送队列
+// gRPC 流不支持并发 Send，消息由发送队列的写协程依次写入；返回 nil 表示消息已入队
+func (c *Agent) SendCommandMessage(msg *protobuf.CommandMessage) error {
+	c.streamMutex.RLock()
+	sender := c.sender
+	active := c.streamActive
+	c.streamMutex.RUnlock()
+
+	if sender == nil || !active {
+		return fmt.Errorf("command stream not established")
+	}
+
+	return sender.Send(msg)
+}
+
+// SendStats 返回当前命令流发送队列的统计，命令流未建立时返回 false
+func (c *Agent) SendStats() (sendqueue.Stats, bool) {
+	c.streamMutex.RLock()
+	sender := c.sender
+	c.streamMutex.RUnlock()
+
+	if sender == nil {
+		return sendqueue.Stats{}, false
+	}
+	return sender.Stats(), true
+}
+
+// serveCommandStream 建立一次命令流并处理消息，直到流断开
+// 返回值表示流是否成功建立（握手已被 Server 确认）
+func (c *Agent) serveCommandStream(hello *protobuf.AgentHello, heartbeatInterval time.Duration, handler CommandHandler, control ControlHandler) (bool, error) {
+	c.mutex.RLock()
+	commandClient := c.commandClient
+	c.mutex.RUnlock()
+
+	if commandClient == nil {
+		return false, fmt.Errorf("client not connected")
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+
+	stream, err := commandClient.ConnectForCommands(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to open command stream: %w", err)
+	}
+
+	// 握手超时未确认则取消本次流
+	handshakeTimer := time.AfterFunc(c.timeout, cancel)
+	err = c.handshake(stream, hello)
+	if !handshakeTimer.Stop() {
+		return false, fmt.Errorf("handshake timed out after %v", c.timeout)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// 写入失败或阻塞超时时取消本次流，Recv 随之返回并触发重连
+	sender := sendqueue.New(stream.Send, func(err error) {
+		log.Printf("Command stream send failed: %v", err)
+		cancel()
+	}, c.sendQueueSize, c.sendTimeout)
+	defer sender.Close()
+
+	c.streamMutex.Lock()
+	c.sender = sender
+	c.streamActive = true
+	c.streamMutex.Unlock()
+
+	defer func() {
+		c.streamMutex.Lock()
+		c.sender = nil
+		c.streamActive = false
+		c.streamMutex.Unlock()
+	}()
+
+	// 流断开后 Server 不会继续发送分片，Server 推送的文件随之失败，按分片请求的文件等待重连后继续
+	defer c.abortFileTransfers()
+
+	log.Printf("Command stream established with server %s", c.serverAddr)
+	if c.connectedHandler != nil {
+		c.connectedHandler()
+	}
+
+	go c.heartbeatLoop(ctx, hello.HostId, heartbeatInterval)
+
+	// 先上报对账信息，再重发未确认的结果
+	if err := c.sendReconcileReport(hello.HostId); err != nil {
+		return true, fmt.Errorf("failed to send reconcile report: %w", err)
+	}
+	go c.replayOutbox(hello.HostId)
+
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			if sendErr := sender.Err(); sendErr != nil {
+				return true, sendErr
+			}
+			return true, err
+		}
+
+		switch payload := msg.Payload.(type) {
+		case *protobuf.CommandMessage_CommandContent:
+			c.dispatchCommand(hello.HostId, payload.CommandContent, handler)
+		case *protobuf.CommandMessage_Control:
+			c.dispatchControl(hello.HostId, payload.Control, control)
+		case *protobuf.CommandMessage_FileChunk:
+			c.routeFileChunk(payload.FileChunk)
+		case *protobuf.CommandMessage_PeerGrant:
+			if c.peerGrantHandler != nil {
+				c.peerGrantHandler(payload.PeerGrant)
+			}
+		case *protobuf.CommandMessage_Revocations:
+			if c.revocationHandler != nil {
+				c.revocationHandler(payload.Revocations)
+			}
+		case *protobuf.CommandMessage_Heartbeat:
+			// Server 心跳，流可用即可，无需回应
+		case *protobuf.CommandMessage_Ack:
+			// 结果未被确认时保留在发件箱，下次重连后重发
+			c.outbox.Ack(payload.Ack.RefId, payload.Ack.Success)
+			if !payload.Ack.Success {
+				log.Printf("Server rejected message %s: %s", payload.Ack.RefId, payload.Ack.Message)
+			}
+		default:
+			log.Printf("Ignoring unexpected message from server: %T", msg.Payload)
+		}
+	}
+}
+
+// handshake 发送握手消息并等待 Server 确认
+func (c *Agent) handshake(stream protobuf.CommandService_ConnectForCommandsClient, hello *protobuf.AgentHello) error {
+	if hello.GetHostId() == "" {
+		return fmt.Errorf("host id is required for handshake")
+	}
+
+	// 握手完成前流上只有这一条消息，直接写入
+	if err := stream.Send(&protobuf.CommandMessage{
+		Payload: &protobuf.CommandMessage_Hello{Hello: hello},
+	}); err != nil {
+		return fmt.Errorf("failed to send hello: %w", err)
+	}
+
+	msg, err := stream.Recv()
+	if err != nil {
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+
+	ack := msg.GetAck()
+	if ack == nil {
+		return fmt.Errorf("handshake failed: expected ack, got %T", msg.Payload)
+	}
+	if !ack.Success {
+		return fmt.Errorf("handshake rejected: %s", ack.Message)
+	}
+	if ack.RefId != hello.HostId {
+		return fmt.Errorf("handshake failed: ack for host %s, expected %s", ack.RefId, hello.HostId)
+	}
+	return nil
+}
+
+// dispatchCommand 校验命令目标后异步执行，并回传执行结果
+func (c *Agent) dispatchCommand(hostID string, content *protobuf.CommandContent, handler CommandHandler) {
+	if content.Command == "" {
+		return
+	}
+
+	if content.HostId != "" && content.HostId != hostID {
+		log.Printf("Rejecting command %s addressed to host %s", content.CommandId, content.HostId)
+		c.sendResult(&protobuf.CommandResult{
+			CommandId:    content.CommandId,
+			HostId:       hostID,
+			ExitCode:     -1,
+			ErrorMessage: fmt.Sprintf("command addressed to host %s, not %s", content.HostId, hostID),
+		})
+		return
+	}
+
+	// Server 重连对账时会重新投递未确认收到的命令，已收到的命令只确认不再执行
+	if !c.trackCommand(content.CommandId) {
+		log.Printf("Ignoring duplicate command %s", content.CommandId)
+		c.sendDeliveryAck(content.CommandId, true, true, "command is running")
+		return
+	}
+
+	// 执行前持久化投递记录，Agent 重启后同样不会重复执行
+	execute, err := c.deliveries.Record(content.CommandId, content.Attempt)
+	if err != nil {
+		c.untrackCommand(content.CommandId)
+		log.Printf("Rejecting command %s: %v", content.CommandId, err)
+		c.sendDeliveryAck(content.CommandId, false, false, err.Error())
+		return
+	}
+	if !execute {
+		c.untrackCommand(content.CommandId)
+		log.Printf("Ignoring duplicate delivery of command %s (attempt %d)", content.CommandId, content.Attempt)
+		c.sendDeliveryAck(content.CommandId, true, true, "command already delivered")
+		c.resendResult(hostID, content.CommandId)
+		return
+	}
+	c.sendDeliveryAck(content.CommandId, true, false, "")
+
+	// 文件分片紧随命令之后到达，需在处理下一条消息前登记
+	var transfer *fileTransfer
+	if content.File != nil {
+		transfer = c.beginFileTransfer(content.CommandId, len(content.File.ChunkSha256) > 0)
+	}
+
+	go func() {
+		defer c.untrackCommand(content.CommandId)
+		c.outbox.Begin(content.CommandId, hostID)
+		var result *protobuf.CommandResult
+		var restart func()
+		if content.Upgrade != nil {
+			// 新版本二进制同样作为文件接收，不占用执行队列
+			result, restart = c.receiveUpgrade(hostID, content, transfer)
+		} else if transfer != nil {
+			// 文件接收不占用执行队列
+			result = c.receiveFile(hostID, content, transfer)
+		} else if content.Fetch != nil {
+			// 文件收集由处理器放入执行队列
+			result = c.fetchFiles(hostID, content)
+		} else {
+			result = handler(content, func(chunk *protobuf.CommandOutputChunk) {
+				chunk.HostId = hostID
+				c.sendOutputChunk(chunk)
+			})
+		}
+		if result == nil {
+			return
+		}
+		// 结果中的主机ID必须与流身份一致，否则会被 Server 拒绝
+		result.HostId = hostID
+		// 先保存到发件箱，命令流断开时重连后重发
+		c.outbox.Complete(result)
+		c.sendResult(result)
+
+		if restart != nil {
+			c.awaitResultAck(content.CommandId, upgradeAckTimeout)
+			restart()
+		}
+	}()
+}
+
+// beginFileTransfer 登记正在接收的文件
+func (c *Agent) beginFileTransfer(commandID string, resumable bool) *fileTransfer {
+	transfer := &fileTransfer{
+		chunks:      make(chan *protobuf.FileChunk, fileChunkBuffer),
+		done:        make(chan struct{}),
+		resumable:   resumable,
+		interrupted: make(chan struct{}, 1),
+	}
+	c.transfersMutex.Lock()
+	c.transfers[commandID] = transfer
+	c.transfersMutex.Unlock()
+	return transfer
+}
+
+// endFileTransfer 文件接收结束后取消登记
+func (c *Agent) endFileTransfer(commandID string, transfer *fileTransfer) {
+	close(transfer.done)
+	c.transfersMutex.Lock()
+	if c.transfers[commandID] == transfer {
+		delete(c.transfers, commandID)
+	}
+	c.transfersMutex.Unlock()
+}
+
+// fileStream 创建文件接收的分片通道，分片请求经命令流的发送队列发送
+func (c *Agent) fileStream(hostID string, content *protobuf.CommandContent, transfer *fileTransfer) *FileStream {
+	return &FileStream{
+		Chunks:      transfer.chunks,
+		Interrupted: transfer.interrupted,
+		Request: func(req *protobuf.FileChunkRequest) error {
+			req.CommandId = content.CommandId
+			req.HostId = hostID
+			return c.SendCommandMessage(&protobuf.CommandMessage{
+				Payload: &protobuf.CommandMessage_FileChunkRequest{FileChunkRequest: req},
+			})
+		},
+	}
+}
+
+// receiveFile 调用文件分发处理器接收文件，结束后取消登记
+func (c *Agent) receiveFile(hostID string, content *protobuf.CommandContent, transfer *fileTransfer) *protobuf.CommandResult {
+	defer c.endFileTransfer(content.CommandId, transfer)
+
+	if c.fileHandler == nil {
+		now := timestamppb.Now()
+		return &protobuf.CommandResult{
+			CommandId:    content.CommandId,
+			ExitCode:     -1,
+			ErrorMessage: "file transfer not supported",
+			StartedAt:    now,
+			FinishedAt:   now,
+		}
+	}
+	return c.fileHandler(content, c.fileStream(hostID, content, transfer))
+}
+
+// receiveUpgrade 调用自升级处理器接收新版本二进制，结束后取消登记
+// 升级命令必须携带新版本二进制，否则不会被当作普通命令执行
+func (c *Agent) receiveUpgrade(hostID string, content *protobuf.CommandContent, transfer *fileTransfer) (*protobuf.CommandResult, func()) {
+	if transfer == nil {
+		return failedResult(content.CommandId, "upgrade command carries no agent binary"), nil
+	}
+	defer c.endFileTransfer(content.CommandId, transfer)
+
+	if c.upgradeHandler == nil {
+		return failedResult(content.CommandId, "agent upgrade not supported"), nil
+	}
+	return c.upgradeHandler(content, c.fileStream(hostID, content, transfer))
+}
+
+// failedResult 创建未执行即失败的命令结果
+func failedResult(commandID, message string) *protobuf.CommandResult {
+	now := timestamppb.Now()
+	return &protobuf.CommandResult{
+		CommandId:    commandID,
+		ExitCode:     -1,
+		ErrorMessage: message,
+		StartedAt:    now,
+		FinishedAt:   now,
+	}
+}
+
+// awaitResultAck 等待命令结果被 Server 确认，超时后返回
+// 未启用发件箱时无法得知确认，只等待发送队列写出结果
+func (c *Agent) awaitResultAck(commandID string, timeout time.Duration) {
+	if c.outbox == nil {
+		time.Sleep(time.Second)
+		return
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, pending := c.outbox.Result(commandID); !pending {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Printf("Result of command %s not acknowledged within %v", commandID, timeout)
+}
+
+// fetchFiles 调用文件收集处理器回传文件，分片经命令流的发送队列发送，先于执行结果到达 Server
+func (c *Agent) fetchFiles(hostID string, content *protobuf.CommandContent) *protobuf.CommandResult {
+	if c.fetchHandler == nil {
+		now := timestamppb.Now()
+		return &protobuf.CommandResult{
+			CommandId:    content.CommandId,
+			ExitCode:     -1,
+			ErrorMessage: "file fetch not supported",
+			StartedAt:    now,
+			FinishedAt:   now,
+		}
+	}
+	return c.fetchHandler(content, func(chunk *protobuf.FetchChunk) error {
+		chunk.CommandId = content.CommandId
+		chunk.HostId = hostID
+		return c.SendCommandMessage(&protobuf.CommandMessage{
+			Payload: &protobuf.CommandMessage_FetchChunk{FetchChunk: chunk},
+		})
+	})
+}
+
+// routeFileChunk 将文件分片交给对应的文件接收，缓冲已满时阻塞命令流的接收直到分片被取走
+func (c *Agent) routeFileChunk(chunk *protobuf.FileChunk) {
+	c.transfersMutex.Lock()
+	transfer := c.transfers[chunk.CommandId]
+	c.transfersMutex.Unlock()
+
+	if transfer == nil {
+		log.Printf("Dropping file chunk of unknown transfer %s at offset %d", chunk.CommandId, chunk.Offset)
+		return
+	}
+	select {
+	case transfer.chunks <- chunk:
+	case <-transfer.done:
+	}
+}
+
+// abortFileTransfers 命令流断开时关闭 Server 推送的文件接收的分片通道，只在命令流的接收协程中调用
+// 按分片请求的文件保持登记并收到中断信号，重连后由接收方重新请求未完成的分片
+func (c *Agent) abortFileTransfers() {
+	c.transfersMutex.Lock()
+	defer c.transfersMutex.Unlock()
+	for commandID, transfer := range c.transfers {
+		if transfer.resumable {
+			select {
+			case transfer.interrupted <- struct{}{}:
+			default:
+			}
+			continue
+		}
+		close(transfer.chunks)
+		delete(c.transfers, commandID)
+	}
+}
+
+// trackCommand 登记已接收的命令，命令已登记时返回 false
+func (c *Agent) trackCommand(commandID string) bool {
+	c.inflightMutex.Lock()
+	defer c.inflightMutex.Unlock()
+	if _, exists := c.inflight[commandID]; exists {
+		return false
+	}
+	c.inflight[commandID] = struct{}{}
+	return true
+}
+
+// untrackCommand 命令结果处理完成后取消登记
+func (c *Agent) untrackCommand(commandID string) {
+	c.inflightMutex.Lock()
+	defer c.inflightMutex.Unlock()
+	delete(c.inflight, commandID)
+}
+
+// sendReconcileReport 上报正在处理的命令和待确认结果的命令
+func (c *Agent) sendReconcileReport(hostID string) error {
+	report := &protobuf.ReconcileReport{HostId: hostID}
+
+	c.inflightMutex.Lock()
+	for commandID := range c.inflight {
+		report.RunningCommandIds = append(report.RunningCommandIds, commandID)
+	}
+	c.inflightMutex.Unlock()
+
+	report.FinishedCommandIds = c.outbox.FinishedIDs()
+
+	return c.SendCommandMessage(&protobuf.CommandMessage{
+		Payload: &protobuf.CommandMessage_Reconcile{Reconcile: report},
+	})
+}
+
+// replayOutbox 重发发件箱中未被 Server 确认的结果
+func (c *Agent) replayOutbox(hostID string) {
+	pending := c.outbox.Pending()
+	if len(pending) == 0 {
+		return
+	}
+
+	log.Printf("Replaying %d unacknowledged command results", len(pending))
+	for _, result := range pending {
+		result.HostId = hostID
+		if err := c.SendCommandMessage(&protobuf.CommandMessage{
+			Payload: &protobuf.CommandMessage_CommandResult{CommandResult: result},
+		}); err != nil {
+			log.Printf("Stopped replaying outbox: %v", err)
+			return
+		}
+	}
+}
+
+// dispatchControl 校验控制请求目标后处理，并回复控制确认
+func (c *Agent) dispatchControl(hostID string, req *protobuf.ControlRequest, control ControlHandler) {
+	var ack *protobuf.ControlAck
+	switch {
+	case req.HostId != "" && req.HostId != hostID:
+		log.Printf("Rejecting control %s addressed to host %s", req.ControlId, req.HostId)
+		ack = &protobuf.ControlAck{
+			ControlId: req.ControlId,
+			CommandId: req.CommandId,
+			Action:    req.Action,
+			Message:   fmt.Sprintf("control addressed to host %s, not %s", req.HostId, hostID),
+		}
+	case control == nil:
+		ack = &protobuf.ControlAck{
+			ControlId: req.ControlId,
+			CommandId: req.CommandId,
+			Action:    req.Action,
+			Message:   "control not supported",
+		}
+	default:
+		ack = control(req)
+	}
+
+	ack.HostId = hostID
+	if err := c.SendCommandMessage(&protobuf.CommandMessage{
+		Payload: &protobuf.CommandMessage_ControlAck{ControlAck: ack},
+	}); err != nil {
+		log.Printf("Failed to send ack of control %s: %v", req.ControlId, err)
+	}
+}
+
+// sendDeliveryAck 确认收到命令，duplicate 表示此前已收到过该命令
+func (c *Agent) sendDeliveryAck(commandID string, success, duplicate bool, message string) {
+	if err := c.SendCommandMessage(&protobuf.CommandMessage{
+		Payload: &protobuf.CommandMessage_Ack{Ack: &protobuf.Ack{
+			RefId:     commandID,
+			Success:   success,
+			Message:   message,
+			Duplicate: duplicate,
+		}},
+	}); err != nil {
+		log.Printf("Failed to send delivery ack of command %s: %v", commandID, err)
+	}
+}
+
+// resendResult 重复投递已执行过的命令时重发其结果；结果已被确认删除时上报失败，命令不会再次执行
+func (c *Agent) resendResult(hostID, commandID string) {
+	result, exists := c.outbox.Result(commandID)
+	if !exists {
+		now := timestamppb.Now()
+		result = &protobuf.CommandResult{
+			CommandId:    commandID,
+			ExitCode:     -1,
+			ErrorMessage: errDeliveredBefore,
+			StartedAt:    now,
+			FinishedAt:   now,
+		}
+	}
+	result.HostId = hostID
+	c.sendResult(result)
+}
+
+// sendResult 回传命令执行结果
+func (c *Agent) sendResult(result *protobuf.CommandResult) {
+	if err := c.SendCommandMessage(&protobuf.CommandMessage{
+		Payload: &protobuf.CommandMessage_CommandResult{CommandResult: result},
+	}); err != nil {
+		log.Printf("Failed to send result of command %s: %v", result.CommandId, err)
+	}
+}
+
+// sendOutputChunk 发送命令输出分片，发送失败时丢弃，完整输出仍随执行结果回传
+func (c *Agent) sendOutputChunk(chunk *protobuf.CommandOutputChunk) {
+	if err := c.SendCommandMessage(&protobuf.CommandMessage{
+		Payload: &protobuf.CommandMessage_OutputChunk{OutputChunk: chunk},
+	}); err != nil {
+		log.Printf("Failed to send output chunk %d of command %s: %v", chunk.Sequence, chunk.CommandId, err)
+	}
+}
+
+// heartbeatLoop 定期通过命令流发送心跳，避免 Server 判定连接超时
+func (c *Agent) heartbeatLoop(ctx context.Context, hostID string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.sendHeartbeat(hostID); err != nil {
+				log.Printf("Failed to send heartbeat: %v", err)
+			}
+		}
+	}
+}
+
+// sendHeartbeat 发送心跳消息
+func (c *Agent) sendHeartbeat(hostID string) error {
+	return c.SendCommandMessage(&protobuf.CommandMessage{
+		Payload: &protobuf.CommandMessage_Heartbeat{Heartbeat: &protobuf.Heartbeat{
+			HostId:    hostID,
+			Timestamp: timestamppb.Now(),
+		}},
+	})
+}
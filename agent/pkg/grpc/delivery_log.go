@@ -0,0 +1,185 @@
+package grpc
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deliveryCompactThreshold 文件记录数超过有效记录两倍再加该数量时，清理过期记录并重写文件
+const deliveryCompactThreshold = 1024
+
+// DeliveryLog 命令投递记录
+// 按命令ID记录收到的命令及其执行次数，追加写入文件并在执行前落盘；
+// 保留窗口内再次收到执行次数不更新的同一命令时视为重复投递，不再执行，Agent 重启后仍然有效
+type DeliveryLog struct {
+	path    string
+	window  time.Duration
+	mutex   sync.Mutex
+	file    *os.File
+	entries map[string]deliveryEntry
+	records int // 文件中的记录数
+	limit   int // 文件记录数达到该值时重写文件
+}
+
+// deliveryEntry 一条投递记录
+type deliveryEntry struct {
+	attempt    uint32
+	receivedAt time.Time
+}
+
+// NewDeliveryLog 创建投递记录并加载文件中保留窗口内的记录
+func NewDeliveryLog(path string, window time.Duration) (*DeliveryLog, error) {
+	if path == "" {
+		return nil, fmt.Errorf("delivery log path is required")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create delivery log directory: %w", err)
+	}
+
+	d := &DeliveryLog{
+		path:    path,
+		window:  window,
+		entries: make(map[string]deliveryEntry),
+	}
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	// 启动时清理过期记录
+	if err := d.compact(); err != nil {
+		return nil, err
+	}
+	if len(d.entries) > 0 {
+		log.Printf("Loaded %d delivered commands from %s", len(d.entries), path)
+	}
+	return d, nil
+}
+
+// load 读取文件中的记录，写入中断留下的不完整记录被忽略
+func (d *DeliveryLog) load() error {
+	file, err := os.Open(d.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open delivery log: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		receivedAt, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		attempt, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			continue
+		}
+		commandID, err := hex.DecodeString(fields[2])
+		if err != nil {
+			continue
+		}
+		d.records++
+		d.entries[string(commandID)] = deliveryEntry{
+			attempt:    uint32(attempt),
+			receivedAt: time.Unix(receivedAt, 0),
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read delivery log: %w", err)
+	}
+	return nil
+}
+
+// Record 记录收到的命令，返回该命令是否需要执行
+// 保留窗口内已记录过相同或更新执行次数的命令返回 false；记录写入失败时返回错误，调用方不应执行该命令
+func (d *DeliveryLog) Record(commandID string, attempt uint32) (bool, error) {
+	if d == nil {
+		return true, nil
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	if entry, exists := d.entries[commandID]; exists && !d.expired(entry, now) && entry.attempt >= attempt {
+		return false, nil
+	}
+
+	if _, err := fmt.Fprintf(d.file, "%d %d %s\n", now.Unix(), attempt, hex.EncodeToString([]byte(commandID))); err != nil {
+		return false, fmt.Errorf("failed to write delivery log: %w", err)
+	}
+	if err := d.file.Sync(); err != nil {
+		return false, fmt.Errorf("failed to sync delivery log: %w", err)
+	}
+	d.entries[commandID] = deliveryEntry{attempt: attempt, receivedAt: now}
+	d.records++
+
+	if d.records >= d.limit {
+		if err := d.compact(); err != nil {
+			log.Printf("Failed to compact delivery log: %v", err)
+		}
+	}
+	return true, nil
+}
+
+// expired 检查记录是否已超出保留窗口，窗口不大于 0 时永不过期
+func (d *DeliveryLog) expired(entry deliveryEntry, now time.Time) bool {
+	return d.window > 0 && now.Sub(entry.receivedAt) > d.window
+}
+
+// compact 删除过期记录并原子重写文件，然后重新打开用于追加，调用方需持有锁或在初始化时调用
+func (d *DeliveryLog) compact() error {
+	now := time.Now()
+	for commandID, entry := range d.entries {
+		if d.expired(entry, now) {
+			delete(d.entries, commandID)
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(d.path), filepath.Base(d.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create delivery log: %w", err)
+	}
+	writer := bufio.NewWriter(tmp)
+	for commandID, entry := range d.entries {
+		fmt.Fprintf(writer, "%d %d %s\n", entry.receivedAt.Unix(), entry.attempt, hex.EncodeToString([]byte(commandID)))
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to rewrite delivery log: %w", err)
+	}
+
+	file, err := os.OpenFile(d.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open delivery log: %w", err)
+	}
+	if d.file != nil {
+		d.file.Close()
+	}
+	d.file = file
+	d.records = len(d.entries)
+	d.limit = 2*len(d.entries) + deliveryCompactThreshold
+	return nil
+}
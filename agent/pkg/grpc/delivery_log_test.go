@@ -0,0 +1,171 @@
+package grpc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestDeliveryLog 打开 path 处的投递记录
+func newTestDeliveryLog(t *testing.T, path string, window time.Duration) *DeliveryLog {
+	t.Helper()
+	d, err := NewDeliveryLog(path, window)
+	if err != nil {
+		t.Fatalf("NewDeliveryLog failed: %v", err)
+	}
+	t.Cleanup(func() { d.file.Close() })
+	return d
+}
+
+// delivery 一次命令投递
+type delivery struct {
+	commandID string
+	attempt   uint32
+	want      bool // 是否需要执行
+}
+
+// recordAll 依次记录投递并检查是否需要执行
+func recordAll(t *testing.T, d *DeliveryLog, deliveries []delivery) {
+	t.Helper()
+	for _, dl := range deliveries {
+		execute, err := d.Record(dl.commandID, dl.attempt)
+		if err != nil {
+			t.Fatalf("Record(%q, %d) failed: %v", dl.commandID, dl.attempt, err)
+		}
+		if execute != dl.want {
+			t.Errorf("Record(%q, %d) = %v, want %v", dl.commandID, dl.attempt, execute, dl.want)
+		}
+	}
+}
+
+func TestDeliveryLogDedupe(t *testing.T) {
+	tests := []struct {
+		name       string
+		deliveries []delivery
+	}{
+		{
+			name: "redelivery of the same attempt is skipped",
+			deliveries: []delivery{
+				{"cmd-1", 1, true},
+				{"cmd-1", 1, false},
+			},
+		},
+		{
+			name: "newer attempt is executed",
+			deliveries: []delivery{
+				{"cmd-1", 1, true},
+				{"cmd-1", 2, true},
+				{"cmd-1", 2, false},
+			},
+		},
+		{
+			name: "older attempt after a newer one is skipped",
+			deliveries: []delivery{
+				{"cmd-1", 3, true},
+				{"cmd-1", 2, false},
+			},
+		},
+		{
+			name: "different commands are independent",
+			deliveries: []delivery{
+				{"cmd-1", 1, true},
+				{"cmd-2", 1, true},
+				{"cmd-2", 1, false},
+			},
+		},
+		{
+			name: "command id with spaces",
+			deliveries: []delivery{
+				{"cmd 1", 1, true},
+				{"cmd 1", 1, false},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDeliveryLog(t, filepath.Join(t.TempDir(), "delivery.log"), time.Hour)
+			recordAll(t, d, tt.deliveries)
+		})
+	}
+}
+
+func TestDeliveryLogSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "delivery.log")
+	d := newTestDeliveryLog(t, path, time.Hour)
+	recordAll(t, d, []delivery{
+		{"cmd-1", 1, true},
+		{"cmd-2", 1, true},
+		{"cmd-2", 2, true},
+	})
+	d.file.Close()
+
+	// 模拟写入中断留下的不完整记录
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(file, "%d 1", time.Now().Unix())
+	file.Close()
+
+	restarted := newTestDeliveryLog(t, path, time.Hour)
+	recordAll(t, restarted, []delivery{
+		{"cmd-1", 1, false},
+		{"cmd-2", 1, false},
+		{"cmd-2", 2, false},
+		{"cmd-2", 3, true},
+		{"cmd-3", 1, true},
+	})
+}
+
+func TestDeliveryLogWindow(t *testing.T) {
+	const window = time.Hour
+
+	tests := []struct {
+		name        string
+		age         time.Duration
+		window      time.Duration
+		wantExecute bool
+	}{
+		{name: "within window", age: window / 2, window: window, wantExecute: false},
+		{name: "expired", age: 2 * window, window: window, wantExecute: true},
+		{name: "no window never expires", age: 1000 * window, window: 0, wantExecute: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 直接写入较早时间收到的记录，然后重新加载
+			path := filepath.Join(t.TempDir(), "delivery.log")
+			line := fmt.Sprintf("%d 1 %s\n", time.Now().Add(-tt.age).Unix(), hex.EncodeToString([]byte("cmd-1")))
+			if err := os.WriteFile(path, []byte(line), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			d := newTestDeliveryLog(t, path, tt.window)
+			recordAll(t, d, []delivery{{"cmd-1", 1, tt.wantExecute}})
+		})
+	}
+}
+
+func TestDeliveryLogCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "delivery.log")
+	d := newTestDeliveryLog(t, path, time.Hour)
+
+	// 同一命令反复重试，记录数达到阈值后文件被重写为每个命令一条记录
+	for attempt := uint32(1); attempt <= deliveryCompactThreshold+1; attempt++ {
+		recordAll(t, d, []delivery{{"cmd-1", attempt, true}})
+	}
+	if d.records >= deliveryCompactThreshold {
+		t.Errorf("%d records in file, want the log compacted", d.records)
+	}
+
+	d.file.Close()
+	restarted := newTestDeliveryLog(t, path, time.Hour)
+	recordAll(t, restarted, []delivery{
+		{"cmd-1", deliveryCompactThreshold + 1, false},
+		{"cmd-1", deliveryCompactThreshold + 2, true},
+	})
+}
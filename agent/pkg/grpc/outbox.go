@@ -0,0 +1,327 @@
+package grpc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"devops-manager/api/protobuf"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// outboxFileExt 发件箱中结果文件的扩展名
+const outboxFileExt = ".result"
+
+// errAgentRestarted Agent 重启前仍在执行的命令，重启后以该错误上报
+const errAgentRestarted = "agent restarted while command was running"
+
+// ResultOutbox 命令结果发件箱
+// 命令开始执行时记录执行中状态，执行完成后保存最终结果，直到 Server 确认后删除。
+// 命令流断开期间完成的结果在重连后重放；Agent 重启时仍处于执行中的命令记为失败并上报
+type ResultOutbox struct {
+	dir      string
+	maxBytes int64
+	mutex    sync.Mutex
+	entries  map[string]*outboxEntry
+	size     int64
+}
+
+// outboxEntry 发件箱中的一条结果
+type outboxEntry struct {
+	commandID string
+	path      string
+	size      int64
+	final     bool
+	storedAt  time.Time
+}
+
+// NewResultOutbox 创建发件箱并加载目录中已有的结果，maxBytes 为磁盘占用上限
+func NewResultOutbox(dir string, maxBytes int64) (*ResultOutbox, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("outbox directory is required")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+
+	o := &ResultOutbox{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*outboxEntry),
+	}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// load 加载目录中的结果，上次运行时仍在执行的命令改为失败结果
+func (o *ResultOutbox) load() error {
+	files, err := os.ReadDir(o.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read outbox directory: %w", err)
+	}
+
+	for _, file := range files {
+		path := filepath.Join(o.dir, file.Name())
+		if file.IsDir() {
+			continue
+		}
+		// 清理写入中断留下的临时文件
+		if !strings.HasSuffix(file.Name(), outboxFileExt) {
+			os.Remove(path)
+			continue
+		}
+
+		result, err := readResult(path)
+		if err != nil {
+			log.Printf("Discarding unreadable outbox entry %s: %v", file.Name(), err)
+			os.Remove(path)
+			continue
+		}
+
+		if result.FinishedAt == nil {
+			result.ExitCode = -1
+			result.ErrorMessage = errAgentRestarted
+			result.FinishedAt = timestamppb.Now()
+			if err := o.store(result, true); err != nil {
+				log.Printf("Failed to record interrupted command %s: %v", result.CommandId, err)
+			}
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		o.add(&outboxEntry{
+			commandID: result.CommandId,
+			path:      path,
+			size:      info.Size(),
+			final:     true,
+			storedAt:  info.ModTime(),
+		})
+	}
+
+	if len(o.entries) > 0 {
+		log.Printf("Loaded %d unacknowledged command results from outbox", len(o.entries))
+	}
+	return nil
+}
+
+// Begin 记录命令开始执行，Agent 异常退出后据此上报命令失败
+func (o *ResultOutbox) Begin(commandID, hostID string) {
+	if o == nil {
+		return
+	}
+	result := &protobuf.CommandResult{
+		CommandId: commandID,
+		HostId:    hostID,
+		StartedAt: timestamppb.Now(),
+	}
+	if err := o.store(result, false); err != nil {
+		log.Printf("Failed to record command %s in outbox: %v", commandID, err)
+	}
+}
+
+// Complete 保存命令的最终结果，直到 Server 确认
+func (o *ResultOutbox) Complete(result *protobuf.CommandResult) {
+	if o == nil {
+		return
+	}
+	if err := o.store(result, true); err != nil {
+		log.Printf("Failed to save result of command %s to outbox: %v", result.CommandId, err)
+	}
+}
+
+// Ack 处理 Server 对结果的确认，确认成功后删除最终结果；返回该确认是否属于发件箱中的结果
+func (o *ResultOutbox) Ack(commandID string, success bool) bool {
+	if o == nil {
+		return false
+	}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	entry, exists := o.entries[commandID]
+	if !exists || !entry.final {
+		return false
+	}
+	if success {
+		o.remove(entry)
+	}
+	return true
+}
+
+// Pending 返回所有未被确认的最终结果，按保存时间排序
+func (o *ResultOutbox) Pending() []*protobuf.CommandResult {
+	if o == nil {
+		return nil
+	}
+	o.mutex.Lock()
+	entries := make([]*outboxEntry, 0, len(o.entries))
+	for _, entry := range o.entries {
+		if entry.final {
+			entries = append(entries, entry)
+		}
+	}
+	o.mutex.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].storedAt.Before(entries[j].storedAt)
+	})
+
+	results := make([]*protobuf.CommandResult, 0, len(entries))
+	for _, entry := range entries {
+		result, err := readResult(entry.path)
+		if err != nil {
+			// 已被确认删除或读取失败，跳过
+			continue
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Result 返回命令未被确认的最终结果
+func (o *ResultOutbox) Result(commandID string) (*protobuf.CommandResult, bool) {
+	if o == nil {
+		return nil, false
+	}
+	o.mutex.Lock()
+	entry, exists := o.entries[commandID]
+	o.mutex.Unlock()
+	if !exists || !entry.final {
+		return nil, false
+	}
+
+	result, err := readResult(entry.path)
+	if err != nil {
+		return nil, false
+	}
+	return result, true
+}
+
+// FinishedIDs 返回所有未被确认的最终结果对应的命令ID
+func (o *ResultOutbox) FinishedIDs() []string {
+	if o == nil {
+		return nil
+	}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	var ids []string
+	for _, entry := range o.entries {
+		if entry.final {
+			ids = append(ids, entry.commandID)
+		}
+	}
+	return ids
+}
+
+// store 原子写入结果文件，超出磁盘上限时淘汰最早的最终结果
+func (o *ResultOutbox) store(result *protobuf.CommandResult, final bool) error {
+	data, err := proto.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(o.dir, "result-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	path := filepath.Join(o.dir, hex.EncodeToString([]byte(result.CommandId))+outboxFileExt)
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if old, exists := o.entries[result.CommandId]; exists {
+		o.size -= old.size
+		delete(o.entries, result.CommandId)
+	}
+	o.add(&outboxEntry{
+		commandID: result.CommandId,
+		path:      path,
+		size:      int64(len(data)),
+		final:     final,
+		storedAt:  time.Now(),
+	})
+	o.evict(result.CommandId)
+	return nil
+}
+
+// add 登记结果，调用方需持有锁
+func (o *ResultOutbox) add(entry *outboxEntry) {
+	o.entries[entry.commandID] = entry
+	o.size += entry.size
+}
+
+// remove 删除结果文件，调用方需持有锁
+func (o *ResultOutbox) remove(entry *outboxEntry) {
+	if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove outbox entry of command %s: %v", entry.commandID, err)
+	}
+	o.size -= entry.size
+	delete(o.entries, entry.commandID)
+}
+
+// evict 超出磁盘上限时按保存时间淘汰最终结果，不淘汰执行中的记录和刚写入的结果，调用方需持有锁
+func (o *ResultOutbox) evict(keep string) {
+	if o.maxBytes <= 0 || o.size <= o.maxBytes {
+		return
+	}
+
+	var candidates []*outboxEntry
+	for _, entry := range o.entries {
+		if entry.final && entry.commandID != keep {
+			candidates = append(candidates, entry)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].storedAt.Before(candidates[j].storedAt)
+	})
+
+	for _, entry := range candidates {
+		if o.size <= o.maxBytes {
+			return
+		}
+		log.Printf("Outbox exceeds %d bytes, dropping unacknowledged result of command %s", o.maxBytes, entry.commandID)
+		o.remove(entry)
+	}
+}
+
+// readResult 读取结果文件
+func readResult(path string) (*protobuf.CommandResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	result := &protobuf.CommandResult{}
+	if err := proto.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,206 @@
+package grpc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"devops-manager/api/protobuf"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// newTestOutbox 在 dir 中创建发件箱
+func newTestOutbox(t *testing.T, dir string, maxBytes int64) *ResultOutbox {
+	t.Helper()
+	outbox, err := NewResultOutbox(dir, maxBytes)
+	if err != nil {
+		t.Fatalf("NewResultOutbox failed: %v", err)
+	}
+	return outbox
+}
+
+// finishedResult 已完成命令的结果
+func finishedResult(commandID string) *protobuf.CommandResult {
+	return &protobuf.CommandResult{
+		CommandId:  commandID,
+		HostId:     "host-1",
+		Stdout:     "output of " + commandID,
+		StartedAt:  timestamppb.Now(),
+		FinishedAt: timestamppb.Now(),
+	}
+}
+
+// pendingIDs 返回发件箱中待重放结果的命令ID，按重放顺序
+func pendingIDs(outbox *ResultOutbox) []string {
+	var ids []string
+	for _, result := range outbox.Pending() {
+		ids = append(ids, result.CommandId)
+	}
+	return ids
+}
+
+// resultFiles 返回目录中的结果文件数
+func resultFiles(t *testing.T, dir string) int {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+outboxFileExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestOutboxReplayAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	outbox := newTestOutbox(t, dir, 0)
+	for _, id := range []string{"cmd-1", "cmd-2", "cmd-3"} {
+		outbox.Begin(id, "host-1")
+		outbox.Complete(finishedResult(id))
+		// 重启后按文件修改时间排序，避免同一时间戳
+		time.Sleep(10 * time.Millisecond)
+	}
+	// 执行中的命令不参与重放
+	outbox.Begin("cmd-running", "host-1")
+
+	if got, want := pendingIDs(outbox), []string{"cmd-1", "cmd-2", "cmd-3"}; !equalIDs(got, want) {
+		t.Fatalf("Pending() = %v, want %v", got, want)
+	}
+
+	// 模拟重启：临时文件被清理，已完成的结果按保存顺序重放，执行中的命令记为失败
+	if err := os.WriteFile(filepath.Join(dir, "result-interrupted.tmp"), []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	restarted := newTestOutbox(t, dir, 0)
+
+	pending := restarted.Pending()
+	var ids []string
+	for _, result := range pending {
+		ids = append(ids, result.CommandId)
+	}
+	if want := []string{"cmd-1", "cmd-2", "cmd-3", "cmd-running"}; !equalIDs(ids, want) {
+		t.Fatalf("Pending() after restart = %v, want %v", ids, want)
+	}
+	if got := pending[0].Stdout; got != "output of cmd-1" {
+		t.Errorf("replayed stdout = %q, want the stored result", got)
+	}
+	interrupted := pending[3]
+	if interrupted.ExitCode != -1 || interrupted.ErrorMessage != errAgentRestarted || interrupted.FinishedAt == nil {
+		t.Errorf("interrupted command result = %+v, want a failure with %q", interrupted, errAgentRestarted)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "result-interrupted.tmp")); !os.IsNotExist(err) {
+		t.Errorf("temporary file was not cleaned up on load: %v", err)
+	}
+}
+
+func TestOutboxAck(t *testing.T) {
+	tests := []struct {
+		name        string
+		complete    bool // 命令已完成，否则只记录了执行中状态
+		ackID       string
+		success     bool
+		wantOwned   bool
+		wantPending []string
+	}{
+		{name: "successful ack deletes result", complete: true, ackID: "cmd-1", success: true, wantOwned: true, wantPending: nil},
+		{name: "rejected ack keeps result", complete: true, ackID: "cmd-1", success: false, wantOwned: true, wantPending: []string{"cmd-1"}},
+		{name: "ack of running command is ignored", complete: false, ackID: "cmd-1", success: true, wantOwned: false, wantPending: nil},
+		{name: "ack of unknown command is ignored", complete: true, ackID: "cmd-other", success: true, wantOwned: false, wantPending: []string{"cmd-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			outbox := newTestOutbox(t, dir, 0)
+			outbox.Begin("cmd-1", "host-1")
+			if tt.complete {
+				outbox.Complete(finishedResult("cmd-1"))
+			}
+
+			if owned := outbox.Ack(tt.ackID, tt.success); owned != tt.wantOwned {
+				t.Errorf("Ack(%q, %v) = %v, want %v", tt.ackID, tt.success, owned, tt.wantOwned)
+			}
+			if got := pendingIDs(outbox); !equalIDs(got, tt.wantPending) {
+				t.Errorf("Pending() = %v, want %v", got, tt.wantPending)
+			}
+			wantResult := len(tt.wantPending) > 0 && tt.wantPending[0] == "cmd-1"
+			if _, found := outbox.Result("cmd-1"); found != wantResult {
+				t.Errorf("Result(cmd-1) found = %v, want %v", found, wantResult)
+			}
+
+			// 确认删除的结果在重启后不再重放
+			restarted := newTestOutbox(t, dir, 0)
+			wantAfterRestart := tt.wantPending
+			if !tt.complete {
+				wantAfterRestart = []string{"cmd-1"}
+			}
+			if got := pendingIDs(restarted); !equalIDs(got, wantAfterRestart) {
+				t.Errorf("Pending() after restart = %v, want %v", got, wantAfterRestart)
+			}
+		})
+	}
+}
+
+func TestOutboxAckDeletesFile(t *testing.T) {
+	dir := t.TempDir()
+	outbox := newTestOutbox(t, dir, 0)
+	outbox.Complete(finishedResult("cmd-1"))
+	outbox.Complete(finishedResult("cmd-2"))
+	if n := resultFiles(t, dir); n != 2 {
+		t.Fatalf("%d result files before ack, want 2", n)
+	}
+
+	outbox.Ack("cmd-1", true)
+	if n := resultFiles(t, dir); n != 1 {
+		t.Errorf("%d result files after ack, want 1", n)
+	}
+	if got := outbox.FinishedIDs(); !equalIDs(got, []string{"cmd-2"}) {
+		t.Errorf("FinishedIDs() = %v, want [cmd-2]", got)
+	}
+}
+
+func TestOutboxEvictsOldestResults(t *testing.T) {
+	dir := t.TempDir()
+	size := int64(len(storedResult(t, finishedResult("cmd-1"))))
+	// 只容纳两条结果
+	outbox := newTestOutbox(t, dir, size*2+size/2)
+	for _, id := range []string{"cmd-1", "cmd-2", "cmd-3"} {
+		outbox.Complete(finishedResult(id))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got, want := pendingIDs(outbox), []string{"cmd-2", "cmd-3"}; !equalIDs(got, want) {
+		t.Errorf("Pending() = %v, want %v", got, want)
+	}
+	if n := resultFiles(t, dir); n != 2 {
+		t.Errorf("%d result files, want 2", n)
+	}
+}
+
+// storedResult 保存一条结果并返回其文件内容，用于估算单条结果的大小
+func storedResult(t *testing.T, result *protobuf.CommandResult) []byte {
+	t.Helper()
+	dir := t.TempDir()
+	newTestOutbox(t, dir, 0).Complete(result)
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+outboxFileExt))
+	if len(files) != 1 {
+		t.Fatalf("expected one result file, got %d", len(files))
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
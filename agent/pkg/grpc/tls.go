@@ -0,0 +1,190 @@
+package grpc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// TLSFiles 双向 TLS 所需的证书文件
+type TLSFiles struct {
+	CertFile   string // Agent 证书
+	KeyFile    string // Agent 私钥
+	CAFile     string // 用于校验 Server 证书的 CA
+	ServerName string // Server 证书名称，为空时使用连接地址中的主机名
+	Enroll     bool   // 通过 Server 内置 CA 入网，证书尚未签发时不带客户端证书连接
+}
+
+// transportCredentials 构建连接凭证，每次连接时重新读取证书以便更换证书后生效
+func (c *Agent) transportCredentials() (credentials.TransportCredentials, error) {
+	if c.tlsFiles == nil {
+		return insecure.NewCredentials(), nil
+	}
+
+	// 入网前证书尚不存在，不带客户端证书连接，仅能调用 Register 提交 CSR
+	var certificates []tls.Certificate
+	if _, err := os.Stat(c.tlsFiles.CertFile); !c.tlsFiles.Enroll || err == nil {
+		cert, err := tls.LoadX509KeyPair(c.tlsFiles.CertFile, c.tlsFiles.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load agent certificate: %w", err)
+		}
+		certificates = append(certificates, cert)
+	}
+
+	caPEM, err := os.ReadFile(c.tlsFiles.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA: %w", err)
+	}
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", c.tlsFiles.CAFile)
+	}
+
+	return credentials.NewTLS(&tls.Config{
+		Certificates: certificates,
+		RootCAs:      rootCAs,
+		ServerName:   c.tlsFiles.ServerName,
+		MinVersion:   tls.VersionTLS12,
+	}), nil
+}
+
+// CertificateCommonName 读取证书文件的 CN
+func CertificateCommonName(certFile string) (string, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return "", err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("no certificate found in %s", certFile)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	return cert.Subject.CommonName, nil
+}
+
+// CertificateExpiresWithin 检查证书文件是否不存在或将在指定时间内过期
+func CertificateExpiresWithin(certFile string, d time.Duration) bool {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return true
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return true
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true
+	}
+	return time.Until(cert.NotAfter) < d
+}
+
+// CreateCSR 使用 Agent 私钥生成 CN 为主机ID的证书签名请求，私钥不存在时自动生成
+func CreateCSR(keyFile, hostID string) (string, error) {
+	key, err := loadOrCreateKey(keyFile)
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: hostID},
+		DNSNames: []string{hostID},
+	}, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to create CSR: %w", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})), nil
+}
+
+// SaveCertificate 保存 Server 签发的证书，先写临时文件再替换，避免读到半个文件
+func SaveCertificate(certFile, certPEM string) error {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return fmt.Errorf("invalid certificate returned by server")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+	tmpFile := certFile + ".tmp"
+	if err := os.WriteFile(tmpFile, []byte(certPEM), 0644); err != nil {
+		return fmt.Errorf("failed to write certificate: %w", err)
+	}
+	return os.Rename(tmpFile, certFile)
+}
+
+// loadOrCreateKey 读取 Agent 私钥，文件不存在时生成 ECDSA P-256 私钥
+func loadOrCreateKey(keyFile string) (crypto.Signer, error) {
+	data, err := os.ReadFile(keyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return createKey(keyFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no private key found in %s", keyFile)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid agent key: %w", err)
+	}
+
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", parsed)
+	}
+	return key, nil
+}
+
+// createKey 生成私钥并写入文件
+func createKey(keyFile string) (crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate agent key: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write agent key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,418 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"devops-manager/agent/pkg/config"
+	"devops-manager/agent/pkg/grpc"
+	"devops-manager/agent/pkg/utils"
+	"devops-manager/api/protobuf"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// upgradeSmokeTestTimeout 运行新版本 -version 自检的超时
+const upgradeSmokeTestTimeout = 10 * time.Second
+
+// maxUpgradeStarts 新版本在确认前最多启动的次数，超过时视为反复崩溃并回滚
+const maxUpgradeStarts = 3
+
+// upgradeState 升级状态，切换二进制前写入，新版本连上 Server 后删除
+type upgradeState struct {
+	Version    string    `json:"version"`               // 目标版本
+	Previous   string    `json:"previous"`              // 升级前的版本
+	Backup     string    `json:"backup"`                // 旧版本二进制的备份路径
+	Deadline   time.Time `json:"deadline"`              // 新版本连上 Server 的期限
+	Starts     int       `json:"starts"`                // 新版本已启动的次数
+	RolledBack string    `json:"rolled_back,omitempty"` // 回滚原因，旧版本启动后记录日志并删除状态
+}
+
+// AgentUpgrader Agent 自升级：接收并校验新版本二进制，备份当前二进制后切换并以新版本替换当前进程；
+// 新版本未能在期限内连上 Server，或在确认前反复启动时，恢复备份并以旧版本替换当前进程
+type AgentUpgrader struct {
+	version    string
+	executable string
+	publicKey  ed25519.PublicKey
+	stateFile  string
+
+	mutex    sync.Mutex
+	state    *upgradeState
+	watchdog *time.Timer
+}
+
+var (
+	agentUpgrader      *AgentUpgrader
+	agentUpgraderMutex sync.RWMutex
+)
+
+// NewAgentUpgrader 根据自升级配置创建升级器，version 为当前运行的版本
+func NewAgentUpgrader(cfg config.UpgradeConfig, version string) (*AgentUpgrader, error) {
+	if !utils.ExecSupported {
+		return nil, fmt.Errorf("agent upgrade is not supported on this platform")
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate agent executable: %w", err)
+	}
+	if executable, err = filepath.EvalSymlinks(executable); err != nil {
+		return nil, fmt.Errorf("failed to resolve agent executable: %w", err)
+	}
+
+	upgrader := &AgentUpgrader{
+		version:    version,
+		executable: executable,
+		stateFile:  cfg.StateFile,
+	}
+	if cfg.PublicKeyFile != "" {
+		if upgrader.publicKey, err = loadUpgradePublicKey(cfg.PublicKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	return upgrader, nil
+}
+
+// loadUpgradePublicKey 读取 PEM 格式的 Ed25519 公钥
+func loadUpgradePublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upgrade public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid upgrade public key %s: no PEM block found", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upgrade public key %s: %w", path, err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid upgrade public key %s: expected Ed25519, got %T", path, key)
+	}
+	return publicKey, nil
+}
+
+// SetAgentUpgrader 设置全局升级器
+func SetAgentUpgrader(upgrader *AgentUpgrader) {
+	agentUpgraderMutex.Lock()
+	defer agentUpgraderMutex.Unlock()
+	agentUpgrader = upgrader
+}
+
+// GetAgentUpgrader 获取全局升级器，未启用自升级时返回 nil
+func GetAgentUpgrader() *AgentUpgrader {
+	agentUpgraderMutex.RLock()
+	defer agentUpgraderMutex.RUnlock()
+	return agentUpgrader
+}
+
+// HandleUpgrade 接收并校验新版本二进制，实现 grpc.UpgradeHandler
+func HandleUpgrade(content *protobuf.CommandContent, stream *grpc.FileStream) (*protobuf.CommandResult, func()) {
+	upgrader := GetAgentUpgrader()
+	if upgrader == nil {
+		now := timestamppb.Now()
+		return &protobuf.CommandResult{
+			CommandId:    content.CommandId,
+			HostId:       content.HostId,
+			ExitCode:     -1,
+			StartedAt:    now,
+			FinishedAt:   now,
+			ErrorMessage: "agent upgrade is disabled",
+		}, nil
+	}
+	return upgrader.Stage(content, stream)
+}
+
+// Stage 接收新版本二进制到当前二进制旁，校验摘要、签名并运行 -version 自检
+// 校验通过时返回切换到新版本的 restart
+func (u *AgentUpgrader) Stage(content *protobuf.CommandContent, stream *grpc.FileStream) (*protobuf.CommandResult, func()) {
+	spec := content.Upgrade
+	log.Printf("Receiving agent %s for command %s: %d bytes", spec.Version, content.CommandId, content.File.Size)
+
+	startedAt := timestamppb.Now()
+	staged := u.executable + ".new"
+	err := u.stage(content, stream, staged)
+	finishedAt := timestamppb.Now()
+
+	if err != nil {
+		os.Remove(staged)
+		log.Printf("Agent upgrade %s failed: %v", content.CommandId, err)
+		return &protobuf.CommandResult{
+			CommandId:    content.CommandId,
+			HostId:       content.HostId,
+			Stderr:       err.Error(),
+			ExitCode:     -1,
+			StartedAt:    startedAt,
+			FinishedAt:   finishedAt,
+			ErrorMessage: err.Error(),
+		}, nil
+	}
+
+	log.Printf("Agent %s staged at %s, restarting after the result is acknowledged", spec.Version, staged)
+	result := &protobuf.CommandResult{
+		CommandId:  content.CommandId,
+		HostId:     content.HostId,
+		Stdout:     fmt.Sprintf("staged agent %s (sha256 %s), restarting from %s\n", spec.Version, content.File.Sha256, u.version),
+		ExitCode:   0,
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+	}
+	return result, func() { u.restart(spec, staged) }
+}
+
+// stage 接收并校验新版本二进制
+func (u *AgentUpgrader) stage(content *protobuf.CommandContent, stream *grpc.FileStream, staged string) error {
+	spec := content.Upgrade
+	if spec.Version == u.version {
+		return fmt.Errorf("agent is already running version %s", spec.Version)
+	}
+
+	// 新版本二进制写在当前二进制旁，不受文件分发的允许目录限制
+	if _, err := GetFileReceiver().receiveTo(content, stream, staged); err != nil {
+		return err
+	}
+	if err := os.Chmod(staged, 0755); err != nil {
+		return fmt.Errorf("failed to make agent binary executable: %w", err)
+	}
+	if err := u.verifySignature(staged, spec.Signature); err != nil {
+		return err
+	}
+	return smokeTestAgent(staged, spec.Version)
+}
+
+// verifySignature 配置了公钥时校验二进制内容的 Ed25519 签名
+func (u *AgentUpgrader) verifySignature(path, signature string) error {
+	if u.publicKey == nil {
+		return nil
+	}
+	if signature == "" {
+		return fmt.Errorf("upgrade is not signed")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid upgrade signature: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read agent binary: %w", err)
+	}
+	if !ed25519.Verify(u.publicKey, data, sig) {
+		return fmt.Errorf("upgrade signature verification failed")
+	}
+	return nil
+}
+
+// smokeTestAgent 运行新版本的 -version，确认二进制可以在本机启动且版本与升级目标一致
+func smokeTestAgent(path, version string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), upgradeSmokeTestTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, path, "-version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("new agent failed to start: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	reported := strings.TrimSpace(string(output))
+	if !strings.HasSuffix(reported, " v"+version) {
+		return fmt.Errorf("new agent reports %q, expected version %s", reported, version)
+	}
+	return nil
+}
+
+// restart 写入升级状态，备份当前二进制并切换到新版本后替换当前进程；失败时恢复旧版本继续运行
+func (u *AgentUpgrader) restart(spec *protobuf.UpgradeSpec, staged string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	state := &upgradeState{
+		Version:  spec.Version,
+		Previous: u.version,
+		Backup:   u.executable + ".old",
+		Deadline: time.Now().Add(time.Duration(spec.ReconnectTimeout) * time.Second),
+	}
+	if err := u.saveState(state); err != nil {
+		log.Printf("Agent upgrade to %s aborted: %v", spec.Version, err)
+		os.Remove(staged)
+		return
+	}
+
+	if err := os.Rename(u.executable, state.Backup); err != nil {
+		log.Printf("Agent upgrade to %s aborted: failed to back up current binary: %v", spec.Version, err)
+		u.removeState()
+		os.Remove(staged)
+		return
+	}
+	if err := os.Rename(staged, u.executable); err != nil {
+		log.Printf("Agent upgrade to %s aborted: failed to install new binary: %v", spec.Version, err)
+		u.restoreBackup(state.Backup)
+		u.removeState()
+		os.Remove(staged)
+		return
+	}
+	utils.SyncDir(filepath.Dir(u.executable))
+
+	log.Printf("Restarting into agent %s (previous %s)", spec.Version, u.version)
+	err := utils.ExecSelf(u.executable)
+
+	// 替换进程失败，恢复旧版本继续运行
+	log.Printf("Agent upgrade to %s aborted: failed to start new binary: %v", spec.Version, err)
+	u.restoreBackup(state.Backup)
+	u.removeState()
+}
+
+// Recover 启动时检查升级状态：新版本在期限内记录启动次数并等待连上 Server，
+// 超过期限或启动次数时回滚；旧版本回滚后启动时记录回滚原因。应在连接 Server 之前调用
+func (u *AgentUpgrader) Recover() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	state, err := u.loadState()
+	if err != nil {
+		log.Printf("Warning: ignoring unreadable upgrade state: %v", err)
+		u.removeState()
+		return
+	}
+	if state == nil {
+		return
+	}
+
+	switch {
+	case state.RolledBack != "":
+		log.Printf("Agent upgrade to %s was rolled back to %s: %s", state.Version, u.version, state.RolledBack)
+		u.removeState()
+	case state.Version != u.version:
+		log.Printf("Discarding upgrade state for agent %s, running %s", state.Version, u.version)
+		u.removeState()
+	default:
+		state.Starts++
+		if state.Starts > maxUpgradeStarts {
+			u.rollback(state, fmt.Sprintf("agent %s started %d times without connecting to server", state.Version, state.Starts-1))
+			return
+		}
+		remaining := time.Until(state.Deadline)
+		if remaining <= 0 {
+			u.rollback(state, fmt.Sprintf("agent %s did not connect to server before %s", state.Version, state.Deadline.Format(time.RFC3339)))
+			return
+		}
+		if err := u.saveState(state); err != nil {
+			log.Printf("Warning: failed to save upgrade state: %v", err)
+		}
+		u.state = state
+		u.watchdog = time.AfterFunc(remaining, u.expire)
+		log.Printf("Running upgraded agent %s, waiting up to %v to connect to server", state.Version, remaining.Round(time.Second))
+	}
+}
+
+// Confirm 新版本连上 Server 后确认升级：停止回滚计时，删除旧版本备份和升级状态
+func (u *AgentUpgrader) Confirm() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.state == nil {
+		return
+	}
+	u.watchdog.Stop()
+	if err := os.Remove(u.state.Backup); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Warning: failed to remove previous agent binary: %v", err)
+	}
+	u.removeState()
+	log.Printf("Agent upgrade from %s to %s confirmed", u.state.Previous, u.state.Version)
+	u.state = nil
+}
+
+// expire 新版本未能在期限内连上 Server，回滚到旧版本
+func (u *AgentUpgrader) expire() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.state == nil {
+		return
+	}
+	u.rollback(u.state, fmt.Sprintf("agent %s did not connect to server before %s", u.state.Version, u.state.Deadline.Format(time.RFC3339)))
+}
+
+// rollback 恢复旧版本二进制并替换当前进程，旧版本启动后记录回滚原因
+// 无法恢复时继续以当前版本运行；恢复后无法启动旧版本时退出，由进程管理器以旧版本重新拉起
+func (u *AgentUpgrader) rollback(state *upgradeState, reason string) {
+	log.Printf("Rolling back agent %s to %s: %s", state.Version, state.Previous, reason)
+
+	state.RolledBack = reason
+	if err := u.saveState(state); err != nil {
+		log.Printf("Warning: failed to save upgrade state: %v", err)
+	}
+	if err := os.Rename(state.Backup, u.executable); err != nil {
+		log.Printf("Rollback failed, keeping agent %s: %v", state.Version, err)
+		u.removeState()
+		u.state = nil
+		return
+	}
+	utils.SyncDir(filepath.Dir(u.executable))
+
+	err := utils.ExecSelf(u.executable)
+	log.Fatalf("Failed to start previous agent %s: %v", state.Previous, err)
+}
+
+// restoreBackup 将备份的旧版本二进制恢复到原路径
+func (u *AgentUpgrader) restoreBackup(backup string) {
+	if err := os.Rename(backup, u.executable); err != nil {
+		log.Printf("Failed to restore previous agent binary from %s: %v", backup, err)
+	}
+}
+
+// loadState 读取升级状态，状态文件不存在时返回 nil
+func (u *AgentUpgrader) loadState() (*upgradeState, error) {
+	data, err := os.ReadFile(u.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state upgradeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// saveState 原子写入升级状态
+func (u *AgentUpgrader) saveState(state *upgradeState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(u.stateFile)
+	if err := utils.EnsureDir(dir); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	tmp := u.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write upgrade state: %w", err)
+	}
+	if err := os.Rename(tmp, u.stateFile); err != nil {
+		return fmt.Errorf("failed to write upgrade state: %w", err)
+	}
+	utils.SyncDir(dir)
+	return nil
+}
+
+// removeState 删除升级状态
+func (u *AgentUpgrader) removeState() {
+	if err := os.Remove(u.stateFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Warning: failed to remove upgrade state: %v", err)
+	}
+}
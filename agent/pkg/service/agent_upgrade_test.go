@@ -0,0 +1,166 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePublicKey 将公钥以 PEM 格式写入临时文件
+func writePublicKey(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "upgrade.pub")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadUpgradePublicKey(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notPEM := filepath.Join(t.TempDir(), "upgrade.pub")
+	if err := os.WriteFile(notPEM, []byte("not a key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{name: "ed25519 key", path: writePublicKey(t, publicKey)},
+		{name: "missing file", path: filepath.Join(t.TempDir(), "missing.pub"), wantErr: "failed to read upgrade public key"},
+		{name: "not PEM", path: notPEM, wantErr: "no PEM block found"},
+		{name: "not ed25519", path: writePublicKey(t, &ecdsaKey.PublicKey), wantErr: "expected Ed25519"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := loadUpgradePublicKey(tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadUpgradePublicKey error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadUpgradePublicKey failed: %v", err)
+			}
+			if !key.Equal(publicKey) {
+				t.Error("loaded key differs from the written key")
+			}
+		})
+	}
+}
+
+func TestVerifyUpgradeSignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := []byte("agent binary v2")
+	path := filepath.Join(t.TempDir(), "devops-agent.new")
+	if err := os.WriteFile(path, binary, 0755); err != nil {
+		t.Fatal(err)
+	}
+	sign := func(key ed25519.PrivateKey, data []byte) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	}
+
+	signed := &AgentUpgrader{publicKey: publicKey}
+	unsigned := &AgentUpgrader{}
+
+	tests := []struct {
+		name      string
+		upgrader  *AgentUpgrader
+		signature string
+		wantErr   string
+	}{
+		{name: "valid signature", upgrader: signed, signature: sign(privateKey, binary)},
+		{name: "no public key accepts unsigned upgrade", upgrader: unsigned},
+		{name: "no public key ignores signature", upgrader: unsigned, signature: "invalid"},
+		{name: "unsigned upgrade", upgrader: signed, wantErr: "upgrade is not signed"},
+		{name: "invalid base64", upgrader: signed, signature: "not base64!", wantErr: "invalid upgrade signature"},
+		{name: "signature of other content", upgrader: signed, signature: sign(privateKey, []byte("agent binary v3")), wantErr: "verification failed"},
+		{name: "signed by another key", upgrader: signed, signature: sign(otherKey, binary), wantErr: "verification failed"},
+		{name: "truncated signature", upgrader: signed, signature: base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, binary)[:32]), wantErr: "verification failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.upgrader.verifySignature(path, tt.signature)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("verifySignature error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifySignature failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestSmokeTestAgent(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("/bin/sh unavailable")
+	}
+	dir := t.TempDir()
+	script := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{name: "expected version", path: script("ok", `echo "DevOps Agent v2.0.0"`)},
+		{name: "other version", path: script("other", `echo "DevOps Agent v1.9.0"`), wantErr: "expected version 2.0.0"},
+		{name: "version prefix", path: script("prefix", `echo "DevOps Agent v12.0.0"`), wantErr: "expected version 2.0.0"},
+		{name: "fails to start", path: script("fail", `echo "bad binary" >&2; exit 1`), wantErr: "new agent failed to start"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := smokeTestAgent(tt.path, "2.0.0")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("smokeTestAgent error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("smokeTestAgent failed: %v", err)
+			}
+		})
+	}
+}
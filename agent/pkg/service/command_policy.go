@@ -0,0 +1,229 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"devops-manager/agent/pkg/config"
+)
+
+// 策略动作
+const (
+	PolicyActionAllow = "allow"
+	PolicyActionDeny  = "deny"
+)
+
+// 策略拒绝代码
+const (
+	PolicyCodeDenied     = "POLICY_DENIED"      // 命中 deny 规则
+	PolicyCodeNotAllowed = "POLICY_NOT_ALLOWED" // 未命中任何 allow 规则
+)
+
+// PolicyViolation 命令被策略拒绝的结构化原因
+type PolicyViolation struct {
+	Code    string `json:"code"`
+	Rule    string `json:"rule,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+	User    string `json:"user,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+func (v *PolicyViolation) Error() string {
+	if v.Rule != "" {
+		return fmt.Sprintf("command rejected by policy rule %q: %s", v.Rule, v.Reason)
+	}
+	return "command rejected by policy: " + v.Reason
+}
+
+// Encode 编码为 JSON，作为 CommandResult.ErrorMessage 返回给 Server
+func (v *PolicyViolation) Encode() string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v.Error()
+	}
+	return string(data)
+}
+
+// policyRule 编译后的策略规则
+type policyRule struct {
+	name    string
+	action  string
+	pattern *regexp.Regexp
+	source  string // 原始 regex 或 glob，用于拒绝原因
+	users   map[string]bool
+}
+
+// appliesTo 检查规则是否对该用户生效
+func (r *policyRule) appliesTo(user string) bool {
+	return len(r.users) == 0 || r.users[user]
+}
+
+// CommandPolicy 命令执行策略
+type CommandPolicy struct {
+	dryRun bool
+	rules  []*policyRule
+}
+
+var (
+	commandPolicy      *CommandPolicy
+	commandPolicyMutex sync.RWMutex
+)
+
+func init() {
+	policy, err := NewCommandPolicy(config.PolicyConfig{Rules: config.DefaultPolicyRules()}, nil)
+	if err != nil {
+		panic(fmt.Sprintf("invalid default command policy: %v", err))
+	}
+	commandPolicy = policy
+}
+
+// SetCommandPolicy 设置全局命令执行策略
+func SetCommandPolicy(policy *CommandPolicy) {
+	commandPolicyMutex.Lock()
+	defer commandPolicyMutex.Unlock()
+	commandPolicy = policy
+}
+
+// GetCommandPolicy 获取全局命令执行策略
+func GetCommandPolicy() *CommandPolicy {
+	commandPolicyMutex.RLock()
+	defer commandPolicyMutex.RUnlock()
+	return commandPolicy
+}
+
+// NewCommandPolicy 根据配置编译命令策略，tags 为 Agent 标签，标签不匹配的规则会被忽略
+func NewCommandPolicy(cfg config.PolicyConfig, tags map[string]string) (*CommandPolicy, error) {
+	policy := &CommandPolicy{dryRun: cfg.DryRun}
+
+	for i, rc := range cfg.Rules {
+		name := rc.Name
+		if name == "" {
+			name = fmt.Sprintf("rule-%d", i+1)
+		}
+
+		if rc.Action != PolicyActionAllow && rc.Action != PolicyActionDeny {
+			return nil, fmt.Errorf("policy rule %s: action must be allow or deny", name)
+		}
+		if (rc.Regex == "") == (rc.Glob == "") {
+			return nil, fmt.Errorf("policy rule %s: exactly one of regex and glob is required", name)
+		}
+
+		if !tagsMatch(rc.Tags, tags) {
+			log.Printf("Policy rule %s skipped: agent tags do not match %v", name, rc.Tags)
+			continue
+		}
+
+		source := rc.Regex
+		expr := rc.Regex
+		if rc.Glob != "" {
+			source = rc.Glob
+			expr = globToRegexp(rc.Glob)
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("policy rule %s: invalid pattern: %w", name, err)
+		}
+
+		rule := &policyRule{
+			name:    name,
+			action:  rc.Action,
+			pattern: pattern,
+			source:  source,
+		}
+		if len(rc.Users) > 0 {
+			rule.users = make(map[string]bool, len(rc.Users))
+			for _, user := range rc.Users {
+				rule.users[user] = true
+			}
+		}
+		policy.rules = append(policy.rules, rule)
+	}
+
+	return policy, nil
+}
+
+// Check 检查命令是否允许执行，user 为发起命令的用户
+// dry-run 模式下只记录将被拒绝的命令并放行
+func (p *CommandPolicy) Check(command, user string) error {
+	violation := p.evaluate(strings.TrimSpace(command), user)
+	if violation == nil {
+		return nil
+	}
+
+	if p.dryRun {
+		log.Printf("Policy dry-run: command %q would be rejected: %s", command, violation.Encode())
+		return nil
+	}
+	return violation
+}
+
+// evaluate 按规则评估命令，deny 规则优先于 allow 规则
+func (p *CommandPolicy) evaluate(command, user string) *PolicyViolation {
+	hasAllowRules := false
+	allowed := false
+
+	for _, rule := range p.rules {
+		if !rule.appliesTo(user) {
+			continue
+		}
+
+		switch rule.action {
+		case PolicyActionDeny:
+			if rule.pattern.MatchString(command) {
+				return &PolicyViolation{
+					Code:    PolicyCodeDenied,
+					Rule:    rule.name,
+					Pattern: rule.source,
+					User:    user,
+					Reason:  "command matches deny rule",
+				}
+			}
+		case PolicyActionAllow:
+			hasAllowRules = true
+			if !allowed && rule.pattern.MatchString(command) {
+				allowed = true
+			}
+		}
+	}
+
+	if hasAllowRules && !allowed {
+		return &PolicyViolation{
+			Code:   PolicyCodeNotAllowed,
+			User:   user,
+			Reason: "command does not match any allow rule",
+		}
+	}
+	return nil
+}
+
+// tagsMatch 检查 Agent 标签是否包含规则要求的全部标签
+func tagsMatch(required, tags map[string]string) bool {
+	for k, v := range required {
+		if tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// globToRegexp 将通配符转换为匹配整条命令的正则表达式
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString(`(?s)^`)
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(`.*`)
+		case '?':
+			b.WriteString(`.`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString(`$`)
+	return b.String()
+}
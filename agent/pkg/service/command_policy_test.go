@@ -0,0 +1,273 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"devops-manager/agent/pkg/config"
+)
+
+func TestCommandPolicyCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []config.PolicyRule
+		tags     map[string]string
+		command  string
+		user     string
+		wantCode string // 为空表示允许执行
+		wantRule string
+	}{
+		{
+			name:    "no rules allows everything",
+			command: "rm -rf /tmp/x",
+		},
+		{
+			name: "deny takes precedence over allow",
+			rules: []config.PolicyRule{
+				{Name: "allow-all", Action: PolicyActionAllow, Glob: "*"},
+				{Name: "deny-curl", Action: PolicyActionDeny, Regex: `\bcurl\b`},
+			},
+			command:  "curl http://example.com",
+			wantCode: PolicyCodeDenied,
+			wantRule: "deny-curl",
+		},
+		{
+			name: "deny before allow in rule order",
+			rules: []config.PolicyRule{
+				{Name: "deny-curl", Action: PolicyActionDeny, Regex: `\bcurl\b`},
+				{Name: "allow-all", Action: PolicyActionAllow, Glob: "*"},
+			},
+			command:  "curl http://example.com",
+			wantCode: PolicyCodeDenied,
+			wantRule: "deny-curl",
+		},
+		{
+			name: "command matching an allow rule",
+			rules: []config.PolicyRule{
+				{Name: "allow-systemctl", Action: PolicyActionAllow, Glob: "systemctl status *"},
+			},
+			command: "systemctl status nginx",
+		},
+		{
+			name: "command matching no allow rule",
+			rules: []config.PolicyRule{
+				{Name: "allow-systemctl", Action: PolicyActionAllow, Glob: "systemctl status *"},
+			},
+			command:  "systemctl stop nginx",
+			wantCode: PolicyCodeNotAllowed,
+		},
+		{
+			name: "deny rules only allow unmatched commands",
+			rules: []config.PolicyRule{
+				{Name: "deny-curl", Action: PolicyActionDeny, Regex: `\bcurl\b`},
+			},
+			command: "uptime",
+		},
+		{
+			name: "glob matches the whole command",
+			rules: []config.PolicyRule{
+				{Name: "deny-uptime", Action: PolicyActionDeny, Glob: "uptime"},
+			},
+			command: "uptime && curl http://example.com",
+		},
+		{
+			name: "regex matches anywhere in the command",
+			rules: []config.PolicyRule{
+				{Name: "deny-uptime", Action: PolicyActionDeny, Regex: "uptime"},
+			},
+			command:  "date; uptime",
+			wantCode: PolicyCodeDenied,
+			wantRule: "deny-uptime",
+		},
+		{
+			name: "glob metacharacters are literal",
+			rules: []config.PolicyRule{
+				{Name: "allow-cat", Action: PolicyActionAllow, Glob: "cat /var/log/app.log"},
+			},
+			command:  "cat /var/log/appXlog",
+			wantCode: PolicyCodeNotAllowed,
+		},
+		{
+			name: "glob question mark matches one character",
+			rules: []config.PolicyRule{
+				{Name: "allow-tail", Action: PolicyActionAllow, Glob: "tail -n ? app.log"},
+			},
+			command: "tail -n 5 app.log",
+		},
+		{
+			name: "surrounding whitespace is ignored",
+			rules: []config.PolicyRule{
+				{Name: "allow-uptime", Action: PolicyActionAllow, Glob: "uptime"},
+			},
+			command: "  uptime\n",
+		},
+		{
+			name: "user rule applies to listed user",
+			rules: []config.PolicyRule{
+				{Name: "deny-alice-docker", Action: PolicyActionDeny, Glob: "docker *", Users: []string{"alice"}},
+			},
+			command:  "docker ps",
+			user:     "alice",
+			wantCode: PolicyCodeDenied,
+			wantRule: "deny-alice-docker",
+		},
+		{
+			name: "user rule ignored for other users",
+			rules: []config.PolicyRule{
+				{Name: "deny-alice-docker", Action: PolicyActionDeny, Glob: "docker *", Users: []string{"alice"}},
+			},
+			command: "docker ps",
+			user:    "bob",
+		},
+		{
+			name: "allow rules of other users do not restrict",
+			rules: []config.PolicyRule{
+				{Name: "allow-alice-uptime", Action: PolicyActionAllow, Glob: "uptime", Users: []string{"alice"}},
+			},
+			command: "docker ps",
+			user:    "bob",
+		},
+		{
+			name: "allow rules of the user restrict",
+			rules: []config.PolicyRule{
+				{Name: "allow-alice-uptime", Action: PolicyActionAllow, Glob: "uptime", Users: []string{"alice"}},
+			},
+			command:  "docker ps",
+			user:     "alice",
+			wantCode: PolicyCodeNotAllowed,
+		},
+		{
+			name: "tag rule applies when tags match",
+			rules: []config.PolicyRule{
+				{Name: "deny-prod-restart", Action: PolicyActionDeny, Glob: "systemctl restart *", Tags: map[string]string{"env": "prod"}},
+			},
+			tags:     map[string]string{"env": "prod", "role": "web"},
+			command:  "systemctl restart nginx",
+			wantCode: PolicyCodeDenied,
+			wantRule: "deny-prod-restart",
+		},
+		{
+			name: "tag rule skipped when tags differ",
+			rules: []config.PolicyRule{
+				{Name: "deny-prod-restart", Action: PolicyActionDeny, Glob: "systemctl restart *", Tags: map[string]string{"env": "prod"}},
+			},
+			tags:    map[string]string{"env": "staging"},
+			command: "systemctl restart nginx",
+		},
+		{
+			name: "tag rule skipped when agent has no tags",
+			rules: []config.PolicyRule{
+				{Name: "allow-prod-status", Action: PolicyActionAllow, Glob: "systemctl status *", Tags: map[string]string{"env": "prod"}},
+			},
+			command: "systemctl restart nginx",
+		},
+		{
+			name: "unnamed rule gets positional name",
+			rules: []config.PolicyRule{
+				{Action: PolicyActionAllow, Glob: "*"},
+				{Action: PolicyActionDeny, Regex: "curl"},
+			},
+			command:  "curl http://example.com",
+			wantCode: PolicyCodeDenied,
+			wantRule: "rule-2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewCommandPolicy(config.PolicyConfig{Rules: tt.rules}, tt.tags)
+			if err != nil {
+				t.Fatalf("NewCommandPolicy failed: %v", err)
+			}
+
+			err = policy.Check(tt.command, tt.user)
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Errorf("Check(%q, %q) = %v, want allowed", tt.command, tt.user, err)
+				}
+				return
+			}
+
+			var violation *PolicyViolation
+			if !errors.As(err, &violation) {
+				t.Fatalf("Check(%q, %q) = %v, want a PolicyViolation", tt.command, tt.user, err)
+			}
+			if violation.Code != tt.wantCode || violation.Rule != tt.wantRule || violation.User != tt.user {
+				t.Errorf("violation = %+v, want code %s, rule %q and user %q", violation, tt.wantCode, tt.wantRule, tt.user)
+			}
+		})
+	}
+}
+
+func TestCommandPolicyDryRun(t *testing.T) {
+	policy, err := NewCommandPolicy(config.PolicyConfig{
+		DryRun: true,
+		Rules:  []config.PolicyRule{{Name: "deny-curl", Action: PolicyActionDeny, Regex: "curl"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewCommandPolicy failed: %v", err)
+	}
+	if err := policy.Check("curl http://example.com", "alice"); err != nil {
+		t.Errorf("Check in dry-run mode = %v, want allowed", err)
+	}
+}
+
+func TestDefaultPolicyRules(t *testing.T) {
+	policy, err := NewCommandPolicy(config.PolicyConfig{Rules: config.DefaultPolicyRules()}, nil)
+	if err != nil {
+		t.Fatalf("NewCommandPolicy failed: %v", err)
+	}
+
+	tests := []struct {
+		command  string
+		wantRule string // 为空表示允许执行
+	}{
+		{command: "rm -rf /", wantRule: "deny-rm-root"},
+		{command: "cd /tmp && rm -rf /*", wantRule: "deny-rm-root"},
+		{command: "rm -rf /tmp/build"},
+		{command: "mkfs.ext4 /dev/sdb1", wantRule: "deny-format-disk"},
+		{command: "format C:", wantRule: "deny-format-disk"},
+		{command: "del /q /s C:\\data", wantRule: "deny-del-recursive"},
+		{command: "shutdown -h now", wantRule: "deny-shutdown"},
+		{command: "sudo reboot", wantRule: "deny-shutdown"},
+		{command: "init 0", wantRule: "deny-shutdown"},
+		{command: "systemctl status reboot-notifier"},
+		{command: "uptime"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			err := policy.Check(tt.command, "")
+			if tt.wantRule == "" {
+				if err != nil {
+					t.Errorf("Check(%q) = %v, want allowed", tt.command, err)
+				}
+				return
+			}
+			var violation *PolicyViolation
+			if !errors.As(err, &violation) || violation.Rule != tt.wantRule {
+				t.Errorf("Check(%q) = %v, want rejected by %s", tt.command, err, tt.wantRule)
+			}
+		})
+	}
+}
+
+func TestNewCommandPolicyInvalidRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule config.PolicyRule
+	}{
+		{name: "unknown action", rule: config.PolicyRule{Action: "block", Glob: "*"}},
+		{name: "missing pattern", rule: config.PolicyRule{Action: PolicyActionDeny}},
+		{name: "both regex and glob", rule: config.PolicyRule{Action: PolicyActionDeny, Regex: "curl", Glob: "curl *"}},
+		{name: "invalid regex", rule: config.PolicyRule{Action: PolicyActionDeny, Regex: "("}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewCommandPolicy(config.PolicyConfig{Rules: []config.PolicyRule{tt.rule}}, nil); err == nil {
+				t.Error("NewCommandPolicy succeeded, want an error")
+			}
+		})
+	}
+}
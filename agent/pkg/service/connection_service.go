@@ -34,7 +34,7 @@ func NewConnectionService(cfg *config.Config) *ConnectionService {
 
 	return &ConnectionService{
 		config:     cfg,
-		grpcClient: grpc.NewAgent(cfg.Server.Address, cfg.Server.Timeout, cfg.Server.RetryInterval),
+		grpcClient: grpc.NewAgent(cfg.Server.Address, cfg.Server.Timeout, cfg.Server.RetryInterval, newTLSFiles(cfg)),
 		ctx:        ctx,
 		cancel:     cancel,
 	}
@@ -94,8 +94,7 @@ func (cs *ConnectionService) SendMessage(msg *protobuf.CommandMessage) error {
 		return fmt.Errorf("not connected to server")
 	}
 
-	// TODO: 实现命令消息发送逻辑
-	return fmt.Errorf("SendMessage not implemented yet")
+	return cs.grpcClient.SendCommandMessage(msg)
 }
 
 // Register 注册到服务器
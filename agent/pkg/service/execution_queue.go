@@ -0,0 +1,191 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"sync"
+)
+
+// 执行队列默认配置
+const (
+	DefaultMaxConcurrentCommands = 4
+	DefaultCommandQueueSize      = 100
+)
+
+// ErrQueueFull 执行队列已满，命令被拒绝
+var ErrQueueFull = errors.New("agent busy: command queue is full")
+
+// errQueuedCanceled 命令在排队期间被取消
+var errQueuedCanceled = errors.New("command canceled while queued")
+
+// queuedCommand 排队中的命令
+type queuedCommand struct {
+	commandID string
+	run       func()
+	canceled  bool
+	done      chan struct{}
+}
+
+// ExecutionQueue 命令执行队列，固定数量的 worker 按 FIFO 顺序执行命令
+type ExecutionQueue struct {
+	jobs     chan *queuedCommand
+	workers  int
+	mutex    sync.Mutex
+	queued   map[string]*queuedCommand
+	running  int
+	capacity int
+}
+
+// QueueStats 执行队列状态
+type QueueStats struct {
+	Running       int
+	Queued        int
+	MaxConcurrent int
+	Capacity      int
+}
+
+var (
+	executionQueue      *ExecutionQueue
+	executionQueueMutex sync.RWMutex
+)
+
+func init() {
+	executionQueue = NewExecutionQueue(DefaultMaxConcurrentCommands, DefaultCommandQueueSize)
+}
+
+// SetExecutionQueue 设置全局命令执行队列
+func SetExecutionQueue(queue *ExecutionQueue) {
+	executionQueueMutex.Lock()
+	defer executionQueueMutex.Unlock()
+	executionQueue = queue
+}
+
+// GetExecutionQueue 获取全局命令执行队列
+func GetExecutionQueue() *ExecutionQueue {
+	executionQueueMutex.RLock()
+	defer executionQueueMutex.RUnlock()
+	return executionQueue
+}
+
+// NewExecutionQueue 创建执行队列并启动 worker，workers 为最大并发数，capacity 为排队容量
+func NewExecutionQueue(workers, capacity int) *ExecutionQueue {
+	if workers <= 0 {
+		workers = DefaultMaxConcurrentCommands
+	}
+	if capacity < 0 {
+		capacity = 0
+	}
+
+	q := &ExecutionQueue{
+		jobs:     make(chan *queuedCommand, capacity),
+		workers:  workers,
+		queued:   make(map[string]*queuedCommand),
+		capacity: capacity,
+	}
+	for i := 0; i < workers; i++ {
+		go q.worker()
+	}
+	return q
+}
+
+// Run 将命令加入队列并等待执行完成
+// 队列已满时立即返回 ErrQueueFull；排队期间被取消时返回 errQueuedCanceled，run 不会执行
+func (q *ExecutionQueue) Run(commandID string, run func()) error {
+	job := &queuedCommand{
+		commandID: commandID,
+		run:       run,
+		done:      make(chan struct{}),
+	}
+
+	q.mutex.Lock()
+	if _, exists := q.queued[commandID]; exists {
+		q.mutex.Unlock()
+		return errors.New("command " + commandID + " is already queued")
+	}
+	// 有空闲 worker 时直接交给 worker，否则占用排队容量
+	select {
+	case q.jobs <- job:
+		q.queued[commandID] = job
+	default:
+		q.mutex.Unlock()
+		return ErrQueueFull
+	}
+	q.mutex.Unlock()
+
+	<-job.done
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if job.canceled {
+		return errQueuedCanceled
+	}
+	return nil
+}
+
+// worker 按 FIFO 顺序取出命令执行，跳过排队期间被取消的命令
+func (q *ExecutionQueue) worker() {
+	for job := range q.jobs {
+		q.mutex.Lock()
+		if q.queued[job.commandID] == job {
+			delete(q.queued, job.commandID)
+		}
+		if job.canceled {
+			q.mutex.Unlock()
+			continue
+		}
+		q.running++
+		q.mutex.Unlock()
+
+		q.execute(job)
+
+		q.mutex.Lock()
+		q.running--
+		q.mutex.Unlock()
+	}
+}
+
+// execute 执行命令，命令异常退出时不影响 worker
+func (q *ExecutionQueue) execute(job *queuedCommand) {
+	defer close(job.done)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Command %s panicked: %v", job.commandID, r)
+		}
+	}()
+	job.run()
+}
+
+// Cancel 取消排队中的命令，命令已开始执行或不在队列中时返回 false
+func (q *ExecutionQueue) Cancel(commandID string) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	job, exists := q.queued[commandID]
+	if !exists || job.canceled {
+		return false
+	}
+	job.canceled = true
+	delete(q.queued, commandID)
+	close(job.done)
+	return true
+}
+
+// IsQueued 检查命令是否在排队
+func (q *ExecutionQueue) IsQueued(commandID string) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	_, exists := q.queued[commandID]
+	return exists
+}
+
+// Stats 返回执行队列状态
+func (q *ExecutionQueue) Stats() QueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return QueueStats{
+		Running:       q.running,
+		Queued:        len(q.queued),
+		MaxConcurrent: q.workers,
+		Capacity:      q.capacity,
+	}
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"devops-manager/agent/pkg/config"
+	"devops-manager/agent/pkg/utils"
+	"devops-manager/api/protobuf"
+	"devops-manager/api/ratelimit"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fetchChunkSize 回传文件时每个分片的字节数
+const fetchChunkSize = 256 * 1024
+
+// errFetchSend 分片发送失败，命令流已断开，停止回传
+var errFetchSend = errors.New("failed to send file chunk")
+
+// FileCollector 收集 Server 指定的文件：只回传 fetch_allowed_dirs 内的普通文件，符号链接解析后检查；
+// 收集命令同样经过命令策略和执行用户策略，文件须可由执行用户读取
+type FileCollector struct {
+	allowedDirs []string
+}
+
+var (
+	fileCollector      = &FileCollector{}
+	fileCollectorMutex sync.RWMutex
+)
+
+// fetchFile 待回传的文件，path 为匹配到的路径，resolved 为解析符号链接后的路径
+type fetchFile struct {
+	path     string
+	resolved string
+}
+
+// NewFileCollector 根据文件配置创建文件收集器，未配置允许收集的目录时拒绝所有文件收集
+func NewFileCollector(cfg config.FilesConfig) *FileCollector {
+	collector := &FileCollector{}
+	for _, dir := range cfg.FetchAllowedDirs {
+		if dir != "" {
+			collector.allowedDirs = append(collector.allowedDirs, filepath.Clean(dir))
+		}
+	}
+	return collector
+}
+
+// SetFileCollector 设置全局文件收集器
+func SetFileCollector(collector *FileCollector) {
+	fileCollectorMutex.Lock()
+	defer fileCollectorMutex.Unlock()
+	fileCollector = collector
+}
+
+// GetFileCollector 获取全局文件收集器
+func GetFileCollector() *FileCollector {
+	fileCollectorMutex.RLock()
+	defer fileCollectorMutex.RUnlock()
+	return fileCollector
+}
+
+// HandleFetch 在执行队列中收集匹配的文件并回传给 Server，实现 grpc.FetchHandler
+func HandleFetch(content *protobuf.CommandContent, send func(*protobuf.FetchChunk) error) *protobuf.CommandResult {
+	var result *protobuf.CommandResult
+	err := GetExecutionQueue().Run(content.CommandId, func() {
+		result = GetFileCollector().Collect(content, send)
+	})
+	if err == nil && result != nil {
+		return result
+	}
+	return rejectedResult(content, err)
+}
+
+// Collect 收集匹配的文件并回传给 Server
+// 超过单文件大小上限的文件被跳过，达到总字节数上限后不再回传，回传速度不超过 bandwidth_limit；标准输出逐行列出已回传的文件及摘要和被跳过的文件
+func (c *FileCollector) Collect(content *protobuf.CommandContent, send func(*protobuf.FetchChunk) error) *protobuf.CommandResult {
+	spec := content.Fetch
+	log.Printf("Collecting files for command %s: %v", content.CommandId, spec.Patterns)
+
+	startedAt := timestamppb.Now()
+	options, err := c.authorize(content)
+	if err != nil {
+		log.Printf("File collection %s rejected: %v", content.CommandId, err)
+		errorMessage := err.Error()
+		var violation *PolicyViolation
+		if errors.As(err, &violation) {
+			errorMessage = violation.Encode()
+		}
+		return &protobuf.CommandResult{
+			CommandId:    content.CommandId,
+			HostId:       content.HostId,
+			Stderr:       err.Error(),
+			ExitCode:     -1,
+			StartedAt:    startedAt,
+			FinishedAt:   timestamppb.Now(),
+			ErrorMessage: errorMessage,
+		}
+	}
+	files, skipped := c.matchFetchPatterns(spec.Patterns, options)
+
+	limiter := ratelimit.New(spec.BandwidthLimit)
+	var stdout strings.Builder
+	var total int64
+	sent := 0
+	var sendErr error
+	for _, file := range files {
+		size, digest, err := sendFetchFile(file, spec.MaxFileBytes, spec.MaxTotalBytes, total, limiter, send)
+		if errors.Is(err, errFetchSend) {
+			sendErr = err
+			break
+		}
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", file.path, err))
+			continue
+		}
+		total += size
+		sent++
+		fmt.Fprintf(&stdout, "%s %d %s\n", file.path, size, digest)
+	}
+	for _, entry := range skipped {
+		fmt.Fprintf(&stdout, "skipped %s\n", entry)
+	}
+	finishedAt := timestamppb.Now()
+
+	result := &protobuf.CommandResult{
+		CommandId:  content.CommandId,
+		HostId:     content.HostId,
+		Stdout:     stdout.String(),
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+	}
+	switch {
+	case sendErr != nil:
+		log.Printf("File collection %s failed: %v", content.CommandId, sendErr)
+		result.ExitCode = -1
+		result.ErrorMessage = sendErr.Error()
+		result.Stderr = sendErr.Error()
+	case sent == 0:
+		result.ExitCode = 1
+		result.ErrorMessage = "no files matched"
+		result.Stderr = "no files matched\n"
+	default:
+		log.Printf("File collection %s completed: %d files, %d bytes", content.CommandId, sent, total)
+	}
+	return result
+}
+
+// authorize 检查是否允许文件收集，以命令内容（收集任务的描述）匹配命令策略，返回确定了执行用户的执行选项
+func (c *FileCollector) authorize(content *protobuf.CommandContent) (*utils.ExecOptions, error) {
+	if len(c.allowedDirs) == 0 {
+		return nil, fmt.Errorf("file collection is disabled: files.fetch_allowed_dirs is not configured")
+	}
+	command := content.Command
+	if command == "" {
+		command = "file fetch: " + strings.Join(content.Fetch.Patterns, " ")
+	}
+	if err := GetCommandPolicy().Check(command, content.RequestedBy); err != nil {
+		return nil, err
+	}
+	return GetRunAsPolicy().Resolve(execOptions(content.Options), content.RequestedBy)
+}
+
+// matchFetchPatterns 展开匹配模式并去重，只保留允许的目录内执行用户可读的普通文件，返回排序后的文件和被跳过的条目
+func (c *FileCollector) matchFetchPatterns(patterns []string, options *utils.ExecOptions) ([]fetchFile, []string) {
+	var files []fetchFile
+	var skipped []string
+	seen := make(map[string]struct{})
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", pattern, err))
+			continue
+		}
+		for _, match := range matches {
+			if _, exists := seen[match]; exists {
+				continue
+			}
+			seen[match] = struct{}{}
+			resolved, err := filepath.EvalSymlinks(match)
+			if err != nil {
+				skipped = append(skipped, fmt.Sprintf("%s: %v", match, err))
+				continue
+			}
+			if resolved, err = filepath.Abs(resolved); err != nil {
+				skipped = append(skipped, fmt.Sprintf("%s: %v", match, err))
+				continue
+			}
+			if !c.allowed(resolved) {
+				skipped = append(skipped, fmt.Sprintf("%s: not in an allowed directory", match))
+				continue
+			}
+			info, err := os.Stat(resolved)
+			if err != nil {
+				skipped = append(skipped, fmt.Sprintf("%s: %v", match, err))
+				continue
+			}
+			if !info.Mode().IsRegular() {
+				continue
+			}
+			if err := utils.CheckReadAccess(options.User, options.Group, resolved); err != nil {
+				skipped = append(skipped, fmt.Sprintf("%s: %v", match, err))
+				continue
+			}
+			files = append(files, fetchFile{path: match, resolved: resolved})
+		}
+	}
+	return files, skipped
+}
+
+// allowed 检查已解析的路径是否位于允许收集的目录内
+func (c *FileCollector) allowed(resolved string) bool {
+	for _, dir := range c.allowedDirs {
+		allowedDir, err := utils.ResolvePath(dir)
+		if err != nil {
+			continue
+		}
+		if utils.PathWithin(allowedDir, resolved) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendFetchFile 按分片回传文件并计算摘要，最后一个分片标记 eof；文件内容以打开时的大小为准
+// 打开后确认解析后的路径中仍没有符号链接且指向同一文件，避免检查后路径被替换
+func sendFetchFile(target fetchFile, maxFileBytes, maxTotalBytes, total int64, limiter *ratelimit.Limiter, send func(*protobuf.FetchChunk) error) (int64, string, error) {
+	file, err := os.Open(target.resolved)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, "", err
+	}
+	if !info.Mode().IsRegular() {
+		return 0, "", fmt.Errorf("not a regular file")
+	}
+	current, err := filepath.EvalSymlinks(target.resolved)
+	if err != nil || current != target.resolved {
+		return 0, "", fmt.Errorf("file changed during collection")
+	}
+	if linkInfo, err := os.Lstat(target.resolved); err != nil || !os.SameFile(info, linkInfo) {
+		return 0, "", fmt.Errorf("file changed during collection")
+	}
+	size := info.Size()
+	if maxFileBytes > 0 && size > maxFileBytes {
+		return 0, "", fmt.Errorf("size %d exceeds %d bytes", size, maxFileBytes)
+	}
+	if maxTotalBytes > 0 && total+size > maxTotalBytes {
+		return 0, "", fmt.Errorf("total size exceeds %d bytes", maxTotalBytes)
+	}
+
+	digest := sha256.New()
+	reader := io.LimitReader(file, size)
+	var offset int64
+	for {
+		// 分片在发送队列中等待发送，每个分片使用独立的缓冲
+		buf := make([]byte, fetchChunkSize)
+		n, err := io.ReadFull(reader, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, "", fmt.Errorf("failed to read file: %w", err)
+		}
+		digest.Write(buf[:n])
+		limiter.Wait(context.Background(), n)
+		eof := offset+int64(n) >= size || err != nil
+		if sendErr := send(&protobuf.FetchChunk{Path: target.path, Offset: offset, Data: buf[:n], Eof: eof}); sendErr != nil {
+			return 0, "", fmt.Errorf("%w: %v", errFetchSend, sendErr)
+		}
+		offset += int64(n)
+		if eof {
+			return offset, hex.EncodeToString(digest.Sum(nil)), nil
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devops-manager/agent/pkg/config"
+	"devops-manager/api/protobuf"
+)
+
+func TestFileCollectorCollect(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := filepath.Join(root, "allowed")
+	outside := filepath.Join(root, "outside")
+	for _, dir := range []string{allowed, outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]os.FileMode{
+		filepath.Join(allowed, "app.log"):    0644,
+		filepath.Join(allowed, "secret.log"): 0600,
+		filepath.Join(outside, "passwd"):     0644,
+	}
+	for path, mode := range files {
+		if err := os.WriteFile(path, []byte("data\n"), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 允许的目录内指向外部的文件链接
+	if err := os.Symlink(filepath.Join(outside, "passwd"), filepath.Join(allowed, "passwd.log")); err != nil {
+		t.Skipf("symbolic links unavailable: %v", err)
+	}
+
+	savedCommandPolicy, savedRunAsPolicy := GetCommandPolicy(), GetRunAsPolicy()
+	t.Cleanup(func() {
+		SetCommandPolicy(savedCommandPolicy)
+		SetRunAsPolicy(savedRunAsPolicy)
+	})
+	commandPolicy, err := NewCommandPolicy(config.PolicyConfig{Rules: []config.PolicyRule{
+		{Name: "deny-outside", Action: PolicyActionDeny, Glob: "file fetch: *" + outside + "*"},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetCommandPolicy(commandPolicy)
+
+	agentUser, err := newRunAsPolicy(config.ExecutionConfig{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var asNobody *RunAsPolicy
+	if current, err := user.Current(); err == nil && current.Uid == "0" {
+		if _, err := user.Lookup("nobody"); err == nil {
+			if asNobody, err = newRunAsPolicy(config.ExecutionConfig{DefaultUser: "nobody"}, true); err != nil {
+				t.Fatal(err)
+			}
+			// 执行用户需要能进入临时目录
+			for _, dir := range []string{filepath.Dir(root), root} {
+				if err := os.Chmod(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	collector := NewFileCollector(config.FilesConfig{FetchAllowedDirs: []string{allowed}})
+	disabled := NewFileCollector(config.FilesConfig{})
+
+	tests := []struct {
+		name        string
+		collector   *FileCollector
+		runAs       *RunAsPolicy
+		asNobody    bool
+		patterns    []string
+		wantFiles   []string
+		wantSkipped []string
+		wantCode    string
+		wantErr     string
+	}{
+		{name: "file in allowed dir", collector: collector, patterns: []string{filepath.Join(allowed, "app.log")}, wantFiles: []string{filepath.Join(allowed, "app.log")}},
+		{name: "collection disabled", collector: disabled, patterns: []string{filepath.Join(allowed, "app.log")}, wantErr: "files.fetch_allowed_dirs is not configured"},
+		{name: "outside allowed dir", collector: collector, patterns: []string{filepath.Join(root, "*", "*")}, wantFiles: []string{filepath.Join(allowed, "app.log"), filepath.Join(allowed, "secret.log")}, wantSkipped: []string{outside + "/passwd: not in an allowed directory"}},
+		{name: "parent traversal", collector: collector, patterns: []string{allowed + "/../outside/passwd"}, wantSkipped: []string{"not in an allowed directory"}},
+		{name: "symlink escapes allowed dir", collector: collector, patterns: []string{filepath.Join(allowed, "passwd.log")}, wantSkipped: []string{"not in an allowed directory"}},
+		{name: "denied by command policy", collector: collector, patterns: []string{filepath.Join(outside, "passwd")}, wantCode: PolicyCodeDenied},
+		{name: "run-as user denied", collector: collector, runAs: &RunAsPolicy{privileged: true}, patterns: []string{filepath.Join(allowed, "app.log")}, wantCode: PolicyCodeRunAsDenied},
+		{name: "run-as user can read", collector: collector, asNobody: true, patterns: []string{filepath.Join(allowed, "app.log")}, wantFiles: []string{filepath.Join(allowed, "app.log")}},
+		{name: "run-as user cannot read", collector: collector, asNobody: true, patterns: []string{filepath.Join(allowed, "secret.log")}, wantSkipped: []string{"user nobody cannot read"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runAs := tt.runAs
+			if tt.asNobody {
+				if asNobody == nil {
+					t.Skip("requires running as root with a nobody user")
+				}
+				runAs = asNobody
+			}
+			if runAs == nil {
+				runAs = agentUser
+			}
+			SetRunAsPolicy(runAs)
+
+			var sent []string
+			send := func(chunk *protobuf.FetchChunk) error {
+				if chunk.Eof {
+					sent = append(sent, chunk.Path)
+				}
+				return nil
+			}
+			content := &protobuf.CommandContent{
+				CommandId:   "cmd-1",
+				RequestedBy: "alice",
+				Fetch:       &protobuf.FetchSpec{Patterns: tt.patterns},
+			}
+			result := tt.collector.Collect(content, send)
+
+			if tt.wantCode != "" || tt.wantErr != "" {
+				if result.ExitCode != -1 || len(sent) != 0 {
+					t.Fatalf("Collect exit code = %d, sent %v, want rejected", result.ExitCode, sent)
+				}
+				if tt.wantCode != "" {
+					var violation PolicyViolation
+					if err := json.Unmarshal([]byte(result.ErrorMessage), &violation); err != nil || violation.Code != tt.wantCode {
+						t.Fatalf("error message %q, want policy violation %s", result.ErrorMessage, tt.wantCode)
+					}
+				}
+				if !strings.Contains(result.ErrorMessage, tt.wantErr) {
+					t.Fatalf("error message %q, want %q", result.ErrorMessage, tt.wantErr)
+				}
+				return
+			}
+			if strings.Join(sent, ",") != strings.Join(tt.wantFiles, ",") {
+				t.Errorf("sent files = %v, want %v", sent, tt.wantFiles)
+			}
+			for _, reason := range tt.wantSkipped {
+				if !strings.Contains(result.Stdout, reason) {
+					t.Errorf("stdout %q does not report %q", result.Stdout, reason)
+				}
+			}
+			if len(tt.wantFiles) == 0 && result.ExitCode != 1 {
+				t.Errorf("exit code = %d, want 1 when no file is sent", result.ExitCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,468 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"devops-manager/agent/pkg/config"
+	"devops-manager/agent/pkg/grpc"
+	"devops-manager/agent/pkg/utils"
+	"devops-manager/api/protobuf"
+	"devops-manager/api/ratelimit"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// defaultDistributedFileMode 未指定权限时分发文件的权限
+const defaultDistributedFileMode = 0644
+
+// resumeRetryInterval 命令流断开后重新请求分片的间隔
+const resumeRetryInterval = time.Second
+
+// errTransferInterrupted 命令流断开，等待重连后继续请求分片
+var errTransferInterrupted = errors.New("command stream interrupted")
+
+// FileReceiver 接收 Server 分发的文件：写入目标目录下的临时文件，校验大小和 SHA-256 后设置属主和权限，再原子重命名到目标路径
+// 带分片清单时逐个请求分片，分片来自本地缓存、同区域的其他 Agent 或 Server，均按分片摘要校验；
+// 命令流断开后在 resumeTimeout 内等待重连，从未完成的分片继续
+type FileReceiver struct {
+	allowedDirs   []string
+	idleTimeout   time.Duration
+	resumeTimeout time.Duration
+
+	// 对等分发的双向 TLS 配置，为 nil 时不从对等 Agent 获取分片；每个对等主机使用校验其证书的客户端
+	peerTLS          *tls.Config
+	peerTimeout      time.Duration
+	peerClients      map[string]*http.Client
+	peerClientsMutex sync.Mutex
+}
+
+var (
+	fileReceiver = &FileReceiver{
+		idleTimeout:   60 * time.Second,
+		resumeTimeout: 10 * time.Minute,
+	}
+	fileReceiverMutex sync.RWMutex
+)
+
+// NewFileReceiver 根据文件分发和对等分发配置创建文件接收器，tlsCfg 为连接 Server 的 TLS 配置，对等分发使用同一证书
+func NewFileReceiver(cfg config.FilesConfig, peer config.PeerConfig, tlsCfg config.TLSConfig) *FileReceiver {
+	receiver := &FileReceiver{
+		idleTimeout:   cfg.IdleTimeout,
+		resumeTimeout: cfg.ResumeTimeout,
+		peerTimeout:   peer.FetchTimeout,
+		peerClients:   make(map[string]*http.Client),
+	}
+	for _, dir := range cfg.AllowedDirs {
+		if dir != "" {
+			receiver.allowedDirs = append(receiver.allowedDirs, filepath.Clean(dir))
+		}
+	}
+	peerTLS, err := peerTLSConfig(tlsCfg)
+	if err != nil {
+		log.Printf("Warning: fetching chunks from peers disabled: %v", err)
+	} else {
+		receiver.peerTLS = peerTLS
+	}
+	return receiver
+}
+
+// peerClient 获取访问对等主机分片服务的客户端，要求对方证书名称为该主机ID
+func (r *FileReceiver) peerClient(hostID string) (*http.Client, error) {
+	if r.peerTLS == nil {
+		return nil, fmt.Errorf("peer distribution requires server.tls to be enabled")
+	}
+
+	r.peerClientsMutex.Lock()
+	defer r.peerClientsMutex.Unlock()
+	if client, exists := r.peerClients[hostID]; exists {
+		return client, nil
+	}
+	tlsConfig := r.peerTLS.Clone()
+	tlsConfig.ServerName = hostID
+	client := &http.Client{
+		Timeout:   r.peerTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	r.peerClients[hostID] = client
+	return client, nil
+}
+
+// closePeerConnections 关闭访问对等主机的空闲连接
+func (r *FileReceiver) closePeerConnections() {
+	r.peerClientsMutex.Lock()
+	defer r.peerClientsMutex.Unlock()
+	for _, client := range r.peerClients {
+		client.CloseIdleConnections()
+	}
+}
+
+// SetFileReceiver 设置全局文件接收器
+func SetFileReceiver(receiver *FileReceiver) {
+	fileReceiverMutex.Lock()
+	defer fileReceiverMutex.Unlock()
+	fileReceiver = receiver
+}
+
+// GetFileReceiver 获取全局文件接收器
+func GetFileReceiver() *FileReceiver {
+	fileReceiverMutex.RLock()
+	defer fileReceiverMutex.RUnlock()
+	return fileReceiver
+}
+
+// HandleFile 接收文件分发命令的文件内容并返回执行结果，实现 grpc.FileHandler
+func HandleFile(content *protobuf.CommandContent, stream *grpc.FileStream) *protobuf.CommandResult {
+	return GetFileReceiver().Receive(content, stream)
+}
+
+// Receive 接收文件，成功时退出码为 0，失败时目标路径保持不变
+func (r *FileReceiver) Receive(content *protobuf.CommandContent, stream *grpc.FileStream) *protobuf.CommandResult {
+	spec := content.File
+	log.Printf("Receiving file %s for command %s: %d bytes to %s", spec.Name, content.CommandId, spec.Size, spec.DestPath)
+
+	startedAt := timestamppb.Now()
+	var summary string
+	dest, err := r.checkDestination(spec.DestPath)
+	if err == nil {
+		err = GetRunAsPolicy().CheckOwner(spec.Owner, spec.Group, content.RequestedBy)
+	}
+	if err == nil {
+		summary, err = r.receiveTo(content, stream, dest)
+	}
+	finishedAt := timestamppb.Now()
+
+	if err != nil {
+		log.Printf("File transfer %s failed: %v", content.CommandId, err)
+		errorMessage := err.Error()
+		var violation *PolicyViolation
+		if errors.As(err, &violation) {
+			errorMessage = violation.Encode()
+		}
+		return &protobuf.CommandResult{
+			CommandId:    content.CommandId,
+			HostId:       content.HostId,
+			Stderr:       err.Error(),
+			ExitCode:     -1,
+			StartedAt:    startedAt,
+			FinishedAt:   finishedAt,
+			ErrorMessage: errorMessage,
+		}
+	}
+
+	log.Printf("File transfer %s completed: %s", content.CommandId, spec.DestPath)
+	return &protobuf.CommandResult{
+		CommandId:  content.CommandId,
+		HostId:     content.HostId,
+		Stdout:     fmt.Sprintf("wrote %d bytes to %s (sha256 %s)\n", spec.Size, spec.DestPath, spec.Sha256) + summary,
+		ExitCode:   0,
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+	}
+}
+
+// receiveTo 接收文件内容写入 dest，不检查 dest 是否位于允许的目录内；按分片请求时返回分片来源统计
+func (r *FileReceiver) receiveTo(content *protobuf.CommandContent, stream *grpc.FileStream, dest string) (string, error) {
+	spec := content.File
+	if len(spec.ChunkSha256) > 0 {
+		var summary string
+		err := r.receive(spec, dest, func(tmp *os.File, digest hash.Hash) error {
+			var fetchErr error
+			summary, fetchErr = r.fetchChunks(content, tmp, digest, stream)
+			return fetchErr
+		})
+		return summary, err
+	}
+	return "", r.receive(spec, dest, func(tmp *os.File, digest hash.Hash) error {
+		return r.copyChunks(tmp, digest, spec.Size, stream.Chunks)
+	})
+}
+
+// receive 由 fill 写入临时文件并计算摘要，校验后重命名到目标路径
+func (r *FileReceiver) receive(spec *protobuf.FileSpec, dest string, fill func(tmp *os.File, digest hash.Hash) error) error {
+	mode, err := utils.ParseFileMode(spec.Mode, defaultDistributedFileMode)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(dest)
+	if err := utils.EnsureDir(dir); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	// 目标目录在检查之后被替换为符号链接时拒绝写入
+	if resolved, err := filepath.EvalSymlinks(dir); err != nil || resolved != dir {
+		return fmt.Errorf("destination directory %s changed during transfer", dir)
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(dest)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	digest := sha256.New()
+	if err := fill(tmp, digest); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(digest.Sum(nil)); actual != spec.Sha256 {
+		return fmt.Errorf("sha256 mismatch: expected %s, got %s", spec.Sha256, actual)
+	}
+
+	// 先修改属主再设置权限，修改属主会清除 setuid/setgid 位
+	if err := utils.ChownFile(tmp.Name(), spec.Owner, spec.Group); err != nil {
+		return fmt.Errorf("failed to change owner: %w", err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return fmt.Errorf("failed to change mode: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+	renamed = true
+	utils.SyncDir(dir)
+	return nil
+}
+
+// copyChunks 按偏移顺序写入分片并计算摘要，直到收到最后一个分片
+func (r *FileReceiver) copyChunks(file *os.File, digest hash.Hash, size int64, chunks <-chan *protobuf.FileChunk) error {
+	idle := time.NewTimer(r.idleTimeout)
+	defer idle.Stop()
+
+	var offset int64
+	for {
+		select {
+		case <-idle.C:
+			return fmt.Errorf("no file data received for %v", r.idleTimeout)
+		case chunk, ok := <-chunks:
+			if !ok {
+				return fmt.Errorf("file transfer interrupted at offset %d", offset)
+			}
+			if chunk.Error != "" {
+				return fmt.Errorf("server aborted file transfer: %s", chunk.Error)
+			}
+			if chunk.Offset != offset {
+				return fmt.Errorf("unexpected chunk offset %d, expected %d", chunk.Offset, offset)
+			}
+			if offset+int64(len(chunk.Data)) > size {
+				return fmt.Errorf("file data exceeds expected size %d", size)
+			}
+			if _, err := file.Write(chunk.Data); err != nil {
+				return fmt.Errorf("failed to write file: %w", err)
+			}
+			digest.Write(chunk.Data)
+			offset += int64(len(chunk.Data))
+
+			if chunk.Last {
+				if offset != size {
+					return fmt.Errorf("size mismatch: expected %d bytes, got %d", size, offset)
+				}
+				return nil
+			}
+			idle.Reset(r.idleTimeout)
+		}
+	}
+}
+
+// fetchChunks 按分片清单逐个获取分片写入临时文件，全部写入后计算整个文件的摘要，返回分片来源统计
+// 起始分片按主机ID错开，同时接收的 Agent 先取得不同的分片，随后可以互相提供；从对等 Agent 获取的速度不超过 bandwidth_limit
+func (r *FileReceiver) fetchChunks(content *protobuf.CommandContent, file *os.File, digest hash.Hash, stream *grpc.FileStream) (string, error) {
+	spec := content.File
+	count := int64(len(spec.ChunkSha256))
+	if spec.ChunkSize <= 0 || count != max(1, (spec.Size+spec.ChunkSize-1)/spec.ChunkSize) {
+		return "", fmt.Errorf("invalid chunk manifest: %d chunks of %d bytes for %d bytes", count, spec.ChunkSize, spec.Size)
+	}
+
+	cache := GetChunkCache()
+	limiter := ratelimit.New(spec.BandwidthLimit)
+	hasher := fnv.New32a()
+	hasher.Write([]byte(content.HostId))
+	start := int64(hasher.Sum32()) % count
+
+	var cached, fromServer, fromPeers int
+	// 已校验并放入缓存、尚未上报的分片，随下一次分片请求上报，Server 此后才会指派其他 Agent 从本机获取
+	var stored []int64
+	for i := int64(0); i < count; i++ {
+		index := (start + i) % count
+		offset := index * spec.ChunkSize
+		size := min(spec.ChunkSize, spec.Size-offset)
+		chunkDigest := spec.ChunkSha256[index]
+
+		data, ok := cache.Get(chunkDigest)
+		if ok && int64(len(data)) == size {
+			cached++
+			stored = append(stored, index)
+		} else {
+			var peer string
+			var err error
+			data, peer, err = r.requestChunk(content.CommandId, index, offset, size, chunkDigest, stored, stream, limiter)
+			if err != nil {
+				return "", err
+			}
+			stored = nil
+			if peer != "" {
+				fromPeers++
+			} else {
+				fromServer++
+			}
+			if cache.Put(chunkDigest, data) {
+				stored = append(stored, index)
+			}
+		}
+		if _, err := file.WriteAt(data, offset); err != nil {
+			return "", fmt.Errorf("failed to write file: %w", err)
+		}
+	}
+
+	if len(stored) > 0 {
+		if err := stream.Request(&protobuf.FileChunkRequest{StoredChunks: stored, ReportOnly: true}); err != nil {
+			log.Printf("Failed to report cached chunks of command %s: %v", content.CommandId, err)
+		}
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	if _, err := io.Copy(digest, file); err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	return fmt.Sprintf("chunks: %d total, %d cached, %d from server, %d from peers\n", count, cached, fromServer, fromPeers), nil
+}
+
+// requestChunk 向 Server 请求分片，Server 指派对等 Agent 时从其获取，失败或摘要不符时在下次请求中上报，直到 Server 直接发送分片
+// 命令流断开时每隔 resumeRetryInterval 重新请求，超过 resumeTimeout 仍未恢复时失败；返回提供分片的对等主机ID，由 Server 发送时为空
+// stored 为已校验并缓存的分片序号，随请求上报
+func (r *FileReceiver) requestChunk(commandID string, index, offset, size int64, chunkDigest string, stored []int64, stream *grpc.FileStream, limiter *ratelimit.Limiter) ([]byte, string, error) {
+	var failed, corrupt []string
+	var interruptedAt time.Time
+	for {
+		req := &protobuf.FileChunkRequest{Index: index, FailedPeers: failed, CorruptPeers: corrupt, StoredChunks: stored}
+		err := stream.Request(req)
+		var chunk *protobuf.FileChunk
+		if err == nil {
+			chunk, err = r.nextChunk(stream, offset)
+		} else {
+			err = fmt.Errorf("%w: %v", errTransferInterrupted, err)
+		}
+		if errors.Is(err, errTransferInterrupted) {
+			if interruptedAt.IsZero() {
+				interruptedAt = time.Now()
+				log.Printf("File transfer %s interrupted at chunk %d, waiting for the command stream to resume", commandID, index)
+			}
+			if time.Since(interruptedAt) > r.resumeTimeout {
+				return nil, "", fmt.Errorf("file transfer not resumed within %v at chunk %d: %w", r.resumeTimeout, index, err)
+			}
+			time.Sleep(resumeRetryInterval)
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if !interruptedAt.IsZero() {
+			log.Printf("File transfer %s resumed at chunk %d", commandID, index)
+			interruptedAt = time.Time{}
+		}
+
+		if chunk.Peer != nil {
+			limiter.Wait(context.Background(), int(size))
+			client, err := r.peerClient(chunk.Peer.HostId)
+			var data []byte
+			if err == nil {
+				data, err = fetchPeerChunk(client, chunk.Peer.Address, chunkDigest, size)
+			}
+			if err == nil {
+				return data, chunk.Peer.HostId, nil
+			}
+			log.Printf("Failed to fetch chunk %d of command %s from peer %s: %v", index, commandID, chunk.Peer.HostId, err)
+			if errors.Is(err, errChunkCorrupt) {
+				corrupt = append(corrupt, chunk.Peer.HostId)
+			} else {
+				failed = append(failed, chunk.Peer.HostId)
+			}
+			continue
+		}
+
+		if int64(len(chunk.Data)) != size || chunkSHA256(chunk.Data) != chunkDigest {
+			return nil, "", fmt.Errorf("chunk %d sha256 mismatch", index)
+		}
+		return chunk.Data, "", nil
+	}
+}
+
+// nextChunk 等待 Server 对分片请求的回复，丢弃命令流断开前请求的其他分片的迟到回复
+func (r *FileReceiver) nextChunk(stream *grpc.FileStream, offset int64) (*protobuf.FileChunk, error) {
+	idle := time.NewTimer(r.idleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case <-idle.C:
+			return nil, fmt.Errorf("no file chunk received for %v", r.idleTimeout)
+		case <-stream.Interrupted:
+			return nil, errTransferInterrupted
+		case chunk, ok := <-stream.Chunks:
+			if !ok {
+				return nil, fmt.Errorf("file transfer interrupted at offset %d", offset)
+			}
+			if chunk.Error != "" {
+				return nil, fmt.Errorf("server aborted file transfer: %s", chunk.Error)
+			}
+			if chunk.Offset != offset {
+				log.Printf("Dropping stale file chunk at offset %d, expected %d", chunk.Offset, offset)
+				continue
+			}
+			return chunk, nil
+		}
+	}
+}
+
+// checkDestination 检查目标路径为绝对路径、不是目录，且位于允许的目录内；未配置允许的目录时拒绝所有路径
+// 目标路径和允许的目录均解析符号链接后比较，返回解析后的目标路径，避免经由符号链接写到允许的目录之外
+func (r *FileReceiver) checkDestination(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("destination must be an absolute path: %s", path)
+	}
+	if len(r.allowedDirs) == 0 {
+		return "", fmt.Errorf("file distribution is disabled: files.allowed_dirs is not configured")
+	}
+	dest, err := utils.ResolvePath(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve destination %s: %w", path, err)
+	}
+	if info, err := os.Stat(dest); err == nil && info.IsDir() {
+		return "", fmt.Errorf("destination is a directory: %s", path)
+	}
+	for _, dir := range r.allowedDirs {
+		allowed, err := utils.ResolvePath(dir)
+		if err != nil {
+			continue
+		}
+		if utils.PathWithin(allowed, dest) {
+			return dest, nil
+		}
+	}
+	return "", fmt.Errorf("destination %s is not in an allowed directory", path)
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devops-manager/agent/pkg/config"
+)
+
+func TestCheckDestination(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := filepath.Join(root, "allowed")
+	outside := filepath.Join(root, "outside")
+	for _, dir := range []string{filepath.Join(allowed, "sub"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "passwd"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	symlink := func(target, name string) {
+		if err := os.Symlink(target, name); err != nil {
+			t.Skipf("symbolic links unavailable: %v", err)
+		}
+	}
+	// 允许的目录内指向外部的目录和文件链接、内部互相指向的链接以及悬空链接
+	symlink(outside, filepath.Join(allowed, "escape"))
+	symlink(filepath.Join(outside, "passwd"), filepath.Join(allowed, "passwd"))
+	symlink(filepath.Join(allowed, "sub"), filepath.Join(allowed, "alias"))
+	symlink(filepath.Join(root, "missing"), filepath.Join(allowed, "dangling"))
+	// 允许的目录本身经由符号链接配置
+	symlink(allowed, filepath.Join(root, "link-to-allowed"))
+
+	receiver := NewFileReceiver(config.FilesConfig{AllowedDirs: []string{allowed}}, config.PeerConfig{}, config.TLSConfig{})
+	viaLink := NewFileReceiver(config.FilesConfig{AllowedDirs: []string{filepath.Join(root, "link-to-allowed")}}, config.PeerConfig{}, config.TLSConfig{})
+	disabled := NewFileReceiver(config.FilesConfig{}, config.PeerConfig{}, config.TLSConfig{})
+
+	tests := []struct {
+		name     string
+		receiver *FileReceiver
+		path     string
+		want     string
+		wantErr  string
+	}{
+		{name: "file in allowed dir", receiver: receiver, path: filepath.Join(allowed, "app.conf"), want: filepath.Join(allowed, "app.conf")},
+		{name: "new nested dir", receiver: receiver, path: filepath.Join(allowed, "new", "dir", "app.conf"), want: filepath.Join(allowed, "new", "dir", "app.conf")},
+		{name: "symlink within allowed dir", receiver: receiver, path: filepath.Join(allowed, "alias", "app.conf"), want: filepath.Join(allowed, "sub", "app.conf")},
+		{name: "allowed dir configured via symlink", receiver: viaLink, path: filepath.Join(allowed, "app.conf"), want: filepath.Join(allowed, "app.conf")},
+		{name: "relative path", receiver: receiver, path: "allowed/app.conf", wantErr: "absolute path"},
+		{name: "distribution disabled", receiver: disabled, path: filepath.Join(allowed, "app.conf"), wantErr: "files.allowed_dirs is not configured"},
+		{name: "outside allowed dir", receiver: receiver, path: filepath.Join(outside, "app.conf"), wantErr: "not in an allowed directory"},
+		{name: "allowed dir itself", receiver: receiver, path: allowed, wantErr: "is a directory"},
+		{name: "existing directory", receiver: receiver, path: filepath.Join(allowed, "sub"), wantErr: "is a directory"},
+		{name: "parent traversal", receiver: receiver, path: allowed + "/../outside/app.conf", wantErr: "not in an allowed directory"},
+		{name: "prefix of allowed dir", receiver: receiver, path: allowed + "-other/app.conf", wantErr: "not in an allowed directory"},
+		{name: "symlinked parent escapes", receiver: receiver, path: filepath.Join(allowed, "escape", "app.conf"), wantErr: "not in an allowed directory"},
+		{name: "symlinked parent escapes into new dir", receiver: receiver, path: filepath.Join(allowed, "escape", "new", "app.conf"), wantErr: "not in an allowed directory"},
+		{name: "symlinked file escapes", receiver: receiver, path: filepath.Join(allowed, "passwd"), wantErr: "not in an allowed directory"},
+		{name: "dangling symlink", receiver: receiver, path: filepath.Join(allowed, "dangling", "app.conf"), wantErr: "dangling symbolic link"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.receiver.checkDestination(tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("checkDestination(%s) = %q, %v, want error %q", tt.path, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("checkDestination(%s) failed: %v", tt.path, err)
+			}
+			if got != tt.want {
+				t.Errorf("checkDestination(%s) = %s, want %s", tt.path, got, tt.want)
+			}
+		})
+	}
+}
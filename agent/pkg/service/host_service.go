@@ -13,11 +13,18 @@ import (
 	"devops-manager/agent/pkg/grpc"
 	"devops-manager/agent/pkg/utils"
 	"devops-manager/api/protobuf"
+
+	"google.golang.org/protobuf/proto"
 )
 
+// agentCapabilities Agent 在握手时声明的能力
+var agentCapabilities = []string{"command", "output_stream", "control", "delivery_ack", "file_push", "file_fetch", "file_peer", "file_resume"}
+
 type HostAgent struct {
 	config       *config.Config
+	version      string
 	grpcAgent    *grpc.Agent
+	taskService  *TaskService
 	hostInfo     *protobuf.HostInfo
 	ctx          context.Context
 	cancel       context.CancelFunc
@@ -25,17 +32,20 @@ type HostAgent struct {
 	startTime    time.Time
 	isRegistered bool
 	lastRegister time.Time
+	peerServer   *PeerServer
 }
 
-func NewHostAgent(cfg *config.Config) *HostAgent {
+func NewHostAgent(cfg *config.Config, version string) *HostAgent {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	hostInfo := &protobuf.HostInfo{
-		Id:       generateAgentID(cfg.Agent.AgentID),
+		Id:       resolveAgentID(cfg),
 		Hostname: utils.GetHostname(),
 		Ip:       utils.GetLocalIP(),
 		Os:       runtime.GOOS,
 		Tags:     make(map[string]string),
+
+		AgentVersion: version,
 	}
 
 	// 复制配置中的标签
@@ -43,15 +53,43 @@ func NewHostAgent(cfg *config.Config) *HostAgent {
 		hostInfo.Tags[k] = v
 	}
 
-	grpcAgent := grpc.NewAgent(cfg.Server.Address, cfg.Server.Timeout, cfg.Server.RetryInterval)
+	grpcAgent := grpc.NewAgent(cfg.Server.Address, cfg.Server.Timeout, cfg.Server.RetryInterval, newTLSFiles(cfg))
+	grpcAgent.SetSendQueue(cfg.Server.SendQueueSize, cfg.Server.SendTimeout)
+
+	// 发件箱不可用时结果只发送一次，命令流断开期间完成的结果会丢失
+	outbox, err := grpc.NewResultOutbox(cfg.Outbox.Dir, cfg.Outbox.MaxBytes)
+	if err != nil {
+		log.Printf("Warning: result outbox disabled: %v", err)
+	} else {
+		grpcAgent.SetOutbox(outbox)
+	}
+
+	// 投递记录不可用时只在命令执行期间去重，Agent 重启后重复投递的命令可能再次执行
+	deliveries, err := grpc.NewDeliveryLog(cfg.Delivery.DedupeFile, cfg.Delivery.DedupeWindow)
+	if err != nil {
+		log.Printf("Warning: delivery dedupe log disabled: %v", err)
+	} else {
+		grpcAgent.SetDeliveryLog(deliveries)
+	}
+	grpcAgent.SetFileHandler(HandleFile)
+	grpcAgent.SetFetchHandler(HandleFetch)
+	grpcAgent.SetPeerGrantHandler(HandlePeerGrant)
+	grpcAgent.SetRevocationHandler(HandleRevocationList)
+	if upgrader := GetAgentUpgrader(); upgrader != nil {
+		grpcAgent.SetUpgradeHandler(HandleUpgrade)
+		// 升级后的新版本连上 Server 即确认升级
+		grpcAgent.SetConnectedHandler(upgrader.Confirm)
+	}
 
 	return &HostAgent{
-		config:    cfg,
-		grpcAgent: grpcAgent,
-		hostInfo:  hostInfo,
-		ctx:       ctx,
-		cancel:    cancel,
-		startTime: time.Now(),
+		config:      cfg,
+		version:     version,
+		grpcAgent:   grpcAgent,
+		taskService: NewTaskService(),
+		hostInfo:    hostInfo,
+		ctx:         ctx,
+		cancel:      cancel,
+		startTime:   time.Now(),
 	}
 }
 
@@ -66,6 +104,30 @@ func (ha *HostAgent) Start() error {
 	// 启动状态上报器
 	go ha.statusReporter()
 
+	// 主动连接 Server 的命令流，接收并执行下发的命令
+	capabilities := append([]string(nil), agentCapabilities...)
+	if GetAgentUpgrader() != nil {
+		capabilities = append(capabilities, "agent_upgrade")
+	}
+	hello := &protobuf.AgentHello{
+		HostId:       ha.hostInfo.Id,
+		AgentVersion: ha.version,
+		Capabilities: capabilities,
+		AuthToken:    ha.config.Server.AuthToken,
+	}
+
+	// 分片服务启动失败时仍可接收对等分发，只是不向其他 Agent 提供分片
+	if cache := GetChunkCache(); ha.config.Peer.Listen != "" && cache != nil {
+		peerServer, err := StartPeerServer(ha.config.Peer.Listen, cache, ha.config.Server.TLS)
+		if err != nil {
+			log.Printf("Warning: peer chunk server disabled: %v", err)
+		} else {
+			ha.peerServer = peerServer
+			hello.PeerAddress = peerServer.AdvertiseAddr(ha.config.Peer)
+		}
+	}
+	go ha.grpcAgent.RunCommandStream(hello, ha.config.Agent.HeartbeatInterval, ha.taskService.HandleCommand, ha.taskService.HandleControl)
+
 	return nil
 }
 
@@ -73,29 +135,40 @@ func (ha *HostAgent) Stop() {
 	log.Println("Stopping host agent...")
 	ha.cancel()
 	ha.grpcAgent.Stop()
+	if ha.peerServer != nil {
+		ha.peerServer.Stop()
+	}
 }
 
 func (ha *HostAgent) Wait() {
 	<-ha.ctx.Done()
 }
 
+// completedTaskCleanupInterval 清理已结束任务执行记录的间隔
+const completedTaskCleanupInterval = 10 * time.Minute
+
 func (ha *HostAgent) statusReporter() {
 	// 首次连接时尝试注册
 	registerTicker := time.NewTicker(30 * time.Second) // 每30秒检查一次注册状态
 	reportTicker := time.NewTicker(ha.config.Agent.ReportInterval)
+	cleanupTicker := time.NewTicker(completedTaskCleanupInterval)
 	defer registerTicker.Stop()
 	defer reportTicker.Stop()
+	defer cleanupTicker.Stop()
 
 	for {
 		select {
 		case <-ha.ctx.Done():
 			return
 		case <-registerTicker.C:
-			if ha.grpcAgent.IsConnected() && !ha.isRegistered {
+			if ha.grpcAgent.IsConnected() && (!ha.isRegistered || ha.needCertificate()) {
 				if err := ha.tryRegister(); err != nil {
 					log.Printf("Failed to register: %v", err)
 				}
 			}
+		case <-cleanupTicker.C:
+			// 执行结束后未能及时清理的记录（如等待超时后进程才退出）
+			ha.taskService.CleanupCompletedTasks(completedTaskCleanupInterval)
 		case <-reportTicker.C:
 			if ha.grpcAgent.IsConnected() && ha.isRegistered {
 				if err := ha.reportStatus(); err != nil {
@@ -118,6 +191,8 @@ func (ha *HostAgent) tryRegister() error {
 	ha.mutex.RLock()
 	needRegister := !ha.isRegistered || time.Since(ha.lastRegister) > time.Hour
 	ha.mutex.RUnlock()
+	needCertificate := ha.needCertificate()
+	needRegister = needRegister || needCertificate
 
 	if !needRegister {
 		return nil
@@ -126,7 +201,17 @@ func (ha *HostAgent) tryRegister() error {
 	// 更新主机信息
 	ha.updateHostInfo()
 
-	response, err := ha.grpcAgent.Register(ha.ctx, ha.hostInfo)
+	hostInfo := ha.hostInfo
+	if needCertificate {
+		csr, err := grpc.CreateCSR(ha.config.Server.TLS.KeyFile, ha.hostInfo.Id)
+		if err != nil {
+			return err
+		}
+		hostInfo = proto.Clone(ha.hostInfo).(*protobuf.HostInfo)
+		hostInfo.Csr = csr
+	}
+
+	response, err := ha.grpcAgent.Register(ha.ctx, hostInfo)
 	if err != nil {
 		return err
 	}
@@ -135,6 +220,18 @@ func (ha *HostAgent) tryRegister() error {
 		return fmt.Errorf("registration failed: %s", response.ErrorMessage)
 	}
 
+	if needCertificate {
+		if response.Certificate == "" {
+			log.Printf("Host %s is waiting for approval to receive its certificate", ha.hostInfo.Id)
+			return nil
+		}
+		if err := grpc.SaveCertificate(ha.config.Server.TLS.CertFile, response.Certificate); err != nil {
+			return err
+		}
+		log.Printf("Certificate issued by server saved to %s, reconnecting", ha.config.Server.TLS.CertFile)
+		ha.grpcAgent.Reconnect()
+	}
+
 	ha.mutex.Lock()
 	ha.isRegistered = true
 	ha.lastRegister = time.Now()
@@ -144,6 +241,12 @@ func (ha *HostAgent) tryRegister() error {
 	return nil
 }
 
+// needCertificate 启用入网时，证书不存在或即将过期则需要携带 CSR 注册
+func (ha *HostAgent) needCertificate() bool {
+	tlsCfg := ha.config.Server.TLS
+	return tlsCfg.Enabled && tlsCfg.Enroll && grpc.CertificateExpiresWithin(tlsCfg.CertFile, tlsCfg.RenewBefore)
+}
+
 func (ha *HostAgent) reportStatus() error {
 	// 获取系统状态信息
 	status := utils.GetSystemStatus()
@@ -154,6 +257,20 @@ func (ha *HostAgent) reportStatus() error {
 		status.CustomTags[k] = v
 	}
 
+	// 上报执行队列负载，供服务端调度参考
+	stats := GetExecutionQueue().Stats()
+	status.RunningCommands = uint32(stats.Running)
+	status.QueuedCommands = uint32(stats.Queued)
+	status.MaxConcurrentCommands = uint32(stats.MaxConcurrent)
+	status.CommandQueueCapacity = uint32(stats.Capacity)
+
+	// 上报命令流发送队列状态，便于在服务端排查发送积压
+	if sendStats, ok := ha.grpcAgent.SendStats(); ok {
+		status.CustomTags["stream_send_queued"] = fmt.Sprintf("%d/%d", sendStats.Queued, sendStats.Capacity)
+		status.CustomTags["stream_send_dropped"] = fmt.Sprintf("%d", sendStats.Dropped)
+		status.CustomTags["stream_send_max_latency"] = sendStats.MaxLatency.String()
+	}
+
 	response, err := ha.grpcAgent.ReportStatus(ha.ctx, status)
 	if err != nil {
 		return err
@@ -183,6 +300,43 @@ func (ha *HostAgent) updateHostInfo() {
 	ha.hostInfo.Tags["cpu_count"] = fmt.Sprintf("%d", runtime.NumCPU())
 }
 
+// newTLSFiles 根据配置生成 gRPC 客户端的 TLS 证书文件，未启用时返回 nil
+func newTLSFiles(cfg *config.Config) *grpc.TLSFiles {
+	if !cfg.Server.TLS.Enabled {
+		return nil
+	}
+	return &grpc.TLSFiles{
+		CertFile:   cfg.Server.TLS.CertFile,
+		KeyFile:    cfg.Server.TLS.KeyFile,
+		CAFile:     cfg.Server.TLS.CAFile,
+		ServerName: cfg.Server.TLS.ServerName,
+		Enroll:     cfg.Server.TLS.Enroll,
+	}
+}
+
+// resolveAgentID 确定主机ID：启用 mTLS 时 Server 要求主机ID与证书 CN 一致，
+// 未配置 agent_id 则直接使用证书 CN
+func resolveAgentID(cfg *config.Config) string {
+	if !cfg.Server.TLS.Enabled {
+		return generateAgentID(cfg.Agent.AgentID)
+	}
+
+	cn, err := grpc.CertificateCommonName(cfg.Server.TLS.CertFile)
+	if err != nil {
+		// 入网模式下证书尚未签发，使用配置的主机ID申请证书
+		if !cfg.Server.TLS.Enroll {
+			log.Printf("Failed to read agent certificate CN: %v", err)
+		}
+		return generateAgentID(cfg.Agent.AgentID)
+	}
+
+	if cfg.Agent.AgentID != "" && cfg.Agent.AgentID != cn {
+		log.Printf("Warning: agent_id %s does not match certificate CN %s, server will reject it", cfg.Agent.AgentID, cn)
+		return cfg.Agent.AgentID
+	}
+	return cn
+}
+
 func generateAgentID(configID string) string {
 	if configID != "" {
 		return configID
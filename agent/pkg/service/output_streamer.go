@@ -0,0 +1,130 @@
+package service
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"devops-manager/agent/pkg/grpc"
+	"devops-manager/agent/pkg/utils"
+	"devops-manager/api/protobuf"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// outputChunkSize 单个输出分片的最大字节数，缓冲达到该大小时立即发送
+	outputChunkSize = 32 * 1024
+	// outputFlushInterval 缓冲输出的最长发送间隔
+	outputFlushInterval = 500 * time.Millisecond
+)
+
+// outputStreamer 将命令输出合并为带序号的分片，按大小或时间间隔发送
+type outputStreamer struct {
+	commandID string
+	sink      grpc.OutputSink
+
+	mutex    sync.Mutex
+	sequence uint64
+	stdout   bytes.Buffer
+	stderr   bytes.Buffer
+	closed   bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newOutputStreamer 创建输出分片发送器并启动定时发送
+func newOutputStreamer(commandID string, sink grpc.OutputSink) *outputStreamer {
+	s := &outputStreamer{
+		commandID: commandID,
+		sink:      sink,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go s.flushLoop()
+	return s
+}
+
+// Write 实现 utils.OutputHandler，缓存命令输出
+func (s *outputStreamer) Write(stream string, data []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// 命令超时返回后进程可能仍有少量输出，此时丢弃
+	if s.closed {
+		return
+	}
+
+	buffer := s.buffer(stream)
+	buffer.Write(data)
+	if buffer.Len() >= outputChunkSize {
+		s.flushLocked(stream)
+	}
+}
+
+// Close 停止定时发送并发送剩余输出，应在回传执行结果之前调用
+func (s *outputStreamer) Close() {
+	close(s.stop)
+	<-s.done
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.flushLocked(utils.OutputStdout)
+	s.flushLocked(utils.OutputStderr)
+	s.closed = true
+}
+
+// flushLoop 定时发送缓冲中的输出
+func (s *outputStreamer) flushLoop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(outputFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mutex.Lock()
+			s.flushLocked(utils.OutputStdout)
+			s.flushLocked(utils.OutputStderr)
+			s.mutex.Unlock()
+		}
+	}
+}
+
+// flushLocked 将指定输出流的缓冲按分片大小发送，调用方需持有锁
+func (s *outputStreamer) flushLocked(stream string) {
+	buffer := s.buffer(stream)
+	for buffer.Len() > 0 {
+		data := make([]byte, min(buffer.Len(), outputChunkSize))
+		buffer.Read(data)
+
+		s.sequence++
+		s.sink(&protobuf.CommandOutputChunk{
+			CommandId: s.commandID,
+			Sequence:  s.sequence,
+			Stream:    toProtobufStream(stream),
+			Data:      data,
+			Timestamp: timestamppb.Now(),
+		})
+	}
+}
+
+// buffer 获取输出流对应的缓冲
+func (s *outputStreamer) buffer(stream string) *bytes.Buffer {
+	if stream == utils.OutputStderr {
+		return &s.stderr
+	}
+	return &s.stdout
+}
+
+// toProtobufStream 转换输出流类型
+func toProtobufStream(stream string) protobuf.OutputStream {
+	if stream == utils.OutputStderr {
+		return protobuf.OutputStream_OUTPUT_STREAM_STDERR
+	}
+	return protobuf.OutputStream_OUTPUT_STREAM_STDOUT
+}
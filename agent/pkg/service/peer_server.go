@@ -0,0 +1,449 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"devops-manager/agent/pkg/config"
+	"devops-manager/agent/pkg/utils"
+	"devops-manager/api/protobuf"
+)
+
+// peerChunkPath 分片服务的请求路径前缀，其后为分片的 SHA-256
+const peerChunkPath = "/chunks/"
+
+// errChunkCorrupt 对等 Agent 提供的分片与摘要不符
+var errChunkCorrupt = errors.New("chunk sha256 mismatch")
+
+// peerGrantTTL Server 授权其他主机获取分片后授权的有效期
+const peerGrantTTL = 5 * time.Minute
+
+// peerGrantWait 分片请求先于授权到达时等待授权的最长时间
+const peerGrantWait = 3 * time.Second
+
+// ChunkCache 已接收文件分片的本地缓存，按分片 SHA-256 保存在 <dir>/<摘要前两位>/<摘要>
+type ChunkCache struct {
+	dir      string
+	maxBytes int64
+	size     int64
+	mutex    sync.Mutex
+}
+
+var (
+	chunkCache      *ChunkCache
+	chunkCacheMutex sync.RWMutex
+)
+
+// NewChunkCache 创建分片缓存，统计已有分片的占用
+func NewChunkCache(dir string, maxBytes int64) (*ChunkCache, error) {
+	if err := utils.EnsureDir(dir); err != nil {
+		return nil, fmt.Errorf("failed to create chunk cache directory: %w", err)
+	}
+	cache := &ChunkCache{dir: dir, maxBytes: maxBytes}
+	err := filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				cache.size += info.Size()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan chunk cache: %w", err)
+	}
+	return cache, nil
+}
+
+// SetChunkCache 设置全局分片缓存
+func SetChunkCache(cache *ChunkCache) {
+	chunkCacheMutex.Lock()
+	defer chunkCacheMutex.Unlock()
+	chunkCache = cache
+}
+
+// GetChunkCache 获取全局分片缓存，未设置时返回 nil
+func GetChunkCache() *ChunkCache {
+	chunkCacheMutex.RLock()
+	defer chunkCacheMutex.RUnlock()
+	return chunkCache
+}
+
+// Get 读取分片并校验摘要，摘要不符的分片被删除
+func (c *ChunkCache) Get(digest string) ([]byte, bool) {
+	if c == nil || !isChunkDigest(digest) {
+		return nil, false
+	}
+	path := c.path(digest)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	if chunkSHA256(data) != digest {
+		log.Printf("Warning: Removing corrupted cached chunk %s", digest)
+		c.remove(path, int64(len(data)))
+		return nil, false
+	}
+	// 修改时间用于淘汰最久未使用的分片
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data, true
+}
+
+// Put 保存已校验的分片，超过占用上限时淘汰最久未使用的分片，返回分片是否已放入缓存
+func (c *ChunkCache) Put(digest string, data []byte) bool {
+	if c == nil || !isChunkDigest(digest) || int64(len(data)) > c.maxBytes {
+		return false
+	}
+	path := c.path(digest)
+	if _, err := os.Stat(path); err == nil {
+		return true
+	}
+	if err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+		log.Printf("Failed to cache chunk %s: %v", digest, err)
+		return false
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "chunk-*.tmp")
+	if err != nil {
+		log.Printf("Failed to cache chunk %s: %v", digest, err)
+		return false
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("Failed to cache chunk %s: %v", digest, err)
+		return false
+	}
+
+	c.mutex.Lock()
+	c.size += int64(len(data))
+	evict := c.size > c.maxBytes
+	c.mutex.Unlock()
+	if evict {
+		c.evict()
+	}
+	return true
+}
+
+// evict 按修改时间从旧到新删除分片，直到占用降到上限的 90%
+func (c *ChunkCache) evict() {
+	type cachedChunk struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var chunks []cachedChunk
+	filepath.WalkDir(c.dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() || strings.HasSuffix(name, ".tmp") {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			chunks = append(chunks, cachedChunk{path: name, size: info.Size(), modTime: info.ModTime()})
+		}
+		return nil
+	})
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].modTime.Before(chunks[j].modTime) })
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var total int64
+	for _, chunk := range chunks {
+		total += chunk.size
+	}
+	c.size = total
+	target := c.maxBytes / 10 * 9
+	for _, chunk := range chunks {
+		if c.size <= target {
+			break
+		}
+		if err := os.Remove(chunk.path); err == nil {
+			c.size -= chunk.size
+		}
+	}
+}
+
+// remove 删除分片并更新占用
+func (c *ChunkCache) remove(path string, size int64) {
+	if err := os.Remove(path); err == nil {
+		c.mutex.Lock()
+		c.size -= size
+		c.mutex.Unlock()
+	}
+}
+
+// path 分片摘要对应的文件路径
+func (c *ChunkCache) path(digest string) string {
+	return filepath.Join(c.dir, digest[:2], digest)
+}
+
+// PeerGrants Server 下发的对等分发授权，按主机ID和分片摘要记录过期时间
+type PeerGrants struct {
+	grants      map[string]time.Time
+	changed     chan struct{} // 新增授权时关闭并替换，唤醒等待授权的请求
+	lastCleanup time.Time
+	mutex       sync.Mutex
+}
+
+var peerGrants = &PeerGrants{
+	grants:  make(map[string]time.Time),
+	changed: make(chan struct{}),
+}
+
+// HandlePeerGrant 记录 Server 下发的对等分发授权，实现 grpc.PeerGrantHandler
+func HandlePeerGrant(grant *protobuf.PeerGrant) {
+	peerGrants.Grant(grant.HostId, grant.ChunkSha256)
+}
+
+// Grant 授权主机在 peerGrantTTL 内获取分片
+func (g *PeerGrants) Grant(hostID, digest string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(g.lastCleanup) > peerGrantTTL {
+		for key, expires := range g.grants {
+			if now.After(expires) {
+				delete(g.grants, key)
+			}
+		}
+		g.lastCleanup = now
+	}
+	g.grants[hostID+"/"+digest] = now.Add(peerGrantTTL)
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+// Wait 检查主机是否获准获取分片，尚未授权时最多等待 timeout
+func (g *PeerGrants) Wait(hostID, digest string, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	key := hostID + "/" + digest
+	for {
+		g.mutex.Lock()
+		expires, granted := g.grants[key]
+		changed := g.changed
+		g.mutex.Unlock()
+		if granted && time.Now().Before(expires) {
+			return true
+		}
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+// PeerRevocations Server 下发的已吊销证书序列号，对等分发双方拒绝使用这些证书的连接
+type PeerRevocations struct {
+	serials map[string]struct{}
+	mutex   sync.RWMutex
+}
+
+var peerRevocations = &PeerRevocations{serials: make(map[string]struct{})}
+
+// HandleRevocationList 以 Server 下发的完整列表替换已吊销的证书，实现 grpc.RevocationHandler
+func HandleRevocationList(list *protobuf.RevocationList) {
+	peerRevocations.Replace(list.Serials)
+	// 空闲的对等连接可能由刚吊销的证书建立，关闭后重新握手校验
+	if receiver := GetFileReceiver(); receiver != nil {
+		receiver.closePeerConnections()
+	}
+}
+
+// Replace 替换已吊销的证书序列号
+func (r *PeerRevocations) Replace(serials []string) {
+	revoked := make(map[string]struct{}, len(serials))
+	for _, serial := range serials {
+		revoked[strings.ToLower(serial)] = struct{}{}
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.serials = revoked
+}
+
+// IsRevoked 检查证书是否已被吊销
+func (r *PeerRevocations) IsRevoked(cert *x509.Certificate) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	_, revoked := r.serials[cert.SerialNumber.Text(16)]
+	return revoked
+}
+
+// peerTLSConfig 对等分发的双向 TLS 配置：使用 Agent 证书，以 ca_file 校验对方证书并拒绝已吊销的证书；每次握手重新读取证书以便轮换后生效
+func peerTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, fmt.Errorf("peer distribution requires server.tls to be enabled")
+	}
+	caPEM, err := os.ReadFile(cfg.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+	}
+
+	loadCertificate := func() (*tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load agent certificate: %w", err)
+		}
+		return &cert, nil
+	}
+	return &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return loadCertificate()
+		},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return loadCertificate()
+		},
+		// 证书链校验通过后拒绝 Server 通知已吊销的证书
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) > 0 && peerRevocations.IsRevoked(state.PeerCertificates[0]) {
+				return fmt.Errorf("peer certificate %s has been revoked", state.PeerCertificates[0].SerialNumber.Text(16))
+			}
+			return nil
+		},
+		RootCAs:    pool,
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// PeerServer 向同区域的其他 Agent 提供缓存中的文件分片
+type PeerServer struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// StartPeerServer 在 listen 上启动分片服务，只提供 GET /chunks/<SHA-256>
+// 使用 Agent 证书双向 TLS 认证，请求方证书 CN 为其主机ID，只提供 Server 已授权该主机获取的分片
+func StartPeerServer(listen string, cache *ChunkCache, tlsCfg config.TLSConfig) (*PeerServer, error) {
+	tlsConfig, err := peerTLSConfig(tlsCfg)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", listen, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(peerChunkPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		digest := strings.TrimPrefix(r.URL.Path, peerChunkPath)
+		requester := r.TLS.PeerCertificates[0].Subject.CommonName
+		// 连接建立后证书才被吊销时，同一连接上的后续请求同样拒绝
+		if peerRevocations.IsRevoked(r.TLS.PeerCertificates[0]) {
+			log.Printf("Warning: Rejected chunk %s request from %s with a revoked certificate", digest, requester)
+			http.Error(w, "certificate revoked", http.StatusForbidden)
+			return
+		}
+		if !peerGrants.Wait(requester, digest, peerGrantWait) {
+			log.Printf("Warning: Rejected chunk %s request from %s without a grant", digest, requester)
+			http.Error(w, "chunk not granted", http.StatusForbidden)
+			return
+		}
+		data, ok := cache.Get(digest)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+	})
+
+	ps := &PeerServer{
+		server:   &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+		listener: tls.NewListener(listener, tlsConfig),
+	}
+	go func() {
+		if err := ps.server.Serve(ps.listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Peer chunk server stopped: %v", err)
+		}
+	}()
+	log.Printf("Peer chunk server listening on %s", listener.Addr())
+	return ps, nil
+}
+
+// AdvertiseAddr 告知 Server 的分片服务地址，未配置时使用本机 IP 和实际监听端口
+func (ps *PeerServer) AdvertiseAddr(cfg config.PeerConfig) string {
+	if cfg.AdvertiseAddr != "" {
+		return cfg.AdvertiseAddr
+	}
+	_, port, err := net.SplitHostPort(ps.listener.Addr().String())
+	if err != nil {
+		return ""
+	}
+	return net.JoinHostPort(utils.GetLocalIP(), port)
+}
+
+// Stop 停止分片服务
+func (ps *PeerServer) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ps.server.Shutdown(ctx)
+}
+
+// fetchPeerChunk 从对等 Agent 获取分片并校验大小和摘要，摘要不符时返回 errChunkCorrupt
+// client 须校验对方证书为该对等主机的证书
+func fetchPeerChunk(client *http.Client, address, digest string, size int64) ([]byte, error) {
+	resp, err := client.Get("https://" + address + peerChunkPath + digest)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer %s responded %s", address, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, size+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk from peer %s: %w", address, err)
+	}
+	if int64(len(data)) != size || chunkSHA256(data) != digest {
+		return nil, fmt.Errorf("%w: from peer %s", errChunkCorrupt, address)
+	}
+	return data, nil
+}
+
+// chunkSHA256 计算分片的 SHA-256（小写十六进制）
+func chunkSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// isChunkDigest 检查是否为小写十六进制的 SHA-256 摘要
+func isChunkDigest(digest string) bool {
+	return len(digest) == 64 && strings.Trim(digest, "0123456789abcdef") == ""
+}
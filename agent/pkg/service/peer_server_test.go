@@ -0,0 +1,333 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"devops-manager/agent/pkg/config"
+	"devops-manager/agent/pkg/grpc"
+	"devops-manager/api/protobuf"
+)
+
+// writePeerCertificates 在临时目录生成 CA 和各主机的双向认证证书，返回各主机的 TLS 配置和证书序列号
+func writePeerCertificates(t *testing.T, hosts ...string) (map[string]config.TLSConfig, map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	writePEM := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := writePEM("ca.crt", "CERTIFICATE", caDER)
+
+	configs := make(map[string]config.TLSConfig)
+	serials := make(map[string]string)
+	for i, host := range hosts {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serial := big.NewInt(int64(100 + i))
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: serial,
+			Subject:      pkix.Name{CommonName: host},
+			DNSNames:     []string{host},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		}, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		configs[host] = config.TLSConfig{
+			Enabled:  true,
+			CertFile: writePEM(host+".crt", "CERTIFICATE", der),
+			KeyFile:  writePEM(host+".key", "EC PRIVATE KEY", keyDER),
+			CAFile:   caFile,
+		}
+		serials[host] = serial.Text(16)
+	}
+	return configs, serials
+}
+
+func TestPeerServerGrantsAndRevocations(t *testing.T) {
+	configs, serials := writePeerCertificates(t, "host-a", "host-b", "host-c")
+	cache, err := NewChunkCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("chunk data")
+	digest := chunkSHA256(data)
+	if !cache.Put(digest, data) {
+		t.Fatal("chunk not cached")
+	}
+
+	server, err := StartPeerServer("127.0.0.1:0", cache, configs["host-a"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	address := server.listener.Addr().String()
+
+	savedReceiver := GetFileReceiver()
+	t.Cleanup(func() {
+		SetFileReceiver(savedReceiver)
+		peerRevocations.Replace(nil)
+	})
+	peerConfig := config.PeerConfig{FetchTimeout: 10 * time.Second}
+	receiverB := NewFileReceiver(config.FilesConfig{}, peerConfig, configs["host-b"])
+	receiverC := NewFileReceiver(config.FilesConfig{}, peerConfig, configs["host-c"])
+	SetFileReceiver(receiverB)
+	fetch := func(receiver *FileReceiver, peer string) ([]byte, error) {
+		t.Helper()
+		client, err := receiver.peerClient(peer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fetchPeerChunk(client, address, digest, int64(len(data)))
+	}
+
+	// 未获授权的主机在等待授权超时后被拒绝
+	if _, err := fetch(receiverB, "host-a"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("fetch without grant = %v, want 403", err)
+	}
+
+	peerGrants.Grant("host-b", digest)
+	got, err := fetch(receiverB, "host-a")
+	if err != nil {
+		t.Fatalf("fetch with grant failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("fetched %q, want %q", got, data)
+	}
+
+	// 授权晚于请求到达时等待授权
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		peerGrants.Grant("host-c", digest)
+	}()
+	if _, err := fetch(receiverC, "host-a"); err != nil {
+		t.Fatalf("fetch with a delayed grant failed: %v", err)
+	}
+
+	// 分片服务的证书名称须为被指派的主机ID
+	if _, err := fetch(receiverB, "host-c"); err == nil {
+		t.Fatal("fetch from a peer with another host's certificate succeeded")
+	}
+
+	// 吊销请求方的证书后，已授权的请求同样被拒绝
+	HandleRevocationList(&protobuf.RevocationList{Serials: []string{serials["host-b"]}})
+	if _, err := fetch(receiverB, "host-a"); err == nil {
+		t.Fatal("fetch with a revoked client certificate succeeded")
+	}
+	if _, err := fetch(receiverC, "host-a"); err != nil {
+		t.Fatalf("fetch by another host failed after revocation: %v", err)
+	}
+
+	// 吊销分片服务的证书后，请求方拒绝连接
+	receiverC.closePeerConnections()
+	HandleRevocationList(&protobuf.RevocationList{Serials: []string{serials["host-a"]}})
+	if _, err := fetch(receiverC, "host-a"); err == nil {
+		t.Fatal("fetch from a peer with a revoked certificate succeeded")
+	}
+}
+
+func TestFetchPeerChunkVerifiesDigest(t *testing.T) {
+	data := []byte("chunk data")
+	digest := chunkSHA256(data)
+
+	tests := []struct {
+		name        string
+		body        []byte // 为 nil 时返回 404
+		wantErr     bool
+		wantCorrupt bool
+	}{
+		{name: "valid", body: data},
+		{name: "corrupt", body: []byte("chunk DATA"), wantErr: true, wantCorrupt: true},
+		{name: "truncated", body: data[:4], wantErr: true, wantCorrupt: true},
+		{name: "oversized", body: append(append([]byte{}, data...), '!'), wantErr: true, wantCorrupt: true},
+		{name: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != peerChunkPath+digest || tt.body == nil {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write(tt.body)
+			}))
+			defer server.Close()
+
+			got, err := fetchPeerChunk(server.Client(), server.Listener.Addr().String(), digest, int64(len(data)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetchPeerChunk error = %v, want error %v", err, tt.wantErr)
+			}
+			if errors.Is(err, errChunkCorrupt) != tt.wantCorrupt {
+				t.Errorf("fetchPeerChunk error = %v, want corrupt %v", err, tt.wantCorrupt)
+			}
+			if !tt.wantErr && !bytes.Equal(got, data) {
+				t.Errorf("fetched %q, want %q", got, data)
+			}
+		})
+	}
+}
+
+func TestChunkCacheRemovesCorruptChunk(t *testing.T) {
+	cache, err := NewChunkCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("chunk data")
+	digest := chunkSHA256(data)
+	if !cache.Put(digest, data) {
+		t.Fatal("chunk not cached")
+	}
+	if err := os.WriteFile(cache.path(digest), []byte("chunk DATA"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get(digest); ok {
+		t.Fatal("corrupted chunk returned from cache")
+	}
+	if _, err := os.Stat(cache.path(digest)); !os.IsNotExist(err) {
+		t.Errorf("corrupted chunk not removed: %v", err)
+	}
+}
+
+func TestFetchChunksReportsStoredChunks(t *testing.T) {
+	const chunkSize = 4
+	content := []byte("0123456789abcdef01")
+	var manifest []string
+	for offset := 0; offset < len(content); offset += chunkSize {
+		manifest = append(manifest, chunkSHA256(content[offset:min(offset+chunkSize, len(content))]))
+	}
+	count := int64(len(manifest))
+
+	// run 接收文件，Server 直接回复每个分片，返回 Agent 发出的所有分片请求
+	run := func(t *testing.T, cache *ChunkCache) []*protobuf.FileChunkRequest {
+		t.Helper()
+		saved := GetChunkCache()
+		SetChunkCache(cache)
+		defer SetChunkCache(saved)
+
+		chunks := make(chan *protobuf.FileChunk, 1)
+		var requests []*protobuf.FileChunkRequest
+		stream := &grpc.FileStream{
+			Chunks: chunks,
+			Request: func(req *protobuf.FileChunkRequest) error {
+				requests = append(requests, req)
+				if !req.ReportOnly {
+					offset := req.Index * chunkSize
+					chunks <- &protobuf.FileChunk{Offset: offset, Data: content[offset:min(offset+chunkSize, int64(len(content)))]}
+				}
+				return nil
+			},
+		}
+		file, err := os.CreateTemp(t.TempDir(), "file-*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		receiver := &FileReceiver{idleTimeout: time.Second, resumeTimeout: time.Second}
+		digest := sha256.New()
+		spec := &protobuf.FileSpec{Size: int64(len(content)), ChunkSize: chunkSize, ChunkSha256: manifest}
+		if _, err := receiver.fetchChunks(&protobuf.CommandContent{CommandId: "cmd-1", HostId: "host-1", File: spec}, file, digest, stream); err != nil {
+			t.Fatalf("fetchChunks failed: %v", err)
+		}
+		if got, want := digest.Sum(nil), sha256.Sum256(content); !bytes.Equal(got, want[:]) {
+			t.Fatal("received file sha256 mismatch")
+		}
+		return requests
+	}
+
+	t.Run("cached chunks are reported after they are stored", func(t *testing.T) {
+		cache, err := NewChunkCache(t.TempDir(), 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		requests := run(t, cache)
+		if len(requests) != int(count)+1 {
+			t.Fatalf("%d requests, want %d chunk requests and a final report", len(requests), count)
+		}
+		last := requests[len(requests)-1]
+		if !last.ReportOnly {
+			t.Fatal("last request is not a report")
+		}
+		reported := make(map[int64]bool)
+		requested := make(map[int64]bool)
+		for _, req := range requests {
+			for _, index := range req.StoredChunks {
+				// 只上报此前已请求并放入缓存的分片
+				if !requested[index] || reported[index] {
+					t.Errorf("chunk %d reported before it was stored or reported twice", index)
+				}
+				reported[index] = true
+			}
+			if !req.ReportOnly {
+				requested[req.Index] = true
+			}
+		}
+		if len(reported) != int(count) {
+			t.Errorf("reported chunks %v, want all %d chunks", reported, count)
+		}
+
+		// 缓存中已有的分片不再请求，同样上报
+		requests = run(t, cache)
+		if len(requests) != 1 || !requests[0].ReportOnly || len(requests[0].StoredChunks) != int(count) {
+			t.Errorf("requests with all chunks cached = %v, want a single report of %d chunks", requests, count)
+		}
+	})
+
+	t.Run("chunks are not reported without a cache", func(t *testing.T) {
+		for _, req := range run(t, nil) {
+			if req.ReportOnly || len(req.StoredChunks) > 0 {
+				t.Errorf("request %v reports chunks that were not cached", req)
+			}
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+
+	"devops-manager/agent/pkg/config"
+	"devops-manager/agent/pkg/utils"
+)
+
+// PolicyCodeRunAsDenied 请求的执行用户或用户组不在允许列表中
+const PolicyCodeRunAsDenied = "POLICY_RUN_AS_DENIED"
+
+// RunAsPolicy 执行用户策略
+type RunAsPolicy struct {
+	privileged    bool // Agent 以 root 运行
+	defaultUser   string
+	allowedUsers  map[string]bool
+	allowedGroups map[string]bool
+}
+
+var (
+	runAsPolicy      = &RunAsPolicy{}
+	runAsPolicyMutex sync.RWMutex
+)
+
+// NewRunAsPolicy 根据执行配置创建执行用户策略，Agent 以 root 运行时必须配置默认执行用户
+func NewRunAsPolicy(cfg config.ExecutionConfig) (*RunAsPolicy, error) {
+	return newRunAsPolicy(cfg, os.Geteuid() == 0)
+}
+
+// newRunAsPolicy 创建执行用户策略，privileged 表示 Agent 以 root 运行
+func newRunAsPolicy(cfg config.ExecutionConfig, privileged bool) (*RunAsPolicy, error) {
+	if privileged && cfg.DefaultUser == "" {
+		return nil, errors.New("execution.default_user is required when the agent runs as root")
+	}
+	policy := &RunAsPolicy{privileged: privileged, defaultUser: cfg.DefaultUser}
+	if len(cfg.AllowedUsers) > 0 {
+		policy.allowedUsers = make(map[string]bool, len(cfg.AllowedUsers))
+		for _, name := range cfg.AllowedUsers {
+			policy.allowedUsers[name] = true
+		}
+	}
+	if len(cfg.AllowedGroups) > 0 {
+		policy.allowedGroups = make(map[string]bool, len(cfg.AllowedGroups))
+		for _, name := range cfg.AllowedGroups {
+			policy.allowedGroups[name] = true
+		}
+	}
+	return policy, nil
+}
+
+// SetRunAsPolicy 设置全局执行用户策略
+func SetRunAsPolicy(policy *RunAsPolicy) {
+	runAsPolicyMutex.Lock()
+	defer runAsPolicyMutex.Unlock()
+	runAsPolicy = policy
+}
+
+// GetRunAsPolicy 获取全局执行用户策略
+func GetRunAsPolicy() *RunAsPolicy {
+	runAsPolicyMutex.RLock()
+	defer runAsPolicyMutex.RUnlock()
+	return runAsPolicy
+}
+
+// Resolve 确定命令的执行用户，未指定时使用默认执行用户，requestedBy 为发起命令的用户
+// 默认执行用户总是允许的；允许列表为空时只允许默认执行用户
+// Agent 以 root 运行时不会在未配置默认执行用户的情况下以 root 执行
+// 指定的用户组须为执行用户的主组或附加组，或在用户组允许列表中
+func (p *RunAsPolicy) Resolve(options *utils.ExecOptions, requestedBy string) (*utils.ExecOptions, error) {
+	resolved := utils.ExecOptions{}
+	if options != nil {
+		resolved = *options
+	}
+
+	if resolved.User == "" {
+		if resolved.Group != "" {
+			return nil, fmt.Errorf("run as group %s requires a user", resolved.Group)
+		}
+		resolved.User = p.defaultUser
+	}
+	if resolved.User == "" && p.privileged {
+		return nil, &PolicyViolation{
+			Code:   PolicyCodeRunAsDenied,
+			User:   requestedBy,
+			Reason: "no run as user specified and execution.default_user is not configured",
+		}
+	}
+	if resolved.User != "" && !p.allowsUser(resolved.User) {
+		return nil, &PolicyViolation{
+			Code:   PolicyCodeRunAsDenied,
+			User:   requestedBy,
+			Reason: fmt.Sprintf("run as user %s is not allowed", resolved.User),
+		}
+	}
+	if resolved.Group != "" {
+		if err := p.checkGroup(resolved.User, resolved.Group, requestedBy); err != nil {
+			return nil, err
+		}
+	}
+	return &resolved, nil
+}
+
+// allowsUser 检查是否允许以指定用户执行，允许列表为空时只允许默认执行用户
+func (p *RunAsPolicy) allowsUser(name string) bool {
+	return name == p.defaultUser || p.allowedUsers[name]
+}
+
+// checkGroup 检查用户组是否允许与执行用户一起使用
+func (p *RunAsPolicy) checkGroup(userName, group, requestedBy string) error {
+	if p.allowedGroups[group] {
+		return nil
+	}
+	member, err := utils.UserInGroup(userName, group)
+	if err != nil {
+		return &PolicyViolation{
+			Code:   PolicyCodeRunAsDenied,
+			User:   requestedBy,
+			Reason: fmt.Sprintf("run as group %s is not allowed: %v", group, err),
+		}
+	}
+	if !member {
+		return &PolicyViolation{
+			Code:   PolicyCodeRunAsDenied,
+			User:   requestedBy,
+			Reason: fmt.Sprintf("run as group %s is not allowed for user %s", group, userName),
+		}
+	}
+	return nil
+}
+
+// CheckOwner 检查分发文件的属主和属组，规则与执行用户相同，requestedBy 为发起命令的用户
+// 未指定属主时文件属于 Agent 进程用户，指定的属组按 Agent 进程用户检查
+func (p *RunAsPolicy) CheckOwner(owner, group, requestedBy string) error {
+	if owner != "" && !p.allowsUser(owner) {
+		return &PolicyViolation{
+			Code:   PolicyCodeRunAsDenied,
+			User:   requestedBy,
+			Reason: fmt.Sprintf("file owner %s is not allowed", owner),
+		}
+	}
+	if group == "" {
+		return nil
+	}
+	if owner == "" {
+		current, err := user.Current()
+		if err != nil {
+			return fmt.Errorf("failed to get current user: %w", err)
+		}
+		owner = current.Username
+	}
+	return p.checkGroup(owner, group, requestedBy)
+}
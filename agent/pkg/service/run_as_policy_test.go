@@ -0,0 +1,150 @@
+package service
+
+import (
+	"errors"
+	"os/user"
+	"testing"
+
+	"devops-manager/agent/pkg/config"
+	"devops-manager/agent/pkg/utils"
+)
+
+func TestNewRunAsPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        config.ExecutionConfig
+		privileged bool
+		wantErr    bool
+	}{
+		{name: "unprivileged without default user", cfg: config.ExecutionConfig{}},
+		{name: "root without default user", cfg: config.ExecutionConfig{}, privileged: true, wantErr: true},
+		{name: "root with allowed users only", cfg: config.ExecutionConfig{AllowedUsers: []string{"deploy"}}, privileged: true, wantErr: true},
+		{name: "root with default user", cfg: config.ExecutionConfig{DefaultUser: "nobody"}, privileged: true},
+		{name: "root explicitly running as root", cfg: config.ExecutionConfig{DefaultUser: "root"}, privileged: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newRunAsPolicy(tt.cfg, tt.privileged)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newRunAsPolicy error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunAsPolicyResolve(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("current user unavailable: %v", err)
+	}
+	primary, err := user.LookupGroupId(current.Gid)
+	if err != nil {
+		t.Skipf("primary group unavailable: %v", err)
+	}
+
+	withDefault := config.ExecutionConfig{DefaultUser: current.Username}
+	withAllowed := config.ExecutionConfig{DefaultUser: "nobody", AllowedUsers: []string{"deploy", current.Username}}
+	withGroups := config.ExecutionConfig{DefaultUser: current.Username, AllowedGroups: []string{"no-such-group"}}
+
+	tests := []struct {
+		name       string
+		cfg        config.ExecutionConfig
+		privileged bool
+		options    *utils.ExecOptions
+		wantUser   string
+		wantDenied bool
+		wantErr    bool
+	}{
+		{name: "default user", cfg: withDefault, wantUser: current.Username},
+		{name: "explicit default user", cfg: withDefault, options: &utils.ExecOptions{User: current.Username}, wantUser: current.Username},
+		{name: "empty allowed list permits only default user", cfg: withDefault, options: &utils.ExecOptions{User: "deploy"}, wantDenied: true},
+		{name: "empty allowed list denies other user", cfg: withDefault, privileged: true, options: &utils.ExecOptions{User: "backup"}, wantDenied: true},
+		{name: "allowed user", cfg: withAllowed, options: &utils.ExecOptions{User: "deploy"}, wantUser: "deploy"},
+		{name: "user not in allowed list", cfg: withAllowed, options: &utils.ExecOptions{User: "backup"}, wantDenied: true},
+		{name: "unprivileged agent user", cfg: config.ExecutionConfig{}, wantUser: ""},
+		{name: "unprivileged agent cannot pick another user", cfg: config.ExecutionConfig{}, options: &utils.ExecOptions{User: "backup"}, wantDenied: true},
+		{
+			// 未经 newRunAsPolicy 校验的策略同样不会以 root 执行
+			name:       "root without default user",
+			cfg:        config.ExecutionConfig{},
+			privileged: true,
+			wantDenied: true,
+		},
+		{name: "group without user", cfg: withDefault, options: &utils.ExecOptions{Group: primary.Name}, wantErr: true},
+		{name: "primary group", cfg: withDefault, options: &utils.ExecOptions{User: current.Username, Group: primary.Name}, wantUser: current.Username},
+		{name: "unknown group", cfg: withDefault, options: &utils.ExecOptions{User: current.Username, Group: "no-such-group-2"}, wantDenied: true},
+		{name: "allowed group", cfg: withGroups, options: &utils.ExecOptions{User: current.Username, Group: "no-such-group"}, wantUser: current.Username},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := newRunAsPolicy(tt.cfg, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			policy.privileged = tt.privileged
+
+			resolved, err := policy.Resolve(tt.options, "alice")
+			var violation *PolicyViolation
+			if denied := errors.As(err, &violation); denied != tt.wantDenied {
+				t.Fatalf("Resolve error = %v, want denied %v", err, tt.wantDenied)
+			}
+			if tt.wantDenied {
+				if violation.Code != PolicyCodeRunAsDenied || violation.User != "alice" {
+					t.Errorf("violation = %+v", violation)
+				}
+				return
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Resolve succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve failed: %v", err)
+			}
+			if resolved.User != tt.wantUser {
+				t.Errorf("resolved user = %q, want %q", resolved.User, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestRunAsPolicyCheckOwner(t *testing.T) {
+	policy, err := newRunAsPolicy(config.ExecutionConfig{DefaultUser: "nobody", AllowedUsers: []string{"deploy"}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strict, err := newRunAsPolicy(config.ExecutionConfig{DefaultUser: "nobody"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		policy     *RunAsPolicy
+		owner      string
+		wantDenied bool
+	}{
+		{name: "agent user", policy: strict, owner: ""},
+		{name: "default user", policy: strict, owner: "nobody"},
+		{name: "empty allowed list denies other owner", policy: strict, owner: "deploy", wantDenied: true},
+		{name: "allowed owner", policy: policy, owner: "deploy"},
+		{name: "owner not in allowed list", policy: policy, owner: "root", wantDenied: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.CheckOwner(tt.owner, "", "alice")
+			var violation *PolicyViolation
+			if denied := errors.As(err, &violation); denied != tt.wantDenied {
+				t.Fatalf("CheckOwner error = %v, want denied %v", err, tt.wantDenied)
+			}
+			if !tt.wantDenied && err != nil {
+				t.Fatalf("CheckOwner failed: %v", err)
+			}
+		})
+	}
+}
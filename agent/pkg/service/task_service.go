@@ -2,13 +2,18 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 	"sync"
 	"time"
 
+	"devops-manager/agent/pkg/grpc"
 	"devops-manager/agent/pkg/utils"
 	"devops-manager/api/protobuf"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // TaskService 任务执行服务
@@ -17,17 +22,35 @@ type TaskService struct {
 	mutex        sync.RWMutex
 }
 
+// 任务执行状态
+const (
+	TaskStatusRunning   = "running"
+	TaskStatusPaused    = "paused"
+	TaskStatusCompleted = "completed"
+	TaskStatusFailed    = "failed"
+	TaskStatusCanceled  = "canceled"
+)
+
 // TaskExecution 任务执行状态
 type TaskExecution struct {
 	TaskID    string
 	Command   string
-	Status    string // running, completed, failed, canceled
+	Status    string // running, paused, completed, failed, canceled
 	StartTime time.Time
 	EndTime   *time.Time
 	Result    *utils.CommandResult
 	Cancel    context.CancelFunc
+	Process   *os.Process // 命令进程，进程启动前为 nil
 }
 
+// isActive 检查任务是否仍在执行（含暂停）
+func (te *TaskExecution) isActive() bool {
+	return te.Status == TaskStatusRunning || te.Status == TaskStatusPaused
+}
+
+// errTaskCanceled 任务被取消
+var errTaskCanceled = errors.New("task canceled")
+
 // NewTaskService 创建任务服务
 func NewTaskService() *TaskService {
 	return &TaskService{
@@ -35,11 +58,19 @@ func NewTaskService() *TaskService {
 	}
 }
 
-// ExecuteTask 执行任务
-func (ts *TaskService) ExecuteTask(taskID, command string, timeout time.Duration) (*utils.CommandResult, error) {
-	// 验证命令安全性
-	if err := utils.ValidateCommand(command); err != nil {
-		return nil, fmt.Errorf("command validation failed: %w", err)
+// ExecuteTask 执行任务，user 为发起命令的用户，用于匹配命令策略
+// script 不为空时 command 为脚本内容，由指定解释器执行
+// options 为执行选项，未指定执行用户时使用默认执行用户；output 不为空时执行过程中增量回调命令输出
+func (ts *TaskService) ExecuteTask(taskID, command, user string, script *protobuf.ScriptSpec, options *utils.ExecOptions, timeout time.Duration, output utils.OutputHandler) (*utils.CommandResult, error) {
+	// 检查命令执行策略
+	if err := GetCommandPolicy().Check(command, user); err != nil {
+		return nil, err
+	}
+
+	// 确定执行用户
+	options, err := GetRunAsPolicy().Resolve(options, user)
+	if err != nil {
+		return nil, err
 	}
 
 	// 检查任务是否已在执行
@@ -54,42 +85,71 @@ func (ts *TaskService) ExecuteTask(taskID, command string, timeout time.Duration
 	execution := &TaskExecution{
 		TaskID:    taskID,
 		Command:   command,
-		Status:    "running",
+		Status:    TaskStatusRunning,
 		StartTime: time.Now(),
 		Cancel:    cancel,
 	}
 	ts.runningTasks[taskID] = execution
 	ts.mutex.Unlock()
 
-	log.Printf("Starting task execution: %s, command: %s", taskID, command)
+	description := "command: " + command
+	if script != nil {
+		description = fmt.Sprintf("%s script (%d bytes), args: %v", script.Interpreter, len(command), script.Args)
+	}
+	if options.User != "" {
+		log.Printf("Starting task execution: %s, %s, run as: %s", taskID, description, options.User)
+	} else {
+		log.Printf("Starting task execution: %s, %s", taskID, description)
+	}
 
 	// 异步执行命令
+	done := make(chan struct{})
 	go func() {
-		defer func() {
+		defer close(done)
+
+		started := func(process *os.Process) {
 			ts.mutex.Lock()
-			execution.Status = "completed"
-			now := time.Now()
-			execution.EndTime = &now
+			execution.Process = process
 			ts.mutex.Unlock()
-		}()
+		}
 
-		result := utils.ExecuteCommand(command, timeout)
+		var result *utils.CommandResult
+		if script != nil {
+			result = utils.ExecuteScriptContext(ctx, script.Interpreter, command, script.Args, timeout, options, output, started)
+		} else {
+			result = utils.ExecuteCommandContext(ctx, command, timeout, options, output, started)
+		}
 
 		ts.mutex.Lock()
 		execution.Result = result
-		if result.ExitCode != 0 {
-			execution.Status = "failed"
+		if execution.isActive() {
+			execution.Status = TaskStatusCompleted
+			if result.ExitCode != 0 {
+				execution.Status = TaskStatusFailed
+			}
+			now := time.Now()
+			execution.EndTime = &now
 		}
 		ts.mutex.Unlock()
 
 		log.Printf("Task %s completed with exit code: %d", taskID, result.ExitCode)
 	}()
 
-	// 等待任务完成或超时
+	// 等待任务完成、取消或超时
 	select {
+	case <-done:
+		ts.mutex.RLock()
+		result := execution.Result
+		ts.mutex.RUnlock()
+		return result, nil
 	case <-ctx.Done():
-		return nil, fmt.Errorf("task canceled")
-	case <-time.After(timeout + time.Second): // 给一点额外时间
+		// 等待进程退出，返回取消前已产生的输出
+		<-done
+		ts.mutex.RLock()
+		result := execution.Result
+		ts.mutex.RUnlock()
+		return result, errTaskCanceled
+	case <-time.After(timeout + utils.MaxTerminationDelay() + time.Second): // 留出终止进程树的时间
 		ts.mutex.RLock()
 		result := execution.Result
 		ts.mutex.RUnlock()
@@ -101,6 +161,163 @@ func (ts *TaskService) ExecuteTask(taskID, command string, timeout time.Duration
 	}
 }
 
+// HandleCommand 将 Server 下发的命令加入执行队列，执行完成后返回执行结果
+// 队列已满时立即返回 busy 结果；sink 不为空时，执行过程中的输出按分片增量发送给 Server
+func (ts *TaskService) HandleCommand(cmd *protobuf.CommandContent, sink grpc.OutputSink) *protobuf.CommandResult {
+	var result *protobuf.CommandResult
+	err := GetExecutionQueue().Run(cmd.CommandId, func() {
+		result = ts.executeCommand(cmd, sink)
+	})
+	if err == nil && result != nil {
+		return result
+	}
+	return rejectedResult(cmd, err)
+}
+
+// rejectedResult 创建未能在执行队列中执行的命令结果
+func rejectedResult(cmd *protobuf.CommandContent, err error) *protobuf.CommandResult {
+	now := timestamppb.Now()
+	rejected := &protobuf.CommandResult{
+		CommandId:  cmd.CommandId,
+		HostId:     cmd.HostId,
+		ExitCode:   -1,
+		StartedAt:  now,
+		FinishedAt: now,
+	}
+	switch {
+	case errors.Is(err, ErrQueueFull):
+		stats := GetExecutionQueue().Stats()
+		log.Printf("Command %s rejected: %d running, %d queued", cmd.CommandId, stats.Running, stats.Queued)
+		rejected.Busy = true
+		rejected.ErrorMessage = err.Error()
+	case errors.Is(err, errQueuedCanceled):
+		log.Printf("Command %s canceled before execution", cmd.CommandId)
+		rejected.ErrorMessage = errTaskCanceled.Error()
+	case err != nil:
+		rejected.ErrorMessage = err.Error()
+	default:
+		rejected.ErrorMessage = "command execution failed"
+	}
+	rejected.Stderr = rejected.ErrorMessage
+	return rejected
+}
+
+// executeCommand 执行 Server 下发的命令并构建执行结果
+func (ts *TaskService) executeCommand(cmd *protobuf.CommandContent, sink grpc.OutputSink) *protobuf.CommandResult {
+	if cmd.Script != nil {
+		log.Printf("Executing %s script %s", cmd.Script.Interpreter, cmd.CommandId)
+	} else {
+		log.Printf("Executing command %s: %s", cmd.CommandId, cmd.Command)
+	}
+
+	// 设置超时时间
+	timeout := 30 * time.Second
+	if cmd.Timeout != nil {
+		timeout = cmd.Timeout.AsDuration()
+	}
+
+	var output utils.OutputHandler
+	if sink != nil {
+		streamer := newOutputStreamer(cmd.CommandId, sink)
+		defer streamer.Close()
+		output = streamer.Write
+	}
+
+	// 执行结果交给命令流发送（由发件箱保证送达），执行记录不再需要
+	defer ts.forgetTask(cmd.CommandId)
+
+	startedAt := timestamppb.Now()
+	result, err := ts.ExecuteTask(cmd.CommandId, cmd.Command, cmd.RequestedBy, cmd.Script, execOptions(cmd.Options), timeout, output)
+	finishedAt := timestamppb.Now()
+
+	if errors.Is(err, errTaskCanceled) && result != nil {
+		log.Printf("Command %s canceled, process tree terminated with %s", cmd.CommandId, result.Signal)
+		return &protobuf.CommandResult{
+			CommandId:         cmd.CommandId,
+			HostId:            cmd.HostId,
+			Stdout:            result.Stdout,
+			Stderr:            result.Stderr,
+			ExitCode:          -1,
+			StartedAt:         startedAt,
+			FinishedAt:        finishedAt,
+			ErrorMessage:      err.Error(),
+			Truncated:         result.Truncated,
+			StdoutSize:        uint64(result.StdoutSize),
+			StderrSize:        uint64(result.StderrSize),
+			TerminationSignal: result.Signal,
+		}
+	}
+
+	if err != nil {
+		errorMessage := err.Error()
+		var violation *PolicyViolation
+		if errors.As(err, &violation) {
+			log.Printf("Command %s rejected by policy: %s", cmd.CommandId, violation.Encode())
+			errorMessage = violation.Encode()
+		}
+		return &protobuf.CommandResult{
+			CommandId:    cmd.CommandId,
+			HostId:       cmd.HostId,
+			Stderr:       err.Error(),
+			ExitCode:     -1,
+			StartedAt:    startedAt,
+			FinishedAt:   finishedAt,
+			ErrorMessage: errorMessage,
+		}
+	}
+
+	if result.TimedOut {
+		log.Printf("Command %s timed out, process tree terminated with %s", cmd.CommandId, result.Signal)
+	} else if result.OOMKilled {
+		log.Printf("Command %s killed by OOM killer after exceeding its memory limit", cmd.CommandId)
+	} else {
+		log.Printf("Command %s completed with exit code: %d", cmd.CommandId, result.ExitCode)
+	}
+	if result.Truncated {
+		log.Printf("Command %s output truncated: stdout %d bytes, stderr %d bytes", cmd.CommandId, result.StdoutSize, result.StderrSize)
+	}
+	return &protobuf.CommandResult{
+		CommandId:         cmd.CommandId,
+		HostId:            cmd.HostId,
+		Stdout:            result.Stdout,
+		Stderr:            result.Stderr,
+		ExitCode:          int32(result.ExitCode),
+		StartedAt:         startedAt,
+		FinishedAt:        finishedAt,
+		ErrorMessage:      result.Error,
+		Truncated:         result.Truncated,
+		StdoutSize:        uint64(result.StdoutSize),
+		StderrSize:        uint64(result.StderrSize),
+		TimedOut:          result.TimedOut,
+		TerminationSignal: result.Signal,
+		OomKilled:         result.OOMKilled,
+	}
+}
+
+// execOptions 转换 Server 下发的执行选项
+func execOptions(options *protobuf.ExecutionOptions) *utils.ExecOptions {
+	if options == nil {
+		return nil
+	}
+	result := &utils.ExecOptions{
+		User:       options.RunAsUser,
+		Group:      options.RunAsGroup,
+		WorkingDir: options.WorkingDir,
+		Env:        options.Env,
+		Umask:      options.Umask,
+		Stdin:      options.Stdin,
+	}
+	if limits := options.Resources; limits != nil {
+		result.Resources = &utils.ResourceLimits{
+			CPUQuota:    limits.CpuQuota,
+			MemoryBytes: limits.MemoryBytes,
+			IOWeight:    limits.IoWeight,
+			MaxPIDs:     limits.MaxPids,
+		}
+	}
+	return result
+}
+
 // GetTaskStatus 获取任务状态
 func (ts *TaskService) GetTaskStatus(taskID string) (*TaskExecution, bool) {
 	ts.mutex.RLock()
@@ -110,7 +327,7 @@ func (ts *TaskService) GetTaskStatus(taskID string) (*TaskExecution, bool) {
 	return execution, exists
 }
 
-// CancelTask 取消任务
+// CancelTask 取消任务，终止命令进程
 func (ts *TaskService) CancelTask(taskID string) error {
 	ts.mutex.Lock()
 	defer ts.mutex.Unlock()
@@ -120,9 +337,9 @@ func (ts *TaskService) CancelTask(taskID string) error {
 		return fmt.Errorf("task %s not found", taskID)
 	}
 
-	if execution.Status == "running" {
+	if execution.isActive() {
 		execution.Cancel()
-		execution.Status = "canceled"
+		execution.Status = TaskStatusCanceled
 		now := time.Now()
 		execution.EndTime = &now
 		log.Printf("Task %s canceled", taskID)
@@ -131,6 +348,107 @@ func (ts *TaskService) CancelTask(taskID string) error {
 	return nil
 }
 
+// HandleControl 处理 Server 下发的命令控制请求，返回处理后命令的执行状态
+func (ts *TaskService) HandleControl(req *protobuf.ControlRequest) *protobuf.ControlAck {
+	ack := &protobuf.ControlAck{
+		ControlId: req.ControlId,
+		CommandId: req.CommandId,
+		HostId:    req.HostId,
+		Action:    req.Action,
+	}
+
+	// 排队中的命令只能取消
+	queue := GetExecutionQueue()
+	if req.Action == protobuf.ControlAction_CONTROL_ACTION_CANCEL && queue.Cancel(req.CommandId) {
+		ack.Success = true
+		ack.State = protobuf.ExecutionState_EXECUTION_STATE_CANCELED
+		log.Printf("Queued command %s canceled by %s", req.CommandId, req.RequestedBy)
+		return ack
+	}
+	if queue.IsQueued(req.CommandId) {
+		ack.State = protobuf.ExecutionState_EXECUTION_STATE_QUEUED
+		ack.Message = fmt.Sprintf("command %s is queued and has not started", req.CommandId)
+		return ack
+	}
+
+	ts.mutex.RLock()
+	execution, exists := ts.runningTasks[req.CommandId]
+	ts.mutex.RUnlock()
+	if !exists {
+		ack.State = protobuf.ExecutionState_EXECUTION_STATE_NOT_FOUND
+		ack.Message = fmt.Sprintf("command %s not found", req.CommandId)
+		return ack
+	}
+
+	var err error
+	switch req.Action {
+	case protobuf.ControlAction_CONTROL_ACTION_CANCEL:
+		err = ts.CancelTask(req.CommandId)
+	case protobuf.ControlAction_CONTROL_ACTION_SIGNAL:
+		err = ts.controlProcess(execution, func(process *os.Process) error {
+			return utils.SignalProcess(process, int(req.Signal))
+		}, "")
+	case protobuf.ControlAction_CONTROL_ACTION_PAUSE:
+		err = ts.controlProcess(execution, utils.SuspendProcess, TaskStatusPaused)
+	case protobuf.ControlAction_CONTROL_ACTION_RESUME:
+		err = ts.controlProcess(execution, utils.ResumeProcess, TaskStatusRunning)
+	default:
+		err = fmt.Errorf("unsupported control action %v", req.Action)
+	}
+
+	ts.mutex.RLock()
+	ack.State = executionState(execution.Status)
+	ts.mutex.RUnlock()
+
+	if err != nil {
+		ack.Message = err.Error()
+		log.Printf("Control %s of command %s failed: %v", req.Action, req.CommandId, err)
+		return ack
+	}
+
+	ack.Success = true
+	log.Printf("Control %s of command %s requested by %s applied, state: %s",
+		req.Action, req.CommandId, req.RequestedBy, ack.State)
+	return ack
+}
+
+// controlProcess 对执行中的命令进程执行控制操作，status 不为空时更新任务状态
+func (ts *TaskService) controlProcess(execution *TaskExecution, apply func(*os.Process) error, status string) error {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	if !execution.isActive() {
+		return fmt.Errorf("task %s is not running", execution.TaskID)
+	}
+	if execution.Process == nil {
+		return fmt.Errorf("task %s process not started", execution.TaskID)
+	}
+
+	if err := apply(execution.Process); err != nil {
+		return err
+	}
+	if status != "" {
+		execution.Status = status
+	}
+	return nil
+}
+
+// executionState 转换任务状态为控制确认中的执行状态
+func executionState(status string) protobuf.ExecutionState {
+	switch status {
+	case TaskStatusRunning:
+		return protobuf.ExecutionState_EXECUTION_STATE_RUNNING
+	case TaskStatusPaused:
+		return protobuf.ExecutionState_EXECUTION_STATE_PAUSED
+	case TaskStatusCanceled:
+		return protobuf.ExecutionState_EXECUTION_STATE_CANCELED
+	case TaskStatusCompleted, TaskStatusFailed:
+		return protobuf.ExecutionState_EXECUTION_STATE_FINISHED
+	default:
+		return protobuf.ExecutionState_EXECUTION_STATE_UNKNOWN
+	}
+}
+
 // GetRunningTasks 获取正在运行的任务列表
 func (ts *TaskService) GetRunningTasks() []string {
 	ts.mutex.RLock()
@@ -138,13 +456,23 @@ func (ts *TaskService) GetRunningTasks() []string {
 
 	var tasks []string
 	for taskID, execution := range ts.runningTasks {
-		if execution.Status == "running" {
+		if execution.isActive() {
 			tasks = append(tasks, taskID)
 		}
 	}
 	return tasks
 }
 
+// forgetTask 删除已结束任务的执行记录，任务仍在执行时保留
+func (ts *TaskService) forgetTask(taskID string) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	if execution, exists := ts.runningTasks[taskID]; exists && !execution.isActive() {
+		delete(ts.runningTasks, taskID)
+	}
+}
+
 // CleanupCompletedTasks 清理已完成的任务
 func (ts *TaskService) CleanupCompletedTasks(maxAge time.Duration) {
 	ts.mutex.Lock()
@@ -152,7 +480,7 @@ func (ts *TaskService) CleanupCompletedTasks(maxAge time.Duration) {
 
 	cutoff := time.Now().Add(-maxAge)
 	for taskID, execution := range ts.runningTasks {
-		if execution.Status != "running" && execution.EndTime != nil && execution.EndTime.Before(cutoff) {
+		if !execution.isActive() && execution.EndTime != nil && execution.EndTime.Before(cutoff) {
 			delete(ts.runningTasks, taskID)
 			log.Printf("Cleaned up completed task: %s", taskID)
 		}
@@ -0,0 +1,238 @@
+//go:build linux
+
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// cgroupMountPoint cgroup v2 挂载点
+const cgroupMountPoint = "/sys/fs/cgroup"
+
+// cgroupControllers 命令 cgroup 使用的控制器
+var cgroupControllers = []string{"cpu", "memory", "io", "pids"}
+
+// cgroupPeriod cpu.max 的周期（微秒）
+const cgroupPeriod = 100000
+
+var (
+	cgroupOnce    sync.Once
+	cgroupBase    string          // 命令 cgroup 的父目录
+	cgroupEnabled map[string]bool // 父目录中已启用的控制器
+	cgroupErr     error
+	cgroupSeq     atomic.Uint64
+)
+
+// initCgroups 在 Agent 自身的 cgroup 下建立命令子树：
+// Agent 进程移入 agent 叶子节点，命令放在 commands 下各自的临时 cgroup 中
+// cgroup v2 不允许有进程的节点向子节点启用控制器，因此 Agent 不能留在自身 cgroup 的根上
+func initCgroups() (string, map[string]bool, error) {
+	if _, err := os.Stat(filepath.Join(cgroupMountPoint, "cgroup.controllers")); err != nil {
+		return "", nil, fmt.Errorf("cgroup v2 is not mounted at %s", cgroupMountPoint)
+	}
+
+	self, err := currentCgroup()
+	if err != nil {
+		return "", nil, err
+	}
+	root := filepath.Join(cgroupMountPoint, self)
+	// Agent 重启前已移入叶子节点时，以上一级为根
+	if filepath.Base(root) == "agent" {
+		if _, err := os.Stat(filepath.Join(filepath.Dir(root), "commands")); err == nil {
+			root = filepath.Dir(root)
+		}
+	}
+
+	leaf := filepath.Join(root, "agent")
+	if err := os.MkdirAll(leaf, 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to create agent cgroup: %w", err)
+	}
+	if err := writeCgroupFile(leaf, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+		return "", nil, fmt.Errorf("failed to move agent into %s: %w", leaf, err)
+	}
+
+	enabled, err := enableControllers(root)
+	if err != nil {
+		return "", nil, err
+	}
+	base := filepath.Join(root, "commands")
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to create commands cgroup: %w", err)
+	}
+	if enabled, err = enableControllers(base); err != nil {
+		return "", nil, err
+	}
+	return base, enabled, nil
+}
+
+// currentCgroup 读取当前进程所在的 cgroup v2 路径
+func currentCgroup() (string, error) {
+	file, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("cgroup v2 path not found in /proc/self/cgroup")
+}
+
+// enableControllers 在 dir 中为子节点启用可用的控制器，返回已启用的控制器
+func enableControllers(dir string) (map[string]bool, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return nil, err
+	}
+	available := make(map[string]bool)
+	for _, name := range strings.Fields(string(data)) {
+		available[name] = true
+	}
+
+	enabled := make(map[string]bool)
+	for _, name := range cgroupControllers {
+		if !available[name] {
+			continue
+		}
+		if err := writeCgroupFile(dir, "cgroup.subtree_control", "+"+name); err != nil {
+			return nil, fmt.Errorf("failed to enable %s controller in %s (is cgroup delegation enabled for the agent?): %w", name, dir, err)
+		}
+		enabled[name] = true
+	}
+	return enabled, nil
+}
+
+// writeCgroupFile 写入 cgroup 接口文件
+func writeCgroupFile(dir, name, value string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+}
+
+// commandCgroup 单条命令的临时 cgroup
+type commandCgroup struct {
+	path string
+	dir  *os.File
+}
+
+// newCommandCgroup 为命令创建临时 cgroup 并写入资源限制
+func newCommandCgroup(limits *ResourceLimits) (*commandCgroup, error) {
+	cgroupOnce.Do(func() {
+		cgroupBase, cgroupEnabled, cgroupErr = initCgroups()
+		if cgroupErr == nil {
+			log.Printf("Command cgroups enabled under %s", cgroupBase)
+		}
+	})
+	if cgroupErr != nil {
+		return nil, fmt.Errorf("resource limits unavailable: %w", cgroupErr)
+	}
+
+	settings := limits.cgroupSettings()
+	for file := range settings {
+		controller, _, _ := strings.Cut(file, ".")
+		if !cgroupEnabled[controller] {
+			return nil, fmt.Errorf("resource limits unavailable: %s controller is not enabled", controller)
+		}
+	}
+
+	path := filepath.Join(cgroupBase, fmt.Sprintf("cmd-%d-%d", os.Getpid(), cgroupSeq.Add(1)))
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create command cgroup: %w", err)
+	}
+	cgroup := &commandCgroup{path: path}
+
+	for file, value := range settings {
+		if err := writeCgroupFile(path, file, value); err != nil {
+			cgroup.Remove()
+			return nil, fmt.Errorf("failed to set %s: %w", file, err)
+		}
+	}
+	// 内存限制不计入 swap，超出时直接触发 OOM；未开启 swap 时文件不存在
+	if limits.MemoryBytes > 0 {
+		writeCgroupFile(path, "memory.swap.max", "0")
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		cgroup.Remove()
+		return nil, fmt.Errorf("failed to open command cgroup: %w", err)
+	}
+	cgroup.dir = dir
+	return cgroup, nil
+}
+
+// cgroupSettings 将资源限制转换为 cgroup 接口文件及取值
+func (l *ResourceLimits) cgroupSettings() map[string]string {
+	settings := make(map[string]string)
+	if l.CPUQuota > 0 {
+		quota := max(int64(l.CPUQuota*cgroupPeriod), 1000)
+		settings["cpu.max"] = fmt.Sprintf("%d %d", quota, cgroupPeriod)
+	}
+	if l.MemoryBytes > 0 {
+		settings["memory.max"] = strconv.FormatInt(l.MemoryBytes, 10)
+	}
+	if l.IOWeight > 0 {
+		settings["io.weight"] = fmt.Sprintf("default %d", l.IOWeight)
+	}
+	if l.MaxPIDs > 0 {
+		settings["pids.max"] = strconv.FormatInt(l.MaxPIDs, 10)
+	}
+	return settings
+}
+
+// Apply 使命令进程在创建时即位于该 cgroup 中
+func (c *commandCgroup) Apply(cmd *exec.Cmd) {
+	attr := sysProcAttr(cmd)
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(c.dir.Fd())
+}
+
+// OOMKilled 检查 cgroup 中是否有进程被 OOM killer 终止
+func (c *commandCgroup) OOMKilled() bool {
+	data, err := os.ReadFile(filepath.Join(c.path, "memory.events"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if count, ok := strings.CutPrefix(line, "oom_kill "); ok {
+			n, _ := strconv.ParseUint(strings.TrimSpace(count), 10, 64)
+			return n > 0
+		}
+	}
+	return false
+}
+
+// Remove 结束 cgroup 中残留的进程并删除 cgroup
+func (c *commandCgroup) Remove() {
+	if c.dir != nil {
+		c.dir.Close()
+	}
+	for attempt := 0; attempt < 10; attempt++ {
+		err := syscall.Rmdir(c.path)
+		if err == nil || errors.Is(err, syscall.ENOENT) {
+			return
+		}
+		if !errors.Is(err, syscall.EBUSY) {
+			log.Printf("Failed to remove command cgroup %s: %v", c.path, err)
+			return
+		}
+		// 脱离进程组的后台进程仍在 cgroup 中
+		writeCgroupFile(c.path, "cgroup.kill", "1")
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Printf("Failed to remove command cgroup %s: processes still running", c.path)
+}
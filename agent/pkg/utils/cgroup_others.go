@@ -0,0 +1,25 @@
+//go:build !linux
+
+package utils
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// commandCgroup 非 Linux 平台不支持 cgroup
+type commandCgroup struct{}
+
+// newCommandCgroup 非 Linux 平台不支持资源限制
+func newCommandCgroup(limits *ResourceLimits) (*commandCgroup, error) {
+	return nil, fmt.Errorf("resource limits require cgroup v2 on linux")
+}
+
+// Apply 非 Linux 平台无操作
+func (c *commandCgroup) Apply(cmd *exec.Cmd) {}
+
+// OOMKilled 非 Linux 平台总是返回 false
+func (c *commandCgroup) OOMKilled() bool { return false }
+
+// Remove 非 Linux 平台无操作
+func (c *commandCgroup) Remove() {}
@@ -1,12 +1,14 @@
 package utils
 
 import (
-	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
-	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,56 +20,193 @@ type CommandResult struct {
 	ExitCode int           `json:"exit_code"`
 	Duration time.Duration `json:"duration"`
 	Error    string        `json:"error,omitempty"`
+
+	// 输出超过上限时只保留首尾部分，Truncated 为 true，Size 为原始字节数
+	Truncated  bool  `json:"truncated,omitempty"`
+	StdoutSize int64 `json:"stdout_size"`
+	StderrSize int64 `json:"stderr_size"`
+
+	// 超时或取消时终止了进程树：TimedOut 表示因超时终止，Signal 为最终发送的信号
+	TimedOut bool   `json:"timed_out,omitempty"`
+	Signal   string `json:"signal,omitempty"`
+
+	// 命令进程因超出资源限制中的内存上限被 OOM killer 终止
+	OOMKilled bool `json:"oom_killed,omitempty"`
 }
 
-// ExecuteCommand 执行命令
-func ExecuteCommand(command string, timeout time.Duration) *CommandResult {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+// DefaultMaxOutputBytes 默认单个输出流在执行结果中保留的最大字节数
+const DefaultMaxOutputBytes = 1024 * 1024
 
-	result := &CommandResult{
-		Command: command,
+var maxOutputBytes atomic.Int64
+
+func init() {
+	maxOutputBytes.Store(DefaultMaxOutputBytes)
+	killGracePeriod.Store(int64(DefaultKillGracePeriod))
+}
+
+// SetMaxOutputBytes 设置单个输出流在执行结果中保留的最大字节数，不大于 0 时使用默认值
+func SetMaxOutputBytes(limit int) {
+	if limit <= 0 {
+		limit = DefaultMaxOutputBytes
 	}
+	maxOutputBytes.Store(int64(limit))
+}
 
-	startTime := time.Now()
-	defer func() {
-		result.Duration = time.Since(startTime)
-	}()
+// 终止命令进程树时发送的信号
+const (
+	SignalTerminate = "SIGTERM"
+	SignalKill      = "SIGKILL"
+)
 
-	// 根据操作系统选择shell
-	var cmd *exec.Cmd
-	if runtime.GOOS == "windows" {
-		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
-	} else {
-		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+// DefaultKillGracePeriod 默认发送 SIGTERM 后等待进程退出的时间
+const DefaultKillGracePeriod = 5 * time.Second
+
+// pipeCloseDelay 发送 SIGKILL 后仍有进程持有输出管道时，等待多久后强制关闭管道
+const pipeCloseDelay = 2 * time.Second
+
+var killGracePeriod atomic.Int64
+
+// SetKillGracePeriod 设置终止命令时 SIGTERM 与 SIGKILL 之间的宽限期，不大于 0 时使用默认值
+func SetKillGracePeriod(grace time.Duration) {
+	if grace <= 0 {
+		grace = DefaultKillGracePeriod
 	}
+	killGracePeriod.Store(int64(grace))
+}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+// MaxTerminationDelay 返回命令超时或取消后到执行返回的最长时间
+func MaxTerminationDelay() time.Duration {
+	return time.Duration(killGracePeriod.Load()) + pipeCloseDelay
+}
 
-	err := cmd.Run()
+// 输出流名称
+const (
+	OutputStdout = "stdout"
+	OutputStderr = "stderr"
+)
 
-	result.Stdout = strings.TrimSpace(stdout.String())
-	result.Stderr = strings.TrimSpace(stderr.String())
+// OutputHandler 命令输出回调，stdout 和 stderr 可能在不同 goroutine 中并发回调
+type OutputHandler func(stream string, data []byte)
 
-	if err != nil {
-		result.Error = err.Error()
-		if exitError, ok := err.(*exec.ExitError); ok {
-			result.ExitCode = exitError.ExitCode()
-		} else {
-			result.ExitCode = -1
-		}
-	} else {
-		result.ExitCode = 0
+// outputWriter 缓存输出的首尾部分，同时将每次写入转发给回调
+type outputWriter struct {
+	buffer  *limitedBuffer
+	stream  string
+	handler OutputHandler
+}
+
+func newOutputWriter(stream string, handler OutputHandler) *outputWriter {
+	return &outputWriter{
+		buffer:  newLimitedBuffer(int(maxOutputBytes.Load())),
+		stream:  stream,
+		handler: handler,
 	}
+}
 
-	return result
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.buffer.Write(p)
+	if w.handler != nil && len(p) > 0 {
+		data := make([]byte, len(p))
+		copy(data, p)
+		w.handler(w.stream, data)
+	}
+	return len(p), nil
+}
+
+// limitedBuffer 只保留前 limit/2 和后 limit/2 字节的输出缓冲，避免大输出占用内存
+type limitedBuffer struct {
+	head      []byte
+	tail      []byte
+	headLimit int
+	tailLimit int
+	total     int64
+}
+
+func newLimitedBuffer(limit int) *limitedBuffer {
+	headLimit := limit / 2
+	return &limitedBuffer{headLimit: headLimit, tailLimit: limit - headLimit}
+}
+
+func (b *limitedBuffer) Write(p []byte) {
+	b.total += int64(len(p))
+
+	if n := min(b.headLimit-len(b.head), len(p)); n > 0 {
+		b.head = append(b.head, p[:n]...)
+		p = p[n:]
+	}
+	if len(p) == 0 {
+		return
+	}
+
+	b.tail = append(b.tail, p...)
+	// 超出两倍上限时才丢弃旧数据，减少内存拷贝
+	if len(b.tail) > 2*b.tailLimit {
+		b.tail = append(b.tail[:0], b.tail[len(b.tail)-b.tailLimit:]...)
+	}
+}
+
+// Size 返回写入的原始字节数
+func (b *limitedBuffer) Size() int64 {
+	return b.total
+}
+
+// String 返回保留的输出及是否发生截断，截断处插入被省略的字节数
+func (b *limitedBuffer) String() (string, bool) {
+	tail := b.tail
+	if len(tail) > b.tailLimit {
+		tail = tail[len(tail)-b.tailLimit:]
+	}
+
+	omitted := b.total - int64(len(b.head)) - int64(len(tail))
+	if omitted <= 0 {
+		return string(b.head) + string(tail), false
+	}
+
+	// 截断位置可能落在多字节字符中间，去掉不完整的字符
+	return strings.ToValidUTF8(string(b.head), "") +
+		fmt.Sprintf("\n... [%d bytes truncated] ...\n", omitted) +
+		strings.ToValidUTF8(string(tail), ""), true
+}
+
+// fillOutput 将命令输出填入执行结果
+func (r *CommandResult) fillOutput(stdout, stderr *limitedBuffer) {
+	var stdoutTruncated, stderrTruncated bool
+	r.Stdout, stdoutTruncated = stdout.String()
+	r.Stderr, stderrTruncated = stderr.String()
+	r.Stdout = strings.TrimSpace(r.Stdout)
+	r.Stderr = strings.TrimSpace(r.Stderr)
+	r.StdoutSize = stdout.Size()
+	r.StderrSize = stderr.Size()
+	r.Truncated = stdoutTruncated || stderrTruncated
+}
+
+// ExecuteCommand 执行命令
+func ExecuteCommand(command string, timeout time.Duration) *CommandResult {
+	return ExecuteCommandWithOutput(command, timeout, nil)
+}
+
+// ExecuteCommandWithOutput 执行命令，执行过程中通过 output 增量回调完整输出
+// 返回结果中每个输出流最多保留 SetMaxOutputBytes 设置的字节数
+func ExecuteCommandWithOutput(command string, timeout time.Duration, output OutputHandler) *CommandResult {
+	return ExecuteCommandContext(context.Background(), command, timeout, nil, output, nil)
 }
 
 // ExecuteCommandWithInput 执行带输入的命令
 func ExecuteCommandWithInput(command, input string, timeout time.Duration) *CommandResult {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return ExecuteCommandContext(context.Background(), command, timeout, &ExecOptions{Stdin: []byte(input)}, nil, nil)
+}
+
+// ExecuteCommandContext 通过系统 shell 执行命令，ctx 取消或超时时终止命令的整个进程树
+// options 为空时以 Agent 运行用户在当前目录执行；started 不为空时在进程启动后回调，用于向进程发送信号
+func ExecuteCommandContext(ctx context.Context, command string, timeout time.Duration, options *ExecOptions, output OutputHandler, started func(*os.Process)) *CommandResult {
+	cmd, err := options.shellCommand(command)
+	return runCommand(ctx, command, cmd, err, options, timeout, output, started)
+}
+
+// runCommand 运行已构建的命令并收集执行结果，buildErr 为构建命令时的错误
+// 执行选项设置了资源限制时，命令在独立的临时 cgroup 中运行
+func runCommand(ctx context.Context, command string, cmd *exec.Cmd, buildErr error, options *ExecOptions, timeout time.Duration, output OutputHandler, started func(*os.Process)) *CommandResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	result := &CommandResult{
@@ -79,22 +218,31 @@ func ExecuteCommandWithInput(command, input string, timeout time.Duration) *Comm
 		result.Duration = time.Since(startTime)
 	}()
 
-	var cmd *exec.Cmd
-	if runtime.GOOS == "windows" {
-		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
-	} else {
-		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	if buildErr != nil {
+		result.Error = buildErr.Error()
+		result.ExitCode = -1
+		return result
 	}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	cmd.Stdin = strings.NewReader(input)
+	var cgroup *commandCgroup
+	if limits := options.resourceLimits(); limits != nil {
+		var err error
+		if cgroup, err = newCommandCgroup(limits); err != nil {
+			result.Error = err.Error()
+			result.ExitCode = -1
+			return result
+		}
+		defer cgroup.Remove()
+		cgroup.Apply(cmd)
+	}
 
-	err := cmd.Run()
+	stdout := newOutputWriter(OutputStdout, output)
+	stderr := newOutputWriter(OutputStderr, output)
 
-	result.Stdout = strings.TrimSpace(stdout.String())
-	result.Stderr = strings.TrimSpace(stderr.String())
+	signal, err := runProcessTree(ctx, cmd, stdout, stderr, started)
+
+	result.fillOutput(stdout.buffer, stderr.buffer)
+	result.Signal = signal
 
 	if err != nil {
 		result.Error = err.Error()
@@ -107,27 +255,147 @@ func ExecuteCommandWithInput(command, input string, timeout time.Duration) *Comm
 		result.ExitCode = 0
 	}
 
+	if cgroup != nil && cgroup.OOMKilled() {
+		result.OOMKilled = true
+		result.Error = fmt.Sprintf("command killed by OOM killer: memory limit of %d bytes exceeded", options.Resources.MemoryBytes)
+		if result.ExitCode == 0 {
+			result.ExitCode = -1
+		}
+	}
+
+	if signal != "" {
+		if ctx.Err() == context.DeadlineExceeded {
+			result.TimedOut = true
+			result.Error = fmt.Sprintf("command timed out after %s, terminated with %s", timeout, signal)
+		} else {
+			result.Error = fmt.Sprintf("command canceled, terminated with %s", signal)
+		}
+		if result.ExitCode == 0 {
+			result.ExitCode = -1
+		}
+	}
+
 	return result
 }
 
-// ValidateCommand 验证命令是否安全
-func ValidateCommand(command string) error {
-	// 基本的命令安全检查
-	dangerousCommands := []string{
-		"rm -rf /",
-		"format",
-		"del /f /s /q",
-		"shutdown",
-		"reboot",
-		"halt",
+// runProcessTree 在独立进程组中运行命令并等待其结束，返回终止命令时最终发送的信号
+// 命令退出后，后台子进程仍持有输出管道时会继续等待，直到这些进程退出或 ctx 结束
+// ctx 结束时向整个进程组发送 SIGTERM，宽限期后仍未结束则发送 SIGKILL
+func runProcessTree(ctx context.Context, cmd *exec.Cmd, stdout, stderr io.Writer, started func(*os.Process)) (string, error) {
+	setProcessGroup(cmd)
+
+	// 自行创建输出管道，命令退出后仍能在终止进程树时关闭管道
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	stderrReader, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdoutReader.Close()
+		stdoutWriter.Close()
+		return "", err
+	}
+	defer stdoutReader.Close()
+	defer stderrReader.Close()
+
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
+	err = cmd.Start()
+	// 写端已由子进程继承，持有写端的进程全部退出后读端返回 EOF
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	if err != nil {
+		return "", err
+	}
+	if started != nil {
+		started(cmd.Process)
+	}
+
+	var copying sync.WaitGroup
+	copying.Add(2)
+	go func() {
+		defer copying.Done()
+		io.Copy(stdout, stdoutReader)
+	}()
+	go func() {
+		defer copying.Done()
+		io.Copy(stderr, stderrReader)
+	}()
+
+	killer := &processTreeKiller{
+		process: cmd.Process,
+		grace:   time.Duration(killGracePeriod.Load()),
+		pipes:   []io.Closer{stdoutReader, stderrReader},
+		done:    make(chan struct{}),
+	}
+	go killer.watch(ctx)
+
+	err = cmd.Wait()
+	copying.Wait()
+	close(killer.done)
+
+	return killer.finalSignal(), err
+}
+
+// processTreeKiller 按 SIGTERM、宽限期、SIGKILL 的顺序终止命令进程树
+type processTreeKiller struct {
+	process *os.Process
+	grace   time.Duration
+	pipes   []io.Closer
+	done    chan struct{} // 命令及持有输出管道的进程全部结束后关闭
+
+	mutex  sync.Mutex
+	signal string
+}
+
+// watch 等待 ctx 结束后终止进程树
+func (k *processTreeKiller) watch(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-k.done:
+		return
 	}
 
-	lowerCmd := strings.ToLower(strings.TrimSpace(command))
-	for _, dangerous := range dangerousCommands {
-		if strings.Contains(lowerCmd, dangerous) {
-			return fmt.Errorf("dangerous command detected: %s", dangerous)
+	if err := terminateProcessTree(k.process); err == nil {
+		k.setSignal(SignalTerminate)
+		if k.wait(k.grace) {
+			return
 		}
 	}
 
-	return nil
+	killProcessTree(k.process)
+	k.setSignal(SignalKill)
+	if k.wait(pipeCloseDelay) {
+		return
+	}
+
+	// 脱离进程组的进程仍持有输出管道，关闭管道结束等待
+	for _, pipe := range k.pipes {
+		pipe.Close()
+	}
+}
+
+// wait 等待命令结束，超时返回 false
+func (k *processTreeKiller) wait(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-k.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (k *processTreeKiller) setSignal(signal string) {
+	k.mutex.Lock()
+	k.signal = signal
+	k.mutex.Unlock()
+}
+
+func (k *processTreeKiller) finalSignal() string {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.signal
 }
@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ExecOptions 命令执行选项
+type ExecOptions struct {
+	User       string            // 执行用户（用户名或 UID），为空时以 Agent 运行用户执行
+	Group      string            // 执行用户组（组名或 GID），为空时使用执行用户的主组
+	WorkingDir string            // 工作目录，为空时切换用户则使用其主目录，否则使用 Agent 当前目录
+	Env        map[string]string // 追加的环境变量，同名时覆盖
+	Umask      string            // 八进制权限掩码
+	Stdin      []byte            // 标准输入内容
+	Resources  *ResourceLimits   // 资源限制，仅 Linux cgroup v2 支持
+}
+
+// ResourceLimits 命令资源限制，字段为 0 时不限制
+type ResourceLimits struct {
+	CPUQuota    float64 // CPU 上限（核数）
+	MemoryBytes int64   // 内存上限（字节）
+	IOWeight    uint32  // IO 权重，1-10000
+	MaxPIDs     int64   // 最大进程数
+}
+
+// isZero 检查是否未设置任何资源限制
+func (l *ResourceLimits) isZero() bool {
+	return l == nil || *l == ResourceLimits{}
+}
+
+// resourceLimits 返回执行选项中的资源限制，未设置时返回 nil
+func (o *ExecOptions) resourceLimits() *ResourceLimits {
+	if o == nil || o.Resources.isZero() {
+		return nil
+	}
+	return o.Resources
+}
+
+// defaultPath 切换执行用户后使用的 PATH，避免继承 Agent 的环境
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// shellCommand 按执行选项构建通过系统 shell 执行的命令
+func (o *ExecOptions) shellCommand(command string) (*exec.Cmd, error) {
+	// 根据操作系统选择shell
+	if runtime.GOOS == "windows" {
+		return o.command("cmd", "/C", command)
+	}
+	return o.command("sh", "-c", command)
+}
+
+// command 按执行选项构建命令，设置 umask 时先由 sh 设置 umask 再 exec 目标程序
+func (o *ExecOptions) command(name string, args ...string) (*exec.Cmd, error) {
+	if o == nil {
+		o = &ExecOptions{}
+	}
+
+	// 切换执行用户时按该用户的 PATH 查找程序，而不是 Agent 的 PATH
+	shell := "sh"
+	if o.User != "" {
+		name = lookPathIn(name, defaultPath)
+		shell = lookPathIn(shell, defaultPath)
+	}
+
+	var cmd *exec.Cmd
+	if o.Umask != "" {
+		umask, err := strconv.ParseUint(o.Umask, 8, 32)
+		if err != nil || umask > 0777 {
+			return nil, fmt.Errorf("invalid umask: %s", o.Umask)
+		}
+		if runtime.GOOS == "windows" {
+			return nil, fmt.Errorf("umask is not supported on windows")
+		}
+		wrapper := fmt.Sprintf(`umask %04o && exec "$@"`, umask)
+		cmd = exec.Command(shell, append([]string{"-c", wrapper, "sh", name}, args...)...)
+	} else {
+		cmd = exec.Command(name, args...)
+	}
+
+	if o.WorkingDir != "" {
+		if !filepath.IsAbs(o.WorkingDir) {
+			return nil, fmt.Errorf("working directory must be an absolute path: %s", o.WorkingDir)
+		}
+		cmd.Dir = o.WorkingDir
+	}
+
+	if o.User != "" {
+		account, err := lookupUser(o.User)
+		if err != nil {
+			return nil, err
+		}
+		if err := setCredential(cmd, account, o.Group); err != nil {
+			return nil, err
+		}
+		if cmd.Dir == "" {
+			cmd.Dir = homeDir(account)
+		}
+		cmd.Env = userEnv(account)
+	}
+
+	if len(o.Env) > 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		keys := make([]string, 0, len(o.Env))
+		for key := range o.Env {
+			if key == "" || strings.ContainsAny(key, "=\x00") {
+				return nil, fmt.Errorf("invalid environment variable name: %q", key)
+			}
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		// 同名变量以最后出现的为准
+		for _, key := range keys {
+			cmd.Env = append(cmd.Env, key+"="+o.Env[key])
+		}
+	}
+
+	if len(o.Stdin) > 0 {
+		cmd.Stdin = bytes.NewReader(o.Stdin)
+	}
+
+	return cmd, nil
+}
+
+// lookPathIn 在 path 列出的目录中查找可执行程序，name 含路径或未找到时原样返回
+func lookPathIn(name, path string) string {
+	if strings.ContainsRune(name, filepath.Separator) {
+		return name
+	}
+	for _, dir := range filepath.SplitList(path) {
+		candidate := filepath.Join(dir, name)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return candidate
+		}
+	}
+	return name
+}
+
+// lookupUser 按用户名或 UID 查找用户
+func lookupUser(name string) (*user.User, error) {
+	account, err := user.Lookup(name)
+	if err == nil {
+		return account, nil
+	}
+	if _, convErr := strconv.ParseUint(name, 10, 32); convErr == nil {
+		if account, idErr := user.LookupId(name); idErr == nil {
+			return account, nil
+		}
+	}
+	return nil, fmt.Errorf("user %s not found: %w", name, err)
+}
+
+// lookupGroup 按组名或 GID 查找用户组
+func lookupGroup(name string) (*user.Group, error) {
+	group, err := user.LookupGroup(name)
+	if err == nil {
+		return group, nil
+	}
+	if _, convErr := strconv.ParseUint(name, 10, 32); convErr == nil {
+		if group, idErr := user.LookupGroupId(name); idErr == nil {
+			return group, nil
+		}
+	}
+	return nil, fmt.Errorf("group %s not found: %w", name, err)
+}
+
+// UserInGroup 检查用户组是否为用户的主组或附加组，用户和用户组均可为名称或 ID
+func UserInGroup(userName, groupName string) (bool, error) {
+	account, err := lookupUser(userName)
+	if err != nil {
+		return false, err
+	}
+	group, err := lookupGroup(groupName)
+	if err != nil {
+		return false, err
+	}
+	if account.Gid == group.Gid {
+		return true, nil
+	}
+	groupIDs, err := account.GroupIds()
+	if err != nil {
+		return false, fmt.Errorf("failed to list groups of user %s: %w", account.Username, err)
+	}
+	for _, id := range groupIDs {
+		if id == group.Gid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// homeDir 返回用户主目录，主目录不存在时（如 nobody）使用根目录
+func homeDir(account *user.User) string {
+	if info, err := os.Stat(account.HomeDir); err == nil && info.IsDir() {
+		return account.HomeDir
+	}
+	return string(filepath.Separator)
+}
+
+// userEnv 构建切换用户后的最小环境变量，不继承 Agent 的环境
+func userEnv(account *user.User) []string {
+	env := []string{
+		"PATH=" + defaultPath,
+		"HOME=" + account.HomeDir,
+		"USER=" + account.Username,
+		"LOGNAME=" + account.Username,
+	}
+	if lang := os.Getenv("LANG"); lang != "" {
+		env = append(env, "LANG="+lang)
+	}
+	return env
+}
@@ -6,6 +6,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // FileInfo 文件信息结构
@@ -87,3 +89,95 @@ func CopyFile(src, dst string) error {
 	_, err = io.Copy(dstFile, srcFile)
 	return err
 }
+
+// ChownFile 修改文件属主，owner 为空时不修改，group 为空时使用属主的主组
+func ChownFile(path, owner, group string) error {
+	if owner == "" {
+		return nil
+	}
+	account, err := lookupUser(owner)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.Atoi(account.Uid)
+	if err != nil {
+		return fmt.Errorf("invalid uid %s of user %s", account.Uid, account.Username)
+	}
+	gidText := account.Gid
+	if group != "" {
+		target, err := lookupGroup(group)
+		if err != nil {
+			return err
+		}
+		gidText = target.Gid
+	}
+	gid, err := strconv.Atoi(gidText)
+	if err != nil {
+		return fmt.Errorf("invalid gid %s", gidText)
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// ParseFileMode 将八进制权限（含 setuid、setgid、sticky 位）转换为 os.FileMode
+func ParseFileMode(mode string, defaultMode os.FileMode) (os.FileMode, error) {
+	if mode == "" {
+		return defaultMode, nil
+	}
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 07777 {
+		return 0, fmt.Errorf("invalid file mode: %s", mode)
+	}
+	result := os.FileMode(value & 0777)
+	if value&04000 != 0 {
+		result |= os.ModeSetuid
+	}
+	if value&02000 != 0 {
+		result |= os.ModeSetgid
+	}
+	if value&01000 != 0 {
+		result |= os.ModeSticky
+	}
+	return result, nil
+}
+
+// SyncDir 将目录项的变更（如重命名）落盘，不支持的平台忽略错误
+func SyncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// ResolvePath 解析路径中的符号链接，返回绝对路径的真实位置
+// 路径末尾尚不存在的部分原样拼接在已存在部分的真实路径之后；悬空的符号链接返回错误
+func ResolvePath(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	var missing []string
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if _, lstatErr := os.Lstat(path); lstatErr == nil {
+			return "", fmt.Errorf("dangling symbolic link: %s", path)
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		missing = append([]string{filepath.Base(path)}, missing...)
+		path = parent
+	}
+}
+
+// PathWithin 判断 path 是否位于目录 dir 之内（不含 dir 本身），两者均应为已解析的路径
+func PathWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
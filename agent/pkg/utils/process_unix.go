@@ -0,0 +1,193 @@
+//go:build !windows
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// sysProcAttr 返回命令的进程属性，不存在时创建
+func sysProcAttr(cmd *exec.Cmd) *syscall.SysProcAttr {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	return cmd.SysProcAttr
+}
+
+// setProcessGroup 使命令在独立的进程组中运行，便于终止其派生的所有子进程
+func setProcessGroup(cmd *exec.Cmd) {
+	sysProcAttr(cmd).Setpgid = true
+}
+
+// setCredential 使命令以指定用户运行，group 为空时使用用户的主组，并加入用户的附加组
+// Agent 非 root 运行时只能以自身用户执行
+func setCredential(cmd *exec.Cmd, account *user.User, group string) error {
+	uid, err := strconv.ParseUint(account.Uid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid uid %s of user %s", account.Uid, account.Username)
+	}
+	gidText := account.Gid
+	if group != "" {
+		target, err := lookupGroup(group)
+		if err != nil {
+			return err
+		}
+		gidText = target.Gid
+	}
+	gid, err := strconv.ParseUint(gidText, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid gid %s", gidText)
+	}
+
+	if os.Geteuid() != 0 {
+		if int(uid) == os.Geteuid() && int(gid) == os.Getegid() {
+			return nil
+		}
+		return fmt.Errorf("agent is not running as root, cannot run command as %s", account.Username)
+	}
+
+	var groups []uint32
+	if groupIDs, err := account.GroupIds(); err == nil {
+		for _, id := range groupIDs {
+			if value, err := strconv.ParseUint(id, 10, 32); err == nil {
+				groups = append(groups, uint32(value))
+			}
+		}
+	}
+
+	sysProcAttr(cmd).Credential = &syscall.Credential{
+		Uid:    uint32(uid),
+		Gid:    uint32(gid),
+		Groups: groups,
+	}
+	return nil
+}
+
+// CheckReadAccess 按权限位检查执行用户能否读取文件，包括进入其所在的各级目录
+// userName 为空时以 Agent 进程用户读取，由操作系统检查；group 不为空时代替用户的主组
+func CheckReadAccess(userName, group, path string) error {
+	if userName == "" {
+		return nil
+	}
+	account, err := lookupUser(userName)
+	if err != nil {
+		return err
+	}
+	if account.Uid == "0" {
+		return nil
+	}
+	gids := make(map[string]bool)
+	if group != "" {
+		target, err := lookupGroup(group)
+		if err != nil {
+			return err
+		}
+		gids[target.Gid] = true
+	} else {
+		gids[account.Gid] = true
+	}
+	if groupIDs, err := account.GroupIds(); err == nil {
+		for _, id := range groupIDs {
+			gids[id] = true
+		}
+	}
+
+	allowed := func(target string, perm os.FileMode) bool {
+		info, err := os.Stat(target)
+		if err != nil {
+			return false
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return false
+		}
+		mode := info.Mode().Perm()
+		switch {
+		case strconv.FormatUint(uint64(stat.Uid), 10) == account.Uid:
+			return mode&(perm<<6) != 0
+		case gids[strconv.FormatUint(uint64(stat.Gid), 10)]:
+			return mode&(perm<<3) != 0
+		default:
+			return mode&perm != 0
+		}
+	}
+
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if !allowed(dir, 01) {
+			return fmt.Errorf("user %s cannot access directory %s", account.Username, dir)
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+	if !allowed(path, 04) {
+		return fmt.Errorf("user %s cannot read %s", account.Username, path)
+	}
+	return nil
+}
+
+// chownToCredential 命令切换了执行用户时，将文件属主改为该用户
+func chownToCredential(cmd *exec.Cmd, paths ...string) error {
+	if cmd.SysProcAttr == nil || cmd.SysProcAttr.Credential == nil {
+		return nil
+	}
+	credential := cmd.SysProcAttr.Credential
+	for _, path := range paths {
+		if err := os.Chown(path, int(credential.Uid), int(credential.Gid)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signalProcessGroup 向进程所在的进程组发送信号，进程组已全部退出时不报错
+func signalProcessGroup(process *os.Process, signal syscall.Signal) error {
+	err := syscall.Kill(-process.Pid, signal)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+// terminateProcessTree 请求进程树退出，已暂停的进程需要恢复后才能处理 SIGTERM
+func terminateProcessTree(process *os.Process) error {
+	if err := signalProcessGroup(process, syscall.SIGTERM); err != nil {
+		return err
+	}
+	return signalProcessGroup(process, syscall.SIGCONT)
+}
+
+// killProcessTree 强制结束进程树
+func killProcessTree(process *os.Process) error {
+	return signalProcessGroup(process, syscall.SIGKILL)
+}
+
+// SignalProcess 向命令进程树发送信号
+func SignalProcess(process *os.Process, signal int) error {
+	return signalProcessGroup(process, syscall.Signal(signal))
+}
+
+// SuspendProcess 暂停命令进程树
+func SuspendProcess(process *os.Process) error {
+	return signalProcessGroup(process, syscall.SIGSTOP)
+}
+
+// ResumeProcess 恢复已暂停的命令进程树
+func ResumeProcess(process *os.Process) error {
+	return signalProcessGroup(process, syscall.SIGCONT)
+}
+
+// ExecSupported 当前平台支持以新的可执行文件替换当前进程
+const ExecSupported = true
+
+// ExecSelf 以 path 替换当前进程，沿用当前进程的参数和环境变量，成功时不返回
+func ExecSelf(path string) error {
+	return syscall.Exec(path, os.Args, os.Environ())
+}
@@ -0,0 +1,77 @@
+//go:build windows
+
+package utils
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// errUnsupportedOnWindows Windows 不支持的进程控制操作
+var errUnsupportedOnWindows = errors.New("not supported on windows")
+
+// setProcessGroup 使命令在独立的进程组中运行
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
+
+// setCredential Windows 不支持切换执行用户
+func setCredential(cmd *exec.Cmd, account *user.User, group string) error {
+	return errUnsupportedOnWindows
+}
+
+// CheckReadAccess Windows 不支持切换执行用户，只能以 Agent 进程用户读取
+func CheckReadAccess(userName, group, path string) error {
+	if userName == "" {
+		return nil
+	}
+	return errUnsupportedOnWindows
+}
+
+// chownToCredential Windows 不切换执行用户，无需修改文件属主
+func chownToCredential(cmd *exec.Cmd, paths ...string) error {
+	return nil
+}
+
+// terminateProcessTree Windows 控制台程序无法可靠地优雅退出，由调用方直接强制结束
+func terminateProcessTree(process *os.Process) error {
+	return errUnsupportedOnWindows
+}
+
+// killProcessTree 通过 taskkill 强制结束进程及其所有子进程
+func killProcessTree(process *os.Process) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(process.Pid)).Run()
+}
+
+// SignalProcess 向进程发送信号，Windows 仅支持 SIGKILL(9)，结束整个进程树
+func SignalProcess(process *os.Process, signal int) error {
+	if signal != 9 {
+		return errUnsupportedOnWindows
+	}
+	return killProcessTree(process)
+}
+
+// SuspendProcess 暂停进程，Windows 不支持
+func SuspendProcess(process *os.Process) error {
+	return errUnsupportedOnWindows
+}
+
+// ResumeProcess 恢复已暂停的进程，Windows 不支持
+func ResumeProcess(process *os.Process) error {
+	return errUnsupportedOnWindows
+}
+
+// ExecSupported Windows 不支持替换当前进程
+const ExecSupported = false
+
+// ExecSelf 替换当前进程，Windows 不支持
+func ExecSelf(path string) error {
+	return errUnsupportedOnWindows
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 支持的脚本解释器及临时脚本文件扩展名
+var scriptInterpreters = map[string]string{
+	"bash":    ".sh",
+	"sh":      ".sh",
+	"python3": ".py",
+	"perl":    ".pl",
+}
+
+// ExecuteScriptContext 将脚本写入私有临时文件后由 interpreter 解释执行，执行结束后删除临时文件
+// 临时文件所在目录仅执行用户可访问；其余参数与 ExecuteCommandContext 相同
+func ExecuteScriptContext(ctx context.Context, interpreter, script string, args []string, timeout time.Duration, options *ExecOptions, output OutputHandler, started func(*os.Process)) *CommandResult {
+	command := strings.TrimSpace(interpreter + " <script> " + strings.Join(args, " "))
+
+	ext, ok := scriptInterpreters[interpreter]
+	if !ok {
+		return runCommand(ctx, command, nil, fmt.Errorf("unsupported script interpreter: %s", interpreter), options, timeout, output, started)
+	}
+
+	dir, err := os.MkdirTemp("", "devops-script-")
+	if err != nil {
+		return runCommand(ctx, command, nil, fmt.Errorf("failed to create script directory: %w", err), options, timeout, output, started)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "script"+ext)
+	cmd, err := options.command(interpreter, append([]string{path}, args...)...)
+	if err == nil {
+		err = writeScriptFile(dir, path, script, cmd)
+	}
+	return runCommand(ctx, command, cmd, err, options, timeout, output, started)
+}
+
+// writeScriptFile 写入脚本文件，命令切换了执行用户时将目录和文件属主改为该用户
+func writeScriptFile(dir, path, script string, cmd *exec.Cmd) error {
+	if err := os.WriteFile(path, []byte(script), 0600); err != nil {
+		return fmt.Errorf("failed to write script: %w", err)
+	}
+	if err := chownToCredential(cmd, dir, path); err != nil {
+		return fmt.Errorf("failed to change script owner: %w", err)
+	}
+	return nil
+}
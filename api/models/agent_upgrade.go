@@ -0,0 +1,187 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"devops-manager/api/protobuf"
+)
+
+// UpgradeSpec Agent 自升级参数，新版本二进制作为文件随命令分发
+type UpgradeSpec struct {
+	Version          string `json:"version"`             // 目标版本
+	Signature        string `json:"signature,omitempty"` // 二进制内容的 Ed25519 签名（Base64）
+	ReconnectTimeout int64  `json:"reconnect_timeout"`   // 新版本启动后连上 Server 的期限（秒），超过时 Agent 回滚
+}
+
+// Scan 实现 sql.Scanner 接口
+func (u *UpgradeSpec) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, u)
+	case string:
+		return json.Unmarshal([]byte(v), u)
+	default:
+		*u = UpgradeSpec{}
+		return nil
+	}
+}
+
+// Value 实现 driver.Valuer 接口
+func (u UpgradeSpec) Value() (driver.Value, error) {
+	return json.Marshal(u)
+}
+
+// Validate 检查自升级参数
+func (u *UpgradeSpec) Validate() error {
+	if u == nil {
+		return nil
+	}
+	if u.Version == "" {
+		return fmt.Errorf("version is required")
+	}
+	if len(u.Version) > 64 || strings.ContainsAny(u.Version, " \t\r\n\x00") {
+		return fmt.Errorf("invalid version: %q", u.Version)
+	}
+	if u.Signature != "" {
+		if _, err := base64.StdEncoding.DecodeString(u.Signature); err != nil {
+			return fmt.Errorf("signature must be base64 encoded: %w", err)
+		}
+	}
+	if u.ReconnectTimeout <= 0 {
+		return fmt.Errorf("reconnect_timeout must be positive")
+	}
+	return nil
+}
+
+// Description 自升级命令的描述，保存在命令的 Command 字段中
+func (u *UpgradeSpec) Description() string {
+	return "agent upgrade: " + u.Version
+}
+
+// ToProtobuf 转换为 protobuf UpgradeSpec 格式，为空时返回 nil
+func (u *UpgradeSpec) ToProtobuf() *protobuf.UpgradeSpec {
+	if u == nil {
+		return nil
+	}
+	return &protobuf.UpgradeSpec{
+		Version:          u.Version,
+		Signature:        u.Signature,
+		ReconnectTimeout: u.ReconnectTimeout,
+	}
+}
+
+// CreateUpgradeSpecFromProtobuf 从 protobuf UpgradeSpec 创建自升级参数
+func CreateUpgradeSpecFromProtobuf(upgrade *protobuf.UpgradeSpec) *UpgradeSpec {
+	if upgrade == nil {
+		return nil
+	}
+	return &UpgradeSpec{
+		Version:          upgrade.Version,
+		Signature:        upgrade.Signature,
+		ReconnectTimeout: upgrade.ReconnectTimeout,
+	}
+}
+
+// UpgradeCampaignStatus 升级批次计划状态
+type UpgradeCampaignStatus string
+
+const (
+	UpgradeCampaignStatusRunning   UpgradeCampaignStatus = "running"   // 进行中
+	UpgradeCampaignStatusCompleted UpgradeCampaignStatus = "completed" // 所有批次已完成
+	UpgradeCampaignStatusFailed    UpgradeCampaignStatus = "failed"    // 失败主机超过上限，停止后续批次
+	UpgradeCampaignStatusCanceled  UpgradeCampaignStatus = "canceled"  // 已取消
+)
+
+// UpgradeCampaign Agent 升级计划，目标主机按批次依次升级
+type UpgradeCampaign struct {
+	ID               uint                  `json:"id" gorm:"primaryKey"`
+	CampaignID       string                `json:"campaign_id" gorm:"uniqueIndex;size:255;not null;comment:升级计划唯一标识"`
+	Version          string                `json:"version" gorm:"size:64;not null;comment:目标版本"`
+	SHA256           string                `json:"sha256" gorm:"size:64;not null;comment:新版本二进制的 SHA-256"`
+	Size             int64                 `json:"size" gorm:"comment:新版本二进制的字节数"`
+	Signature        string                `json:"signature,omitempty" gorm:"type:text;comment:二进制内容的 Ed25519 签名"`
+	BatchSize        int                   `json:"batch_size" gorm:"comment:每批升级的主机数"`
+	MaxFailures      int                   `json:"max_failures" gorm:"comment:允许失败的主机数，超过时停止后续批次"`
+	ReconnectTimeout int64                 `json:"reconnect_timeout" gorm:"comment:新版本连上 Server 的期限(秒)"`
+	Timeout          int64                 `json:"timeout" gorm:"comment:下载和校验的超时时间(秒)"`
+	Status           UpgradeCampaignStatus `json:"status" gorm:"size:20;default:running;comment:升级计划状态"`
+	CurrentBatch     int                   `json:"current_batch" gorm:"default:0;comment:当前批次，从 1 开始"`
+	TotalBatches     int                   `json:"total_batches" gorm:"default:0;comment:总批次数"`
+	TotalHosts       int                   `json:"total_hosts" gorm:"default:0;comment:总主机数"`
+	SucceededHosts   int                   `json:"succeeded_hosts" gorm:"default:0;comment:升级成功的主机数"`
+	FailedHosts      int                   `json:"failed_hosts" gorm:"default:0;comment:升级失败或已回滚的主机数"`
+	ErrorMessage     string                `json:"error_message" gorm:"type:text;comment:停止原因"`
+	CreatedBy        string                `json:"created_by" gorm:"size:255;comment:创建者"`
+	FinishedAt       *time.Time            `json:"finished_at" gorm:"comment:结束时间"`
+	CreatedAt        time.Time             `json:"created_at"`
+	UpdatedAt        time.Time             `json:"updated_at"`
+
+	Hosts []UpgradeCampaignHost `json:"hosts,omitempty" gorm:"-"`
+}
+
+// TableName 指定表名
+func (UpgradeCampaign) TableName() string {
+	return "upgrade_campaigns"
+}
+
+// IsFinished 检查升级计划是否已结束
+func (c *UpgradeCampaign) IsFinished() bool {
+	return c.Status != UpgradeCampaignStatusRunning
+}
+
+// UpgradeHostStatus 主机升级状态
+type UpgradeHostStatus string
+
+const (
+	UpgradeHostStatusPending    UpgradeHostStatus = "pending"     // 等待所在批次开始
+	UpgradeHostStatusUpgrading  UpgradeHostStatus = "upgrading"   // 升级命令已下发，Agent 下载并校验新版本
+	UpgradeHostStatusRestarting UpgradeHostStatus = "restarting"  // Agent 已切换到新版本，等待其重新连接
+	UpgradeHostStatusSucceeded  UpgradeHostStatus = "succeeded"   // 以新版本重新连接
+	UpgradeHostStatusFailed     UpgradeHostStatus = "failed"      // 下发、下载或校验失败，或未在期限内重新连接
+	UpgradeHostStatusRolledBack UpgradeHostStatus = "rolled_back" // 新版本未能连接，Agent 已回滚到旧版本
+	UpgradeHostStatusSkipped    UpgradeHostStatus = "skipped"     // 已是目标版本
+	UpgradeHostStatusCanceled   UpgradeHostStatus = "canceled"    // 升级计划停止时尚未开始
+)
+
+// IsFinished 检查主机升级是否已结束
+func (s UpgradeHostStatus) IsFinished() bool {
+	switch s {
+	case UpgradeHostStatusPending, UpgradeHostStatusUpgrading, UpgradeHostStatusRestarting:
+		return false
+	default:
+		return true
+	}
+}
+
+// IsFailure 检查主机升级是否计入失败
+func (s UpgradeHostStatus) IsFailure() bool {
+	return s == UpgradeHostStatusFailed || s == UpgradeHostStatusRolledBack
+}
+
+// UpgradeCampaignHost 升级计划中的主机
+type UpgradeCampaignHost struct {
+	ID           uint              `json:"id" gorm:"primaryKey"`
+	CampaignID   string            `json:"campaign_id" gorm:"index;size:255;not null;comment:升级计划ID"`
+	HostID       string            `json:"host_id" gorm:"index;size:255;not null;comment:主机ID"`
+	Batch        int               `json:"batch" gorm:"comment:批次，从 1 开始"`
+	Status       UpgradeHostStatus `json:"status" gorm:"size:20;default:pending;comment:升级状态"`
+	FromVersion  string            `json:"from_version" gorm:"size:64;comment:升级前的 Agent 版本"`
+	TaskID       string            `json:"task_id,omitempty" gorm:"size:255;comment:所在批次的升级任务ID"`
+	CommandID    string            `json:"command_id,omitempty" gorm:"size:255;comment:升级命令ID"`
+	ErrorMessage string            `json:"error_message" gorm:"type:text;comment:失败原因"`
+	Deadline     *time.Time        `json:"deadline,omitempty" gorm:"comment:等待重新连接的截止时间"`
+	StartedAt    *time.Time        `json:"started_at" gorm:"comment:开始升级时间"`
+	FinishedAt   *time.Time        `json:"finished_at" gorm:"comment:升级结束时间"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// TableName 指定表名
+func (UpgradeCampaignHost) TableName() string {
+	return "upgrade_campaign_hosts"
+}
@@ -23,23 +23,31 @@ const (
 
 // Command 命令模型
 type Command struct {
-	ID         uint           `json:"id" gorm:"primaryKey"`
-	CommandID  string         `json:"command_id" gorm:"uniqueIndex;size:255;not null;comment:命令唯一标识"`
-	TaskID     *string        `json:"task_id" gorm:"size:255;comment:所属任务ID"`
-	HostID     string         `json:"host_id" gorm:"size:255;not null;comment:目标主机ID"`
-	Command    string         `json:"command" gorm:"type:text;not null;comment:命令内容"`
-	Parameters string         `json:"parameters" gorm:"type:text;comment:命令参数"`
-	Timeout    int64          `json:"timeout" gorm:"comment:超时时间(秒)"`
-	Status     CommandStatus  `json:"status" gorm:"size:20;default:pending;comment:命令状态"`
-	Stdout     string         `json:"stdout" gorm:"type:longtext;comment:标准输出"`
-	Stderr     string         `json:"stderr" gorm:"type:longtext;comment:错误输出"`
-	ExitCode   *int32         `json:"exit_code" gorm:"comment:退出码"`
-	StartedAt  *time.Time     `json:"started_at" gorm:"comment:开始执行时间"`
-	FinishedAt *time.Time     `json:"finished_at" gorm:"comment:完成时间"`
-	ErrorMsg   string         `json:"error_message" gorm:"type:text;comment:执行错误信息"`
-	CreatedAt  time.Time      `json:"created_at"`
-	UpdatedAt  time.Time      `json:"updated_at"`
-	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index"`
+	ID          uint              `json:"id" gorm:"primaryKey"`
+	CommandID   string            `json:"command_id" gorm:"uniqueIndex;size:255;not null;comment:命令唯一标识"`
+	TaskID      *string           `json:"task_id" gorm:"size:255;comment:所属任务ID"`
+	HostID      string            `json:"host_id" gorm:"size:255;not null;comment:目标主机ID"`
+	Command     string            `json:"command" gorm:"type:text;not null;comment:命令内容"`
+	Script      *ScriptSpec       `json:"script,omitempty" gorm:"type:json;comment:脚本执行参数"`
+	File        *FileSpec         `json:"file,omitempty" gorm:"type:json;comment:文件分发参数"`
+	Fetch       *FetchSpec        `json:"fetch,omitempty" gorm:"type:json;comment:文件收集参数"`
+	Upgrade     *UpgradeSpec      `json:"upgrade,omitempty" gorm:"type:json;comment:Agent 自升级参数"`
+	Parameters  string            `json:"parameters" gorm:"type:text;comment:命令参数"`
+	Timeout     int64             `json:"timeout" gorm:"comment:超时时间(秒)"`
+	RequestedBy string            `json:"requested_by" gorm:"size:64;comment:发起用户"`
+	Options     *ExecutionOptions `json:"options,omitempty" gorm:"type:json;comment:执行选项"`
+	Status      CommandStatus     `json:"status" gorm:"size:20;default:pending;comment:命令状态"`
+	Attempt     uint32            `json:"attempt" gorm:"default:0;comment:执行次数，手动重试时递增"`
+	Stdout      string            `json:"stdout" gorm:"type:longtext;comment:标准输出"`
+	Stderr      string            `json:"stderr" gorm:"type:longtext;comment:错误输出"`
+	Truncated   bool              `json:"truncated" gorm:"default:false;comment:输出是否被截断"`
+	ExitCode    *int32            `json:"exit_code" gorm:"comment:退出码"`
+	StartedAt   *time.Time        `json:"started_at" gorm:"comment:开始执行时间"`
+	FinishedAt  *time.Time        `json:"finished_at" gorm:"comment:完成时间"`
+	ErrorMsg    string            `json:"error_message" gorm:"type:text;comment:执行错误信息"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt    `json:"-" gorm:"index"`
 
 	// 关联关系 - 不设置外键约束，避免迁移问题
 	Task          *Task          `json:"task,omitempty" gorm:"-"`
@@ -62,12 +70,19 @@ func (c *Command) ToProtobufContent() *protobuf.CommandContent {
 	}
 
 	return &protobuf.CommandContent{
-		CommandId:  c.CommandID,
-		HostId:     c.HostID,
-		Command:    c.Command,
-		Parameters: c.Parameters, // 现在直接使用 string 类型
-		Timeout:    timeout,
-		CreatedAt:  timestamppb.New(c.CreatedAt),
+		CommandId:   c.CommandID,
+		HostId:      c.HostID,
+		Command:     c.Command,
+		Parameters:  c.Parameters, // 现在直接使用 string 类型
+		Timeout:     timeout,
+		CreatedAt:   timestamppb.New(c.CreatedAt),
+		RequestedBy: c.RequestedBy,
+		Options:     c.Options.ToProtobuf(),
+		Script:      c.Script.ToProtobuf(),
+		File:        c.File.ToProtobuf(),
+		Fetch:       c.Fetch.ToProtobuf(),
+		Upgrade:     c.Upgrade.ToProtobuf(),
+		Attempt:     c.Attempt,
 	}
 }
 
@@ -105,6 +120,12 @@ func (c *Command) FromProtobufContent(content *protobuf.CommandContent) {
 
 	// 直接使用 string 类型的参数
 	c.Parameters = content.Parameters
+	c.RequestedBy = content.RequestedBy
+	c.Options = CreateExecutionOptionsFromProtobuf(content.Options)
+	c.Script = CreateScriptSpecFromProtobuf(content.Script)
+	c.File = CreateFileSpecFromProtobuf(content.File)
+	c.Fetch = CreateFetchSpecFromProtobuf(content.Fetch)
+	c.Upgrade = CreateUpgradeSpecFromProtobuf(content.Upgrade)
 
 	// 转换超时时间
 	if content.Timeout != nil {
@@ -121,6 +142,7 @@ func (c *Command) FromProtobufContent(content *protobuf.CommandContent) {
 func (c *Command) UpdateFromProtobufResult(result *protobuf.CommandResult) {
 	c.Stdout = result.Stdout
 	c.Stderr = result.Stderr
+	c.Truncated = result.Truncated
 	c.ErrorMsg = result.ErrorMessage
 	c.ExitCode = &result.ExitCode
 
@@ -135,7 +157,9 @@ func (c *Command) UpdateFromProtobufResult(result *protobuf.CommandResult) {
 		c.FinishedAt = &finishedAt
 
 		// 根据退出码设置状态
-		if result.ExitCode == 0 {
+		if result.TimedOut {
+			c.Status = CommandStatusTimeout
+		} else if result.ExitCode == 0 {
 			c.Status = CommandStatusCompleted
 		} else {
 			c.Status = CommandStatusFailed
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"devops-manager/api/protobuf"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ControlAction 命令控制动作
+type ControlAction string
+
+const (
+	ControlActionCancel ControlAction = "cancel" // 取消命令执行
+	ControlActionSignal ControlAction = "signal" // 向命令进程发送信号
+	ControlActionPause  ControlAction = "pause"  // 暂停命令进程
+	ControlActionResume ControlAction = "resume" // 恢复已暂停的命令进程
+)
+
+// 命令在 Agent 上的执行状态
+const (
+	ExecutionStateUnknown  = "unknown"
+	ExecutionStateRunning  = "running"
+	ExecutionStatePaused   = "paused"
+	ExecutionStateCanceled = "canceled"
+	ExecutionStateFinished = "finished"
+	ExecutionStateNotFound = "not_found"
+	ExecutionStateQueued   = "queued"
+)
+
+var controlActionToProtobuf = map[ControlAction]protobuf.ControlAction{
+	ControlActionCancel: protobuf.ControlAction_CONTROL_ACTION_CANCEL,
+	ControlActionSignal: protobuf.ControlAction_CONTROL_ACTION_SIGNAL,
+	ControlActionPause:  protobuf.ControlAction_CONTROL_ACTION_PAUSE,
+	ControlActionResume: protobuf.ControlAction_CONTROL_ACTION_RESUME,
+}
+
+var executionStateFromProtobuf = map[protobuf.ExecutionState]string{
+	protobuf.ExecutionState_EXECUTION_STATE_UNKNOWN:   ExecutionStateUnknown,
+	protobuf.ExecutionState_EXECUTION_STATE_RUNNING:   ExecutionStateRunning,
+	protobuf.ExecutionState_EXECUTION_STATE_PAUSED:    ExecutionStatePaused,
+	protobuf.ExecutionState_EXECUTION_STATE_CANCELED:  ExecutionStateCanceled,
+	protobuf.ExecutionState_EXECUTION_STATE_FINISHED:  ExecutionStateFinished,
+	protobuf.ExecutionState_EXECUTION_STATE_NOT_FOUND: ExecutionStateNotFound,
+	protobuf.ExecutionState_EXECUTION_STATE_QUEUED:    ExecutionStateQueued,
+}
+
+// IsValid 检查控制动作是否有效
+func (a ControlAction) IsValid() bool {
+	_, ok := controlActionToProtobuf[a]
+	return ok
+}
+
+// CommandControl 下发给 Agent 的命令控制请求
+type CommandControl struct {
+	ControlID   string        `json:"control_id"`
+	CommandID   string        `json:"command_id"`
+	HostID      string        `json:"host_id"`
+	Action      ControlAction `json:"action"`
+	Signal      int32         `json:"signal,omitempty"`
+	RequestedBy string        `json:"requested_by"`
+	CreatedAt   time.Time     `json:"created_at"`
+}
+
+// ToProtobuf 转换为 protobuf ControlRequest 格式
+func (c *CommandControl) ToProtobuf() *protobuf.ControlRequest {
+	return &protobuf.ControlRequest{
+		ControlId:   c.ControlID,
+		CommandId:   c.CommandID,
+		HostId:      c.HostID,
+		Action:      controlActionToProtobuf[c.Action],
+		Signal:      c.Signal,
+		RequestedBy: c.RequestedBy,
+		CreatedAt:   timestamppb.New(c.CreatedAt),
+	}
+}
+
+// ControlAck Agent 对命令控制请求的确认
+type ControlAck struct {
+	ControlID string        `json:"control_id"`
+	CommandID string        `json:"command_id"`
+	HostID    string        `json:"host_id"`
+	Action    ControlAction `json:"action"`
+	Success   bool          `json:"success"`
+	Message   string        `json:"message,omitempty"`
+	State     string        `json:"state"` // 处理后命令在 Agent 上的执行状态
+}
+
+// CreateControlAckFromProtobuf 从 protobuf ControlAck 创建控制确认
+func CreateControlAckFromProtobuf(ack *protobuf.ControlAck) *ControlAck {
+	c := &ControlAck{
+		ControlID: ack.ControlId,
+		CommandID: ack.CommandId,
+		HostID:    ack.HostId,
+		Success:   ack.Success,
+		Message:   ack.Message,
+		State:     ExecutionStateUnknown,
+	}
+	for action, value := range controlActionToProtobuf {
+		if value == ack.Action {
+			c.Action = action
+			break
+		}
+	}
+	if state, ok := executionStateFromProtobuf[ack.State]; ok {
+		c.State = state
+	}
+	return c
+}
@@ -19,19 +19,28 @@ const (
 
 // CommandHost 命令主机关联模型，映射到 commands_hosts 表
 type CommandHost struct {
-	ID            uint       `json:"id" gorm:"primaryKey"`
-	CommandID     string     `json:"command_id" gorm:"size:255;not null;comment:命令ID"`
-	HostID        string     `json:"host_id" gorm:"size:255;not null;comment:主机ID"`
-	Status        string     `json:"status" gorm:"size:20;default:待执行;comment:命令状态"`
-	Stdout        string     `json:"stdout" gorm:"type:longtext;comment:标准输出"`
-	Stderr        string     `json:"stderr" gorm:"type:longtext;comment:错误输出"`
-	ExitCode      int        `json:"exit_code" gorm:"default:0;comment:退出码"`
-	StartedAt     *time.Time `json:"started_at" gorm:"comment:开始执行时间"`
-	FinishedAt    *time.Time `json:"finished_at" gorm:"comment:完成时间"`
-	ErrorMessage  string     `json:"error_message" gorm:"type:text;comment:执行错误信息"`
-	ExecutionTime *int64     `json:"execution_time" gorm:"comment:执行时长(毫秒)"`
-	CreatedAt     time.Time  `json:"created_at"`
-	UpdatedAt     time.Time  `json:"updated_at"`
+	ID                uint       `json:"id" gorm:"primaryKey"`
+	CommandID         string     `json:"command_id" gorm:"size:255;not null;comment:命令ID"`
+	HostID            string     `json:"host_id" gorm:"size:255;not null;comment:主机ID"`
+	Status            string     `json:"status" gorm:"size:20;default:待执行;comment:命令状态"`
+	Stdout            string     `json:"stdout" gorm:"type:longtext;comment:标准输出"`
+	Stderr            string     `json:"stderr" gorm:"type:longtext;comment:错误输出"`
+	ExitCode          int        `json:"exit_code" gorm:"default:0;comment:退出码"`
+	StartedAt         *time.Time `json:"started_at" gorm:"comment:开始执行时间"`
+	FinishedAt        *time.Time `json:"finished_at" gorm:"comment:完成时间"`
+	ErrorMessage      string     `json:"error_message" gorm:"type:text;comment:执行错误信息"`
+	ExecutionTime     *int64     `json:"execution_time" gorm:"comment:执行时长(毫秒)"`
+	OutputSeq         uint64     `json:"output_seq" gorm:"default:0;comment:已追加的输出分片序号"`
+	Truncated         bool       `json:"truncated" gorm:"default:false;comment:输出是否被截断"`
+	StdoutSize        int64      `json:"stdout_size" gorm:"default:0;comment:标准输出原始字节数"`
+	StderrSize        int64      `json:"stderr_size" gorm:"default:0;comment:错误输出原始字节数"`
+	StdoutSpool       string     `json:"stdout_spool" gorm:"size:512;comment:完整标准输出的存储位置"`
+	StderrSpool       string     `json:"stderr_spool" gorm:"size:512;comment:完整错误输出的存储位置"`
+	TerminationSignal string     `json:"termination_signal" gorm:"size:16;comment:超时或取消时最终发送给进程树的信号"`
+	OOMKilled         bool       `json:"oom_killed" gorm:"column:oom_killed;default:false;comment:是否因超出内存限制被终止"`
+	DeliveredAt       *time.Time `json:"delivered_at" gorm:"comment:Agent 确认收到命令的时间"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
 
 	// 关联关系 - 不设置外键约束，避免迁移问题
 	Command *Command `json:"command,omitempty" gorm:"-"`
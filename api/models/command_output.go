@@ -0,0 +1,76 @@
+package models
+
+import (
+	"devops-manager/api/protobuf"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 输出流类型
+const (
+	OutputStreamStdout = "stdout"
+	OutputStreamStderr = "stderr"
+)
+
+// CommandOutputChunk 命令执行过程中的输出分片
+type CommandOutputChunk struct {
+	CommandID string    `json:"command_id"`
+	HostID    string    `json:"host_id"`
+	Sequence  uint64    `json:"sequence"`
+	Stream    string    `json:"stream"`
+	Data      string    `json:"data"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// CreateCommandOutputChunkFromProtobuf 从 protobuf CommandOutputChunk 创建输出分片
+func CreateCommandOutputChunkFromProtobuf(chunk *protobuf.CommandOutputChunk) *CommandOutputChunk {
+	c := &CommandOutputChunk{
+		CommandID: chunk.CommandId,
+		HostID:    chunk.HostId,
+		Sequence:  chunk.Sequence,
+		Stream:    OutputStreamStdout,
+		Data:      string(chunk.Data),
+		Timestamp: time.Now(),
+	}
+	if chunk.Stream == protobuf.OutputStream_OUTPUT_STREAM_STDERR {
+		c.Stream = OutputStreamStderr
+	}
+	if chunk.Timestamp != nil {
+		c.Timestamp = chunk.Timestamp.AsTime()
+	}
+	return c
+}
+
+// CommandOutput 命令主机的增量输出，偏移量为字节数
+type CommandOutput struct {
+	CommandID    string `json:"command_id"`
+	HostID       string `json:"host_id"`
+	Status       string `json:"status"`
+	Finished     bool   `json:"finished"`
+	Stdout       string `json:"stdout"`
+	Stderr       string `json:"stderr"`
+	StdoutOffset int    `json:"stdout_offset"` // 下次请求使用的 stdout 偏移量
+	StderrOffset int    `json:"stderr_offset"` // 下次请求使用的 stderr 偏移量
+	OutputSeq    uint64 `json:"output_seq"`    // 已追加的最大分片序号
+	Truncated    bool   `json:"truncated"`     // 输出超过上限被截断
+	StdoutSize   int64  `json:"stdout_size"`   // 标准输出原始字节数（执行完成后有效）
+	StderrSize   int64  `json:"stderr_size"`   // 错误输出原始字节数（执行完成后有效）
+	Spooled      bool   `json:"spooled"`       // 完整输出已转存，可通过 output/full 下载
+}
+
+// TruncateOutput 输出超过 limit 字节时只保留首尾各一半，截断处插入被省略的字节数
+func TruncateOutput(output string, limit int) (string, bool) {
+	if limit <= 0 || len(output) <= limit {
+		return output, false
+	}
+
+	head := output[:limit/2]
+	tail := output[len(output)-(limit-limit/2):]
+	omitted := len(output) - len(head) - len(tail)
+
+	// 截断位置可能落在多字节字符中间，去掉不完整的字符
+	return strings.ToValidUTF8(head, "") +
+		fmt.Sprintf("\n... [%d bytes truncated] ...\n", omitted) +
+		strings.ToValidUTF8(tail, ""), true
+}
@@ -9,18 +9,25 @@ import (
 
 // CommandResult 命令执行结果模型
 type CommandResult struct {
-	ID            uint       `json:"id" gorm:"primaryKey"`
-	CommandID     string     `json:"command_id" gorm:"uniqueIndex;size:255;not null;comment:命令ID"`
-	HostID        string     `json:"host_id" gorm:"size:255;not null;comment:执行主机ID"`
-	Stdout        string     `json:"stdout" gorm:"type:longtext;comment:标准输出"`
-	Stderr        string     `json:"stderr" gorm:"type:longtext;comment:错误输出"`
-	ExitCode      int32      `json:"exit_code" gorm:"default:0;comment:退出码"`
-	StartedAt     *time.Time `json:"started_at" gorm:"comment:开始执行时间"`
-	FinishedAt    *time.Time `json:"finished_at" gorm:"comment:完成时间"`
-	ErrorMessage  string     `json:"error_message" gorm:"type:text;comment:执行错误信息"`
-	ExecutionTime *int64     `json:"execution_time" gorm:"comment:执行时长(毫秒)"`
-	CreatedAt     time.Time  `json:"created_at"`
-	UpdatedAt     time.Time  `json:"updated_at"`
+	ID                uint       `json:"id" gorm:"primaryKey"`
+	CommandID         string     `json:"command_id" gorm:"uniqueIndex;size:255;not null;comment:命令ID"`
+	HostID            string     `json:"host_id" gorm:"size:255;not null;comment:执行主机ID"`
+	Stdout            string     `json:"stdout" gorm:"type:longtext;comment:标准输出"`
+	Stderr            string     `json:"stderr" gorm:"type:longtext;comment:错误输出"`
+	ExitCode          int32      `json:"exit_code" gorm:"default:0;comment:退出码"`
+	StartedAt         *time.Time `json:"started_at" gorm:"comment:开始执行时间"`
+	FinishedAt        *time.Time `json:"finished_at" gorm:"comment:完成时间"`
+	ErrorMessage      string     `json:"error_message" gorm:"type:text;comment:执行错误信息"`
+	ExecutionTime     *int64     `json:"execution_time" gorm:"comment:执行时长(毫秒)"`
+	Truncated         bool       `json:"truncated" gorm:"default:false;comment:输出是否被截断"`
+	StdoutSize        int64      `json:"stdout_size" gorm:"default:0;comment:标准输出原始字节数"`
+	StderrSize        int64      `json:"stderr_size" gorm:"default:0;comment:错误输出原始字节数"`
+	TimedOut          bool       `json:"timed_out" gorm:"default:false;comment:是否因超时被终止"`
+	TerminationSignal string     `json:"termination_signal" gorm:"size:16;comment:终止进程树时最终发送的信号"`
+	OOMKilled         bool       `json:"oom_killed" gorm:"column:oom_killed;default:false;comment:是否因超出内存限制被终止"`
+	Busy              bool       `json:"busy,omitempty" gorm:"-"` // Agent 执行队列已满，命令未执行
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
 
 	// 关联关系 - 不设置外键约束，避免迁移问题
 	Command *Command `json:"command,omitempty" gorm:"-"`
@@ -35,12 +42,19 @@ func (CommandResult) TableName() string {
 // ToProtobuf 转换为 protobuf CommandResult 格式
 func (cr *CommandResult) ToProtobuf() *protobuf.CommandResult {
 	result := &protobuf.CommandResult{
-		CommandId:    cr.CommandID,
-		HostId:       cr.HostID,
-		Stdout:       cr.Stdout,
-		Stderr:       cr.Stderr,
-		ExitCode:     cr.ExitCode,
-		ErrorMessage: cr.ErrorMessage,
+		CommandId:         cr.CommandID,
+		HostId:            cr.HostID,
+		Stdout:            cr.Stdout,
+		Stderr:            cr.Stderr,
+		ExitCode:          cr.ExitCode,
+		ErrorMessage:      cr.ErrorMessage,
+		Truncated:         cr.Truncated,
+		StdoutSize:        uint64(cr.StdoutSize),
+		StderrSize:        uint64(cr.StderrSize),
+		TimedOut:          cr.TimedOut,
+		TerminationSignal: cr.TerminationSignal,
+		OomKilled:         cr.OOMKilled,
+		Busy:              cr.Busy,
 	}
 
 	if cr.StartedAt != nil {
@@ -62,6 +76,21 @@ func (cr *CommandResult) FromProtobuf(result *protobuf.CommandResult) {
 	cr.Stderr = result.Stderr
 	cr.ExitCode = result.ExitCode
 	cr.ErrorMessage = result.ErrorMessage
+	cr.Truncated = result.Truncated
+	cr.StdoutSize = int64(result.StdoutSize)
+	cr.StderrSize = int64(result.StderrSize)
+	cr.TimedOut = result.TimedOut
+	cr.TerminationSignal = result.TerminationSignal
+	cr.OOMKilled = result.OomKilled
+	cr.Busy = result.Busy
+
+	// 未上报原始大小时（旧版本 Agent）以实际输出长度为准
+	if cr.StdoutSize == 0 {
+		cr.StdoutSize = int64(len(cr.Stdout))
+	}
+	if cr.StderrSize == 0 {
+		cr.StderrSize = int64(len(cr.Stderr))
+	}
 
 	if result.StartedAt != nil {
 		startedAt := result.StartedAt.AsTime()
@@ -104,22 +133,29 @@ func CreateCommandResultFromProtobuf(result *protobuf.CommandResult) *CommandRes
 // ToCommandHost 转换为 CommandHost 模型
 func (cr *CommandResult) ToCommandHost() *CommandHost {
 	ch := &CommandHost{
-		CommandID:     cr.CommandID,
-		HostID:        cr.HostID,
-		Stdout:        cr.Stdout,
-		Stderr:        cr.Stderr,
-		ExitCode:      int(cr.ExitCode),
-		StartedAt:     cr.StartedAt,
-		FinishedAt:    cr.FinishedAt,
-		ErrorMessage:  cr.ErrorMessage,
-		ExecutionTime: cr.ExecutionTime,
-		CreatedAt:     cr.CreatedAt,
-		UpdatedAt:     cr.UpdatedAt,
+		CommandID:         cr.CommandID,
+		HostID:            cr.HostID,
+		Stdout:            cr.Stdout,
+		Stderr:            cr.Stderr,
+		ExitCode:          int(cr.ExitCode),
+		StartedAt:         cr.StartedAt,
+		FinishedAt:        cr.FinishedAt,
+		ErrorMessage:      cr.ErrorMessage,
+		ExecutionTime:     cr.ExecutionTime,
+		Truncated:         cr.Truncated,
+		StdoutSize:        cr.StdoutSize,
+		StderrSize:        cr.StderrSize,
+		TerminationSignal: cr.TerminationSignal,
+		OOMKilled:         cr.OOMKilled,
+		CreatedAt:         cr.CreatedAt,
+		UpdatedAt:         cr.UpdatedAt,
 	}
 
 	// 根据退出码设置状态
 	if cr.FinishedAt != nil {
-		if cr.ExitCode == 0 {
+		if cr.TimedOut {
+			ch.Status = string(CommandHostStatusTimeout)
+		} else if cr.ExitCode == 0 {
 			ch.Status = string(CommandHostStatusCompleted)
 		} else {
 			ch.Status = string(CommandHostStatusExecFailed)
@@ -144,6 +180,12 @@ func (cr *CommandResult) FromCommandHost(ch *CommandHost) {
 	cr.FinishedAt = ch.FinishedAt
 	cr.ErrorMessage = ch.ErrorMessage
 	cr.ExecutionTime = ch.ExecutionTime
+	cr.Truncated = ch.Truncated
+	cr.StdoutSize = ch.StdoutSize
+	cr.StderrSize = ch.StderrSize
+	cr.TimedOut = ch.Status == string(CommandHostStatusTimeout)
+	cr.TerminationSignal = ch.TerminationSignal
+	cr.OOMKilled = ch.OOMKilled
 	cr.CreatedAt = ch.CreatedAt
 	cr.UpdatedAt = ch.UpdatedAt
 }
@@ -68,6 +68,13 @@ func (cb *CommandBuilder) WithCommand(cmd string) *CommandBuilder {
 	return cb
 }
 
+// WithScript 设置脚本模式，body 为脚本内容，由 interpreter 解释执行
+func (cb *CommandBuilder) WithScript(interpreter, body string, args ...string) *CommandBuilder {
+	cb.command.Command = body
+	cb.command.Script = &ScriptSpec{Interpreter: interpreter, Args: args}
+	return cb
+}
+
 // WithParameter 设置命令参数（简单字符串格式）
 func (cb *CommandBuilder) WithParameter(key, value string) *CommandBuilder {
 	if cb.command.Parameters == "" {
@@ -105,6 +112,61 @@ func (cb *CommandBuilder) WithTimeout(seconds int64) *CommandBuilder {
 	return cb
 }
 
+// WithRunAs 设置执行用户和用户组，group 为空时使用用户的主组
+func (cb *CommandBuilder) WithRunAs(user, group string) *CommandBuilder {
+	cb.options().RunAsUser = user
+	cb.options().RunAsGroup = group
+	return cb
+}
+
+// WithWorkingDir 设置工作目录
+func (cb *CommandBuilder) WithWorkingDir(dir string) *CommandBuilder {
+	cb.options().WorkingDir = dir
+	return cb
+}
+
+// WithEnv 追加环境变量
+func (cb *CommandBuilder) WithEnv(key, value string) *CommandBuilder {
+	options := cb.options()
+	if options.Env == nil {
+		options.Env = make(map[string]string)
+	}
+	options.Env[key] = value
+	return cb
+}
+
+// WithUmask 设置八进制权限掩码，如 "0022"
+func (cb *CommandBuilder) WithUmask(umask string) *CommandBuilder {
+	cb.options().Umask = umask
+	return cb
+}
+
+// WithStdin 设置标准输入内容
+func (cb *CommandBuilder) WithStdin(input string) *CommandBuilder {
+	cb.options().Stdin = input
+	return cb
+}
+
+// WithResourceLimits 设置资源限制
+func (cb *CommandBuilder) WithResourceLimits(limits *ResourceLimits) *CommandBuilder {
+	cb.options().Resources = limits
+	return cb
+}
+
+// WithOptions 设置执行选项
+func (cb *CommandBuilder) WithOptions(options *ExecutionOptions) *CommandBuilder {
+	cb.command.Options = options
+	return cb
+}
+
+// options 获取执行选项，不存在时创建
+func (cb *CommandBuilder) options() *ExecutionOptions {
+	if cb.command.Options == nil {
+		cb.command.Options = &ExecutionOptions{}
+	}
+	return cb.command.Options
+}
+
 // Build 构建命令
 func (cb *CommandBuilder) Build() *Command {
 	cb.command.CreatedAt = time.Now()
@@ -122,14 +184,42 @@ func NewCommandMessageHelper() *CommandMessageHelper {
 // CreateCommandMessage 创建包含命令内容的消息
 func (h *CommandMessageHelper) CreateCommandMessage(content *protobuf.CommandContent) *protobuf.CommandMessage {
 	return &protobuf.CommandMessage{
-		CommandContent: content,
+		Payload: &protobuf.CommandMessage_CommandContent{CommandContent: content},
 	}
 }
 
 // CreateResultMessage 创建包含命令结果的消息
 func (h *CommandMessageHelper) CreateResultMessage(result *protobuf.CommandResult) *protobuf.CommandMessage {
 	return &protobuf.CommandMessage{
-		CommandResult: result,
+		Payload: &protobuf.CommandMessage_CommandResult{CommandResult: result},
+	}
+}
+
+// CreateHelloMessage 创建握手消息
+func (h *CommandMessageHelper) CreateHelloMessage(hello *protobuf.AgentHello) *protobuf.CommandMessage {
+	return &protobuf.CommandMessage{
+		Payload: &protobuf.CommandMessage_Hello{Hello: hello},
+	}
+}
+
+// CreateHeartbeatMessage 创建心跳消息
+func (h *CommandMessageHelper) CreateHeartbeatMessage(hostID string) *protobuf.CommandMessage {
+	return &protobuf.CommandMessage{
+		Payload: &protobuf.CommandMessage_Heartbeat{Heartbeat: &protobuf.Heartbeat{
+			HostId:    hostID,
+			Timestamp: timestamppb.Now(),
+		}},
+	}
+}
+
+// CreateAckMessage 创建确认消息
+func (h *CommandMessageHelper) CreateAckMessage(refID string, success bool, message string) *protobuf.CommandMessage {
+	return &protobuf.CommandMessage{
+		Payload: &protobuf.CommandMessage_Ack{Ack: &protobuf.Ack{
+			RefId:   refID,
+			Success: success,
+			Message: message,
+		}},
 	}
 }
 
@@ -153,6 +243,15 @@ func (f *CommandFactory) CreateSimpleCommand(hostID, command string) *protobuf.C
 	}
 }
 
+// CreateScriptCommand 创建脚本命令
+func (f *CommandFactory) CreateScriptCommand(hostID, interpreter, body string, args ...string) *protobuf.CommandContent {
+	return NewCommandBuilder().
+		WithHostID(hostID).
+		WithScript(interpreter, body, args...).
+		Build().
+		ToProtobufContent()
+}
+
 // CreateCommandWithParams 创建带参数的命令
 func (f *CommandFactory) CreateCommandWithParams(hostID, command string, params map[string]string, timeoutSeconds int64) *protobuf.CommandContent {
 	// 将 map 转换为字符串格式
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"devops-manager/api/protobuf"
+)
+
+// ExecutionOptions 命令执行选项，为空时命令以 Agent 配置的默认执行用户运行
+type ExecutionOptions struct {
+	RunAsUser  string            `json:"run_as_user,omitempty"`  // 执行用户（用户名或 UID）
+	RunAsGroup string            `json:"run_as_group,omitempty"` // 执行用户组（组名或 GID），为空时使用执行用户的主组
+	WorkingDir string            `json:"working_dir,omitempty"`  // 工作目录（绝对路径），为空时使用执行用户的主目录
+	Env        map[string]string `json:"env,omitempty"`          // 追加的环境变量
+	Umask      string            `json:"umask,omitempty"`        // 八进制权限掩码
+	Stdin      string            `json:"stdin,omitempty"`        // 标准输入内容
+	Resources  *ResourceLimits   `json:"resources,omitempty"`    // 资源限制（Linux cgroup v2）
+}
+
+// ResourceLimits 命令资源限制，字段为 0 时不限制
+type ResourceLimits struct {
+	CPUQuota    float64 `json:"cpu_quota,omitempty"`    // CPU 上限（核数），如 0.5 表示半个核
+	MemoryBytes int64   `json:"memory_bytes,omitempty"` // 内存上限（字节）
+	IOWeight    uint32  `json:"io_weight,omitempty"`    // IO 权重，1-10000
+	MaxPIDs     int64   `json:"max_pids,omitempty"`     // 最大进程数
+}
+
+// 资源限制取值范围
+const (
+	MinCPUQuota    = 0.01
+	MinMemoryBytes = 4 * 1024 * 1024
+	MaxIOWeight    = 10000
+)
+
+// Validate 检查资源限制
+func (r *ResourceLimits) Validate() error {
+	if r == nil {
+		return nil
+	}
+	if r.CPUQuota != 0 && r.CPUQuota < MinCPUQuota {
+		return fmt.Errorf("cpu_quota must be at least %.2f", MinCPUQuota)
+	}
+	if r.MemoryBytes != 0 && r.MemoryBytes < MinMemoryBytes {
+		return fmt.Errorf("memory_bytes must be at least %d", MinMemoryBytes)
+	}
+	if r.IOWeight > MaxIOWeight {
+		return fmt.Errorf("io_weight must be between 1 and %d", MaxIOWeight)
+	}
+	if r.MaxPIDs < 0 {
+		return fmt.Errorf("max_pids must not be negative")
+	}
+	return nil
+}
+
+// Scan 实现 sql.Scanner 接口
+func (o *ExecutionOptions) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, o)
+	case string:
+		return json.Unmarshal([]byte(v), o)
+	default:
+		*o = ExecutionOptions{}
+		return nil
+	}
+}
+
+// Value 实现 driver.Valuer 接口
+func (o ExecutionOptions) Value() (driver.Value, error) {
+	return json.Marshal(o)
+}
+
+// Validate 检查执行选项
+func (o *ExecutionOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if o.RunAsGroup != "" && o.RunAsUser == "" {
+		return fmt.Errorf("run_as_group requires run_as_user")
+	}
+	if o.WorkingDir != "" && !strings.HasPrefix(o.WorkingDir, "/") && !isWindowsAbsPath(o.WorkingDir) {
+		return fmt.Errorf("working_dir must be an absolute path: %s", o.WorkingDir)
+	}
+	if _, err := ParseUmask(o.Umask); err != nil {
+		return err
+	}
+	if err := o.Resources.Validate(); err != nil {
+		return err
+	}
+	for key := range o.Env {
+		if key == "" || strings.ContainsAny(key, "=\x00") {
+			return fmt.Errorf("invalid environment variable name: %q", key)
+		}
+	}
+	return nil
+}
+
+// ParseUmask 解析八进制权限掩码，为空时返回 -1
+func ParseUmask(umask string) (int, error) {
+	if umask == "" {
+		return -1, nil
+	}
+	value, err := strconv.ParseUint(umask, 8, 32)
+	if err != nil || value > 0777 {
+		return 0, fmt.Errorf("invalid umask: %s", umask)
+	}
+	return int(value), nil
+}
+
+// isWindowsAbsPath 检查是否为带盘符的 Windows 绝对路径
+func isWindowsAbsPath(path string) bool {
+	return len(path) >= 3 && path[1] == ':' && (path[2] == '\\' || path[2] == '/')
+}
+
+// ToProtobuf 转换为 protobuf ExecutionOptions 格式，为空时返回 nil
+func (o *ExecutionOptions) ToProtobuf() *protobuf.ExecutionOptions {
+	if o == nil {
+		return nil
+	}
+	options := &protobuf.ExecutionOptions{
+		RunAsUser:  o.RunAsUser,
+		RunAsGroup: o.RunAsGroup,
+		WorkingDir: o.WorkingDir,
+		Env:        o.Env,
+		Umask:      o.Umask,
+	}
+	if o.Stdin != "" {
+		options.Stdin = []byte(o.Stdin)
+	}
+	if o.Resources != nil {
+		options.Resources = &protobuf.ResourceLimits{
+			CpuQuota:    o.Resources.CPUQuota,
+			MemoryBytes: o.Resources.MemoryBytes,
+			IoWeight:    o.Resources.IOWeight,
+			MaxPids:     o.Resources.MaxPIDs,
+		}
+	}
+	return options
+}
+
+// CreateExecutionOptionsFromProtobuf 从 protobuf ExecutionOptions 创建执行选项
+func CreateExecutionOptionsFromProtobuf(options *protobuf.ExecutionOptions) *ExecutionOptions {
+	if options == nil {
+		return nil
+	}
+	result := &ExecutionOptions{
+		RunAsUser:  options.RunAsUser,
+		RunAsGroup: options.RunAsGroup,
+		WorkingDir: options.WorkingDir,
+		Env:        options.Env,
+		Umask:      options.Umask,
+		Stdin:      string(options.Stdin),
+	}
+	if options.Resources != nil {
+		result.Resources = &ResourceLimits{
+			CPUQuota:    options.Resources.CpuQuota,
+			MemoryBytes: options.Resources.MemoryBytes,
+			IOWeight:    options.Resources.IoWeight,
+			MaxPIDs:     options.Resources.MaxPids,
+		}
+	}
+	return result
+}
@@ -0,0 +1,246 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"devops-manager/api/protobuf"
+)
+
+// DefaultFileMode 未指定权限时分发文件的权限
+const DefaultFileMode = "0644"
+
+// FileSpec 文件分发参数，文件内容按 SHA-256 保存在 Server 文件存储中
+type FileSpec struct {
+	SHA256   string `json:"sha256"`          // 文件内容的 SHA-256（小写十六进制）
+	Name     string `json:"name,omitempty"`  // 上传时的文件名
+	Size     int64  `json:"size"`            // 文件大小（字节）
+	DestPath string `json:"dest_path"`       // 目标路径（绝对路径）
+	Owner    string `json:"owner,omitempty"` // 属主（用户名或 UID），为空时不修改
+	Group    string `json:"group,omitempty"` // 属组（组名或 GID），为空时使用属主的主组
+	Mode     string `json:"mode,omitempty"`  // 八进制权限，为空时使用 0644
+	Peer     bool   `json:"peer,omitempty"`  // 对等分发，同区域的 Agent 之间互相提供已接收的分片
+
+	BandwidthLimit int64 `json:"bandwidth_limit,omitempty"` // 每台主机接收文件的限速（字节/秒），为 0 时只受 Server 配置限制
+}
+
+// Scan 实现 sql.Scanner 接口
+func (f *FileSpec) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, f)
+	case string:
+		return json.Unmarshal([]byte(v), f)
+	default:
+		*f = FileSpec{}
+		return nil
+	}
+}
+
+// Value 实现 driver.Valuer 接口
+func (f FileSpec) Value() (driver.Value, error) {
+	return json.Marshal(f)
+}
+
+// Validate 检查文件分发参数
+func (f *FileSpec) Validate() error {
+	if f == nil {
+		return nil
+	}
+	if !IsValidSHA256(f.SHA256) {
+		return fmt.Errorf("invalid sha256: %s", f.SHA256)
+	}
+	if f.Size < 0 {
+		return fmt.Errorf("file size must not be negative")
+	}
+	if f.DestPath == "" {
+		return fmt.Errorf("dest_path is required")
+	}
+	if !strings.HasPrefix(f.DestPath, "/") && !isWindowsAbsPath(f.DestPath) {
+		return fmt.Errorf("dest_path must be an absolute path: %s", f.DestPath)
+	}
+	if strings.HasSuffix(f.DestPath, "/") || strings.HasSuffix(f.DestPath, "\\") {
+		return fmt.Errorf("dest_path must be a file path: %s", f.DestPath)
+	}
+	if strings.ContainsRune(f.DestPath, 0) || strings.ContainsRune(f.Owner, 0) || strings.ContainsRune(f.Group, 0) {
+		return fmt.Errorf("file spec contains NUL character")
+	}
+	if f.Group != "" && f.Owner == "" {
+		return fmt.Errorf("group requires owner")
+	}
+	if f.BandwidthLimit < 0 {
+		return fmt.Errorf("bandwidth_limit must not be negative")
+	}
+	if _, err := ParseFileMode(f.Mode); err != nil {
+		return err
+	}
+	return nil
+}
+
+// IsValidSHA256 检查是否为小写十六进制的 SHA-256 摘要
+func IsValidSHA256(digest string) bool {
+	if len(digest) != 64 {
+		return false
+	}
+	return strings.Trim(digest, "0123456789abcdef") == ""
+}
+
+// ParseFileMode 解析八进制文件权限，为空时返回默认权限
+func ParseFileMode(mode string) (uint32, error) {
+	if mode == "" {
+		mode = DefaultFileMode
+	}
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 07777 {
+		return 0, fmt.Errorf("invalid file mode: %s", mode)
+	}
+	return uint32(value), nil
+}
+
+// Description 文件分发命令的描述，保存在命令的 Command 字段中
+func (f *FileSpec) Description() string {
+	name := f.Name
+	if name == "" {
+		name = f.SHA256
+	}
+	if f.Peer {
+		return fmt.Sprintf("file push (peer): %s -> %s", name, f.DestPath)
+	}
+	return fmt.Sprintf("file push: %s -> %s", name, f.DestPath)
+}
+
+// ToProtobuf 转换为 protobuf FileSpec 格式，为空时返回 nil
+func (f *FileSpec) ToProtobuf() *protobuf.FileSpec {
+	if f == nil {
+		return nil
+	}
+	return &protobuf.FileSpec{
+		Sha256:   f.SHA256,
+		Size:     f.Size,
+		DestPath: f.DestPath,
+		Owner:    f.Owner,
+		Group:    f.Group,
+		Mode:     f.Mode,
+		Name:     f.Name,
+		Peer:     f.Peer,
+
+		BandwidthLimit: f.BandwidthLimit,
+	}
+}
+
+// CreateFileSpecFromProtobuf 从 protobuf FileSpec 创建文件分发参数
+func CreateFileSpecFromProtobuf(file *protobuf.FileSpec) *FileSpec {
+	if file == nil {
+		return nil
+	}
+	return &FileSpec{
+		SHA256:   file.Sha256,
+		Name:     file.Name,
+		Size:     file.Size,
+		DestPath: file.DestPath,
+		Owner:    file.Owner,
+		Group:    file.Group,
+		Mode:     file.Mode,
+		Peer:     file.Peer,
+
+		BandwidthLimit: file.BandwidthLimit,
+	}
+}
+
+// MaxFetchPatterns 文件收集最多指定的路径数
+const MaxFetchPatterns = 32
+
+// FetchSpec 文件收集参数，Agent 将匹配的文件回传到 Server 按任务和主机保存
+type FetchSpec struct {
+	Patterns      []string `json:"patterns"`                  // 文件路径或通配符（绝对路径）
+	MaxFileBytes  int64    `json:"max_file_bytes,omitempty"`  // 单个文件的最大字节数，超过时跳过，为 0 时不限制
+	MaxTotalBytes int64    `json:"max_total_bytes,omitempty"` // 每台主机收集的总字节数上限
+
+	BandwidthLimit int64 `json:"bandwidth_limit,omitempty"` // 每台主机回传文件的限速（字节/秒），为 0 时只受 Server 配置限制
+}
+
+// Scan 实现 sql.Scanner 接口
+func (f *FetchSpec) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, f)
+	case string:
+		return json.Unmarshal([]byte(v), f)
+	default:
+		*f = FetchSpec{}
+		return nil
+	}
+}
+
+// Value 实现 driver.Valuer 接口
+func (f FetchSpec) Value() (driver.Value, error) {
+	return json.Marshal(f)
+}
+
+// Validate 检查文件收集参数
+func (f *FetchSpec) Validate() error {
+	if f == nil {
+		return nil
+	}
+	if len(f.Patterns) == 0 {
+		return fmt.Errorf("at least one path pattern is required")
+	}
+	if len(f.Patterns) > MaxFetchPatterns {
+		return fmt.Errorf("at most %d path patterns are allowed", MaxFetchPatterns)
+	}
+	for _, pattern := range f.Patterns {
+		if !strings.HasPrefix(pattern, "/") && !isWindowsAbsPath(pattern) {
+			return fmt.Errorf("path pattern must be absolute: %s", pattern)
+		}
+		if strings.ContainsRune(pattern, 0) {
+			return fmt.Errorf("path pattern contains NUL character")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid path pattern %s: %w", pattern, err)
+		}
+	}
+	if f.MaxFileBytes < 0 || f.MaxTotalBytes < 0 {
+		return fmt.Errorf("size limits must not be negative")
+	}
+	if f.BandwidthLimit < 0 {
+		return fmt.Errorf("bandwidth_limit must not be negative")
+	}
+	return nil
+}
+
+// Description 文件收集命令的描述，保存在命令的 Command 字段中
+func (f *FetchSpec) Description() string {
+	return "file fetch: " + strings.Join(f.Patterns, " ")
+}
+
+// ToProtobuf 转换为 protobuf FetchSpec 格式，为空时返回 nil
+func (f *FetchSpec) ToProtobuf() *protobuf.FetchSpec {
+	if f == nil {
+		return nil
+	}
+	return &protobuf.FetchSpec{
+		Patterns:      f.Patterns,
+		MaxFileBytes:  f.MaxFileBytes,
+		MaxTotalBytes: f.MaxTotalBytes,
+
+		BandwidthLimit: f.BandwidthLimit,
+	}
+}
+
+// CreateFetchSpecFromProtobuf 从 protobuf FetchSpec 创建文件收集参数
+func CreateFetchSpecFromProtobuf(fetch *protobuf.FetchSpec) *FetchSpec {
+	if fetch == nil {
+		return nil
+	}
+	return &FetchSpec{
+		Patterns:      fetch.Patterns,
+		MaxFileBytes:  fetch.MaxFileBytes,
+		MaxTotalBytes: fetch.MaxTotalBytes,
+
+		BandwidthLimit: fetch.BandwidthLimit,
+	}
+}
@@ -20,17 +20,25 @@ const (
 
 // Host 主机模型
 type Host struct {
-	ID        uint           `json:"id" gorm:"primaryKey"`
-	HostID    string         `json:"host_id" gorm:"uniqueIndex;size:255;not null;comment:主机唯一标识"`
-	Hostname  string         `json:"hostname" gorm:"size:255;not null;comment:主机名"`
-	IP        string         `json:"ip" gorm:"size:45;comment:IP地址"`
-	OS        string         `json:"os" gorm:"size:100;comment:操作系统"`
-	Status    HostStatus     `json:"status" gorm:"size:20;default:pending;comment:主机状态"`
-	Tags      JSON           `json:"tags" gorm:"type:json;comment:标签信息"`
-	LastSeen  time.Time      `json:"last_seen" gorm:"comment:最后上报时间"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	ID           uint           `json:"id" gorm:"primaryKey"`
+	HostID       string         `json:"host_id" gorm:"uniqueIndex;size:255;not null;comment:主机唯一标识"`
+	Hostname     string         `json:"hostname" gorm:"size:255;not null;comment:主机名"`
+	IP           string         `json:"ip" gorm:"size:45;comment:IP地址"`
+	OS           string         `json:"os" gorm:"size:100;comment:操作系统"`
+	AgentVersion string         `json:"agent_version" gorm:"size:64;comment:Agent 版本"`
+	Status       HostStatus     `json:"status" gorm:"size:20;default:pending;comment:主机状态"`
+	Tags         JSON           `json:"tags" gorm:"type:json;comment:主机标签（管理员维护，决定用户主机范围）"`
+	Labels       JSON           `json:"labels" gorm:"type:json;comment:Agent 上报的标签"`
+	LastSeen     time.Time      `json:"last_seen" gorm:"comment:最后上报时间"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
+
+	// Agent 执行队列状态，随状态上报更新
+	RunningCommands       int `json:"running_commands" gorm:"default:0;comment:执行中的命令数"`
+	QueuedCommands        int `json:"queued_commands" gorm:"default:0;comment:排队中的命令数"`
+	MaxConcurrentCommands int `json:"max_concurrent_commands" gorm:"default:0;comment:最大并发命令数"`
+	CommandQueueCapacity  int `json:"command_queue_capacity" gorm:"default:0;comment:命令队列容量"`
 }
 
 // JSON 自定义类型用于处理 JSON 字段
@@ -77,13 +85,14 @@ func (h *Host) ToProtobuf() map[string]interface{} {
 	}
 
 	return map[string]interface{}{
-		"id":        h.HostID,
-		"hostname":  h.Hostname,
-		"ip":        h.IP,
-		"os":        h.OS,
-		"status":    string(h.Status),
-		"tags":      tags,
-		"last_seen": h.LastSeen.Unix(),
+		"id":            h.HostID,
+		"hostname":      h.Hostname,
+		"ip":            h.IP,
+		"os":            h.OS,
+		"agent_version": h.AgentVersion,
+		"status":        string(h.Status),
+		"tags":          tags,
+		"last_seen":     h.LastSeen.Unix(),
 	}
 }
 
@@ -101,6 +110,9 @@ func (h *Host) FromProtobuf(data map[string]interface{}) {
 	if os, ok := data["os"].(string); ok {
 		h.OS = os
 	}
+	if agentVersion, ok := data["agent_version"].(string); ok {
+		h.AgentVersion = agentVersion
+	}
 	if tags, ok := data["tags"].(map[string]string); ok {
 		h.Tags = make(JSON)
 		for k, v := range tags {
@@ -118,26 +130,32 @@ type PendingHost struct {
 	Hostname  string            `json:"hostname"`
 	IP        string            `json:"ip"`
 	OS        string            `json:"os"`
+	Version   string            `json:"agent_version,omitempty"` // Agent 版本
 	Tags      map[string]string `json:"tags"`
-	FirstSeen int64             `json:"first_seen"` // 首次注册时间
-	LastSeen  int64             `json:"last_seen"`  // 最后上报时间
+	CSR       string            `json:"csr,omitempty"` // 证书签名请求，准入时由内置 CA 签发
+	FirstSeen int64             `json:"first_seen"`    // 首次注册时间
+	LastSeen  int64             `json:"last_seen"`     // 最后上报时间
 }
 
-// ToHost 转换为 Host 模型
+// ToHost 转换为 Host 模型，准入时以主机上报的标签作为初始主机标签，之后只有管理员可以修改
 func (ph *PendingHost) ToHost() *Host {
 	tags := make(JSON)
+	labels := make(JSON)
 	for k, v := range ph.Tags {
 		tags[k] = v
+		labels[k] = v
 	}
 
 	return &Host{
-		HostID:   ph.HostID,
-		Hostname: ph.Hostname,
-		IP:       ph.IP,
-		OS:       ph.OS,
-		Status:   HostStatusPending,
-		Tags:     tags,
-		LastSeen: time.Unix(ph.LastSeen, 0),
+		HostID:       ph.HostID,
+		Hostname:     ph.Hostname,
+		IP:           ph.IP,
+		OS:           ph.OS,
+		AgentVersion: ph.Version,
+		Status:       HostStatusPending,
+		Tags:         tags,
+		Labels:       labels,
+		LastSeen:     time.Unix(ph.LastSeen, 0),
 	}
 }
 
@@ -148,7 +166,9 @@ func FromProtobufToPending(hostInfo *protobuf.HostInfo) *PendingHost {
 		Hostname:  hostInfo.Hostname,
 		IP:        hostInfo.Ip,
 		OS:        hostInfo.Os,
+		Version:   hostInfo.AgentVersion,
 		Tags:      hostInfo.Tags,
+		CSR:       hostInfo.Csr,
 		FirstSeen: time.Now().Unix(),
 		LastSeen:  hostInfo.LastSeen,
 	}
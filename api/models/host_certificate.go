@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+)
+
+// CertificateStatus 主机证书状态
+type CertificateStatus string
+
+const (
+	CertificateStatusActive     CertificateStatus = "active"     // 有效
+	CertificateStatusSuperseded CertificateStatus = "superseded" // 已被新证书取代（旧版本的记录，签发新证书时一并吊销）
+	CertificateStatusRevoked    CertificateStatus = "revoked"    // 已吊销
+)
+
+// HostCertificate 内置 CA 为主机签发的证书
+type HostCertificate struct {
+	ID           uint              `json:"id" gorm:"primaryKey"`
+	HostID       string            `json:"host_id" gorm:"size:255;not null;index;comment:主机ID"`
+	SerialNumber string            `json:"serial_number" gorm:"uniqueIndex;size:64;not null;comment:证书序列号（十六进制）"`
+	CertPEM      string            `json:"cert_pem" gorm:"type:text;not null;comment:PEM 格式证书"`
+	Status       CertificateStatus `json:"status" gorm:"size:20;not null;default:active;index;comment:证书状态"`
+	NotBefore    time.Time         `json:"not_before" gorm:"comment:生效时间"`
+	NotAfter     time.Time         `json:"not_after" gorm:"comment:过期时间"`
+	RevokedAt    *time.Time        `json:"revoked_at,omitempty" gorm:"comment:吊销时间"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// TableName 指定表名
+func (HostCertificate) TableName() string {
+	return "host_certificates"
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"devops-manager/api/protobuf"
+)
+
+// 脚本解释器
+const (
+	ScriptInterpreterBash    = "bash"
+	ScriptInterpreterSh      = "sh"
+	ScriptInterpreterPython3 = "python3"
+	ScriptInterpreterPerl    = "perl"
+)
+
+// MaxScriptBytes 脚本内容的最大字节数
+const MaxScriptBytes = 1024 * 1024
+
+// ScriptSpec 脚本执行参数，命令的 Command 字段为脚本内容
+type ScriptSpec struct {
+	Interpreter string   `json:"interpreter"`    // 解释器：bash、sh、python3、perl
+	Args        []string `json:"args,omitempty"` // 脚本参数
+}
+
+// Scan 实现 sql.Scanner 接口
+func (s *ScriptSpec) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	default:
+		*s = ScriptSpec{}
+		return nil
+	}
+}
+
+// Value 实现 driver.Valuer 接口
+func (s ScriptSpec) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+// IsValidScriptInterpreter 检查是否为支持的脚本解释器
+func IsValidScriptInterpreter(interpreter string) bool {
+	switch interpreter {
+	case ScriptInterpreterBash, ScriptInterpreterSh, ScriptInterpreterPython3, ScriptInterpreterPerl:
+		return true
+	default:
+		return false
+	}
+}
+
+// Validate 检查脚本参数及脚本内容
+func (s *ScriptSpec) Validate(body string) error {
+	if s == nil {
+		return nil
+	}
+	if !IsValidScriptInterpreter(s.Interpreter) {
+		return fmt.Errorf("unsupported script interpreter: %s", s.Interpreter)
+	}
+	if strings.TrimSpace(body) == "" {
+		return fmt.Errorf("script body is required")
+	}
+	if len(body) > MaxScriptBytes {
+		return fmt.Errorf("script body exceeds %d bytes", MaxScriptBytes)
+	}
+	for _, arg := range s.Args {
+		if strings.ContainsRune(arg, 0) {
+			return fmt.Errorf("script argument contains NUL character")
+		}
+	}
+	return nil
+}
+
+// ToProtobuf 转换为 protobuf ScriptSpec 格式，为空时返回 nil
+func (s *ScriptSpec) ToProtobuf() *protobuf.ScriptSpec {
+	if s == nil {
+		return nil
+	}
+	return &protobuf.ScriptSpec{
+		Interpreter: s.Interpreter,
+		Args:        s.Args,
+	}
+}
+
+// CreateScriptSpecFromProtobuf 从 protobuf ScriptSpec 创建脚本执行参数
+func CreateScriptSpecFromProtobuf(script *protobuf.ScriptSpec) *ScriptSpec {
+	if script == nil {
+		return nil
+	}
+	return &ScriptSpec{
+		Interpreter: script.Interpreter,
+		Args:        script.Args,
+	}
+}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+)
+
+// UserRole 用户角色
+type UserRole string
+
+const (
+	UserRoleViewer   UserRole = "viewer"   // 只读：查看主机、任务和日志
+	UserRoleOperator UserRole = "operator" // 运维：创建和控制任务
+	UserRoleAdmin    UserRole = "admin"    // 管理员：主机准入、删除、证书、用户管理和数据库维护
+)
+
+// roleLevels 角色权限等级，高等级包含低等级的全部权限
+var roleLevels = map[UserRole]int{
+	UserRoleViewer:   1,
+	UserRoleOperator: 2,
+	UserRoleAdmin:    3,
+}
+
+// IsValid 检查角色是否合法
+func (r UserRole) IsValid() bool {
+	_, ok := roleLevels[r]
+	return ok
+}
+
+// Allows 检查该角色是否具备 required 角色的权限
+func (r UserRole) Allows(required UserRole) bool {
+	return r.IsValid() && roleLevels[r] >= roleLevels[required]
+}
+
+// HostTagRule 主机标签规则，主机标签 Key 的值等于 Value 时匹配，Value 为 "*" 时只要求存在该标签
+type HostTagRule struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Matches 检查主机标签是否匹配该规则
+func (r HostTagRule) Matches(tags map[string]string) bool {
+	value, ok := tags[r.Key]
+	return ok && (r.Value == "*" || value == r.Value)
+}
+
+// HostScope 用户可操作的主机范围，主机匹配任一规则即在范围内；为空表示不限制
+type HostScope []HostTagRule
+
+// IsRestricted 检查是否限制了主机范围
+func (s HostScope) IsRestricted() bool {
+	return len(s) > 0
+}
+
+// Matches 检查主机标签是否在范围内
+func (s HostScope) Matches(tags map[string]string) bool {
+	if !s.IsRestricted() {
+		return true
+	}
+	for _, rule := range s {
+		if rule.Matches(tags) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesHost 检查主机是否在范围内
+func (s HostScope) MatchesHost(host *Host) bool {
+	tags := make(map[string]string, len(host.Tags))
+	for k, v := range host.Tags {
+		if str, ok := v.(string); ok {
+			tags[k] = str
+		}
+	}
+	return s.Matches(tags)
+}
+
+// Scan 实现 sql.Scanner 接口
+func (s *HostScope) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	default:
+		*s = nil
+		return nil
+	}
+}
+
+// Value 实现 driver.Valuer 接口
+func (s HostScope) Value() (driver.Value, error) {
+	if s == nil {
+		return nil, nil
+	}
+	return json.Marshal(s)
+}
+
+// User 平台用户
+type User struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	Username     string    `json:"username" gorm:"uniqueIndex;size:64;not null;comment:用户名"`
+	PasswordHash string    `json:"-" gorm:"size:255;comment:密码哈希(bcrypt)"`
+	Role         UserRole  `json:"role" gorm:"size:20;not null;default:viewer;comment:角色"`
+	HostScope    HostScope `json:"host_scope" gorm:"type:json;comment:可操作主机的标签范围，为空不限制"`
+	Enabled      bool      `json:"enabled" gorm:"not null;default:true;comment:是否启用"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// EffectiveHostScope 用户实际生效的主机范围，管理员不受限制
+func (u *User) EffectiveHostScope() HostScope {
+	if u.Role == UserRoleAdmin {
+		return nil
+	}
+	return u.HostScope
+}
+
+// TableName 指定表名
+func (User) TableName() string {
+	return "users"
+}
+
+// APIToken 用户 API 令牌，仅保存令牌的 SHA-256 哈希
+type APIToken struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	UserID     uint       `json:"user_id" gorm:"not null;index;comment:所属用户ID"`
+	Name       string     `json:"name" gorm:"size:255;comment:令牌名称"`
+	TokenHash  string     `json:"-" gorm:"uniqueIndex;size:64;not null;comment:令牌SHA-256哈希"`
+	Prefix     string     `json:"prefix" gorm:"size:16;comment:令牌前缀，便于识别"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" gorm:"comment:过期时间"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" gorm:"comment:最后使用时间"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" gorm:"comment:吊销时间"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// TableName 指定表名
+func (APIToken) TableName() string {
+	return "api_tokens"
+}
+
+// IsUsable 检查令牌是否未吊销且未过期
+func (t *APIToken) IsUsable(now time.Time) bool {
+	if t.RevokedAt != nil {
+		return false
+	}
+	return t.ExpiresAt == nil || now.Before(*t.ExpiresAt)
+}
@@ -0,0 +1,76 @@
+package models
+
+import "testing"
+
+func TestHostScopeMatches(t *testing.T) {
+	payments := HostScope{{Key: "team", Value: "payments"}}
+	anyZone := HostScope{{Key: "zone", Value: "*"}}
+	either := HostScope{{Key: "team", Value: "payments"}, {Key: "env", Value: "staging"}}
+
+	tests := []struct {
+		name  string
+		scope HostScope
+		tags  map[string]string
+		want  bool
+	}{
+		{name: "unrestricted matches untagged host", scope: nil, tags: nil, want: true},
+		{name: "unrestricted matches any host", scope: HostScope{}, tags: map[string]string{"team": "search"}, want: true},
+		{name: "exact value", scope: payments, tags: map[string]string{"team": "payments"}, want: true},
+		{name: "other value", scope: payments, tags: map[string]string{"team": "search"}, want: false},
+		{name: "value is case sensitive", scope: payments, tags: map[string]string{"team": "Payments"}, want: false},
+		{name: "missing key", scope: payments, tags: map[string]string{"env": "payments"}, want: false},
+		{name: "untagged host", scope: payments, tags: nil, want: false},
+		{name: "wildcard requires key", scope: anyZone, tags: map[string]string{"zone": ""}, want: true},
+		{name: "wildcard without key", scope: anyZone, tags: map[string]string{"team": "payments"}, want: false},
+		{name: "any rule matches", scope: either, tags: map[string]string{"env": "staging"}, want: true},
+		{name: "no rule matches", scope: either, tags: map[string]string{"team": "search", "env": "production"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.scope.Matches(tt.tags); got != tt.want {
+				t.Errorf("Matches(%v) = %v, want %v", tt.tags, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostScopeMatchesHost(t *testing.T) {
+	scope := HostScope{{Key: "team", Value: "payments"}}
+
+	tests := []struct {
+		name string
+		host *Host
+		want bool
+	}{
+		{name: "host tag", host: &Host{Tags: JSON{"team": "payments"}}, want: true},
+		{name: "non-string tag value", host: &Host{Tags: JSON{"team": 1}}, want: false},
+		{name: "agent label is ignored", host: &Host{Labels: JSON{"team": "payments"}}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scope.MatchesHost(tt.host); got != tt.want {
+				t.Errorf("MatchesHost = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEffectiveHostScope(t *testing.T) {
+	scope := HostScope{{Key: "team", Value: "payments"}}
+
+	for _, tt := range []struct {
+		role       UserRole
+		restricted bool
+	}{
+		{UserRoleViewer, true},
+		{UserRoleOperator, true},
+		{UserRoleAdmin, false},
+	} {
+		user := &User{Role: tt.role, HostScope: scope}
+		if got := user.EffectiveHostScope().IsRestricted(); got != tt.restricted {
+			t.Errorf("%s EffectiveHostScope restricted = %v, want %v", tt.role, got, tt.restricted)
+		}
+	}
+}
@@ -23,34 +23,1723 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// 命令输出流类型
+type OutputStream int32
+
+const (
+	OutputStream_OUTPUT_STREAM_STDOUT OutputStream = 0 // 标准输出
+	OutputStream_OUTPUT_STREAM_STDERR OutputStream = 1 // 错误输出
+)
+
+// Enum value maps for OutputStream.
+var (
+	OutputStream_name = map[int32]string{
+		0: "OUTPUT_STREAM_STDOUT",
+		1: "OUTPUT_STREAM_STDERR",
+	}
+	OutputStream_value = map[string]int32{
+		"OUTPUT_STREAM_STDOUT": 0,
+		"OUTPUT_STREAM_STDERR": 1,
+	}
+)
+
+func (x OutputStream) Enum() *OutputStream {
+	p := new(OutputStream)
+	*p = x
+	return p
+}
+
+func (x OutputStream) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (OutputStream) Descriptor() protoreflect.EnumDescriptor {
+	return file_command_proto_enumTypes[0].Descriptor()
+}
+
+func (OutputStream) Type() protoreflect.EnumType {
+	return &file_command_proto_enumTypes[0]
+}
+
+func (x OutputStream) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use OutputStream.Descriptor instead.
+func (OutputStream) EnumDescriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{0}
+}
+
+// 命令控制动作
+type ControlAction int32
+
+const (
+	ControlAction_CONTROL_ACTION_CANCEL ControlAction = 0 // 取消命令执行
+	ControlAction_CONTROL_ACTION_SIGNAL ControlAction = 1 // 向命令进程发送信号
+	ControlAction_CONTROL_ACTION_PAUSE  ControlAction = 2 // 暂停命令进程
+	ControlAction_CONTROL_ACTION_RESUME ControlAction = 3 // 恢复已暂停的命令进程
+)
+
+// Enum value maps for ControlAction.
+var (
+	ControlAction_name = map[int32]string{
+		0: "CONTROL_ACTION_CANCEL",
+		1: "CONTROL_ACTION_SIGNAL",
+		2: "CONTROL_ACTION_PAUSE",
+		3: "CONTROL_ACTION_RESUME",
+	}
+	ControlAction_value = map[string]int32{
+		"CONTROL_ACTION_CANCEL": 0,
+		"CONTROL_ACTION_SIGNAL": 1,
+		"CONTROL_ACTION_PAUSE":  2,
+		"CONTROL_ACTION_RESUME": 3,
+	}
+)
+
+func (x ControlAction) Enum() *ControlAction {
+	p := new(ControlAction)
+	*p = x
+	return p
+}
+
+func (x ControlAction) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ControlAction) Descriptor() protoreflect.EnumDescriptor {
+	return file_command_proto_enumTypes[1].Descriptor()
+}
+
+func (ControlAction) Type() protoreflect.EnumType {
+	return &file_command_proto_enumTypes[1]
+}
+
+func (x ControlAction) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ControlAction.Descriptor instead.
+func (ControlAction) EnumDescriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{1}
+}
+
+// 命令在 Agent 上的执行状态
+type ExecutionState int32
+
+const (
+	ExecutionState_EXECUTION_STATE_UNKNOWN   ExecutionState = 0 // 未知
+	ExecutionState_EXECUTION_STATE_RUNNING   ExecutionState = 1 // 执行中
+	ExecutionState_EXECUTION_STATE_PAUSED    ExecutionState = 2 // 已暂停
+	ExecutionState_EXECUTION_STATE_CANCELED  ExecutionState = 3 // 已取消
+	ExecutionState_EXECUTION_STATE_FINISHED  ExecutionState = 4 // 已执行结束
+	ExecutionState_EXECUTION_STATE_NOT_FOUND ExecutionState = 5 // Agent 上没有该命令
+	ExecutionState_EXECUTION_STATE_QUEUED    ExecutionState = 6 // 在 Agent 执行队列中等待
+)
+
+// Enum value maps for ExecutionState.
+var (
+	ExecutionState_name = map[int32]string{
+		0: "EXECUTION_STATE_UNKNOWN",
+		1: "EXECUTION_STATE_RUNNING",
+		2: "EXECUTION_STATE_PAUSED",
+		3: "EXECUTION_STATE_CANCELED",
+		4: "EXECUTION_STATE_FINISHED",
+		5: "EXECUTION_STATE_NOT_FOUND",
+		6: "EXECUTION_STATE_QUEUED",
+	}
+	ExecutionState_value = map[string]int32{
+		"EXECUTION_STATE_UNKNOWN":   0,
+		"EXECUTION_STATE_RUNNING":   1,
+		"EXECUTION_STATE_PAUSED":    2,
+		"EXECUTION_STATE_CANCELED":  3,
+		"EXECUTION_STATE_FINISHED":  4,
+		"EXECUTION_STATE_NOT_FOUND": 5,
+		"EXECUTION_STATE_QUEUED":    6,
+	}
+)
+
+func (x ExecutionState) Enum() *ExecutionState {
+	p := new(ExecutionState)
+	*p = x
+	return p
+}
+
+func (x ExecutionState) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ExecutionState) Descriptor() protoreflect.EnumDescriptor {
+	return file_command_proto_enumTypes[2].Descriptor()
+}
+
+func (ExecutionState) Type() protoreflect.EnumType {
+	return &file_command_proto_enumTypes[2]
+}
+
+func (x ExecutionState) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ExecutionState.Descriptor instead.
+func (ExecutionState) EnumDescriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{2}
+}
+
 // 命令内容
 type CommandContent struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"` // 命令 ID
-	HostId        string                 `protobuf:"bytes,2,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`          // 目标主机 ID
-	Command       string                 `protobuf:"bytes,3,opt,name=command,proto3" json:"command,omitempty"`                      // 命令内容
-	Parameters    string                 `protobuf:"bytes,4,opt,name=parameters,proto3" json:"parameters,omitempty"`                // 命令参数
-	Timeout       *durationpb.Duration   `protobuf:"bytes,5,opt,name=timeout,proto3" json:"timeout,omitempty"`                      // 超时时间
-	CreatedAt     *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"` // 创建时间
+	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`       // 命令 ID
+	HostId        string                 `protobuf:"bytes,2,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`                // 目标主机 ID
+	Command       string                 `protobuf:"bytes,3,opt,name=command,proto3" json:"command,omitempty"`                            // 命令内容
+	Parameters    string                 `protobuf:"bytes,4,opt,name=parameters,proto3" json:"parameters,omitempty"`                      // 命令参数
+	Timeout       *durationpb.Duration   `protobuf:"bytes,5,opt,name=timeout,proto3" json:"timeout,omitempty"`                            // 超时时间
+	CreatedAt     *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`       // 创建时间
+	RequestedBy   string                 `protobuf:"bytes,7,opt,name=requested_by,json=requestedBy,proto3" json:"requested_by,omitempty"` // 发起用户（Agent 按用户匹配命令策略）
+	Options       *ExecutionOptions      `protobuf:"bytes,8,opt,name=options,proto3" json:"options,omitempty"`                            // 执行选项
+	Script        *ScriptSpec            `protobuf:"bytes,9,opt,name=script,proto3" json:"script,omitempty"`                              // 脚本模式，不为空时 command 为脚本内容
+	Attempt       uint32                 `protobuf:"varint,10,opt,name=attempt,proto3" json:"attempt,omitempty"`                          // 执行次数，手动重试时递增；Agent 按命令 ID 去重，只执行次数更新的投递
+	File          *FileSpec              `protobuf:"bytes,11,opt,name=file,proto3" json:"file,omitempty"`                                 // 文件分发，不为空时 Server 随后通过 file_chunk 发送文件内容
+	Fetch         *FetchSpec             `protobuf:"bytes,12,opt,name=fetch,proto3" json:"fetch,omitempty"`                               // 文件收集，不为空时 Agent 通过 fetch_chunk 回传匹配的文件
+	Upgrade       *UpgradeSpec           `protobuf:"bytes,13,opt,name=upgrade,proto3" json:"upgrade,omitempty"`                           // Agent 自升级，file 为新版本二进制（不指定 dest_path），Agent 校验后替换自身并重新执行
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *CommandContent) Reset() {
-	*x = CommandContent{}
-	mi := &file_command_proto_msgTypes[0]
+func (x *CommandContent) Reset() {
+	*x = CommandContent{}
+	mi := &file_command_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CommandContent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommandContent) ProtoMessage() {}
+
+func (x *CommandContent) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommandContent.ProtoReflect.Descriptor instead.
+func (*CommandContent) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *CommandContent) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
+
+func (x *CommandContent) GetHostId() string {
+	if x != nil {
+		return x.HostId
+	}
+	return ""
+}
+
+func (x *CommandContent) GetCommand() string {
+	if x != nil {
+		return x.Command
+	}
+	return ""
+}
+
+func (x *CommandContent) GetParameters() string {
+	if x != nil {
+		return x.Parameters
+	}
+	return ""
+}
+
+func (x *CommandContent) GetTimeout() *durationpb.Duration {
+	if x != nil {
+		return x.Timeout
+	}
+	return nil
+}
+
+func (x *CommandContent) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *CommandContent) GetRequestedBy() string {
+	if x != nil {
+		return x.RequestedBy
+	}
+	return ""
+}
+
+func (x *CommandContent) GetOptions() *ExecutionOptions {
+	if x != nil {
+		return x.Options
+	}
+	return nil
+}
+
+func (x *CommandContent) GetScript() *ScriptSpec {
+	if x != nil {
+		return x.Script
+	}
+	return nil
+}
+
+func (x *CommandContent) GetAttempt() uint32 {
+	if x != nil {
+		return x.Attempt
+	}
+	return 0
+}
+
+func (x *CommandContent) GetFile() *FileSpec {
+	if x != nil {
+		return x.File
+	}
+	return nil
+}
+
+func (x *CommandContent) GetFetch() *FetchSpec {
+	if x != nil {
+		return x.Fetch
+	}
+	return nil
+}
+
+func (x *CommandContent) GetUpgrade() *UpgradeSpec {
+	if x != nil {
+		return x.Upgrade
+	}
+	return nil
+}
+
+// 文件分发参数
+type FileSpec struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Sha256         string                 `protobuf:"bytes,1,opt,name=sha256,proto3" json:"sha256,omitempty"`                                         // 文件内容的 SHA-256（十六进制），Agent 写入完成后校验
+	Size           int64                  `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`                                            // 文件大小（字节）
+	DestPath       string                 `protobuf:"bytes,3,opt,name=dest_path,json=destPath,proto3" json:"dest_path,omitempty"`                     // 目标路径（绝对路径）
+	Owner          string                 `protobuf:"bytes,4,opt,name=owner,proto3" json:"owner,omitempty"`                                           // 属主（用户名或 UID），为空时不修改
+	Group          string                 `protobuf:"bytes,5,opt,name=group,proto3" json:"group,omitempty"`                                           // 属组（组名或 GID），为空时使用属主的主组
+	Mode           string                 `protobuf:"bytes,6,opt,name=mode,proto3" json:"mode,omitempty"`                                             // 八进制权限，如 "0644"
+	Name           string                 `protobuf:"bytes,7,opt,name=name,proto3" json:"name,omitempty"`                                             // 上传时的文件名
+	Peer           bool                   `protobuf:"varint,8,opt,name=peer,proto3" json:"peer,omitempty"`                                            // 对等分发，Agent 按分片向 Server 请求，Server 可指派同区域已持有分片的 Agent 提供
+	ChunkSize      int64                  `protobuf:"varint,9,opt,name=chunk_size,json=chunkSize,proto3" json:"chunk_size,omitempty"`                 // 按分片请求时的分片大小（字节），最后一个分片可能更小
+	ChunkSha256    []string               `protobuf:"bytes,10,rep,name=chunk_sha256,json=chunkSha256,proto3" json:"chunk_sha256,omitempty"`           // 各分片的 SHA-256，按分片序号排列，分片按摘要缓存和提供；不为空时 Agent 按分片请求，命令流断开重连后从未完成的分片继续
+	BandwidthLimit int64                  `protobuf:"varint,11,opt,name=bandwidth_limit,json=bandwidthLimit,proto3" json:"bandwidth_limit,omitempty"` // 该主机接收文件的限速（字节/秒），0 表示不限速，Agent 从对等 Agent 获取分片时遵守
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *FileSpec) Reset() {
+	*x = FileSpec{}
+	mi := &file_command_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FileSpec) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FileSpec) ProtoMessage() {}
+
+func (x *FileSpec) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FileSpec.ProtoReflect.Descriptor instead.
+func (*FileSpec) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *FileSpec) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
+
+func (x *FileSpec) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *FileSpec) GetDestPath() string {
+	if x != nil {
+		return x.DestPath
+	}
+	return ""
+}
+
+func (x *FileSpec) GetOwner() string {
+	if x != nil {
+		return x.Owner
+	}
+	return ""
+}
+
+func (x *FileSpec) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *FileSpec) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+func (x *FileSpec) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *FileSpec) GetPeer() bool {
+	if x != nil {
+		return x.Peer
+	}
+	return false
+}
+
+func (x *FileSpec) GetChunkSize() int64 {
+	if x != nil {
+		return x.ChunkSize
+	}
+	return 0
+}
+
+func (x *FileSpec) GetChunkSha256() []string {
+	if x != nil {
+		return x.ChunkSha256
+	}
+	return nil
+}
+
+func (x *FileSpec) GetBandwidthLimit() int64 {
+	if x != nil {
+		return x.BandwidthLimit
+	}
+	return 0
+}
+
+// Agent 自升级参数
+type UpgradeSpec struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Version          string                 `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`                                            // 目标版本，新版本二进制以 -version 运行时须输出该版本
+	Signature        string                 `protobuf:"bytes,2,opt,name=signature,proto3" json:"signature,omitempty"`                                        // 二进制内容的 Ed25519 签名（Base64），Agent 配置了公钥时必须校验通过
+	ReconnectTimeout int64                  `protobuf:"varint,3,opt,name=reconnect_timeout,json=reconnectTimeout,proto3" json:"reconnect_timeout,omitempty"` // 新版本启动后须在该时长（秒）内连上 Server，否则 Agent 回滚到旧版本
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *UpgradeSpec) Reset() {
+	*x = UpgradeSpec{}
+	mi := &file_command_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpgradeSpec) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpgradeSpec) ProtoMessage() {}
+
+func (x *UpgradeSpec) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpgradeSpec.ProtoReflect.Descriptor instead.
+func (*UpgradeSpec) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *UpgradeSpec) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *UpgradeSpec) GetSignature() string {
+	if x != nil {
+		return x.Signature
+	}
+	return ""
+}
+
+func (x *UpgradeSpec) GetReconnectTimeout() int64 {
+	if x != nil {
+		return x.ReconnectTimeout
+	}
+	return 0
+}
+
+// 文件数据分片（Server 下发给 Agent），按 offset 顺序发送；对等分发时逐个回复 Agent 的分片请求
+type FileChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"` // 文件分发命令 ID
+	Offset        int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`                       // 分片在文件中的偏移
+	Data          []byte                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`                            // 分片数据
+	Last          bool                   `protobuf:"varint,4,opt,name=last,proto3" json:"last,omitempty"`                           // 是否为最后一个分片
+	Error         string                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`                          // Server 读取文件失败时中止传输
+	Peer          *FilePeer              `protobuf:"bytes,6,opt,name=peer,proto3" json:"peer,omitempty"`                            // 对等分发时不为空表示改为从该 Agent 获取分片，data 为空
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FileChunk) Reset() {
+	*x = FileChunk{}
+	mi := &file_command_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FileChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FileChunk) ProtoMessage() {}
+
+func (x *FileChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FileChunk.ProtoReflect.Descriptor instead.
+func (*FileChunk) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *FileChunk) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
+
+func (x *FileChunk) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *FileChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *FileChunk) GetLast() bool {
+	if x != nil {
+		return x.Last
+	}
+	return false
+}
+
+func (x *FileChunk) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *FileChunk) GetPeer() *FilePeer {
+	if x != nil {
+		return x.Peer
+	}
+	return nil
+}
+
+// 提供文件分片的对等 Agent
+type FilePeer struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	HostId        string                 `protobuf:"bytes,1,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"` // 主机 ID
+	Address       string                 `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`             // 分片服务地址（host:port）
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FilePeer) Reset() {
+	*x = FilePeer{}
+	mi := &file_command_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FilePeer) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FilePeer) ProtoMessage() {}
+
+func (x *FilePeer) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FilePeer.ProtoReflect.Descriptor instead.
+func (*FilePeer) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *FilePeer) GetHostId() string {
+	if x != nil {
+		return x.HostId
+	}
+	return ""
+}
+
+func (x *FilePeer) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+// 对等分发授权（Server 发送给提供分片的 Agent），允许指定主机获取该分片，指派对等 Agent 前发送
+type PeerGrant struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	HostId        string                 `protobuf:"bytes,1,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`                // 获准获取分片的主机 ID（客户端证书 CN）
+	ChunkSha256   string                 `protobuf:"bytes,2,opt,name=chunk_sha256,json=chunkSha256,proto3" json:"chunk_sha256,omitempty"` // 分片的 SHA-256
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PeerGrant) Reset() {
+	*x = PeerGrant{}
+	mi := &file_command_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PeerGrant) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PeerGrant) ProtoMessage() {}
+
+func (x *PeerGrant) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PeerGrant.ProtoReflect.Descriptor instead.
+func (*PeerGrant) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *PeerGrant) GetHostId() string {
+	if x != nil {
+		return x.HostId
+	}
+	return ""
+}
+
+func (x *PeerGrant) GetChunkSha256() string {
+	if x != nil {
+		return x.ChunkSha256
+	}
+	return ""
+}
+
+// 已吊销的证书（Server 发送给 Agent），建立命令流后及吊销生效时发送完整列表，对等分发双方拒绝这些证书
+type RevocationList struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Serials       []string               `protobuf:"bytes,1,rep,name=serials,proto3" json:"serials,omitempty"` // 已吊销证书的序列号（小写十六进制）
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RevocationList) Reset() {
+	*x = RevocationList{}
+	mi := &file_command_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RevocationList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RevocationList) ProtoMessage() {}
+
+func (x *RevocationList) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RevocationList.ProtoReflect.Descriptor instead.
+func (*RevocationList) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *RevocationList) GetSerials() []string {
+	if x != nil {
+		return x.Serials
+	}
+	return nil
+}
+
+// 分片请求（Agent 发送给 Server），Server 以 file_chunk 回复分片数据，对等分发时也可能回复对等 Agent
+type FileChunkRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`                  // 文件分发命令 ID
+	HostId        string                 `protobuf:"bytes,2,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`                           // 主机 ID
+	Index         int64                  `protobuf:"varint,3,opt,name=index,proto3" json:"index,omitempty"`                                          // 分片序号
+	FailedPeers   []string               `protobuf:"bytes,4,rep,name=failed_peers,json=failedPeers,proto3" json:"failed_peers,omitempty"`            // 获取该分片失败的对等 Agent，Server 不再指派
+	CorruptPeers  []string               `protobuf:"bytes,5,rep,name=corrupt_peers,json=corruptPeers,proto3" json:"corrupt_peers,omitempty"`         // 提供的分片摘要不符的对等 Agent，Server 不再为该文件指派
+	StoredChunks  []int64                `protobuf:"varint,6,rep,packed,name=stored_chunks,json=storedChunks,proto3" json:"stored_chunks,omitempty"` // 上次请求后已校验摘要并放入分片缓存的分片序号，Server 此后才指派请求方提供这些分片
+	ReportOnly    bool                   `protobuf:"varint,7,opt,name=report_only,json=reportOnly,proto3" json:"report_only,omitempty"`              // 只上报 stored_chunks，不请求分片，Server 不回复
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FileChunkRequest) Reset() {
+	*x = FileChunkRequest{}
+	mi := &file_command_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FileChunkRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FileChunkRequest) ProtoMessage() {}
+
+func (x *FileChunkRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FileChunkRequest.ProtoReflect.Descriptor instead.
+func (*FileChunkRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *FileChunkRequest) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
+
+func (x *FileChunkRequest) GetHostId() string {
+	if x != nil {
+		return x.HostId
+	}
+	return ""
+}
+
+func (x *FileChunkRequest) GetIndex() int64 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *FileChunkRequest) GetFailedPeers() []string {
+	if x != nil {
+		return x.FailedPeers
+	}
+	return nil
+}
+
+func (x *FileChunkRequest) GetCorruptPeers() []string {
+	if x != nil {
+		return x.CorruptPeers
+	}
+	return nil
+}
+
+func (x *FileChunkRequest) GetStoredChunks() []int64 {
+	if x != nil {
+		return x.StoredChunks
+	}
+	return nil
+}
+
+func (x *FileChunkRequest) GetReportOnly() bool {
+	if x != nil {
+		return x.ReportOnly
+	}
+	return false
+}
+
+// 文件收集参数
+type FetchSpec struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Patterns       []string               `protobuf:"bytes,1,rep,name=patterns,proto3" json:"patterns,omitempty"`                                    // 文件路径或通配符（绝对路径）
+	MaxFileBytes   int64                  `protobuf:"varint,2,opt,name=max_file_bytes,json=maxFileBytes,proto3" json:"max_file_bytes,omitempty"`     // 单个文件的最大字节数，超过时跳过该文件
+	MaxTotalBytes  int64                  `protobuf:"varint,3,opt,name=max_total_bytes,json=maxTotalBytes,proto3" json:"max_total_bytes,omitempty"`  // 收集的总字节数上限，达到后不再收集其余文件
+	BandwidthLimit int64                  `protobuf:"varint,4,opt,name=bandwidth_limit,json=bandwidthLimit,proto3" json:"bandwidth_limit,omitempty"` // 回传文件的限速（字节/秒），0 表示不限速
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *FetchSpec) Reset() {
+	*x = FetchSpec{}
+	mi := &file_command_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FetchSpec) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FetchSpec) ProtoMessage() {}
+
+func (x *FetchSpec) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FetchSpec.ProtoReflect.Descriptor instead.
+func (*FetchSpec) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *FetchSpec) GetPatterns() []string {
+	if x != nil {
+		return x.Patterns
+	}
+	return nil
+}
+
+func (x *FetchSpec) GetMaxFileBytes() int64 {
+	if x != nil {
+		return x.MaxFileBytes
+	}
+	return 0
+}
+
+func (x *FetchSpec) GetMaxTotalBytes() int64 {
+	if x != nil {
+		return x.MaxTotalBytes
+	}
+	return 0
+}
+
+func (x *FetchSpec) GetBandwidthLimit() int64 {
+	if x != nil {
+		return x.BandwidthLimit
+	}
+	return 0
+}
+
+// 收集的文件数据分片（Agent 回传给 Server），逐个文件按 offset 顺序发送
+type FetchChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"` // 文件收集命令 ID
+	HostId        string                 `protobuf:"bytes,2,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`          // 主机 ID
+	Path          string                 `protobuf:"bytes,3,opt,name=path,proto3" json:"path,omitempty"`                            // 文件在主机上的绝对路径
+	Offset        int64                  `protobuf:"varint,4,opt,name=offset,proto3" json:"offset,omitempty"`                       // 分片在文件中的偏移
+	Data          []byte                 `protobuf:"bytes,5,opt,name=data,proto3" json:"data,omitempty"`                            // 分片数据
+	Eof           bool                   `protobuf:"varint,6,opt,name=eof,proto3" json:"eof,omitempty"`                             // 是否为该文件的最后一个分片
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FetchChunk) Reset() {
+	*x = FetchChunk{}
+	mi := &file_command_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FetchChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FetchChunk) ProtoMessage() {}
+
+func (x *FetchChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FetchChunk.ProtoReflect.Descriptor instead.
+func (*FetchChunk) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *FetchChunk) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
+
+func (x *FetchChunk) GetHostId() string {
+	if x != nil {
+		return x.HostId
+	}
+	return ""
+}
+
+func (x *FetchChunk) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *FetchChunk) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *FetchChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *FetchChunk) GetEof() bool {
+	if x != nil {
+		return x.Eof
+	}
+	return false
+}
+
+// 脚本执行参数
+type ScriptSpec struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Interpreter   string                 `protobuf:"bytes,1,opt,name=interpreter,proto3" json:"interpreter,omitempty"` // 解释器：bash、sh、python3、perl
+	Args          []string               `protobuf:"bytes,2,rep,name=args,proto3" json:"args,omitempty"`               // 脚本参数
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScriptSpec) Reset() {
+	*x = ScriptSpec{}
+	mi := &file_command_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScriptSpec) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScriptSpec) ProtoMessage() {}
+
+func (x *ScriptSpec) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScriptSpec.ProtoReflect.Descriptor instead.
+func (*ScriptSpec) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ScriptSpec) GetInterpreter() string {
+	if x != nil {
+		return x.Interpreter
+	}
+	return ""
+}
+
+func (x *ScriptSpec) GetArgs() []string {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+// 命令执行选项
+type ExecutionOptions struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RunAsUser     string                 `protobuf:"bytes,1,opt,name=run_as_user,json=runAsUser,proto3" json:"run_as_user,omitempty"`                                            // 执行用户（用户名或 UID），为空时使用 Agent 配置的默认执行用户
+	RunAsGroup    string                 `protobuf:"bytes,2,opt,name=run_as_group,json=runAsGroup,proto3" json:"run_as_group,omitempty"`                                         // 执行用户组（组名或 GID），为空时使用执行用户的主组
+	WorkingDir    string                 `protobuf:"bytes,3,opt,name=working_dir,json=workingDir,proto3" json:"working_dir,omitempty"`                                           // 工作目录（绝对路径），为空时使用执行用户的主目录
+	Env           map[string]string      `protobuf:"bytes,4,rep,name=env,proto3" json:"env,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // 追加的环境变量
+	Umask         string                 `protobuf:"bytes,5,opt,name=umask,proto3" json:"umask,omitempty"`                                                                       // 八进制权限掩码，如 "0022"，为空时继承 Agent
+	Stdin         []byte                 `protobuf:"bytes,6,opt,name=stdin,proto3" json:"stdin,omitempty"`                                                                       // 标准输入内容
+	Resources     *ResourceLimits        `protobuf:"bytes,7,opt,name=resources,proto3" json:"resources,omitempty"`                                                               // 资源限制（Linux cgroup v2）
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExecutionOptions) Reset() {
+	*x = ExecutionOptions{}
+	mi := &file_command_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExecutionOptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExecutionOptions) ProtoMessage() {}
+
+func (x *ExecutionOptions) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExecutionOptions.ProtoReflect.Descriptor instead.
+func (*ExecutionOptions) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ExecutionOptions) GetRunAsUser() string {
+	if x != nil {
+		return x.RunAsUser
+	}
+	return ""
+}
+
+func (x *ExecutionOptions) GetRunAsGroup() string {
+	if x != nil {
+		return x.RunAsGroup
+	}
+	return ""
+}
+
+func (x *ExecutionOptions) GetWorkingDir() string {
+	if x != nil {
+		return x.WorkingDir
+	}
+	return ""
+}
+
+func (x *ExecutionOptions) GetEnv() map[string]string {
+	if x != nil {
+		return x.Env
+	}
+	return nil
+}
+
+func (x *ExecutionOptions) GetUmask() string {
+	if x != nil {
+		return x.Umask
+	}
+	return ""
+}
+
+func (x *ExecutionOptions) GetStdin() []byte {
+	if x != nil {
+		return x.Stdin
+	}
+	return nil
+}
+
+func (x *ExecutionOptions) GetResources() *ResourceLimits {
+	if x != nil {
+		return x.Resources
+	}
+	return nil
+}
+
+// 命令资源限制，字段为 0 时不限制
+type ResourceLimits struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CpuQuota      float64                `protobuf:"fixed64,1,opt,name=cpu_quota,json=cpuQuota,proto3" json:"cpu_quota,omitempty"`         // CPU 上限（核数），如 0.5 表示半个核
+	MemoryBytes   int64                  `protobuf:"varint,2,opt,name=memory_bytes,json=memoryBytes,proto3" json:"memory_bytes,omitempty"` // 内存上限（字节），超出时命令被 OOM killer 终止
+	IoWeight      uint32                 `protobuf:"varint,3,opt,name=io_weight,json=ioWeight,proto3" json:"io_weight,omitempty"`          // IO 权重，1-10000，默认 100
+	MaxPids       int64                  `protobuf:"varint,4,opt,name=max_pids,json=maxPids,proto3" json:"max_pids,omitempty"`             // 最大进程数
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResourceLimits) Reset() {
+	*x = ResourceLimits{}
+	mi := &file_command_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResourceLimits) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResourceLimits) ProtoMessage() {}
+
+func (x *ResourceLimits) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResourceLimits.ProtoReflect.Descriptor instead.
+func (*ResourceLimits) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ResourceLimits) GetCpuQuota() float64 {
+	if x != nil {
+		return x.CpuQuota
+	}
+	return 0
+}
+
+func (x *ResourceLimits) GetMemoryBytes() int64 {
+	if x != nil {
+		return x.MemoryBytes
+	}
+	return 0
+}
+
+func (x *ResourceLimits) GetIoWeight() uint32 {
+	if x != nil {
+		return x.IoWeight
+	}
+	return 0
+}
+
+func (x *ResourceLimits) GetMaxPids() int64 {
+	if x != nil {
+		return x.MaxPids
+	}
+	return 0
+}
+
+// 命令执行结果
+type CommandResult struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	CommandId         string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`                          // 命令 ID
+	HostId            string                 `protobuf:"bytes,2,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`                                   // 执行主机 ID
+	Stdout            string                 `protobuf:"bytes,3,opt,name=stdout,proto3" json:"stdout,omitempty"`                                                 // 标准输出
+	Stderr            string                 `protobuf:"bytes,4,opt,name=stderr,proto3" json:"stderr,omitempty"`                                                 // 错误输出
+	ExitCode          int32                  `protobuf:"varint,5,opt,name=exit_code,json=exitCode,proto3" json:"exit_code,omitempty"`                            // 退出码
+	StartedAt         *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`                          // 开始执行时间
+	FinishedAt        *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=finished_at,json=finishedAt,proto3" json:"finished_at,omitempty"`                       // 完成时间
+	ErrorMessage      string                 `protobuf:"bytes,8,opt,name=error_message,json=errorMessage,proto3" json:"error_message,omitempty"`                 // 执行错误信息（若有）
+	Truncated         bool                   `protobuf:"varint,9,opt,name=truncated,proto3" json:"truncated,omitempty"`                                          // 输出超过上限，stdout/stderr 只保留首尾部分
+	StdoutSize        uint64                 `protobuf:"varint,10,opt,name=stdout_size,json=stdoutSize,proto3" json:"stdout_size,omitempty"`                     // 标准输出原始字节数
+	StderrSize        uint64                 `protobuf:"varint,11,opt,name=stderr_size,json=stderrSize,proto3" json:"stderr_size,omitempty"`                     // 错误输出原始字节数
+	TimedOut          bool                   `protobuf:"varint,12,opt,name=timed_out,json=timedOut,proto3" json:"timed_out,omitempty"`                           // 命令因超时被终止
+	TerminationSignal string                 `protobuf:"bytes,13,opt,name=termination_signal,json=terminationSignal,proto3" json:"termination_signal,omitempty"` // 超时或取消时最终发送给进程树的信号，如 SIGTERM、SIGKILL
+	OomKilled         bool                   `protobuf:"varint,14,opt,name=oom_killed,json=oomKilled,proto3" json:"oom_killed,omitempty"`                        // 命令进程因超出内存限制被 OOM killer 终止
+	Busy              bool                   `protobuf:"varint,15,opt,name=busy,proto3" json:"busy,omitempty"`                                                   // Agent 执行队列已满，命令未执行
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *CommandResult) Reset() {
+	*x = CommandResult{}
+	mi := &file_command_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CommandResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommandResult) ProtoMessage() {}
+
+func (x *CommandResult) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommandResult.ProtoReflect.Descriptor instead.
+func (*CommandResult) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *CommandResult) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
+
+func (x *CommandResult) GetHostId() string {
+	if x != nil {
+		return x.HostId
+	}
+	return ""
+}
+
+func (x *CommandResult) GetStdout() string {
+	if x != nil {
+		return x.Stdout
+	}
+	return ""
+}
+
+func (x *CommandResult) GetStderr() string {
+	if x != nil {
+		return x.Stderr
+	}
+	return ""
+}
+
+func (x *CommandResult) GetExitCode() int32 {
+	if x != nil {
+		return x.ExitCode
+	}
+	return 0
+}
+
+func (x *CommandResult) GetStartedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartedAt
+	}
+	return nil
+}
+
+func (x *CommandResult) GetFinishedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.FinishedAt
+	}
+	return nil
+}
+
+func (x *CommandResult) GetErrorMessage() string {
+	if x != nil {
+		return x.ErrorMessage
+	}
+	return ""
+}
+
+func (x *CommandResult) GetTruncated() bool {
+	if x != nil {
+		return x.Truncated
+	}
+	return false
+}
+
+func (x *CommandResult) GetStdoutSize() uint64 {
+	if x != nil {
+		return x.StdoutSize
+	}
+	return 0
+}
+
+func (x *CommandResult) GetStderrSize() uint64 {
+	if x != nil {
+		return x.StderrSize
+	}
+	return 0
+}
+
+func (x *CommandResult) GetTimedOut() bool {
+	if x != nil {
+		return x.TimedOut
+	}
+	return false
+}
+
+func (x *CommandResult) GetTerminationSignal() string {
+	if x != nil {
+		return x.TerminationSignal
+	}
+	return ""
+}
+
+func (x *CommandResult) GetOomKilled() bool {
+	if x != nil {
+		return x.OomKilled
+	}
+	return false
+}
+
+func (x *CommandResult) GetBusy() bool {
+	if x != nil {
+		return x.Busy
+	}
+	return false
+}
+
+// 命令输出分片（命令执行过程中 Agent 增量发送）
+type CommandOutputChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`     // 命令 ID
+	HostId        string                 `protobuf:"bytes,2,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`              // 执行主机 ID
+	Sequence      uint64                 `protobuf:"varint,3,opt,name=sequence,proto3" json:"sequence,omitempty"`                       // 分片序号，同一命令内从 1 开始递增
+	Stream        OutputStream           `protobuf:"varint,4,opt,name=stream,proto3,enum=minexus.OutputStream" json:"stream,omitempty"` // 输出流类型
+	Data          []byte                 `protobuf:"bytes,5,opt,name=data,proto3" json:"data,omitempty"`                                // 输出内容
+	Timestamp     *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`                      // 产生时间
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CommandOutputChunk) Reset() {
+	*x = CommandOutputChunk{}
+	mi := &file_command_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CommandOutputChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommandOutputChunk) ProtoMessage() {}
+
+func (x *CommandOutputChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommandOutputChunk.ProtoReflect.Descriptor instead.
+func (*CommandOutputChunk) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *CommandOutputChunk) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
+
+func (x *CommandOutputChunk) GetHostId() string {
+	if x != nil {
+		return x.HostId
+	}
+	return ""
+}
+
+func (x *CommandOutputChunk) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *CommandOutputChunk) GetStream() OutputStream {
+	if x != nil {
+		return x.Stream
+	}
+	return OutputStream_OUTPUT_STREAM_STDOUT
+}
+
+func (x *CommandOutputChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *CommandOutputChunk) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+// 命令控制请求（Server 下发给 Agent）
+type ControlRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ControlId     string                 `protobuf:"bytes,1,opt,name=control_id,json=controlId,proto3" json:"control_id,omitempty"`       // 控制请求 ID
+	CommandId     string                 `protobuf:"bytes,2,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`       // 目标命令 ID
+	HostId        string                 `protobuf:"bytes,3,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`                // 目标主机 ID
+	Action        ControlAction          `protobuf:"varint,4,opt,name=action,proto3,enum=minexus.ControlAction" json:"action,omitempty"`  // 控制动作
+	Signal        int32                  `protobuf:"varint,5,opt,name=signal,proto3" json:"signal,omitempty"`                             // 信号编号（仅 SIGNAL 使用）
+	RequestedBy   string                 `protobuf:"bytes,6,opt,name=requested_by,json=requestedBy,proto3" json:"requested_by,omitempty"` // 发起用户
+	CreatedAt     *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`       // 创建时间
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ControlRequest) Reset() {
+	*x = ControlRequest{}
+	mi := &file_command_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ControlRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ControlRequest) ProtoMessage() {}
+
+func (x *ControlRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ControlRequest.ProtoReflect.Descriptor instead.
+func (*ControlRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *ControlRequest) GetControlId() string {
+	if x != nil {
+		return x.ControlId
+	}
+	return ""
+}
+
+func (x *ControlRequest) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
+
+func (x *ControlRequest) GetHostId() string {
+	if x != nil {
+		return x.HostId
+	}
+	return ""
+}
+
+func (x *ControlRequest) GetAction() ControlAction {
+	if x != nil {
+		return x.Action
+	}
+	return ControlAction_CONTROL_ACTION_CANCEL
+}
+
+func (x *ControlRequest) GetSignal() int32 {
+	if x != nil {
+		return x.Signal
+	}
+	return 0
+}
+
+func (x *ControlRequest) GetRequestedBy() string {
+	if x != nil {
+		return x.RequestedBy
+	}
+	return ""
+}
+
+func (x *ControlRequest) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+// 命令控制确认（Agent 处理控制请求后回复）
+type ControlAck struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ControlId     string                 `protobuf:"bytes,1,opt,name=control_id,json=controlId,proto3" json:"control_id,omitempty"`      // 控制请求 ID
+	CommandId     string                 `protobuf:"bytes,2,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`      // 目标命令 ID
+	HostId        string                 `protobuf:"bytes,3,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`               // 执行主机 ID
+	Action        ControlAction          `protobuf:"varint,4,opt,name=action,proto3,enum=minexus.ControlAction" json:"action,omitempty"` // 控制动作
+	Success       bool                   `protobuf:"varint,5,opt,name=success,proto3" json:"success,omitempty"`                          // 是否成功
+	Message       string                 `protobuf:"bytes,6,opt,name=message,proto3" json:"message,omitempty"`                           // 说明信息（失败原因等）
+	State         ExecutionState         `protobuf:"varint,7,opt,name=state,proto3,enum=minexus.ExecutionState" json:"state,omitempty"`  // 处理后命令的执行状态
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ControlAck) Reset() {
+	*x = ControlAck{}
+	mi := &file_command_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ControlAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ControlAck) ProtoMessage() {}
+
+func (x *ControlAck) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ControlAck.ProtoReflect.Descriptor instead.
+func (*ControlAck) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *ControlAck) GetControlId() string {
+	if x != nil {
+		return x.ControlId
+	}
+	return ""
+}
+
+func (x *ControlAck) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
+
+func (x *ControlAck) GetHostId() string {
+	if x != nil {
+		return x.HostId
+	}
+	return ""
+}
+
+func (x *ControlAck) GetAction() ControlAction {
+	if x != nil {
+		return x.Action
+	}
+	return ControlAction_CONTROL_ACTION_CANCEL
+}
+
+func (x *ControlAck) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *ControlAck) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *ControlAck) GetState() ExecutionState {
+	if x != nil {
+		return x.State
+	}
+	return ExecutionState_EXECUTION_STATE_UNKNOWN
+}
+
+// Agent 握手消息（命令流建立后 Agent 发送的第一条消息）
+type AgentHello struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	HostId        string                 `protobuf:"bytes,1,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`                   // 主机 ID
+	AgentVersion  string                 `protobuf:"bytes,2,opt,name=agent_version,json=agentVersion,proto3" json:"agent_version,omitempty"` // Agent 版本
+	Capabilities  []string               `protobuf:"bytes,3,rep,name=capabilities,proto3" json:"capabilities,omitempty"`                     // Agent 支持的能力
+	AuthToken     string                 `protobuf:"bytes,4,opt,name=auth_token,json=authToken,proto3" json:"auth_token,omitempty"`          // 认证令牌
+	PeerAddress   string                 `protobuf:"bytes,5,opt,name=peer_address,json=peerAddress,proto3" json:"peer_address,omitempty"`    // 对等分发的分片服务地址，为空时不向其他 Agent 提供分片
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AgentHello) Reset() {
+	*x = AgentHello{}
+	mi := &file_command_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentHello) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentHello) ProtoMessage() {}
+
+func (x *AgentHello) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentHello.ProtoReflect.Descriptor instead.
+func (*AgentHello) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *AgentHello) GetHostId() string {
+	if x != nil {
+		return x.HostId
+	}
+	return ""
+}
+
+func (x *AgentHello) GetAgentVersion() string {
+	if x != nil {
+		return x.AgentVersion
+	}
+	return ""
+}
+
+func (x *AgentHello) GetCapabilities() []string {
+	if x != nil {
+		return x.Capabilities
+	}
+	return nil
+}
+
+func (x *AgentHello) GetAuthToken() string {
+	if x != nil {
+		return x.AuthToken
+	}
+	return ""
+}
+
+func (x *AgentHello) GetPeerAddress() string {
+	if x != nil {
+		return x.PeerAddress
+	}
+	return ""
+}
+
+// 重连对账，Agent 握手后上报仍在处理的命令，Server 据此恢复跟踪、重新下发未收到的命令
+type ReconcileReport struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	HostId             string                 `protobuf:"bytes,1,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`                                       // 主机 ID
+	RunningCommandIds  []string               `protobuf:"bytes,2,rep,name=running_command_ids,json=runningCommandIds,proto3" json:"running_command_ids,omitempty"`    // 正在执行或排队的命令
+	FinishedCommandIds []string               `protobuf:"bytes,3,rep,name=finished_command_ids,json=finishedCommandIds,proto3" json:"finished_command_ids,omitempty"` // 已完成但结果未被确认的命令，结果随后重发
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *ReconcileReport) Reset() {
+	*x = ReconcileReport{}
+	mi := &file_command_proto_msgTypes[18]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *CommandContent) String() string {
+func (x *ReconcileReport) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*CommandContent) ProtoMessage() {}
+func (*ReconcileReport) ProtoMessage() {}
 
-func (x *CommandContent) ProtoReflect() protoreflect.Message {
-	mi := &file_command_proto_msgTypes[0]
+func (x *ReconcileReport) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[18]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -61,83 +1750,56 @@ func (x *CommandContent) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use CommandContent.ProtoReflect.Descriptor instead.
-func (*CommandContent) Descriptor() ([]byte, []int) {
-	return file_command_proto_rawDescGZIP(), []int{0}
-}
-
-func (x *CommandContent) GetCommandId() string {
-	if x != nil {
-		return x.CommandId
-	}
-	return ""
+// Deprecated: Use ReconcileReport.ProtoReflect.Descriptor instead.
+func (*ReconcileReport) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{18}
 }
 
-func (x *CommandContent) GetHostId() string {
+func (x *ReconcileReport) GetHostId() string {
 	if x != nil {
 		return x.HostId
 	}
 	return ""
 }
 
-func (x *CommandContent) GetCommand() string {
-	if x != nil {
-		return x.Command
-	}
-	return ""
-}
-
-func (x *CommandContent) GetParameters() string {
-	if x != nil {
-		return x.Parameters
-	}
-	return ""
-}
-
-func (x *CommandContent) GetTimeout() *durationpb.Duration {
+func (x *ReconcileReport) GetRunningCommandIds() []string {
 	if x != nil {
-		return x.Timeout
+		return x.RunningCommandIds
 	}
 	return nil
 }
 
-func (x *CommandContent) GetCreatedAt() *timestamppb.Timestamp {
+func (x *ReconcileReport) GetFinishedCommandIds() []string {
 	if x != nil {
-		return x.CreatedAt
+		return x.FinishedCommandIds
 	}
 	return nil
 }
 
-// 命令执行结果
-type CommandResult struct {
+// 心跳消息
+type Heartbeat struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`          // 命令 ID
-	HostId        string                 `protobuf:"bytes,2,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`                   // 执行主机 ID
-	Stdout        string                 `protobuf:"bytes,3,opt,name=stdout,proto3" json:"stdout,omitempty"`                                 // 标准输出
-	Stderr        string                 `protobuf:"bytes,4,opt,name=stderr,proto3" json:"stderr,omitempty"`                                 // 错误输出
-	ExitCode      int32                  `protobuf:"varint,5,opt,name=exit_code,json=exitCode,proto3" json:"exit_code,omitempty"`            // 退出码
-	StartedAt     *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`          // 开始执行时间
-	FinishedAt    *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=finished_at,json=finishedAt,proto3" json:"finished_at,omitempty"`       // 完成时间
-	ErrorMessage  string                 `protobuf:"bytes,8,opt,name=error_message,json=errorMessage,proto3" json:"error_message,omitempty"` // 执行错误信息（若有）
+	HostId        string                 `protobuf:"bytes,1,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"` // 主机 ID
+	Timestamp     *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`         // 发送时间
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *CommandResult) Reset() {
-	*x = CommandResult{}
-	mi := &file_command_proto_msgTypes[1]
+func (x *Heartbeat) Reset() {
+	*x = Heartbeat{}
+	mi := &file_command_proto_msgTypes[19]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *CommandResult) String() string {
+func (x *Heartbeat) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*CommandResult) ProtoMessage() {}
+func (*Heartbeat) ProtoMessage() {}
 
-func (x *CommandResult) ProtoReflect() protoreflect.Message {
-	mi := &file_command_proto_msgTypes[1]
+func (x *Heartbeat) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[19]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -148,79 +1810,123 @@ func (x *CommandResult) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use CommandResult.ProtoReflect.Descriptor instead.
-func (*CommandResult) Descriptor() ([]byte, []int) {
-	return file_command_proto_rawDescGZIP(), []int{1}
+// Deprecated: Use Heartbeat.ProtoReflect.Descriptor instead.
+func (*Heartbeat) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{19}
 }
 
-func (x *CommandResult) GetCommandId() string {
+func (x *Heartbeat) GetHostId() string {
 	if x != nil {
-		return x.CommandId
+		return x.HostId
 	}
 	return ""
 }
 
-func (x *CommandResult) GetHostId() string {
+func (x *Heartbeat) GetTimestamp() *timestamppb.Timestamp {
 	if x != nil {
-		return x.HostId
+		return x.Timestamp
 	}
-	return ""
+	return nil
 }
 
-func (x *CommandResult) GetStdout() string {
-	if x != nil {
-		return x.Stdout
-	}
-	return ""
+// 确认消息
+// Server -> Agent：握手确认（ref_id 为主机 ID）和命令结果确认（ref_id 为命令 ID）
+// Agent -> Server：命令投递确认（ref_id 为命令 ID），Agent 持久化记录命令后回复，失败时 Server 将命令标记为下发失败
+type Ack struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RefId         string                 `protobuf:"bytes,1,opt,name=ref_id,json=refId,proto3" json:"ref_id,omitempty"` // 被确认的消息标识
+	Success       bool                   `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"`         // 是否成功
+	Message       string                 `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`          // 说明信息（失败原因等）
+	Duplicate     bool                   `protobuf:"varint,4,opt,name=duplicate,proto3" json:"duplicate,omitempty"`     // 命令投递确认：Agent 此前已收到该命令，本次未重复执行
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
-func (x *CommandResult) GetStderr() string {
+func (x *Ack) Reset() {
+	*x = Ack{}
+	mi := &file_command_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Ack) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ack) ProtoMessage() {}
+
+func (x *Ack) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[20]
 	if x != nil {
-		return x.Stderr
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
 	}
-	return ""
+	return mi.MessageOf(x)
 }
 
-func (x *CommandResult) GetExitCode() int32 {
+// Deprecated: Use Ack.ProtoReflect.Descriptor instead.
+func (*Ack) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *Ack) GetRefId() string {
 	if x != nil {
-		return x.ExitCode
+		return x.RefId
 	}
-	return 0
+	return ""
 }
 
-func (x *CommandResult) GetStartedAt() *timestamppb.Timestamp {
+func (x *Ack) GetSuccess() bool {
 	if x != nil {
-		return x.StartedAt
+		return x.Success
 	}
-	return nil
+	return false
 }
 
-func (x *CommandResult) GetFinishedAt() *timestamppb.Timestamp {
+func (x *Ack) GetMessage() string {
 	if x != nil {
-		return x.FinishedAt
+		return x.Message
 	}
-	return nil
+	return ""
 }
 
-func (x *CommandResult) GetErrorMessage() string {
+func (x *Ack) GetDuplicate() bool {
 	if x != nil {
-		return x.ErrorMessage
+		return x.Duplicate
 	}
-	return ""
+	return false
 }
 
 // 命令消息（用于双向流通信）
 type CommandMessage struct {
-	state          protoimpl.MessageState `protogen:"open.v1"`
-	CommandContent *CommandContent        `protobuf:"bytes,1,opt,name=command_content,json=commandContent,proto3" json:"command_content,omitempty"` // 命令内容
-	CommandResult  *CommandResult         `protobuf:"bytes,2,opt,name=command_result,json=commandResult,proto3" json:"command_result,omitempty"`    // 命令结果
-	unknownFields  protoimpl.UnknownFields
-	sizeCache      protoimpl.SizeCache
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Payload:
+	//
+	//	*CommandMessage_CommandContent
+	//	*CommandMessage_CommandResult
+	//	*CommandMessage_Hello
+	//	*CommandMessage_Heartbeat
+	//	*CommandMessage_Ack
+	//	*CommandMessage_OutputChunk
+	//	*CommandMessage_Control
+	//	*CommandMessage_ControlAck
+	//	*CommandMessage_Reconcile
+	//	*CommandMessage_FileChunk
+	//	*CommandMessage_FetchChunk
+	//	*CommandMessage_FileChunkRequest
+	//	*CommandMessage_PeerGrant
+	//	*CommandMessage_Revocations
+	Payload       isCommandMessage_Payload `protobuf_oneof:"payload"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *CommandMessage) Reset() {
 	*x = CommandMessage{}
-	mi := &file_command_proto_msgTypes[2]
+	mi := &file_command_proto_msgTypes[21]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -232,7 +1938,7 @@ func (x *CommandMessage) String() string {
 func (*CommandMessage) ProtoMessage() {}
 
 func (x *CommandMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_command_proto_msgTypes[2]
+	mi := &file_command_proto_msgTypes[21]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -245,28 +1951,235 @@ func (x *CommandMessage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CommandMessage.ProtoReflect.Descriptor instead.
 func (*CommandMessage) Descriptor() ([]byte, []int) {
-	return file_command_proto_rawDescGZIP(), []int{2}
+	return file_command_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *CommandMessage) GetPayload() isCommandMessage_Payload {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
 }
 
 func (x *CommandMessage) GetCommandContent() *CommandContent {
 	if x != nil {
-		return x.CommandContent
+		if x, ok := x.Payload.(*CommandMessage_CommandContent); ok {
+			return x.CommandContent
+		}
 	}
 	return nil
 }
 
 func (x *CommandMessage) GetCommandResult() *CommandResult {
 	if x != nil {
-		return x.CommandResult
+		if x, ok := x.Payload.(*CommandMessage_CommandResult); ok {
+			return x.CommandResult
+		}
+	}
+	return nil
+}
+
+func (x *CommandMessage) GetHello() *AgentHello {
+	if x != nil {
+		if x, ok := x.Payload.(*CommandMessage_Hello); ok {
+			return x.Hello
+		}
+	}
+	return nil
+}
+
+func (x *CommandMessage) GetHeartbeat() *Heartbeat {
+	if x != nil {
+		if x, ok := x.Payload.(*CommandMessage_Heartbeat); ok {
+			return x.Heartbeat
+		}
+	}
+	return nil
+}
+
+func (x *CommandMessage) GetAck() *Ack {
+	if x != nil {
+		if x, ok := x.Payload.(*CommandMessage_Ack); ok {
+			return x.Ack
+		}
+	}
+	return nil
+}
+
+func (x *CommandMessage) GetOutputChunk() *CommandOutputChunk {
+	if x != nil {
+		if x, ok := x.Payload.(*CommandMessage_OutputChunk); ok {
+			return x.OutputChunk
+		}
+	}
+	return nil
+}
+
+func (x *CommandMessage) GetControl() *ControlRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*CommandMessage_Control); ok {
+			return x.Control
+		}
+	}
+	return nil
+}
+
+func (x *CommandMessage) GetControlAck() *ControlAck {
+	if x != nil {
+		if x, ok := x.Payload.(*CommandMessage_ControlAck); ok {
+			return x.ControlAck
+		}
+	}
+	return nil
+}
+
+func (x *CommandMessage) GetReconcile() *ReconcileReport {
+	if x != nil {
+		if x, ok := x.Payload.(*CommandMessage_Reconcile); ok {
+			return x.Reconcile
+		}
+	}
+	return nil
+}
+
+func (x *CommandMessage) GetFileChunk() *FileChunk {
+	if x != nil {
+		if x, ok := x.Payload.(*CommandMessage_FileChunk); ok {
+			return x.FileChunk
+		}
+	}
+	return nil
+}
+
+func (x *CommandMessage) GetFetchChunk() *FetchChunk {
+	if x != nil {
+		if x, ok := x.Payload.(*CommandMessage_FetchChunk); ok {
+			return x.FetchChunk
+		}
+	}
+	return nil
+}
+
+func (x *CommandMessage) GetFileChunkRequest() *FileChunkRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*CommandMessage_FileChunkRequest); ok {
+			return x.FileChunkRequest
+		}
+	}
+	return nil
+}
+
+func (x *CommandMessage) GetPeerGrant() *PeerGrant {
+	if x != nil {
+		if x, ok := x.Payload.(*CommandMessage_PeerGrant); ok {
+			return x.PeerGrant
+		}
+	}
+	return nil
+}
+
+func (x *CommandMessage) GetRevocations() *RevocationList {
+	if x != nil {
+		if x, ok := x.Payload.(*CommandMessage_Revocations); ok {
+			return x.Revocations
+		}
 	}
 	return nil
 }
 
+type isCommandMessage_Payload interface {
+	isCommandMessage_Payload()
+}
+
+type CommandMessage_CommandContent struct {
+	CommandContent *CommandContent `protobuf:"bytes,1,opt,name=command_content,json=commandContent,proto3,oneof"` // 命令内容（Server -> Agent）
+}
+
+type CommandMessage_CommandResult struct {
+	CommandResult *CommandResult `protobuf:"bytes,2,opt,name=command_result,json=commandResult,proto3,oneof"` // 命令结果（Agent -> Server）
+}
+
+type CommandMessage_Hello struct {
+	Hello *AgentHello `protobuf:"bytes,3,opt,name=hello,proto3,oneof"` // 握手（Agent -> Server）
+}
+
+type CommandMessage_Heartbeat struct {
+	Heartbeat *Heartbeat `protobuf:"bytes,4,opt,name=heartbeat,proto3,oneof"` // 心跳（双向）
+}
+
+type CommandMessage_Ack struct {
+	Ack *Ack `protobuf:"bytes,5,opt,name=ack,proto3,oneof"` // 确认（双向）
+}
+
+type CommandMessage_OutputChunk struct {
+	OutputChunk *CommandOutputChunk `protobuf:"bytes,6,opt,name=output_chunk,json=outputChunk,proto3,oneof"` // 命令输出分片（Agent -> Server）
+}
+
+type CommandMessage_Control struct {
+	Control *ControlRequest `protobuf:"bytes,7,opt,name=control,proto3,oneof"` // 命令控制请求（Server -> Agent）
+}
+
+type CommandMessage_ControlAck struct {
+	ControlAck *ControlAck `protobuf:"bytes,8,opt,name=control_ack,json=controlAck,proto3,oneof"` // 命令控制确认（Agent -> Server）
+}
+
+type CommandMessage_Reconcile struct {
+	Reconcile *ReconcileReport `protobuf:"bytes,9,opt,name=reconcile,proto3,oneof"` // 重连对账（Agent -> Server）
+}
+
+type CommandMessage_FileChunk struct {
+	FileChunk *FileChunk `protobuf:"bytes,10,opt,name=file_chunk,json=fileChunk,proto3,oneof"` // 文件数据分片（Server -> Agent）
+}
+
+type CommandMessage_FetchChunk struct {
+	FetchChunk *FetchChunk `protobuf:"bytes,11,opt,name=fetch_chunk,json=fetchChunk,proto3,oneof"` // 收集的文件数据分片（Agent -> Server）
+}
+
+type CommandMessage_FileChunkRequest struct {
+	FileChunkRequest *FileChunkRequest `protobuf:"bytes,12,opt,name=file_chunk_request,json=fileChunkRequest,proto3,oneof"` // 对等分发的分片请求（Agent -> Server）
+}
+
+type CommandMessage_PeerGrant struct {
+	PeerGrant *PeerGrant `protobuf:"bytes,13,opt,name=peer_grant,json=peerGrant,proto3,oneof"` // 对等分发授权（Server -> Agent）
+}
+
+type CommandMessage_Revocations struct {
+	Revocations *RevocationList `protobuf:"bytes,14,opt,name=revocations,proto3,oneof"` // 已吊销的证书列表（Server -> Agent）
+}
+
+func (*CommandMessage_CommandContent) isCommandMessage_Payload() {}
+
+func (*CommandMessage_CommandResult) isCommandMessage_Payload() {}
+
+func (*CommandMessage_Hello) isCommandMessage_Payload() {}
+
+func (*CommandMessage_Heartbeat) isCommandMessage_Payload() {}
+
+func (*CommandMessage_Ack) isCommandMessage_Payload() {}
+
+func (*CommandMessage_OutputChunk) isCommandMessage_Payload() {}
+
+func (*CommandMessage_Control) isCommandMessage_Payload() {}
+
+func (*CommandMessage_ControlAck) isCommandMessage_Payload() {}
+
+func (*CommandMessage_Reconcile) isCommandMessage_Payload() {}
+
+func (*CommandMessage_FileChunk) isCommandMessage_Payload() {}
+
+func (*CommandMessage_FetchChunk) isCommandMessage_Payload() {}
+
+func (*CommandMessage_FileChunkRequest) isCommandMessage_Payload() {}
+
+func (*CommandMessage_PeerGrant) isCommandMessage_Payload() {}
+
+func (*CommandMessage_Revocations) isCommandMessage_Payload() {}
+
 var File_command_proto protoreflect.FileDescriptor
 
 const file_command_proto_rawDesc = "" +
 	"\n" +
-	"\rcommand.proto\x12\aminexus\x1a\x1egoogle/protobuf/duration.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xf2\x01\n" +
+	"\rcommand.proto\x12\aminexus\x1a\x1egoogle/protobuf/duration.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x92\x04\n" +
 	"\x0eCommandContent\x12\x1d\n" +
 	"\n" +
 	"command_id\x18\x01 \x01(\tR\tcommandId\x12\x17\n" +
@@ -277,7 +2190,95 @@ const file_command_proto_rawDesc = "" +
 	"parameters\x123\n" +
 	"\atimeout\x18\x05 \x01(\v2\x19.google.protobuf.DurationR\atimeout\x129\n" +
 	"\n" +
-	"created_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\"\xb1\x02\n" +
+	"created_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x12!\n" +
+	"\frequested_by\x18\a \x01(\tR\vrequestedBy\x123\n" +
+	"\aoptions\x18\b \x01(\v2\x19.minexus.ExecutionOptionsR\aoptions\x12+\n" +
+	"\x06script\x18\t \x01(\v2\x13.minexus.ScriptSpecR\x06script\x12\x18\n" +
+	"\aattempt\x18\n" +
+	" \x01(\rR\aattempt\x12%\n" +
+	"\x04file\x18\v \x01(\v2\x11.minexus.FileSpecR\x04file\x12(\n" +
+	"\x05fetch\x18\f \x01(\v2\x12.minexus.FetchSpecR\x05fetch\x12.\n" +
+	"\aupgrade\x18\r \x01(\v2\x14.minexus.UpgradeSpecR\aupgrade\"\xa6\x02\n" +
+	"\bFileSpec\x12\x16\n" +
+	"\x06sha256\x18\x01 \x01(\tR\x06sha256\x12\x12\n" +
+	"\x04size\x18\x02 \x01(\x03R\x04size\x12\x1b\n" +
+	"\tdest_path\x18\x03 \x01(\tR\bdestPath\x12\x14\n" +
+	"\x05owner\x18\x04 \x01(\tR\x05owner\x12\x14\n" +
+	"\x05group\x18\x05 \x01(\tR\x05group\x12\x12\n" +
+	"\x04mode\x18\x06 \x01(\tR\x04mode\x12\x12\n" +
+	"\x04name\x18\a \x01(\tR\x04name\x12\x12\n" +
+	"\x04peer\x18\b \x01(\bR\x04peer\x12\x1d\n" +
+	"\n" +
+	"chunk_size\x18\t \x01(\x03R\tchunkSize\x12!\n" +
+	"\fchunk_sha256\x18\n" +
+	" \x03(\tR\vchunkSha256\x12'\n" +
+	"\x0fbandwidth_limit\x18\v \x01(\x03R\x0ebandwidthLimit\"r\n" +
+	"\vUpgradeSpec\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\tR\aversion\x12\x1c\n" +
+	"\tsignature\x18\x02 \x01(\tR\tsignature\x12+\n" +
+	"\x11reconnect_timeout\x18\x03 \x01(\x03R\x10reconnectTimeout\"\xa7\x01\n" +
+	"\tFileChunk\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x01 \x01(\tR\tcommandId\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\fR\x04data\x12\x12\n" +
+	"\x04last\x18\x04 \x01(\bR\x04last\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\x12%\n" +
+	"\x04peer\x18\x06 \x01(\v2\x11.minexus.FilePeerR\x04peer\"=\n" +
+	"\bFilePeer\x12\x17\n" +
+	"\ahost_id\x18\x01 \x01(\tR\x06hostId\x12\x18\n" +
+	"\aaddress\x18\x02 \x01(\tR\aaddress\"G\n" +
+	"\tPeerGrant\x12\x17\n" +
+	"\ahost_id\x18\x01 \x01(\tR\x06hostId\x12!\n" +
+	"\fchunk_sha256\x18\x02 \x01(\tR\vchunkSha256\"*\n" +
+	"\x0eRevocationList\x12\x18\n" +
+	"\aserials\x18\x01 \x03(\tR\aserials\"\xee\x01\n" +
+	"\x10FileChunkRequest\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x01 \x01(\tR\tcommandId\x12\x17\n" +
+	"\ahost_id\x18\x02 \x01(\tR\x06hostId\x12\x14\n" +
+	"\x05index\x18\x03 \x01(\x03R\x05index\x12!\n" +
+	"\ffailed_peers\x18\x04 \x03(\tR\vfailedPeers\x12#\n" +
+	"\rcorrupt_peers\x18\x05 \x03(\tR\fcorruptPeers\x12#\n" +
+	"\rstored_chunks\x18\x06 \x03(\x03R\fstoredChunks\x12\x1f\n" +
+	"\vreport_only\x18\a \x01(\bR\n" +
+	"reportOnly\"\x9e\x01\n" +
+	"\tFetchSpec\x12\x1a\n" +
+	"\bpatterns\x18\x01 \x03(\tR\bpatterns\x12$\n" +
+	"\x0emax_file_bytes\x18\x02 \x01(\x03R\fmaxFileBytes\x12&\n" +
+	"\x0fmax_total_bytes\x18\x03 \x01(\x03R\rmaxTotalBytes\x12'\n" +
+	"\x0fbandwidth_limit\x18\x04 \x01(\x03R\x0ebandwidthLimit\"\x96\x01\n" +
+	"\n" +
+	"FetchChunk\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x01 \x01(\tR\tcommandId\x12\x17\n" +
+	"\ahost_id\x18\x02 \x01(\tR\x06hostId\x12\x12\n" +
+	"\x04path\x18\x03 \x01(\tR\x04path\x12\x16\n" +
+	"\x06offset\x18\x04 \x01(\x03R\x06offset\x12\x12\n" +
+	"\x04data\x18\x05 \x01(\fR\x04data\x12\x10\n" +
+	"\x03eof\x18\x06 \x01(\bR\x03eof\"B\n" +
+	"\n" +
+	"ScriptSpec\x12 \n" +
+	"\vinterpreter\x18\x01 \x01(\tR\vinterpreter\x12\x12\n" +
+	"\x04args\x18\x02 \x03(\tR\x04args\"\xc6\x02\n" +
+	"\x10ExecutionOptions\x12\x1e\n" +
+	"\vrun_as_user\x18\x01 \x01(\tR\trunAsUser\x12 \n" +
+	"\frun_as_group\x18\x02 \x01(\tR\n" +
+	"runAsGroup\x12\x1f\n" +
+	"\vworking_dir\x18\x03 \x01(\tR\n" +
+	"workingDir\x124\n" +
+	"\x03env\x18\x04 \x03(\v2\".minexus.ExecutionOptions.EnvEntryR\x03env\x12\x14\n" +
+	"\x05umask\x18\x05 \x01(\tR\x05umask\x12\x14\n" +
+	"\x05stdin\x18\x06 \x01(\fR\x05stdin\x125\n" +
+	"\tresources\x18\a \x01(\v2\x17.minexus.ResourceLimitsR\tresources\x1a6\n" +
+	"\bEnvEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x88\x01\n" +
+	"\x0eResourceLimits\x12\x1b\n" +
+	"\tcpu_quota\x18\x01 \x01(\x01R\bcpuQuota\x12!\n" +
+	"\fmemory_bytes\x18\x02 \x01(\x03R\vmemoryBytes\x12\x1b\n" +
+	"\tio_weight\x18\x03 \x01(\rR\bioWeight\x12\x19\n" +
+	"\bmax_pids\x18\x04 \x01(\x03R\amaxPids\"\x90\x04\n" +
 	"\rCommandResult\x12\x1d\n" +
 	"\n" +
 	"command_id\x18\x01 \x01(\tR\tcommandId\x12\x17\n" +
@@ -289,10 +2290,105 @@ const file_command_proto_rawDesc = "" +
 	"started_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\tstartedAt\x12;\n" +
 	"\vfinished_at\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\n" +
 	"finishedAt\x12#\n" +
-	"\rerror_message\x18\b \x01(\tR\ferrorMessage\"\x91\x01\n" +
-	"\x0eCommandMessage\x12@\n" +
-	"\x0fcommand_content\x18\x01 \x01(\v2\x17.minexus.CommandContentR\x0ecommandContent\x12=\n" +
-	"\x0ecommand_result\x18\x02 \x01(\v2\x16.minexus.CommandResultR\rcommandResult2\\\n" +
+	"\rerror_message\x18\b \x01(\tR\ferrorMessage\x12\x1c\n" +
+	"\ttruncated\x18\t \x01(\bR\ttruncated\x12\x1f\n" +
+	"\vstdout_size\x18\n" +
+	" \x01(\x04R\n" +
+	"stdoutSize\x12\x1f\n" +
+	"\vstderr_size\x18\v \x01(\x04R\n" +
+	"stderrSize\x12\x1b\n" +
+	"\ttimed_out\x18\f \x01(\bR\btimedOut\x12-\n" +
+	"\x12termination_signal\x18\r \x01(\tR\x11terminationSignal\x12\x1d\n" +
+	"\n" +
+	"oom_killed\x18\x0e \x01(\bR\toomKilled\x12\x12\n" +
+	"\x04busy\x18\x0f \x01(\bR\x04busy\"\xe5\x01\n" +
+	"\x12CommandOutputChunk\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x01 \x01(\tR\tcommandId\x12\x17\n" +
+	"\ahost_id\x18\x02 \x01(\tR\x06hostId\x12\x1a\n" +
+	"\bsequence\x18\x03 \x01(\x04R\bsequence\x12-\n" +
+	"\x06stream\x18\x04 \x01(\x0e2\x15.minexus.OutputStreamR\x06stream\x12\x12\n" +
+	"\x04data\x18\x05 \x01(\fR\x04data\x128\n" +
+	"\ttimestamp\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\"\x8d\x02\n" +
+	"\x0eControlRequest\x12\x1d\n" +
+	"\n" +
+	"control_id\x18\x01 \x01(\tR\tcontrolId\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x02 \x01(\tR\tcommandId\x12\x17\n" +
+	"\ahost_id\x18\x03 \x01(\tR\x06hostId\x12.\n" +
+	"\x06action\x18\x04 \x01(\x0e2\x16.minexus.ControlActionR\x06action\x12\x16\n" +
+	"\x06signal\x18\x05 \x01(\x05R\x06signal\x12!\n" +
+	"\frequested_by\x18\x06 \x01(\tR\vrequestedBy\x129\n" +
+	"\n" +
+	"created_at\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\"\xf6\x01\n" +
+	"\n" +
+	"ControlAck\x12\x1d\n" +
+	"\n" +
+	"control_id\x18\x01 \x01(\tR\tcontrolId\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x02 \x01(\tR\tcommandId\x12\x17\n" +
+	"\ahost_id\x18\x03 \x01(\tR\x06hostId\x12.\n" +
+	"\x06action\x18\x04 \x01(\x0e2\x16.minexus.ControlActionR\x06action\x12\x18\n" +
+	"\asuccess\x18\x05 \x01(\bR\asuccess\x12\x18\n" +
+	"\amessage\x18\x06 \x01(\tR\amessage\x12-\n" +
+	"\x05state\x18\a \x01(\x0e2\x17.minexus.ExecutionStateR\x05state\"\xb0\x01\n" +
+	"\n" +
+	"AgentHello\x12\x17\n" +
+	"\ahost_id\x18\x01 \x01(\tR\x06hostId\x12#\n" +
+	"\ragent_version\x18\x02 \x01(\tR\fagentVersion\x12\"\n" +
+	"\fcapabilities\x18\x03 \x03(\tR\fcapabilities\x12\x1d\n" +
+	"\n" +
+	"auth_token\x18\x04 \x01(\tR\tauthToken\x12!\n" +
+	"\fpeer_address\x18\x05 \x01(\tR\vpeerAddress\"\x8c\x01\n" +
+	"\x0fReconcileReport\x12\x17\n" +
+	"\ahost_id\x18\x01 \x01(\tR\x06hostId\x12.\n" +
+	"\x13running_command_ids\x18\x02 \x03(\tR\x11runningCommandIds\x120\n" +
+	"\x14finished_command_ids\x18\x03 \x03(\tR\x12finishedCommandIds\"^\n" +
+	"\tHeartbeat\x12\x17\n" +
+	"\ahost_id\x18\x01 \x01(\tR\x06hostId\x128\n" +
+	"\ttimestamp\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\"n\n" +
+	"\x03Ack\x12\x15\n" +
+	"\x06ref_id\x18\x01 \x01(\tR\x05refId\x12\x18\n" +
+	"\asuccess\x18\x02 \x01(\bR\asuccess\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\x12\x1c\n" +
+	"\tduplicate\x18\x04 \x01(\bR\tduplicate\"\xb6\x06\n" +
+	"\x0eCommandMessage\x12B\n" +
+	"\x0fcommand_content\x18\x01 \x01(\v2\x17.minexus.CommandContentH\x00R\x0ecommandContent\x12?\n" +
+	"\x0ecommand_result\x18\x02 \x01(\v2\x16.minexus.CommandResultH\x00R\rcommandResult\x12+\n" +
+	"\x05hello\x18\x03 \x01(\v2\x13.minexus.AgentHelloH\x00R\x05hello\x122\n" +
+	"\theartbeat\x18\x04 \x01(\v2\x12.minexus.HeartbeatH\x00R\theartbeat\x12 \n" +
+	"\x03ack\x18\x05 \x01(\v2\f.minexus.AckH\x00R\x03ack\x12@\n" +
+	"\foutput_chunk\x18\x06 \x01(\v2\x1b.minexus.CommandOutputChunkH\x00R\voutputChunk\x123\n" +
+	"\acontrol\x18\a \x01(\v2\x17.minexus.ControlRequestH\x00R\acontrol\x126\n" +
+	"\vcontrol_ack\x18\b \x01(\v2\x13.minexus.ControlAckH\x00R\n" +
+	"controlAck\x128\n" +
+	"\treconcile\x18\t \x01(\v2\x18.minexus.ReconcileReportH\x00R\treconcile\x123\n" +
+	"\n" +
+	"file_chunk\x18\n" +
+	" \x01(\v2\x12.minexus.FileChunkH\x00R\tfileChunk\x126\n" +
+	"\vfetch_chunk\x18\v \x01(\v2\x13.minexus.FetchChunkH\x00R\n" +
+	"fetchChunk\x12I\n" +
+	"\x12file_chunk_request\x18\f \x01(\v2\x19.minexus.FileChunkRequestH\x00R\x10fileChunkRequest\x123\n" +
+	"\n" +
+	"peer_grant\x18\r \x01(\v2\x12.minexus.PeerGrantH\x00R\tpeerGrant\x12;\n" +
+	"\vrevocations\x18\x0e \x01(\v2\x17.minexus.RevocationListH\x00R\vrevocationsB\t\n" +
+	"\apayload*B\n" +
+	"\fOutputStream\x12\x18\n" +
+	"\x14OUTPUT_STREAM_STDOUT\x10\x00\x12\x18\n" +
+	"\x14OUTPUT_STREAM_STDERR\x10\x01*z\n" +
+	"\rControlAction\x12\x19\n" +
+	"\x15CONTROL_ACTION_CANCEL\x10\x00\x12\x19\n" +
+	"\x15CONTROL_ACTION_SIGNAL\x10\x01\x12\x18\n" +
+	"\x14CONTROL_ACTION_PAUSE\x10\x02\x12\x19\n" +
+	"\x15CONTROL_ACTION_RESUME\x10\x03*\xdd\x01\n" +
+	"\x0eExecutionState\x12\x1b\n" +
+	"\x17EXECUTION_STATE_UNKNOWN\x10\x00\x12\x1b\n" +
+	"\x17EXECUTION_STATE_RUNNING\x10\x01\x12\x1a\n" +
+	"\x16EXECUTION_STATE_PAUSED\x10\x02\x12\x1c\n" +
+	"\x18EXECUTION_STATE_CANCELED\x10\x03\x12\x1c\n" +
+	"\x18EXECUTION_STATE_FINISHED\x10\x04\x12\x1d\n" +
+	"\x19EXECUTION_STATE_NOT_FOUND\x10\x05\x12\x1a\n" +
+	"\x16EXECUTION_STATE_QUEUED\x10\x062\\\n" +
 	"\x0eCommandService\x12J\n" +
 	"\x12ConnectForCommands\x12\x17.minexus.CommandMessage\x1a\x17.minexus.CommandMessage(\x010\x01B&Z$devops-manager/api/protobuf;protobufb\x06proto3"
 
@@ -308,28 +2404,79 @@ func file_command_proto_rawDescGZIP() []byte {
 	return file_command_proto_rawDescData
 }
 
-var file_command_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_command_proto_enumTypes = make([]protoimpl.EnumInfo, 3)
+var file_command_proto_msgTypes = make([]protoimpl.MessageInfo, 23)
 var file_command_proto_goTypes = []any{
-	(*CommandContent)(nil),        // 0: minexus.CommandContent
-	(*CommandResult)(nil),         // 1: minexus.CommandResult
-	(*CommandMessage)(nil),        // 2: minexus.CommandMessage
-	(*durationpb.Duration)(nil),   // 3: google.protobuf.Duration
-	(*timestamppb.Timestamp)(nil), // 4: google.protobuf.Timestamp
+	(OutputStream)(0),             // 0: minexus.OutputStream
+	(ControlAction)(0),            // 1: minexus.ControlAction
+	(ExecutionState)(0),           // 2: minexus.ExecutionState
+	(*CommandContent)(nil),        // 3: minexus.CommandContent
+	(*FileSpec)(nil),              // 4: minexus.FileSpec
+	(*UpgradeSpec)(nil),           // 5: minexus.UpgradeSpec
+	(*FileChunk)(nil),             // 6: minexus.FileChunk
+	(*FilePeer)(nil),              // 7: minexus.FilePeer
+	(*PeerGrant)(nil),             // 8: minexus.PeerGrant
+	(*RevocationList)(nil),        // 9: minexus.RevocationList
+	(*FileChunkRequest)(nil),      // 10: minexus.FileChunkRequest
+	(*FetchSpec)(nil),             // 11: minexus.FetchSpec
+	(*FetchChunk)(nil),            // 12: minexus.FetchChunk
+	(*ScriptSpec)(nil),            // 13: minexus.ScriptSpec
+	(*ExecutionOptions)(nil),      // 14: minexus.ExecutionOptions
+	(*ResourceLimits)(nil),        // 15: minexus.ResourceLimits
+	(*CommandResult)(nil),         // 16: minexus.CommandResult
+	(*CommandOutputChunk)(nil),    // 17: minexus.CommandOutputChunk
+	(*ControlRequest)(nil),        // 18: minexus.ControlRequest
+	(*ControlAck)(nil),            // 19: minexus.ControlAck
+	(*AgentHello)(nil),            // 20: minexus.AgentHello
+	(*ReconcileReport)(nil),       // 21: minexus.ReconcileReport
+	(*Heartbeat)(nil),             // 22: minexus.Heartbeat
+	(*Ack)(nil),                   // 23: minexus.Ack
+	(*CommandMessage)(nil),        // 24: minexus.CommandMessage
+	nil,                           // 25: minexus.ExecutionOptions.EnvEntry
+	(*durationpb.Duration)(nil),   // 26: google.protobuf.Duration
+	(*timestamppb.Timestamp)(nil), // 27: google.protobuf.Timestamp
 }
 var file_command_proto_depIdxs = []int32{
-	3, // 0: minexus.CommandContent.timeout:type_name -> google.protobuf.Duration
-	4, // 1: minexus.CommandContent.created_at:type_name -> google.protobuf.Timestamp
-	4, // 2: minexus.CommandResult.started_at:type_name -> google.protobuf.Timestamp
-	4, // 3: minexus.CommandResult.finished_at:type_name -> google.protobuf.Timestamp
-	0, // 4: minexus.CommandMessage.command_content:type_name -> minexus.CommandContent
-	1, // 5: minexus.CommandMessage.command_result:type_name -> minexus.CommandResult
-	2, // 6: minexus.CommandService.ConnectForCommands:input_type -> minexus.CommandMessage
-	2, // 7: minexus.CommandService.ConnectForCommands:output_type -> minexus.CommandMessage
-	7, // [7:8] is the sub-list for method output_type
-	6, // [6:7] is the sub-list for method input_type
-	6, // [6:6] is the sub-list for extension type_name
-	6, // [6:6] is the sub-list for extension extendee
-	0, // [0:6] is the sub-list for field type_name
+	26, // 0: minexus.CommandContent.timeout:type_name -> google.protobuf.Duration
+	27, // 1: minexus.CommandContent.created_at:type_name -> google.protobuf.Timestamp
+	14, // 2: minexus.CommandContent.options:type_name -> minexus.ExecutionOptions
+	13, // 3: minexus.CommandContent.script:type_name -> minexus.ScriptSpec
+	4,  // 4: minexus.CommandContent.file:type_name -> minexus.FileSpec
+	11, // 5: minexus.CommandContent.fetch:type_name -> minexus.FetchSpec
+	5,  // 6: minexus.CommandContent.upgrade:type_name -> minexus.UpgradeSpec
+	7,  // 7: minexus.FileChunk.peer:type_name -> minexus.FilePeer
+	25, // 8: minexus.ExecutionOptions.env:type_name -> minexus.ExecutionOptions.EnvEntry
+	15, // 9: minexus.ExecutionOptions.resources:type_name -> minexus.ResourceLimits
+	27, // 10: minexus.CommandResult.started_at:type_name -> google.protobuf.Timestamp
+	27, // 11: minexus.CommandResult.finished_at:type_name -> google.protobuf.Timestamp
+	0,  // 12: minexus.CommandOutputChunk.stream:type_name -> minexus.OutputStream
+	27, // 13: minexus.CommandOutputChunk.timestamp:type_name -> google.protobuf.Timestamp
+	1,  // 14: minexus.ControlRequest.action:type_name -> minexus.ControlAction
+	27, // 15: minexus.ControlRequest.created_at:type_name -> google.protobuf.Timestamp
+	1,  // 16: minexus.ControlAck.action:type_name -> minexus.ControlAction
+	2,  // 17: minexus.ControlAck.state:type_name -> minexus.ExecutionState
+	27, // 18: minexus.Heartbeat.timestamp:type_name -> google.protobuf.Timestamp
+	3,  // 19: minexus.CommandMessage.command_content:type_name -> minexus.CommandContent
+	16, // 20: minexus.CommandMessage.command_result:type_name -> minexus.CommandResult
+	20, // 21: minexus.CommandMessage.hello:type_name -> minexus.AgentHello
+	22, // 22: minexus.CommandMessage.heartbeat:type_name -> minexus.Heartbeat
+	23, // 23: minexus.CommandMessage.ack:type_name -> minexus.Ack
+	17, // 24: minexus.CommandMessage.output_chunk:type_name -> minexus.CommandOutputChunk
+	18, // 25: minexus.CommandMessage.control:type_name -> minexus.ControlRequest
+	19, // 26: minexus.CommandMessage.control_ack:type_name -> minexus.ControlAck
+	21, // 27: minexus.CommandMessage.reconcile:type_name -> minexus.ReconcileReport
+	6,  // 28: minexus.CommandMessage.file_chunk:type_name -> minexus.FileChunk
+	12, // 29: minexus.CommandMessage.fetch_chunk:type_name -> minexus.FetchChunk
+	10, // 30: minexus.CommandMessage.file_chunk_request:type_name -> minexus.FileChunkRequest
+	8,  // 31: minexus.CommandMessage.peer_grant:type_name -> minexus.PeerGrant
+	9,  // 32: minexus.CommandMessage.revocations:type_name -> minexus.RevocationList
+	24, // 33: minexus.CommandService.ConnectForCommands:input_type -> minexus.CommandMessage
+	24, // 34: minexus.CommandService.ConnectForCommands:output_type -> minexus.CommandMessage
+	34, // [34:35] is the sub-list for method output_type
+	33, // [33:34] is the sub-list for method input_type
+	33, // [33:33] is the sub-list for extension type_name
+	33, // [33:33] is the sub-list for extension extendee
+	0,  // [0:33] is the sub-list for field type_name
 }
 
 func init() { file_command_proto_init() }
@@ -337,18 +2484,35 @@ func file_command_proto_init() {
 	if File_command_proto != nil {
 		return
 	}
+	file_command_proto_msgTypes[21].OneofWrappers = []any{
+		(*CommandMessage_CommandContent)(nil),
+		(*CommandMessage_CommandResult)(nil),
+		(*CommandMessage_Hello)(nil),
+		(*CommandMessage_Heartbeat)(nil),
+		(*CommandMessage_Ack)(nil),
+		(*CommandMessage_OutputChunk)(nil),
+		(*CommandMessage_Control)(nil),
+		(*CommandMessage_ControlAck)(nil),
+		(*CommandMessage_Reconcile)(nil),
+		(*CommandMessage_FileChunk)(nil),
+		(*CommandMessage_FetchChunk)(nil),
+		(*CommandMessage_FileChunkRequest)(nil),
+		(*CommandMessage_PeerGrant)(nil),
+		(*CommandMessage_Revocations)(nil),
+	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_command_proto_rawDesc), len(file_command_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   3,
+			NumEnums:      3,
+			NumMessages:   23,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_command_proto_goTypes,
 		DependencyIndexes: file_command_proto_depIdxs,
+		EnumInfos:         file_command_proto_enumTypes,
 		MessageInfos:      file_command_proto_msgTypes,
 	}.Build()
 	File_command_proto = out.File
@@ -23,15 +23,22 @@ const (
 
 // 主机信息
 type HostInfo struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	Hostname      string                 `protobuf:"bytes,2,opt,name=hostname,proto3" json:"hostname,omitempty"`
-	Ip            string                 `protobuf:"bytes,3,opt,name=ip,proto3" json:"ip,omitempty"`
-	Os            string                 `protobuf:"bytes,4,opt,name=os,proto3" json:"os,omitempty"`
-	Tags          map[string]string      `protobuf:"bytes,5,rep,name=tags,proto3" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
-	LastSeen      int64                  `protobuf:"varint,6,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"` // Unix timestamp of last registration/communication
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state                 protoimpl.MessageState `protogen:"open.v1"`
+	Id                    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Hostname              string                 `protobuf:"bytes,2,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Ip                    string                 `protobuf:"bytes,3,opt,name=ip,proto3" json:"ip,omitempty"`
+	Os                    string                 `protobuf:"bytes,4,opt,name=os,proto3" json:"os,omitempty"`
+	Tags                  map[string]string      `protobuf:"bytes,5,rep,name=tags,proto3" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`     // Agent 上报时为其配置的标签；Server 返回时为管理员维护的主机标签（决定用户主机范围和对等分发区域）
+	LastSeen              int64                  `protobuf:"varint,6,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"`                                                      // Unix timestamp of last registration/communication
+	Csr                   string                 `protobuf:"bytes,7,opt,name=csr,proto3" json:"csr,omitempty"`                                                                                 // PEM 格式证书签名请求（首次入网或证书轮换时携带）
+	AgentVersion          string                 `protobuf:"bytes,8,opt,name=agent_version,json=agentVersion,proto3" json:"agent_version,omitempty"`                                           // Agent 版本
+	Labels                map[string]string      `protobuf:"bytes,9,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // Agent 上报的标签（Server 返回，仅供展示）
+	RunningCommands       int32                  `protobuf:"varint,10,opt,name=running_commands,json=runningCommands,proto3" json:"running_commands,omitempty"`                                // 最近一次状态上报时执行中的命令数（Server 返回）
+	QueuedCommands        int32                  `protobuf:"varint,11,opt,name=queued_commands,json=queuedCommands,proto3" json:"queued_commands,omitempty"`                                   // 最近一次状态上报时排队中的命令数（Server 返回）
+	MaxConcurrentCommands int32                  `protobuf:"varint,12,opt,name=max_concurrent_commands,json=maxConcurrentCommands,proto3" json:"max_concurrent_commands,omitempty"`            // Agent 最大并发命令数（Server 返回）
+	CommandQueueCapacity  int32                  `protobuf:"varint,13,opt,name=command_queue_capacity,json=commandQueueCapacity,proto3" json:"command_queue_capacity,omitempty"`               // Agent 命令队列容量（Server 返回）
+	unknownFields         protoimpl.UnknownFields
+	sizeCache             protoimpl.SizeCache
 }
 
 func (x *HostInfo) Reset() {
@@ -106,12 +113,62 @@ func (x *HostInfo) GetLastSeen() int64 {
 	return 0
 }
 
+func (x *HostInfo) GetCsr() string {
+	if x != nil {
+		return x.Csr
+	}
+	return ""
+}
+
+func (x *HostInfo) GetAgentVersion() string {
+	if x != nil {
+		return x.AgentVersion
+	}
+	return ""
+}
+
+func (x *HostInfo) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *HostInfo) GetRunningCommands() int32 {
+	if x != nil {
+		return x.RunningCommands
+	}
+	return 0
+}
+
+func (x *HostInfo) GetQueuedCommands() int32 {
+	if x != nil {
+		return x.QueuedCommands
+	}
+	return 0
+}
+
+func (x *HostInfo) GetMaxConcurrentCommands() int32 {
+	if x != nil {
+		return x.MaxConcurrentCommands
+	}
+	return 0
+}
+
+func (x *HostInfo) GetCommandQueueCapacity() int32 {
+	if x != nil {
+		return x.CommandQueueCapacity
+	}
+	return 0
+}
+
 // 注册应答
 type RegisterResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
 	AssignedId    string                 `protobuf:"bytes,2,opt,name=assigned_id,json=assignedId,proto3" json:"assigned_id,omitempty"`
 	ErrorMessage  string                 `protobuf:"bytes,3,opt,name=error_message,json=errorMessage,proto3" json:"error_message,omitempty"`
+	Certificate   string                 `protobuf:"bytes,4,opt,name=certificate,proto3" json:"certificate,omitempty"` // Server 内置 CA 签发的 PEM 格式证书（主机准入后下发）
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -167,6 +224,13 @@ func (x *RegisterResponse) GetErrorMessage() string {
 	return ""
 }
 
+func (x *RegisterResponse) GetCertificate() string {
+	if x != nil {
+		return x.Certificate
+	}
+	return ""
+}
+
 // CPU 信息
 type CPUInfo struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -426,9 +490,14 @@ type HostStatus struct {
 	Memory *MemoryInfo `protobuf:"bytes,6,opt,name=memory,proto3" json:"memory,omitempty"` // 内存信息
 	Disks  []*DiskInfo `protobuf:"bytes,7,rep,name=disks,proto3" json:"disks,omitempty"`   // 磁盘信息列表
 	// 自定义标签和元数据
-	CustomTags    map[string]string `protobuf:"bytes,8,rep,name=custom_tags,json=customTags,proto3" json:"custom_tags,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	CustomTags map[string]string `protobuf:"bytes,8,rep,name=custom_tags,json=customTags,proto3" json:"custom_tags,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// 命令执行队列
+	RunningCommands       uint32 `protobuf:"varint,9,opt,name=running_commands,json=runningCommands,proto3" json:"running_commands,omitempty"`                      // 正在执行的命令数
+	QueuedCommands        uint32 `protobuf:"varint,10,opt,name=queued_commands,json=queuedCommands,proto3" json:"queued_commands,omitempty"`                        // 排队等待执行的命令数
+	MaxConcurrentCommands uint32 `protobuf:"varint,11,opt,name=max_concurrent_commands,json=maxConcurrentCommands,proto3" json:"max_concurrent_commands,omitempty"` // 最大并发执行数
+	CommandQueueCapacity  uint32 `protobuf:"varint,12,opt,name=command_queue_capacity,json=commandQueueCapacity,proto3" json:"command_queue_capacity,omitempty"`    // 排队容量
+	unknownFields         protoimpl.UnknownFields
+	sizeCache             protoimpl.SizeCache
 }
 
 func (x *HostStatus) Reset() {
@@ -517,6 +586,34 @@ func (x *HostStatus) GetCustomTags() map[string]string {
 	return nil
 }
 
+func (x *HostStatus) GetRunningCommands() uint32 {
+	if x != nil {
+		return x.RunningCommands
+	}
+	return 0
+}
+
+func (x *HostStatus) GetQueuedCommands() uint32 {
+	if x != nil {
+		return x.QueuedCommands
+	}
+	return 0
+}
+
+func (x *HostStatus) GetMaxConcurrentCommands() uint32 {
+	if x != nil {
+		return x.MaxConcurrentCommands
+	}
+	return 0
+}
+
+func (x *HostStatus) GetCommandQueueCapacity() uint32 {
+	if x != nil {
+		return x.CommandQueueCapacity
+	}
+	return 0
+}
+
 // host 状态上报应答
 type HostStatusResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -575,22 +672,34 @@ var File_host_proto protoreflect.FileDescriptor
 const file_host_proto_rawDesc = "" +
 	"\n" +
 	"\n" +
-	"host.proto\x12\aminexus\"\xdd\x01\n" +
+	"host.proto\x12\aminexus\"\xc8\x04\n" +
 	"\bHostInfo\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1a\n" +
 	"\bhostname\x18\x02 \x01(\tR\bhostname\x12\x0e\n" +
 	"\x02ip\x18\x03 \x01(\tR\x02ip\x12\x0e\n" +
 	"\x02os\x18\x04 \x01(\tR\x02os\x12/\n" +
 	"\x04tags\x18\x05 \x03(\v2\x1b.minexus.HostInfo.TagsEntryR\x04tags\x12\x1b\n" +
-	"\tlast_seen\x18\x06 \x01(\x03R\blastSeen\x1a7\n" +
+	"\tlast_seen\x18\x06 \x01(\x03R\blastSeen\x12\x10\n" +
+	"\x03csr\x18\a \x01(\tR\x03csr\x12#\n" +
+	"\ragent_version\x18\b \x01(\tR\fagentVersion\x125\n" +
+	"\x06labels\x18\t \x03(\v2\x1d.minexus.HostInfo.LabelsEntryR\x06labels\x12)\n" +
+	"\x10running_commands\x18\n" +
+	" \x01(\x05R\x0frunningCommands\x12'\n" +
+	"\x0fqueued_commands\x18\v \x01(\x05R\x0equeuedCommands\x126\n" +
+	"\x17max_concurrent_commands\x18\f \x01(\x05R\x15maxConcurrentCommands\x124\n" +
+	"\x16command_queue_capacity\x18\r \x01(\x05R\x14commandQueueCapacity\x1a7\n" +
 	"\tTagsEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"r\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x94\x01\n" +
 	"\x10RegisterResponse\x12\x18\n" +
 	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x1f\n" +
 	"\vassigned_id\x18\x02 \x01(\tR\n" +
 	"assignedId\x12#\n" +
-	"\rerror_message\x18\x03 \x01(\tR\ferrorMessage\"\xaf\x01\n" +
+	"\rerror_message\x18\x03 \x01(\tR\ferrorMessage\x12 \n" +
+	"\vcertificate\x18\x04 \x01(\tR\vcertificate\"\xaf\x01\n" +
 	"\aCPUInfo\x12#\n" +
 	"\rusage_percent\x18\x01 \x01(\x01R\fusagePercent\x12\x1d\n" +
 	"\n" +
@@ -622,7 +731,7 @@ const file_host_proto_rawDesc = "" +
 	"used_bytes\x18\x05 \x01(\x04R\tusedBytes\x12\x1d\n" +
 	"\n" +
 	"free_bytes\x18\x06 \x01(\x04R\tfreeBytes\x12#\n" +
-	"\rusage_percent\x18\a \x01(\x01R\fusagePercent\"\xf9\x02\n" +
+	"\rusage_percent\x18\a \x01(\x01R\fusagePercent\"\xbb\x04\n" +
 	"\n" +
 	"HostStatus\x12\x17\n" +
 	"\ahost_id\x18\x01 \x01(\tR\x06hostId\x12\x1c\n" +
@@ -633,7 +742,12 @@ const file_host_proto_rawDesc = "" +
 	"\x06memory\x18\x06 \x01(\v2\x13.minexus.MemoryInfoR\x06memory\x12'\n" +
 	"\x05disks\x18\a \x03(\v2\x11.minexus.DiskInfoR\x05disks\x12D\n" +
 	"\vcustom_tags\x18\b \x03(\v2#.minexus.HostStatus.CustomTagsEntryR\n" +
-	"customTags\x1a=\n" +
+	"customTags\x12)\n" +
+	"\x10running_commands\x18\t \x01(\rR\x0frunningCommands\x12'\n" +
+	"\x0fqueued_commands\x18\n" +
+	" \x01(\rR\x0equeuedCommands\x126\n" +
+	"\x17max_concurrent_commands\x18\v \x01(\rR\x15maxConcurrentCommands\x124\n" +
+	"\x16command_queue_capacity\x18\f \x01(\rR\x14commandQueueCapacity\x1a=\n" +
 	"\x0fCustomTagsEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
 	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"H\n" +
@@ -656,7 +770,7 @@ func file_host_proto_rawDescGZIP() []byte {
 	return file_host_proto_rawDescData
 }
 
-var file_host_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_host_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
 var file_host_proto_goTypes = []any{
 	(*HostInfo)(nil),           // 0: minexus.HostInfo
 	(*RegisterResponse)(nil),   // 1: minexus.RegisterResponse
@@ -666,23 +780,25 @@ var file_host_proto_goTypes = []any{
 	(*HostStatus)(nil),         // 5: minexus.HostStatus
 	(*HostStatusResponse)(nil), // 6: minexus.HostStatusResponse
 	nil,                        // 7: minexus.HostInfo.TagsEntry
-	nil,                        // 8: minexus.HostStatus.CustomTagsEntry
+	nil,                        // 8: minexus.HostInfo.LabelsEntry
+	nil,                        // 9: minexus.HostStatus.CustomTagsEntry
 }
 var file_host_proto_depIdxs = []int32{
 	7, // 0: minexus.HostInfo.tags:type_name -> minexus.HostInfo.TagsEntry
-	2, // 1: minexus.HostStatus.cpu:type_name -> minexus.CPUInfo
-	3, // 2: minexus.HostStatus.memory:type_name -> minexus.MemoryInfo
-	4, // 3: minexus.HostStatus.disks:type_name -> minexus.DiskInfo
-	8, // 4: minexus.HostStatus.custom_tags:type_name -> minexus.HostStatus.CustomTagsEntry
-	0, // 5: minexus.HostService.Register:input_type -> minexus.HostInfo
-	5, // 6: minexus.HostService.ReportStatus:input_type -> minexus.HostStatus
-	1, // 7: minexus.HostService.Register:output_type -> minexus.RegisterResponse
-	6, // 8: minexus.HostService.ReportStatus:output_type -> minexus.HostStatusResponse
-	7, // [7:9] is the sub-list for method output_type
-	5, // [5:7] is the sub-list for method input_type
-	5, // [5:5] is the sub-list for extension type_name
-	5, // [5:5] is the sub-list for extension extendee
-	0, // [0:5] is the sub-list for field type_name
+	8, // 1: minexus.HostInfo.labels:type_name -> minexus.HostInfo.LabelsEntry
+	2, // 2: minexus.HostStatus.cpu:type_name -> minexus.CPUInfo
+	3, // 3: minexus.HostStatus.memory:type_name -> minexus.MemoryInfo
+	4, // 4: minexus.HostStatus.disks:type_name -> minexus.DiskInfo
+	9, // 5: minexus.HostStatus.custom_tags:type_name -> minexus.HostStatus.CustomTagsEntry
+	0, // 6: minexus.HostService.Register:input_type -> minexus.HostInfo
+	5, // 7: minexus.HostService.ReportStatus:input_type -> minexus.HostStatus
+	1, // 8: minexus.HostService.Register:output_type -> minexus.RegisterResponse
+	6, // 9: minexus.HostService.ReportStatus:output_type -> minexus.HostStatusResponse
+	8, // [8:10] is the sub-list for method output_type
+	6, // [6:8] is the sub-list for method input_type
+	6, // [6:6] is the sub-list for extension type_name
+	6, // [6:6] is the sub-list for extension extendee
+	0, // [0:6] is the sub-list for field type_name
 }
 
 func init() { file_host_proto_init() }
@@ -696,7 +812,7 @@ func file_host_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_host_proto_rawDesc), len(file_host_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   9,
+			NumMessages:   10,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -0,0 +1,91 @@
+// Package ratelimit 文件传输限速
+// 按字节计数的令牌桶：令牌以设定速率补充，桶容量为一秒的传输量；每次传输前预留所需令牌，令牌不足时等待补足
+// 单次传输可以超过桶容量，超出部分由之后的等待抵扣。速率不大于 0 或限速器为 nil 时不限速
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter 令牌桶限速器，可被多个协程共享
+type Limiter struct {
+	mutex  sync.Mutex
+	rate   int64   // 每秒字节数
+	tokens float64 // 可用令牌，预留超出时为负数
+	last   time.Time
+}
+
+// New 创建限速器，bytesPerSecond 不大于 0 时不限速
+func New(bytesPerSecond int64) *Limiter {
+	return &Limiter{rate: bytesPerSecond, tokens: float64(bytesPerSecond), last: time.Now()}
+}
+
+// Rate 获取每秒字节数，为 0 时不限速
+func (l *Limiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return max(l.rate, 0)
+}
+
+// Wait 预留 n 字节的令牌并等待到可以传输，ctx 结束时返回其错误（已预留的令牌不归还）
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	return sleep(ctx, l.reserve(n))
+}
+
+// reserve 扣除 n 字节的令牌，返回需要等待的时长
+func (l *Limiter) reserve(n int) time.Duration {
+	if l == nil || n <= 0 {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+}
+
+// refill 按经过的时间补充令牌，不超过桶容量，调用方需持有锁
+func (l *Limiter) refill(now time.Time) {
+	if l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+		if l.tokens > float64(l.rate) {
+			l.tokens = float64(l.rate)
+		}
+	}
+	l.last = now
+}
+
+// WaitAll 在每个限速器上预留 n 字节，等待其中最长的时长，nil 限速器被忽略
+func WaitAll(ctx context.Context, n int, limiters ...*Limiter) error {
+	var delay time.Duration
+	for _, limiter := range limiters {
+		delay = max(delay, limiter.reserve(n))
+	}
+	return sleep(ctx, delay)
+}
+
+// sleep 等待 delay，ctx 结束时返回其错误
+func sleep(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,222 @@
+// Package sendqueue 命令流发送队列
+// gRPC 流不支持并发 Send，Agent 和 Server 的每条命令流都通过一个发送队列串行写入：
+// 调用方将消息放入有界缓冲，由单个写协程依次发送；写入超时视为流阻塞，队列失效并通知调用方关闭流
+package sendqueue
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"devops-manager/api/protobuf"
+)
+
+// 默认配置
+const (
+	DefaultSize        = 256
+	DefaultSendTimeout = 10 * time.Second
+)
+
+var (
+	// ErrQueueFull 缓冲已满且在超时时间内未腾出空间
+	ErrQueueFull = errors.New("send queue full")
+	// ErrClosed 队列已关闭
+	ErrClosed = errors.New("send queue closed")
+	// ErrSendTimeout 单条消息写入流超时
+	ErrSendTimeout = errors.New("stream send timed out")
+)
+
+// SendFunc 向流写入一条消息
+type SendFunc func(msg *protobuf.CommandMessage) error
+
+// Stats 发送队列统计
+type Stats struct {
+	Queued         int           // 当前排队的消息数
+	Capacity       int           // 缓冲容量
+	Sent           uint64        // 已写入流的消息数
+	Dropped        uint64        // 缓冲已满被拒绝的消息数
+	Failed         uint64        // 写入失败或超时的次数
+	LastLatency    time.Duration // 最近一条消息的写入耗时
+	MaxLatency     time.Duration // 最大写入耗时
+	LastError      string        // 导致队列失效的错误
+	LastSentAt     time.Time     // 最近一次写入成功的时间
+	EnqueueWaiting int           // 正在等待缓冲空间的调用方数
+}
+
+// Queue 单条流的发送队列
+type Queue struct {
+	send     SendFunc
+	onError  func(error)
+	timeout  time.Duration
+	messages chan *protobuf.CommandMessage
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mutex sync.Mutex
+	err   error
+	stats Stats
+}
+
+// New 创建发送队列并启动写协程
+// size 为缓冲消息数，timeout 同时作为入队等待和单条消息写入的超时，不大于 0 时使用默认值；
+// 写入失败或超时后队列失效，onError 在此时被调用一次，调用方应关闭对应的流
+func New(send SendFunc, onError func(error), size int, timeout time.Duration) *Queue {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	if timeout <= 0 {
+		timeout = DefaultSendTimeout
+	}
+
+	q := &Queue{
+		send:     send,
+		onError:  onError,
+		timeout:  timeout,
+		messages: make(chan *protobuf.CommandMessage, size),
+		done:     make(chan struct{}),
+	}
+	q.stats.Capacity = size
+
+	go q.writeLoop()
+	return q
+}
+
+// Send 将消息放入发送队列，缓冲已满时最多等待超时时间
+// 返回 nil 仅表示消息已入队，写入失败会通过 onError 通知
+func (q *Queue) Send(msg *protobuf.CommandMessage) error {
+	select {
+	case <-q.done:
+		return q.closedErr()
+	default:
+	}
+
+	select {
+	case q.messages <- msg:
+		return nil
+	default:
+	}
+
+	q.mutex.Lock()
+	q.stats.EnqueueWaiting++
+	q.mutex.Unlock()
+	defer func() {
+		q.mutex.Lock()
+		q.stats.EnqueueWaiting--
+		q.mutex.Unlock()
+	}()
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+
+	select {
+	case q.messages <- msg:
+		return nil
+	case <-q.done:
+		return q.closedErr()
+	case <-timer.C:
+		q.mutex.Lock()
+		q.stats.Dropped++
+		q.mutex.Unlock()
+		return fmt.Errorf("%w: %d messages pending", ErrQueueFull, len(q.messages))
+	}
+}
+
+// Close 关闭队列，未发送的消息被丢弃
+func (q *Queue) Close() {
+	q.closeOnce.Do(func() {
+		close(q.done)
+	})
+}
+
+// Done 队列关闭或失效时关闭的通道
+func (q *Queue) Done() <-chan struct{} {
+	return q.done
+}
+
+// Err 返回导致队列失效的写入错误，未失效或被 Close 关闭时为 nil
+func (q *Queue) Err() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.err
+}
+
+// Stats 返回发送队列统计
+func (q *Queue) Stats() Stats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	stats := q.stats
+	stats.Queued = len(q.messages)
+	return stats
+}
+
+// writeLoop 依次将缓冲中的消息写入流，直到队列关闭或写入失败
+func (q *Queue) writeLoop() {
+	for {
+		select {
+		case <-q.done:
+			return
+		case msg := <-q.messages:
+			if !q.write(msg) {
+				return
+			}
+		}
+	}
+}
+
+// write 写入一条消息，超时未返回时使队列失效，由 onError 关闭流以解除阻塞
+func (q *Queue) write(msg *protobuf.CommandMessage) bool {
+	start := time.Now()
+	timer := time.AfterFunc(q.timeout, func() {
+		q.fail(fmt.Errorf("%w after %v", ErrSendTimeout, q.timeout))
+	})
+	err := q.send(msg)
+	if !timer.Stop() {
+		// 已超时，队列已失效
+		return false
+	}
+	if err != nil {
+		q.fail(err)
+		return false
+	}
+
+	latency := time.Since(start)
+	q.mutex.Lock()
+	q.stats.Sent++
+	q.stats.LastLatency = latency
+	if latency > q.stats.MaxLatency {
+		q.stats.MaxLatency = latency
+	}
+	q.stats.LastSentAt = time.Now()
+	q.mutex.Unlock()
+	return true
+}
+
+// fail 记录写入错误并使队列失效，只在首次失效时通知调用方
+func (q *Queue) fail(err error) {
+	first := false
+	q.closeOnce.Do(func() {
+		q.mutex.Lock()
+		q.err = err
+		q.stats.Failed++
+		q.stats.LastError = err.Error()
+		q.mutex.Unlock()
+		close(q.done)
+		first = true
+	})
+	if first && q.onError != nil {
+		q.onError(err)
+	}
+}
+
+// closedErr 队列关闭后返回给调用方的错误
+func (q *Queue) closedErr() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.err != nil {
+		return fmt.Errorf("%w: %v", ErrClosed, q.err)
+	}
+	return ErrClosed
+}
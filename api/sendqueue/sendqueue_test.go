@@ -0,0 +1,201 @@
+package sendqueue
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"devops-manager/api/protobuf"
+)
+
+// ackMessage 以 ref_id 标识顺序的测试消息
+func ackMessage(i int) *protobuf.CommandMessage {
+	return &protobuf.CommandMessage{
+		Payload: &protobuf.CommandMessage_Ack{Ack: &protobuf.Ack{RefId: strconv.Itoa(i)}},
+	}
+}
+
+// waitDone 等待队列失效或关闭
+func waitDone(t *testing.T, q *Queue) {
+	t.Helper()
+	select {
+	case <-q.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("queue was not closed")
+	}
+}
+
+func TestQueueOrdering(t *testing.T) {
+	const count = 500
+
+	var mutex sync.Mutex
+	var sent []string
+	all := make(chan struct{})
+	q := New(func(msg *protobuf.CommandMessage) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		sent = append(sent, msg.GetAck().RefId)
+		if len(sent) == count {
+			close(all)
+		}
+		return nil
+	}, nil, 8, time.Second)
+	defer q.Close()
+
+	for i := 0; i < count; i++ {
+		if err := q.Send(ackMessage(i)); err != nil {
+			t.Fatalf("Send(%d) failed: %v", i, err)
+		}
+	}
+	select {
+	case <-all:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not all messages were sent")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for i, refID := range sent {
+		if refID != strconv.Itoa(i) {
+			t.Fatalf("message %d sent as %s, want in enqueue order", i, refID)
+		}
+	}
+	if stats := q.Stats(); stats.Sent != count || stats.Dropped != 0 || stats.Failed != 0 {
+		t.Errorf("stats = %+v, want %d sent and nothing dropped or failed", stats, count)
+	}
+}
+
+func TestQueueOverflow(t *testing.T) {
+	// 写入慢于入队但不超时：缓冲满后等待超时的调用方被拒绝，队列本身仍然可用
+	const timeout = 200 * time.Millisecond
+	release := make(chan struct{})
+	q := New(func(msg *protobuf.CommandMessage) error {
+		select {
+		case <-release:
+		case <-time.After(timeout * 3 / 4):
+		}
+		return nil
+	}, func(err error) {
+		t.Errorf("onError called: %v", err)
+	}, 1, timeout)
+	defer q.Close()
+
+	const senders = 10
+	results := make(chan error, senders)
+	for i := 0; i < senders; i++ {
+		go func(i int) {
+			results <- q.Send(ackMessage(i))
+		}(i)
+	}
+
+	var full int
+	for i := 0; i < senders; i++ {
+		err := <-results
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrQueueFull):
+			full++
+		default:
+			t.Errorf("Send returned %v, want nil or ErrQueueFull", err)
+		}
+	}
+	close(release)
+
+	if full == 0 {
+		t.Fatal("no Send was rejected with ErrQueueFull")
+	}
+	if stats := q.Stats(); stats.Dropped != uint64(full) || stats.EnqueueWaiting != 0 {
+		t.Errorf("stats = %+v, want %d dropped and no waiting callers", stats, full)
+	}
+	if err := q.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil after overflow", err)
+	}
+	if err := q.Send(ackMessage(senders)); err != nil {
+		t.Errorf("Send after overflow failed: %v", err)
+	}
+}
+
+func TestQueueWriteFailure(t *testing.T) {
+	errBroken := errors.New("stream broken")
+
+	tests := []struct {
+		name    string
+		send    func(block <-chan struct{}) error
+		wantErr error
+	}{
+		{
+			name: "write blocks past timeout",
+			send: func(block <-chan struct{}) error {
+				<-block
+				return nil
+			},
+			wantErr: ErrSendTimeout,
+		},
+		{
+			name: "write returns error",
+			send: func(<-chan struct{}) error {
+				return errBroken
+			},
+			wantErr: errBroken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := make(chan struct{})
+			defer close(block)
+
+			var mutex sync.Mutex
+			var notified []error
+			q := New(func(*protobuf.CommandMessage) error {
+				return tt.send(block)
+			}, func(err error) {
+				mutex.Lock()
+				notified = append(notified, err)
+				mutex.Unlock()
+			}, 4, 50*time.Millisecond)
+
+			if err := q.Send(ackMessage(0)); err != nil {
+				t.Fatalf("first Send failed: %v", err)
+			}
+			waitDone(t, q)
+
+			if err := q.Err(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Err() = %v, want %v", err, tt.wantErr)
+			}
+			if err := q.Send(ackMessage(1)); !errors.Is(err, ErrClosed) {
+				t.Errorf("Send after failure = %v, want ErrClosed", err)
+			}
+			if stats := q.Stats(); stats.Failed != 1 || stats.Sent != 0 || stats.LastError == "" {
+				t.Errorf("stats = %+v, want one failure and nothing sent", stats)
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if len(notified) != 1 || !errors.Is(notified[0], tt.wantErr) {
+				t.Errorf("onError calls = %v, want exactly one %v", notified, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQueueClose(t *testing.T) {
+	q := New(func(*protobuf.CommandMessage) error {
+		return nil
+	}, func(err error) {
+		t.Errorf("onError called on Close: %v", err)
+	}, 4, time.Second)
+
+	q.Close()
+	q.Close()
+	waitDone(t, q)
+
+	if err := q.Send(ackMessage(0)); !errors.Is(err, ErrClosed) {
+		t.Errorf("Send after Close = %v, want ErrClosed", err)
+	}
+	if err := q.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil after Close", err)
+	}
+}
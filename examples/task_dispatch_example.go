@@ -53,6 +53,30 @@ func (e *ExampleTaskDispatcher) SendCommandToAgent(hostID string, command *model
 	return nil
 }
 
+func (e *ExampleTaskDispatcher) SendControlToAgent(hostID string, control *models.CommandControl) error {
+	fmt.Printf("📤 发送控制请求到 Agent %s:\n", hostID)
+	fmt.Printf("   控制ID: %s\n", control.ControlID)
+	fmt.Printf("   命令ID: %s\n", control.CommandID)
+	fmt.Printf("   动作: %s\n", control.Action)
+
+	// 模拟 Agent 确认
+	go func() {
+		ack := &models.ControlAck{
+			ControlID: control.ControlID,
+			CommandID: control.CommandID,
+			HostID:    hostID,
+			Action:    control.Action,
+			Success:   true,
+			State:     models.ExecutionStateFinished,
+		}
+		if err := service.GetTaskService().HandleControlAck(ack); err != nil {
+			log.Printf("处理控制确认失败: %v", err)
+		}
+	}()
+
+	return nil
+}
+
 func RunTaskDispatchExample() {
 	fmt.Println("🚀 任务下发执行系统示例")
 	fmt.Println("========================")
@@ -72,15 +96,14 @@ func RunTaskDispatchExample() {
 
 	fmt.Printf("📋 创建任务，目标主机: %v\n", hostIDs)
 
-	task, err := taskService.CreateTask(
-		"系统更新任务",
-		"更新所有服务器的系统包",
-		hostIDs,
-		"sudo apt update && sudo apt upgrade -y",
-		300, // 5分钟超时
-		"",
-		"admin",
-	)
+	task, err := taskService.CreateTask(service.CreateTaskRequest{
+		Name:        "系统更新任务",
+		Description: "更新所有服务器的系统包",
+		HostIDs:     hostIDs,
+		Command:     "sudo apt update && sudo apt upgrade -y",
+		Timeout:     300, // 5分钟超时
+		CreatedBy:   "admin",
+	})
 
 	if err != nil {
 		log.Fatalf("创建任务失败: %v", err)
@@ -93,7 +116,7 @@ func RunTaskDispatchExample() {
 
 	// 启动任务
 	fmt.Printf("\n🎯 启动任务下发...\n")
-	err = taskService.StartTask(task.TaskID)
+	err = taskService.StartTask(task.TaskID, task.CreatedBy, nil)
 	if err != nil {
 		log.Fatalf("启动任务失败: %v", err)
 	}
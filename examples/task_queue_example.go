@@ -5,13 +5,14 @@ import (
 	"log"
 	"time"
 
+	"devops-manager/api/models"
 	"devops-manager/server/pkg/service"
 )
 
 // ExampleTaskService 示例任务服务
 type ExampleTaskService struct{}
 
-func (e *ExampleTaskService) StartTask(taskID string) error {
+func (e *ExampleTaskService) StartTask(taskID, operator string, scope models.HostScope) error {
 	log.Printf("Executing task: %s", taskID)
 	// 模拟任务执行时间
 	time.Sleep(time.Duration(100+taskID[len(taskID)-1]) * time.Millisecond)
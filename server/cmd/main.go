@@ -1,18 +1,24 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"log"
 	"net"
+	"os"
 	"sync"
 
 	"devops-manager/server/pkg/config"
 	"devops-manager/server/pkg/controller"
 	"devops-manager/server/pkg/database"
+	"devops-manager/server/pkg/service"
 
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	// Swagger imports
 	_ "devops-manager/docs"
@@ -35,6 +41,11 @@ import (
 
 // @securityDefinitions.basic  BasicAuth
 
+// @securityDefinitions.apikey  BearerAuth
+// @in                          header
+// @name                        Authorization
+// @description                 API 令牌，格式为 "Bearer dm_xxx"
+
 // @externalDocs.description  OpenAPI
 // @externalDocs.url          https://swagger.io/resources/open-api/
 
@@ -57,6 +68,38 @@ func main() {
 	}
 	defer database.CloseRedis()
 
+	// 初始化内置 CA
+	if cfg.GRPC.TLS.CA.Enabled {
+		if err := service.InitCertificateAuthority(&cfg.GRPC.TLS.CA); err != nil {
+			log.Fatalf("Failed to initialize certificate authority: %v", err)
+		}
+	}
+
+	// 初始化命令输出存储
+	if err := service.InitOutputStore(&cfg.Output); err != nil {
+		log.Fatalf("Failed to initialize output store: %v", err)
+	}
+	if err := service.InitFileStore(&cfg.Files); err != nil {
+		log.Fatalf("Failed to initialize file store: %v", err)
+	}
+	if err := service.InitCollectStore(&cfg.Files); err != nil {
+		log.Fatalf("Failed to initialize collect store: %v", err)
+	}
+
+	// 初始化 Agent 升级计划，继续推进未结束的计划
+	service.InitUpgradeService(&cfg.Upgrade)
+
+	// Agent 断开后等待重连对账的时长
+	service.SetReconnectGracePeriod(cfg.GRPC.ReconnectGracePeriod)
+
+	// 初始化 REST API 认证
+	if cfg.Auth.Enabled {
+		if err := service.GetAuthService().EnsureAdmin(cfg.Auth.AdminUsername, cfg.Auth.AdminPassword, cfg.Auth.AdminPasswordFile); err != nil {
+			log.Fatalf("Failed to initialize authentication: %v", err)
+		}
+	}
+	controller.SetAuthEnabled(cfg.Auth.Enabled)
+
 	var wg sync.WaitGroup
 
 	// 启动 gRPC 服务器
@@ -82,10 +125,25 @@ func startGRPCServer(cfg *config.Config) {
 		log.Fatalf("Failed to listen on %s: %v", cfg.GRPC.Address, err)
 	}
 
-	s := grpc.NewServer()
+	var opts []grpc.ServerOption
+	if cfg.GRPC.TLS.Enabled {
+		creds, err := loadServerTLSCredentials(&cfg.GRPC.TLS)
+		if err != nil {
+			log.Fatalf("Failed to load gRPC TLS credentials: %v", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+		service.SetClientCertRequired(true)
+		log.Println("gRPC mutual TLS enabled")
+	} else {
+		log.Println("Warning: gRPC TLS disabled, agent traffic is plaintext")
+	}
+
+	s := grpc.NewServer(opts...)
 
 	// 注册所有 gRPC 服务并获取任务控制器
 	taskController := controller.RegisterGRPCServices(s)
+	taskController.SetAgentAuthToken(cfg.GRPC.AgentAuthToken)
+	taskController.SetSendQueueOptions(cfg.GRPC.SendQueueSize, cfg.GRPC.SendTimeout)
 
 	// 设置任务分发器，建立 TaskService 和 gRPC 控制器的连接
 	controller.SetupTaskDispatcher(taskController)
@@ -96,6 +154,64 @@ func startGRPCServer(cfg *config.Config) {
 	}
 }
 
+// loadServerTLSCredentials 加载 gRPC 服务端双向 TLS 凭证
+// 启用内置 CA 时允许尚未入网的 Agent 不带证书注册，其余接口由服务层要求证书
+func loadServerTLSCredentials(tlsCfg *config.TLSConfig) (credentials.TransportCredentials, error) {
+	ca := service.GetCertificateAuthority()
+
+	var cert tls.Certificate
+	var err error
+	switch {
+	case tlsCfg.CertFile != "":
+		cert, err = tls.LoadX509KeyPair(tlsCfg.CertFile, tlsCfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load server certificate: %w", err)
+		}
+	case ca != nil:
+		cert, err = ca.IssueServerCertificate(tlsCfg.ServerNames)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("server certificate is required when built-in CA is disabled")
+	}
+
+	clientCAs := x509.NewCertPool()
+	if ca != nil {
+		clientCAs = ca.CertPool()
+	}
+	if tlsCfg.ClientCAFile != "" {
+		caPEM, err := os.ReadFile(tlsCfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", tlsCfg.ClientCAFile)
+		}
+	} else if ca == nil {
+		return nil, fmt.Errorf("client CA is required when built-in CA is disabled")
+	}
+
+	clientAuth := tls.RequireAndVerifyClientCert
+	if ca != nil {
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   clientAuth,
+		MinVersion:   tls.VersionTLS12,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			// 拒绝已吊销的客户端证书
+			if ca != nil && len(state.PeerCertificates) > 0 && ca.IsRevoked(state.PeerCertificates[0].SerialNumber) {
+				return fmt.Errorf("certificate %s has been revoked", state.PeerCertificates[0].SerialNumber.Text(16))
+			}
+			return nil
+		},
+	}), nil
+}
+
 func startHTTPServer(cfg *config.Config) {
 	r := gin.Default()
 
@@ -3,15 +3,20 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
 	HTTP    HTTPConfig    `yaml:"http"`
+	Auth    AuthConfig    `yaml:"auth"`
 	GRPC    GRPCConfig    `yaml:"grpc"`
 	MySQL   MySQLConfig   `yaml:"mysql"`
 	Redis   RedisConfig   `yaml:"redis"`
+	Output  OutputConfig  `yaml:"output"`
+	Files   FilesConfig   `yaml:"files"`
+	Upgrade UpgradeConfig `yaml:"upgrade"`
 	Logging LoggingConfig `yaml:"logging"`
 }
 
@@ -19,8 +24,44 @@ type HTTPConfig struct {
 	Address string `yaml:"address"`
 }
 
+// AuthConfig REST API 认证配置
+type AuthConfig struct {
+	Enabled           bool   `yaml:"enabled"`
+	AdminUsername     string `yaml:"admin_username"`      // 首次启动且没有任何用户时创建的管理员
+	AdminPassword     string `yaml:"admin_password"`      // 初始管理员密码，为空时随机生成并写入 admin_password_file
+	AdminPasswordFile string `yaml:"admin_password_file"` // 随机生成的初始管理员密码的保存位置（权限 0600）
+}
+
 type GRPCConfig struct {
-	Address string `yaml:"address"`
+	Address        string    `yaml:"address"`
+	AgentAuthToken string    `yaml:"agent_auth_token"` // Agent 命令流握手令牌，为空时不校验
+	TLS            TLSConfig `yaml:"tls"`
+
+	// Agent 断开后等待重连对账的时长，超时后将其已下发的命令标记为失败；为负数时断开即标记失败
+	ReconnectGracePeriod time.Duration `yaml:"reconnect_grace_period"`
+
+	// 每条命令流的发送队列：缓冲消息数，以及入队等待和单条消息写入的超时，写入超时视为连接阻塞并断开
+	SendQueueSize int           `yaml:"send_queue_size"`
+	SendTimeout   time.Duration `yaml:"send_timeout"`
+}
+
+// TLSConfig gRPC 双向 TLS 配置
+type TLSConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	CertFile     string   `yaml:"cert_file"`      // 服务端证书，为空且启用内置 CA 时由 CA 签发
+	KeyFile      string   `yaml:"key_file"`       // 服务端私钥
+	ClientCAFile string   `yaml:"client_ca_file"` // 用于校验 Agent 证书的 CA
+	ServerNames  []string `yaml:"server_names"`   // 内置 CA 签发服务端证书时使用的域名/IP
+	CA           CAConfig `yaml:"ca"`
+}
+
+// CAConfig 内置证书颁发机构配置
+type CAConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	CertFile        string        `yaml:"cert_file"`        // CA 证书，不存在时自动生成
+	KeyFile         string        `yaml:"key_file"`         // CA 私钥
+	CertValidity    time.Duration `yaml:"cert_validity"`    // 签发给主机的证书有效期
+	RotationOverlap time.Duration `yaml:"rotation_overlap"` // 签发新证书后主机旧证书仍可使用的时长，超过后吊销
 }
 
 type MySQLConfig struct {
@@ -41,6 +82,37 @@ type RedisConfig struct {
 	DB       int    `yaml:"db"`
 }
 
+// OutputConfig 命令输出存储配置
+type OutputConfig struct {
+	MaxBytes int    `yaml:"max_bytes"` // 数据库中每个输出流保留的最大字节数，超出时截断
+	SpoolDir string `yaml:"spool_dir"` // 完整输出转存目录，为空时不转存
+}
+
+// FilesConfig 文件分发存储配置
+type FilesConfig struct {
+	Dir       string `yaml:"dir"`        // 上传文件的存储目录，按 SHA-256 保存
+	MaxBytes  int64  `yaml:"max_bytes"`  // 单个上传文件的最大字节数
+	ChunkSize int    `yaml:"chunk_size"` // 向 Agent 发送文件时每个分片的字节数
+
+	CollectDir      string `yaml:"collect_dir"`       // 文件收集任务回传文件的存储目录，按任务和主机保存
+	CollectMaxBytes int64  `yaml:"collect_max_bytes"` // 每台主机在一个收集任务中回传的最大字节数
+
+	// 对等分发：同一标签值的主机属于同一区域，Agent 只从同区域的 Agent 获取分片
+	PeerZoneTag   string `yaml:"peer_zone_tag"`   // 划分区域的标签名
+	PeerChunkSize int    `yaml:"peer_chunk_size"` // 对等分发和断点续传时的分片字节数，不超过 gRPC 消息上限
+
+	// 传输限速（字节/秒），为 0 时不限速；任务指定的限速与每主机限速取较小值
+	MaxBandwidth  int64 `yaml:"max_bandwidth"`  // Server 所有文件传输的总带宽
+	HostBandwidth int64 `yaml:"host_bandwidth"` // 每台主机的文件传输带宽
+}
+
+// UpgradeConfig Agent 升级计划配置
+type UpgradeConfig struct {
+	CheckInterval    time.Duration `yaml:"check_interval"`    // 检查升级批次进度的间隔
+	ReconnectTimeout time.Duration `yaml:"reconnect_timeout"` // 创建计划时未指定的默认值：新版本启动后连上 Server 的期限，超过时 Agent 回滚
+	Timeout          time.Duration `yaml:"timeout"`           // 创建计划时未指定的默认值：下载和校验新版本的超时时间
+}
+
 type LoggingConfig struct {
 	Level  string `yaml:"level"`
 	Format string `yaml:"format"`
@@ -78,8 +150,25 @@ func getDefaultConfig() *Config {
 		HTTP: HTTPConfig{
 			Address: ":8080",
 		},
+		Auth: AuthConfig{
+			Enabled:           true,
+			AdminUsername:     "admin",
+			AdminPasswordFile: filepath.Join("server", "data", "admin_password"),
+		},
 		GRPC: GRPCConfig{
-			Address: ":50051",
+			Address:              ":50051",
+			ReconnectGracePeriod: 60 * time.Second,
+			SendQueueSize:        256,
+			SendTimeout:          10 * time.Second,
+			TLS: TLSConfig{
+				ServerNames: []string{"localhost", "127.0.0.1"},
+				CA: CAConfig{
+					CertFile:        filepath.Join("server", "config", "ca", "ca.crt"),
+					KeyFile:         filepath.Join("server", "config", "ca", "ca.key"),
+					CertValidity:    30 * 24 * time.Hour,
+					RotationOverlap: 5 * time.Minute,
+				},
+			},
 		},
 		MySQL: MySQLConfig{
 			Host:      "127.0.0.1",
@@ -97,6 +186,25 @@ func getDefaultConfig() *Config {
 			Password: "",
 			DB:       0,
 		},
+		Output: OutputConfig{
+			MaxBytes: 1024 * 1024,
+		},
+		Files: FilesConfig{
+			Dir:       filepath.Join("server", "data", "files"),
+			MaxBytes:  1024 * 1024 * 1024,
+			ChunkSize: 256 * 1024,
+
+			CollectDir:      filepath.Join("server", "data", "collected"),
+			CollectMaxBytes: 1024 * 1024 * 1024,
+
+			PeerZoneTag:   "zone",
+			PeerChunkSize: 1024 * 1024,
+		},
+		Upgrade: UpgradeConfig{
+			CheckInterval:    10 * time.Second,
+			ReconnectTimeout: 5 * time.Minute,
+			Timeout:          10 * time.Minute,
+		},
 		Logging: LoggingConfig{
 			Level:  "info",
 			Format: "json",
@@ -110,15 +218,78 @@ func mergeDefaults(config *Config) {
 	if config.HTTP.Address == "" {
 		config.HTTP.Address = defaults.HTTP.Address
 	}
+	if config.Auth.AdminUsername == "" {
+		config.Auth.AdminUsername = defaults.Auth.AdminUsername
+	}
+	if config.Auth.AdminPasswordFile == "" {
+		config.Auth.AdminPasswordFile = defaults.Auth.AdminPasswordFile
+	}
 	if config.GRPC.Address == "" {
 		config.GRPC.Address = defaults.GRPC.Address
 	}
+	if config.GRPC.ReconnectGracePeriod == 0 {
+		config.GRPC.ReconnectGracePeriod = defaults.GRPC.ReconnectGracePeriod
+	}
+	if config.GRPC.SendQueueSize <= 0 {
+		config.GRPC.SendQueueSize = defaults.GRPC.SendQueueSize
+	}
+	if config.GRPC.SendTimeout <= 0 {
+		config.GRPC.SendTimeout = defaults.GRPC.SendTimeout
+	}
+	if len(config.GRPC.TLS.ServerNames) == 0 {
+		config.GRPC.TLS.ServerNames = defaults.GRPC.TLS.ServerNames
+	}
+	if config.GRPC.TLS.CA.CertFile == "" {
+		config.GRPC.TLS.CA.CertFile = defaults.GRPC.TLS.CA.CertFile
+	}
+	if config.GRPC.TLS.CA.KeyFile == "" {
+		config.GRPC.TLS.CA.KeyFile = defaults.GRPC.TLS.CA.KeyFile
+	}
+	if config.GRPC.TLS.CA.CertValidity == 0 {
+		config.GRPC.TLS.CA.CertValidity = defaults.GRPC.TLS.CA.CertValidity
+	}
+	if config.GRPC.TLS.CA.RotationOverlap == 0 {
+		config.GRPC.TLS.CA.RotationOverlap = defaults.GRPC.TLS.CA.RotationOverlap
+	}
 	if config.MySQL.Host == "" {
 		config.MySQL = defaults.MySQL
 	}
 	if config.Redis.Host == "" {
 		config.Redis = defaults.Redis
 	}
+	if config.Output.MaxBytes <= 0 {
+		config.Output.MaxBytes = defaults.Output.MaxBytes
+	}
+	if config.Files.Dir == "" {
+		config.Files.Dir = defaults.Files.Dir
+	}
+	if config.Files.MaxBytes <= 0 {
+		config.Files.MaxBytes = defaults.Files.MaxBytes
+	}
+	if config.Files.ChunkSize <= 0 {
+		config.Files.ChunkSize = defaults.Files.ChunkSize
+	}
+	if config.Files.CollectDir == "" {
+		config.Files.CollectDir = defaults.Files.CollectDir
+	}
+	if config.Files.CollectMaxBytes <= 0 {
+		config.Files.CollectMaxBytes = defaults.Files.CollectMaxBytes
+	}
+	if config.Files.PeerZoneTag == "" {
+		config.Files.PeerZoneTag = defaults.Files.PeerZoneTag
+	}
+	if config.Files.PeerChunkSize <= 0 {
+		config.Files.PeerChunkSize = defaults.Files.PeerChunkSize
+	}
+	if config.Upgrade.CheckInterval <= 0 {
+		config.Upgrade.CheckInterval = defaults.Upgrade.CheckInterval
+	}
+	if config.Upgrade.ReconnectTimeout <= 0 {
+		config.Upgrade.ReconnectTimeout = defaults.Upgrade.ReconnectTimeout
+	}
+	if config.Upgrade.Timeout <= 0 {
+		config.Upgrade.Timeout = defaults.Upgrade.Timeout
+	}
 	if config.Logging.Level == "" {
 		config.Logging.Level = defaults.Logging.Level
 	}
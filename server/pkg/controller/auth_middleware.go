@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"devops-manager/api/models"
+	"devops-manager/server/pkg/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// principalKey gin 上下文中保存已认证调用方的键
+const principalKey = "principal"
+
+// authEnabled 是否启用 REST API 认证
+var authEnabled = true
+
+// anonymousPrincipal 未启用认证时的调用方，拥有全部权限
+var anonymousPrincipal = &service.Principal{Username: "anonymous", Role: models.UserRoleAdmin}
+
+// SetAuthEnabled 设置是否启用 REST API 认证
+func SetAuthEnabled(enabled bool) {
+	authEnabled = enabled
+	if !enabled {
+		log.Println("Warning: REST API authentication disabled, all requests are treated as admin")
+	}
+}
+
+// AuthMiddleware 认证中间件，支持 Authorization: Bearer <API 令牌> 和 HTTP Basic
+func AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authEnabled {
+			c.Set(principalKey, anonymousPrincipal)
+			c.Next()
+			return
+		}
+
+		principal, err := authenticate(c)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Basic realm="devops-manager"`)
+			SendErrorResponse(c, http.StatusUnauthorized, err.Error())
+			c.Abort()
+			return
+		}
+
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// authenticate 从请求头中解析并校验调用方身份
+func authenticate(c *gin.Context) (*service.Principal, error) {
+	authService := service.GetAuthService()
+
+	header := c.GetHeader("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return authService.AuthenticateToken(strings.TrimSpace(token))
+	}
+	if username, password, ok := c.Request.BasicAuth(); ok {
+		return authService.AuthenticatePassword(username, password)
+	}
+	// 浏览器 EventSource 无法设置请求头，事件流请求允许通过 access_token 参数传递令牌
+	if token := c.Query("access_token"); token != "" && strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		return authService.AuthenticateToken(token)
+	}
+	return nil, service.ErrInvalidCredentials
+}
+
+// RequireRole 角色校验中间件，需在 AuthMiddleware 之后使用
+func RequireRole(role models.UserRole) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := CurrentPrincipal(c)
+		if principal == nil || !principal.Role.Allows(role) {
+			SendErrorResponse(c, http.StatusForbidden, "Permission denied: "+string(role)+" role required")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireUnrestrictedScope 拒绝限制了主机范围的调用方，用于无法按主机过滤的全局查询，需在 AuthMiddleware 之后使用
+func requireUnrestrictedScope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if currentHostScope(c).IsRestricted() {
+			SendErrorResponse(c, http.StatusForbidden, "Permission denied: not available to users with a host scope")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// CurrentPrincipal 获取当前请求的已认证调用方
+func CurrentPrincipal(c *gin.Context) *service.Principal {
+	value, exists := c.Get(principalKey)
+	if !exists {
+		return nil
+	}
+	principal, _ := value.(*service.Principal)
+	return principal
+}
+
+// currentUsername 获取当前调用方用户名，用于任务创建者和审计日志
+func currentUsername(c *gin.Context) string {
+	if principal := CurrentPrincipal(c); principal != nil {
+		return principal.Username
+	}
+	return ""
+}
+
+// currentHostScope 获取当前调用方可操作的主机范围，为空表示不限制
+func currentHostScope(c *gin.Context) models.HostScope {
+	if principal := CurrentPrincipal(c); principal != nil {
+		return principal.HostScope
+	}
+	return nil
+}
+
+// isHostScopeError 检查是否为目标主机越权错误
+func isHostScopeError(err error) bool {
+	var scopeErr *service.HostScopeError
+	return errors.As(err, &scopeErr)
+}
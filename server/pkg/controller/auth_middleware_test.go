@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"devops-manager/api/models"
+	"devops-manager/server/pkg/database"
+	"devops-manager/server/pkg/service"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupAuthTestDB 使用内存 SQLite 作为认证服务的数据库并写入各角色用户（密码与用户名相同）
+// 认证服务是单例，整个测试包共用同一个数据库
+func setupAuthTestDB(t *testing.T) {
+	t.Helper()
+	if database.DB == nil {
+		db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+		if err != nil {
+			t.Fatalf("failed to open test database: %v", err)
+		}
+		sqlDB, err := db.DB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sqlDB.SetMaxOpenConns(1)
+		if err := db.AutoMigrate(&models.User{}, &models.APIToken{}); err != nil {
+			t.Fatal(err)
+		}
+		database.DB = db
+	}
+
+	users := []struct {
+		username string
+		role     models.UserRole
+		enabled  bool
+	}{
+		{"viewer", models.UserRoleViewer, true},
+		{"operator", models.UserRoleOperator, true},
+		{"admin", models.UserRoleAdmin, true},
+		{"disabled", models.UserRoleAdmin, false},
+	}
+	for _, u := range users {
+		var count int64
+		database.DB.Model(&models.User{}).Where("username = ?", u.username).Count(&count)
+		if count > 0 {
+			continue
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(u.username), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user := &models.User{Username: u.username, PasswordHash: string(hash), Role: u.role, Enabled: true}
+		if err := database.DB.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+		if !u.enabled {
+			database.DB.Model(user).Update("enabled", false)
+		}
+	}
+}
+
+// newRBACTestRouter 各角色要求一个接口的测试路由
+func newRBACTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api", AuthMiddleware())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	api.GET("/viewer", RequireRole(models.UserRoleViewer), ok)
+	api.GET("/operator", RequireRole(models.UserRoleOperator), ok)
+	api.GET("/admin", RequireRole(models.UserRoleAdmin), ok)
+	return r
+}
+
+func TestAuthMiddlewareRBAC(t *testing.T) {
+	setupAuthTestDB(t)
+	SetAuthEnabled(true)
+	router := newRBACTestRouter()
+
+	var operator models.User
+	if err := database.DB.Where("username = ?", "operator").First(&operator).Error; err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := service.GetAuthService().CreateToken(operator.ID, "test", 0)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		username   string
+		password   string
+		bearer     string
+		wantStatus int
+	}{
+		{name: "no credentials", path: "/api/viewer", wantStatus: http.StatusUnauthorized},
+		{name: "wrong password", path: "/api/viewer", username: "viewer", password: "admin", wantStatus: http.StatusUnauthorized},
+		{name: "disabled user", path: "/api/viewer", username: "disabled", password: "disabled", wantStatus: http.StatusUnauthorized},
+		{name: "invalid token", path: "/api/viewer", bearer: "dm_invalid", wantStatus: http.StatusUnauthorized},
+		{name: "viewer reads", path: "/api/viewer", username: "viewer", password: "viewer", wantStatus: http.StatusOK},
+		{name: "viewer cannot operate", path: "/api/operator", username: "viewer", password: "viewer", wantStatus: http.StatusForbidden},
+		{name: "viewer cannot administer", path: "/api/admin", username: "viewer", password: "viewer", wantStatus: http.StatusForbidden},
+		{name: "operator operates", path: "/api/operator", username: "operator", password: "operator", wantStatus: http.StatusOK},
+		{name: "operator cannot administer", path: "/api/admin", username: "operator", password: "operator", wantStatus: http.StatusForbidden},
+		{name: "operator token operates", path: "/api/operator", bearer: token, wantStatus: http.StatusOK},
+		{name: "operator token cannot administer", path: "/api/admin", bearer: token, wantStatus: http.StatusForbidden},
+		{name: "admin administers", path: "/api/admin", username: "admin", password: "admin", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.username != "" {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareDisabled(t *testing.T) {
+	SetAuthEnabled(false)
+	defer SetAuthEnabled(true)
+	router := newRBACTestRouter()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("GET /api/admin with authentication disabled = %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"log"
+	"time"
+
+	"devops-manager/api/protobuf"
+)
+
+// fetchWriterBacklog 待写入的分片超过该数量时不再等待 Server 总带宽，避免限速使缓冲持续堆积
+const fetchWriterBacklog = 64
+
+// fetchWriterBuffer 每个收集命令缓冲的待写入分片数上限，写入磁盘跟不上时丢弃后续分片并以失败记录该命令
+const fetchWriterBuffer = 256
+
+// fetchWriterIdleTimeout 写入协程超过该时长没有新的分片或结果时退出（如 Agent 下线后不再重发结果）
+const fetchWriterIdleTimeout = 10 * time.Minute
+
+// fetchWriter 按顺序写入一个收集命令回传的文件分片：等待带宽和写入磁盘在独立协程中进行，不阻塞 Agent 命令流的接收
+// 命令的最终结果同样排在分片之后处理，处理结果时文件已全部写入
+type fetchWriter struct {
+	commandID  string
+	items      chan func()
+	overflowed bool // 缓冲曾经已满，之后的分片被丢弃
+	finished   bool // 已放入最终结果，不再接收分片
+}
+
+// enqueueFetchChunk 将分片交给收集命令的写入协程，缓冲已满时丢弃该命令之后的所有分片
+func (tc *GRPCTaskController) enqueueFetchChunk(agentID string, chunk *protobuf.FetchChunk) {
+	tc.fetchWritersMutex.Lock()
+	defer tc.fetchWritersMutex.Unlock()
+
+	writer, exists := tc.fetchWriters[chunk.CommandId]
+	if !exists {
+		// 预留一个位置给最终结果
+		writer = &fetchWriter{commandID: chunk.CommandId, items: make(chan func(), fetchWriterBuffer+1)}
+		tc.fetchWriters[chunk.CommandId] = writer
+		go tc.runFetchWriter(writer)
+	}
+	if writer.overflowed {
+		return
+	}
+	if len(writer.items) >= fetchWriterBuffer {
+		writer.overflowed = true
+		log.Printf("Warning: Fetch chunks of command %s from agent %s exceed the write queue, discarding the rest", chunk.CommandId, agentID)
+		return
+	}
+	writer.items <- func() { tc.writeFetchChunk(agentID, chunk, len(writer.items) < fetchWriterBacklog) }
+}
+
+// finishFetchWriter 收集命令有分片尚在写入时，将最终结果排在分片之后处理并返回 true；没有写入协程时返回 false
+// finish 的参数表示是否有分片因缓冲已满被丢弃
+func (tc *GRPCTaskController) finishFetchWriter(commandID string, finish func(overflowed bool)) bool {
+	tc.fetchWritersMutex.Lock()
+	defer tc.fetchWritersMutex.Unlock()
+
+	writer, exists := tc.fetchWriters[commandID]
+	if !exists {
+		return false
+	}
+	delete(tc.fetchWriters, commandID)
+	writer.finished = true
+	overflowed := writer.overflowed
+	writer.items <- func() { finish(overflowed) }
+	close(writer.items)
+	return true
+}
+
+// runFetchWriter 依次执行写入协程中的分片和结果，空闲超时后退出
+func (tc *GRPCTaskController) runFetchWriter(writer *fetchWriter) {
+	idle := time.NewTimer(fetchWriterIdleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case item, ok := <-writer.items:
+			if !ok {
+				return
+			}
+			item()
+			idle.Reset(fetchWriterIdleTimeout)
+		case <-idle.C:
+			tc.fetchWritersMutex.Lock()
+			if !writer.finished && len(writer.items) == 0 {
+				delete(tc.fetchWriters, writer.commandID)
+				tc.fetchWritersMutex.Unlock()
+				log.Printf("Fetch writer of command %s idle for %v without a result, stopped", writer.commandID, fetchWriterIdleTimeout)
+				return
+			}
+			tc.fetchWritersMutex.Unlock()
+			idle.Reset(fetchWriterIdleTimeout)
+		}
+	}
+}
+
+// markFetchOverflow 有分片因写入跟不上被丢弃时，将收集命令的结果记为失败
+func markFetchOverflow(result *protobuf.CommandResult) {
+	message := "file collection failed: server could not keep up with collected file chunks, some files are incomplete"
+	result.ExitCode = -1
+	result.ErrorMessage = message
+	if result.Stderr != "" && result.Stderr[len(result.Stderr)-1] != '\n' {
+		result.Stderr += "\n"
+	}
+	result.Stderr += message + "\n"
+}
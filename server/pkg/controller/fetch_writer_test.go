@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"devops-manager/api/models"
+	"devops-manager/api/protobuf"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fetchTaskService 记录收集分片和命令结果，写入分片时等待 release
+type fetchTaskService struct {
+	TaskServiceInterface
+	release chan struct{}
+
+	mutex   sync.Mutex
+	events  []string
+	results chan *models.CommandResult
+}
+
+func (s *fetchTaskService) HandleFetchChunk(hostID string, chunk *protobuf.FetchChunk) error {
+	<-s.release
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, "chunk")
+	return nil
+}
+
+func (s *fetchTaskService) HandleCommandResult(result *models.CommandResult) error {
+	s.mutex.Lock()
+	s.events = append(s.events, "result")
+	s.mutex.Unlock()
+	s.results <- result
+	return nil
+}
+
+func newFetchTestController() (*GRPCTaskController, *fetchTaskService) {
+	ts := &fetchTaskService{release: make(chan struct{}), results: make(chan *models.CommandResult, 1)}
+	return NewGRPCTaskController(ts), ts
+}
+
+// handleWithin 在限定时间内处理 Agent 消息，超时说明命令流的接收被阻塞
+func handleWithin(t *testing.T, tc *GRPCTaskController, msg *protobuf.CommandMessage) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		tc.handleAgentMessage("host-1", msg)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handling an agent message blocked the receive loop")
+	}
+}
+
+func fetchChunkMessage(offset int64) *protobuf.CommandMessage {
+	return &protobuf.CommandMessage{Payload: &protobuf.CommandMessage_FetchChunk{FetchChunk: &protobuf.FetchChunk{
+		CommandId: "cmd-1", HostId: "host-1", Path: "/var/log/app.log", Offset: offset, Data: []byte("data"),
+	}}}
+}
+
+func fetchResultMessage() *protobuf.CommandMessage {
+	return &protobuf.CommandMessage{Payload: &protobuf.CommandMessage_CommandResult{CommandResult: &protobuf.CommandResult{
+		CommandId: "cmd-1", HostId: "host-1", StartedAt: timestamppb.Now(), FinishedAt: timestamppb.Now(),
+	}}}
+}
+
+func TestFetchChunksDoNotBlockReceiveLoop(t *testing.T) {
+	tc, ts := newFetchTestController()
+
+	// 写入分片阻塞时，分片和结果的处理都不阻塞命令流的接收
+	for i := int64(0); i < 3; i++ {
+		handleWithin(t, tc, fetchChunkMessage(i*4))
+	}
+	handleWithin(t, tc, fetchResultMessage())
+
+	select {
+	case <-ts.results:
+		t.Fatal("result handled before its fetch chunks were written")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(ts.release)
+
+	select {
+	case result := <-ts.results:
+		if result.ExitCode != 0 {
+			t.Errorf("result exit code = %d, want 0", result.ExitCode)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("result not handled after fetch chunks were written")
+	}
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	if len(ts.events) != 4 || ts.events[3] != "result" {
+		t.Errorf("events = %v, want 3 chunks then the result", ts.events)
+	}
+}
+
+func TestFetchChunkOverflowFailsCommand(t *testing.T) {
+	tc, ts := newFetchTestController()
+
+	// 写入一直阻塞时缓冲被占满，后续分片被丢弃
+	for i := int64(0); i < fetchWriterBuffer+10; i++ {
+		handleWithin(t, tc, fetchChunkMessage(i*4))
+	}
+	handleWithin(t, tc, fetchResultMessage())
+	close(ts.release)
+
+	select {
+	case result := <-ts.results:
+		if result.ExitCode != -1 || result.ErrorMessage == "" {
+			t.Errorf("result = exit code %d, error %q, want a collection failure", result.ExitCode, result.ErrorMessage)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("result not handled")
+	}
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	// 第一个分片已被写入协程取出，缓冲中最多还有 fetchWriterBuffer 个
+	if chunks := len(ts.events) - 1; chunks > fetchWriterBuffer+1 {
+		t.Errorf("%d chunks written, want at most %d", chunks, fetchWriterBuffer+1)
+	}
+}
+
+func TestResultWithoutFetchChunks(t *testing.T) {
+	tc, ts := newFetchTestController()
+
+	handleWithin(t, tc, fetchResultMessage())
+	select {
+	case <-ts.results:
+	default:
+		t.Fatal("result of a command without fetch chunks not handled immediately")
+	}
+	if _, exists := tc.fetchWriters["cmd-1"]; exists {
+		t.Error("fetch writer created for a command without fetch chunks")
+	}
+}
@@ -41,6 +41,16 @@ func RegisterGRPCServices(s *grpc.Server) *GRPCTaskController {
 func SetupTaskDispatcher(taskController *GRPCTaskController) {
 	// 将 gRPC 任务控制器设置为任务分发器
 	service.SetTaskDispatcher(taskController)
+
+	// 证书吊销生效后断开使用已吊销证书的命令流，并通知其他 Agent 的对等分发拒绝该证书（主动吊销时立即生效，轮换时在保留期结束后生效）
+	if ca := service.GetCertificateAuthority(); ca != nil {
+		ca.OnRevoke(func(hostID string) {
+			if taskController.DisconnectRevokedAgent(hostID) {
+				log.Printf("Agent %s disconnected after certificate revocation", hostID)
+			}
+			taskController.BroadcastRevocations()
+		})
+	}
 	log.Println("Task dispatcher setup completed")
 }
 
@@ -70,7 +80,7 @@ func ValidateGRPCRequest(req interface{}) error {
 func (gc *GRPCController) Register(ctx context.Context, req *protobuf.HostInfo) (*protobuf.RegisterResponse, error) {
 	LogGRPCRequest("Register", req.Hostname)
 
-	err := gc.hostService.RegisterHost(req)
+	certificate, err := gc.hostService.RegisterHost(ctx, req)
 	if err != nil {
 		LogGRPCResponse("Register", false, err.Error())
 		return &protobuf.RegisterResponse{
@@ -80,17 +90,29 @@ func (gc *GRPCController) Register(ctx context.Context, req *protobuf.HostInfo)
 	}
 
 	LogGRPCResponse("Register", true, "Host registered successfully")
-	return &protobuf.RegisterResponse{
+	response := &protobuf.RegisterResponse{
 		Success:    true,
 		AssignedId: req.Id,
-	}, nil
+	}
+	if certificate != nil {
+		response.Certificate = certificate.CertPEM
+	}
+	return response, nil
 }
 
 // ReportStatus 实现HostServiceServer接口
 func (gc *GRPCController) ReportStatus(ctx context.Context, req *protobuf.HostStatus) (*protobuf.HostStatusResponse, error) {
 	LogGRPCRequest("ReportStatus", req.HostId)
 
-	err := gc.hostService.ReportHostStatus(req)
+	if err := service.RequireHostIdentity(ctx, req.HostId); err != nil {
+		LogGRPCResponse("ReportStatus", false, err.Error())
+		return &protobuf.HostStatusResponse{
+			Success: false,
+			Message: err.Error(),
+		}, nil
+	}
+
+	err := gc.hostService.ReportHostStatus(ctx, req)
 	if err != nil {
 		LogGRPCResponse("ReportStatus", false, err.Error())
 		return &protobuf.HostStatusResponse{
@@ -46,7 +46,7 @@ func (gc *GRPCHostController) Register(ctx context.Context, req *protobuf.HostIn
 	}
 
 	// 注册主机
-	err := gc.hostService.RegisterHost(req)
+	certificate, err := gc.hostService.RegisterHost(ctx, req)
 	if err != nil {
 		LogGRPCResponse("Register", false, err.Error())
 		return &protobuf.RegisterResponse{
@@ -57,10 +57,14 @@ func (gc *GRPCHostController) Register(ctx context.Context, req *protobuf.HostIn
 
 	LogGRPCResponse("Register", true, "Host registered successfully: "+req.Id)
 
-	return &protobuf.RegisterResponse{
+	response := &protobuf.RegisterResponse{
 		Success:    true,
 		AssignedId: req.Id,
-	}, nil
+	}
+	if certificate != nil {
+		response.Certificate = certificate.CertPEM
+	}
+	return response, nil
 }
 
 // ReportStatus 处理Agent的主机状态上报
@@ -77,8 +81,17 @@ func (gc *GRPCHostController) ReportStatus(ctx context.Context, req *protobuf.Ho
 		}, nil
 	}
 
+	// 校验证书身份
+	if err := service.RequireHostIdentity(ctx, req.HostId); err != nil {
+		LogGRPCResponse("ReportStatus", false, err.Error())
+		return &protobuf.HostStatusResponse{
+			Success: false,
+			Message: err.Error(),
+		}, nil
+	}
+
 	// 处理状态上报
-	err := gc.hostService.ReportHostStatus(req)
+	err := gc.hostService.ReportHostStatus(ctx, req)
 	if err != nil {
 		LogGRPCResponse("ReportStatus", false, err.Error())
 		return &protobuf.HostStatusResponse{
@@ -87,6 +100,9 @@ func (gc *GRPCHostController) ReportStatus(ctx context.Context, req *protobuf.Ho
 		}, nil
 	}
 
+	// 同步主机负载到任务调度
+	service.GetTaskService().UpdateHostLoadFromStatus(req)
+
 	LogGRPCResponse("ReportStatus", true, "Status report processed successfully")
 
 	return &protobuf.HostStatusResponse{
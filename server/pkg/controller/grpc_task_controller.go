@@ -2,25 +2,67 @@ package controller
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/big"
 	"sync"
 	"time"
 
 	"devops-manager/api/models"
 	"devops-manager/api/protobuf"
+	"devops-manager/api/sendqueue"
+	"devops-manager/server/pkg/service"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // AgentConnection Agent连接信息
 type AgentConnection struct {
-	Stream      protobuf.CommandService_ConnectForCommandsServer
-	ConnectedAt time.Time
-	LastPing    time.Time
-	IsActive    bool
-	Context     context.Context
-	Cancel      context.CancelFunc
+	Stream       protobuf.CommandService_ConnectForCommandsServer
+	Sender       *sendqueue.Queue // 发送队列，所有发往 Agent 的消息都经由它串行写入 Stream
+	AgentVersion string           // 握手时上报的 Agent 版本
+	Capabilities []string         // 握手时声明的 Agent 能力
+	PeerAddress  string           // 对等分发的分片服务地址，为空时不指派其他 Agent 从它获取分片
+	CertSerial   *big.Int         // 命令流使用的客户端证书序列号，未携带证书时为 nil
+	ConnectedAt  time.Time
+	LastPing     time.Time
+	IsActive     bool
+	Context      context.Context
+	Cancel       context.CancelFunc
+}
+
+// capabilityDeliveryAck Agent 回复命令投递确认并按命令ID持久化去重
+const capabilityDeliveryAck = "delivery_ack"
+
+// capabilityFilePush Agent 支持接收文件分发
+const capabilityFilePush = "file_push"
+
+// capabilityFileFetch Agent 支持文件收集
+const capabilityFileFetch = "file_fetch"
+
+// capabilityFilePeer Agent 支持对等分发，按分片请求文件并可从其他 Agent 获取分片
+const capabilityFilePeer = "file_peer"
+
+// capabilityFileResume Agent 对所有文件分发按分片请求，命令流断开重连后从未完成的分片继续
+const capabilityFileResume = "file_resume"
+
+// capabilityAgentUpgrade Agent 支持自升级：接收新版本二进制，校验后切换并重启，无法连上 Server 时回滚
+const capabilityAgentUpgrade = "agent_upgrade"
+
+// HasCapability 检查 Agent 是否在握手时声明了指定能力
+func (conn *AgentConnection) HasCapability(capability string) bool {
+	for _, c := range conn.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
 }
 
 // ConnectionPool 连接池管理
@@ -42,16 +84,30 @@ type GRPCTaskController struct {
 	connectionPool *ConnectionPool
 	// 任务服务引用，用于处理命令结果
 	taskService TaskServiceInterface
+	// Agent 握手认证令牌，为空时不校验
+	agentAuthToken string
+	// 每条命令流的发送队列配置
+	sendQueueSize int
+	sendTimeout   time.Duration
+	// 正在回传文件的收集命令，按命令ID索引
+	fetchWriters      map[string]*fetchWriter
+	fetchWritersMutex sync.Mutex
 }
 
 // TaskServiceInterface 任务服务接口，避免循环导入
 type TaskServiceInterface interface {
 	HandleCommandResult(result *models.CommandResult) error
+	HandleCommandOutput(chunk *models.CommandOutputChunk) error
+	HandleControlAck(ack *models.ControlAck) error
 	HandleHostConnectionChange(hostID string, connected bool) error
+	ReconcileAgent(hostID string, running, finished []string, connectedAt time.Time, deliveryAck bool) error
+	HandleCommandDelivered(hostID, commandID string, success, duplicate bool, message string) error
+	HandleFetchChunk(hostID string, chunk *protobuf.FetchChunk) error
+	PlanFileChunk(hostID string, req *protobuf.FileChunkRequest) (*service.FileChunkPlan, error)
 }
 
-// AddConnection 添加Agent连接到连接池
-func (cp *ConnectionPool) AddConnection(agentID string, stream protobuf.CommandService_ConnectForCommandsServer) {
+// AddConnection 添加Agent连接到连接池，返回的上下文在连接被移除或替换时取消
+func (cp *ConnectionPool) AddConnection(agentID string, stream protobuf.CommandService_ConnectForCommandsServer, sender *sendqueue.Queue, hello *protobuf.AgentHello) context.Context {
 	cp.mutex.Lock()
 	defer cp.mutex.Unlock()
 
@@ -65,16 +121,27 @@ func (cp *ConnectionPool) AddConnection(agentID string, stream protobuf.CommandS
 	// 创建新的连接上下文
 	ctx, cancel := context.WithCancel(context.Background())
 
+	var certSerial *big.Int
+	if cert := service.PeerCertificate(stream.Context()); cert != nil {
+		certSerial = cert.SerialNumber
+	}
+
 	cp.connections[agentID] = &AgentConnection{
-		Stream:      stream,
-		ConnectedAt: time.Now(),
-		LastPing:    time.Now(),
-		IsActive:    true,
-		Context:     ctx,
-		Cancel:      cancel,
+		Stream:       stream,
+		Sender:       sender,
+		AgentVersion: hello.GetAgentVersion(),
+		Capabilities: hello.GetCapabilities(),
+		PeerAddress:  hello.GetPeerAddress(),
+		CertSerial:   certSerial,
+		ConnectedAt:  time.Now(),
+		LastPing:     time.Now(),
+		IsActive:     true,
+		Context:      ctx,
+		Cancel:       cancel,
 	}
 
 	log.Printf("Agent %s added to connection pool", agentID)
+	return ctx
 }
 
 // RemoveConnection 从连接池移除Agent连接
@@ -91,6 +158,25 @@ func (cp *ConnectionPool) RemoveConnection(agentID string) {
 	}
 }
 
+// RemoveConnectionIfStream 仅当连接池中登记的仍是该流时才移除
+// Agent 重连后旧流的断开不应影响新建立的连接
+func (cp *ConnectionPool) RemoveConnectionIfStream(agentID string, stream protobuf.CommandService_ConnectForCommandsServer) bool {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	conn, exists := cp.connections[agentID]
+	if !exists || conn.Stream != stream {
+		return false
+	}
+
+	if conn.Cancel != nil {
+		conn.Cancel()
+	}
+	delete(cp.connections, agentID)
+	log.Printf("Agent %s removed from connection pool", agentID)
+	return true
+}
+
 // GetConnection 获取Agent连接
 func (cp *ConnectionPool) GetConnection(agentID string) (*AgentConnection, bool) {
 	cp.mutex.RLock()
@@ -145,29 +231,20 @@ func (cp *ConnectionPool) startHeartbeatMonitor() {
 }
 
 // checkConnectionHealth 检查连接健康状态
+// 超时的连接标记为不活跃并取消其上下文，由命令流处理按断开流程移除连接并通知任务服务
 func (cp *ConnectionPool) checkConnectionHealth() {
 	cp.mutex.Lock()
 	defer cp.mutex.Unlock()
 
 	now := time.Now()
-	var disconnectedAgents []string
-
 	for agentID, conn := range cp.connections {
 		// 检查连接是否超时
-		if now.Sub(conn.LastPing) > cp.connectionTimeout {
+		if conn.IsActive && now.Sub(conn.LastPing) > cp.connectionTimeout {
 			log.Printf("Agent %s connection timeout, marking as inactive", agentID)
 			conn.IsActive = false
-			disconnectedAgents = append(disconnectedAgents, agentID)
-		}
-	}
-
-	// 清理超时的连接
-	for _, agentID := range disconnectedAgents {
-		if conn, exists := cp.connections[agentID]; exists {
 			if conn.Cancel != nil {
 				conn.Cancel()
 			}
-			delete(cp.connections, agentID)
 		}
 	}
 }
@@ -209,6 +286,9 @@ func NewGRPCTaskController(taskService TaskServiceInterface) *GRPCTaskController
 	return &GRPCTaskController{
 		connectionPool: NewConnectionPool(),
 		taskService:    taskService,
+		sendQueueSize:  sendqueue.DefaultSize,
+		sendTimeout:    sendqueue.DefaultSendTimeout,
+		fetchWriters:   make(map[string]*fetchWriter),
 	}
 }
 
@@ -220,66 +300,236 @@ func RegisterTaskGRPCService(s *grpc.Server, taskService TaskServiceInterface) *
 	return controller
 }
 
+// SetAgentAuthToken 设置 Agent 握手认证令牌
+func (tc *GRPCTaskController) SetAgentAuthToken(token string) {
+	tc.agentAuthToken = token
+}
+
+// SetSendQueueOptions 设置命令流发送队列的缓冲消息数和超时，对之后建立的连接生效
+func (tc *GRPCTaskController) SetSendQueueOptions(size int, timeout time.Duration) {
+	tc.sendQueueSize = size
+	tc.sendTimeout = timeout
+}
+
 // ConnectForCommands 处理Agent的命令连接请求
 // Agent调用此方法与Server建立长连接，用于接收和执行命令
+// 流上的第一条消息必须是握手消息，通过校验后才登记连接
 func (tc *GRPCTaskController) ConnectForCommands(stream protobuf.CommandService_ConnectForCommandsServer) error {
 	LogGRPCRequest("ConnectForCommands", "New agent connection")
 
-	var agentID string
-	var isRegistered bool
+	hello, err := tc.acceptHello(stream)
+	if err != nil {
+		log.Printf("Agent command stream rejected: %v", err)
+		return err
+	}
+	agentID := hello.HostId
+	connCtx, sender := tc.registerAgent(hello, stream)
+	defer sender.Close()
+
+	// 在独立 goroutine 中接收消息，以便连接被 Server 移除（断开、吊销、被新流替换）时及时结束本流
+	messages := make(chan *protobuf.CommandMessage)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case messages <- msg:
+			case <-connCtx.Done():
+				return
+			}
+		}
+	}()
 
 	// 监听Agent的消息
 	for {
-		msg, err := stream.Recv()
-		if err != nil {
-			if agentID != "" {
-				log.Printf("Agent %s disconnected: %v", agentID, err)
-				// 从连接池移除连接
-				tc.connectionPool.RemoveConnection(agentID)
-
+		select {
+		case <-connCtx.Done():
+			log.Printf("Agent %s command stream closed by server", agentID)
+			// 心跳超时时连接仍登记为本流，与 Agent 断开走同一流程；被新流替换或主动断开时已移除
+			if tc.connectionPool.RemoveConnectionIfStream(agentID, stream) && tc.taskService != nil {
+				tc.taskService.HandleHostConnectionChange(agentID, false)
+			}
+			return status.Error(codes.Aborted, "connection closed by server")
+		case err := <-recvErr:
+			log.Printf("Agent %s disconnected: %v", agentID, err)
+			// 从连接池移除连接（Agent 已用新流重连时保留新连接）
+			if tc.connectionPool.RemoveConnectionIfStream(agentID, stream) {
 				// 通知任务服务主机连接断开
 				if tc.taskService != nil {
 					tc.taskService.HandleHostConnectionChange(agentID, false)
 				}
-			} else {
-				log.Printf("Unknown agent disconnected: %v", err)
 			}
 			return err
+		case msg := <-messages:
+			tc.handleAgentMessage(agentID, msg)
 		}
+	}
+}
 
-		// 处理Agent返回的命令执行结果
-		if result := msg.GetCommandResult(); result != nil {
-			// 如果还没有注册，从结果中获取 Agent ID
-			if !isRegistered {
-				agentID = result.HostId
-				tc.registerAgent(agentID, stream)
-				isRegistered = true
-			}
-			// 更新心跳时间
-			tc.connectionPool.UpdateLastPing(agentID)
-			// 处理命令结果
-			tc.handleCommandResult(agentID, result)
-		}
-
-		// 处理Agent发送的心跳或注册信息
-		if content := msg.GetCommandContent(); content != nil {
-			// 如果还没有注册，从内容中获取 Agent ID
-			if !isRegistered {
-				agentID = content.HostId
-				tc.registerAgent(agentID, stream)
-				isRegistered = true
-			}
-			// 更新心跳时间
-			tc.connectionPool.UpdateLastPing(agentID)
-			log.Printf("Received heartbeat from agent %s", agentID)
+// handleAgentMessage 处理握手后 Agent 发送的消息
+func (tc *GRPCTaskController) handleAgentMessage(agentID string, msg *protobuf.CommandMessage) {
+	switch payload := msg.Payload.(type) {
+	case *protobuf.CommandMessage_CommandResult:
+		// 处理Agent返回的命令执行结果，主机ID必须与流身份一致
+		result := payload.CommandResult
+		if result.HostId != agentID {
+			log.Printf("Warning: Rejected command result %s from agent %s claiming host %s",
+				result.CommandId, agentID, result.HostId)
+			return
+		}
+		tc.connectionPool.UpdateLastPing(agentID)
+		tc.handleCommandResult(agentID, result)
+	case *protobuf.CommandMessage_OutputChunk:
+		chunk := payload.OutputChunk
+		if chunk.HostId != agentID {
+			log.Printf("Warning: Rejected output chunk of command %s from agent %s claiming host %s",
+				chunk.CommandId, agentID, chunk.HostId)
+			return
+		}
+		tc.connectionPool.UpdateLastPing(agentID)
+		tc.handleCommandOutput(agentID, chunk)
+	case *protobuf.CommandMessage_FetchChunk:
+		chunk := payload.FetchChunk
+		if chunk.HostId != agentID {
+			log.Printf("Warning: Rejected fetch chunk of command %s from agent %s claiming host %s",
+				chunk.CommandId, agentID, chunk.HostId)
+			return
+		}
+		tc.connectionPool.UpdateLastPing(agentID)
+		tc.handleFetchChunk(agentID, chunk)
+	case *protobuf.CommandMessage_FileChunkRequest:
+		req := payload.FileChunkRequest
+		if req.HostId != agentID {
+			log.Printf("Warning: Rejected file chunk request of command %s from agent %s claiming host %s",
+				req.CommandId, agentID, req.HostId)
+			return
+		}
+		tc.connectionPool.UpdateLastPing(agentID)
+		tc.handleFileChunkRequest(agentID, req)
+	case *protobuf.CommandMessage_ControlAck:
+		ack := payload.ControlAck
+		if ack.HostId != agentID {
+			log.Printf("Warning: Rejected control ack %s from agent %s claiming host %s",
+				ack.ControlId, agentID, ack.HostId)
+			return
+		}
+		tc.connectionPool.UpdateLastPing(agentID)
+		tc.handleControlAck(agentID, ack)
+	case *protobuf.CommandMessage_Reconcile:
+		report := payload.Reconcile
+		if report.HostId != agentID {
+			log.Printf("Warning: Rejected reconcile report from agent %s claiming host %s", agentID, report.HostId)
+			return
+		}
+		tc.connectionPool.UpdateLastPing(agentID)
+		tc.handleReconcile(agentID, report)
+	case *protobuf.CommandMessage_Heartbeat:
+		if payload.Heartbeat.HostId != agentID {
+			log.Printf("Warning: Rejected heartbeat from agent %s claiming host %s", agentID, payload.Heartbeat.HostId)
+			return
+		}
+		tc.connectionPool.UpdateLastPing(agentID)
+	case *protobuf.CommandMessage_Ack:
+		tc.connectionPool.UpdateLastPing(agentID)
+		tc.handleDeliveryAck(agentID, payload.Ack)
+	case *protobuf.CommandMessage_Hello:
+		log.Printf("Warning: Ignoring duplicate hello from agent %s", agentID)
+	default:
+		log.Printf("Warning: Ignoring unexpected message %T from agent %s", msg.Payload, agentID)
+	}
+}
+
+// acceptHello 接收并校验握手消息，并向 Agent 回复确认
+func (tc *GRPCTaskController) acceptHello(stream protobuf.CommandService_ConnectForCommandsServer) (*protobuf.AgentHello, error) {
+	msg, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+
+	hello := msg.GetHello()
+	if hello == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "first message must be hello, got %T", msg.Payload)
+	}
+
+	if err := tc.validateHello(stream.Context(), hello); err != nil {
+		ack := tc.newAck(hello.HostId, false, status.Convert(err).Message())
+		if sendErr := stream.Send(ack); sendErr != nil {
+			log.Printf("Failed to send handshake rejection to agent %s: %v", hello.HostId, sendErr)
 		}
+		return nil, err
+	}
+
+	if err := stream.Send(tc.newAck(hello.HostId, true, "")); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Agent %s handshake accepted: version=%s, capabilities=%v",
+		hello.HostId, hello.AgentVersion, hello.Capabilities)
+	return hello, nil
+}
+
+// validateHello 校验握手消息：主机ID、证书身份、认证令牌以及主机是否已准入
+func (tc *GRPCTaskController) validateHello(ctx context.Context, hello *protobuf.AgentHello) error {
+	if hello.HostId == "" {
+		return status.Error(codes.InvalidArgument, "host_id is required")
+	}
+
+	if err := service.RequireHostIdentity(ctx, hello.HostId); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	if tc.agentAuthToken != "" &&
+		subtle.ConstantTimeCompare([]byte(hello.AuthToken), []byte(tc.agentAuthToken)) != 1 {
+		return status.Error(codes.Unauthenticated, "invalid auth token")
+	}
+
+	if _, exists := service.GetHostService().GetHost(hello.HostId); !exists {
+		return status.Errorf(codes.PermissionDenied, "host %s is not approved", hello.HostId)
+	}
+
+	return nil
+}
+
+// newAck 构建确认消息
+func (tc *GRPCTaskController) newAck(refID string, success bool, message string) *protobuf.CommandMessage {
+	return &protobuf.CommandMessage{
+		Payload: &protobuf.CommandMessage_Ack{Ack: &protobuf.Ack{
+			RefId:   refID,
+			Success: success,
+			Message: message,
+		}},
+	}
+}
+
+// sendAck 向 Agent 发送确认消息，发送失败时只记录日志，Agent 会在重连后重发
+func (tc *GRPCTaskController) sendAck(agentID, refID string, success bool, message string) {
+	conn, exists := tc.connectionPool.GetConnection(agentID)
+	if !exists {
+		return
+	}
+	if err := conn.Sender.Send(tc.newAck(refID, success, message)); err != nil {
+		log.Printf("Failed to send ack of %s to agent %s: %v", refID, agentID, err)
 	}
 }
 
-// registerAgent 注册 Agent 连接
-func (tc *GRPCTaskController) registerAgent(agentID string, stream protobuf.CommandService_ConnectForCommandsServer) {
+// registerAgent 注册 Agent 连接，返回连接上下文和发送队列
+func (tc *GRPCTaskController) registerAgent(hello *protobuf.AgentHello, stream protobuf.CommandService_ConnectForCommandsServer) (context.Context, *sendqueue.Queue) {
+	agentID := hello.HostId
+
+	// 写入失败或阻塞超时时移除连接，结束本流
+	sender := sendqueue.New(stream.Send, func(err error) {
+		log.Printf("Command stream to agent %s failed: %v", agentID, err)
+		if tc.connectionPool.RemoveConnectionIfStream(agentID, stream) && tc.taskService != nil {
+			tc.taskService.HandleHostConnectionChange(agentID, false)
+		}
+	}, tc.sendQueueSize, tc.sendTimeout)
+
 	// 添加到连接池
-	tc.connectionPool.AddConnection(agentID, stream)
+	connCtx := tc.connectionPool.AddConnection(agentID, stream, sender, hello)
 
 	log.Printf("Agent %s registered for command execution", agentID)
 
@@ -287,6 +537,13 @@ func (tc *GRPCTaskController) registerAgent(agentID string, stream protobuf.Comm
 	if tc.taskService != nil {
 		tc.taskService.HandleHostConnectionChange(agentID, true)
 	}
+	// 记录 Agent 版本，正在升级的主机以此判断升级是否成功
+	go service.GetUpgradeService().HandleAgentHello(agentID, hello.GetAgentVersion())
+	if conn, exists := tc.connectionPool.GetConnection(agentID); exists {
+		tc.sendRevocations(agentID, conn)
+	}
+
+	return connCtx, sender
 }
 
 // SendCommandToAgent 实现 TaskDispatcher 接口 - 向指定Agent发送命令
@@ -297,30 +554,251 @@ func (tc *GRPCTaskController) SendCommandToAgent(hostID string, command *models.
 		return fmt.Errorf("agent %s not connected or inactive", hostID)
 	}
 
+	// 不支持文件分发或收集的 Agent 会把任务描述当作命令执行
+	if command.File != nil && !conn.HasCapability(capabilityFilePush) {
+		return fmt.Errorf("agent %s does not support file distribution", hostID)
+	}
+	if command.Fetch != nil && !conn.HasCapability(capabilityFileFetch) {
+		return fmt.Errorf("agent %s does not support file collection", hostID)
+	}
+	if command.Upgrade != nil && !conn.HasCapability(capabilityAgentUpgrade) {
+		return fmt.Errorf("agent %s does not support self-upgrade", hostID)
+	}
+
 	// 将 Command 模型转换为 protobuf 格式
 	commandContent := command.ToProtobufContent()
 
+	// 支持断点续传或对等分发的 Agent 按分片清单请求分片，其余 Agent 由 Server 推送整个文件
+	chunked := false
+	if command.File != nil {
+		peer := command.File.Peer && conn.HasCapability(capabilityFilePeer)
+		chunked = peer || conn.HasCapability(capabilityFileResume)
+		if chunked {
+			if err := tc.attachChunkManifest(commandContent.File); err != nil {
+				return err
+			}
+		}
+		commandContent.File.Peer = peer
+		commandContent.File.BandwidthLimit = service.HostBandwidth(command.File.BandwidthLimit)
+	}
+	if command.Fetch != nil {
+		commandContent.Fetch.BandwidthLimit = service.HostBandwidth(command.Fetch.BandwidthLimit)
+	}
+
 	// 构建命令消息
 	commandMsg := &protobuf.CommandMessage{
-		CommandContent: commandContent,
+		Payload: &protobuf.CommandMessage_CommandContent{CommandContent: commandContent},
 	}
 
-	// 发送命令
-	if err := conn.Stream.Send(commandMsg); err != nil {
+	// 放入发送队列，写入失败时由发送队列移除连接
+	if err := conn.Sender.Send(commandMsg); err != nil {
 		log.Printf("Failed to send command to agent %s: %v", hostID, err)
-		// 从连接池移除失效连接
-		tc.connectionPool.RemoveConnection(hostID)
+		return err
+	}
+
+	LogGRPCRequest("SendCommand", command.CommandID)
+	log.Printf("Command %s sent to agent %s", command.CommandID, hostID)
 
-		// 通知任务服务主机连接断开
-		if tc.taskService != nil {
-			tc.taskService.HandleHostConnectionChange(hostID, false)
+	// 由 Server 推送的文件分片在后台经由发送队列写入，不阻塞命令下发
+	if command.File != nil && !chunked {
+		go tc.pushFile(conn, command)
+	}
+	return nil
+}
+
+// pushFile 发送文件分发命令的文件分片，失败时以执行结果记录错误
+// 中止分片送达时 Agent 也会上报失败结果，按命令结果的去重规则处理
+func (tc *GRPCTaskController) pushFile(conn *AgentConnection, command *models.Command) {
+	startedAt := time.Now()
+	err := tc.sendFileChunks(conn, command)
+	if err == nil || tc.taskService == nil {
+		return
+	}
+	log.Printf("File transfer of command %s to agent %s failed: %v", command.CommandID, command.HostID, err)
+
+	finishedAt := time.Now()
+	result := &models.CommandResult{
+		CommandID:    command.CommandID,
+		HostID:       command.HostID,
+		Stderr:       err.Error(),
+		ExitCode:     -1,
+		StartedAt:    &startedAt,
+		FinishedAt:   &finishedAt,
+		ErrorMessage: "file transfer failed: " + err.Error(),
+	}
+	if err := tc.taskService.HandleCommandResult(result); err != nil {
+		log.Printf("Failed to record file transfer failure of command %s: %v", command.CommandID, err)
+	}
+}
+
+// attachChunkManifest 为按分片请求的文件附加分片大小和各分片的摘要
+func (tc *GRPCTaskController) attachChunkManifest(file *protobuf.FileSpec) error {
+	store := service.GetFileStore()
+	if store == nil {
+		return fmt.Errorf("file store is not configured")
+	}
+	chunkSize := service.GetPeerChunkSize()
+	chunks, err := store.Manifest(file.Sha256, chunkSize)
+	if err != nil {
+		return fmt.Errorf("failed to build chunk manifest of file %s: %w", file.Sha256, err)
+	}
+	file.ChunkSize = int64(chunkSize)
+	file.ChunkSha256 = chunks
+	return nil
+}
+
+// handleFileChunkRequest 回复Agent的分片请求：对等分发时授权并指派同区域已持有分片且在线的Agent，没有时按限速直接发送分片数据；只上报已缓存的分片时不回复
+func (tc *GRPCTaskController) handleFileChunkRequest(agentID string, req *protobuf.FileChunkRequest) {
+	conn, exists := tc.connectionPool.GetConnection(agentID)
+	if !exists || tc.taskService == nil {
+		return
+	}
+	sendChunk := func(chunk *protobuf.FileChunk) {
+		chunk.CommandId = req.CommandId
+		if err := conn.Sender.Send(&protobuf.CommandMessage{
+			Payload: &protobuf.CommandMessage_FileChunk{FileChunk: chunk},
+		}); err != nil {
+			log.Printf("Failed to send file chunk %d of command %s to agent %s: %v", req.Index, req.CommandId, agentID, err)
+		}
+	}
+
+	plan, err := tc.taskService.PlanFileChunk(agentID, req)
+	if err != nil {
+		log.Printf("Failed to handle file chunk request %d of command %s from agent %s: %v", req.Index, req.CommandId, agentID, err)
+		if !req.ReportOnly {
+			sendChunk(&protobuf.FileChunk{Error: err.Error()})
+		}
+		return
+	}
+	if plan == nil {
+		return
+	}
+
+	for _, peerID := range plan.Peers {
+		peerConn, online := tc.connectionPool.GetConnection(peerID)
+		if !online || peerConn.PeerAddress == "" || !peerConn.HasCapability(capabilityFilePeer) {
+			continue
+		}
+		// 对等 Agent 只向获得授权的主机提供分片
+		if err := peerConn.Sender.Send(&protobuf.CommandMessage{
+			Payload: &protobuf.CommandMessage_PeerGrant{PeerGrant: &protobuf.PeerGrant{HostId: agentID, ChunkSha256: plan.ChunkSHA256}},
+		}); err != nil {
+			log.Printf("Failed to grant chunk %d of command %s to agent %s on peer %s: %v", req.Index, req.CommandId, agentID, peerID, err)
+			continue
+		}
+		sendChunk(&protobuf.FileChunk{
+			Offset: plan.Offset,
+			Peer:   &protobuf.FilePeer{HostId: peerID, Address: peerConn.PeerAddress},
+		})
+		return
+	}
+
+	data, err := readFileChunk(plan)
+	if err != nil {
+		log.Printf("Failed to read file chunk %d of command %s for agent %s: %v", req.Index, req.CommandId, agentID, err)
+		sendChunk(&protobuf.FileChunk{Error: err.Error()})
+		return
+	}
+	// 限速等待不阻塞该 Agent 命令流的接收；连接断开时放弃发送，Agent 重连后重新请求该分片
+	go func() {
+		if err := service.WaitTransfer(conn.Context, plan.Limiter, len(data)); err != nil {
+			return
 		}
+		sendChunk(&protobuf.FileChunk{Offset: plan.Offset, Data: data})
+	}()
+}
+
+// readFileChunk 从文件存储读取分片数据
+func readFileChunk(plan *service.FileChunkPlan) ([]byte, error) {
+	store := service.GetFileStore()
+	if store == nil {
+		return nil, fmt.Errorf("file store is not configured")
+	}
+	file, err := store.Open(plan.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data := make([]byte, plan.Size)
+	if _, err := file.ReadAt(data, plan.Offset); err != nil {
+		return nil, fmt.Errorf("failed to read file %s at offset %d: %w", plan.SHA256, plan.Offset, err)
+	}
+	return data, nil
+}
 
+// sendFileChunks 紧随文件分发命令按顺序发送文件分片
+// 分片经由发送队列写入，队列已满时等待，从而按连接的发送速度读取文件；读取失败时发送带错误的分片中止 Agent 的接收
+func (tc *GRPCTaskController) sendFileChunks(conn *AgentConnection, command *models.Command) error {
+	sendChunk := func(chunk *protobuf.FileChunk) error {
+		return conn.Sender.Send(&protobuf.CommandMessage{
+			Payload: &protobuf.CommandMessage_FileChunk{FileChunk: chunk},
+		})
+	}
+	abort := func(err error) error {
+		sendChunk(&protobuf.FileChunk{CommandId: command.CommandID, Error: err.Error()})
 		return err
 	}
 
-	LogGRPCRequest("SendCommand", command.CommandID)
-	log.Printf("Command %s sent to agent %s", command.CommandID, hostID)
+	store := service.GetFileStore()
+	if store == nil {
+		return abort(fmt.Errorf("file store is not configured"))
+	}
+	file, err := store.Open(command.File.SHA256)
+	if err != nil {
+		return abort(err)
+	}
+	defer file.Close()
+
+	limiter := service.NewHostLimiter(command.File.BandwidthLimit)
+	buffer := make([]byte, service.GetFileChunkSize())
+	var offset int64
+	for {
+		n, err := io.ReadFull(file, buffer)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return abort(fmt.Errorf("failed to read file %s: %w", command.File.SHA256, err))
+		}
+		if err := service.WaitTransfer(conn.Context, limiter, n); err != nil {
+			return fmt.Errorf("file transfer to agent %s interrupted: %w", command.HostID, err)
+		}
+		last := err != nil || offset+int64(n) >= command.File.Size
+		chunk := &protobuf.FileChunk{
+			CommandId: command.CommandID,
+			Offset:    offset,
+			Data:      append([]byte(nil), buffer[:n]...),
+			Last:      last,
+		}
+		if err := sendChunk(chunk); err != nil {
+			return fmt.Errorf("failed to send file chunk at offset %d to agent %s: %w", offset, command.HostID, err)
+		}
+		offset += int64(n)
+		if last {
+			break
+		}
+	}
+
+	log.Printf("File %s (%d bytes) of command %s sent to agent %s", command.File.SHA256, offset, command.CommandID, command.HostID)
+	return nil
+}
+
+// SendControlToAgent 发送命令控制请求到指定Agent
+func (tc *GRPCTaskController) SendControlToAgent(hostID string, control *models.CommandControl) error {
+	conn, exists := tc.connectionPool.GetConnection(hostID)
+	if !exists {
+		return fmt.Errorf("agent %s not connected or inactive", hostID)
+	}
+
+	controlMsg := &protobuf.CommandMessage{
+		Payload: &protobuf.CommandMessage_Control{Control: control.ToProtobuf()},
+	}
+
+	if err := conn.Sender.Send(controlMsg); err != nil {
+		log.Printf("Failed to send control to agent %s: %v", hostID, err)
+		return err
+	}
+
+	LogGRPCRequest("SendControl", control.ControlID)
+	log.Printf("Control %s (%s) of command %s sent to agent %s", control.ControlID, control.Action, control.CommandID, hostID)
 	return nil
 }
 
@@ -342,6 +820,20 @@ func (tc *GRPCTaskController) handleCommandResult(agentID string, result *protob
 		return
 	}
 
+	// 收集命令的最终结果在其文件分片全部写入后处理
+	if result.FinishedAt != nil && tc.finishFetchWriter(result.CommandId, func(overflowed bool) {
+		if overflowed {
+			markFetchOverflow(result)
+		}
+		tc.processCommandResult(agentID, result)
+	}) {
+		return
+	}
+	tc.processCommandResult(agentID, result)
+}
+
+// processCommandResult 交给任务服务处理命令结果并确认最终结果
+func (tc *GRPCTaskController) processCommandResult(agentID string, result *protobuf.CommandResult) {
 	// 将 protobuf 结果转换为模型
 	commandResult := models.CreateCommandResultFromProtobuf(result)
 
@@ -368,12 +860,109 @@ func (tc *GRPCTaskController) handleCommandResult(agentID string, result *protob
 			log.Printf("Successfully processed command result for command %s from agent %s",
 				result.CommandId, agentID)
 		}
+
+		// 确认最终结果，Agent 收到成功确认后从发件箱删除；命令不存在时同样确认，避免 Agent 反复重发
+		if result.FinishedAt != nil {
+			if err == nil || errors.Is(err, service.ErrCommandNotFound) {
+				tc.sendAck(agentID, result.CommandId, true, "")
+			} else {
+				tc.sendAck(agentID, result.CommandId, false, err.Error())
+			}
+		}
 	} else {
 		log.Printf("Warning: TaskService not set, command result not processed for command %s from agent %s",
 			result.CommandId, agentID)
 	}
 }
 
+// handleReconcile 处理 Agent 重连后上报的对账信息
+func (tc *GRPCTaskController) handleReconcile(agentID string, report *protobuf.ReconcileReport) {
+	LogGRPCRequest("Reconcile", agentID)
+
+	conn, exists := tc.connectionPool.GetConnection(agentID)
+	if !exists || tc.taskService == nil {
+		return
+	}
+
+	deliveryAck := conn.HasCapability(capabilityDeliveryAck)
+	err := tc.taskService.ReconcileAgent(agentID, report.RunningCommandIds, report.FinishedCommandIds, conn.ConnectedAt, deliveryAck)
+	if err != nil {
+		log.Printf("Failed to reconcile agent %s: %v", agentID, err)
+		LogGRPCResponse("Reconcile", false, err.Error())
+		return
+	}
+	LogGRPCResponse("Reconcile", true, agentID)
+}
+
+// handleDeliveryAck 处理 Agent 的命令投递确认
+func (tc *GRPCTaskController) handleDeliveryAck(agentID string, ack *protobuf.Ack) {
+	if ack.RefId == "" || tc.taskService == nil {
+		return
+	}
+
+	err := tc.taskService.HandleCommandDelivered(agentID, ack.RefId, ack.Success, ack.Duplicate, ack.Message)
+	if err != nil && !errors.Is(err, service.ErrCommandNotFound) {
+		log.Printf("Failed to handle delivery ack of command %s from agent %s: %v", ack.RefId, agentID, err)
+	}
+}
+
+// handleCommandOutput 处理Agent在命令执行过程中发送的输出分片
+func (tc *GRPCTaskController) handleCommandOutput(agentID string, chunk *protobuf.CommandOutputChunk) {
+	if chunk.CommandId == "" || chunk.Sequence == 0 {
+		log.Printf("Warning: Received invalid output chunk from agent %s", agentID)
+		return
+	}
+
+	if tc.taskService == nil {
+		return
+	}
+
+	if err := tc.taskService.HandleCommandOutput(models.CreateCommandOutputChunkFromProtobuf(chunk)); err != nil {
+		log.Printf("Failed to handle output chunk %d of command %s from agent %s: %v",
+			chunk.Sequence, chunk.CommandId, agentID, err)
+	}
+}
+
+// handleFetchChunk 处理Agent回传的收集文件分片，分片交给该命令的写入协程，不阻塞命令流的接收
+func (tc *GRPCTaskController) handleFetchChunk(agentID string, chunk *protobuf.FetchChunk) {
+	if chunk.CommandId == "" || chunk.Path == "" {
+		log.Printf("Warning: Received invalid fetch chunk from agent %s", agentID)
+		return
+	}
+
+	if tc.taskService == nil {
+		return
+	}
+
+	tc.enqueueFetchChunk(agentID, chunk)
+}
+
+// writeFetchChunk 在写入协程中保存收集文件分片，throttle 为 true 时先等待 Server 总带宽
+func (tc *GRPCTaskController) writeFetchChunk(agentID string, chunk *protobuf.FetchChunk, throttle bool) {
+	// 收集的文件同样计入 Server 总带宽；连接已断开时分片已经收到，不再等待直接写入
+	if conn, exists := tc.connectionPool.GetConnection(agentID); exists && throttle {
+		service.WaitTransfer(conn.Context, nil, len(chunk.Data))
+	}
+
+	if err := tc.taskService.HandleFetchChunk(agentID, chunk); err != nil {
+		log.Printf("Failed to handle fetch chunk of %s at offset %d for command %s from agent %s: %v",
+			chunk.Path, chunk.Offset, chunk.CommandId, agentID, err)
+	}
+}
+
+// handleControlAck 处理Agent返回的命令控制确认
+func (tc *GRPCTaskController) handleControlAck(agentID string, ack *protobuf.ControlAck) {
+	LogGRPCResponse("ControlAck", ack.Success, ack.ControlId)
+
+	if tc.taskService == nil {
+		return
+	}
+
+	if err := tc.taskService.HandleControlAck(models.CreateControlAckFromProtobuf(ack)); err != nil {
+		log.Printf("Failed to handle control ack %s from agent %s: %v", ack.ControlId, agentID, err)
+	}
+}
+
 // GetConnectedAgents 获取所有已连接的Agent列表
 func (tc *GRPCTaskController) GetConnectedAgents() []string {
 	activeConns := tc.connectionPool.GetActiveConnections()
@@ -397,11 +986,14 @@ func (tc *GRPCTaskController) GetConnectionInfo(agentID string) map[string]inter
 	}
 
 	return map[string]interface{}{
-		"connected":    true,
-		"connected_at": conn.ConnectedAt,
-		"last_ping":    conn.LastPing,
-		"is_active":    conn.IsActive,
-		"uptime":       time.Since(conn.ConnectedAt).Seconds(),
+		"connected":     true,
+		"agent_version": conn.AgentVersion,
+		"capabilities":  conn.Capabilities,
+		"connected_at":  conn.ConnectedAt,
+		"last_ping":     conn.LastPing,
+		"is_active":     conn.IsActive,
+		"uptime":        time.Since(conn.ConnectedAt).Seconds(),
+		"send_queue":    sendQueueStats(conn.Sender.Stats()),
 	}
 }
 
@@ -422,12 +1014,29 @@ func (tc *GRPCTaskController) GetConnectionStatistics() map[string]interface{} {
 			"connected_at": conn.ConnectedAt,
 			"last_ping":    conn.LastPing,
 			"uptime":       time.Since(conn.ConnectedAt).Seconds(),
+			"send_queue":   sendQueueStats(conn.Sender.Stats()),
 		})
 	}
 
 	return stats
 }
 
+// sendQueueStats 将发送队列统计转换为展示格式
+func sendQueueStats(stats sendqueue.Stats) map[string]interface{} {
+	return map[string]interface{}{
+		"queued":          stats.Queued,
+		"capacity":        stats.Capacity,
+		"sent":            stats.Sent,
+		"dropped":         stats.Dropped,
+		"failed":          stats.Failed,
+		"last_latency_ms": stats.LastLatency.Milliseconds(),
+		"max_latency_ms":  stats.MaxLatency.Milliseconds(),
+		"last_sent_at":    stats.LastSentAt,
+		"last_error":      stats.LastError,
+		"enqueue_waiting": stats.EnqueueWaiting,
+	}
+}
+
 // SendHeartbeatToAgent 向Agent发送心跳检测
 func (tc *GRPCTaskController) SendHeartbeatToAgent(agentID string) error {
 	conn, exists := tc.connectionPool.GetConnection(agentID)
@@ -435,18 +1044,16 @@ func (tc *GRPCTaskController) SendHeartbeatToAgent(agentID string) error {
 		return fmt.Errorf("agent %s not connected", agentID)
 	}
 
-	// 构建心跳消息（使用空的CommandContent作为心跳）
+	// 构建心跳消息
 	heartbeatMsg := &protobuf.CommandMessage{
-		CommandContent: &protobuf.CommandContent{
-			CommandId: "heartbeat-" + time.Now().Format("20060102150405"),
+		Payload: &protobuf.CommandMessage_Heartbeat{Heartbeat: &protobuf.Heartbeat{
 			HostId:    agentID,
-			Command:   "ping",
-		},
+			Timestamp: timestamppb.Now(),
+		}},
 	}
 
-	if err := conn.Stream.Send(heartbeatMsg); err != nil {
+	if err := conn.Sender.Send(heartbeatMsg); err != nil {
 		log.Printf("Failed to send heartbeat to agent %s: %v", agentID, err)
-		tc.connectionPool.RemoveConnection(agentID)
 		return err
 	}
 
@@ -460,9 +1067,8 @@ func (tc *GRPCTaskController) BroadcastToAllAgents(message *protobuf.CommandMess
 	results := make(map[string]error)
 
 	for agentID, conn := range activeConns {
-		if err := conn.Stream.Send(message); err != nil {
+		if err := conn.Sender.Send(message); err != nil {
 			log.Printf("Failed to broadcast message to agent %s: %v", agentID, err)
-			tc.connectionPool.RemoveConnection(agentID)
 			results[agentID] = err
 		} else {
 			results[agentID] = nil
@@ -496,6 +1102,38 @@ func (tc *GRPCTaskController) DisconnectAgent(agentID string) error {
 	return nil
 }
 
+// DisconnectRevokedAgent 主机证书吊销生效后断开其命令流，命令流使用的证书仍有效（已轮换到新证书）时保留
+func (tc *GRPCTaskController) DisconnectRevokedAgent(agentID string) bool {
+	conn, exists := tc.connectionPool.GetConnection(agentID)
+	if !exists {
+		return false
+	}
+	if ca := service.GetCertificateAuthority(); conn.CertSerial != nil && ca != nil && !ca.IsRevoked(conn.CertSerial) {
+		return false
+	}
+	return tc.DisconnectAgent(agentID) == nil
+}
+
+// BroadcastRevocations 向所有支持对等分发的 Agent 发送已吊销的证书列表，吊销生效时调用
+func (tc *GRPCTaskController) BroadcastRevocations() {
+	for agentID, conn := range tc.connectionPool.GetActiveConnections() {
+		tc.sendRevocations(agentID, conn)
+	}
+}
+
+// sendRevocations 向支持对等分发的 Agent 发送完整的已吊销证书列表，对等分发双方据此拒绝已吊销的证书
+func (tc *GRPCTaskController) sendRevocations(agentID string, conn *AgentConnection) {
+	ca := service.GetCertificateAuthority()
+	if ca == nil || !conn.HasCapability(capabilityFilePeer) {
+		return
+	}
+	if err := conn.Sender.Send(&protobuf.CommandMessage{
+		Payload: &protobuf.CommandMessage_Revocations{Revocations: &protobuf.RevocationList{Serials: ca.RevokedSerials()}},
+	}); err != nil {
+		log.Printf("Failed to send certificate revocations to agent %s: %v", agentID, err)
+	}
+}
+
 // Shutdown 关闭控制器，清理所有连接
 func (tc *GRPCTaskController) Shutdown() {
 	log.Println("Shutting down gRPC task controller...")
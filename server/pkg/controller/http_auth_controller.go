@@ -0,0 +1,345 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	apimodels "devops-manager/api/models"
+	"devops-manager/server/pkg/models"
+	"devops-manager/server/pkg/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HTTPAuthController 用户和 API 令牌 HTTP 控制器
+type HTTPAuthController struct {
+	authService *service.AuthService
+}
+
+// NewHTTPAuthController 创建新的认证 HTTP 控制器
+func NewHTTPAuthController() *HTTPAuthController {
+	return &HTTPAuthController{
+		authService: service.GetAuthService(),
+	}
+}
+
+// RegisterAuthHTTPRoutes 注册认证和用户管理相关 HTTP 路由
+func RegisterAuthHTTPRoutes(r *gin.Engine) {
+	controller := NewHTTPAuthController()
+
+	api := r.Group("/api/v1", AuthMiddleware())
+	{
+		// 当前用户
+		api.GET("/auth/me", controller.GetCurrentUser)
+		api.GET("/auth/tokens", controller.GetMyTokens)
+		api.POST("/auth/tokens", controller.CreateMyToken)
+		api.DELETE("/auth/tokens/:tokenId", controller.RevokeMyToken)
+
+		// 用户管理
+		admin := api.Group("", RequireRole(apimodels.UserRoleAdmin))
+		admin.GET("/users", controller.GetUsers)
+		admin.POST("/users", controller.CreateUser)
+		admin.PUT("/users/:id", controller.UpdateUser)
+		admin.DELETE("/users/:id", controller.DeleteUser)
+		admin.GET("/users/:id/tokens", controller.GetUserTokens)
+		admin.DELETE("/users/:id/tokens/:tokenId", controller.RevokeUserToken)
+	}
+}
+
+// GetCurrentUser 获取当前用户
+// @Summary      获取当前用户
+// @Description  获取当前认证调用方的用户名和角色
+// @Tags         认证
+// @Produce      json
+// @Success      200  {object}  models.APIResponse
+// @Failure      401  {object}  models.APIResponse
+// @Router       /auth/me [get]
+func (ac *HTTPAuthController) GetCurrentUser(c *gin.Context) {
+	SendSuccessResponse(c, CurrentPrincipal(c))
+}
+
+// GetMyTokens 获取当前用户的 API 令牌
+// @Summary      获取当前用户的 API 令牌
+// @Description  获取当前用户的 API 令牌列表（不含明文）
+// @Tags         认证
+// @Produce      json
+// @Success      200  {object}  models.APIResponse
+// @Failure      401  {object}  models.APIResponse
+// @Failure      500  {object}  models.APIResponse
+// @Router       /auth/tokens [get]
+func (ac *HTTPAuthController) GetMyTokens(c *gin.Context) {
+	principal := CurrentPrincipal(c)
+	if !ac.requireUser(c, principal) {
+		return
+	}
+	ac.listTokens(c, principal.UserID)
+}
+
+// CreateMyToken 为当前用户创建 API 令牌
+// @Summary      创建 API 令牌
+// @Description  为当前用户创建 API 令牌，明文令牌只在响应中返回一次
+// @Tags         认证
+// @Accept       json
+// @Produce      json
+// @Param        token  body      models.CreateTokenRequest  true  "令牌信息"
+// @Success      200    {object}  models.APIResponse{data=models.CreateTokenResponse}
+// @Failure      400    {object}  models.APIResponse
+// @Failure      401    {object}  models.APIResponse
+// @Failure      500    {object}  models.APIResponse
+// @Router       /auth/tokens [post]
+func (ac *HTTPAuthController) CreateMyToken(c *gin.Context) {
+	principal := CurrentPrincipal(c)
+	if !ac.requireUser(c, principal) {
+		return
+	}
+
+	var req models.CreateTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if req.ExpiresInDays < 0 {
+		SendErrorResponse(c, http.StatusBadRequest, "expires_in_days must not be negative")
+		return
+	}
+
+	token, record, err := ac.authService.CreateToken(principal.UserID, req.Name, time.Duration(req.ExpiresInDays)*24*time.Hour)
+	if err != nil {
+		SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := models.CreateTokenResponse{
+		Token:  token,
+		ID:     record.ID,
+		Name:   record.Name,
+		Prefix: record.Prefix,
+	}
+	if record.ExpiresAt != nil {
+		response.ExpiresAt = record.ExpiresAt.Format(time.RFC3339)
+	}
+	SendSuccessResponse(c, response)
+}
+
+// RevokeMyToken 吊销当前用户的 API 令牌
+// @Summary      吊销 API 令牌
+// @Description  吊销当前用户的 API 令牌
+// @Tags         认证
+// @Produce      json
+// @Param        tokenId  path      int  true  "令牌ID"
+// @Success      200      {object}  models.APIResponse
+// @Failure      400      {object}  models.APIResponse
+// @Failure      404      {object}  models.APIResponse
+// @Router       /auth/tokens/{tokenId} [delete]
+func (ac *HTTPAuthController) RevokeMyToken(c *gin.Context) {
+	principal := CurrentPrincipal(c)
+	if !ac.requireUser(c, principal) {
+		return
+	}
+	ac.revokeToken(c, principal.UserID)
+}
+
+// GetUsers 获取用户列表
+// @Summary      获取用户列表
+// @Description  获取全部用户（需要 admin 角色）
+// @Tags         用户管理
+// @Produce      json
+// @Success      200  {object}  models.APIResponse
+// @Failure      403  {object}  models.APIResponse
+// @Failure      500  {object}  models.APIResponse
+// @Router       /users [get]
+func (ac *HTTPAuthController) GetUsers(c *gin.Context) {
+	users, err := ac.authService.ListUsers()
+	if err != nil {
+		SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	SendSuccessResponse(c, users)
+}
+
+// CreateUser 创建用户
+// @Summary      创建用户
+// @Description  创建用户并分配角色 viewer/operator/admin 及可操作主机的标签范围（需要 admin 角色）
+// @Tags         用户管理
+// @Accept       json
+// @Produce      json
+// @Param        user  body      models.CreateUserRequest  true  "用户信息"
+// @Success      200   {object}  models.APIResponse
+// @Failure      400   {object}  models.APIResponse
+// @Failure      409   {object}  models.APIResponse
+// @Failure      500   {object}  models.APIResponse
+// @Router       /users [post]
+func (ac *HTTPAuthController) CreateUser(c *gin.Context) {
+	var req models.CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	user, err := ac.authService.CreateUser(req.Username, req.Password, apimodels.UserRole(req.Role), req.HostScope)
+	if err != nil {
+		sendAuthError(c, err)
+		return
+	}
+
+	SendSuccessResponse(c, user)
+}
+
+// UpdateUser 更新用户
+// @Summary      更新用户
+// @Description  修改用户角色、启用状态、密码或主机范围（需要 admin 角色）
+// @Tags         用户管理
+// @Accept       json
+// @Produce      json
+// @Param        id    path      int                       true  "用户ID"
+// @Param        user  body      models.UpdateUserRequest  true  "更新内容"
+// @Success      200   {object}  models.APIResponse
+// @Failure      400   {object}  models.APIResponse
+// @Failure      404   {object}  models.APIResponse
+// @Router       /users/{id} [put]
+func (ac *HTTPAuthController) UpdateUser(c *gin.Context) {
+	userID, ok := parseUintParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var req models.UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	user, err := ac.authService.UpdateUser(userID, apimodels.UserRole(req.Role), req.Enabled, req.Password, req.HostScope)
+	if err != nil {
+		sendAuthError(c, err)
+		return
+	}
+
+	SendSuccessResponse(c, user)
+}
+
+// DeleteUser 删除用户
+// @Summary      删除用户
+// @Description  删除用户并吊销其全部 API 令牌（需要 admin 角色）
+// @Tags         用户管理
+// @Produce      json
+// @Param        id   path      int  true  "用户ID"
+// @Success      200  {object}  models.APIResponse
+// @Failure      400  {object}  models.APIResponse
+// @Failure      404  {object}  models.APIResponse
+// @Router       /users/{id} [delete]
+func (ac *HTTPAuthController) DeleteUser(c *gin.Context) {
+	userID, ok := parseUintParam(c, "id")
+	if !ok {
+		return
+	}
+
+	if principal := CurrentPrincipal(c); principal != nil && principal.UserID == userID {
+		SendErrorResponse(c, http.StatusBadRequest, "Cannot delete the current user")
+		return
+	}
+
+	if err := ac.authService.DeleteUser(userID); err != nil {
+		sendAuthError(c, err)
+		return
+	}
+
+	SendMessageResponse(c, "User deleted successfully")
+}
+
+// GetUserTokens 获取指定用户的 API 令牌
+// @Summary      获取用户的 API 令牌
+// @Description  获取指定用户的 API 令牌列表（需要 admin 角色）
+// @Tags         用户管理
+// @Produce      json
+// @Param        id   path      int  true  "用户ID"
+// @Success      200  {object}  models.APIResponse
+// @Failure      400  {object}  models.APIResponse
+// @Router       /users/{id}/tokens [get]
+func (ac *HTTPAuthController) GetUserTokens(c *gin.Context) {
+	userID, ok := parseUintParam(c, "id")
+	if !ok {
+		return
+	}
+	ac.listTokens(c, userID)
+}
+
+// RevokeUserToken 吊销指定用户的 API 令牌
+// @Summary      吊销用户的 API 令牌
+// @Description  吊销指定用户的 API 令牌（需要 admin 角色）
+// @Tags         用户管理
+// @Produce      json
+// @Param        id       path      int  true  "用户ID"
+// @Param        tokenId  path      int  true  "令牌ID"
+// @Success      200      {object}  models.APIResponse
+// @Failure      400      {object}  models.APIResponse
+// @Failure      404      {object}  models.APIResponse
+// @Router       /users/{id}/tokens/{tokenId} [delete]
+func (ac *HTTPAuthController) RevokeUserToken(c *gin.Context) {
+	userID, ok := parseUintParam(c, "id")
+	if !ok {
+		return
+	}
+	ac.revokeToken(c, userID)
+}
+
+// requireUser 未启用认证时没有真实用户，无法管理令牌
+func (ac *HTTPAuthController) requireUser(c *gin.Context, principal *service.Principal) bool {
+	if principal == nil || principal.UserID == 0 {
+		SendErrorResponse(c, http.StatusBadRequest, "API tokens require authentication to be enabled")
+		return false
+	}
+	return true
+}
+
+// listTokens 返回用户的 API 令牌列表
+func (ac *HTTPAuthController) listTokens(c *gin.Context, userID uint) {
+	tokens, err := ac.authService.ListTokens(userID)
+	if err != nil {
+		SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	SendSuccessResponse(c, tokens)
+}
+
+// revokeToken 吊销路径参数 tokenId 指定的令牌
+func (ac *HTTPAuthController) revokeToken(c *gin.Context, userID uint) {
+	tokenID, ok := parseUintParam(c, "tokenId")
+	if !ok {
+		return
+	}
+
+	if err := ac.authService.RevokeToken(userID, tokenID); err != nil {
+		sendAuthError(c, err)
+		return
+	}
+
+	SendMessageResponse(c, "Token revoked successfully")
+}
+
+// parseUintParam 解析正整数路径参数
+func parseUintParam(c *gin.Context, name string) (uint, bool) {
+	value, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil || value == 0 {
+		SendErrorResponse(c, http.StatusBadRequest, "Invalid "+name)
+		return 0, false
+	}
+	return uint(value), true
+}
+
+// sendAuthError 将认证服务错误映射为 HTTP 状态码
+func sendAuthError(c *gin.Context, err error) {
+	switch err {
+	case service.ErrUserNotFound, service.ErrTokenNotFound:
+		SendErrorResponse(c, http.StatusNotFound, err.Error())
+	case service.ErrUserExists:
+		SendErrorResponse(c, http.StatusConflict, err.Error())
+	case service.ErrInvalidRole, service.ErrInvalidHostScope:
+		SendErrorResponse(c, http.StatusBadRequest, err.Error())
+	default:
+		SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+	}
+}
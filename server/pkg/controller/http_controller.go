@@ -23,19 +23,28 @@ func NewHTTPController() *HTTPController {
 
 // RegisterHTTPRoutes 注册所有 HTTP API 路由
 func RegisterHTTPRoutes(r *gin.Engine) {
+	// 注册认证和用户管理路由
+	RegisterAuthHTTPRoutes(r)
+
 	// 注册主机相关路由
 	RegisterHostHTTPRoutes(r)
 
 	// 注册任务相关路由
 	RegisterTaskHTTPRoutes(r)
 
+	// 注册文件分发相关路由
+	RegisterFileHTTPRoutes(r)
+
+	// 注册 Agent 升级相关路由
+	RegisterUpgradeHTTPRoutes(r)
+
 	// 注册命令相关路由
 	RegisterCommandHTTPRoutes(r)
 }
 
 // RegisterCommandHTTPRoutes 注册命令相关路由
 func RegisterCommandHTTPRoutes(r *gin.Engine) {
-	api := r.Group("/api/v1")
+	api := r.Group("/api/v1", AuthMiddleware())
 	{
 		// 命令管理
 		api.POST("/commands", nil)             // 创建命令
@@ -92,7 +101,7 @@ func (hc *HTTPController) RegisterHost(c *gin.Context) {
 	}
 
 	// 注册主机
-	err := hc.hostService.RegisterHost(&hostInfo)
+	_, err := hc.hostService.RegisterHost(c.Request.Context(), &hostInfo)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success":       false,
@@ -287,7 +296,7 @@ func (hc *HTTPController) ReportHostStatus(c *gin.Context) {
 	status.HostId = hostID
 
 	// 处理状态上报
-	err := hc.hostService.ReportHostStatus(&status)
+	err := hc.hostService.ReportHostStatus(c.Request.Context(), &status)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success":       false,
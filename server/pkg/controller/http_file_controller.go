@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+
+	apimodels "devops-manager/api/models"
+	"devops-manager/server/pkg/models"
+	"devops-manager/server/pkg/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HTTPFileController 文件分发 HTTP 控制器
+type HTTPFileController struct{}
+
+// NewHTTPFileController 创建新的文件分发 HTTP 控制器
+func NewHTTPFileController() *HTTPFileController {
+	return &HTTPFileController{}
+}
+
+// RegisterFileHTTPRoutes 注册文件分发相关 HTTP 路由
+func RegisterFileHTTPRoutes(r *gin.Engine) {
+	controller := NewHTTPFileController()
+
+	api := r.Group("/api/v1", AuthMiddleware())
+	{
+		operator := RequireRole(apimodels.UserRoleOperator)
+
+		api.POST("/files", operator, controller.UploadFile)
+	}
+}
+
+// UploadFile 上传待分发的文件
+// @Summary      上传文件
+// @Description  上传待分发到主机的文件，返回文件的 SHA-256，创建文件分发任务时引用该摘要。相同内容只保存一份
+// @Tags         文件分发
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        file  formData  file  true  "文件内容"
+// @Success      200   {object}  models.APIResponse{data=models.FileUploadResponse}
+// @Failure      400   {object}  models.APIResponse
+// @Failure      413   {object}  models.APIResponse
+// @Failure      500   {object}  models.APIResponse
+// @Router       /files [post]
+func (fc *HTTPFileController) UploadFile(c *gin.Context) {
+	LogGRPCRequest("UploadFile", c.Request.Method+" "+c.Request.URL.Path)
+
+	store := service.GetFileStore()
+	if store == nil {
+		LogGRPCResponse("UploadFile", false, "File store is not configured")
+		SendErrorResponse(c, http.StatusInternalServerError, "File store is not configured")
+		return
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		LogGRPCResponse("UploadFile", false, "Invalid upload: "+err.Error())
+		SendErrorResponse(c, http.StatusBadRequest, "Invalid upload: "+err.Error())
+		return
+	}
+	maxBytes := service.GetMaxFileBytes()
+	if header.Size > maxBytes {
+		LogGRPCResponse("UploadFile", false, "File too large: "+header.Filename)
+		SendErrorResponse(c, http.StatusRequestEntityTooLarge, "File too large: "+header.Filename)
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		LogGRPCResponse("UploadFile", false, "Failed to read upload: "+err.Error())
+		SendErrorResponse(c, http.StatusBadRequest, "Failed to read upload: "+err.Error())
+		return
+	}
+	defer file.Close()
+
+	stored, err := store.Put(file, maxBytes)
+	if err != nil {
+		if errors.Is(err, service.ErrFileTooLarge) {
+			LogGRPCResponse("UploadFile", false, err.Error())
+			SendErrorResponse(c, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		LogGRPCResponse("UploadFile", false, "Failed to store file: "+err.Error())
+		SendErrorResponse(c, http.StatusInternalServerError, "Failed to store file: "+err.Error())
+		return
+	}
+
+	LogGRPCResponse("UploadFile", true, "File uploaded: "+stored.SHA256)
+	SendSuccessResponse(c, models.FileUploadResponse{
+		SHA256: stored.SHA256,
+		Size:   stored.Size,
+		Name:   filepath.Base(header.Filename),
+	})
+}
@@ -3,6 +3,7 @@ package controller
 import (
 	"net/http"
 
+	apimodels "devops-manager/api/models"
 	"devops-manager/api/protobuf"
 	"devops-manager/server/pkg/service"
 
@@ -25,24 +26,51 @@ func NewHTTPHostController() *HTTPHostController {
 func RegisterHostHTTPRoutes(r *gin.Engine) {
 	controller := NewHTTPHostController()
 
-	api := r.Group("/api/v1")
+	api := r.Group("/api/v1", AuthMiddleware())
 	{
+		operator := RequireRole(apimodels.UserRoleOperator)
+		admin := RequireRole(apimodels.UserRoleAdmin)
+		scoped := controller.requireHostInScope()
+
 		// 主机管理
-		api.POST("/hosts/register", controller.RegisterHost)
+		api.POST("/hosts/register", operator, controller.RegisterHost)
 		api.GET("/hosts", controller.GetHosts)
-		api.GET("/hosts/:id", controller.GetHost)
-		api.PUT("/hosts/:id", controller.UpdateHost)
-		api.DELETE("/hosts/:id", controller.DeleteHost)
+		api.GET("/hosts/:id", scoped, controller.GetHost)
+		api.PUT("/hosts/:id", admin, controller.UpdateHost) // 主机标签决定用户的主机范围，只有管理员可以修改
+		api.DELETE("/hosts/:id", admin, controller.DeleteHost)
 
 		// 主机状态
-		api.POST("/hosts/:id/status", controller.ReportHostStatus)
-		api.GET("/hosts/:id/status", controller.GetHostStatus)
+		api.POST("/hosts/:id/status", operator, scoped, controller.ReportHostStatus)
+		api.GET("/hosts/:id/status", scoped, controller.GetHostStatus)
 
 		// 准入管理
 		api.GET("/pending-hosts", controller.GetPendingHosts)
 		api.GET("/pending-hosts/count", controller.GetPendingHostsCount)
-		api.POST("/pending-hosts/:id/approve", controller.ApproveHost)
-		api.POST("/pending-hosts/:id/reject", controller.RejectHost)
+		api.POST("/pending-hosts/:id/approve", admin, controller.ApproveHost)
+		api.POST("/pending-hosts/:id/reject", admin, controller.RejectHost)
+
+		// 主机证书
+		api.GET("/hosts/:id/certificates", scoped, controller.GetHostCertificates)
+		api.POST("/hosts/:id/certificates/revoke", admin, controller.RevokeHostCertificates)
+	}
+}
+
+// requireHostInScope 主机范围校验中间件，路径中的主机不在调用方范围内时按不存在处理
+func (hc *HTTPHostController) requireHostInScope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope := currentHostScope(c)
+		if !scope.IsRestricted() {
+			c.Next()
+			return
+		}
+
+		host, exists := hc.hostService.GetHost(c.Param("id"))
+		if !exists || !scope.Matches(host.Tags) {
+			SendErrorResponse(c, http.StatusNotFound, "Host not found")
+			c.Abort()
+			return
+		}
+		c.Next()
 	}
 }
 
@@ -65,7 +93,7 @@ func (hc *HTTPHostController) RegisterHost(c *gin.Context) {
 	}
 
 	// 注册主机
-	err := hc.hostService.RegisterHost(&hostInfo)
+	_, err := hc.hostService.RegisterHost(c.Request.Context(), &hostInfo)
 	if err != nil {
 		SendErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
@@ -76,7 +104,7 @@ func (hc *HTTPHostController) RegisterHost(c *gin.Context) {
 
 // GetHosts 获取所有主机
 // @Summary      获取主机列表
-// @Description  获取系统中所有已准入的主机信息
+// @Description  获取系统中所有已准入的主机信息，只返回调用方主机范围内的主机
 // @Tags         主机管理
 // @Accept       json
 // @Produce      json
@@ -85,6 +113,18 @@ func (hc *HTTPHostController) RegisterHost(c *gin.Context) {
 // @Router       /hosts [get]
 func (hc *HTTPHostController) GetHosts(c *gin.Context) {
 	hosts := hc.hostService.GetAllHosts()
+
+	scope := currentHostScope(c)
+	if scope.IsRestricted() {
+		filtered := make([]*protobuf.HostInfo, 0, len(hosts))
+		for _, host := range hosts {
+			if scope.Matches(host.Tags) {
+				filtered = append(filtered, host)
+			}
+		}
+		hosts = filtered
+	}
+
 	SendSuccessResponse(c, hosts)
 }
 
@@ -231,7 +271,7 @@ func (hc *HTTPHostController) ReportHostStatus(c *gin.Context) {
 	status.HostId = hostID
 
 	// 处理状态上报
-	err := hc.hostService.ReportHostStatus(&status)
+	err := hc.hostService.ReportHostStatus(c.Request.Context(), &status)
 	if err != nil {
 		SendErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
@@ -252,3 +292,57 @@ func (hc *HTTPHostController) GetHostStatus(c *gin.Context) {
 
 	SendSuccessResponse(c, status)
 }
+
+// GetHostCertificates 获取主机证书列表
+// @Summary      获取主机证书列表
+// @Description  获取内置 CA 为主机签发的全部证书（含已取代和已吊销）
+// @Tags         主机管理
+// @Accept       json
+// @Produce      json
+// @Param        id   path      string  true  "主机ID"
+// @Success      200  {object}  models.APIResponse
+// @Failure      400  {object}  models.APIResponse
+// @Failure      500  {object}  models.APIResponse
+// @Router       /hosts/{id}/certificates [get]
+func (hc *HTTPHostController) GetHostCertificates(c *gin.Context) {
+	hostID := c.Param("id")
+
+	certificates, err := hc.hostService.GetHostCertificates(hostID)
+	if err != nil {
+		if err == service.ErrCANotEnabled {
+			SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		} else {
+			SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	SendSuccessResponse(c, certificates)
+}
+
+// RevokeHostCertificates 吊销主机证书
+// @Summary      吊销主机证书
+// @Description  吊销主机的全部证书并断开其命令流，主机需删除后重新准入才能再次入网
+// @Tags         主机管理
+// @Accept       json
+// @Produce      json
+// @Param        id   path      string  true  "主机ID"
+// @Success      200  {object}  models.APIResponse
+// @Failure      400  {object}  models.APIResponse
+// @Failure      500  {object}  models.APIResponse
+// @Router       /hosts/{id}/certificates/revoke [post]
+func (hc *HTTPHostController) RevokeHostCertificates(c *gin.Context) {
+	hostID := c.Param("id")
+
+	revoked, err := hc.hostService.RevokeHostCertificates(hostID)
+	if err != nil {
+		if err == service.ErrCANotEnabled {
+			SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		} else {
+			SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	SendSuccessResponse(c, gin.H{"revoked": revoked})
+}
@@ -1,10 +1,14 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"path/filepath"
 	"strconv"
 	"time"
 
+	apimodels "devops-manager/api/models"
 	"devops-manager/server/pkg/models"
 	"devops-manager/server/pkg/service"
 
@@ -27,21 +31,26 @@ func NewHTTPTaskController() *HTTPTaskController {
 func RegisterTaskHTTPRoutes(r *gin.Engine) {
 	controller := NewHTTPTaskController()
 
-	api := r.Group("/api/v1")
+	api := r.Group("/api/v1", AuthMiddleware())
 	{
+		operator := RequireRole(apimodels.UserRoleOperator)
+		admin := RequireRole(apimodels.UserRoleAdmin)
+		scoped := controller.requireTaskInScope()
+		unrestricted := requireUnrestrictedScope()
+
 		// 任务管理
-		api.POST("/tasks", controller.CreateTask)
+		api.POST("/tasks", operator, controller.CreateTask)
 		api.GET("/tasks", controller.GetTasks)
-		api.GET("/tasks/:id", controller.GetTask)
+		api.GET("/tasks/:id", scoped, controller.GetTask)
 
 		// 任务状态监控
-		api.GET("/tasks/:id/status", controller.GetTaskStatus)
-		api.GET("/tasks/:id/progress", controller.GetTaskProgress)
+		api.GET("/tasks/:id/status", scoped, controller.GetTaskStatus)
+		api.GET("/tasks/:id/progress", scoped, controller.GetTaskProgress)
 
 		// 任务控制
-		api.POST("/tasks/:id/start", controller.StartTask)
-		api.POST("/tasks/:id/stop", controller.StopTask)
-		api.POST("/tasks/:id/cancel", controller.CancelTask)
+		api.POST("/tasks/:id/start", operator, controller.StartTask)
+		api.POST("/tasks/:id/stop", operator, controller.StopTask)
+		api.POST("/tasks/:id/cancel", operator, controller.CancelTask)
 
 		// 任务统计和报告
 		api.GET("/tasks/statistics", controller.GetTaskStatistics)
@@ -53,37 +62,68 @@ func RegisterTaskHTTPRoutes(r *gin.Engine) {
 		api.GET("/tasks/by-date", controller.GetTasksByDateRange)
 
 		// 任务主机管理
-		api.GET("/tasks/:id/hosts", controller.GetTaskHosts)
-		api.POST("/tasks/:id/hosts", controller.AddTaskHosts)
-		api.DELETE("/tasks/:id/hosts/:hostId", controller.RemoveTaskHost)
+		api.GET("/tasks/:id/hosts", scoped, controller.GetTaskHosts)
+		api.POST("/tasks/:id/hosts", operator, controller.AddTaskHosts)
+		api.DELETE("/tasks/:id/hosts/:hostId", operator, controller.RemoveTaskHost)
+		api.POST("/tasks/:id/hosts/:hostId/control", operator, controller.ControlTaskHost)
+		api.GET("/tasks/:id/hosts/:hostId/output", scoped, controller.GetTaskHostOutput)
+		api.GET("/tasks/:id/hosts/:hostId/output/full", scoped, controller.DownloadTaskHostOutput)
+		api.GET("/tasks/:id/hosts/:hostId/files", operator, controller.GetTaskHostFiles)
+		api.GET("/tasks/:id/hosts/:hostId/files/download", operator, controller.DownloadTaskHostFile)
+		api.GET("/tasks/:id/files/archive", operator, controller.DownloadTaskFilesArchive)
+		api.GET("/tasks/:id/events", scoped, controller.StreamTaskEvents)
 
 		// 任务日志和详情
-		api.GET("/tasks/:id/logs", controller.GetTaskLogs)
-		api.GET("/tasks/:id/logs/detailed", controller.GetDetailedTaskLogs)
-		api.GET("/tasks/:id/audit", controller.GetTaskAuditTrail)
-		api.GET("/tasks/:id/timeline", controller.GetTaskExecutionTimeline)
-		api.GET("/tasks/:id/summary", controller.GetTaskExecutionSummary)
+		api.GET("/tasks/:id/logs", scoped, controller.GetTaskLogs)
+		api.GET("/tasks/:id/logs/detailed", scoped, controller.GetDetailedTaskLogs)
+		api.GET("/tasks/:id/audit", scoped, controller.GetTaskAuditTrail)
+		api.GET("/tasks/:id/timeline", scoped, controller.GetTaskExecutionTimeline)
+		api.GET("/tasks/:id/summary", scoped, controller.GetTaskExecutionSummary)
 
 		// 异常处理和超时管理
 		api.GET("/tasks/failed-commands", controller.GetFailedCommands)
-		api.POST("/tasks/commands/:commandId/retry", controller.RetryFailedCommand)
-		api.POST("/tasks/commands/:commandId/check-timeout", controller.CheckCommandTimeout)
+		api.POST("/tasks/commands/:commandId/retry", operator, controller.RetryFailedCommand)
+		api.POST("/tasks/commands/:commandId/check-timeout", operator, controller.CheckCommandTimeout)
 		api.GET("/tasks/timeout-statistics", controller.GetTimeoutStatistics)
 		api.GET("/tasks/error-statistics", controller.GetErrorStatistics)
 
 		// 数据库优化和维护
 		api.GET("/tasks/database-statistics", controller.GetDatabaseStatistics)
-		api.POST("/tasks/cleanup-old-records", controller.CleanupOldRecords)
-		api.POST("/tasks/cleanup-old-logs", controller.CleanupOldLogs)
-		api.POST("/tasks/optimize-tables", controller.OptimizeTables)
+		api.POST("/tasks/cleanup-old-records", admin, controller.CleanupOldRecords)
+		api.POST("/tasks/cleanup-old-logs", admin, controller.CleanupOldLogs)
+		api.POST("/tasks/optimize-tables", admin, controller.OptimizeTables)
 
 		// 日志搜索和分析
-		api.GET("/tasks/search-logs", controller.SearchLogs)
-		api.POST("/tasks/update-daily-statistics", controller.UpdateDailyStatistics)
+		api.GET("/tasks/search-logs", unrestricted, controller.SearchLogs)
+		api.POST("/tasks/update-daily-statistics", admin, controller.UpdateDailyStatistics)
 		api.GET("/tasks/table-sizes", controller.AnalyzeTableSizes)
 	}
 }
 
+// requireTaskInScope 任务范围校验中间件，任务的目标主机或路径中的主机不在调用方范围内时按不存在处理
+func (tc *HTTPTaskController) requireTaskInScope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope := currentHostScope(c)
+		if !scope.IsRestricted() {
+			c.Next()
+			return
+		}
+
+		err := tc.taskService.CheckTaskScope(c.Param("id"), c.Param("hostId"), scope)
+		if isHostScopeError(err) {
+			SendErrorResponse(c, http.StatusNotFound, "Task not found")
+			c.Abort()
+			return
+		}
+		if err != nil {
+			SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
 // CreateTask 创建任务
 // @Summary      创建新任务
 // @Description  创建一个新的执行任务
@@ -93,6 +133,7 @@ func RegisterTaskHTTPRoutes(r *gin.Engine) {
 // @Param        task  body      models.CreateTaskRequest  true  "任务信息"
 // @Success      200   {object}  models.APIResponse{data=models.TaskResponse}
 // @Failure      400   {object}  models.APIResponse
+// @Failure      403   {object}  models.APIResponse
 // @Failure      500   {object}  models.APIResponse
 // @Router       /tasks [post]
 func (tc *HTTPTaskController) CreateTask(c *gin.Context) {
@@ -118,24 +159,107 @@ func (tc *HTTPTaskController) CreateTask(c *gin.Context) {
 		return
 	}
 
-	if req.Command == "" {
+	// 普通命令、脚本、文件分发与文件收集只能指定其一，脚本任务的命令内容为脚本内容，文件任务的命令内容为任务描述
+	modes := 0
+	for _, specified := range []bool{req.Command != "", req.Script != nil, req.File != nil, req.Fetch != nil} {
+		if specified {
+			modes++
+		}
+	}
+	if modes > 1 {
+		LogGRPCResponse("CreateTask", false, "Command, script, file and fetch are mutually exclusive")
+		SendErrorResponse(c, http.StatusBadRequest, "Command, script, file and fetch are mutually exclusive")
+		return
+	}
+
+	command := req.Command
+	var script *apimodels.ScriptSpec
+	var file *apimodels.FileSpec
+	var fetch *apimodels.FetchSpec
+	if req.Script != nil {
+		command = req.Script.Body
+		script = &apimodels.ScriptSpec{Interpreter: req.Script.Interpreter, Args: req.Script.Args}
+		if err := script.Validate(command); err != nil {
+			LogGRPCResponse("CreateTask", false, "Invalid script: "+err.Error())
+			SendErrorResponse(c, http.StatusBadRequest, "Invalid script: "+err.Error())
+			return
+		}
+	}
+
+	if req.File != nil {
+		file = &apimodels.FileSpec{
+			SHA256:   req.File.SHA256,
+			Name:     req.File.Name,
+			DestPath: req.File.DestPath,
+			Owner:    req.File.Owner,
+			Group:    req.File.Group,
+			Mode:     req.File.Mode,
+			Peer:     req.File.Peer,
+
+			BandwidthLimit: req.File.BandwidthLimit,
+		}
+		if err := file.Validate(); err != nil {
+			LogGRPCResponse("CreateTask", false, "Invalid file: "+err.Error())
+			SendErrorResponse(c, http.StatusBadRequest, "Invalid file: "+err.Error())
+			return
+		}
+		command = file.Description()
+	}
+
+	if req.Fetch != nil {
+		fetch = &apimodels.FetchSpec{
+			Patterns:       req.Fetch.Patterns,
+			MaxFileBytes:   req.Fetch.MaxFileBytes,
+			BandwidthLimit: req.Fetch.BandwidthLimit,
+			MaxTotalBytes:  req.Fetch.MaxTotalBytes,
+		}
+		if err := fetch.Validate(); err != nil {
+			LogGRPCResponse("CreateTask", false, "Invalid fetch: "+err.Error())
+			SendErrorResponse(c, http.StatusBadRequest, "Invalid fetch: "+err.Error())
+			return
+		}
+		command = fetch.Description()
+	}
+
+	if command == "" {
 		LogGRPCResponse("CreateTask", false, "Command is required")
 		SendErrorResponse(c, http.StatusBadRequest, "Command is required")
 		return
 	}
 
+	if err := req.Options.Validate(); err != nil {
+		LogGRPCResponse("CreateTask", false, "Invalid execution options: "+err.Error())
+		SendErrorResponse(c, http.StatusBadRequest, "Invalid execution options: "+err.Error())
+		return
+	}
+
 	// 创建任务
-	task, err := tc.taskService.CreateTask(
-		req.Name,
-		req.Description,
-		req.HostIDs,
-		req.Command,
-		req.Timeout,
-		req.Parameters,
-		"admin", // TODO: 从认证信息中获取用户
-	)
+	task, err := tc.taskService.CreateTask(service.CreateTaskRequest{
+		Name:        req.Name,
+		Description: req.Description,
+		HostIDs:     req.HostIDs,
+		Command:     command,
+		Script:      script,
+		File:        file,
+		Fetch:       fetch,
+		Timeout:     req.Timeout,
+		Parameters:  req.Parameters,
+		Options:     req.Options,
+		CreatedBy:   currentUsername(c),
+		Scope:       currentHostScope(c),
+	})
 
 	if err != nil {
+		if isHostScopeError(err) {
+			LogGRPCResponse("CreateTask", false, err.Error())
+			SendErrorResponse(c, http.StatusForbidden, "Permission denied: "+err.Error())
+			return
+		}
+		if errors.Is(err, service.ErrFileNotFound) {
+			LogGRPCResponse("CreateTask", false, err.Error())
+			SendErrorResponse(c, http.StatusBadRequest, "Invalid file: "+err.Error())
+			return
+		}
 		LogGRPCResponse("CreateTask", false, "Failed to create task: "+err.Error())
 		SendErrorResponse(c, http.StatusInternalServerError, "Failed to create task: "+err.Error())
 		return
@@ -191,7 +315,7 @@ func (tc *HTTPTaskController) GetTasks(c *gin.Context) {
 	}
 
 	// 获取任务列表
-	tasks, total, err := tc.taskService.GetTasks(page, size, status, name)
+	tasks, total, err := tc.taskService.GetTasks(page, size, status, name, currentHostScope(c))
 	if err != nil {
 		LogGRPCResponse("GetTasks", false, "Failed to get tasks: "+err.Error())
 		SendErrorResponse(c, http.StatusInternalServerError, "Failed to get tasks: "+err.Error())
@@ -348,6 +472,7 @@ func (tc *HTTPTaskController) GetTaskProgress(c *gin.Context) {
 // @Param        id   path      string  true  "任务ID"
 // @Success      200  {object}  models.APIResponse
 // @Failure      400  {object}  models.APIResponse
+// @Failure      403  {object}  models.APIResponse
 // @Failure      500  {object}  models.APIResponse
 // @Router       /tasks/{id}/start [post]
 func (tc *HTTPTaskController) StartTask(c *gin.Context) {
@@ -360,8 +485,13 @@ func (tc *HTTPTaskController) StartTask(c *gin.Context) {
 		return
 	}
 
-	err := tc.taskService.StartTask(taskID)
+	err := tc.taskService.StartTask(taskID, currentUsername(c), currentHostScope(c))
 	if err != nil {
+		if isHostScopeError(err) {
+			LogGRPCResponse("StartTask", false, err.Error())
+			SendErrorResponse(c, http.StatusForbidden, "Permission denied: "+err.Error())
+			return
+		}
 		LogGRPCResponse("StartTask", false, "Failed to start task: "+err.Error())
 		SendErrorResponse(c, http.StatusInternalServerError, "Failed to start task: "+err.Error())
 		return
@@ -380,6 +510,7 @@ func (tc *HTTPTaskController) StartTask(c *gin.Context) {
 // @Param        id   path      string  true  "任务ID"
 // @Success      200  {object}  models.APIResponse
 // @Failure      400  {object}  models.APIResponse
+// @Failure      403  {object}  models.APIResponse
 // @Failure      500  {object}  models.APIResponse
 // @Router       /tasks/{id}/stop [post]
 func (tc *HTTPTaskController) StopTask(c *gin.Context) {
@@ -392,8 +523,13 @@ func (tc *HTTPTaskController) StopTask(c *gin.Context) {
 		return
 	}
 
-	err := tc.taskService.StopTask(taskID)
+	err := tc.taskService.StopTask(taskID, currentUsername(c), currentHostScope(c))
 	if err != nil {
+		if isHostScopeError(err) {
+			LogGRPCResponse("StopTask", false, err.Error())
+			SendErrorResponse(c, http.StatusForbidden, "Permission denied: "+err.Error())
+			return
+		}
 		LogGRPCResponse("StopTask", false, "Failed to stop task: "+err.Error())
 		SendErrorResponse(c, http.StatusInternalServerError, "Failed to stop task: "+err.Error())
 		return
@@ -412,6 +548,7 @@ func (tc *HTTPTaskController) StopTask(c *gin.Context) {
 // @Param        id   path      string  true  "任务ID"
 // @Success      200  {object}  models.APIResponse
 // @Failure      400  {object}  models.APIResponse
+// @Failure      403  {object}  models.APIResponse
 // @Failure      500  {object}  models.APIResponse
 // @Router       /tasks/{id}/cancel [post]
 func (tc *HTTPTaskController) CancelTask(c *gin.Context) {
@@ -424,8 +561,13 @@ func (tc *HTTPTaskController) CancelTask(c *gin.Context) {
 		return
 	}
 
-	err := tc.taskService.CancelTask(taskID)
+	err := tc.taskService.CancelTask(taskID, currentUsername(c), currentHostScope(c))
 	if err != nil {
+		if isHostScopeError(err) {
+			LogGRPCResponse("CancelTask", false, err.Error())
+			SendErrorResponse(c, http.StatusForbidden, "Permission denied: "+err.Error())
+			return
+		}
 		LogGRPCResponse("CancelTask", false, "Failed to cancel task: "+err.Error())
 		SendErrorResponse(c, http.StatusInternalServerError, "Failed to cancel task: "+err.Error())
 		return
@@ -494,7 +636,7 @@ func (tc *HTTPTaskController) GetTasksByHost(c *gin.Context) {
 		size = 20
 	}
 
-	tasks, total, err := tc.taskService.GetTasksByHost(hostID, page, size, status)
+	tasks, total, err := tc.taskService.GetTasksByHost(hostID, page, size, status, currentHostScope(c))
 	if err != nil {
 		LogGRPCResponse("GetTasksByHost", false, "Failed to get tasks by host: "+err.Error())
 		SendErrorResponse(c, http.StatusInternalServerError, "Failed to get tasks by host: "+err.Error())
@@ -567,7 +709,7 @@ func (tc *HTTPTaskController) GetTasksByStatus(c *gin.Context) {
 		size = 20
 	}
 
-	tasks, total, err := tc.taskService.GetTasksByStatus(status, page, size)
+	tasks, total, err := tc.taskService.GetTasksByStatus(status, page, size, currentHostScope(c))
 	if err != nil {
 		LogGRPCResponse("GetTasksByStatus", false, "Failed to get tasks by status: "+err.Error())
 		SendErrorResponse(c, http.StatusInternalServerError, "Failed to get tasks by status: "+err.Error())
@@ -664,7 +806,7 @@ func (tc *HTTPTaskController) GetTasksByDateRange(c *gin.Context) {
 		size = 20
 	}
 
-	tasks, total, err := tc.taskService.GetTasksByDateRange(startDate, endDate, page, size, status)
+	tasks, total, err := tc.taskService.GetTasksByDateRange(startDate, endDate, page, size, status, currentHostScope(c))
 	if err != nil {
 		LogGRPCResponse("GetTasksByDateRange", false, "Failed to get tasks by date range: "+err.Error())
 		SendErrorResponse(c, http.StatusInternalServerError, "Failed to get tasks by date range: "+err.Error())
@@ -738,6 +880,268 @@ func (tc *HTTPTaskController) GetTaskHosts(c *gin.Context) {
 	SendSuccessResponse(c, hosts)
 }
 
+// GetTaskHostOutput 获取任务主机的命令输出
+// @Summary      获取任务主机的命令输出
+// @Description  获取命令在指定主机上的输出，命令执行过程中随 Agent 上报增量追加。传入上次返回的 stdout_offset/stderr_offset 只获取新增输出；finished 为 true 后输出以最终执行结果为准。输出超过上限时 truncated 为 true，spooled 为 true 时可通过 output/full 下载完整输出
+// @Tags         任务管理
+// @Produce      json
+// @Param        id             path      string  true   "任务ID"
+// @Param        hostId         path      string  true   "主机ID"
+// @Param        stdout_offset  query     int     false  "stdout 起始字节偏移量"
+// @Param        stderr_offset  query     int     false  "stderr 起始字节偏移量"
+// @Success      200            {object}  models.APIResponse
+// @Failure      404            {object}  models.APIResponse
+// @Router       /tasks/{id}/hosts/{hostId}/output [get]
+func (tc *HTTPTaskController) GetTaskHostOutput(c *gin.Context) {
+	LogGRPCRequest("GetTaskHostOutput", c.Request.Method+" "+c.Request.URL.Path)
+
+	taskID := c.Param("id")
+	hostID := c.Param("hostId")
+
+	stdoutOffset, _ := strconv.Atoi(c.DefaultQuery("stdout_offset", "0"))
+	stderrOffset, _ := strconv.Atoi(c.DefaultQuery("stderr_offset", "0"))
+
+	output, err := tc.taskService.GetCommandHostOutput(taskID, hostID, stdoutOffset, stderrOffset)
+	if err != nil {
+		LogGRPCResponse("GetTaskHostOutput", false, "Failed to get command output: "+err.Error())
+		SendErrorResponse(c, http.StatusNotFound, "Failed to get command output: "+err.Error())
+		return
+	}
+
+	LogGRPCResponse("GetTaskHostOutput", true, "Command output retrieved: "+output.CommandID)
+	SendSuccessResponse(c, output)
+}
+
+// DownloadTaskHostOutput 下载任务主机转存的完整输出
+// @Summary      下载任务主机的完整输出
+// @Description  下载命令在指定主机上转存的完整输出，需在服务端配置 output.spool_dir
+// @Tags         任务管理
+// @Produce      plain
+// @Param        id      path      string  true   "任务ID"
+// @Param        hostId  path      string  true   "主机ID"
+// @Param        stream  query     string  false  "输出流：stdout（默认）或 stderr"
+// @Success      200     {string}  string  "完整输出"
+// @Failure      400     {object}  models.APIResponse
+// @Failure      404     {object}  models.APIResponse
+// @Router       /tasks/{id}/hosts/{hostId}/output/full [get]
+func (tc *HTTPTaskController) DownloadTaskHostOutput(c *gin.Context) {
+	LogGRPCRequest("DownloadTaskHostOutput", c.Request.Method+" "+c.Request.URL.Path)
+
+	taskID := c.Param("id")
+	hostID := c.Param("hostId")
+	stream := c.DefaultQuery("stream", apimodels.OutputStreamStdout)
+	if stream != apimodels.OutputStreamStdout && stream != apimodels.OutputStreamStderr {
+		SendErrorResponse(c, http.StatusBadRequest, "Invalid stream: "+stream)
+		return
+	}
+
+	reader, err := tc.taskService.OpenSpooledOutput(taskID, hostID, stream)
+	if err != nil {
+		LogGRPCResponse("DownloadTaskHostOutput", false, "Failed to open spooled output: "+err.Error())
+		SendErrorResponse(c, http.StatusNotFound, "Failed to open spooled output: "+err.Error())
+		return
+	}
+	defer reader.Close()
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.%s.log"`, taskID, hostID, stream))
+	c.DataFromReader(http.StatusOK, -1, "text/plain; charset=utf-8", reader, nil)
+	LogGRPCResponse("DownloadTaskHostOutput", true, "Spooled output downloaded: "+taskID+"/"+hostID)
+}
+
+// GetTaskHostFiles 获取主机在文件收集任务中回传的文件
+// @Summary      获取主机回传的文件
+// @Description  列出文件收集任务中指定主机已回传完成的文件，path 为文件在主机上的路径
+// @Tags         任务管理
+// @Produce      json
+// @Param        id      path      string  true  "任务ID"
+// @Param        hostId  path      string  true  "主机ID"
+// @Success      200     {object}  models.APIResponse{data=[]models.CollectedFileResponse}
+// @Failure      403     {object}  models.APIResponse
+// @Failure      500     {object}  models.APIResponse
+// @Router       /tasks/{id}/hosts/{hostId}/files [get]
+func (tc *HTTPTaskController) GetTaskHostFiles(c *gin.Context) {
+	LogGRPCRequest("GetTaskHostFiles", c.Request.Method+" "+c.Request.URL.Path)
+
+	taskID := c.Param("id")
+	hostID := c.Param("hostId")
+
+	files, err := tc.taskService.ListCollectedFiles(taskID, hostID, currentHostScope(c))
+	if err != nil {
+		if isHostScopeError(err) {
+			LogGRPCResponse("GetTaskHostFiles", false, "Permission denied: "+err.Error())
+			SendErrorResponse(c, http.StatusForbidden, "Permission denied: "+err.Error())
+			return
+		}
+		LogGRPCResponse("GetTaskHostFiles", false, "Failed to list collected files: "+err.Error())
+		SendErrorResponse(c, http.StatusInternalServerError, "Failed to list collected files: "+err.Error())
+		return
+	}
+
+	response := make([]models.CollectedFileResponse, 0, len(files))
+	for _, file := range files {
+		response = append(response, models.CollectedFileResponse{
+			Path:    file.Path,
+			Size:    file.Size,
+			ModTime: file.ModTime.Format("2006-01-02T15:04:05Z"),
+		})
+	}
+
+	LogGRPCResponse("GetTaskHostFiles", true, fmt.Sprintf("Collected files retrieved: %s/%s (%d)", taskID, hostID, len(files)))
+	SendSuccessResponse(c, response)
+}
+
+// DownloadTaskHostFile 下载主机回传的单个文件
+// @Summary      下载主机回传的文件
+// @Description  下载文件收集任务中指定主机回传的单个文件
+// @Tags         任务管理
+// @Produce      application/octet-stream
+// @Param        id      path      string  true  "任务ID"
+// @Param        hostId  path      string  true  "主机ID"
+// @Param        path    query     string  true  "文件在主机上的路径"
+// @Success      200     {file}    binary
+// @Failure      400     {object}  models.APIResponse
+// @Failure      403     {object}  models.APIResponse
+// @Failure      404     {object}  models.APIResponse
+// @Failure      500     {object}  models.APIResponse
+// @Router       /tasks/{id}/hosts/{hostId}/files/download [get]
+func (tc *HTTPTaskController) DownloadTaskHostFile(c *gin.Context) {
+	LogGRPCRequest("DownloadTaskHostFile", c.Request.Method+" "+c.Request.URL.Path)
+
+	taskID := c.Param("id")
+	hostID := c.Param("hostId")
+	path := c.Query("path")
+	if path == "" {
+		SendErrorResponse(c, http.StatusBadRequest, "File path is required")
+		return
+	}
+
+	file, err := tc.taskService.OpenCollectedFile(taskID, hostID, path, currentHostScope(c))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if isHostScopeError(err) {
+			status = http.StatusForbidden
+		} else if errors.Is(err, service.ErrFileNotFound) {
+			status = http.StatusNotFound
+		}
+		LogGRPCResponse("DownloadTaskHostFile", false, "Failed to open collected file: "+err.Error())
+		SendErrorResponse(c, status, "Failed to open collected file: "+err.Error())
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		LogGRPCResponse("DownloadTaskHostFile", false, "Failed to open collected file: "+err.Error())
+		SendErrorResponse(c, http.StatusInternalServerError, "Failed to open collected file: "+err.Error())
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filepath.Base(file.Name())))
+	c.DataFromReader(http.StatusOK, info.Size(), "application/octet-stream", file, nil)
+	LogGRPCResponse("DownloadTaskHostFile", true, "Collected file downloaded: "+taskID+"/"+hostID+":"+path)
+}
+
+// DownloadTaskFilesArchive 打包下载任务所有主机回传的文件
+// @Summary      打包下载回传的文件
+// @Description  以 tar.gz 下载文件收集任务中所有主机回传的文件，条目路径为 <主机ID>/<文件在主机上的路径>。调用者主机范围外的主机不包含在压缩包中
+// @Tags         任务管理
+// @Produce      application/gzip
+// @Param        id  path      string  true  "任务ID"
+// @Success      200 {file}    binary
+// @Failure      404 {object}  models.APIResponse
+// @Router       /tasks/{id}/files/archive [get]
+func (tc *HTTPTaskController) DownloadTaskFilesArchive(c *gin.Context) {
+	LogGRPCRequest("DownloadTaskFilesArchive", c.Request.Method+" "+c.Request.URL.Path)
+
+	taskID := c.Param("id")
+	if _, err := tc.taskService.GetTask(taskID); err != nil {
+		LogGRPCResponse("DownloadTaskFilesArchive", false, "Task not found: "+err.Error())
+		SendErrorResponse(c, http.StatusNotFound, "Task not found: "+err.Error())
+		return
+	}
+
+	// 响应头发出后无法再返回错误响应，打包失败时中断连接，客户端得到不完整的压缩包
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-files.tar.gz"`, taskID))
+	c.Header("Content-Type", "application/gzip")
+	c.Status(http.StatusOK)
+	if err := tc.taskService.WriteCollectedArchive(taskID, c.Writer, currentHostScope(c)); err != nil {
+		LogGRPCResponse("DownloadTaskFilesArchive", false, "Failed to archive collected files: "+err.Error())
+		c.Abort()
+		return
+	}
+	LogGRPCResponse("DownloadTaskFilesArchive", true, "Collected files archive downloaded: "+taskID)
+}
+
+// StreamTaskEvents 实时推送任务事件
+// @Summary      实时推送任务事件
+// @Description  以 Server-Sent Events 推送任务的主机状态变化、命令输出分片和任务状态变化。首次连接先推送 snapshot 事件（当前状态和已有输出），断线重连时携带 Last-Event-ID 请求头（或 last_event_id 参数）续传，事件已不在缓冲内时重新推送 snapshot。任务结束后服务端关闭连接
+// @Tags         任务管理
+// @Produce      text/event-stream
+// @Param        id             path      string  true   "任务ID"
+// @Param        last_event_id  query     string  false  "最后收到的事件ID"
+// @Param        access_token   query     string  false  "API 令牌，用于无法设置请求头的 EventSource"
+// @Success      200            {string}  string  "事件流"
+// @Failure      404            {object}  models.APIResponse
+// @Router       /tasks/{id}/events [get]
+func (tc *HTTPTaskController) StreamTaskEvents(c *gin.Context) {
+	LogGRPCRequest("StreamTaskEvents", c.Request.Method+" "+c.Request.URL.Path)
+
+	taskID := c.Param("id")
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	// 先订阅再读取快照，保证快照之后的事件不会丢失
+	replay, events, resumed, cancel := service.GetTaskEventHub().Subscribe(taskID, lastEventID)
+	defer cancel()
+
+	stream := newTaskEventStream(c)
+	if resumed {
+		for _, event := range replay {
+			if !stream.sendEvent(event) {
+				LogGRPCResponse("StreamTaskEvents", true, "Task event stream finished: "+taskID)
+				return
+			}
+		}
+	} else {
+		task, outputs, err := tc.taskService.GetTaskOutputSnapshot(taskID)
+		if err != nil {
+			LogGRPCResponse("StreamTaskEvents", false, "Failed to get task snapshot: "+err.Error())
+			SendErrorResponse(c, http.StatusNotFound, "Failed to get task snapshot: "+err.Error())
+			return
+		}
+		stream.sendSnapshot(task, outputs)
+		if task.IsCompleted() {
+			LogGRPCResponse("StreamTaskEvents", true, "Task event stream finished: "+taskID)
+			return
+		}
+	}
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			LogGRPCResponse("StreamTaskEvents", true, "Task event stream closed by client: "+taskID)
+			return
+		case <-keepalive.C:
+			stream.sendComment("ping")
+		case event, ok := <-events:
+			if !ok {
+				// 消费过慢被断开，客户端按 Last-Event-ID 重连续传
+				LogGRPCResponse("StreamTaskEvents", false, "Task event stream dropped: "+taskID)
+				return
+			}
+			if !stream.sendEvent(event) {
+				LogGRPCResponse("StreamTaskEvents", true, "Task event stream finished: "+taskID)
+				return
+			}
+		}
+	}
+}
+
 // AddTaskHosts 添加任务主机
 // @Summary      添加任务主机
 // @Description  向现有任务添加新的目标主机
@@ -748,6 +1152,7 @@ func (tc *HTTPTaskController) GetTaskHosts(c *gin.Context) {
 // @Param        hosts    body      models.AddTaskHostsRequest true  "主机列表"
 // @Success      200      {object}  models.APIResponse
 // @Failure      400      {object}  models.APIResponse
+// @Failure      403      {object}  models.APIResponse
 // @Failure      500      {object}  models.APIResponse
 // @Router       /tasks/{id}/hosts [post]
 func (tc *HTTPTaskController) AddTaskHosts(c *gin.Context) {
@@ -776,8 +1181,13 @@ func (tc *HTTPTaskController) AddTaskHosts(c *gin.Context) {
 		return
 	}
 
-	err := tc.taskService.AddTaskHosts(taskID, req.HostIDs)
+	err := tc.taskService.AddTaskHosts(taskID, req.HostIDs, currentUsername(c), currentHostScope(c))
 	if err != nil {
+		if isHostScopeError(err) {
+			LogGRPCResponse("AddTaskHosts", false, err.Error())
+			SendErrorResponse(c, http.StatusForbidden, "Permission denied: "+err.Error())
+			return
+		}
 		LogGRPCResponse("AddTaskHosts", false, "Failed to add task hosts: "+err.Error())
 		SendErrorResponse(c, http.StatusInternalServerError, "Failed to add task hosts: "+err.Error())
 		return
@@ -797,6 +1207,7 @@ func (tc *HTTPTaskController) AddTaskHosts(c *gin.Context) {
 // @Param        hostId  path      string  true  "主机ID"
 // @Success      200     {object}  models.APIResponse
 // @Failure      400     {object}  models.APIResponse
+// @Failure      403     {object}  models.APIResponse
 // @Failure      500     {object}  models.APIResponse
 // @Router       /tasks/{id}/hosts/{hostId} [delete]
 func (tc *HTTPTaskController) RemoveTaskHost(c *gin.Context) {
@@ -811,8 +1222,13 @@ func (tc *HTTPTaskController) RemoveTaskHost(c *gin.Context) {
 		return
 	}
 
-	err := tc.taskService.RemoveTaskHost(taskID, hostID)
+	err := tc.taskService.RemoveTaskHost(taskID, hostID, currentHostScope(c))
 	if err != nil {
+		if isHostScopeError(err) {
+			LogGRPCResponse("RemoveTaskHost", false, err.Error())
+			SendErrorResponse(c, http.StatusForbidden, "Permission denied: "+err.Error())
+			return
+		}
 		LogGRPCResponse("RemoveTaskHost", false, "Failed to remove task host: "+err.Error())
 		SendErrorResponse(c, http.StatusInternalServerError, "Failed to remove task host: "+err.Error())
 		return
@@ -822,6 +1238,59 @@ func (tc *HTTPTaskController) RemoveTaskHost(c *gin.Context) {
 	SendSuccessResponse(c, gin.H{"message": "Host removed successfully"})
 }
 
+// ControlTaskHost 控制任务在指定主机上执行的命令
+// @Summary      控制主机命令
+// @Description  向任务在指定主机上正在执行的命令发送取消、信号、暂停或恢复请求，返回 Agent 的确认结果
+// @Tags         任务控制
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string                        true  "任务ID"
+// @Param        hostId   path      string                        true  "主机ID"
+// @Param        request  body      models.CommandControlRequest  true  "控制请求"
+// @Success      200      {object}  models.APIResponse
+// @Failure      400      {object}  models.APIResponse
+// @Failure      403      {object}  models.APIResponse
+// @Failure      504      {object}  models.APIResponse
+// @Router       /tasks/{id}/hosts/{hostId}/control [post]
+func (tc *HTTPTaskController) ControlTaskHost(c *gin.Context) {
+	LogGRPCRequest("ControlTaskHost", c.Request.Method+" "+c.Request.URL.Path)
+
+	taskID := c.Param("id")
+	hostID := c.Param("hostId")
+	if taskID == "" || hostID == "" {
+		LogGRPCResponse("ControlTaskHost", false, "Task ID and Host ID are required")
+		SendErrorResponse(c, http.StatusBadRequest, "Task ID and Host ID are required")
+		return
+	}
+
+	var req models.CommandControlRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		LogGRPCResponse("ControlTaskHost", false, "Invalid request body: "+err.Error())
+		SendErrorResponse(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	ack, err := tc.taskService.ControlTaskHost(taskID, hostID, apimodels.ControlAction(req.Action), req.Signal,
+		currentUsername(c), currentHostScope(c))
+	if err != nil {
+		switch {
+		case isHostScopeError(err):
+			LogGRPCResponse("ControlTaskHost", false, err.Error())
+			SendErrorResponse(c, http.StatusForbidden, "Permission denied: "+err.Error())
+		case errors.Is(err, service.ErrControlAckTimeout):
+			LogGRPCResponse("ControlTaskHost", false, err.Error())
+			SendErrorResponse(c, http.StatusGatewayTimeout, "Failed to control command: "+err.Error())
+		default:
+			LogGRPCResponse("ControlTaskHost", false, "Failed to control command: "+err.Error())
+			SendErrorResponse(c, http.StatusBadRequest, "Failed to control command: "+err.Error())
+		}
+		return
+	}
+
+	LogGRPCResponse("ControlTaskHost", ack.Success, "Command control acknowledged: "+taskID+"/"+hostID+" "+ack.State)
+	SendSuccessResponse(c, ack)
+}
+
 // GetTaskLogs 获取任务日志
 // @Summary      获取任务日志
 // @Description  获取任务执行的详细日志信息
@@ -914,7 +1383,7 @@ func (tc *HTTPTaskController) GetFailedCommands(c *gin.Context) {
 		size = 20
 	}
 
-	commands, total, err := tc.taskService.GetFailedCommands(page, size, hostID)
+	commands, total, err := tc.taskService.GetFailedCommands(page, size, hostID, currentHostScope(c))
 	if err != nil {
 		LogGRPCResponse("GetFailedCommands", false, "Failed to get failed commands: "+err.Error())
 		SendErrorResponse(c, http.StatusInternalServerError, "Failed to get failed commands: "+err.Error())
@@ -943,6 +1412,7 @@ func (tc *HTTPTaskController) GetFailedCommands(c *gin.Context) {
 // @Param        commandId  path      string  true  "命令ID"
 // @Success      200        {object}  models.APIResponse
 // @Failure      400        {object}  models.APIResponse
+// @Failure      403        {object}  models.APIResponse
 // @Failure      500        {object}  models.APIResponse
 // @Router       /tasks/commands/{commandId}/retry [post]
 func (tc *HTTPTaskController) RetryFailedCommand(c *gin.Context) {
@@ -955,8 +1425,13 @@ func (tc *HTTPTaskController) RetryFailedCommand(c *gin.Context) {
 		return
 	}
 
-	err := tc.taskService.RetryFailedCommand(commandID)
+	err := tc.taskService.RetryFailedCommand(commandID, currentHostScope(c))
 	if err != nil {
+		if isHostScopeError(err) {
+			LogGRPCResponse("RetryFailedCommand", false, err.Error())
+			SendErrorResponse(c, http.StatusForbidden, "Permission denied: "+err.Error())
+			return
+		}
 		LogGRPCResponse("RetryFailedCommand", false, "Failed to retry command: "+err.Error())
 		SendErrorResponse(c, http.StatusInternalServerError, "Failed to retry command: "+err.Error())
 		return
@@ -0,0 +1,225 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	apimodels "devops-manager/api/models"
+	"devops-manager/server/pkg/models"
+	"devops-manager/server/pkg/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HTTPUpgradeController Agent 升级计划 HTTP 控制器
+type HTTPUpgradeController struct{}
+
+// NewHTTPUpgradeController 创建新的 Agent 升级计划 HTTP 控制器
+func NewHTTPUpgradeController() *HTTPUpgradeController {
+	return &HTTPUpgradeController{}
+}
+
+// RegisterUpgradeHTTPRoutes 注册 Agent 升级计划相关 HTTP 路由
+func RegisterUpgradeHTTPRoutes(r *gin.Engine) {
+	controller := NewHTTPUpgradeController()
+
+	api := r.Group("/api/v1", AuthMiddleware())
+	{
+		admin := RequireRole(apimodels.UserRoleAdmin)
+
+		api.POST("/upgrades", admin, controller.CreateUpgrade)
+		api.GET("/upgrades", controller.GetUpgrades)
+		api.GET("/upgrades/:id", controller.GetUpgrade)
+		api.POST("/upgrades/:id/cancel", admin, controller.CancelUpgrade)
+	}
+}
+
+// CreateUpgrade 创建 Agent 升级计划
+// @Summary      创建 Agent 升级计划
+// @Description  将已上传的新版本 Agent 二进制按批次升级到目标主机。Agent 下载并校验摘要和签名后切换二进制并重启，未能在期限内连上 Server 时自动回滚；当前批次全部结束后才开始下一批，失败主机数超过 max_failures 时停止后续批次
+// @Tags         Agent 升级
+// @Accept       json
+// @Produce      json
+// @Param        upgrade  body      models.CreateUpgradeRequest  true  "升级计划"
+// @Success      200      {object}  models.APIResponse{data=apimodels.UpgradeCampaign}
+// @Failure      400      {object}  models.APIResponse
+// @Failure      403      {object}  models.APIResponse
+// @Failure      500      {object}  models.APIResponse
+// @Router       /upgrades [post]
+func (uc *HTTPUpgradeController) CreateUpgrade(c *gin.Context) {
+	LogGRPCRequest("CreateUpgrade", c.Request.Method+" "+c.Request.URL.Path)
+
+	upgradeService := service.GetUpgradeService()
+	if upgradeService == nil {
+		LogGRPCResponse("CreateUpgrade", false, "Upgrade service is not configured")
+		SendErrorResponse(c, http.StatusInternalServerError, "Upgrade service is not configured")
+		return
+	}
+
+	var req models.CreateUpgradeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		LogGRPCResponse("CreateUpgrade", false, "Invalid request body: "+err.Error())
+		SendErrorResponse(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	campaign, err := upgradeService.CreateCampaign(service.UpgradeCampaignOptions{
+		Version:          req.Version,
+		SHA256:           req.SHA256,
+		Signature:        req.Signature,
+		HostIDs:          req.HostIDs,
+		BatchSize:        req.BatchSize,
+		MaxFailures:      req.MaxFailures,
+		ReconnectTimeout: time.Duration(req.ReconnectTimeout) * time.Second,
+		Timeout:          time.Duration(req.Timeout) * time.Second,
+		CreatedBy:        currentUsername(c),
+		Scope:            currentHostScope(c),
+	})
+	if err != nil {
+		switch {
+		case isHostScopeError(err):
+			LogGRPCResponse("CreateUpgrade", false, err.Error())
+			SendErrorResponse(c, http.StatusForbidden, "Permission denied: "+err.Error())
+		case errors.Is(err, service.ErrInvalidUpgrade):
+			LogGRPCResponse("CreateUpgrade", false, err.Error())
+			SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		case errors.Is(err, service.ErrFileNotFound):
+			LogGRPCResponse("CreateUpgrade", false, err.Error())
+			SendErrorResponse(c, http.StatusBadRequest, "Invalid file: "+err.Error())
+		default:
+			LogGRPCResponse("CreateUpgrade", false, "Failed to create upgrade campaign: "+err.Error())
+			SendErrorResponse(c, http.StatusInternalServerError, "Failed to create upgrade campaign: "+err.Error())
+		}
+		return
+	}
+
+	LogGRPCResponse("CreateUpgrade", true, "Upgrade campaign created: "+campaign.CampaignID)
+	SendSuccessResponse(c, campaign)
+}
+
+// GetUpgrades 获取 Agent 升级计划列表
+// @Summary      获取 Agent 升级计划列表
+// @Description  分页获取 Agent 升级计划，按创建时间倒序
+// @Tags         Agent 升级
+// @Produce      json
+// @Param        page    query     int     false  "页码"  default(1)
+// @Param        size    query     int     false  "每页数量"  default(20)
+// @Param        status  query     string  false  "计划状态：running、completed、failed 或 canceled"
+// @Success      200     {object}  models.APIResponse
+// @Failure      500     {object}  models.APIResponse
+// @Router       /upgrades [get]
+func (uc *HTTPUpgradeController) GetUpgrades(c *gin.Context) {
+	LogGRPCRequest("GetUpgrades", c.Request.Method+" "+c.Request.URL.Path)
+
+	upgradeService := service.GetUpgradeService()
+	if upgradeService == nil {
+		LogGRPCResponse("GetUpgrades", false, "Upgrade service is not configured")
+		SendErrorResponse(c, http.StatusInternalServerError, "Upgrade service is not configured")
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 100 {
+		size = 20
+	}
+
+	campaigns, total, err := upgradeService.GetCampaigns(page, size, c.Query("status"))
+	if err != nil {
+		LogGRPCResponse("GetUpgrades", false, "Failed to get upgrade campaigns: "+err.Error())
+		SendErrorResponse(c, http.StatusInternalServerError, "Failed to get upgrade campaigns: "+err.Error())
+		return
+	}
+
+	LogGRPCResponse("GetUpgrades", true, "Retrieved "+strconv.Itoa(len(campaigns))+" upgrade campaigns")
+	SendSuccessResponse(c, gin.H{
+		"upgrades": campaigns,
+		"pagination": gin.H{
+			"page":  page,
+			"size":  size,
+			"total": total,
+		},
+	})
+}
+
+// GetUpgrade 获取 Agent 升级计划详情
+// @Summary      获取 Agent 升级计划详情
+// @Description  获取升级计划及每台主机的批次、升级前版本和升级状态
+// @Tags         Agent 升级
+// @Produce      json
+// @Param        id   path      string  true  "升级计划ID"
+// @Success      200  {object}  models.APIResponse{data=apimodels.UpgradeCampaign}
+// @Failure      404  {object}  models.APIResponse
+// @Failure      500  {object}  models.APIResponse
+// @Router       /upgrades/{id} [get]
+func (uc *HTTPUpgradeController) GetUpgrade(c *gin.Context) {
+	LogGRPCRequest("GetUpgrade", c.Request.Method+" "+c.Request.URL.Path)
+
+	upgradeService := service.GetUpgradeService()
+	if upgradeService == nil {
+		LogGRPCResponse("GetUpgrade", false, "Upgrade service is not configured")
+		SendErrorResponse(c, http.StatusInternalServerError, "Upgrade service is not configured")
+		return
+	}
+
+	campaign, err := upgradeService.GetCampaign(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, service.ErrUpgradeCampaignNotFound) {
+			LogGRPCResponse("GetUpgrade", false, err.Error())
+			SendErrorResponse(c, http.StatusNotFound, err.Error())
+			return
+		}
+		LogGRPCResponse("GetUpgrade", false, "Failed to get upgrade campaign: "+err.Error())
+		SendErrorResponse(c, http.StatusInternalServerError, "Failed to get upgrade campaign: "+err.Error())
+		return
+	}
+
+	LogGRPCResponse("GetUpgrade", true, "Retrieved upgrade campaign: "+campaign.CampaignID)
+	SendSuccessResponse(c, campaign)
+}
+
+// CancelUpgrade 取消 Agent 升级计划
+// @Summary      取消 Agent 升级计划
+// @Description  未开始的主机不再升级，当前批次尚未完成的升级命令被取消；已切换到新版本的主机仍按重新连接时的版本记录结果
+// @Tags         Agent 升级
+// @Produce      json
+// @Param        id   path      string  true  "升级计划ID"
+// @Success      200  {object}  models.APIResponse
+// @Failure      404  {object}  models.APIResponse
+// @Failure      409  {object}  models.APIResponse
+// @Failure      500  {object}  models.APIResponse
+// @Router       /upgrades/{id}/cancel [post]
+func (uc *HTTPUpgradeController) CancelUpgrade(c *gin.Context) {
+	LogGRPCRequest("CancelUpgrade", c.Request.Method+" "+c.Request.URL.Path)
+
+	upgradeService := service.GetUpgradeService()
+	if upgradeService == nil {
+		LogGRPCResponse("CancelUpgrade", false, "Upgrade service is not configured")
+		SendErrorResponse(c, http.StatusInternalServerError, "Upgrade service is not configured")
+		return
+	}
+
+	campaignID := c.Param("id")
+	if err := upgradeService.CancelCampaign(campaignID, currentUsername(c)); err != nil {
+		switch {
+		case errors.Is(err, service.ErrUpgradeCampaignNotFound):
+			LogGRPCResponse("CancelUpgrade", false, err.Error())
+			SendErrorResponse(c, http.StatusNotFound, err.Error())
+		case errors.Is(err, service.ErrUpgradeCampaignFinished):
+			LogGRPCResponse("CancelUpgrade", false, err.Error())
+			SendErrorResponse(c, http.StatusConflict, err.Error())
+		default:
+			LogGRPCResponse("CancelUpgrade", false, "Failed to cancel upgrade campaign: "+err.Error())
+			SendErrorResponse(c, http.StatusInternalServerError, "Failed to cancel upgrade campaign: "+err.Error())
+		}
+		return
+	}
+
+	LogGRPCResponse("CancelUpgrade", true, "Upgrade campaign canceled: "+campaignID)
+	SendMessageResponse(c, "Upgrade campaign canceled")
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	apimodels "devops-manager/api/models"
+	"devops-manager/server/pkg/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// taskSnapshot 事件流建立时推送的任务快照
+type taskSnapshot struct {
+	TaskID     string                     `json:"task_id"`
+	Status     string                     `json:"status"`
+	Terminated bool                       `json:"terminated"`
+	Hosts      []*apimodels.CommandOutput `json:"hosts"`
+	Timestamp  time.Time                  `json:"timestamp"`
+}
+
+// taskEventStream 任务事件的 Server-Sent Events 写入器
+type taskEventStream struct {
+	c *gin.Context
+	// sent 记录快照中各主机输出流已推送的字节数，用于裁剪与快照重叠的输出事件
+	sent map[string]int
+}
+
+// newTaskEventStream 设置事件流响应头并创建写入器
+func newTaskEventStream(c *gin.Context) *taskEventStream {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	return &taskEventStream{c: c, sent: make(map[string]int)}
+}
+
+// sendSnapshot 推送任务快照，快照不带事件ID，不影响客户端的 Last-Event-ID
+func (s *taskEventStream) sendSnapshot(task *apimodels.Task, outputs []*apimodels.CommandOutput) {
+	for _, output := range outputs {
+		s.sent[outputKey(output.CommandID, output.HostID, apimodels.OutputStreamStdout)] = output.StdoutOffset
+		s.sent[outputKey(output.CommandID, output.HostID, apimodels.OutputStreamStderr)] = output.StderrOffset
+	}
+
+	s.write("", "snapshot", &taskSnapshot{
+		TaskID:     task.TaskID,
+		Status:     string(task.Status),
+		Terminated: task.IsCompleted(),
+		Hosts:      outputs,
+		Timestamp:  time.Now(),
+	})
+}
+
+// sendEvent 推送任务事件，返回 false 表示任务已结束，应关闭事件流
+func (s *taskEventStream) sendEvent(event *service.TaskEvent) bool {
+	if event.Type == service.TaskEventOutput {
+		if event = s.trimOutput(event); event == nil {
+			return true
+		}
+	}
+
+	s.write(event.ID, event.Type, event)
+	return !(event.Type == service.TaskEventTask && event.Terminated)
+}
+
+// sendComment 推送注释行，用于保持连接
+func (s *taskEventStream) sendComment(comment string) {
+	fmt.Fprintf(s.c.Writer, ": %s\n\n", comment)
+	s.c.Writer.Flush()
+}
+
+// trimOutput 裁剪已包含在快照中的输出，返回 nil 表示整个分片都已推送过
+// 事件由所有订阅方共享，裁剪时返回副本
+func (s *taskEventStream) trimOutput(event *service.TaskEvent) *service.TaskEvent {
+	key := outputKey(event.CommandID, event.HostID, event.Stream)
+	sent, tracked := s.sent[key]
+	if !tracked {
+		return event
+	}
+
+	end := event.Offset + len(event.Data)
+	if end <= sent {
+		return nil
+	}
+	s.sent[key] = end
+	if event.Offset >= sent {
+		return event
+	}
+
+	trimmed := *event
+	trimmed.Data = event.Data[sent-event.Offset:]
+	trimmed.Offset = sent
+	return &trimmed
+}
+
+// write 写入一条 SSE 消息
+func (s *taskEventStream) write(id, eventType string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	if id != "" {
+		fmt.Fprintf(s.c.Writer, "id: %s\n", id)
+	}
+	fmt.Fprintf(s.c.Writer, "event: %s\ndata: %s\n\n", eventType, payload)
+	s.c.Writer.Flush()
+}
+
+// outputKey 生成主机输出流的标识
+func outputKey(commandID, hostID, stream string) string {
+	return commandID + "/" + hostID + "/" + stream
+}
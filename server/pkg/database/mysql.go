@@ -89,6 +89,11 @@ func autoMigrate() error {
 		&models.Command{},
 		&models.CommandHost{},
 		&models.CommandResult{},
+		&models.HostCertificate{},
+		&models.User{},
+		&models.APIToken{},
+		&models.UpgradeCampaign{},
+		&models.UpgradeCampaignHost{},
 		// 审计和日志相关表将在 TaskService 初始化时创建
 	)
 }
@@ -1,5 +1,9 @@
 package models
 
+import (
+	apimodels "devops-manager/api/models"
+)
+
 // APIResponse 标准API响应结构
 type APIResponse struct {
 	Success      bool        `json:"success" example:"true"`
@@ -64,12 +68,77 @@ type TaskListResponse struct {
 
 // CreateTaskRequest 创建任务请求
 type CreateTaskRequest struct {
-	Name        string   `json:"name" example:"执行脚本任务" binding:"required"`
-	Description string   `json:"description" example:"在指定主机上执行部署脚本"`
-	HostIDs     []string `json:"host_ids" example:"agent-host-001,agent-host-002" binding:"required"`
-	Command     string   `json:"command" example:"bash deploy.sh" binding:"required"`
-	Timeout     int      `json:"timeout" example:"300"`
-	Parameters  string   `json:"parameters"`
+	Name        string                      `json:"name" example:"执行脚本任务" binding:"required"`
+	Description string                      `json:"description" example:"在指定主机上执行部署脚本"`
+	HostIDs     []string                    `json:"host_ids" example:"agent-host-001,agent-host-002" binding:"required"`
+	Command     string                      `json:"command" example:"bash deploy.sh"` // 与 script、file、fetch 只能指定其一
+	Script      *CreateTaskScript           `json:"script"`                           // 脚本模式
+	File        *CreateTaskFile             `json:"file"`                             // 文件分发模式
+	Fetch       *CreateTaskFetch            `json:"fetch"`                            // 文件收集模式
+	Timeout     int                         `json:"timeout" example:"300"`
+	Parameters  string                      `json:"parameters"`
+	Options     *apimodels.ExecutionOptions `json:"options"` // 执行用户、工作目录、环境变量等执行选项
+}
+
+// CreateTaskScript 脚本任务内容
+type CreateTaskScript struct {
+	Interpreter string   `json:"interpreter" example:"bash" binding:"required"` // bash、sh、python3 或 perl
+	Body        string   `json:"body" example:"echo hello" binding:"required"`
+	Args        []string `json:"args" example:"--env,production"`
+}
+
+// CreateTaskFile 文件分发任务内容，文件需先通过 /files 上传
+type CreateTaskFile struct {
+	SHA256   string `json:"sha256" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" binding:"required"`
+	DestPath string `json:"dest_path" example:"/etc/app/app.conf" binding:"required"`
+	Owner    string `json:"owner" example:"app"` // 属主，为空时不修改
+	Group    string `json:"group" example:"app"` // 属组，为空时使用属主的主组
+	Mode     string `json:"mode" example:"0644"` // 八进制权限，为空时为 0644
+	Name     string `json:"name" example:"app.conf"`
+	Peer     bool   `json:"peer" example:"false"` // 对等分发，同区域的 Agent 之间互相提供已接收的分片
+
+	BandwidthLimit int64 `json:"bandwidth_limit" example:"10485760"` // 每台主机的限速（字节/秒），为 0 时只受服务端配置限制
+}
+
+// CreateTaskFetch 文件收集任务内容
+type CreateTaskFetch struct {
+	Patterns      []string `json:"patterns" example:"/var/log/app/*.log" binding:"required"` // 文件路径或通配符（绝对路径）
+	MaxFileBytes  int64    `json:"max_file_bytes" example:"104857600"`                       // 单个文件上限，超过时跳过，为 0 时不限制
+	MaxTotalBytes int64    `json:"max_total_bytes" example:"524288000"`                      // 每台主机的总字节数上限，为 0 时使用服务端上限
+
+	BandwidthLimit int64 `json:"bandwidth_limit" example:"10485760"` // 每台主机的限速（字节/秒），为 0 时只受服务端配置限制
+}
+
+// CollectedFileResponse 主机回传的文件
+type CollectedFileResponse struct {
+	Path    string `json:"path" example:"/var/log/app/app.log"`
+	Size    int64  `json:"size" example:"1024"`
+	ModTime string `json:"mod_time" example:"2024-01-01T00:00:00Z"`
+}
+
+// FileUploadResponse 文件上传响应
+type FileUploadResponse struct {
+	SHA256 string `json:"sha256" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+	Size   int64  `json:"size" example:"1024"`
+	Name   string `json:"name" example:"app.conf"`
+}
+
+// CreateUpgradeRequest 创建 Agent 升级计划请求，新版本二进制需先通过 /files 上传
+type CreateUpgradeRequest struct {
+	Version          string   `json:"version" example:"1.1.0" binding:"required"` // 新版本执行 -version 输出的版本号须与之一致
+	SHA256           string   `json:"sha256" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" binding:"required"`
+	Signature        string   `json:"signature"`                                                           // 二进制内容的 Ed25519 签名（Base64），Agent 配置了公钥时必须提供
+	HostIDs          []string `json:"host_ids" example:"agent-host-001,agent-host-002" binding:"required"` // 按顺序分批
+	BatchSize        int      `json:"batch_size" example:"5"`                                              // 每批主机数，为 0 时每批 1 台
+	MaxFailures      int      `json:"max_failures" example:"0"`                                            // 允许失败的主机数，超过时停止后续批次
+	ReconnectTimeout int      `json:"reconnect_timeout" example:"300"`                                     // 新版本连上 Server 的期限（秒），为 0 时使用服务端配置
+	Timeout          int      `json:"timeout" example:"600"`                                               // 下载和校验的超时时间（秒），为 0 时使用服务端配置
+}
+
+// CommandControlRequest 命令控制请求
+type CommandControlRequest struct {
+	Action string `json:"action" example:"pause" binding:"required"` // cancel、signal、pause 或 resume
+	Signal int32  `json:"signal" example:"15"`                       // signal 动作发送的信号编号
 }
 
 // HostRegisterRequest 主机注册请求
@@ -79,3 +148,34 @@ type HostRegisterRequest struct {
 	OS       string            `json:"os" example:"linux"`
 	Tags     map[string]string `json:"tags"`
 }
+
+// CreateUserRequest 创建用户请求
+type CreateUserRequest struct {
+	Username  string              `json:"username" example:"alice" binding:"required"`
+	Password  string              `json:"password" example:"s3cret" binding:"required"`
+	Role      string              `json:"role" example:"operator" binding:"required"`
+	HostScope apimodels.HostScope `json:"host_scope"` // 可操作主机的标签范围，如 [{"key":"team","value":"payments"}]，为空不限制
+}
+
+// UpdateUserRequest 更新用户请求，未提供的字段保持不变
+type UpdateUserRequest struct {
+	Role      string               `json:"role" example:"viewer"`
+	Enabled   *bool                `json:"enabled" example:"true"`
+	Password  string               `json:"password"`
+	HostScope *apimodels.HostScope `json:"host_scope"` // 提供空数组表示取消限制
+}
+
+// CreateTokenRequest 创建 API 令牌请求
+type CreateTokenRequest struct {
+	Name          string `json:"name" example:"ci-pipeline" binding:"required"`
+	ExpiresInDays int    `json:"expires_in_days" example:"90"` // 0 表示永不过期
+}
+
+// CreateTokenResponse 创建 API 令牌响应，明文令牌只返回一次
+type CreateTokenResponse struct {
+	Token     string `json:"token" example:"dm_3f2a..."`
+	ID        uint   `json:"id" example:"1"`
+	Name      string `json:"name" example:"ci-pipeline"`
+	Prefix    string `json:"prefix" example:"dm_3f2a9c1b"`
+	ExpiresAt string `json:"expires_at,omitempty" example:"2024-04-01T00:00:00Z"`
+}
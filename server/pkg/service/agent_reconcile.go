@@ -0,0 +1,257 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"devops-manager/api/models"
+
+	"gorm.io/gorm"
+)
+
+// errMsgCommandLost Agent 重连后不再知道的执行中命令
+const errMsgCommandLost = "Command lost by agent after reconnect"
+
+// reconnectGracePeriod Agent 断开后等待重连的时长，超时仍未对账则将其命令标记为失败
+var reconnectGracePeriod = 60 * time.Second
+
+// disconnectTimers 等待重连的主机，按主机ID索引
+var disconnectTimers = struct {
+	sync.Mutex
+	timers map[string]*time.Timer
+}{timers: make(map[string]*time.Timer)}
+
+// SetReconnectGracePeriod 设置 Agent 断开后等待重连的时长，不大于 0 时断开即标记命令失败
+func SetReconnectGracePeriod(period time.Duration) {
+	reconnectGracePeriod = period
+}
+
+// scheduleDisconnectFailure 主机断开后开始计时，宽限期内未重连对账则将断开前下发的命令标记为失败
+func (ts *TaskService) scheduleDisconnectFailure(hostID string) {
+	disconnectedAt := time.Now()
+	fail := func() {
+		if err := ts.failDisconnectedCommands(hostID, disconnectedAt); err != nil {
+			log.Printf("Failed to handle disconnection of host %s: %v", hostID, err)
+		}
+	}
+
+	if reconnectGracePeriod <= 0 {
+		fail()
+		return
+	}
+
+	disconnectTimers.Lock()
+	defer disconnectTimers.Unlock()
+
+	// 重复断开时保留最早的计时
+	if _, exists := disconnectTimers.timers[hostID]; exists {
+		return
+	}
+	disconnectTimers.timers[hostID] = time.AfterFunc(reconnectGracePeriod, func() {
+		disconnectTimers.Lock()
+		delete(disconnectTimers.timers, hostID)
+		disconnectTimers.Unlock()
+		fail()
+	})
+	log.Printf("Host %s disconnected, waiting %v for reconnect before failing its commands", hostID, reconnectGracePeriod)
+}
+
+// cancelDisconnectFailure 主机重连对账后停止计时，返回是否处于宽限期内
+func cancelDisconnectFailure(hostID string) bool {
+	disconnectTimers.Lock()
+	defer disconnectTimers.Unlock()
+
+	timer, exists := disconnectTimers.timers[hostID]
+	if !exists {
+		return false
+	}
+	timer.Stop()
+	delete(disconnectTimers.timers, hostID)
+	return true
+}
+
+// dispatchedCommandsQuery 已启动任务中的命令，未启动任务的待执行命令不受连接状态影响
+func dispatchedCommandsQuery(tx *gorm.DB) *gorm.DB {
+	return tx.Model(&models.Command{}).
+		Select("command_id").
+		Where("task_id IN (?)", tx.Model(&models.Task{}).Select("task_id").Where("status = ?", models.TaskStatusRunning))
+}
+
+// ReconcileAgent 根据 Agent 重连后上报的命令对账
+// running 为 Agent 正在执行或排队的命令，finished 为已完成但结果未被确认的命令（结果随后重发）；
+// 只处理 connectedAt 之前下发的命令：Agent 正在执行的恢复为运行中，从未收到的重新投递，
+// Agent 已不知道的执行中命令标记为失败。
+// deliveryAck 表示 Agent 支持投递确认并按命令ID去重：此时以投递确认判断 Agent 是否收到过命令，
+// 未确认投递的命令总是重新投递；否则只重新下发仍处于待执行状态的命令
+func (ts *TaskService) ReconcileAgent(hostID string, running, finished []string, connectedAt time.Time, deliveryAck bool) error {
+	inGrace := cancelDisconnectFailure(hostID)
+
+	runningSet := make(map[string]bool, len(running))
+	for _, id := range running {
+		runningSet[id] = true
+	}
+	finishedSet := make(map[string]bool, len(finished))
+	for _, id := range finished {
+		finishedSet[id] = true
+	}
+
+	var resumed, redispatch, lost []string
+	affectedTasks := make(map[string]bool)
+
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
+		var commandHosts []models.CommandHost
+		err := tx.Where("host_id = ? AND updated_at < ? AND command_id IN (?)", hostID, connectedAt, dispatchedCommandsQuery(tx)).
+			Where("(status IN (?) OR (status = ? AND error_message IN (?)))",
+				[]string{string(models.CommandHostStatusPending), string(models.CommandHostStatusRunning)},
+				string(models.CommandHostStatusFailed),
+				[]string{errMsgHostConnectionLost, errMsgAgentDisconnected}).
+			Find(&commandHosts).Error
+		if err != nil {
+			return fmt.Errorf("failed to get commands of host %s: %w", hostID, err)
+		}
+
+		for _, ch := range commandHosts {
+			switch {
+			case runningSet[ch.CommandID]:
+				if ch.Status != string(models.CommandHostStatusRunning) {
+					resumed = append(resumed, ch.CommandID)
+				}
+			case finishedSet[ch.CommandID]:
+				// 结果随后从 Agent 发件箱重发
+			case ch.Status == string(models.CommandHostStatusFailed):
+				// 断开时已标记为失败且 Agent 未上报的命令保持失败
+			case deliveryAck && ch.DeliveredAt == nil,
+				!deliveryAck && ch.Status == string(models.CommandHostStatusPending):
+				redispatch = append(redispatch, ch.CommandID)
+			default:
+				lost = append(lost, ch.CommandID)
+			}
+		}
+
+		now := time.Now()
+		if len(resumed) > 0 {
+			err = tx.Model(&models.CommandHost{}).
+				Where("command_id IN (?) AND host_id = ?", resumed, hostID).
+				Updates(map[string]interface{}{
+					"status":        string(models.CommandHostStatusRunning),
+					"finished_at":   nil,
+					"error_message": "",
+					"updated_at":    now,
+				}).Error
+			if err != nil {
+				return fmt.Errorf("failed to resume command hosts: %w", err)
+			}
+			err = tx.Model(&models.Command{}).
+				Where("command_id IN (?)", resumed).
+				Updates(map[string]interface{}{
+					"status":      models.CommandStatusRunning,
+					"finished_at": nil,
+					"error_msg":   "",
+					"updated_at":  now,
+				}).Error
+			if err != nil {
+				return fmt.Errorf("failed to resume commands: %w", err)
+			}
+		}
+
+		if len(lost) > 0 {
+			err = tx.Model(&models.CommandHost{}).
+				Where("command_id IN (?) AND host_id = ?", lost, hostID).
+				Updates(map[string]interface{}{
+					"status":        string(models.CommandHostStatusExecFailed),
+					"finished_at":   now,
+					"error_message": errMsgCommandLost,
+					"updated_at":    now,
+				}).Error
+			if err != nil {
+				return fmt.Errorf("failed to fail lost command hosts: %w", err)
+			}
+			err = tx.Model(&models.Command{}).
+				Where("command_id IN (?)", lost).
+				Updates(map[string]interface{}{
+					"status":      models.CommandStatusFailed,
+					"finished_at": now,
+					"error_msg":   errMsgCommandLost,
+					"updated_at":  now,
+				}).Error
+			if err != nil {
+				return fmt.Errorf("failed to fail lost commands: %w", err)
+			}
+		}
+
+		changed := append(append([]string{}, resumed...), lost...)
+		if len(changed) == 0 {
+			return nil
+		}
+		var taskIDs []string
+		err = tx.Model(&models.Command{}).
+			Where("command_id IN (?) AND task_id IS NOT NULL", changed).
+			Distinct().Pluck("task_id", &taskIDs).Error
+		if err != nil {
+			return fmt.Errorf("failed to get affected tasks: %w", err)
+		}
+		for _, taskID := range taskIDs {
+			affectedTasks[taskID] = true
+			if err := ts.updateTaskProgressInTransaction(tx, taskID); err != nil {
+				log.Printf("Failed to update task progress for task %s: %v", taskID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// 重新投递 Agent 未确认收到的命令
+	for _, commandID := range redispatch {
+		ts.redispatchCommand(commandID)
+	}
+
+	for taskID := range affectedTasks {
+		if err := ts.cacheService.InvalidateTaskCache(taskID); err != nil {
+			log.Printf("Failed to invalidate task cache: %v", err)
+		}
+	}
+
+	log.Printf("Reconciled host %s (within grace period: %v): %d running, %d finished, %d resumed, %d redispatched, %d lost",
+		hostID, inGrace, len(running), len(finished), len(resumed), len(redispatch), len(lost))
+
+	if len(resumed) > 0 || len(redispatch) > 0 || len(lost) > 0 {
+		go func() {
+			details := map[string]interface{}{
+				"connection_status": "reconciled",
+				"resumed":           resumed,
+				"redispatched":      redispatch,
+				"lost":              lost,
+			}
+			if err := ts.auditService.LogHostAction(AuditActionHostConnected, hostID, details); err != nil {
+				log.Printf("Failed to log host reconciliation audit: %v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// redispatchCommand 重新向 Agent 下发命令
+func (ts *TaskService) redispatchCommand(commandID string) {
+	if taskDispatcher == nil {
+		return
+	}
+
+	var command models.Command
+	if err := ts.db.Where("command_id = ?", commandID).First(&command).Error; err != nil {
+		log.Printf("Failed to load command %s for redispatch: %v", commandID, err)
+		return
+	}
+
+	go func() {
+		if err := taskDispatcher.SendCommandToAgent(command.HostID, &command); err != nil {
+			log.Printf("Failed to redispatch command %s to agent %s: %v", commandID, command.HostID, err)
+			ts.updateCommandDispatchFailed(commandID, err.Error())
+		} else {
+			log.Printf("Command %s redispatched to agent %s", commandID, command.HostID)
+		}
+	}()
+}
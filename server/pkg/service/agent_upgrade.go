@@ -0,0 +1,724 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"devops-manager/api/models"
+	"devops-manager/server/pkg/config"
+	"devops-manager/server/pkg/database"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// upgradeReconnectGrace Agent 回滚到旧版本并重新连接所需的时间，Server 在 Agent 的回滚期限之后再等待该时长才判定失败
+const upgradeReconnectGrace = 2 * time.Minute
+
+var (
+	// ErrInvalidUpgrade 升级计划参数不合法
+	ErrInvalidUpgrade = errors.New("invalid upgrade campaign")
+	// ErrUpgradeCampaignNotFound 升级计划不存在
+	ErrUpgradeCampaignNotFound = errors.New("upgrade campaign not found")
+	// ErrUpgradeCampaignFinished 升级计划已结束
+	ErrUpgradeCampaignFinished = errors.New("upgrade campaign is already finished")
+)
+
+// UpgradeCampaignOptions 创建升级计划的参数，新版本二进制需已上传到文件存储
+type UpgradeCampaignOptions struct {
+	Version          string
+	SHA256           string
+	Signature        string
+	HostIDs          []string
+	BatchSize        int           // 每批升级的主机数，为 0 时每批 1 台
+	MaxFailures      int           // 允许失败的主机数，超过时不再开始后续批次
+	ReconnectTimeout time.Duration // 为 0 时使用 upgrade.reconnect_timeout
+	Timeout          time.Duration // 为 0 时使用 upgrade.timeout
+	CreatedBy        string
+	Scope            models.HostScope
+}
+
+// UpgradeService Agent 升级计划：目标主机按批次下发升级命令，Agent 下载并校验新版本后切换二进制重启；
+// 以新版本重新连接视为成功，未在期限内重新连接或以旧版本重新连接（Agent 已回滚）视为失败，失败数超过上限时停止后续批次
+type UpgradeService struct {
+	db               *gorm.DB
+	taskService      *TaskService
+	checkInterval    time.Duration
+	reconnectTimeout time.Duration
+	timeout          time.Duration
+	kick             chan struct{}
+	// 推进批次、处理 Agent 重连和取消计划互斥执行
+	mutex sync.Mutex
+}
+
+var upgradeService *UpgradeService
+
+// InitUpgradeService 根据配置初始化升级计划服务，并继续推进未结束的计划
+func InitUpgradeService(cfg *config.UpgradeConfig) {
+	upgradeService = &UpgradeService{
+		db:               database.GetDB(),
+		taskService:      GetTaskService(),
+		checkInterval:    cfg.CheckInterval,
+		reconnectTimeout: cfg.ReconnectTimeout,
+		timeout:          cfg.Timeout,
+		kick:             make(chan struct{}, 1),
+	}
+	go upgradeService.run()
+}
+
+// GetUpgradeService 获取升级计划服务，未初始化时返回 nil
+func GetUpgradeService() *UpgradeService {
+	return upgradeService
+}
+
+// CreateCampaign 创建升级计划，已是目标版本的主机被跳过，其余主机按 host_ids 的顺序分批
+func (us *UpgradeService) CreateCampaign(opts UpgradeCampaignOptions) (*models.UpgradeCampaign, error) {
+	if opts.ReconnectTimeout <= 0 {
+		opts.ReconnectTimeout = us.reconnectTimeout
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = us.timeout
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1
+	}
+	spec := &models.UpgradeSpec{
+		Version:          opts.Version,
+		Signature:        opts.Signature,
+		ReconnectTimeout: int64(opts.ReconnectTimeout.Seconds()),
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUpgrade, err)
+	}
+	if !models.IsValidSHA256(opts.SHA256) {
+		return nil, fmt.Errorf("%w: invalid sha256: %s", ErrInvalidUpgrade, opts.SHA256)
+	}
+	if opts.MaxFailures < 0 {
+		return nil, fmt.Errorf("%w: max_failures must not be negative", ErrInvalidUpgrade)
+	}
+	hostIDs := uniqueStrings(opts.HostIDs)
+	if len(hostIDs) == 0 {
+		return nil, fmt.Errorf("%w: at least one host is required", ErrInvalidUpgrade)
+	}
+	if fileStore == nil {
+		return nil, fmt.Errorf("file store is not configured")
+	}
+	stored, err := fileStore.Stat(opts.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	if err := us.taskService.checkHostScope(hostIDs, opts.Scope); err != nil {
+		return nil, err
+	}
+
+	var hosts []models.Host
+	if err := us.db.Where("host_id IN ?", hostIDs).Find(&hosts).Error; err != nil {
+		return nil, fmt.Errorf("failed to query hosts: %w", err)
+	}
+	versions := make(map[string]string, len(hosts))
+	for _, host := range hosts {
+		versions[host.HostID] = host.AgentVersion
+	}
+	var missing []string
+	for _, hostID := range hostIDs {
+		if _, exists := versions[hostID]; !exists {
+			missing = append(missing, hostID)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: hosts not found: %s", ErrInvalidUpgrade, strings.Join(missing, ", "))
+	}
+
+	now := time.Now()
+	campaign := &models.UpgradeCampaign{
+		CampaignID:       "upgrade-" + uuid.New().String(),
+		Version:          opts.Version,
+		SHA256:           stored.SHA256,
+		Size:             stored.Size,
+		Signature:        opts.Signature,
+		BatchSize:        opts.BatchSize,
+		MaxFailures:      opts.MaxFailures,
+		ReconnectTimeout: spec.ReconnectTimeout,
+		Timeout:          int64(opts.Timeout.Seconds()),
+		Status:           models.UpgradeCampaignStatusRunning,
+		TotalHosts:       len(hostIDs),
+		CreatedBy:        opts.CreatedBy,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	campaignHosts := make([]models.UpgradeCampaignHost, 0, len(hostIDs))
+	queued := 0
+	for _, hostID := range hostIDs {
+		host := models.UpgradeCampaignHost{
+			CampaignID:  campaign.CampaignID,
+			HostID:      hostID,
+			Status:      models.UpgradeHostStatusPending,
+			FromVersion: versions[hostID],
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		if versions[hostID] == opts.Version {
+			host.Status = models.UpgradeHostStatusSkipped
+			host.FinishedAt = &now
+		} else {
+			host.Batch = queued/opts.BatchSize + 1
+			queued++
+		}
+		campaignHosts = append(campaignHosts, host)
+	}
+	campaign.TotalBatches = (queued + opts.BatchSize - 1) / opts.BatchSize
+	if queued == 0 {
+		campaign.Status = models.UpgradeCampaignStatusCompleted
+		campaign.FinishedAt = &now
+	}
+
+	err = us.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(campaign).Error; err != nil {
+			return fmt.Errorf("failed to create upgrade campaign: %w", err)
+		}
+		if err := tx.Create(&campaignHosts).Error; err != nil {
+			return fmt.Errorf("failed to create upgrade campaign hosts: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	campaign.Hosts = campaignHosts
+
+	go func() {
+		details := map[string]interface{}{
+			"version":       campaign.Version,
+			"sha256":        campaign.SHA256,
+			"signed":        campaign.Signature != "",
+			"host_ids":      hostIDs,
+			"batch_size":    campaign.BatchSize,
+			"total_batches": campaign.TotalBatches,
+			"max_failures":  campaign.MaxFailures,
+		}
+		if err := us.taskService.auditService.LogUpgradeAction(AuditActionUpgradeCreated, campaign.CampaignID, "", campaign.CreatedBy, details); err != nil {
+			log.Printf("Failed to log upgrade campaign audit: %v", err)
+		}
+	}()
+
+	log.Printf("Upgrade campaign %s created: version %s, %d hosts in %d batches", campaign.CampaignID, campaign.Version, queued, campaign.TotalBatches)
+	us.trigger()
+	return campaign, nil
+}
+
+// GetCampaign 获取升级计划及其主机
+func (us *UpgradeService) GetCampaign(campaignID string) (*models.UpgradeCampaign, error) {
+	var campaign models.UpgradeCampaign
+	if err := us.db.Where("campaign_id = ?", campaignID).First(&campaign).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrUpgradeCampaignNotFound, campaignID)
+		}
+		return nil, fmt.Errorf("failed to get upgrade campaign: %w", err)
+	}
+	if err := us.db.Where("campaign_id = ?", campaignID).Order("batch, id").Find(&campaign.Hosts).Error; err != nil {
+		return nil, fmt.Errorf("failed to get upgrade campaign hosts: %w", err)
+	}
+	return &campaign, nil
+}
+
+// GetCampaigns 分页获取升级计划，按创建时间倒序
+func (us *UpgradeService) GetCampaigns(page, size int, status string) ([]models.UpgradeCampaign, int, error) {
+	query := us.db.Model(&models.UpgradeCampaign{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count upgrade campaigns: %w", err)
+	}
+	var campaigns []models.UpgradeCampaign
+	if err := query.Order("created_at DESC").Offset((page - 1) * size).Limit(size).Find(&campaigns).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to get upgrade campaigns: %w", err)
+	}
+	return campaigns, int(total), nil
+}
+
+// CancelCampaign 取消升级计划：未开始的主机不再升级，取消当前批次尚未完成的升级命令；
+// 已切换到新版本的主机仍按重新连接时的版本记录结果
+func (us *UpgradeService) CancelCampaign(campaignID, operator string) error {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	campaign, err := us.GetCampaign(campaignID)
+	if err != nil {
+		return err
+	}
+	if campaign.IsFinished() {
+		return fmt.Errorf("%w: %s", ErrUpgradeCampaignFinished, campaignID)
+	}
+
+	now := time.Now()
+	tasks := make(map[string]struct{})
+	for _, host := range campaign.Hosts {
+		if host.Status == models.UpgradeHostStatusUpgrading && host.TaskID != "" {
+			tasks[host.TaskID] = struct{}{}
+		}
+	}
+	err = us.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.UpgradeCampaignHost{}).
+			Where("campaign_id = ? AND status IN ?", campaignID, []models.UpgradeHostStatus{models.UpgradeHostStatusPending, models.UpgradeHostStatusUpgrading}).
+			Updates(map[string]interface{}{
+				"status":        models.UpgradeHostStatusCanceled,
+				"error_message": "campaign canceled by " + operator,
+				"finished_at":   now,
+				"updated_at":    now,
+			}).Error
+		if err != nil {
+			return fmt.Errorf("failed to cancel upgrade campaign hosts: %w", err)
+		}
+		return tx.Model(&models.UpgradeCampaign{}).Where("campaign_id = ?", campaignID).Updates(map[string]interface{}{
+			"status":        models.UpgradeCampaignStatusCanceled,
+			"error_message": "canceled by " + operator,
+			"finished_at":   now,
+			"updated_at":    now,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for taskID := range tasks {
+		if err := us.taskService.CancelTask(taskID, operator, nil); err != nil {
+			log.Printf("Failed to cancel upgrade task %s: %v", taskID, err)
+		}
+	}
+	us.logCampaignEnded(campaign, models.UpgradeCampaignStatusCanceled, operator)
+	log.Printf("Upgrade campaign %s canceled by %s", campaignID, operator)
+	return nil
+}
+
+// HandleAgentHello 处理 Agent 建立命令流时声明的版本：更新主机记录的 Agent 版本，
+// 正在升级的主机以目标版本连接时升级成功，已切换新版本的主机以其他版本连接说明 Agent 已回滚
+func (us *UpgradeService) HandleAgentHello(hostID, version string) {
+	if us == nil || version == "" {
+		return
+	}
+	if err := us.db.Model(&models.Host{}).Where("host_id = ?", hostID).Update("agent_version", version).Error; err != nil {
+		log.Printf("Failed to update agent version of host %s: %v", hostID, err)
+	}
+
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	var host models.UpgradeCampaignHost
+	err := us.db.Where("host_id = ? AND status IN ?", hostID, []models.UpgradeHostStatus{models.UpgradeHostStatusUpgrading, models.UpgradeHostStatusRestarting}).
+		Order("id DESC").First(&host).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Failed to get upgrade state of host %s: %v", hostID, err)
+		}
+		return
+	}
+	var campaign models.UpgradeCampaign
+	if err := us.db.Where("campaign_id = ?", host.CampaignID).First(&campaign).Error; err != nil {
+		log.Printf("Failed to get upgrade campaign %s: %v", host.CampaignID, err)
+		return
+	}
+
+	// 结果可能尚未被推进处理，先按升级命令的状态更新
+	if host.Status == models.UpgradeHostStatusUpgrading && version != campaign.Version {
+		us.syncUpgradeCommand(&campaign, &host)
+	}
+
+	switch {
+	case host.Status.IsFinished():
+		// 升级命令已失败
+	case version == campaign.Version:
+		us.finishHost(&campaign, &host, models.UpgradeHostStatusSucceeded, "")
+	case host.Status == models.UpgradeHostStatusRestarting:
+		us.finishHost(&campaign, &host, models.UpgradeHostStatusRolledBack,
+			fmt.Sprintf("agent reconnected with version %s, upgrade to %s was rolled back", version, campaign.Version))
+	default:
+		// 升级命令尚未完成，旧版本因网络中断重新连接
+		return
+	}
+	us.trigger()
+}
+
+// trigger 立即推进升级计划
+func (us *UpgradeService) trigger() {
+	select {
+	case us.kick <- struct{}{}:
+	default:
+	}
+}
+
+// run 定期推进进行中的升级计划
+func (us *UpgradeService) run() {
+	ticker := time.NewTicker(us.checkInterval)
+	defer ticker.Stop()
+	for {
+		us.advanceAll()
+		select {
+		case <-ticker.C:
+		case <-us.kick:
+		}
+	}
+}
+
+// advanceAll 处理等待重连超时的主机，并推进所有进行中的计划
+func (us *UpgradeService) advanceAll() {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	us.expireRestarting()
+
+	var campaigns []models.UpgradeCampaign
+	if err := us.db.Where("status = ?", models.UpgradeCampaignStatusRunning).Find(&campaigns).Error; err != nil {
+		log.Printf("Failed to get running upgrade campaigns: %v", err)
+		return
+	}
+	for i := range campaigns {
+		if err := us.advance(&campaigns[i]); err != nil {
+			log.Printf("Failed to advance upgrade campaign %s: %v", campaigns[i].CampaignID, err)
+		}
+	}
+}
+
+// expireRestarting 已切换新版本但超过期限仍未重新连接的主机判定为失败，包括已取消计划中的主机
+func (us *UpgradeService) expireRestarting() {
+	var hosts []models.UpgradeCampaignHost
+	err := us.db.Where("status = ? AND deadline < ?", models.UpgradeHostStatusRestarting, time.Now()).Find(&hosts).Error
+	if err != nil {
+		log.Printf("Failed to get restarting upgrade hosts: %v", err)
+		return
+	}
+	for i := range hosts {
+		var campaign models.UpgradeCampaign
+		if err := us.db.Where("campaign_id = ?", hosts[i].CampaignID).First(&campaign).Error; err != nil {
+			log.Printf("Failed to get upgrade campaign %s: %v", hosts[i].CampaignID, err)
+			continue
+		}
+		us.finishHost(&campaign, &hosts[i], models.UpgradeHostStatusFailed, "agent did not reconnect after switching to the new version")
+	}
+}
+
+// advance 同步当前批次的升级命令状态；当前批次全部结束后开始下一批，没有待升级的主机时结束计划
+func (us *UpgradeService) advance(campaign *models.UpgradeCampaign) error {
+	var hosts []models.UpgradeCampaignHost
+	if err := us.db.Where("campaign_id = ?", campaign.CampaignID).Order("batch, id").Find(&hosts).Error; err != nil {
+		return fmt.Errorf("failed to get upgrade campaign hosts: %w", err)
+	}
+
+	inFlight := 0
+	for i := range hosts {
+		host := &hosts[i]
+		if host.Status == models.UpgradeHostStatusUpgrading {
+			us.syncUpgradeCommand(campaign, host)
+		}
+		if host.Status == models.UpgradeHostStatusUpgrading || host.Status == models.UpgradeHostStatusRestarting {
+			inFlight++
+		}
+	}
+
+	failed, pending := 0, 0
+	nextBatch := 0
+	for _, host := range hosts {
+		if host.Status.IsFailure() {
+			failed++
+		}
+		if host.Status == models.UpgradeHostStatusPending {
+			pending++
+			if nextBatch == 0 || host.Batch < nextBatch {
+				nextBatch = host.Batch
+			}
+		}
+	}
+
+	// 失败数超过上限时不再开始后续批次，当前批次结束后计划失败
+	if failed > campaign.MaxFailures && pending > 0 {
+		message := fmt.Sprintf("%d hosts failed to upgrade, exceeding max_failures %d", failed, campaign.MaxFailures)
+		now := time.Now()
+		err := us.db.Model(&models.UpgradeCampaignHost{}).
+			Where("campaign_id = ? AND status = ?", campaign.CampaignID, models.UpgradeHostStatusPending).
+			Updates(map[string]interface{}{
+				"status":        models.UpgradeHostStatusCanceled,
+				"error_message": message,
+				"finished_at":   now,
+				"updated_at":    now,
+			}).Error
+		if err != nil {
+			return fmt.Errorf("failed to cancel remaining hosts: %w", err)
+		}
+		us.db.Model(&models.UpgradeCampaign{}).Where("campaign_id = ?", campaign.CampaignID).
+			Updates(map[string]interface{}{"error_message": message, "updated_at": now})
+		campaign.ErrorMessage = message
+		log.Printf("Upgrade campaign %s halted: %s", campaign.CampaignID, message)
+		pending = 0
+	}
+
+	if inFlight > 0 {
+		return nil
+	}
+	if pending > 0 {
+		return us.startBatch(campaign, nextBatch, hosts)
+	}
+
+	status := models.UpgradeCampaignStatusCompleted
+	if failed > campaign.MaxFailures {
+		status = models.UpgradeCampaignStatusFailed
+	}
+	now := time.Now()
+	err := us.db.Model(&models.UpgradeCampaign{}).Where("campaign_id = ?", campaign.CampaignID).Updates(map[string]interface{}{
+		"status":      status,
+		"finished_at": now,
+		"updated_at":  now,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to finish upgrade campaign: %w", err)
+	}
+	us.logCampaignEnded(campaign, status, "")
+	log.Printf("Upgrade campaign %s %s", campaign.CampaignID, status)
+	return nil
+}
+
+// syncUpgradeCommand 按升级命令的执行状态更新主机：命令成功说明新版本已就位，等待 Agent 以新版本重新连接
+func (us *UpgradeService) syncUpgradeCommand(campaign *models.UpgradeCampaign, host *models.UpgradeCampaignHost) {
+	var commandHost models.CommandHost
+	if err := us.db.Where("command_id = ? AND host_id = ?", host.CommandID, host.HostID).First(&commandHost).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			us.finishHost(campaign, host, models.UpgradeHostStatusFailed, "upgrade command not found")
+		} else {
+			log.Printf("Failed to get upgrade command %s: %v", host.CommandID, err)
+		}
+		return
+	}
+
+	switch models.CommandHostStatus(commandHost.Status) {
+	case models.CommandHostStatusPending, models.CommandHostStatusRunning:
+		return
+	case models.CommandHostStatusCompleted:
+		deadline := time.Now().Add(time.Duration(campaign.ReconnectTimeout)*time.Second + upgradeReconnectGrace)
+		err := us.db.Model(&models.UpgradeCampaignHost{}).Where("id = ?", host.ID).Updates(map[string]interface{}{
+			"status":     models.UpgradeHostStatusRestarting,
+			"deadline":   deadline,
+			"updated_at": time.Now(),
+		}).Error
+		if err != nil {
+			log.Printf("Failed to update upgrade state of host %s: %v", host.HostID, err)
+			return
+		}
+		host.Status = models.UpgradeHostStatusRestarting
+		host.Deadline = &deadline
+		log.Printf("Host %s staged agent %s, waiting for it to reconnect", host.HostID, campaign.Version)
+	default:
+		message := commandHost.ErrorMessage
+		if message == "" {
+			message = strings.TrimSpace(commandHost.Stderr)
+		}
+		if message == "" {
+			message = "upgrade command " + commandHost.Status
+		}
+		us.finishHost(campaign, host, models.UpgradeHostStatusFailed, message)
+	}
+}
+
+// startBatch 为一个批次的主机创建并启动升级任务
+func (us *UpgradeService) startBatch(campaign *models.UpgradeCampaign, batch int, hosts []models.UpgradeCampaignHost) error {
+	var hostIDs []string
+	for _, host := range hosts {
+		if host.Status == models.UpgradeHostStatusPending && host.Batch == batch {
+			hostIDs = append(hostIDs, host.HostID)
+		}
+	}
+
+	name := fmt.Sprintf("agent upgrade %s batch %d/%d", campaign.Version, batch, campaign.TotalBatches)
+	file := &models.FileSpec{
+		SHA256: campaign.SHA256,
+		Name:   "agent-" + campaign.Version,
+		Size:   campaign.Size,
+	}
+	upgrade := &models.UpgradeSpec{
+		Version:          campaign.Version,
+		Signature:        campaign.Signature,
+		ReconnectTimeout: campaign.ReconnectTimeout,
+	}
+	task, commandIDs, err := us.taskService.createUpgradeTask(name, campaign.CampaignID, hostIDs, file, upgrade, campaign.Timeout, campaign.CreatedBy)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = us.db.Transaction(func(tx *gorm.DB) error {
+		for _, hostID := range hostIDs {
+			err := tx.Model(&models.UpgradeCampaignHost{}).
+				Where("campaign_id = ? AND host_id = ?", campaign.CampaignID, hostID).
+				Updates(map[string]interface{}{
+					"status":     models.UpgradeHostStatusUpgrading,
+					"task_id":    task.TaskID,
+					"command_id": commandIDs[hostID],
+					"started_at": now,
+					"updated_at": now,
+				}).Error
+			if err != nil {
+				return fmt.Errorf("failed to update upgrade campaign host: %w", err)
+			}
+		}
+		return tx.Model(&models.UpgradeCampaign{}).Where("campaign_id = ?", campaign.CampaignID).
+			Updates(map[string]interface{}{"current_batch": batch, "updated_at": now}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	// 下发失败的命令在下一次推进时记为主机升级失败
+	if err := us.taskService.StartTask(task.TaskID, campaign.CreatedBy, nil); err != nil {
+		return fmt.Errorf("failed to start upgrade task %s: %w", task.TaskID, err)
+	}
+	log.Printf("Upgrade campaign %s started batch %d/%d with %d hosts (task %s)", campaign.CampaignID, batch, campaign.TotalBatches, len(hostIDs), task.TaskID)
+	return nil
+}
+
+// finishHost 记录主机的升级结果并更新计划的成功和失败数
+func (us *UpgradeService) finishHost(campaign *models.UpgradeCampaign, host *models.UpgradeCampaignHost, status models.UpgradeHostStatus, message string) {
+	now := time.Now()
+	err := us.db.Model(&models.UpgradeCampaignHost{}).Where("id = ?", host.ID).Updates(map[string]interface{}{
+		"status":        status,
+		"error_message": message,
+		"finished_at":   now,
+		"updated_at":    now,
+	}).Error
+	if err != nil {
+		log.Printf("Failed to update upgrade state of host %s: %v", host.HostID, err)
+		return
+	}
+	host.Status = status
+	host.ErrorMessage = message
+	host.FinishedAt = &now
+
+	var succeeded, failed int64
+	us.db.Model(&models.UpgradeCampaignHost{}).
+		Where("campaign_id = ? AND status = ?", campaign.CampaignID, models.UpgradeHostStatusSucceeded).Count(&succeeded)
+	us.db.Model(&models.UpgradeCampaignHost{}).
+		Where("campaign_id = ? AND status IN ?", campaign.CampaignID, []models.UpgradeHostStatus{models.UpgradeHostStatusFailed, models.UpgradeHostStatusRolledBack}).Count(&failed)
+	us.db.Model(&models.UpgradeCampaign{}).Where("campaign_id = ?", campaign.CampaignID).Updates(map[string]interface{}{
+		"succeeded_hosts": succeeded,
+		"failed_hosts":    failed,
+		"updated_at":      now,
+	})
+
+	if message != "" {
+		log.Printf("Upgrade of host %s to %s %s: %s", host.HostID, campaign.Version, status, message)
+	} else {
+		log.Printf("Upgrade of host %s to %s %s", host.HostID, campaign.Version, status)
+	}
+	go func() {
+		details := map[string]interface{}{
+			"version":      campaign.Version,
+			"from_version": host.FromVersion,
+			"status":       status,
+			"batch":        host.Batch,
+		}
+		if message != "" {
+			details["error_message"] = message
+		}
+		if err := us.taskService.auditService.LogUpgradeAction(AuditActionUpgradeHost, campaign.CampaignID, host.HostID, campaign.CreatedBy, details); err != nil {
+			log.Printf("Failed to log upgrade host audit: %v", err)
+		}
+	}()
+}
+
+// logCampaignEnded 记录计划结束的审计日志，operator 为空时记为计划创建者
+func (us *UpgradeService) logCampaignEnded(campaign *models.UpgradeCampaign, status models.UpgradeCampaignStatus, operator string) {
+	if operator == "" {
+		operator = campaign.CreatedBy
+	}
+	go func() {
+		details := map[string]interface{}{
+			"version": campaign.Version,
+			"status":  status,
+		}
+		if campaign.ErrorMessage != "" {
+			details["error_message"] = campaign.ErrorMessage
+		}
+		if err := us.taskService.auditService.LogUpgradeAction(AuditActionUpgradeEnded, campaign.CampaignID, "", operator, details); err != nil {
+			log.Printf("Failed to log upgrade campaign audit: %v", err)
+		}
+	}()
+}
+
+// createUpgradeTask 为一个批次创建升级任务，返回任务及各主机的命令ID；新版本二进制作为文件随命令分发，目标路径由 Agent 决定
+func (ts *TaskService) createUpgradeTask(name, description string, hostIDs []string, file *models.FileSpec, upgrade *models.UpgradeSpec, timeout int64, createdBy string) (*models.Task, map[string]string, error) {
+	now := time.Now()
+	task := &models.Task{
+		TaskID:      "task-" + uuid.New().String(),
+		Name:        name,
+		Description: description,
+		CreatedBy:   createdBy,
+		Status:      models.TaskStatusPending,
+		TotalHosts:  len(hostIDs),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	commandIDs := make(map[string]string, len(hostIDs))
+
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(task).Error; err != nil {
+			return fmt.Errorf("failed to create task: %w", err)
+		}
+		for _, hostID := range hostIDs {
+			commandID := "cmd-" + uuid.New().String()
+			cmd := &models.Command{
+				CommandID:   commandID,
+				TaskID:      &task.TaskID,
+				HostID:      hostID,
+				Command:     upgrade.Description(),
+				File:        file,
+				Upgrade:     upgrade,
+				Timeout:     timeout,
+				RequestedBy: createdBy,
+				Status:      models.CommandStatusPending,
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			}
+			if err := tx.Create(cmd).Error; err != nil {
+				return fmt.Errorf("failed to create command for host %s: %w", hostID, err)
+			}
+			cmdHost := &models.CommandHost{
+				CommandID: commandID,
+				HostID:    hostID,
+				Status:    string(models.CommandHostStatusPending),
+				CreatedAt: now,
+				UpdatedAt: now,
+			}
+			if err := tx.Create(cmdHost).Error; err != nil {
+				return fmt.Errorf("failed to create command host for host %s: %w", hostID, err)
+			}
+			commandIDs[hostID] = commandID
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	go func() {
+		if err := ts.cacheService.InvalidateTaskListCache(); err != nil {
+			log.Printf("Failed to invalidate task list cache: %v", err)
+		}
+	}()
+	return task, commandIDs, nil
+}
+
+// uniqueStrings 去除重复和空字符串，保持原有顺序
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]struct{}, len(values))
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if _, exists := seen[value]; exists || value == "" {
+			continue
+		}
+		seen[value] = struct{}{}
+		result = append(result, value)
+	}
+	return result
+}
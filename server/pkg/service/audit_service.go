@@ -38,8 +38,12 @@ const (
 	AuditActionCommandResult  AuditAction = "command_result"
 	AuditActionCommandTimeout AuditAction = "command_timeout"
 	AuditActionCommandError   AuditAction = "command_error"
+	AuditActionCommandControl AuditAction = "command_control"
 	AuditActionHostConnected  AuditAction = "host_connected"
 	AuditActionHostDisconnect AuditAction = "host_disconnected"
+	AuditActionUpgradeCreated AuditAction = "upgrade_created"
+	AuditActionUpgradeHost    AuditAction = "upgrade_host"
+	AuditActionUpgradeEnded   AuditAction = "upgrade_ended"
 )
 
 // AuditLog 审计日志模型
@@ -182,6 +186,33 @@ func (as *AuditService) LogHostAction(action AuditAction, hostID string, details
 	return nil
 }
 
+// LogUpgradeAction 记录 Agent 升级计划审计日志，hostID 为空时记录整个计划的操作
+func (as *AuditService) LogUpgradeAction(action AuditAction, campaignID, hostID, userID string, details interface{}) error {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("failed to marshal details: %w", err)
+	}
+
+	auditLog := &AuditLog{
+		Action:     string(action),
+		EntityID:   campaignID,
+		EntityType: "upgrade_campaign",
+		HostID:     hostID,
+		UserID:     userID,
+		Details:    detailsJSON,
+		Timestamp:  time.Now(),
+		CreatedAt:  time.Now(),
+	}
+
+	err = as.db.Create(auditLog).Error
+	if err != nil {
+		return fmt.Errorf("failed to create audit log: %w", err)
+	}
+
+	log.Printf("Audit log created: action=%s, campaign_id=%s, host_id=%s", action, campaignID, hostID)
+	return nil
+}
+
 // LogTaskExecution 记录任务执行日志
 func (as *AuditService) LogTaskExecution(taskID, logLevel, message string, details interface{}, hostID, commandID string) error {
 	detailsJSON, err := json.Marshal(details)
@@ -0,0 +1,410 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"devops-manager/api/models"
+	"devops-manager/server/pkg/database"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// apiTokenPrefix API 令牌前缀，便于在日志和配置中识别
+const apiTokenPrefix = "dm_"
+
+// 认证相关错误
+var (
+	ErrInvalidCredentials = &AuthError{Code: "INVALID_CREDENTIALS", Message: "Invalid credentials"}
+	ErrUserNotFound       = &AuthError{Code: "USER_NOT_FOUND", Message: "User not found"}
+	ErrUserExists         = &AuthError{Code: "USER_EXISTS", Message: "User already exists"}
+	ErrInvalidRole        = &AuthError{Code: "INVALID_ROLE", Message: "Invalid role, must be one of viewer, operator, admin"}
+	ErrTokenNotFound      = &AuthError{Code: "TOKEN_NOT_FOUND", Message: "API token not found"}
+	ErrInvalidHostScope   = &AuthError{Code: "INVALID_HOST_SCOPE", Message: "Invalid host scope, every rule requires key and value"}
+)
+
+// AuthError 认证错误
+type AuthError struct {
+	Code    string
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return e.Message
+}
+
+// Principal 已认证的调用方
+type Principal struct {
+	UserID   uint            `json:"user_id"`
+	Username string          `json:"username"`
+	Role     models.UserRole `json:"role"`
+	TokenID  uint            `json:"token_id,omitempty"` // 通过 API 令牌认证时的令牌ID
+
+	// HostScope 可操作的主机范围，为空表示不限制
+	HostScope models.HostScope `json:"host_scope,omitempty"`
+}
+
+// newPrincipal 根据用户构建调用方
+func newPrincipal(user *models.User, tokenID uint) *Principal {
+	return &Principal{
+		UserID:    user.ID,
+		Username:  user.Username,
+		Role:      user.Role,
+		TokenID:   tokenID,
+		HostScope: user.EffectiveHostScope(),
+	}
+}
+
+// AuthService 用户、API 令牌和角色管理
+type AuthService struct {
+	db *gorm.DB
+}
+
+var (
+	authServiceInstance *AuthService
+	authServiceOnce     sync.Once
+)
+
+// GetAuthService 获取认证服务单例
+func GetAuthService() *AuthService {
+	authServiceOnce.Do(func() {
+		authServiceInstance = &AuthService{
+			db: database.GetDB(),
+		}
+	})
+	return authServiceInstance
+}
+
+// EnsureAdmin 系统中没有任何用户时创建初始管理员
+// 密码为空时随机生成并写入权限为 0600 的 passwordFile，日志中只输出文件位置
+func (as *AuthService) EnsureAdmin(username, password, passwordFile string) error {
+	var count int64
+	if err := as.db.Model(&models.User{}).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to count users: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	generated := password == ""
+	if generated {
+		secret, err := randomHex(12)
+		if err != nil {
+			return err
+		}
+		password = secret
+		// 先写入密码文件，写入失败时不创建无人知道密码的管理员
+		if err := writeSecretFile(passwordFile, password); err != nil {
+			return fmt.Errorf("failed to save initial admin password: %w", err)
+		}
+	}
+
+	if _, err := as.CreateUser(username, password, models.UserRoleAdmin, nil); err != nil {
+		return fmt.Errorf("failed to create initial admin: %w", err)
+	}
+
+	if generated {
+		log.Printf("Created initial admin user %q, password saved to %s, change it after first login and delete the file", username, passwordFile)
+	} else {
+		log.Printf("Created initial admin user %q", username)
+	}
+	return nil
+}
+
+// writeSecretFile 将 secret 写入仅属主可读写的文件，文件已存在时覆盖
+func writeSecretFile(path, secret string) error {
+	if path == "" {
+		return fmt.Errorf("no password file configured")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	// 文件已存在时 OpenFile 不会修改其权限
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.WriteString(secret + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// CreateUser 创建用户，hostScope 为空时不限制可操作的主机
+func (as *AuthService) CreateUser(username, password string, role models.UserRole, hostScope models.HostScope) (*models.User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" || password == "" {
+		return nil, fmt.Errorf("username and password are required")
+	}
+	if !role.IsValid() {
+		return nil, ErrInvalidRole
+	}
+	if err := validateHostScope(hostScope); err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := as.db.Model(&models.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("failed to query user: %w", err)
+	}
+	if count > 0 {
+		return nil, ErrUserExists
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	user := &models.User{
+		Username:     username,
+		PasswordHash: string(hash),
+		Role:         role,
+		HostScope:    hostScope,
+		Enabled:      true,
+	}
+	if err := as.db.Create(user).Error; err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	log.Printf("User created: %s (role=%s)", username, role)
+	return user, nil
+}
+
+// ListUsers 获取全部用户
+func (as *AuthService) ListUsers() ([]models.User, error) {
+	var users []models.User
+	if err := as.db.Order("id ASC").Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	return users, nil
+}
+
+// GetUser 获取单个用户
+func (as *AuthService) GetUser(id uint) (*models.User, error) {
+	var user models.User
+	err := as.db.First(&user, id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user: %w", err)
+	}
+	return &user, nil
+}
+
+// UpdateUser 更新用户角色、启用状态、密码或主机范围，零值字段保持不变
+func (as *AuthService) UpdateUser(id uint, role models.UserRole, enabled *bool, password string, hostScope *models.HostScope) (*models.User, error) {
+	user, err := as.GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if role != "" {
+		if !role.IsValid() {
+			return nil, ErrInvalidRole
+		}
+		updates["role"] = role
+	}
+	if enabled != nil {
+		updates["enabled"] = *enabled
+	}
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+		updates["password_hash"] = string(hash)
+	}
+	if hostScope != nil {
+		if err := validateHostScope(*hostScope); err != nil {
+			return nil, err
+		}
+		updates["host_scope"] = *hostScope
+	}
+	if len(updates) == 0 {
+		return user, nil
+	}
+
+	if err := as.db.Model(user).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+
+	log.Printf("User updated: %s", user.Username)
+	return as.GetUser(id)
+}
+
+// DeleteUser 删除用户并吊销其全部 API 令牌
+func (as *AuthService) DeleteUser(id uint) error {
+	user, err := as.GetUser(id)
+	if err != nil {
+		return err
+	}
+
+	err = as.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&models.APIToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", &now).Error; err != nil {
+			return err
+		}
+		return tx.Delete(user).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	log.Printf("User deleted: %s", user.Username)
+	return nil
+}
+
+// AuthenticatePassword 使用用户名和密码认证
+func (as *AuthService) AuthenticatePassword(username, password string) (*Principal, error) {
+	var user models.User
+	err := as.db.Where("username = ?", username).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user: %w", err)
+	}
+
+	if !user.Enabled || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return newPrincipal(&user, 0), nil
+}
+
+// AuthenticateToken 使用 API 令牌认证
+func (as *AuthService) AuthenticateToken(token string) (*Principal, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, ErrInvalidCredentials
+	}
+
+	var record models.APIToken
+	err := as.db.Where("token_hash = ?", hashToken(token)).First(&record).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query token: %w", err)
+	}
+
+	now := time.Now()
+	if !record.IsUsable(now) {
+		return nil, ErrInvalidCredentials
+	}
+
+	user, err := as.GetUser(record.UserID)
+	if err == ErrUserNotFound || (err == nil && !user.Enabled) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := as.db.Model(&record).Update("last_used_at", &now).Error; err != nil {
+		log.Printf("Failed to update last use of token %d: %v", record.ID, err)
+	}
+
+	return newPrincipal(user, record.ID), nil
+}
+
+// CreateToken 为用户创建 API 令牌，ttl 为 0 时永不过期
+// 返回的明文令牌只在创建时可见
+func (as *AuthService) CreateToken(userID uint, name string, ttl time.Duration) (string, *models.APIToken, error) {
+	if _, err := as.GetUser(userID); err != nil {
+		return "", nil, err
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", nil, err
+	}
+	token := apiTokenPrefix + secret
+
+	record := &models.APIToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashToken(token),
+		Prefix:    token[:len(apiTokenPrefix)+8],
+	}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		record.ExpiresAt = &expiresAt
+	}
+
+	if err := as.db.Create(record).Error; err != nil {
+		return "", nil, fmt.Errorf("failed to create token: %w", err)
+	}
+
+	log.Printf("API token %s created for user %d", record.Prefix, userID)
+	return token, record, nil
+}
+
+// ListTokens 获取用户的 API 令牌
+func (as *AuthService) ListTokens(userID uint) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+	if err := as.db.Where("user_id = ?", userID).Order("id DESC").Find(&tokens).Error; err != nil {
+		return nil, fmt.Errorf("failed to query tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// RevokeToken 吊销用户的 API 令牌
+func (as *AuthService) RevokeToken(userID, tokenID uint) error {
+	now := time.Now()
+	result := as.db.Model(&models.APIToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, userID).
+		Update("revoked_at", &now)
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke token: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrTokenNotFound
+	}
+
+	log.Printf("API token %d of user %d revoked", tokenID, userID)
+	return nil
+}
+
+// validateHostScope 校验主机范围规则
+func validateHostScope(scope models.HostScope) error {
+	for _, rule := range scope {
+		if strings.TrimSpace(rule.Key) == "" || rule.Value == "" {
+			return ErrInvalidHostScope
+		}
+	}
+	return nil
+}
+
+// hashToken 计算令牌的 SHA-256 哈希
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex 生成 n 字节随机数的十六进制字符串
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate random secret: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"devops-manager/api/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestAuthService 创建使用测试数据库的认证服务
+func newTestAuthService(t *testing.T) *AuthService {
+	t.Helper()
+	return &AuthService{db: newTestDB(t, &models.User{}, &models.APIToken{})}
+}
+
+// addTestUser 直接写入用户，disabled 为 true 时写入后禁用
+func addTestUser(t *testing.T, as *AuthService, username, password string, role models.UserRole, disabled bool) *models.User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Username: username, PasswordHash: string(hash), Role: role, Enabled: true}
+	if err := as.db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if disabled {
+		if err := as.db.Model(user).Update("enabled", false).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user
+}
+
+func TestEnsureAdminGeneratedPassword(t *testing.T) {
+	as := newTestAuthService(t)
+	passwordFile := filepath.Join(t.TempDir(), "data", "admin_password")
+
+	if err := as.EnsureAdmin("admin", "", passwordFile); err != nil {
+		t.Fatalf("EnsureAdmin failed: %v", err)
+	}
+
+	info, err := os.Stat(passwordFile)
+	if err != nil {
+		t.Fatalf("password file not written: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("password file mode = %o, want 600", perm)
+	}
+	data, err := os.ReadFile(passwordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal, err := as.AuthenticatePassword("admin", strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatalf("generated password does not authenticate: %v", err)
+	}
+	if principal.Role != models.UserRoleAdmin {
+		t.Errorf("initial user role = %s, want admin", principal.Role)
+	}
+
+	// 已有用户时不再创建管理员，也不改写密码文件
+	os.Remove(passwordFile)
+	if err := as.EnsureAdmin("root", "", passwordFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(passwordFile); !os.IsNotExist(err) {
+		t.Errorf("password file rewritten although users exist")
+	}
+}
+
+func TestEnsureAdminConfiguredPassword(t *testing.T) {
+	as := newTestAuthService(t)
+	passwordFile := filepath.Join(t.TempDir(), "admin_password")
+
+	if err := as.EnsureAdmin("admin", "secret", passwordFile); err != nil {
+		t.Fatalf("EnsureAdmin failed: %v", err)
+	}
+	if _, err := os.Stat(passwordFile); !os.IsNotExist(err) {
+		t.Errorf("password file written for a configured password")
+	}
+	if _, err := as.AuthenticatePassword("admin", "secret"); err != nil {
+		t.Errorf("configured password does not authenticate: %v", err)
+	}
+}
+
+func TestEnsureAdminUnwritablePasswordFile(t *testing.T) {
+	as := newTestAuthService(t)
+	// 密码文件的父目录是普通文件，无法写入
+	parent := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(parent, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := as.EnsureAdmin("admin", "", filepath.Join(parent, "admin_password")); err == nil {
+		t.Fatal("EnsureAdmin succeeded without saving the password")
+	}
+	var count int64
+	as.db.Model(&models.User{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d users created although the password could not be saved", count)
+	}
+}
+
+func TestAuthenticatePassword(t *testing.T) {
+	as := newTestAuthService(t)
+	addTestUser(t, as, "alice", "alice-pass", models.UserRoleOperator, false)
+	addTestUser(t, as, "bob", "bob-pass", models.UserRoleViewer, true)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantRole models.UserRole
+		wantErr  bool
+	}{
+		{name: "valid", username: "alice", password: "alice-pass", wantRole: models.UserRoleOperator},
+		{name: "wrong password", username: "alice", password: "bob-pass", wantErr: true},
+		{name: "empty password", username: "alice", password: "", wantErr: true},
+		{name: "unknown user", username: "carol", password: "alice-pass", wantErr: true},
+		{name: "disabled user", username: "bob", password: "bob-pass", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := as.AuthenticatePassword(tt.username, tt.password)
+			if tt.wantErr {
+				if err != ErrInvalidCredentials {
+					t.Fatalf("AuthenticatePassword error = %v, want ErrInvalidCredentials", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AuthenticatePassword failed: %v", err)
+			}
+			if principal.Username != tt.username || principal.Role != tt.wantRole {
+				t.Errorf("principal = %s/%s, want %s/%s", principal.Username, principal.Role, tt.username, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	as := newTestAuthService(t)
+	alice := addTestUser(t, as, "alice", "alice-pass", models.UserRoleOperator, false)
+	bob := addTestUser(t, as, "bob", "bob-pass", models.UserRoleViewer, false)
+
+	newToken := func(userID uint, ttl time.Duration) (string, *models.APIToken) {
+		token, record, err := as.CreateToken(userID, "test", ttl)
+		if err != nil {
+			t.Fatalf("CreateToken failed: %v", err)
+		}
+		return token, record
+	}
+
+	valid, validRecord := newToken(alice.ID, 0)
+	expiring, expiringRecord := newToken(alice.ID, time.Hour)
+	revoked, revokedRecord := newToken(alice.ID, 0)
+	disabled, _ := newToken(bob.ID, 0)
+
+	if err := as.RevokeToken(alice.ID, revokedRecord.ID); err != nil {
+		t.Fatal(err)
+	}
+	// 其他用户不能吊销该令牌
+	if err := as.RevokeToken(bob.ID, validRecord.ID); err != ErrTokenNotFound {
+		t.Errorf("RevokeToken of another user's token = %v, want ErrTokenNotFound", err)
+	}
+	past := time.Now().Add(-time.Minute)
+	if err := as.db.Model(expiringRecord).Update("expires_at", &past).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := as.db.Model(bob).Update("enabled", false).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		token       string
+		wantTokenID uint
+		wantErr     bool
+	}{
+		{name: "valid", token: valid, wantTokenID: validRecord.ID},
+		{name: "missing prefix", token: strings.TrimPrefix(valid, apiTokenPrefix), wantErr: true},
+		{name: "unknown token", token: apiTokenPrefix + strings.Repeat("0", 64), wantErr: true},
+		{name: "expired token", token: expiring, wantErr: true},
+		{name: "revoked token", token: revoked, wantErr: true},
+		{name: "disabled user", token: disabled, wantErr: true},
+		{name: "empty", token: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := as.AuthenticateToken(tt.token)
+			if tt.wantErr {
+				if err != ErrInvalidCredentials {
+					t.Fatalf("AuthenticateToken error = %v, want ErrInvalidCredentials", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AuthenticateToken failed: %v", err)
+			}
+			if principal.UserID != alice.ID || principal.TokenID != tt.wantTokenID {
+				t.Errorf("principal = user %d token %d, want user %d token %d",
+					principal.UserID, principal.TokenID, alice.ID, tt.wantTokenID)
+			}
+		})
+	}
+}
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		role     models.UserRole
+		required models.UserRole
+		want     bool
+	}{
+		{models.UserRoleViewer, models.UserRoleViewer, true},
+		{models.UserRoleViewer, models.UserRoleOperator, false},
+		{models.UserRoleViewer, models.UserRoleAdmin, false},
+		{models.UserRoleOperator, models.UserRoleViewer, true},
+		{models.UserRoleOperator, models.UserRoleOperator, true},
+		{models.UserRoleOperator, models.UserRoleAdmin, false},
+		{models.UserRoleAdmin, models.UserRoleAdmin, true},
+		{models.UserRole("root"), models.UserRoleViewer, false},
+		{models.UserRole(""), models.UserRoleViewer, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.role.Allows(tt.required); got != tt.want {
+			t.Errorf("%q.Allows(%q) = %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,472 @@
+package service
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"devops-manager/api/models"
+	"devops-manager/server/pkg/config"
+	"devops-manager/server/pkg/database"
+
+	"gorm.io/gorm"
+)
+
+// caValidity 自动生成的 CA 证书有效期
+const caValidity = 10 * 365 * 24 * time.Hour
+
+// ErrCANotEnabled 未启用内置 CA
+var ErrCANotEnabled = &HostError{Code: "CA_NOT_ENABLED", Message: "Built-in certificate authority is not enabled"}
+
+// CertificateAuthority 内置证书颁发机构，为准入主机签发客户端证书
+type CertificateAuthority struct {
+	cert            *x509.Certificate
+	key             crypto.Signer
+	certPEM         []byte
+	validity        time.Duration
+	rotationOverlap time.Duration
+	db              *gorm.DB
+
+	mutex    sync.RWMutex
+	revoked  map[string]time.Time // 已吊销证书序列号及吊销生效时间
+	onRevoke func(hostID string)
+}
+
+var certificateAuthority *CertificateAuthority
+
+// InitCertificateAuthority 加载内置 CA，证书和私钥不存在时自动生成
+func InitCertificateAuthority(cfg *config.CAConfig) error {
+	cert, key, certPEM, err := loadOrCreateCA(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	ca := &CertificateAuthority{
+		cert:            cert,
+		key:             key,
+		certPEM:         certPEM,
+		validity:        cfg.CertValidity,
+		rotationOverlap: cfg.RotationOverlap,
+		db:              database.GetDB(),
+		revoked:         make(map[string]time.Time),
+	}
+
+	// 加载未过期的已吊销证书
+	var records []models.HostCertificate
+	if err := ca.db.Select("host_id", "serial_number", "revoked_at").
+		Where("status = ? AND not_after > ?", models.CertificateStatusRevoked, time.Now()).
+		Find(&records).Error; err != nil {
+		return fmt.Errorf("failed to load revoked certificates: %w", err)
+	}
+	for _, record := range records {
+		var revokedAt time.Time
+		if record.RevokedAt != nil {
+			revokedAt = *record.RevokedAt
+		}
+		ca.revoked[record.SerialNumber] = revokedAt
+		// 轮换保留期尚未结束的旧证书，到期后断开仍在使用它的连接
+		if revokedAt.After(time.Now()) {
+			ca.scheduleRevoke(record.HostID, revokedAt)
+		}
+	}
+
+	certificateAuthority = ca
+	log.Printf("Built-in CA loaded: %s (%d revoked certificates)", cert.Subject.CommonName, len(records))
+	return nil
+}
+
+// GetCertificateAuthority 获取内置 CA，未启用时返回 nil
+func GetCertificateAuthority() *CertificateAuthority {
+	return certificateAuthority
+}
+
+// loadOrCreateCA 读取 CA 证书和私钥，文件不存在时生成自签名 CA
+func loadOrCreateCA(certFile, keyFile string) (*x509.Certificate, crypto.Signer, []byte, error) {
+	certPEM, certErr := os.ReadFile(certFile)
+	keyPEM, keyErr := os.ReadFile(keyFile)
+
+	if errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist) {
+		return createCA(certFile, keyFile)
+	}
+	if certErr != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read CA certificate: %w", certErr)
+	}
+	if keyErr != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read CA key: %w", keyErr)
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid CA key pair: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid CA certificate: %w", err)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok || !cert.IsCA {
+		return nil, nil, nil, fmt.Errorf("%s is not a usable CA certificate", certFile)
+	}
+
+	return cert, key, certPEM, nil
+}
+
+// createCA 生成自签名 CA 证书并写入文件
+func createCA(certFile, keyFile string) (*x509.Certificate, crypto.Signer, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "DevOps Manager CA", Organization: []string{"DevOps Manager"}},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	for _, dir := range []string{filepath.Dir(certFile), filepath.Dir(keyFile)} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to create CA directory: %w", err)
+		}
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to write CA key: %w", err)
+	}
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to write CA certificate: %w", err)
+	}
+
+	log.Printf("Generated new built-in CA certificate at %s", certFile)
+	return cert, key, certPEM, nil
+}
+
+// randomSerial 生成 128 位随机证书序列号
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serial, nil
+}
+
+// CertPEM 返回 PEM 格式的 CA 证书
+func (ca *CertificateAuthority) CertPEM() []byte {
+	return ca.certPEM
+}
+
+// CertPool 返回仅包含内置 CA 的证书池
+func (ca *CertificateAuthority) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// ValidateCSR 校验 CSR 签名且 CN 与主机ID一致
+func (ca *CertificateAuthority) ValidateCSR(hostID, csrPEM string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("invalid CSR: no PEM certificate request found")
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSR: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid CSR signature: %w", err)
+	}
+	if csr.Subject.CommonName != hostID {
+		return nil, fmt.Errorf("CSR common name %q does not match host %s", csr.Subject.CommonName, hostID)
+	}
+
+	return csr, nil
+}
+
+// SignCSR 为主机签发客户端证书，主机此前的有效证书在 rotation_overlap 后吊销，留出 Agent 切换到新证书的时间
+func (ca *CertificateAuthority) SignCSR(hostID, csrPEM string) (*models.HostCertificate, error) {
+	csr, err := ca.ValidateCSR(hostID, csrPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hostID},
+		DNSNames:     []string{hostID},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(ca.validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		// 主机证书同时用作对等分发分片服务的服务端证书
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+
+	record := &models.HostCertificate{
+		HostID:       hostID,
+		SerialNumber: serial.Text(16),
+		CertPEM:      string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		Status:       models.CertificateStatusActive,
+		NotBefore:    template.NotBefore,
+		NotAfter:     template.NotAfter,
+	}
+
+	revokedAt := now.Add(ca.rotationOverlap)
+	var previous []string
+	err = ca.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.HostCertificate{}).
+			Where("host_id = ? AND status IN ?", hostID, []models.CertificateStatus{models.CertificateStatusActive, models.CertificateStatusSuperseded}).
+			Pluck("serial_number", &previous).Error; err != nil {
+			return err
+		}
+		if len(previous) > 0 {
+			if err := tx.Model(&models.HostCertificate{}).
+				Where("host_id = ? AND serial_number IN ?", hostID, previous).
+				Updates(map[string]interface{}{"status": models.CertificateStatusRevoked, "revoked_at": &revokedAt}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(record).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save certificate: %w", err)
+	}
+
+	ca.mutex.Lock()
+	for _, serial := range previous {
+		ca.revoked[serial] = revokedAt
+	}
+	ca.mutex.Unlock()
+	if len(previous) > 0 {
+		ca.scheduleRevoke(hostID, revokedAt)
+	}
+
+	log.Printf("Issued certificate %s for host %s, expires at %s", record.SerialNumber, hostID, record.NotAfter.Format(time.RFC3339))
+	if len(previous) > 0 {
+		log.Printf("Previous certificates %v of host %s will be revoked at %s", previous, hostID, revokedAt.Format(time.RFC3339))
+	}
+	return record, nil
+}
+
+// IssueServerCertificate 为 gRPC 服务端签发内存证书
+func (ca *CertificateAuthority) IssueServerCertificate(names []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate server key: %w", err)
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "devops-manager-server"},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(ca.validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to sign server certificate: %w", err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+	}, nil
+}
+
+// CurrentCertificate 获取主机当前有效的证书，没有时返回 nil
+func (ca *CertificateAuthority) CurrentCertificate(hostID string) (*models.HostCertificate, error) {
+	var record models.HostCertificate
+	err := ca.db.Where("host_id = ? AND status = ?", hostID, models.CertificateStatusActive).
+		Order("id DESC").First(&record).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query certificate: %w", err)
+	}
+	return &record, nil
+}
+
+// HasCertificate 检查是否曾为主机签发过证书（包括已取代和已吊销的证书）
+func (ca *CertificateAuthority) HasCertificate(hostID string) (bool, error) {
+	var count int64
+	if err := ca.db.Model(&models.HostCertificate{}).Where("host_id = ?", hostID).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to query certificate: %w", err)
+	}
+	return count > 0, nil
+}
+
+// CertificateMatchesCSR 检查证书与 CSR 是否使用同一公钥
+func (ca *CertificateAuthority) CertificateMatchesCSR(record *models.HostCertificate, csr *x509.CertificateRequest) bool {
+	block, _ := pem.Decode([]byte(record.CertPEM))
+	if block == nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+
+	certKey, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return false
+	}
+	csrKey, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(certKey, csrKey)
+}
+
+// ListCertificates 获取主机的全部证书记录
+func (ca *CertificateAuthority) ListCertificates(hostID string) ([]models.HostCertificate, error) {
+	var records []models.HostCertificate
+	if err := ca.db.Where("host_id = ?", hostID).Order("id DESC").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to query certificates: %w", err)
+	}
+	return records, nil
+}
+
+// RevokeHost 立即吊销主机的全部未吊销证书（包括轮换后尚在保留期内的旧证书），并断开该主机的连接
+func (ca *CertificateAuthority) RevokeHost(hostID string) (int, error) {
+	now := time.Now()
+	var records []models.HostCertificate
+	if err := ca.db.Where("host_id = ? AND (status <> ? OR revoked_at > ?)", hostID, models.CertificateStatusRevoked, now).
+		Find(&records).Error; err != nil {
+		return 0, fmt.Errorf("failed to query certificates: %w", err)
+	}
+
+	if len(records) > 0 {
+		if err := ca.db.Model(&models.HostCertificate{}).
+			Where("host_id = ? AND (status <> ? OR revoked_at > ?)", hostID, models.CertificateStatusRevoked, now).
+			Updates(map[string]interface{}{"status": models.CertificateStatusRevoked, "revoked_at": &now}).Error; err != nil {
+			return 0, fmt.Errorf("failed to revoke certificates: %w", err)
+		}
+	}
+
+	ca.mutex.Lock()
+	for _, record := range records {
+		ca.revoked[record.SerialNumber] = now
+	}
+	ca.mutex.Unlock()
+	ca.notifyRevoke(hostID)
+
+	log.Printf("Revoked %d certificates for host %s", len(records), hostID)
+	return len(records), nil
+}
+
+// IsRevoked 检查证书序列号是否已吊销，轮换后保留期内的旧证书视为未吊销
+func (ca *CertificateAuthority) IsRevoked(serial *big.Int) bool {
+	ca.mutex.RLock()
+	defer ca.mutex.RUnlock()
+	revokedAt, exists := ca.revoked[serial.Text(16)]
+	return exists && !time.Now().Before(revokedAt)
+}
+
+// RevokedSerials 返回吊销已生效的证书序列号（小写十六进制），按序列号排序
+func (ca *CertificateAuthority) RevokedSerials() []string {
+	ca.mutex.RLock()
+	defer ca.mutex.RUnlock()
+	now := time.Now()
+	serials := make([]string, 0, len(ca.revoked))
+	for serial, revokedAt := range ca.revoked {
+		if !now.Before(revokedAt) {
+			serials = append(serials, serial)
+		}
+	}
+	sort.Strings(serials)
+	return serials
+}
+
+// OnRevoke 设置主机证书吊销生效后的回调（用于断开使用已吊销证书的命令流）
+// 主动吊销时立即调用，轮换时在旧证书的保留期结束后调用
+func (ca *CertificateAuthority) OnRevoke(fn func(hostID string)) {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	ca.onRevoke = fn
+}
+
+// notifyRevoke 调用吊销回调
+func (ca *CertificateAuthority) notifyRevoke(hostID string) {
+	ca.mutex.RLock()
+	onRevoke := ca.onRevoke
+	ca.mutex.RUnlock()
+	if onRevoke != nil {
+		onRevoke(hostID)
+	}
+}
+
+// scheduleRevoke 在 revokedAt 吊销生效时调用吊销回调
+func (ca *CertificateAuthority) scheduleRevoke(hostID string, revokedAt time.Time) {
+	time.AfterFunc(time.Until(revokedAt), func() {
+		log.Printf("Rotation overlap of host %s expired, previous certificates revoked", hostID)
+		ca.notifyRevoke(hostID)
+	})
+}
@@ -0,0 +1,295 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"devops-manager/api/models"
+	"devops-manager/server/pkg/config"
+	"devops-manager/server/pkg/database"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB 使用内存 SQLite 替换全局数据库连接并迁移指定模型
+func newTestDB(t *testing.T, tables ...interface{}) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	// 内存数据库每个连接独立，限制为单连接
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = previous
+		sqlDB.Close()
+	})
+	return db
+}
+
+// newTestCA 在临时目录生成内置 CA
+func newTestCA(t *testing.T, overlap time.Duration) *CertificateAuthority {
+	t.Helper()
+	newTestDB(t, &models.HostCertificate{})
+	dir := t.TempDir()
+	err := InitCertificateAuthority(&config.CAConfig{
+		Enabled:         true,
+		CertFile:        filepath.Join(dir, "ca.crt"),
+		KeyFile:         filepath.Join(dir, "ca.key"),
+		CertValidity:    24 * time.Hour,
+		RotationOverlap: overlap,
+	})
+	if err != nil {
+		t.Fatalf("InitCertificateAuthority failed: %v", err)
+	}
+	ca := GetCertificateAuthority()
+	t.Cleanup(func() { certificateAuthority = nil })
+	return ca
+}
+
+// newTestCSR 生成 CN 为 commonName 的 CSR
+func newTestCSR(t *testing.T, commonName string) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+// serialOf 解析证书记录的序列号
+func serialOf(t *testing.T, record *models.HostCertificate) *big.Int {
+	t.Helper()
+	serial, ok := new(big.Int).SetString(record.SerialNumber, 16)
+	if !ok {
+		t.Fatalf("invalid serial number %q", record.SerialNumber)
+	}
+	return serial
+}
+
+func TestValidateCSR(t *testing.T) {
+	ca := newTestCA(t, time.Hour)
+
+	valid := newTestCSR(t, "host-1")
+	block, _ := pem.Decode([]byte(valid))
+	tampered := append([]byte(nil), block.Bytes...)
+	tampered[len(tampered)-1] ^= 0xff
+
+	tests := []struct {
+		name    string
+		hostID  string
+		csrPEM  string
+		wantErr string
+	}{
+		{name: "valid", hostID: "host-1", csrPEM: valid},
+		{name: "common name mismatch", hostID: "host-2", csrPEM: valid, wantErr: "does not match host"},
+		{name: "not pem", hostID: "host-1", csrPEM: "not a csr", wantErr: "no PEM certificate request"},
+		{
+			name:    "wrong pem type",
+			hostID:  "host-1",
+			csrPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: block.Bytes})),
+			wantErr: "no PEM certificate request",
+		},
+		{
+			name:    "bad signature",
+			hostID:  "host-1",
+			csrPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: tampered})),
+			wantErr: "invalid CSR",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ca.ValidateCSR(tt.hostID, tt.csrPEM)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateCSR failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateCSR error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignCSRIssuesClientCertificate(t *testing.T) {
+	ca := newTestCA(t, time.Hour)
+
+	record, err := ca.SignCSR("host-1", newTestCSR(t, "host-1"))
+	if err != nil {
+		t.Fatalf("SignCSR failed: %v", err)
+	}
+	block, _ := pem.Decode([]byte(record.CertPEM))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     ca.CertPool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		t.Fatalf("issued certificate does not verify: %v", err)
+	}
+	if !certificateMatchesHost(cert, "host-1") || certificateMatchesHost(cert, "host-2") {
+		t.Errorf("certificate names = %q %v, want host-1 only", cert.Subject.CommonName, cert.DNSNames)
+	}
+	if ca.IsRevoked(cert.SerialNumber) {
+		t.Errorf("new certificate reported revoked")
+	}
+
+	if _, err := ca.SignCSR("host-2", newTestCSR(t, "host-1")); err == nil {
+		t.Errorf("SignCSR accepted a CSR for another host")
+	}
+}
+
+func TestSignCSRRotation(t *testing.T) {
+	tests := []struct {
+		name        string
+		overlap     time.Duration
+		wantRevoked bool
+	}{
+		{name: "previous certificate usable during overlap", overlap: time.Hour, wantRevoked: false},
+		{name: "previous certificate revoked without overlap", overlap: 0, wantRevoked: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca := newTestCA(t, tt.overlap)
+			first, err := ca.SignCSR("host-1", newTestCSR(t, "host-1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := ca.SignCSR("host-1", newTestCSR(t, "host-1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := ca.IsRevoked(serialOf(t, first)); got != tt.wantRevoked {
+				t.Errorf("previous certificate revoked = %v, want %v", got, tt.wantRevoked)
+			}
+			if ca.IsRevoked(serialOf(t, second)) {
+				t.Errorf("new certificate reported revoked")
+			}
+			current, err := ca.CurrentCertificate("host-1")
+			if err != nil || current == nil || current.SerialNumber != second.SerialNumber {
+				t.Errorf("CurrentCertificate = %v, %v, want %s", current, err, second.SerialNumber)
+			}
+		})
+	}
+}
+
+func TestRotationOverlapExpiryNotifies(t *testing.T) {
+	const overlap = 50 * time.Millisecond
+	ca := newTestCA(t, overlap)
+
+	revoked := make(chan string, 1)
+	ca.OnRevoke(func(hostID string) { revoked <- hostID })
+
+	first, err := ca.SignCSR("host-1", newTestCSR(t, "host-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ca.SignCSR("host-1", newTestCSR(t, "host-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case hostID := <-revoked:
+		if hostID != "host-1" {
+			t.Errorf("OnRevoke called for %s, want host-1", hostID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnRevoke not called after rotation overlap expired")
+	}
+	if !ca.IsRevoked(serialOf(t, first)) {
+		t.Errorf("previous certificate not revoked after overlap")
+	}
+}
+
+func TestRevokeHost(t *testing.T) {
+	ca := newTestCA(t, time.Hour)
+
+	var revokedHosts []string
+	ca.OnRevoke(func(hostID string) { revokedHosts = append(revokedHosts, hostID) })
+
+	// 轮换后保留期内的旧证书和当前证书一并吊销，其他主机不受影响
+	first, err := ca.SignCSR("host-1", newTestCSR(t, "host-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ca.SignCSR("host-1", newTestCSR(t, "host-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ca.SignCSR("host-2", newTestCSR(t, "host-2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := ca.RevokeHost("host-1")
+	if err != nil {
+		t.Fatalf("RevokeHost failed: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("RevokeHost revoked %d certificates, want 2", count)
+	}
+	for _, record := range []*models.HostCertificate{first, second} {
+		if !ca.IsRevoked(serialOf(t, record)) {
+			t.Errorf("certificate %s not revoked", record.SerialNumber)
+		}
+	}
+	if ca.IsRevoked(serialOf(t, other)) {
+		t.Errorf("certificate of another host revoked")
+	}
+	if serials := ca.RevokedSerials(); len(serials) != 2 || !slices.Contains(serials, first.SerialNumber) || !slices.Contains(serials, second.SerialNumber) {
+		t.Errorf("RevokedSerials = %v, want [%s %s]", serials, first.SerialNumber, second.SerialNumber)
+	}
+	if len(revokedHosts) != 1 || revokedHosts[0] != "host-1" {
+		t.Errorf("OnRevoke calls = %v, want [host-1]", revokedHosts)
+	}
+	if current, _ := ca.CurrentCertificate("host-1"); current != nil {
+		t.Errorf("host still has an active certificate %s", current.SerialNumber)
+	}
+
+	// 重启后从数据库加载吊销列表
+	if err := InitCertificateAuthority(&config.CAConfig{
+		CertFile: filepath.Join(t.TempDir(), "ca.crt"),
+		KeyFile:  filepath.Join(t.TempDir(), "ca.key"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !GetCertificateAuthority().IsRevoked(serialOf(t, second)) {
+		t.Errorf("revocation lost after reload")
+	}
+}
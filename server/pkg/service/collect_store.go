@@ -0,0 +1,343 @@
+package service
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"devops-manager/server/pkg/config"
+)
+
+// collectStagingDir 接收中的文件所在目录，位于存储目录下，任务ID不会以 . 开头
+const collectStagingDir = ".staging"
+
+// CollectedFile 文件收集任务从主机回传的文件
+type CollectedFile struct {
+	Path    string    `json:"path"` // 文件在主机上的路径
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"` // 回传完成时间
+}
+
+// CollectStore 文件收集任务回传文件的存储，按任务和主机保存
+type CollectStore interface {
+	// Write 按偏移写入文件分片，offset 为 0 时重新开始接收该文件，eof 为 true 时文件接收完成
+	Write(taskID, hostID, hostPath string, offset int64, data []byte, eof bool) error
+	// List 列出主机已回传完成的文件
+	List(taskID, hostID string) ([]CollectedFile, error)
+	// Open 打开主机回传的文件
+	Open(taskID, hostID, hostPath string) (*os.File, error)
+	// Archive 将任务主机回传的文件以 tar.gz 写入 w，条目路径为 <主机ID>/<文件路径>；hostIDs 为 nil 时包含所有主机
+	Archive(taskID string, hostIDs []string, w io.Writer) error
+	// Remove 删除主机在任务中回传的文件，不存在时不报错
+	Remove(taskID, hostID string) error
+	// RemoveTask 删除任务所有主机回传的文件，不存在时不报错
+	RemoveTask(taskID string) error
+}
+
+// LocalCollectStore 基于本地目录的回传文件存储，文件保存在 <dir>/<任务ID>/<主机ID>/<文件路径>
+type LocalCollectStore struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+var (
+	collectStore    CollectStore
+	collectMaxBytes int64 = 1024 * 1024 * 1024
+)
+
+// InitCollectStore 根据配置初始化回传文件存储
+func InitCollectStore(cfg *config.FilesConfig) error {
+	collectMaxBytes = cfg.CollectMaxBytes
+
+	store, err := NewLocalCollectStore(cfg.CollectDir)
+	if err != nil {
+		return err
+	}
+	collectStore = store
+	log.Printf("File collection store: %s", cfg.CollectDir)
+	return nil
+}
+
+// GetCollectStore 获取回传文件存储，未初始化时返回 nil
+func GetCollectStore() CollectStore {
+	return collectStore
+}
+
+// GetCollectMaxBytes 获取每台主机在一个收集任务中回传的最大字节数
+func GetCollectMaxBytes() int64 {
+	return collectMaxBytes
+}
+
+// NewLocalCollectStore 创建本地目录回传文件存储
+func NewLocalCollectStore(dir string) (*LocalCollectStore, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create collect directory: %w", err)
+	}
+	return &LocalCollectStore{dir: dir}, nil
+}
+
+// Write 分片先写入暂存目录，文件接收完成后移动到主机目录
+func (s *LocalCollectStore) Write(taskID, hostID, hostPath string, offset int64, data []byte, eof bool) error {
+	hostDir, err := s.hostDir(taskID, hostID)
+	if err != nil {
+		return err
+	}
+	rel, err := collectRelPath(hostPath)
+	if err != nil {
+		return err
+	}
+	staging := filepath.Join(s.dir, collectStagingDir, hostDir, rel)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(staging), 0750); err != nil {
+		return fmt.Errorf("failed to create collect directory: %w", err)
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(staging, flags, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open collected file: %w", err)
+	}
+	info, err := file.Stat()
+	if err == nil && info.Size() != offset {
+		err = fmt.Errorf("unexpected offset %d of %s, received %d bytes", offset, hostPath, info.Size())
+	}
+	if err == nil {
+		_, err = file.Write(data)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write collected file: %w", err)
+	}
+	if !eof {
+		return nil
+	}
+
+	dest := filepath.Join(s.dir, hostDir, rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0750); err != nil {
+		return fmt.Errorf("failed to create collect directory: %w", err)
+	}
+	if err := os.Rename(staging, dest); err != nil {
+		return fmt.Errorf("failed to store collected file: %w", err)
+	}
+	return nil
+}
+
+// List 遍历主机目录
+func (s *LocalCollectStore) List(taskID, hostID string) ([]CollectedFile, error) {
+	hostDir, err := s.hostDir(taskID, hostID)
+	if err != nil {
+		return nil, err
+	}
+	root := filepath.Join(s.dir, hostDir)
+
+	var files []CollectedFile
+	err = filepath.WalkDir(root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && name == root {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		files = append(files, CollectedFile{
+			Path:    "/" + filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list collected files: %w", err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// Open 打开主机目录中的文件
+func (s *LocalCollectStore) Open(taskID, hostID, hostPath string) (*os.File, error) {
+	hostDir, err := s.hostDir(taskID, hostID)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := collectRelPath(hostPath)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(s.dir, hostDir, rel))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s on host %s", ErrFileNotFound, hostPath, hostID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open collected file: %w", err)
+	}
+	return file, nil
+}
+
+// Archive 遍历任务目录写入 tar.gz，跳过不在 hostIDs 中的主机目录
+func (s *LocalCollectStore) Archive(taskID string, hostIDs []string, w io.Writer) error {
+	taskDir, err := escapePathSegment(taskID)
+	if err != nil {
+		return err
+	}
+	root := filepath.Join(s.dir, taskDir)
+
+	var included map[string]bool
+	if hostIDs != nil {
+		included = make(map[string]bool, len(hostIDs))
+		for _, hostID := range hostIDs {
+			if hostDir, err := escapePathSegment(hostID); err == nil {
+				included[hostDir] = true
+			}
+		}
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	err = filepath.WalkDir(root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && name == root {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() && included != nil && filepath.Dir(name) == root && !included[entry.Name()] {
+			return fs.SkipDir
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.CopyN(archive, file, info.Size())
+		return err
+	})
+	if err == nil {
+		err = archive.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to archive collected files: %w", err)
+	}
+	return nil
+}
+
+// Remove 删除主机目录及暂存文件
+func (s *LocalCollectStore) Remove(taskID, hostID string) error {
+	hostDir, err := s.hostDir(taskID, hostID)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, dir := range []string{filepath.Join(s.dir, hostDir), filepath.Join(s.dir, collectStagingDir, hostDir)} {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to remove collected files: %w", err)
+		}
+	}
+	return nil
+}
+
+// RemoveTask 删除任务目录及暂存文件
+func (s *LocalCollectStore) RemoveTask(taskID string) error {
+	taskDir, err := escapePathSegment(taskID)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, dir := range []string{filepath.Join(s.dir, taskDir), filepath.Join(s.dir, collectStagingDir, taskDir)} {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to remove collected files: %w", err)
+		}
+	}
+	return nil
+}
+
+// hostDir 任务中主机的目录（相对存储目录）
+func (s *LocalCollectStore) hostDir(taskID, hostID string) (string, error) {
+	taskDir, err := escapePathSegment(taskID)
+	if err != nil {
+		return "", err
+	}
+	hostDir, err := escapePathSegment(hostID)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(taskDir, hostDir), nil
+}
+
+// escapePathSegment 将任务ID或主机ID转换为单级目录名
+func escapePathSegment(segment string) (string, error) {
+	escaped := url.PathEscape(segment)
+	if escaped == "" || escaped == "." || escaped == ".." || strings.HasPrefix(escaped, ".") {
+		return "", fmt.Errorf("invalid path segment: %q", segment)
+	}
+	return escaped, nil
+}
+
+// collectRelPath 将主机上的绝对路径转换为主机目录内的相对路径，Windows 路径的盘符作为第一级目录
+func collectRelPath(hostPath string) (string, error) {
+	rel := strings.ReplaceAll(hostPath, "\\", "/")
+	if len(rel) >= 2 && rel[1] == ':' {
+		rel = rel[:1] + rel[2:]
+	}
+	rel = path.Clean("/" + rel)[1:]
+	local := filepath.FromSlash(rel)
+	if rel == "" || !filepath.IsLocal(local) {
+		return "", fmt.Errorf("invalid collected file path: %s", hostPath)
+	}
+	return local, nil
+}
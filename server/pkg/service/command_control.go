@@ -0,0 +1,212 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"devops-manager/api/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// controlAckTimeout 等待 Agent 确认控制请求的最长时间
+const controlAckTimeout = 10 * time.Second
+
+// ErrControlAckTimeout 等待 Agent 确认控制请求超时
+var ErrControlAckTimeout = errors.New("timed out waiting for agent to acknowledge control")
+
+// controlWaiters 等待控制确认的请求，按控制请求ID索引
+var controlWaiters = struct {
+	sync.Mutex
+	waiters map[string]chan *models.ControlAck
+}{waiters: make(map[string]chan *models.ControlAck)}
+
+// ControlTaskHost 向任务在指定主机上执行的命令发送控制请求，并等待 Agent 确认
+// scope 为调用方可操作的主机范围，为空表示不限制
+func (ts *TaskService) ControlTaskHost(taskID, hostID string, action models.ControlAction, signal int32, operator string, scope models.HostScope) (*models.ControlAck, error) {
+	if !action.IsValid() {
+		return nil, fmt.Errorf("invalid control action: %s", action)
+	}
+	if action == models.ControlActionSignal && signal <= 0 {
+		return nil, fmt.Errorf("signal is required for signal action")
+	}
+	if err := ts.checkHostScope([]string{hostID}, scope); err != nil {
+		return nil, err
+	}
+
+	var cmdHost models.CommandHost
+	err := ts.db.Where("host_id = ? AND command_id IN (SELECT command_id FROM commands WHERE task_id = ?)", hostID, taskID).
+		First(&cmdHost).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("host %s not found in task %s", hostID, taskID)
+		}
+		return nil, fmt.Errorf("failed to get command host: %w", err)
+	}
+	if cmdHost.IsCompleted() {
+		return nil, fmt.Errorf("command %s on host %s is already finished: %s", cmdHost.CommandID, hostID, cmdHost.Status)
+	}
+
+	control := &models.CommandControl{
+		ControlID:   uuid.New().String(),
+		CommandID:   cmdHost.CommandID,
+		HostID:      hostID,
+		Action:      action,
+		Signal:      signal,
+		RequestedBy: operator,
+		CreatedAt:   time.Now(),
+	}
+
+	ack, err := ts.sendControlAndWait(control)
+	if err != nil {
+		return nil, err
+	}
+
+	// Agent 已终止命令，同步更新命令状态
+	if action == models.ControlActionCancel && ack.State == models.ExecutionStateCanceled {
+		if err := ts.markCommandHostCanceled(taskID, cmdHost.CommandID, hostID); err != nil {
+			log.Printf("Failed to mark command %s canceled on host %s: %v", cmdHost.CommandID, hostID, err)
+		}
+	}
+
+	go func() {
+		details := map[string]interface{}{
+			"task_id": taskID,
+			"action":  action,
+			"signal":  signal,
+			"success": ack.Success,
+			"state":   ack.State,
+			"message": ack.Message,
+		}
+		if err := ts.auditService.LogCommandAction(AuditActionCommandControl, cmdHost.CommandID, hostID, operator, details); err != nil {
+			log.Printf("Failed to log command control audit: %v", err)
+		}
+	}()
+
+	return ack, nil
+}
+
+// HandleControlAck 处理 Agent 返回的控制确认
+func (ts *TaskService) HandleControlAck(ack *models.ControlAck) error {
+	controlWaiters.Lock()
+	waiter, exists := controlWaiters.waiters[ack.ControlID]
+	if exists {
+		delete(controlWaiters.waiters, ack.ControlID)
+	}
+	controlWaiters.Unlock()
+
+	if exists {
+		waiter <- ack
+		return nil
+	}
+
+	// 任务停止/取消时发送的控制请求不等待确认，这里只记录结果
+	if !ack.Success {
+		log.Printf("Control %s (%s) of command %s failed on host %s: %s, state: %s",
+			ack.ControlID, ack.Action, ack.CommandID, ack.HostID, ack.Message, ack.State)
+		return nil
+	}
+	log.Printf("Control %s (%s) of command %s applied on host %s, state: %s",
+		ack.ControlID, ack.Action, ack.CommandID, ack.HostID, ack.State)
+	return nil
+}
+
+// sendCancelControl 通知 Agent 取消命令，不等待确认
+func (ts *TaskService) sendCancelControl(command models.Command, operator string) error {
+	if taskDispatcher == nil {
+		return fmt.Errorf("task dispatcher not available")
+	}
+
+	return taskDispatcher.SendControlToAgent(command.HostID, &models.CommandControl{
+		ControlID:   uuid.New().String(),
+		CommandID:   command.CommandID,
+		HostID:      command.HostID,
+		Action:      models.ControlActionCancel,
+		RequestedBy: operator,
+		CreatedAt:   time.Now(),
+	})
+}
+
+// sendControlAndWait 发送控制请求并等待 Agent 确认
+func (ts *TaskService) sendControlAndWait(control *models.CommandControl) (*models.ControlAck, error) {
+	if taskDispatcher == nil {
+		return nil, fmt.Errorf("task dispatcher not available")
+	}
+
+	waiter := make(chan *models.ControlAck, 1)
+	controlWaiters.Lock()
+	controlWaiters.waiters[control.ControlID] = waiter
+	controlWaiters.Unlock()
+
+	defer func() {
+		controlWaiters.Lock()
+		delete(controlWaiters.waiters, control.ControlID)
+		controlWaiters.Unlock()
+	}()
+
+	if err := taskDispatcher.SendControlToAgent(control.HostID, control); err != nil {
+		return nil, fmt.Errorf("failed to send control to agent: %w", err)
+	}
+
+	select {
+	case ack := <-waiter:
+		return ack, nil
+	case <-time.After(controlAckTimeout):
+		return nil, ErrControlAckTimeout
+	}
+}
+
+// markCommandHostCanceled 将单个主机上的命令标记为已取消并更新任务进度
+func (ts *TaskService) markCommandHostCanceled(taskID, commandID, hostID string) error {
+	canceled := false
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&models.CommandHost{}).
+			Where("command_id = ? AND host_id = ? AND status IN (?)", commandID, hostID, []string{
+				string(models.CommandHostStatusPending),
+				string(models.CommandHostStatusRunning),
+			}).
+			Updates(map[string]interface{}{
+				"status":      string(models.CommandHostStatusCanceled),
+				"finished_at": now,
+				"updated_at":  now,
+			})
+		if result.Error != nil {
+			return fmt.Errorf("failed to cancel command host: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		canceled = true
+
+		err := tx.Model(&models.Command{}).Where("command_id = ?", commandID).
+			Updates(map[string]interface{}{
+				"status":      models.CommandStatusCanceled,
+				"finished_at": now,
+				"updated_at":  now,
+			}).Error
+		if err != nil {
+			return fmt.Errorf("failed to cancel command: %w", err)
+		}
+
+		return ts.updateTaskProgressInTransaction(tx, taskID)
+	})
+	if err != nil || !canceled {
+		return err
+	}
+
+	if err := ts.cacheService.InvalidateTaskCache(taskID); err != nil {
+		log.Printf("Failed to invalidate task cache: %v", err)
+	}
+
+	ts.publishCommandResult(taskID, &models.CommandResult{
+		CommandID: commandID,
+		HostID:    hostID,
+		ExitCode:  -1,
+	}, string(models.CommandHostStatusCanceled))
+	return nil
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"devops-manager/api/models"
+
+	"gorm.io/gorm"
+)
+
+// errMsgDeliveryRejected Agent 未能记录投递的命令，为避免重复执行不会执行该命令
+const errMsgDeliveryRejected = "Agent rejected command delivery"
+
+// HandleCommandDelivered 处理 Agent 的命令投递确认
+// 确认成功时记录投递时间，重连对账时未确认投递的命令会重新投递；确认失败时命令标记为下发失败
+func (ts *TaskService) HandleCommandDelivered(hostID, commandID string, success, duplicate bool, message string) error {
+	var commandHost models.CommandHost
+	err := ts.db.Where("command_id = ? AND host_id = ?", commandID, hostID).First(&commandHost).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %s on host %s", ErrCommandNotFound, commandID, hostID)
+		}
+		return fmt.Errorf("failed to get command host: %w", err)
+	}
+
+	if !success {
+		if commandHost.IsCompleted() {
+			return nil
+		}
+		errorMsg := errMsgDeliveryRejected
+		if message != "" {
+			errorMsg = fmt.Sprintf("%s: %s", errMsgDeliveryRejected, message)
+		}
+		log.Printf("Command %s rejected by agent %s: %s", commandID, hostID, message)
+		ts.updateCommandDispatchFailed(commandID, errorMsg)
+
+		var command models.Command
+		if err := ts.db.Where("command_id = ?", commandID).First(&command).Error; err == nil && command.TaskID != nil {
+			if err := ts.updateTaskProgressInTransaction(ts.db, *command.TaskID); err != nil {
+				log.Printf("Failed to update task progress for task %s: %v", *command.TaskID, err)
+			}
+			if err := ts.cacheService.InvalidateTaskCache(*command.TaskID); err != nil {
+				log.Printf("Failed to invalidate task cache: %v", err)
+			}
+		}
+		return nil
+	}
+
+	if duplicate {
+		log.Printf("Agent %s already received command %s, delivery not repeated: %s", hostID, commandID, message)
+	}
+	if commandHost.DeliveredAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	err = ts.db.Model(&models.CommandHost{}).
+		Where("id = ? AND delivered_at IS NULL", commandHost.ID).
+		Updates(map[string]interface{}{
+			"delivered_at": now,
+			"updated_at":   now,
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record delivery of command %s: %w", commandID, err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"devops-manager/api/models"
+	"devops-manager/api/protobuf"
+
+	"gorm.io/gorm"
+)
+
+// fetchTarget 正在回传文件的收集命令
+type fetchTarget struct {
+	taskID   string
+	limit    int64 // 回传的总字节数上限
+	received int64
+	exceeded bool
+}
+
+// fetchTargets 正在回传文件的收集命令，按命令ID索引，收到执行结果后移除
+var fetchTargets = struct {
+	sync.Mutex
+	targets map[string]*fetchTarget
+}{targets: make(map[string]*fetchTarget)}
+
+// HandleFetchChunk 处理 Agent 回传的收集文件分片，按任务和主机保存
+// 超过总字节数上限的分片被丢弃，已接收完成的文件保留
+func (ts *TaskService) HandleFetchChunk(hostID string, chunk *protobuf.FetchChunk) error {
+	store := GetCollectStore()
+	if store == nil {
+		return fmt.Errorf("file collection store is not configured")
+	}
+
+	fetchTargets.Lock()
+	defer fetchTargets.Unlock()
+
+	target, exists := fetchTargets.targets[chunk.CommandId]
+	if !exists {
+		var err error
+		target, err = ts.loadFetchTarget(chunk.CommandId, hostID)
+		if err != nil {
+			return err
+		}
+		fetchTargets.targets[chunk.CommandId] = target
+	}
+
+	if target.exceeded {
+		return nil
+	}
+	if target.received+int64(len(chunk.Data)) > target.limit {
+		target.exceeded = true
+		log.Printf("Collected files of command %s from host %s exceed %d bytes, discarding the rest", chunk.CommandId, hostID, target.limit)
+		return nil
+	}
+	target.received += int64(len(chunk.Data))
+
+	return store.Write(target.taskID, hostID, chunk.Path, chunk.Offset, chunk.Data, chunk.Eof)
+}
+
+// loadFetchTarget 查询收集命令，命令必须属于该主机
+func (ts *TaskService) loadFetchTarget(commandID, hostID string) (*fetchTarget, error) {
+	var command models.Command
+	err := ts.db.Where("command_id = ? AND host_id = ?", commandID, hostID).First(&command).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s on host %s", ErrCommandNotFound, commandID, hostID)
+		}
+		return nil, fmt.Errorf("failed to get command: %w", err)
+	}
+	if command.Fetch == nil || command.TaskID == nil {
+		return nil, fmt.Errorf("command %s is not a file fetch task", commandID)
+	}
+
+	limit := collectMaxBytes
+	if command.Fetch.MaxTotalBytes > 0 && command.Fetch.MaxTotalBytes < limit {
+		limit = command.Fetch.MaxTotalBytes
+	}
+	return &fetchTarget{taskID: *command.TaskID, limit: limit}, nil
+}
+
+// forgetFetchTarget 收集命令结束后移除回传状态
+func forgetFetchTarget(commandID string) {
+	fetchTargets.Lock()
+	defer fetchTargets.Unlock()
+	delete(fetchTargets.targets, commandID)
+}
+
+// removeCollectedFiles 删除收集命令上次执行回传的文件
+func removeCollectedFiles(command *models.Command) {
+	forgetFetchTarget(command.CommandID)
+	store := GetCollectStore()
+	if store == nil || command.Fetch == nil || command.TaskID == nil {
+		return
+	}
+	if err := store.Remove(*command.TaskID, command.HostID); err != nil {
+		log.Printf("Failed to remove collected files of command %s: %v", command.CommandID, err)
+	}
+}
+
+// ListCollectedFiles 列出主机在任务中回传的文件，scope 为调用者可操作的主机范围
+func (ts *TaskService) ListCollectedFiles(taskID, hostID string, scope models.HostScope) ([]CollectedFile, error) {
+	store := GetCollectStore()
+	if store == nil {
+		return nil, fmt.Errorf("file collection store is not configured")
+	}
+	if err := ts.checkHostScope([]string{hostID}, scope); err != nil {
+		return nil, err
+	}
+	return store.List(taskID, hostID)
+}
+
+// OpenCollectedFile 打开主机在任务中回传的文件，path 为文件在主机上的路径，scope 为调用者可操作的主机范围
+func (ts *TaskService) OpenCollectedFile(taskID, hostID, path string, scope models.HostScope) (*os.File, error) {
+	store := GetCollectStore()
+	if store == nil {
+		return nil, fmt.Errorf("file collection store is not configured")
+	}
+	if err := ts.checkHostScope([]string{hostID}, scope); err != nil {
+		return nil, err
+	}
+	return store.Open(taskID, hostID, path)
+}
+
+// WriteCollectedArchive 将任务主机回传的文件以 tar.gz 写入 w，不在 scope 内的主机被跳过
+func (ts *TaskService) WriteCollectedArchive(taskID string, w io.Writer, scope models.HostScope) error {
+	store := GetCollectStore()
+	if store == nil {
+		return fmt.Errorf("file collection store is not configured")
+	}
+
+	var hostIDs []string
+	if scope.IsRestricted() {
+		var taskHostIDs []string
+		if err := ts.db.Model(&models.Command{}).Where("task_id = ?", taskID).Distinct().Pluck("host_id", &taskHostIDs).Error; err != nil {
+			return fmt.Errorf("failed to query task hosts: %w", err)
+		}
+		var hosts []models.Host
+		if err := ts.db.Where("host_id IN ?", taskHostIDs).Find(&hosts).Error; err != nil {
+			return fmt.Errorf("failed to query hosts: %w", err)
+		}
+		hostIDs = []string{}
+		for i := range hosts {
+			if scope.MatchesHost(&hosts[i]) {
+				hostIDs = append(hostIDs, hosts[i].HostID)
+			}
+		}
+	}
+	return store.Archive(taskID, hostIDs, w)
+}
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"devops-manager/api/models"
+	"devops-manager/api/ratelimit"
+	"devops-manager/server/pkg/config"
+)
+
+var (
+	// ErrFileNotFound 文件存储中不存在指定摘要的文件
+	ErrFileNotFound = errors.New("file not found")
+	// ErrFileTooLarge 上传文件超过大小上限
+	ErrFileTooLarge = errors.New("file too large")
+)
+
+// StoredFile 文件存储中的文件
+type StoredFile struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// FileStore 文件分发的文件存储，按内容 SHA-256 寻址，相同内容只保存一份
+type FileStore interface {
+	// Put 保存文件内容，超过 maxBytes 时返回 ErrFileTooLarge
+	Put(r io.Reader, maxBytes int64) (*StoredFile, error)
+	// Stat 获取文件信息，不存在时返回 ErrFileNotFound
+	Stat(sha256 string) (*StoredFile, error)
+	// Open 打开文件，不存在时返回 ErrFileNotFound
+	Open(sha256 string) (*os.File, error)
+	// Manifest 按 chunkSize 切分文件，返回各分片的 SHA-256，不存在时返回 ErrFileNotFound
+	Manifest(sha256 string, chunkSize int) ([]string, error)
+}
+
+// LocalFileStore 基于本地目录的文件存储，文件保存在 <dir>/<摘要前两位>/<摘要>
+type LocalFileStore struct {
+	dir string
+}
+
+// maxPeerChunkSize 对等分发分片的最大字节数，分片随 gRPC 消息发送，消息上限为 4MB
+const maxPeerChunkSize = 3 * 1024 * 1024
+
+var (
+	fileStore     FileStore
+	maxFileBytes  int64 = 1024 * 1024 * 1024
+	fileChunkSize       = 256 * 1024
+	peerChunkSize       = 1024 * 1024
+	peerZoneTag         = "zone"
+)
+
+// InitFileStore 根据配置初始化文件分发存储
+func InitFileStore(cfg *config.FilesConfig) error {
+	maxFileBytes = cfg.MaxBytes
+	fileChunkSize = cfg.ChunkSize
+	peerChunkSize = cfg.PeerChunkSize
+	if peerChunkSize > maxPeerChunkSize {
+		log.Printf("Warning: files.peer_chunk_size %d exceeds %d bytes, using %d", peerChunkSize, maxPeerChunkSize, maxPeerChunkSize)
+		peerChunkSize = maxPeerChunkSize
+	}
+	peerZoneTag = cfg.PeerZoneTag
+	transferLimiter = ratelimit.New(cfg.MaxBandwidth)
+	hostBandwidth = cfg.HostBandwidth
+
+	store, err := NewLocalFileStore(cfg.Dir)
+	if err != nil {
+		return err
+	}
+	fileStore = store
+	log.Printf("File distribution store: %s", cfg.Dir)
+	return nil
+}
+
+// GetFileStore 获取文件存储，未初始化时返回 nil
+func GetFileStore() FileStore {
+	return fileStore
+}
+
+// GetMaxFileBytes 获取单个上传文件的最大字节数
+func GetMaxFileBytes() int64 {
+	return maxFileBytes
+}
+
+// GetFileChunkSize 获取向 Agent 发送文件时每个分片的字节数
+func GetFileChunkSize() int {
+	return fileChunkSize
+}
+
+// GetPeerChunkSize 获取对等分发时每个分片的字节数
+func GetPeerChunkSize() int {
+	return peerChunkSize
+}
+
+// NewLocalFileStore 创建本地目录文件存储
+func NewLocalFileStore(dir string) (*LocalFileStore, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create file store directory: %w", err)
+	}
+	return &LocalFileStore{dir: dir}, nil
+}
+
+// Put 写入临时文件并计算摘要，完成后重命名到摘要对应的路径
+func (s *LocalFileStore) Put(r io.Reader, maxBytes int64) (*StoredFile, error) {
+	tmp, err := os.CreateTemp(s.dir, "upload-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(r, maxBytes+1))
+	if err == nil && size > maxBytes {
+		err = fmt.Errorf("%w: exceeds %d bytes", ErrFileTooLarge, maxBytes)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if errors.Is(err, ErrFileTooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to write upload file: %w", err)
+	}
+
+	stored := &StoredFile{SHA256: hex.EncodeToString(hash.Sum(nil)), Size: size}
+	path := s.path(stored.SHA256)
+	if _, err := os.Stat(path); err == nil {
+		// 相同内容已存在
+		return stored, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create file store directory: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, fmt.Errorf("failed to store file: %w", err)
+	}
+	return stored, nil
+}
+
+// Stat 获取文件大小
+func (s *LocalFileStore) Stat(sha256 string) (*StoredFile, error) {
+	if !models.IsValidSHA256(sha256) {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, sha256)
+	}
+	info, err := os.Stat(s.path(sha256))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, sha256)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat stored file: %w", err)
+	}
+	return &StoredFile{SHA256: sha256, Size: info.Size()}, nil
+}
+
+// Open 打开文件
+func (s *LocalFileStore) Open(sha256 string) (*os.File, error) {
+	if !models.IsValidSHA256(sha256) {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, sha256)
+	}
+	file, err := os.Open(s.path(sha256))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, sha256)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stored file: %w", err)
+	}
+	return file, nil
+}
+
+// Manifest 计算各分片的摘要，结果保存在文件旁的 <摘要>.<分片大小>.chunks 中，再次请求时直接读取
+func (s *LocalFileStore) Manifest(sha256 string, chunkSize int) ([]string, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("invalid chunk size: %d", chunkSize)
+	}
+	file, err := s.Open(sha256)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	manifestPath := fmt.Sprintf("%s.%d.chunks", s.path(sha256), chunkSize)
+	if data, err := os.ReadFile(manifestPath); err == nil {
+		return strings.Fields(string(data)), nil
+	}
+
+	var chunks []string
+	buffer := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(file, buffer)
+		if n > 0 {
+			digest := sha256sum(buffer[:n])
+			chunks = append(chunks, digest)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stored file: %w", err)
+		}
+	}
+	if len(chunks) == 0 {
+		// 空文件只有一个空分片
+		chunks = append(chunks, sha256sum(nil))
+	}
+
+	// 写入失败时下次重新计算
+	tmp, err := os.CreateTemp(filepath.Dir(manifestPath), "manifest-*.tmp")
+	if err != nil {
+		return chunks, nil
+	}
+	_, err = tmp.WriteString(strings.Join(chunks, "\n") + "\n")
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), manifestPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return chunks, nil
+}
+
+// sha256sum 计算数据的 SHA-256（小写十六进制）
+func sha256sum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// path 摘要对应的文件路径
+func (s *LocalFileStore) path(digest string) string {
+	return filepath.Join(s.dir, digest[:2], digest)
+}
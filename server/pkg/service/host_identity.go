@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// clientCertRequired gRPC 启用 TLS 时，Agent 的状态上报和命令流必须携带客户端证书
+var clientCertRequired bool
+
+// SetClientCertRequired 设置是否要求 Agent 携带客户端证书
+func SetClientCertRequired(required bool) {
+	clientCertRequired = required
+}
+
+// PeerCertificate 从 gRPC 上下文中取出已校验的客户端证书
+// 非 gRPC 请求或未启用 mTLS 时返回 nil
+func PeerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.AuthInfo == nil {
+		return nil
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+
+	chains := tlsInfo.State.VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return nil
+	}
+	return chains[0][0]
+}
+
+// certificateMatchesHost 证书 CN 或 DNS SAN 与主机ID一致即视为匹配
+func certificateMatchesHost(cert *x509.Certificate, hostID string) bool {
+	if cert.Subject.CommonName == hostID {
+		return true
+	}
+	for _, name := range cert.DNSNames {
+		if name == hostID {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyHostIdentity 校验请求方证书身份与主机ID一致且证书未被吊销
+// 请求未携带客户端证书（HTTP 接口、未启用 mTLS 或尚未入网的 Agent）时不做校验
+func VerifyHostIdentity(ctx context.Context, hostID string) error {
+	cert := PeerCertificate(ctx)
+	if cert == nil {
+		return nil
+	}
+
+	if ca := GetCertificateAuthority(); ca != nil && ca.IsRevoked(cert.SerialNumber) {
+		return fmt.Errorf("certificate %s has been revoked", cert.SerialNumber.Text(16))
+	}
+
+	if !certificateMatchesHost(cert, hostID) {
+		return fmt.Errorf("host identity mismatch: certificate %q does not match host %s",
+			cert.Subject.CommonName, hostID)
+	}
+	return nil
+}
+
+// RequireHostIdentity 与 VerifyHostIdentity 相同，但在要求客户端证书时拒绝未携带证书的请求
+func RequireHostIdentity(ctx context.Context, hostID string) error {
+	if clientCertRequired && PeerCertificate(ctx) == nil {
+		return fmt.Errorf("client certificate required for host %s", hostID)
+	}
+	return VerifyHostIdentity(ctx, hostID)
+}
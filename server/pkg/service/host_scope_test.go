@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"devops-manager/api/models"
+	"devops-manager/api/protobuf"
+)
+
+// newScopeTestService 写入各团队的主机和任务
+// task-payments 只包含 payments 主机，task-mixed 同时包含 payments 和 search 主机，task-search 只包含 search 主机
+func newScopeTestService(t *testing.T) *TaskService {
+	t.Helper()
+	db := newTestDB(t, &models.Host{}, &models.Task{}, &models.Command{})
+
+	hosts := []models.Host{
+		{HostID: "pay-1", Hostname: "pay-1", Status: models.HostStatusApproved, Tags: models.JSON{"team": "payments"}},
+		{HostID: "pay-2", Hostname: "pay-2", Status: models.HostStatusApproved, Tags: models.JSON{"team": "payments"}},
+		// Agent 上报的标签不参与主机范围
+		{HostID: "search-1", Hostname: "search-1", Status: models.HostStatusApproved,
+			Tags: models.JSON{"team": "search"}, Labels: models.JSON{"team": "payments"}},
+	}
+	if err := db.Create(&hosts).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	taskHosts := map[string][]string{
+		"task-payments": {"pay-1", "pay-2"},
+		"task-mixed":    {"pay-1", "search-1"},
+		"task-search":   {"search-1"},
+	}
+	for taskID, hostIDs := range taskHosts {
+		if err := db.Create(&models.Task{TaskID: taskID, Name: taskID, Status: models.TaskStatusFailed}).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, hostID := range hostIDs {
+			id := taskID
+			command := &models.Command{CommandID: taskID + "-" + hostID, TaskID: &id, HostID: hostID, Command: "true", Status: models.CommandStatusFailed}
+			if err := db.Create(command).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// 不属于任务的命令
+	if err := db.Create(&models.Command{CommandID: "standalone", HostID: "search-1", Command: "true", Status: models.CommandStatusFailed}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return &TaskService{db: db}
+}
+
+func TestCheckTaskScope(t *testing.T) {
+	ts := newScopeTestService(t)
+	payments := models.HostScope{{Key: "team", Value: "payments"}}
+
+	tests := []struct {
+		name       string
+		taskID     string
+		hostID     string
+		scope      models.HostScope
+		wantDenied bool
+	}{
+		{name: "unrestricted", taskID: "task-search", scope: nil},
+		{name: "all hosts in scope", taskID: "task-payments", scope: payments},
+		{name: "host in scope", taskID: "task-payments", hostID: "pay-2", scope: payments},
+		{name: "task with a host outside scope", taskID: "task-mixed", scope: payments, wantDenied: true},
+		{name: "task outside scope", taskID: "task-search", scope: payments, wantDenied: true},
+		{name: "host outside scope", taskID: "task-payments", hostID: "search-1", scope: payments, wantDenied: true},
+		{name: "unknown host", taskID: "task-payments", hostID: "missing", scope: payments, wantDenied: true},
+		{name: "host matched only by agent label", taskID: "task-search", scope: payments, wantDenied: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ts.CheckTaskScope(tt.taskID, tt.hostID, tt.scope)
+			var scopeErr *HostScopeError
+			if denied := errors.As(err, &scopeErr); denied != tt.wantDenied {
+				t.Fatalf("CheckTaskScope = %v, want denied %v", err, tt.wantDenied)
+			}
+			if !tt.wantDenied && err != nil {
+				t.Fatalf("CheckTaskScope failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestScopedTaskLists(t *testing.T) {
+	ts := newScopeTestService(t)
+
+	tests := []struct {
+		name  string
+		scope models.HostScope
+		want  []string
+	}{
+		{name: "unrestricted", scope: nil, want: []string{"task-mixed", "task-payments", "task-search"}},
+		{name: "payments", scope: models.HostScope{{Key: "team", Value: "payments"}}, want: []string{"task-payments"}},
+		{name: "search", scope: models.HostScope{{Key: "team", Value: "search"}}, want: []string{"task-search"}},
+		{name: "no host in scope", scope: models.HostScope{{Key: "team", Value: "billing"}}, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tasks, total, err := ts.GetTasksByStatus(string(models.TaskStatusFailed), 1, 10, tt.scope)
+			if err != nil {
+				t.Fatalf("GetTasksByStatus failed: %v", err)
+			}
+			var got []string
+			for _, task := range tasks {
+				got = append(got, task.TaskID)
+			}
+			sort.Strings(got)
+			if total != len(tt.want) || len(got) != len(tt.want) {
+				t.Fatalf("tasks = %v (total %d), want %v", got, total, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("tasks = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestScopedFailedCommands(t *testing.T) {
+	ts := newScopeTestService(t)
+
+	tests := []struct {
+		name  string
+		scope models.HostScope
+		want  int
+	}{
+		{name: "unrestricted", scope: nil, want: 6},
+		{name: "payments", scope: models.HostScope{{Key: "team", Value: "payments"}}, want: 3},
+		{name: "no host in scope", scope: models.HostScope{{Key: "team", Value: "billing"}}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commands, total, err := ts.GetFailedCommands(1, 10, "", tt.scope)
+			if err != nil {
+				t.Fatalf("GetFailedCommands failed: %v", err)
+			}
+			if total != tt.want || len(commands) != tt.want {
+				t.Fatalf("GetFailedCommands returned %d commands (total %d), want %d", len(commands), total, tt.want)
+			}
+			for _, command := range commands {
+				if tt.scope.IsRestricted() && command.HostID == "search-1" {
+					t.Errorf("command %s of a host outside scope returned", command.CommandID)
+				}
+			}
+		})
+	}
+}
+
+func TestAgentReportsDoNotChangeHostTags(t *testing.T) {
+	db := newTestDB(t, &models.Host{})
+	hs := &HostService{db: db}
+	host := &models.Host{HostID: "pay-1", Hostname: "pay-1", Status: models.HostStatusApproved, Tags: models.JSON{"team": "payments"}}
+	if err := db.Create(host).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 注册信息和状态上报中的标签只记录为 Labels
+	if err := hs.updateApprovedHost(host, &protobuf.HostInfo{
+		Id: "pay-1", Hostname: "pay-1", Tags: map[string]string{"team": "search"},
+	}); err != nil {
+		t.Fatalf("updateApprovedHost failed: %v", err)
+	}
+	if err := hs.ReportHostStatus(context.Background(), &protobuf.HostStatus{
+		HostId: "pay-1", CustomTags: map[string]string{"team": "search", "zone": "b"},
+	}); err != nil {
+		t.Fatalf("ReportHostStatus failed: %v", err)
+	}
+
+	var stored models.Host
+	if err := db.Where("host_id = ?", "pay-1").First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Tags) != 1 || stored.Tags["team"] != "payments" {
+		t.Errorf("host tags = %v, want unchanged {team: payments}", stored.Tags)
+	}
+	if stored.Labels["team"] != "search" || stored.Labels["zone"] != "b" {
+		t.Errorf("host labels = %v, want reported tags", stored.Labels)
+	}
+
+	info := hs.modelToProtobuf(&stored)
+	if info.Tags["team"] != "payments" || info.Labels["team"] != "search" {
+		t.Errorf("HostInfo tags = %v labels = %v", info.Tags, info.Labels)
+	}
+}
+
+func TestApprovedHostStartsWithReportedTags(t *testing.T) {
+	pending := &models.PendingHost{HostID: "pay-1", Tags: map[string]string{"team": "payments"}}
+	host := pending.ToHost()
+	if host.Tags["team"] != "payments" || host.Labels["team"] != "payments" {
+		t.Errorf("approved host tags = %v labels = %v, want reported tags in both", host.Tags, host.Labels)
+	}
+}
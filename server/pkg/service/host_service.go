@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"sync"
@@ -36,13 +37,32 @@ func GetHostService() *HostService {
 }
 
 // RegisterHost 注册或更新主机信息
-func (hs *HostService) RegisterHost(hostInfo *protobuf.HostInfo) error {
+// 请求携带客户端证书时，主机ID必须与证书身份一致；已签发过证书的主机只接受携带证书的信息更新
+// 启用内置 CA 时，返回需要下发给已准入主机的证书（无需下发时为 nil）
+func (hs *HostService) RegisterHost(ctx context.Context, hostInfo *protobuf.HostInfo) (*models.HostCertificate, error) {
 	hs.mutex.Lock()
 	defer hs.mutex.Unlock()
 
-	// 如果没有ID，生成一个
+	// 如果没有ID，优先使用证书 CN，否则生成一个
 	if hostInfo.Id == "" {
-		hostInfo.Id = generateHostID()
+		if cert := PeerCertificate(ctx); cert != nil {
+			hostInfo.Id = cert.Subject.CommonName
+		} else {
+			hostInfo.Id = generateHostID()
+		}
+	}
+
+	if err := VerifyHostIdentity(ctx, hostInfo.Id); err != nil {
+		return nil, err
+	}
+
+	// 提前校验 CSR，避免准入时才发现无法签发
+	var csr *x509.CertificateRequest
+	if ca := GetCertificateAuthority(); ca != nil && hostInfo.Csr != "" {
+		var err error
+		if csr, err = ca.ValidateCSR(hostInfo.Id, hostInfo.Csr); err != nil {
+			return nil, err
+		}
 	}
 
 	// 查找现有主机（已准入的）
@@ -56,19 +76,71 @@ func (hs *HostService) RegisterHost(hostInfo *protobuf.HostInfo) error {
 		// 主机不存在或未准入，检查是否在待准入列表中
 		if hs.isPendingHost(hostInfo.Id) {
 			// 更新待准入主机的信息
-			return hs.updatePendingHost(hostInfo)
+			return nil, hs.updatePendingHost(hostInfo)
 		} else {
 			// 新主机，添加到待准入列表
-			return hs.addToPendingList(hostInfo)
+			return nil, hs.addToPendingList(hostInfo)
 		}
 	} else if result.Error != nil {
-		return fmt.Errorf("failed to query host: %w", result.Error)
+		return nil, fmt.Errorf("failed to query host: %w", result.Error)
 	} else {
+		// 已签发证书的主机，未携带证书的请求只能领取准入时签发的证书，不能修改主机信息（标签决定用户的主机范围）
+		if PeerCertificate(ctx) == nil {
+			issued, err := hs.hasIssuedCertificate(hostInfo.Id)
+			if err != nil {
+				return nil, err
+			}
+			if issued {
+				if csr == nil {
+					return nil, fmt.Errorf("client certificate required to update host %s", hostInfo.Id)
+				}
+				return hs.deliverCertificate(ctx, hostInfo.Id, hostInfo.Csr, csr)
+			}
+		}
+
 		// 更新已准入主机的信息
-		return hs.updateApprovedHost(&host, hostInfo)
+		if err := hs.updateApprovedHost(&host, hostInfo); err != nil {
+			return nil, err
+		}
+		if csr == nil {
+			return nil, nil
+		}
+		return hs.deliverCertificate(ctx, hostInfo.Id, hostInfo.Csr, csr)
 	}
 }
 
+// hasIssuedCertificate 检查是否曾为主机签发过证书，未启用内置 CA 时返回 false
+func (hs *HostService) hasIssuedCertificate(hostID string) (bool, error) {
+	ca := GetCertificateAuthority()
+	if ca == nil {
+		return false, nil
+	}
+	return ca.HasCertificate(hostID)
+}
+
+// deliverCertificate 为已准入主机下发证书
+// 携带有效客户端证书的请求视为证书轮换，直接签发新证书；
+// 未携带证书时只下发准入时签发的证书，且 CSR 公钥必须与之一致
+func (hs *HostService) deliverCertificate(ctx context.Context, hostID, csrPEM string, csr *x509.CertificateRequest) (*models.HostCertificate, error) {
+	ca := GetCertificateAuthority()
+
+	if PeerCertificate(ctx) != nil {
+		return ca.SignCSR(hostID, csrPEM)
+	}
+
+	current, err := ca.CurrentCertificate(hostID)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, fmt.Errorf("no certificate issued for host %s, re-approval required", hostID)
+	}
+	if !ca.CertificateMatchesCSR(current, csr) {
+		return nil, fmt.Errorf("CSR does not match the certificate issued for host %s", hostID)
+	}
+	return current, nil
+}
+
 // GetHost 获取单个主机信息
 func (hs *HostService) GetHost(id string) (*protobuf.HostInfo, bool) {
 	hs.mutex.RLock()
@@ -112,7 +184,7 @@ func (hs *HostService) GetAllHosts() []*protobuf.HostInfo {
 	return result
 }
 
-// UpdateHost 更新主机信息
+// UpdateHost 更新主机信息，由管理员维护主机标签
 func (hs *HostService) UpdateHost(hostInfo *protobuf.HostInfo) error {
 	hs.mutex.Lock()
 	defer hs.mutex.Unlock()
@@ -144,9 +216,10 @@ func (hs *HostService) UpdateHost(hostInfo *protobuf.HostInfo) error {
 
 	// 更新时间戳
 	hostInfo.LastSeen = host.LastSeen.Unix()
+	hostInfo.Labels = stringTags(host.Labels)
 
 	// 更新缓存
-	hs.cacheHost(hostInfo)
+	hs.cacheHost(hs.modelToProtobuf(&host))
 
 	return nil
 }
@@ -167,9 +240,34 @@ func (hs *HostService) DeleteHost(id string) error {
 	// 从缓存中删除
 	hs.deleteCachedHost(id)
 
+	// 删除的主机不应再持有有效证书
+	if ca := GetCertificateAuthority(); ca != nil {
+		if _, err := ca.RevokeHost(id); err != nil {
+			return fmt.Errorf("failed to revoke host certificates: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// GetHostCertificates 获取内置 CA 为主机签发的证书
+func (hs *HostService) GetHostCertificates(hostID string) ([]models.HostCertificate, error) {
+	ca := GetCertificateAuthority()
+	if ca == nil {
+		return nil, ErrCANotEnabled
+	}
+	return ca.ListCertificates(hostID)
+}
+
+// RevokeHostCertificates 吊销主机的全部证书并断开其连接，返回吊销数量
+func (hs *HostService) RevokeHostCertificates(hostID string) (int, error) {
+	ca := GetCertificateAuthority()
+	if ca == nil {
+		return 0, ErrCANotEnabled
+	}
+	return ca.RevokeHost(hostID)
+}
+
 // GetHostCount 获取主机统计信息
 func (hs *HostService) GetHostCount() (total, online, offline int) {
 	hs.mutex.RLock()
@@ -208,21 +306,33 @@ func (e *HostError) Error() string {
 
 // modelToProtobuf 将数据库模型转换为 protobuf 格式
 func (hs *HostService) modelToProtobuf(host *models.Host) *protobuf.HostInfo {
-	tags := make(map[string]string)
-	for k, v := range host.Tags {
-		if str, ok := v.(string); ok {
-			tags[k] = str
-		}
-	}
-
 	return &protobuf.HostInfo{
 		Id:       host.HostID,
 		Hostname: host.Hostname,
 		Ip:       host.IP,
 		Os:       host.OS,
-		Tags:     tags,
+		Tags:     stringTags(host.Tags),
+		Labels:   stringTags(host.Labels),
 		LastSeen: host.LastSeen.Unix(),
+
+		AgentVersion: host.AgentVersion,
+
+		RunningCommands:       int32(host.RunningCommands),
+		QueuedCommands:        int32(host.QueuedCommands),
+		MaxConcurrentCommands: int32(host.MaxConcurrentCommands),
+		CommandQueueCapacity:  int32(host.CommandQueueCapacity),
+	}
+}
+
+// stringTags 取出标签中的字符串值
+func stringTags(values models.JSON) map[string]string {
+	tags := make(map[string]string, len(values))
+	for k, v := range values {
+		if str, ok := v.(string); ok {
+			tags[k] = str
+		}
 	}
+	return tags
 }
 
 // cacheHost 缓存主机信息到 Redis
@@ -329,8 +439,12 @@ func (hs *HostService) updatePendingHost(hostInfo *protobuf.HostInfo) error {
 	pendingHost.Hostname = hostInfo.Hostname
 	pendingHost.IP = hostInfo.Ip
 	pendingHost.OS = hostInfo.Os
+	pendingHost.Version = hostInfo.AgentVersion
 	pendingHost.Tags = hostInfo.Tags
 	pendingHost.LastSeen = hostInfo.LastSeen
+	if hostInfo.Csr != "" {
+		pendingHost.CSR = hostInfo.Csr
+	}
 
 	// 重新存储
 	newData, err := json.Marshal(pendingHost)
@@ -342,26 +456,30 @@ func (hs *HostService) updatePendingHost(hostInfo *protobuf.HostInfo) error {
 }
 
 // updateApprovedHost 更新已准入主机信息
+// Agent 上报的标签只记录为 Labels，不修改决定用户主机范围的主机标签
 func (hs *HostService) updateApprovedHost(host *models.Host, hostInfo *protobuf.HostInfo) error {
-	// 准备标签数据
-	tags := make(models.JSON)
+	labels := make(models.JSON)
 	for k, v := range hostInfo.Tags {
-		tags[k] = v
+		labels[k] = v
 	}
 
 	// 更新主机信息
 	host.Hostname = hostInfo.Hostname
 	host.IP = hostInfo.Ip
 	host.OS = hostInfo.Os
-	host.Tags = tags
+	host.Labels = labels
 	host.LastSeen = time.Unix(hostInfo.LastSeen, 0)
+	// 未上报版本的旧版本 Agent 保留握手时记录的版本
+	if hostInfo.AgentVersion != "" {
+		host.AgentVersion = hostInfo.AgentVersion
+	}
 
 	if err := hs.db.Save(host).Error; err != nil {
 		return fmt.Errorf("failed to update approved host: %w", err)
 	}
 
-	// 缓存到 Redis
-	hs.cacheHost(hostInfo)
+	// 缓存到 Redis，缓存的标签须为主机标签而非 Agent 上报的标签
+	hs.cacheHost(hs.modelToProtobuf(host))
 
 	return nil
 }
@@ -458,6 +576,13 @@ func (hs *HostService) ApproveHost(hostID string) error {
 		return fmt.Errorf("failed to query existing host: %w", result.Error)
 	}
 
+	// 启用内置 CA 时为主机签发证书，Agent 下次注册时下发
+	if ca := GetCertificateAuthority(); ca != nil && pendingHost.CSR != "" {
+		if _, err := ca.SignCSR(hostID, pendingHost.CSR); err != nil {
+			return fmt.Errorf("failed to issue certificate: %w", err)
+		}
+	}
+
 	// 从待准入列表中删除
 	if err := redis.Del(ctx, key).Err(); err != nil {
 		// 记录错误但不返回，因为主机已经准入成功
@@ -504,7 +629,11 @@ func (hs *HostService) GetPendingHostsCount() (int, error) {
 }
 
 // ReportHostStatus 处理主机状态上报
-func (hs *HostService) ReportHostStatus(status *protobuf.HostStatus) error {
+func (hs *HostService) ReportHostStatus(ctx context.Context, status *protobuf.HostStatus) error {
+	if err := VerifyHostIdentity(ctx, status.HostId); err != nil {
+		return err
+	}
+
 	hs.mutex.Lock()
 	defer hs.mutex.Unlock()
 
@@ -521,23 +650,31 @@ func (hs *HostService) ReportHostStatus(status *protobuf.HostStatus) error {
 	// 更新主机最后上报时间
 	host.LastSeen = time.Unix(status.Timestamp, 0)
 
-	// 更新主机标签（合并状态信息）
-	if host.Tags == nil {
-		host.Tags = make(models.JSON)
+	// 状态信息和自定义标签记录为 Agent 上报的标签，不修改决定用户主机范围的主机标签
+	if host.Labels == nil {
+		host.Labels = make(models.JSON)
 	}
 
 	// 添加系统状态信息到标签
 	if status.Cpu != nil {
-		host.Tags["cpu_usage"] = fmt.Sprintf("%.2f%%", status.Cpu.UsagePercent)
-		host.Tags["cpu_cores"] = fmt.Sprintf("%d", status.Cpu.CoreCount)
+		host.Labels["cpu_usage"] = fmt.Sprintf("%.2f%%", status.Cpu.UsagePercent)
+		host.Labels["cpu_cores"] = fmt.Sprintf("%d", status.Cpu.CoreCount)
 	}
 
 	if status.Memory != nil {
-		host.Tags["memory_usage"] = fmt.Sprintf("%.2f%%", status.Memory.UsagePercent)
-		host.Tags["memory_total"] = fmt.Sprintf("%.2fGB", float64(status.Memory.TotalBytes)/1024/1024/1024)
+		host.Labels["memory_usage"] = fmt.Sprintf("%.2f%%", status.Memory.UsagePercent)
+		host.Labels["memory_total"] = fmt.Sprintf("%.2fGB", float64(status.Memory.TotalBytes)/1024/1024/1024)
 	}
 
-	host.Tags["uptime"] = fmt.Sprintf("%ds", status.UptimeSeconds)
+	host.Labels["uptime"] = fmt.Sprintf("%ds", status.UptimeSeconds)
+
+	// 执行队列状态，旧版本 Agent 不上报队列容量
+	if status.MaxConcurrentCommands > 0 {
+		host.RunningCommands = int(status.RunningCommands)
+		host.QueuedCommands = int(status.QueuedCommands)
+		host.MaxConcurrentCommands = int(status.MaxConcurrentCommands)
+		host.CommandQueueCapacity = int(status.CommandQueueCapacity)
+	}
 
 	// 更新 IP 地址
 	if status.Ip != "" {
@@ -546,7 +683,7 @@ func (hs *HostService) ReportHostStatus(status *protobuf.HostStatus) error {
 
 	// 合并自定义标签
 	for k, v := range status.CustomTags {
-		host.Tags[k] = v
+		host.Labels[k] = v
 	}
 
 	// 保存到数据库
@@ -554,7 +691,8 @@ func (hs *HostService) ReportHostStatus(status *protobuf.HostStatus) error {
 		return fmt.Errorf("failed to update host status: %w", err)
 	}
 
-	// 缓存状态信息到 Redis
+	// 缓存主机和状态信息到 Redis
+	hs.cacheHost(hs.modelToProtobuf(&host))
 	hs.cacheHostStatus(status)
 
 	return nil
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"devops-manager/api/models"
+	"devops-manager/api/protobuf"
+)
+
+func TestReportHostStatusQueue(t *testing.T) {
+	db := newTestDB(t, &models.Host{})
+	hs := &HostService{db: db}
+	if err := db.Create(&models.Host{HostID: "host-1", Hostname: "host-1", Status: models.HostStatusApproved}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	report := func(status *protobuf.HostStatus) models.Host {
+		t.Helper()
+		status.HostId = "host-1"
+		if err := hs.ReportHostStatus(context.Background(), status); err != nil {
+			t.Fatalf("ReportHostStatus failed: %v", err)
+		}
+		var host models.Host
+		if err := db.Where("host_id = ?", "host-1").First(&host).Error; err != nil {
+			t.Fatal(err)
+		}
+		return host
+	}
+
+	host := report(&protobuf.HostStatus{RunningCommands: 2, QueuedCommands: 5, MaxConcurrentCommands: 4, CommandQueueCapacity: 100})
+	if host.RunningCommands != 2 || host.QueuedCommands != 5 || host.MaxConcurrentCommands != 4 || host.CommandQueueCapacity != 100 {
+		t.Errorf("queue status = %d/%d running, %d/%d queued",
+			host.RunningCommands, host.MaxConcurrentCommands, host.QueuedCommands, host.CommandQueueCapacity)
+	}
+	for _, key := range []string{"running_commands", "queued_commands"} {
+		if _, ok := host.Tags[key]; ok {
+			t.Errorf("queue status %s written to host tags", key)
+		}
+		if _, ok := host.Labels[key]; ok {
+			t.Errorf("queue status %s written to host labels", key)
+		}
+	}
+
+	// 旧版本 Agent 不上报队列状态时保留上次的值
+	host = report(&protobuf.HostStatus{})
+	if host.RunningCommands != 2 || host.MaxConcurrentCommands != 4 {
+		t.Errorf("queue status reset by a report without queue capacity: %d/%d", host.RunningCommands, host.MaxConcurrentCommands)
+	}
+
+	info := hs.modelToProtobuf(&host)
+	if info.RunningCommands != 2 || info.QueuedCommands != 5 || info.MaxConcurrentCommands != 4 || info.CommandQueueCapacity != 100 {
+		t.Errorf("HostInfo queue status = %+v", info)
+	}
+}
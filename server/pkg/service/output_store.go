@@ -0,0 +1,121 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"devops-manager/server/pkg/config"
+)
+
+// OutputStore 命令完整输出的转存存储，key 由 spoolKey 生成
+type OutputStore interface {
+	// Append 追加输出
+	Append(key string, data []byte) error
+	// Open 打开完整输出
+	Open(key string) (io.ReadCloser, error)
+	// Remove 删除输出，不存在时不报错
+	Remove(key string) error
+}
+
+// FileOutputStore 基于本地目录的输出存储
+type FileOutputStore struct {
+	dir string
+}
+
+var (
+	outputStore    OutputStore
+	maxOutputBytes = 1024 * 1024
+)
+
+// InitOutputStore 根据配置设置输出上限并初始化输出转存
+func InitOutputStore(cfg *config.OutputConfig) error {
+	maxOutputBytes = cfg.MaxBytes
+	if cfg.SpoolDir == "" {
+		return nil
+	}
+
+	store, err := NewFileOutputStore(cfg.SpoolDir)
+	if err != nil {
+		return err
+	}
+	outputStore = store
+	log.Printf("Command output spooling enabled: %s", cfg.SpoolDir)
+	return nil
+}
+
+// GetOutputStore 获取输出存储，未启用转存时返回 nil
+func GetOutputStore() OutputStore {
+	return outputStore
+}
+
+// NewFileOutputStore 创建本地目录输出存储
+func NewFileOutputStore(dir string) (*FileOutputStore, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create output spool directory: %w", err)
+	}
+	return &FileOutputStore{dir: dir}, nil
+}
+
+// Append 追加输出到文件
+func (s *FileOutputStore) Append(key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return fmt.Errorf("failed to create output spool directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open output spool: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("failed to write output spool: %w", err)
+	}
+	return nil
+}
+
+// Open 打开输出文件
+func (s *FileOutputStore) Open(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output spool: %w", err)
+	}
+	return file, nil
+}
+
+// Remove 删除输出文件
+func (s *FileOutputStore) Remove(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove output spool: %w", err)
+	}
+	return nil
+}
+
+// path 将 key 转换为存储目录内的文件路径
+func (s *FileOutputStore) path(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("invalid output spool key: %s", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// spoolKey 生成命令主机输出流的存储 key
+func spoolKey(commandID, hostID, stream string) string {
+	return url.PathEscape(commandID) + "/" + url.PathEscape(hostID) + "." + stream
+}
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"devops-manager/api/models"
+	"devops-manager/api/protobuf"
+	"devops-manager/api/ratelimit"
+
+	"gorm.io/gorm"
+)
+
+// peerFileIdleTTL 文件超过该时长没有分片请求后不再记录其持有者
+const peerFileIdleTTL = time.Hour
+
+// FileChunkPlan 分片请求的处理结果
+type FileChunkPlan struct {
+	SHA256 string
+	Index  int64
+	Offset int64
+	Size   int64
+	// 分片的 SHA-256，指派对等主机前以此授权请求方从对等主机获取该分片
+	ChunkSHA256 string
+	// 该命令的主机限速器，发送分片数据前等待，为 nil 时只受 Server 总带宽限制
+	Limiter *ratelimit.Limiter
+	// 同区域可能持有该分片的主机，按随机顺序排列；为空或均不可用时由 Server 发送分片
+	Peers []string
+}
+
+// peerFile 对等分发文件在各区域的分片持有者
+type peerFile struct {
+	zones    map[string]map[int64]map[string]struct{} // 区域 -> 分片序号 -> 主机
+	corrupt  map[string]struct{}                      // 提供过摘要不符分片的主机，不再为该文件指派
+	lastUsed time.Time
+}
+
+// peerTarget 按分片请求文件的分发命令
+type peerTarget struct {
+	key        string // 文件摘要和分片大小
+	sha256     string
+	size       int64
+	chunkSize  int64
+	chunkCount int64
+	zone       string   // 为空时不进行对等分发，分片全部由 Server 发送
+	chunks     []string // 各分片的 SHA-256，仅对等分发时加载
+	limiter    *ratelimit.Limiter
+	lastUsed   time.Time
+}
+
+// peerDistribution 对等分发的分片持有者和进行中的命令，按命令ID索引，收到执行结果后移除
+var peerDistribution = struct {
+	sync.Mutex
+	files       map[string]*peerFile
+	targets     map[string]*peerTarget
+	lastCleanup time.Time
+}{
+	files:   make(map[string]*peerFile),
+	targets: make(map[string]*peerTarget),
+}
+
+// PlanFileChunk 处理 Agent 的分片请求，返回分片位置，对等分发时还返回同区域可提供该分片的主机
+// 请求方上报已校验并缓存的分片后才登记为这些分片的持有者；只上报时返回 nil
+func (ts *TaskService) PlanFileChunk(hostID string, req *protobuf.FileChunkRequest) (*FileChunkPlan, error) {
+	peerDistribution.Lock()
+	defer peerDistribution.Unlock()
+
+	target, exists := peerDistribution.targets[req.CommandId]
+	if !exists {
+		var err error
+		target, err = ts.loadPeerTarget(req.CommandId, hostID)
+		if err != nil {
+			return nil, err
+		}
+		peerDistribution.targets[req.CommandId] = target
+	}
+	if !req.ReportOnly && (req.Index < 0 || req.Index >= target.chunkCount) {
+		return nil, fmt.Errorf("chunk index %d out of range, file %s has %d chunks", req.Index, target.sha256, target.chunkCount)
+	}
+
+	now := time.Now()
+	target.lastUsed = now
+	cleanupPeerFiles(now)
+	file := peerDistribution.files[target.key]
+	if file == nil {
+		file = &peerFile{
+			zones:   make(map[string]map[int64]map[string]struct{}),
+			corrupt: make(map[string]struct{}),
+		}
+		peerDistribution.files[target.key] = file
+	}
+	file.lastUsed = now
+
+	for _, peer := range req.CorruptPeers {
+		if _, marked := file.corrupt[peer]; !marked {
+			file.corrupt[peer] = struct{}{}
+			log.Printf("Warning: Host %s reported corrupted chunk of file %s from peer %s, excluding the peer", hostID, target.sha256, peer)
+		}
+	}
+
+	if target.zone != "" {
+		for _, index := range req.StoredChunks {
+			if index >= 0 && index < int64(len(target.chunks)) {
+				file.holders(target.zone, index)[hostID] = struct{}{}
+			}
+		}
+	}
+	if req.ReportOnly {
+		return nil, nil
+	}
+
+	plan := &FileChunkPlan{
+		SHA256: target.sha256,
+		Index:  req.Index,
+		Offset: req.Index * target.chunkSize,
+		Size:   target.chunkSize,
+
+		Limiter: target.limiter,
+	}
+	if remaining := target.size - plan.Offset; remaining < plan.Size {
+		plan.Size = remaining
+	}
+	if target.zone == "" || req.Index >= int64(len(target.chunks)) {
+		return plan, nil
+	}
+	plan.ChunkSHA256 = target.chunks[req.Index]
+
+	holders := file.holders(target.zone, req.Index)
+	// 获取失败的主机可能已清理缓存或不可达
+	for _, peer := range req.FailedPeers {
+		delete(holders, peer)
+	}
+	for peer := range holders {
+		if _, corrupt := file.corrupt[peer]; corrupt || peer == hostID {
+			continue
+		}
+		plan.Peers = append(plan.Peers, peer)
+	}
+	rand.Shuffle(len(plan.Peers), func(i, j int) { plan.Peers[i], plan.Peers[j] = plan.Peers[j], plan.Peers[i] })
+	return plan, nil
+}
+
+// holders 返回区域内分片的持有者，调用方需持有锁
+func (f *peerFile) holders(zone string, index int64) map[string]struct{} {
+	chunks := f.zones[zone]
+	if chunks == nil {
+		chunks = make(map[int64]map[string]struct{})
+		f.zones[zone] = chunks
+	}
+	holders := chunks[index]
+	if holders == nil {
+		holders = make(map[string]struct{})
+		chunks[index] = holders
+	}
+	return holders
+}
+
+// loadPeerTarget 查询文件分发命令，对等分发时查询主机所在区域，命令必须属于该主机
+func (ts *TaskService) loadPeerTarget(commandID, hostID string) (*peerTarget, error) {
+	var command models.Command
+	err := ts.db.Where("command_id = ? AND host_id = ?", commandID, hostID).First(&command).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s on host %s", ErrCommandNotFound, commandID, hostID)
+		}
+		return nil, fmt.Errorf("failed to get command: %w", err)
+	}
+	if command.File == nil {
+		return nil, fmt.Errorf("command %s is not a file distribution", commandID)
+	}
+
+	chunkSize := int64(peerChunkSize)
+	target := &peerTarget{
+		key:        fmt.Sprintf("%s/%d", command.File.SHA256, chunkSize),
+		sha256:     command.File.SHA256,
+		size:       command.File.Size,
+		chunkSize:  chunkSize,
+		chunkCount: (command.File.Size + chunkSize - 1) / chunkSize,
+		limiter:    NewHostLimiter(command.File.BandwidthLimit),
+	}
+	if target.chunkCount == 0 {
+		// 空文件只有一个空分片
+		target.chunkCount = 1
+	}
+	if !command.File.Peer {
+		return target, nil
+	}
+
+	var host models.Host
+	if err := ts.db.Where("host_id = ?", hostID).First(&host).Error; err != nil {
+		log.Printf("Failed to get zone of host %s, serving chunks directly: %v", hostID, err)
+		return target, nil
+	}
+	zone, ok := host.Tags[peerZoneTag].(string)
+	if !ok || zone == "" {
+		return target, nil
+	}
+	store := GetFileStore()
+	if store == nil {
+		return nil, fmt.Errorf("file store is not configured")
+	}
+	chunks, err := store.Manifest(command.File.SHA256, peerChunkSize)
+	if err != nil {
+		log.Printf("Failed to load chunk manifest of file %s, serving chunks directly: %v", command.File.SHA256, err)
+		return target, nil
+	}
+	target.zone = zone
+	target.chunks = chunks
+	return target, nil
+}
+
+// finishPeerTarget 按分片请求的命令结束后移除分发状态，分片持有者已随分片请求上报登记
+func finishPeerTarget(result *models.CommandResult) {
+	peerDistribution.Lock()
+	defer peerDistribution.Unlock()
+
+	delete(peerDistribution.targets, result.CommandID)
+}
+
+// cleanupPeerFiles 移除长时间没有分片请求的命令和文件，调用方需持有锁
+func cleanupPeerFiles(now time.Time) {
+	if now.Sub(peerDistribution.lastCleanup) < peerFileIdleTTL/6 {
+		return
+	}
+	peerDistribution.lastCleanup = now
+
+	// 未收到执行结果的命令（如 Agent 已下线）同样在空闲后移除
+	active := make(map[string]struct{})
+	for commandID, target := range peerDistribution.targets {
+		if now.Sub(target.lastUsed) > peerFileIdleTTL {
+			delete(peerDistribution.targets, commandID)
+			continue
+		}
+		active[target.key] = struct{}{}
+	}
+	for key, file := range peerDistribution.files {
+		if _, ok := active[key]; !ok && now.Sub(file.lastUsed) > peerFileIdleTTL {
+			delete(peerDistribution.files, key)
+		}
+	}
+}
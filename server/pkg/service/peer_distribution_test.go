@@ -0,0 +1,148 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"devops-manager/api/models"
+	"devops-manager/api/protobuf"
+)
+
+// usePeerTargets 以内存中的对等分发命令替换分发状态，各主机的同名命令分发同一个 3 个分片的文件
+func usePeerTargets(t *testing.T, zone string, hostIDs ...string) {
+	t.Helper()
+	peerDistribution.Lock()
+	savedFiles, savedTargets := peerDistribution.files, peerDistribution.targets
+	peerDistribution.files = make(map[string]*peerFile)
+	peerDistribution.targets = make(map[string]*peerTarget)
+	for _, hostID := range hostIDs {
+		peerDistribution.targets["cmd-"+hostID] = &peerTarget{
+			key:        "file/4",
+			sha256:     "file",
+			size:       10,
+			chunkSize:  4,
+			chunkCount: 3,
+			zone:       zone,
+			chunks:     []string{"chunk-0", "chunk-1", "chunk-2"},
+			lastUsed:   time.Now(),
+		}
+	}
+	peerDistribution.lastCleanup = time.Now()
+	peerDistribution.Unlock()
+
+	t.Cleanup(func() {
+		peerDistribution.Lock()
+		defer peerDistribution.Unlock()
+		peerDistribution.files, peerDistribution.targets = savedFiles, savedTargets
+	})
+}
+
+func TestPlanFileChunkRegistersStoredChunks(t *testing.T) {
+	usePeerTargets(t, "a", "host-1", "host-2", "host-3")
+	ts := &TaskService{}
+
+	plan := func(hostID string, req *protobuf.FileChunkRequest) *FileChunkPlan {
+		t.Helper()
+		req.CommandId = "cmd-" + hostID
+		req.HostId = hostID
+		p, err := ts.PlanFileChunk(hostID, req)
+		if err != nil {
+			t.Fatalf("PlanFileChunk(%s, %d) failed: %v", hostID, req.Index, err)
+		}
+		return p
+	}
+	peers := func(p *FileChunkPlan) string {
+		sorted := append([]string{}, p.Peers...)
+		sort.Strings(sorted)
+		return strings.Join(sorted, ",")
+	}
+
+	// 请求分片的主机在上报已缓存之前不会被指派
+	if p := plan("host-1", &protobuf.FileChunkRequest{Index: 0}); len(p.Peers) != 0 {
+		t.Fatalf("first request assigned peers %v", p.Peers)
+	}
+	if p := plan("host-2", &protobuf.FileChunkRequest{Index: 0}); len(p.Peers) != 0 {
+		t.Fatalf("peers %v assigned before the chunk was reported stored", p.Peers)
+	}
+	if p := plan("host-1", &protobuf.FileChunkRequest{Index: 1, StoredChunks: []int64{0}}); p.Offset != 4 || p.Size != 4 || p.ChunkSHA256 != "chunk-1" {
+		t.Errorf("plan of chunk 1 = %+v", p)
+	}
+	if p := plan("host-3", &protobuf.FileChunkRequest{Index: 0}); peers(p) != "host-1" {
+		t.Errorf("peers of chunk 0 = %v, want [host-1]", p.Peers)
+	}
+
+	// 只上报时不返回分片位置，越界的分片序号被忽略
+	if p := plan("host-2", &protobuf.FileChunkRequest{ReportOnly: true, StoredChunks: []int64{0, 2, 7, -1}}); p != nil {
+		t.Errorf("report returned plan %+v", p)
+	}
+	if p := plan("host-3", &protobuf.FileChunkRequest{Index: 0}); peers(p) != "host-1,host-2" {
+		t.Errorf("peers of chunk 0 = %v, want [host-1 host-2]", p.Peers)
+	}
+	if p := plan("host-3", &protobuf.FileChunkRequest{Index: 2}); peers(p) != "host-2" {
+		t.Errorf("peers of chunk 2 = %v, want [host-2]", p.Peers)
+	}
+	// 请求方自己不会被指派
+	if p := plan("host-2", &protobuf.FileChunkRequest{Index: 2}); len(p.Peers) != 0 {
+		t.Errorf("host assigned to itself: %v", p.Peers)
+	}
+
+	// 获取失败的主机不再是该分片的持有者，摘要不符的主机不再为该文件指派
+	if p := plan("host-3", &protobuf.FileChunkRequest{Index: 0, FailedPeers: []string{"host-1"}}); peers(p) != "host-2" {
+		t.Errorf("peers of chunk 0 after failure = %v, want [host-2]", p.Peers)
+	}
+	if p := plan("host-3", &protobuf.FileChunkRequest{Index: 2, CorruptPeers: []string{"host-2"}}); len(p.Peers) != 0 {
+		t.Errorf("peers of chunk 2 after corruption = %v, want none", p.Peers)
+	}
+
+	if _, err := ts.PlanFileChunk("host-1", &protobuf.FileChunkRequest{CommandId: "cmd-host-1", HostId: "host-1", Index: 3}); err == nil {
+		t.Error("out of range chunk request succeeded")
+	}
+}
+
+func TestFinishPeerTargetDoesNotRegisterChunks(t *testing.T) {
+	usePeerTargets(t, "a", "host-1", "host-2")
+	ts := &TaskService{}
+
+	// 命令成功结束不代表分片已放入缓存，只登记上报过的分片
+	if _, err := ts.PlanFileChunk("host-1", &protobuf.FileChunkRequest{CommandId: "cmd-host-1", HostId: "host-1", Index: 0}); err != nil {
+		t.Fatal(err)
+	}
+	finishedAt := time.Now()
+	finishPeerTarget(&models.CommandResult{CommandID: "cmd-host-1", HostID: "host-1", ExitCode: 0, FinishedAt: &finishedAt})
+
+	p, err := ts.PlanFileChunk("host-2", &protobuf.FileChunkRequest{CommandId: "cmd-host-2", HostId: "host-2", Index: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Peers) != 0 {
+		t.Errorf("peers %v assigned from a finished command without stored chunk reports", p.Peers)
+	}
+
+	peerDistribution.Lock()
+	_, exists := peerDistribution.targets["cmd-host-1"]
+	peerDistribution.Unlock()
+	if exists {
+		t.Error("finished command still tracked")
+	}
+}
+
+func TestPlanFileChunkWithoutZone(t *testing.T) {
+	usePeerTargets(t, "", "host-1", "host-2")
+	ts := &TaskService{}
+
+	if _, err := ts.PlanFileChunk("host-1", &protobuf.FileChunkRequest{CommandId: "cmd-host-1", HostId: "host-1", Index: 1, StoredChunks: []int64{0}}); err != nil {
+		t.Fatal(err)
+	}
+	p, err := ts.PlanFileChunk("host-2", &protobuf.FileChunkRequest{CommandId: "cmd-host-2", HostId: "host-2", Index: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Peers) != 0 || p.ChunkSHA256 != "" {
+		t.Errorf("plan without peer zone = %+v, want chunk served by server", p)
+	}
+	if p.Offset != 0 || p.Size != 4 {
+		t.Errorf("plan of chunk 0 = offset %d size %d", p.Offset, p.Size)
+	}
+}
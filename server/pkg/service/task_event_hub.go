@@ -0,0 +1,181 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 任务事件类型
+const (
+	TaskEventStatus = "status" // 主机命令状态变化
+	TaskEventOutput = "output" // 命令输出分片
+	TaskEventTask   = "task"   // 任务状态变化
+)
+
+// TaskEvent 推送给实时订阅方的任务事件
+type TaskEvent struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	TaskID     string    `json:"task_id"`
+	HostID     string    `json:"host_id,omitempty"`
+	CommandID  string    `json:"command_id,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	ExitCode   *int32    `json:"exit_code,omitempty"`
+	Stream     string    `json:"stream,omitempty"`
+	Offset     int       `json:"offset"` // 输出事件中 data 在该输出流中的起始字节偏移量
+	Data       string    `json:"data,omitempty"`
+	Terminated bool      `json:"terminated,omitempty"` // 任务事件中表示任务已结束
+	Timestamp  time.Time `json:"timestamp"`
+	sequence   uint64
+}
+
+// taskTopic 单个任务的事件缓冲和订阅方
+type taskTopic struct {
+	nextSequence uint64
+	events       []*TaskEvent
+	subscribers  map[chan *TaskEvent]struct{}
+	lastActive   time.Time
+}
+
+// TaskEventHub 任务事件中心，缓存每个任务最近的事件，支持断线后按事件ID续传
+type TaskEventHub struct {
+	mutex       sync.Mutex
+	topics      map[string]*taskTopic
+	epoch       int64 // 进程启动标识，Server 重启后旧的事件ID不可续传
+	bufferSize  int
+	idleTimeout time.Duration
+}
+
+var (
+	taskEventHubInstance *TaskEventHub
+	taskEventHubOnce     sync.Once
+)
+
+// GetTaskEventHub 获取任务事件中心单例
+func GetTaskEventHub() *TaskEventHub {
+	taskEventHubOnce.Do(func() {
+		taskEventHubInstance = &TaskEventHub{
+			topics:      make(map[string]*taskTopic),
+			epoch:       time.Now().UnixNano(),
+			bufferSize:  500,
+			idleTimeout: 10 * time.Minute,
+		}
+		go taskEventHubInstance.startCleanup()
+	})
+	return taskEventHubInstance
+}
+
+// Publish 发布任务事件，订阅方消费过慢时断开其订阅，由客户端按事件ID续传
+func (h *TaskEventHub) Publish(event *TaskEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	topic := h.topic(event.TaskID)
+	topic.nextSequence++
+	event.sequence = topic.nextSequence
+	event.ID = fmt.Sprintf("%d-%d", h.epoch, event.sequence)
+
+	topic.events = append(topic.events, event)
+	if len(topic.events) > h.bufferSize {
+		topic.events = topic.events[len(topic.events)-h.bufferSize:]
+	}
+
+	for ch := range topic.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("Task event subscriber of %s is too slow, closing subscription", event.TaskID)
+			delete(topic.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe 订阅任务事件
+// lastEventID 为客户端收到的最后一个事件ID，仍在缓冲内时返回其后的事件且 resumed 为 true；
+// 否则 resumed 为 false，调用方需要先发送当前快照
+// 返回的通道在订阅被取消或消费过慢时关闭
+func (h *TaskEventHub) Subscribe(taskID, lastEventID string) (replay []*TaskEvent, events <-chan *TaskEvent, resumed bool, cancel func()) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	topic := h.topic(taskID)
+	if sequence, ok := h.parseEventID(lastEventID); ok && sequence <= topic.nextSequence {
+		oldest := topic.nextSequence + 1
+		if len(topic.events) > 0 {
+			oldest = topic.events[0].sequence
+		}
+		if sequence+1 >= oldest {
+			resumed = true
+			for _, event := range topic.events {
+				if event.sequence > sequence {
+					replay = append(replay, event)
+				}
+			}
+		}
+	}
+
+	ch := make(chan *TaskEvent, 256)
+	topic.subscribers[ch] = struct{}{}
+
+	cancel = func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		if _, exists := topic.subscribers[ch]; exists {
+			delete(topic.subscribers, ch)
+			close(ch)
+		}
+		topic.lastActive = time.Now()
+	}
+
+	return replay, ch, resumed, cancel
+}
+
+// topic 获取或创建任务的事件主题，调用方需持有锁
+func (h *TaskEventHub) topic(taskID string) *taskTopic {
+	topic, exists := h.topics[taskID]
+	if !exists {
+		topic = &taskTopic{subscribers: make(map[chan *TaskEvent]struct{})}
+		h.topics[taskID] = topic
+	}
+	topic.lastActive = time.Now()
+	return topic
+}
+
+// parseEventID 解析事件ID，只接受当前进程产生的ID
+func (h *TaskEventHub) parseEventID(id string) (uint64, bool) {
+	epoch, sequence, found := strings.Cut(id, "-")
+	if !found || epoch != strconv.FormatInt(h.epoch, 10) {
+		return 0, false
+	}
+	value, err := strconv.ParseUint(sequence, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// startCleanup 定期清理长时间没有事件和订阅方的任务主题
+func (h *TaskEventHub) startCleanup() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.mutex.Lock()
+		cutoff := time.Now().Add(-h.idleTimeout)
+		for taskID, topic := range h.topics {
+			if len(topic.subscribers) == 0 && topic.lastActive.Before(cutoff) {
+				delete(h.topics, taskID)
+			}
+		}
+		h.mutex.Unlock()
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"log"
 	"sync"
 	"time"
+
+	"devops-manager/api/models"
 )
 
 // TaskPriority 任务优先级
@@ -33,6 +35,8 @@ type HostLoad struct {
 	HostID             string
 	RunningTasks       int
 	MaxConcurrentTasks int
+	QueuedTasks        int // Agent 执行队列中排队的命令数
+	QueueCapacity      int // Agent 执行队列容量，0 表示未上报
 	CPUUsage           float64
 	MemoryUsage        float64
 	LastUpdated        time.Time
@@ -71,7 +75,7 @@ const (
 
 // TaskExecutor 任务执行器接口
 type TaskExecutor interface {
-	StartTask(taskID string) error
+	StartTask(taskID, operator string, scope models.HostScope) error
 }
 
 // NewTaskQueueManager 创建任务队列管理器
@@ -277,6 +281,11 @@ func (tqm *TaskQueueManager) canExecuteTask(task *QueuedTask) bool {
 			return false
 		}
 
+		// 检查 Agent 执行队列是否已满
+		if hostLoad.QueueCapacity > 0 && hostLoad.QueuedTasks >= hostLoad.QueueCapacity {
+			return false
+		}
+
 		// 检查主机资源使用率
 		if hostLoad.CPUUsage > 80.0 || hostLoad.MemoryUsage > 80.0 {
 			return false
@@ -402,8 +411,8 @@ func (tqm *TaskQueueManager) executeTask(workerID int, task *QueuedTask) {
 
 	startTime := time.Now()
 
-	// 执行任务
-	err := tqm.taskService.StartTask(task.TaskID)
+	// 执行任务，队列调度的任务审计记为任务创建者
+	err := tqm.taskService.StartTask(task.TaskID, "", nil)
 
 	tqm.mu.Lock()
 	defer tqm.mu.Unlock()
@@ -547,6 +556,8 @@ func (tqm *TaskQueueManager) GetQueueStatus() map[string]interface{} {
 		hostLoadSummary[hostID] = map[string]interface{}{
 			"running_tasks":        hostLoad.RunningTasks,
 			"max_concurrent_tasks": hostLoad.MaxConcurrentTasks,
+			"queued_tasks":         hostLoad.QueuedTasks,
+			"queue_capacity":       hostLoad.QueueCapacity,
 			"cpu_usage":            hostLoad.CPUUsage,
 			"memory_usage":         hostLoad.MemoryUsage,
 			"available":            hostLoad.Available,
@@ -588,6 +599,30 @@ func (tqm *TaskQueueManager) UpdateHostLoad(hostID string, cpuUsage, memoryUsage
 	hostLoad.LastUpdated = time.Now()
 }
 
+// UpdateAgentLoad 按 Agent 上报的执行队列状态更新主机负载，覆盖本地估算的运行数
+func (tqm *TaskQueueManager) UpdateAgentLoad(hostID string, running, queued, maxConcurrent, capacity int) {
+	tqm.mu.Lock()
+	defer tqm.mu.Unlock()
+
+	hostLoad, exists := tqm.hostLoads[hostID]
+	if !exists {
+		hostLoad = &HostLoad{
+			HostID:             hostID,
+			MaxConcurrentTasks: tqm.maxTasksPerHost,
+			Available:          true,
+		}
+		tqm.hostLoads[hostID] = hostLoad
+	}
+
+	hostLoad.RunningTasks = running
+	hostLoad.QueuedTasks = queued
+	if maxConcurrent > 0 {
+		hostLoad.MaxConcurrentTasks = maxConcurrent
+	}
+	hostLoad.QueueCapacity = capacity
+	hostLoad.LastUpdated = time.Now()
+}
+
 // CancelTask 取消队列中的任务
 func (tqm *TaskQueueManager) CancelTask(taskID string) error {
 	tqm.mu.Lock()
@@ -1,7 +1,9 @@
 package service
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"sort"
 	"strings"
@@ -9,6 +11,7 @@ import (
 	"time"
 
 	"devops-manager/api/models"
+	"devops-manager/api/protobuf"
 	"devops-manager/server/pkg/database"
 
 	"github.com/google/uuid"
@@ -95,19 +98,172 @@ func GetTaskService() *TaskService {
 	return taskServiceInstance
 }
 
+// HostScopeError 目标主机不在调用方的主机范围内
+type HostScopeError struct {
+	HostIDs []string
+}
+
+func (e *HostScopeError) Error() string {
+	return fmt.Sprintf("hosts outside of permitted scope: %s", strings.Join(e.HostIDs, ", "))
+}
+
+// checkHostScope 检查目标主机是否都在主机范围内，范围为空时不限制
+func (ts *TaskService) checkHostScope(hostIDs []string, scope models.HostScope) error {
+	if !scope.IsRestricted() {
+		return nil
+	}
+
+	var hosts []models.Host
+	if err := ts.db.Where("host_id IN ?", hostIDs).Find(&hosts).Error; err != nil {
+		return fmt.Errorf("failed to query hosts: %w", err)
+	}
+
+	permitted := make(map[string]bool, len(hosts))
+	for i := range hosts {
+		if scope.MatchesHost(&hosts[i]) {
+			permitted[hosts[i].HostID] = true
+		}
+	}
+
+	// 不存在的主机同样视为越权，避免泄露主机是否存在
+	var denied []string
+	for _, hostID := range hostIDs {
+		if !permitted[hostID] {
+			denied = append(denied, hostID)
+		}
+	}
+	if len(denied) > 0 {
+		return &HostScopeError{HostIDs: denied}
+	}
+	return nil
+}
+
+// checkTaskScope 检查任务的所有目标主机是否都在主机范围内，范围为空时不限制
+func (ts *TaskService) checkTaskScope(taskID string, scope models.HostScope) error {
+	if !scope.IsRestricted() {
+		return nil
+	}
+
+	var hostIDs []string
+	if err := ts.db.Model(&models.Command{}).Where("task_id = ?", taskID).Distinct().Pluck("host_id", &hostIDs).Error; err != nil {
+		return fmt.Errorf("failed to query task hosts: %w", err)
+	}
+	if len(hostIDs) == 0 {
+		return nil
+	}
+	return ts.checkHostScope(hostIDs, scope)
+}
+
+// CheckTaskScope 检查调用方能否查看任务：任务的全部目标主机以及 hostID（不为空时）都须在主机范围内，范围为空时不限制
+func (ts *TaskService) CheckTaskScope(taskID, hostID string, scope models.HostScope) error {
+	if err := ts.checkTaskScope(taskID, scope); err != nil {
+		return err
+	}
+	if hostID == "" {
+		return nil
+	}
+	return ts.checkHostScope([]string{hostID}, scope)
+}
+
+// scopeHostIDs 获取主机范围内的全部主机ID
+func (ts *TaskService) scopeHostIDs(scope models.HostScope) ([]string, error) {
+	var hosts []models.Host
+	if err := ts.db.Select("host_id", "tags").Find(&hosts).Error; err != nil {
+		return nil, fmt.Errorf("failed to query hosts: %w", err)
+	}
+
+	hostIDs := make([]string, 0, len(hosts))
+	for i := range hosts {
+		if scope.MatchesHost(&hosts[i]) {
+			hostIDs = append(hostIDs, hosts[i].HostID)
+		}
+	}
+	return hostIDs, nil
+}
+
+// scopeTasks 将任务查询限制为目标主机都在主机范围内的任务，范围为空时不限制
+func (ts *TaskService) scopeTasks(query *gorm.DB, scope models.HostScope) (*gorm.DB, error) {
+	if !scope.IsRestricted() {
+		return query, nil
+	}
+
+	hostIDs, err := ts.scopeHostIDs(scope)
+	if err != nil {
+		return nil, err
+	}
+	if len(hostIDs) == 0 {
+		return query.Where("task_id NOT IN (SELECT DISTINCT task_id FROM commands WHERE task_id IS NOT NULL)"), nil
+	}
+	// 子查询须排除不属于任务的命令，NOT IN 遇到 NULL 时不匹配任何任务
+	return query.Where("task_id NOT IN (SELECT DISTINCT task_id FROM commands WHERE task_id IS NOT NULL AND host_id NOT IN ?)", hostIDs), nil
+}
+
+// CreateTaskRequest 创建任务的参数
+type CreateTaskRequest struct {
+	Name        string
+	Description string
+	HostIDs     []string
+	Command     string             // 脚本任务为脚本内容，文件分发和文件收集任务为空时使用任务描述
+	Script      *models.ScriptSpec // 不为空时为脚本任务
+	File        *models.FileSpec   // 不为空时为文件分发任务，文件需已保存在文件存储中
+	Fetch       *models.FetchSpec  // 不为空时为文件收集任务，每台主机回传的总字节数不超过 files.collect_max_bytes
+	Timeout     int
+	Parameters  string
+	Options     *models.ExecutionOptions
+	CreatedBy   string
+	Scope       models.HostScope // 创建者可操作的主机范围
+}
+
 // CreateTask 创建任务
-func (ts *TaskService) CreateTask(name, description string, hostIDs []string, command string, timeout int, parameters string, createdBy string) (*models.Task, error) {
+func (ts *TaskService) CreateTask(req CreateTaskRequest) (*models.Task, error) {
+	if err := req.Script.Validate(req.Command); err != nil {
+		return nil, err
+	}
+	if req.File != nil {
+		if err := req.File.Validate(); err != nil {
+			return nil, err
+		}
+		if fileStore == nil {
+			return nil, fmt.Errorf("file store is not configured")
+		}
+		stored, err := fileStore.Stat(req.File.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		req.File.Size = stored.Size
+		if req.Command == "" {
+			req.Command = req.File.Description()
+		}
+	}
+	if req.Fetch != nil {
+		if err := req.Fetch.Validate(); err != nil {
+			return nil, err
+		}
+		if req.Fetch.MaxTotalBytes == 0 || req.Fetch.MaxTotalBytes > collectMaxBytes {
+			req.Fetch.MaxTotalBytes = collectMaxBytes
+		}
+		if req.Command == "" {
+			req.Command = req.Fetch.Description()
+		}
+	}
+	if err := req.Options.Validate(); err != nil {
+		return nil, err
+	}
+	if err := ts.checkHostScope(req.HostIDs, req.Scope); err != nil {
+		return nil, err
+	}
+
 	// 生成任务ID
 	taskID := "task-" + uuid.New().String()
 
 	// 创建任务
 	task := &models.Task{
 		TaskID:      taskID,
-		Name:        name,
-		Description: description,
-		CreatedBy:   createdBy,
+		Name:        req.Name,
+		Description: req.Description,
+		CreatedBy:   req.CreatedBy,
 		Status:      models.TaskStatusPending,
-		TotalHosts:  len(hostIDs),
+		TotalHosts:  len(req.HostIDs),
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
@@ -120,21 +276,26 @@ func (ts *TaskService) CreateTask(name, description string, hostIDs []string, co
 		}
 
 		// 2. 为每个目标主机创建对应的 Command 和 CommandHost 记录
-		for _, hostID := range hostIDs {
+		for _, hostID := range req.HostIDs {
 			// 生成命令ID
 			commandID := "cmd-" + uuid.New().String()
 
 			// 创建命令记录
 			cmd := &models.Command{
-				CommandID:  commandID,
-				TaskID:     &taskID,
-				HostID:     hostID,
-				Command:    command,
-				Parameters: parameters,
-				Timeout:    int64(timeout),
-				Status:     models.CommandStatusPending,
-				CreatedAt:  time.Now(),
-				UpdatedAt:  time.Now(),
+				CommandID:   commandID,
+				TaskID:      &taskID,
+				HostID:      hostID,
+				Command:     req.Command,
+				Script:      req.Script,
+				File:        req.File,
+				Fetch:       req.Fetch,
+				Parameters:  req.Parameters,
+				Timeout:     int64(req.Timeout),
+				RequestedBy: req.CreatedBy,
+				Options:     req.Options,
+				Status:      models.CommandStatusPending,
+				CreatedAt:   time.Now(),
+				UpdatedAt:   time.Now(),
 			}
 
 			if err := tx.Create(cmd).Error; err != nil {
@@ -177,25 +338,45 @@ func (ts *TaskService) CreateTask(name, description string, hostIDs []string, co
 	// 记录任务创建审计日志
 	go func() {
 		details := map[string]interface{}{
-			"task_name":   name,
-			"description": description,
-			"host_count":  len(hostIDs),
-			"host_ids":    hostIDs,
-			"command":     command,
-			"timeout":     timeout,
-			"parameters":  parameters,
-		}
-		if err := ts.auditService.LogTaskAction(AuditActionTaskCreated, taskID, createdBy, details); err != nil {
+			"task_name":   req.Name,
+			"description": req.Description,
+			"host_count":  len(req.HostIDs),
+			"host_ids":    req.HostIDs,
+			"command":     req.Command,
+			"timeout":     req.Timeout,
+			"parameters":  req.Parameters,
+		}
+		if req.Script != nil {
+			details["script_interpreter"] = req.Script.Interpreter
+			details["script_args"] = req.Script.Args
+		}
+		if req.File != nil {
+			details["file_sha256"] = req.File.SHA256
+			details["file_dest_path"] = req.File.DestPath
+			if req.File.Peer {
+				details["file_peer"] = true
+			}
+		}
+		if req.Fetch != nil {
+			details["fetch_patterns"] = req.Fetch.Patterns
+		}
+		if req.Options != nil {
+			// 标准输入可能包含敏感内容，不写入审计日志
+			details["run_as_user"] = req.Options.RunAsUser
+			details["run_as_group"] = req.Options.RunAsGroup
+			details["working_dir"] = req.Options.WorkingDir
+		}
+		if err := ts.auditService.LogTaskAction(AuditActionTaskCreated, taskID, req.CreatedBy, details); err != nil {
 			log.Printf("Failed to log task creation audit: %v", err)
 		}
 
 		// 记录任务执行日志
-		if err := ts.auditService.LogTaskExecution(taskID, "INFO", fmt.Sprintf("Task '%s' created with %d hosts", name, len(hostIDs)), details, "", ""); err != nil {
+		if err := ts.auditService.LogTaskExecution(taskID, "INFO", fmt.Sprintf("Task '%s' created with %d hosts", req.Name, len(req.HostIDs)), details, "", ""); err != nil {
 			log.Printf("Failed to log task execution: %v", err)
 		}
 	}()
 
-	log.Printf("Task created: %s with %d hosts", taskID, len(hostIDs))
+	log.Printf("Task created: %s with %d hosts", taskID, len(req.HostIDs))
 	return task, nil
 }
 
@@ -233,22 +414,28 @@ func (ts *TaskService) GetTask(taskID string) (*models.Task, error) {
 	return &task, nil
 }
 
-// GetTasks 获取任务列表
-func (ts *TaskService) GetTasks(page, size int, status, name string) ([]*models.Task, int, error) {
-	// 生成缓存键
+// GetTasks 获取任务列表，只返回目标主机都在 scope 内的任务
+func (ts *TaskService) GetTasks(page, size int, status, name string, scope models.HostScope) ([]*models.Task, int, error) {
+	// 生成缓存键，限制了主机范围的查询不使用缓存
 	cacheKey := ts.cacheService.GenerateTaskListCacheKey(page, size, status, name)
+	cacheable := !scope.IsRestricted()
 
 	// 尝试从缓存获取
-	if cachedTasks, cachedTotal, err := ts.cacheService.GetCachedTaskList(cacheKey); err == nil && cachedTasks != nil {
-		log.Printf("Task list cache hit: %s", cacheKey)
-		return cachedTasks, cachedTotal, nil
+	if cacheable {
+		if cachedTasks, cachedTotal, err := ts.cacheService.GetCachedTaskList(cacheKey); err == nil && cachedTasks != nil {
+			log.Printf("Task list cache hit: %s", cacheKey)
+			return cachedTasks, cachedTotal, nil
+		}
 	}
 
 	var tasks []models.Task
 	var total int64
 
 	// 构建查询条件
-	query := ts.db.Model(&models.Task{})
+	query, err := ts.scopeTasks(ts.db.Model(&models.Task{}), scope)
+	if err != nil {
+		return nil, 0, err
+	}
 
 	// 状态过滤
 	if status != "" {
@@ -278,11 +465,13 @@ func (ts *TaskService) GetTasks(page, size int, status, name string) ([]*models.
 	}
 
 	// 异步缓存结果
-	go func() {
-		if err := ts.cacheService.CacheTaskList(cacheKey, result, int(total)); err != nil {
-			log.Printf("Failed to cache task list: %v", err)
-		}
-	}()
+	if cacheable {
+		go func() {
+			if err := ts.cacheService.CacheTaskList(cacheKey, result, int(total)); err != nil {
+				log.Printf("Failed to cache task list: %v", err)
+			}
+		}()
+	}
 
 	return result, int(total), nil
 }
@@ -346,6 +535,13 @@ func (ts *TaskService) DeleteTask(taskID string) error {
 		return err
 	}
 
+	// 删除文件收集任务回传的文件
+	if store := GetCollectStore(); store != nil {
+		if err := store.RemoveTask(taskID); err != nil {
+			log.Printf("Failed to remove collected files of task %s: %v", taskID, err)
+		}
+	}
+
 	log.Printf("Task deleted: %s", taskID)
 	return nil
 }
@@ -353,6 +549,7 @@ func (ts *TaskService) DeleteTask(taskID string) error {
 // TaskDispatcher 任务分发器接口，用于与 gRPC 控制器通信
 type TaskDispatcher interface {
 	SendCommandToAgent(hostID string, command *models.Command) error
+	SendControlToAgent(hostID string, control *models.CommandControl) error
 }
 
 // taskDispatcher 全局任务分发器实例
@@ -364,7 +561,12 @@ func SetTaskDispatcher(dispatcher TaskDispatcher) {
 }
 
 // StartTask 启动任务 - 实现真正的任务下发逻辑
-func (ts *TaskService) StartTask(taskID string) error {
+// operator 为发起操作的用户，为空时（队列调度）记为任务创建者；scope 为其可操作的主机范围，为空表示不限制
+func (ts *TaskService) StartTask(taskID, operator string, scope models.HostScope) error {
+	if err := ts.checkTaskScope(taskID, scope); err != nil {
+		return err
+	}
+
 	// 使用事务确保数据一致性
 	return ts.db.Transaction(func(tx *gorm.DB) error {
 		// 1. 检查任务状态
@@ -376,6 +578,9 @@ func (ts *TaskService) StartTask(taskID string) error {
 			}
 			return fmt.Errorf("failed to get task: %w", err)
 		}
+		if operator == "" {
+			operator = task.CreatedBy
+		}
 
 		if !task.IsPending() {
 			return fmt.Errorf("task is not in pending status: %s", taskID)
@@ -457,7 +662,7 @@ func (ts *TaskService) StartTask(taskID string) error {
 					return hostIDs
 				}(),
 			}
-			if err := ts.auditService.LogTaskAction(AuditActionTaskStarted, taskID, task.CreatedBy, details); err != nil {
+			if err := ts.auditService.LogTaskAction(AuditActionTaskStarted, taskID, operator, details); err != nil {
 				log.Printf("Failed to log task start audit: %v", err)
 			}
 
@@ -473,7 +678,7 @@ func (ts *TaskService) StartTask(taskID string) error {
 					"parameters": cmd.Parameters,
 					"timeout":    cmd.Timeout,
 				}
-				if err := ts.auditService.LogCommandAction(AuditActionCommandSent, cmd.CommandID, cmd.HostID, task.CreatedBy, cmdDetails); err != nil {
+				if err := ts.auditService.LogCommandAction(AuditActionCommandSent, cmd.CommandID, cmd.HostID, operator, cmdDetails); err != nil {
 					log.Printf("Failed to log command send audit: %v", err)
 				}
 
@@ -544,45 +749,30 @@ func (ts *TaskService) updateCommandDispatchFailed(commandID, errorMsg string) {
 	ts.db.Model(&models.CommandHost{}).Where("command_id = ?", commandID).Updates(hostUpdates)
 }
 
-// StopTask 停止任务
-func (ts *TaskService) StopTask(taskID string) error {
-	// 先检查任务状态
-	var task models.Task
-	err := ts.db.Where("task_id = ?", taskID).First(&task).Error
-	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return fmt.Errorf("task not found: %s", taskID)
-		}
-		return fmt.Errorf("failed to get task: %w", err)
-	}
-
-	if !task.IsRunning() {
-		return fmt.Errorf("task is not running: %s", taskID)
-	}
+// StopTask 停止运行中的任务，operator 为发起操作的用户，scope 为其可操作的主机范围
+// 未完成的命令被标记为取消，并通知 Agent 终止正在执行的命令
+func (ts *TaskService) StopTask(taskID, operator string, scope models.HostScope) error {
+	return ts.cancelTask(taskID, operator, scope, "stop", true)
+}
 
-	// 更新任务状态
-	now := time.Now()
-	updates := map[string]interface{}{
-		"status":      models.TaskStatusCanceled,
-		"finished_at": now,
-		"updated_at":  now,
-	}
+// CancelTask 取消任务，operator 为发起操作的用户，scope 为其可操作的主机范围
+// 未完成的命令被标记为取消，并通知 Agent 终止正在执行的命令
+func (ts *TaskService) CancelTask(taskID, operator string, scope models.HostScope) error {
+	return ts.cancelTask(taskID, operator, scope, "cancel", false)
+}
 
-	err = ts.db.Model(&models.Task{}).Where("task_id = ?", taskID).Updates(updates).Error
-	if err != nil {
-		return fmt.Errorf("failed to update task status: %w", err)
+// cancelTask 取消任务及其未完成的命令，requireRunning 为 true 时只允许停止运行中的任务
+func (ts *TaskService) cancelTask(taskID, operator string, scope models.HostScope, operation string, requireRunning bool) error {
+	if err := ts.checkTaskScope(taskID, scope); err != nil {
+		return err
 	}
 
-	log.Printf("Task stopped: %s", taskID)
-	return nil
-}
+	var task models.Task
+	var commands []models.Command
 
-// CancelTask 取消任务 - 实现真正的任务取消逻辑
-func (ts *TaskService) CancelTask(taskID string) error {
 	// 使用事务确保数据一致性
-	return ts.db.Transaction(func(tx *gorm.DB) error {
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
 		// 1. 检查任务状态
-		var task models.Task
 		err := tx.Where("task_id = ?", taskID).First(&task).Error
 		if err != nil {
 			if err == gorm.ErrRecordNotFound {
@@ -591,11 +781,21 @@ func (ts *TaskService) CancelTask(taskID string) error {
 			return fmt.Errorf("failed to get task: %w", err)
 		}
 
+		if requireRunning && !task.IsRunning() {
+			return fmt.Errorf("task is not running: %s", taskID)
+		}
 		if task.IsCompleted() {
 			return fmt.Errorf("task is already completed: %s", taskID)
 		}
 
-		// 2. 更新任务状态为已取消
+		// 2. 获取需要通知 Agent 的未完成命令
+		activeStatuses := []models.CommandStatus{models.CommandStatusPending, models.CommandStatusRunning}
+		err = tx.Where("task_id = ? AND status IN (?)", taskID, activeStatuses).Find(&commands).Error
+		if err != nil {
+			return fmt.Errorf("failed to get active commands: %w", err)
+		}
+
+		// 3. 更新任务状态为已取消
 		now := time.Now()
 		taskUpdates := map[string]interface{}{
 			"status":      models.TaskStatusCanceled,
@@ -608,12 +808,9 @@ func (ts *TaskService) CancelTask(taskID string) error {
 			return fmt.Errorf("failed to update task status: %w", err)
 		}
 
-		// 3. 取消所有未完成的命令
+		// 4. 取消所有未完成的命令
 		err = tx.Model(&models.Command{}).
-			Where("task_id = ? AND status IN (?)", taskID, []models.CommandStatus{
-				models.CommandStatusPending,
-				models.CommandStatusRunning,
-			}).
+			Where("task_id = ? AND status IN (?)", taskID, activeStatuses).
 			Updates(map[string]interface{}{
 				"status":      models.CommandStatusCanceled,
 				"finished_at": now,
@@ -623,7 +820,7 @@ func (ts *TaskService) CancelTask(taskID string) error {
 			return fmt.Errorf("failed to cancel commands: %w", err)
 		}
 
-		// 4. 取消所有未完成的 CommandHost
+		// 5. 取消所有未完成的 CommandHost
 		err = tx.Model(&models.CommandHost{}).
 			Where("command_id IN (SELECT command_id FROM commands WHERE task_id = ?) AND status IN (?)",
 				taskID, []string{
@@ -639,42 +836,53 @@ func (ts *TaskService) CancelTask(taskID string) error {
 			return fmt.Errorf("failed to cancel command hosts: %w", err)
 		}
 
-		// 5. 通知所有相关的 Agent 取消命令执行
-		if taskDispatcher != nil {
-			// 获取所有需要取消的命令
-			var commands []models.Command
-			err = tx.Where("task_id = ? AND status = ?", taskID, models.CommandStatusCanceled).Find(&commands).Error
-			if err != nil {
-				log.Printf("Failed to get canceled commands for task %s: %v", taskID, err)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// 6. 通知相关的 Agent 终止命令执行，确认结果异步记录
+	for _, command := range commands {
+		go func(command models.Command) {
+			if err := ts.sendCancelControl(command, operator); err != nil {
+				log.Printf("Failed to send cancel control to agent %s: %v", command.HostID, err)
 			} else {
-				// 异步通知 Agent 取消命令
-				for _, cmd := range commands {
-					go func(command models.Command) {
-						err := ts.sendCancelCommandToAgent(command)
-						if err != nil {
-							log.Printf("Failed to send cancel command to agent %s: %v", command.HostID, err)
-						} else {
-							log.Printf("Cancel command sent to agent %s for command %s", command.HostID, command.CommandID)
-						}
-					}(cmd)
-				}
+				log.Printf("Cancel control sent to agent %s for command %s", command.HostID, command.CommandID)
 			}
-		}
+		}(command)
+	}
 
-		log.Printf("Task canceled: %s", taskID)
+	log.Printf("Task %s: %s by %s", operation, taskID, operator)
 
-		// 异步使相关缓存失效
-		go func() {
-			if err := ts.cacheService.InvalidateTaskCache(taskID); err != nil {
-				log.Printf("Failed to invalidate task cache: %v", err)
-			}
-			if err := ts.cacheService.InvalidateTaskListCache(); err != nil {
-				log.Printf("Failed to invalidate task list cache: %v", err)
-			}
-		}()
+	// 异步记录审计日志并使相关缓存失效
+	go func() {
+		details := map[string]interface{}{
+			"task_name":         task.Name,
+			"operation":         operation,
+			"old_status":        task.Status,
+			"canceled_commands": len(commands),
+		}
+		if err := ts.auditService.LogTaskAction(AuditActionTaskCanceled, taskID, operator, details); err != nil {
+			log.Printf("Failed to log task %s audit: %v", operation, err)
+		}
 
-		return nil
+		if err := ts.cacheService.InvalidateTaskCache(taskID); err != nil {
+			log.Printf("Failed to invalidate task cache: %v", err)
+		}
+		if err := ts.cacheService.InvalidateTaskListCache(); err != nil {
+			log.Printf("Failed to invalidate task list cache: %v", err)
+		}
+	}()
+
+	GetTaskEventHub().Publish(&TaskEvent{
+		Type:       TaskEventTask,
+		TaskID:     taskID,
+		Status:     string(models.TaskStatusCanceled),
+		Terminated: true,
 	})
+
+	return nil
 }
 
 // GetTaskStatus 获取任务状态
@@ -942,8 +1150,12 @@ func (ts *TaskService) getHostProgressDetails(taskID string) ([]map[string]inter
 	return hostDetails, nil
 }
 
-// AddTaskHosts 添加任务主机
-func (ts *TaskService) AddTaskHosts(taskID string, hostIDs []string) error {
+// AddTaskHosts 添加任务主机，requestedBy 为发起添加的用户，Agent 按该用户匹配命令策略；scope 为调用方可操作的主机范围
+func (ts *TaskService) AddTaskHosts(taskID string, hostIDs []string, requestedBy string, scope models.HostScope) error {
+	if err := ts.checkHostScope(hostIDs, scope); err != nil {
+		return err
+	}
+
 	// 先检查任务状态
 	var task models.Task
 	err := ts.db.Where("task_id = ?", taskID).First(&task).Error
@@ -974,15 +1186,20 @@ func (ts *TaskService) AddTaskHosts(taskID string, hostIDs []string) error {
 
 			// 创建命令记录
 			cmd := &models.Command{
-				CommandID:  commandID,
-				TaskID:     &taskID,
-				HostID:     hostID,
-				Command:    existingCommand.Command,
-				Parameters: existingCommand.Parameters,
-				Timeout:    existingCommand.Timeout,
-				Status:     models.CommandStatusPending,
-				CreatedAt:  time.Now(),
-				UpdatedAt:  time.Now(),
+				CommandID:   commandID,
+				TaskID:      &taskID,
+				HostID:      hostID,
+				Command:     existingCommand.Command,
+				Script:      existingCommand.Script,
+				File:        existingCommand.File,
+				Fetch:       existingCommand.Fetch,
+				Parameters:  existingCommand.Parameters,
+				Timeout:     existingCommand.Timeout,
+				RequestedBy: requestedBy,
+				Options:     existingCommand.Options,
+				Status:      models.CommandStatusPending,
+				CreatedAt:   time.Now(),
+				UpdatedAt:   time.Now(),
 			}
 
 			if err := tx.Create(cmd).Error; err != nil {
@@ -1023,8 +1240,12 @@ func (ts *TaskService) AddTaskHosts(taskID string, hostIDs []string) error {
 	return nil
 }
 
-// RemoveTaskHost 移除任务主机
-func (ts *TaskService) RemoveTaskHost(taskID, hostID string) error {
+// RemoveTaskHost 移除任务主机，scope 为调用方可操作的主机范围，任务的所有主机都须在范围内
+func (ts *TaskService) RemoveTaskHost(taskID, hostID string, scope models.HostScope) error {
+	if err := ts.checkTaskScope(taskID, scope); err != nil {
+		return err
+	}
+
 	// 先检查任务状态
 	var task models.Task
 	err := ts.db.Where("task_id = ?", taskID).First(&task).Error
@@ -1219,12 +1440,49 @@ func (ts *TaskService) GetTaskLogs(taskID string) (map[string]interface{}, error
 	return logResponse, nil
 }
 
+// ErrCommandNotFound 命令不存在（如所属任务已删除），Agent 无需重发该命令的结果
+var ErrCommandNotFound = errors.New("command not found")
+
+// 连接断开时标记命令失败的错误信息，Agent 重连后补报的结果会覆盖该状态
+const (
+	errMsgHostConnectionLost = "Host connection lost"
+	errMsgAgentDisconnected  = "Agent disconnected"
+)
+
 // HandleCommandResult 处理命令执行结果并更新任务状态
+// Agent 重连后会重放未被确认的结果，同一结果按 command_id 幂等处理；
+// 因连接断开被标记为失败的命令，收到补报的结果后更新为实际的最终状态
 func (ts *TaskService) HandleCommandResult(result *models.CommandResult) error {
+	// 收集命令的文件分片先于结果到达，结果到达后不再接收
+	forgetFetchTarget(result.CommandID)
+	finishPeerTarget(result)
+
+	var taskID string
+	var hostStatus string
+	var duplicate bool
+
+	// Agent 已按自身配置截断输出，这里按 Server 上限再次截断，避免超大输出写入数据库
+	var stdoutTruncated, stderrTruncated bool
+	result.Stdout, stdoutTruncated = models.TruncateOutput(result.Stdout, maxOutputBytes)
+	result.Stderr, stderrTruncated = models.TruncateOutput(result.Stderr, maxOutputBytes)
+	result.Truncated = result.Truncated || stdoutTruncated || stderrTruncated
+
 	// 使用事务更新命令结果和任务状态
-	return ts.db.Transaction(func(tx *gorm.DB) error {
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
 		now := time.Now()
 
+		var commandHost models.CommandHost
+		err := tx.Where("command_id = ? AND host_id = ?", result.CommandID, result.HostID).First(&commandHost).Error
+		if err == gorm.ErrRecordNotFound {
+			return fmt.Errorf("%w: %s on host %s", ErrCommandNotFound, result.CommandID, result.HostID)
+		} else if err != nil {
+			return fmt.Errorf("failed to get command host: %w", err)
+		}
+		if isDuplicateResult(&commandHost, result) {
+			duplicate = true
+			return nil
+		}
+
 		// 计算执行时长（如果有开始和结束时间）
 		if result.StartedAt != nil && result.FinishedAt != nil {
 			duration := result.FinishedAt.Sub(*result.StartedAt)
@@ -1234,19 +1492,28 @@ func (ts *TaskService) HandleCommandResult(result *models.CommandResult) error {
 
 		// 1. 更新 CommandHost 记录
 		hostUpdates := map[string]interface{}{
-			"stdout":         result.Stdout,
-			"stderr":         result.Stderr,
-			"exit_code":      result.ExitCode,
-			"started_at":     result.StartedAt,
-			"finished_at":    result.FinishedAt,
-			"error_message":  result.ErrorMessage,
-			"execution_time": result.ExecutionTime,
-			"updated_at":     now,
-		}
-
-		// 根据执行结果设置 CommandHost 状态
+			"stdout":             result.Stdout,
+			"stderr":             result.Stderr,
+			"exit_code":          result.ExitCode,
+			"started_at":         result.StartedAt,
+			"finished_at":        result.FinishedAt,
+			"error_message":      result.ErrorMessage,
+			"execution_time":     result.ExecutionTime,
+			"truncated":          result.Truncated,
+			"stdout_size":        result.StdoutSize,
+			"stderr_size":        result.StderrSize,
+			"termination_signal": result.TerminationSignal,
+			"oom_killed":         result.OOMKilled,
+			"updated_at":         now,
+		}
+
+		// 根据执行结果设置 CommandHost 状态，Agent 因超时终止进程树时记为超时，因繁忙拒绝时记为下发失败
 		if result.FinishedAt != nil {
-			if result.ExitCode == 0 {
+			if result.Busy {
+				hostUpdates["status"] = string(models.CommandHostStatusFailed)
+			} else if result.TimedOut {
+				hostUpdates["status"] = string(models.CommandHostStatusTimeout)
+			} else if result.ExitCode == 0 {
 				hostUpdates["status"] = string(models.CommandHostStatusCompleted)
 			} else {
 				hostUpdates["status"] = string(models.CommandHostStatusExecFailed)
@@ -1255,7 +1522,21 @@ func (ts *TaskService) HandleCommandResult(result *models.CommandResult) error {
 			hostUpdates["status"] = string(models.CommandHostStatusRunning)
 		}
 
-		err := tx.Model(&models.CommandHost{}).Where("command_id = ? AND host_id = ?", result.CommandID, result.HostID).Updates(hostUpdates).Error
+		// 已取消的命令只记录取消前的输出，保留取消状态
+		var canceled int64
+		err = tx.Model(&models.CommandHost{}).
+			Where("command_id = ? AND host_id = ? AND status = ?",
+				result.CommandID, result.HostID, string(models.CommandHostStatusCanceled)).
+			Count(&canceled).Error
+		if err != nil {
+			return fmt.Errorf("failed to check command host status: %w", err)
+		}
+		if canceled > 0 {
+			hostUpdates["status"] = string(models.CommandHostStatusCanceled)
+		}
+		hostStatus, _ = hostUpdates["status"].(string)
+
+		err = tx.Model(&models.CommandHost{}).Where("command_id = ? AND host_id = ?", result.CommandID, result.HostID).Updates(hostUpdates).Error
 		if err != nil {
 			return fmt.Errorf("failed to update command host: %w", err)
 		}
@@ -1268,12 +1549,19 @@ func (ts *TaskService) HandleCommandResult(result *models.CommandResult) error {
 			"started_at":  result.StartedAt,
 			"finished_at": result.FinishedAt,
 			"error_msg":   result.ErrorMessage,
+			"truncated":   result.Truncated,
 			"updated_at":  now,
 		}
 
 		// 设置命令状态
-		if result.FinishedAt != nil {
-			if result.ExitCode == 0 {
+		if canceled > 0 {
+			cmdUpdates["status"] = models.CommandStatusCanceled
+		} else if result.FinishedAt != nil {
+			if result.Busy {
+				cmdUpdates["status"] = models.CommandStatusFailed
+			} else if result.TimedOut {
+				cmdUpdates["status"] = models.CommandStatusTimeout
+			} else if result.ExitCode == 0 {
 				cmdUpdates["status"] = models.CommandStatusCompleted
 			} else {
 				cmdUpdates["status"] = models.CommandStatusFailed
@@ -1307,14 +1595,20 @@ func (ts *TaskService) HandleCommandResult(result *models.CommandResult) error {
 		} else {
 			// 记录已存在，更新现有记录
 			err = tx.Model(&existingResult).Updates(map[string]interface{}{
-				"stdout":         result.Stdout,
-				"stderr":         result.Stderr,
-				"exit_code":      result.ExitCode,
-				"started_at":     result.StartedAt,
-				"finished_at":    result.FinishedAt,
-				"error_message":  result.ErrorMessage,
-				"execution_time": result.ExecutionTime,
-				"updated_at":     now,
+				"stdout":             result.Stdout,
+				"stderr":             result.Stderr,
+				"exit_code":          result.ExitCode,
+				"started_at":         result.StartedAt,
+				"finished_at":        result.FinishedAt,
+				"error_message":      result.ErrorMessage,
+				"execution_time":     result.ExecutionTime,
+				"truncated":          result.Truncated,
+				"stdout_size":        result.StdoutSize,
+				"stderr_size":        result.StderrSize,
+				"timed_out":          result.TimedOut,
+				"termination_signal": result.TerminationSignal,
+				"oom_killed":         result.OOMKilled,
+				"updated_at":         now,
 			}).Error
 			if err != nil {
 				return fmt.Errorf("failed to update command result: %w", err)
@@ -1329,6 +1623,8 @@ func (ts *TaskService) HandleCommandResult(result *models.CommandResult) error {
 		}
 
 		if command.TaskID != nil {
+			taskID = *command.TaskID
+
 			// 更新任务进度和状态
 			err = ts.updateTaskProgressInTransaction(tx, *command.TaskID)
 			if err != nil {
@@ -1351,6 +1647,15 @@ func (ts *TaskService) HandleCommandResult(result *models.CommandResult) error {
 				"finished_at":    result.FinishedAt,
 				"error_message":  result.ErrorMessage,
 			}
+			if result.TerminationSignal != "" {
+				details["termination_signal"] = result.TerminationSignal
+			}
+			if result.OOMKilled {
+				details["oom_killed"] = true
+			}
+			if result.Busy {
+				details["busy"] = true
+			}
 
 			// 根据执行结果选择审计动作
 			var auditAction AuditAction
@@ -1358,7 +1663,19 @@ func (ts *TaskService) HandleCommandResult(result *models.CommandResult) error {
 			var logMessage string
 
 			if result.FinishedAt != nil {
-				if result.ExitCode == 0 {
+				if result.Busy {
+					auditAction = AuditActionCommandError
+					logLevel = "WARN"
+					logMessage = fmt.Sprintf("Command rejected by host %s: agent execution queue is full", result.HostID)
+				} else if result.TimedOut {
+					auditAction = AuditActionCommandTimeout
+					logLevel = "ERROR"
+					logMessage = fmt.Sprintf("Command timed out on host %s, terminated with %s", result.HostID, result.TerminationSignal)
+				} else if result.OOMKilled {
+					auditAction = AuditActionCommandError
+					logLevel = "ERROR"
+					logMessage = fmt.Sprintf("Command killed by OOM killer on host %s after exceeding its memory limit", result.HostID)
+				} else if result.ExitCode == 0 {
 					auditAction = AuditActionCommandResult
 					logLevel = "INFO"
 					logMessage = fmt.Sprintf("Command completed successfully on host %s", result.HostID)
@@ -1397,6 +1714,283 @@ func (ts *TaskService) HandleCommandResult(result *models.CommandResult) error {
 
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	if duplicate {
+		log.Printf("Ignoring duplicate result of command %s from host %s", result.CommandID, result.HostID)
+		return nil
+	}
+
+	// 事务提交后推送状态变化给实时订阅方
+	if taskID != "" {
+		ts.publishCommandResult(taskID, result, hostStatus)
+	}
+	return nil
+}
+
+// isDuplicateResult 检查结果是否已处理过：主机命令已记录相同完成时间的结果，且不是连接断开时标记的失败
+func isDuplicateResult(commandHost *models.CommandHost, result *models.CommandResult) bool {
+	if commandHost.FinishedAt == nil || result.FinishedAt == nil {
+		return false
+	}
+	if commandHost.ErrorMessage == errMsgHostConnectionLost || commandHost.ErrorMessage == errMsgAgentDisconnected {
+		return false
+	}
+	// 数据库时间精度可能低于 Agent 上报的时间，按秒比较
+	return commandHost.FinishedAt.Truncate(time.Second).Equal(result.FinishedAt.Truncate(time.Second))
+}
+
+// publishCommandResult 推送主机命令状态变化，任务状态随之变化时一并推送
+func (ts *TaskService) publishCommandResult(taskID string, result *models.CommandResult, status string) {
+	hub := GetTaskEventHub()
+
+	exitCode := result.ExitCode
+	hub.Publish(&TaskEvent{
+		Type:      TaskEventStatus,
+		TaskID:    taskID,
+		HostID:    result.HostID,
+		CommandID: result.CommandID,
+		Status:    status,
+		ExitCode:  &exitCode,
+	})
+
+	var task models.Task
+	if err := ts.db.Where("task_id = ?", taskID).First(&task).Error; err != nil {
+		log.Printf("Failed to load task %s for event: %v", taskID, err)
+		return
+	}
+	hub.Publish(&TaskEvent{
+		Type:       TaskEventTask,
+		TaskID:     taskID,
+		Status:     string(task.Status),
+		Terminated: task.IsCompleted(),
+	})
+}
+
+// HandleCommandOutput 处理命令执行过程中的输出分片，追加到 CommandHost
+// 序号不大于已追加序号的分片（重复或乱序）以及命令结束后到达的分片会被忽略
+// 数据库中的输出达到上限后不再追加，启用转存时完整输出写入输出存储
+func (ts *TaskService) HandleCommandOutput(chunk *models.CommandOutputChunk) error {
+	column := "stdout"
+	if chunk.Stream == models.OutputStreamStderr {
+		column = "stderr"
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		column:       gorm.Expr("CONCAT(IFNULL("+column+", ''), ?)", chunk.Data),
+		"output_seq": chunk.Sequence,
+		"updated_at": now,
+	}
+
+	store := GetOutputStore()
+	var key string
+	if store != nil {
+		key = spoolKey(chunk.CommandID, chunk.HostID, chunk.Stream)
+		updates[column+"_spool"] = key
+	}
+
+	query := ts.db.Model(&models.CommandHost{}).
+		Where("command_id = ? AND host_id = ? AND output_seq < ? AND finished_at IS NULL",
+			chunk.CommandID, chunk.HostID, chunk.Sequence).
+		Session(&gorm.Session{})
+	result := query.
+		Where("LENGTH(IFNULL("+column+", '')) + ? <= ?", len(chunk.Data), maxOutputBytes).
+		Updates(updates)
+	if result.Error != nil {
+		return fmt.Errorf("failed to append command output: %w", result.Error)
+	}
+
+	appended := result.RowsAffected > 0
+	if !appended {
+		// 输出已达上限，只记录分片序号并标记截断
+		delete(updates, column)
+		updates["truncated"] = true
+		result = query.Updates(updates)
+		if result.Error != nil {
+			return fmt.Errorf("failed to update command output sequence: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			log.Printf("Ignored output chunk %d of command %s from host %s", chunk.Sequence, chunk.CommandID, chunk.HostID)
+			return nil
+		}
+	}
+
+	if store != nil {
+		if err := store.Append(key, []byte(chunk.Data)); err != nil {
+			log.Printf("Failed to spool output chunk %d of command %s: %v", chunk.Sequence, chunk.CommandID, err)
+		}
+	}
+
+	// 收到输出说明命令已开始执行
+	started := ts.db.Model(&models.CommandHost{}).
+		Where("command_id = ? AND host_id = ? AND status = ?",
+			chunk.CommandID, chunk.HostID, string(models.CommandHostStatusPending)).
+		Updates(map[string]interface{}{
+			"status":     string(models.CommandHostStatusRunning),
+			"started_at": chunk.Timestamp,
+			"updated_at": now,
+		})
+	if started.Error != nil {
+		return fmt.Errorf("failed to mark command host running: %w", started.Error)
+	}
+
+	ts.publishCommandOutput(chunk, column, started.RowsAffected > 0, appended)
+	return nil
+}
+
+// publishCommandOutput 推送已追加的输出分片，事件中的偏移量取自追加后的输出长度
+// 未追加到数据库的分片（超过输出上限）不推送，保证实时输出与数据库一致
+func (ts *TaskService) publishCommandOutput(chunk *models.CommandOutputChunk, column string, started, appended bool) {
+	if !started && !appended {
+		return
+	}
+
+	var row struct {
+		TaskID *string
+		Length int
+	}
+	err := ts.db.Table(models.CommandHost{}.TableName()+" AS ch").
+		Select("c.task_id AS task_id, LENGTH(ch."+column+") AS length").
+		Joins("JOIN "+models.Command{}.TableName()+" AS c ON c.command_id = ch.command_id").
+		Where("ch.command_id = ? AND ch.host_id = ?", chunk.CommandID, chunk.HostID).
+		Scan(&row).Error
+	if err != nil {
+		log.Printf("Failed to load output offset of command %s: %v", chunk.CommandID, err)
+		return
+	}
+	if row.TaskID == nil {
+		return
+	}
+
+	hub := GetTaskEventHub()
+	if started {
+		hub.Publish(&TaskEvent{
+			Type:      TaskEventStatus,
+			TaskID:    *row.TaskID,
+			HostID:    chunk.HostID,
+			CommandID: chunk.CommandID,
+			Status:    string(models.CommandHostStatusRunning),
+			Timestamp: chunk.Timestamp,
+		})
+	}
+	if appended {
+		hub.Publish(&TaskEvent{
+			Type:      TaskEventOutput,
+			TaskID:    *row.TaskID,
+			HostID:    chunk.HostID,
+			CommandID: chunk.CommandID,
+			Stream:    chunk.Stream,
+			Offset:    row.Length - len(chunk.Data),
+			Data:      chunk.Data,
+			Timestamp: chunk.Timestamp,
+		})
+	}
+}
+
+// GetTaskOutputSnapshot 获取任务状态及全部主机当前的命令输出，用于实时订阅建立时的快照
+func (ts *TaskService) GetTaskOutputSnapshot(taskID string) (*models.Task, []*models.CommandOutput, error) {
+	var task models.Task
+	err := ts.db.Where("task_id = ?", taskID).First(&task).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil, fmt.Errorf("task not found: %s", taskID)
+		}
+		return nil, nil, fmt.Errorf("failed to get task: %w", err)
+	}
+
+	var commandHosts []models.CommandHost
+	err = ts.db.Where("command_id IN (SELECT command_id FROM commands WHERE task_id = ?)", taskID).
+		Order("id ASC").Find(&commandHosts).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get task hosts: %w", err)
+	}
+
+	outputs := make([]*models.CommandOutput, 0, len(commandHosts))
+	for _, cmdHost := range commandHosts {
+		outputs = append(outputs, &models.CommandOutput{
+			CommandID:    cmdHost.CommandID,
+			HostID:       cmdHost.HostID,
+			Status:       cmdHost.Status,
+			Finished:     cmdHost.IsCompleted(),
+			Stdout:       cmdHost.Stdout,
+			Stderr:       cmdHost.Stderr,
+			StdoutOffset: len(cmdHost.Stdout),
+			StderrOffset: len(cmdHost.Stderr),
+			OutputSeq:    cmdHost.OutputSeq,
+			Truncated:    cmdHost.Truncated,
+			StdoutSize:   cmdHost.StdoutSize,
+			StderrSize:   cmdHost.StderrSize,
+			Spooled:      cmdHost.StdoutSpool != "" || cmdHost.StderrSpool != "",
+		})
+	}
+
+	return &task, outputs, nil
+}
+
+// GetCommandHostOutput 获取任务在指定主机上的命令输出，从给定的字节偏移量开始增量返回
+func (ts *TaskService) GetCommandHostOutput(taskID, hostID string, stdoutOffset, stderrOffset int) (*models.CommandOutput, error) {
+	var command models.Command
+	err := ts.db.Where("task_id = ? AND host_id = ?", taskID, hostID).First(&command).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("host %s not found in task %s", hostID, taskID)
+		}
+		return nil, fmt.Errorf("failed to get command: %w", err)
+	}
+
+	var cmdHost models.CommandHost
+	err = ts.db.Where("command_id = ? AND host_id = ?", command.CommandID, hostID).First(&cmdHost).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get command host: %w", err)
+	}
+
+	stdoutOffset = min(max(stdoutOffset, 0), len(cmdHost.Stdout))
+	stderrOffset = min(max(stderrOffset, 0), len(cmdHost.Stderr))
+
+	return &models.CommandOutput{
+		CommandID:    command.CommandID,
+		HostID:       hostID,
+		Status:       cmdHost.Status,
+		Finished:     cmdHost.IsCompleted(),
+		Stdout:       cmdHost.Stdout[stdoutOffset:],
+		Stderr:       cmdHost.Stderr[stderrOffset:],
+		StdoutOffset: len(cmdHost.Stdout),
+		StderrOffset: len(cmdHost.Stderr),
+		OutputSeq:    cmdHost.OutputSeq,
+		Truncated:    cmdHost.Truncated,
+		StdoutSize:   cmdHost.StdoutSize,
+		StderrSize:   cmdHost.StderrSize,
+		Spooled:      cmdHost.StdoutSpool != "" || cmdHost.StderrSpool != "",
+	}, nil
+}
+
+// OpenSpooledOutput 打开任务在指定主机上转存的完整输出
+func (ts *TaskService) OpenSpooledOutput(taskID, hostID, stream string) (io.ReadCloser, error) {
+	store := GetOutputStore()
+	if store == nil {
+		return nil, fmt.Errorf("output spooling is not enabled")
+	}
+
+	var cmdHost models.CommandHost
+	err := ts.db.Where("host_id = ? AND command_id IN (SELECT command_id FROM commands WHERE task_id = ?)", hostID, taskID).
+		First(&cmdHost).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("host %s not found in task %s", hostID, taskID)
+		}
+		return nil, fmt.Errorf("failed to get command host: %w", err)
+	}
+
+	key := cmdHost.StdoutSpool
+	if stream == models.OutputStreamStderr {
+		key = cmdHost.StderrSpool
+	}
+	if key == "" {
+		return nil, fmt.Errorf("no spooled %s output for host %s", stream, hostID)
+	}
+	return store.Open(key)
 }
 
 // updateTaskProgressInTransaction 在事务中更新任务进度
@@ -1549,48 +2143,21 @@ func (ts *TaskService) updateTaskProgressInTransaction(tx *gorm.DB, taskID strin
 }
 
 // HandleHostConnectionChange 处理主机连接状态变化
+// 主机断开后不立即将命令标记为失败，而是等待 Agent 在宽限期内重连对账
 func (ts *TaskService) HandleHostConnectionChange(hostID string, connected bool) error {
 	if !connected {
-		// 主机断开连接，标记相关的运行中命令为失败
-		updates := map[string]interface{}{
-			"status":        string(models.CommandHostStatusFailed),
-			"error_message": "Host connection lost",
-			"updated_at":    time.Now(),
-		}
-
-		err := ts.db.Model(&models.CommandHost{}).
-			Where("host_id = ? AND status = ?", hostID, string(models.CommandHostStatusRunning)).
-			Updates(updates).Error
-		if err != nil {
-			return fmt.Errorf("failed to update disconnected host commands: %w", err)
-		}
-
-		// 同时更新 Command 记录
-		cmdUpdates := map[string]interface{}{
-			"status":     models.CommandStatusFailed,
-			"error_msg":  "Host connection lost",
-			"updated_at": time.Now(),
-		}
-
-		err = ts.db.Model(&models.Command{}).
-			Where("host_id = ? AND status = ?", hostID, models.CommandStatusRunning).
-			Updates(cmdUpdates).Error
-		if err != nil {
-			return fmt.Errorf("failed to update disconnected host commands: %w", err)
-		}
+		ts.scheduleDisconnectFailure(hostID)
 
 		// 记录主机断开连接的审计日志
 		go func() {
 			details := map[string]interface{}{
 				"connection_status": "disconnected",
-				"reason":            "Host connection lost",
+				"reason":            errMsgHostConnectionLost,
 			}
 			if err := ts.auditService.LogHostAction(AuditActionHostDisconnect, hostID, details); err != nil {
 				log.Printf("Failed to log host disconnection audit: %v", err)
 			}
 		}()
-
-		log.Printf("Marked running commands as failed for disconnected host: %s", hostID)
 	} else {
 		// 记录主机连接的审计日志
 		go func() {
@@ -1774,23 +2341,29 @@ func (ts *TaskService) GetTaskStatistics() (map[string]interface{}, error) {
 	return stats, nil
 }
 
-// GetTasksByHost 按主机筛选任务
-func (ts *TaskService) GetTasksByHost(hostID string, page, size int, status string) ([]*models.Task, int, error) {
-	// 生成缓存键
+// GetTasksByHost 按主机筛选任务，只返回目标主机都在 scope 内的任务
+func (ts *TaskService) GetTasksByHost(hostID string, page, size int, status string, scope models.HostScope) ([]*models.Task, int, error) {
+	// 生成缓存键，限制了主机范围的查询不使用缓存
 	cacheKey := ts.cacheService.GenerateHostTasksCacheKey(page, size, status)
+	cacheable := !scope.IsRestricted()
 
 	// 尝试从缓存获取
-	if cachedTasks, cachedTotal, err := ts.cacheService.GetCachedHostTasks(hostID, cacheKey); err == nil && cachedTasks != nil {
-		log.Printf("Host tasks cache hit: %s, key: %s", hostID, cacheKey)
-		return cachedTasks, cachedTotal, nil
+	if cacheable {
+		if cachedTasks, cachedTotal, err := ts.cacheService.GetCachedHostTasks(hostID, cacheKey); err == nil && cachedTasks != nil {
+			log.Printf("Host tasks cache hit: %s, key: %s", hostID, cacheKey)
+			return cachedTasks, cachedTotal, nil
+		}
 	}
 
 	var tasks []models.Task
 	var total int64
 
 	// 构建查询条件
-	query := ts.db.Model(&models.Task{}).
-		Where("task_id IN (SELECT DISTINCT task_id FROM commands WHERE host_id = ?)", hostID)
+	query, err := ts.scopeTasks(ts.db.Model(&models.Task{}), scope)
+	if err != nil {
+		return nil, 0, err
+	}
+	query = query.Where("task_id IN (SELECT DISTINCT task_id FROM commands WHERE host_id = ?)", hostID)
 
 	// 状态过滤
 	if status != "" {
@@ -1815,22 +2388,28 @@ func (ts *TaskService) GetTasksByHost(hostID string, page, size int, status stri
 	}
 
 	// 异步缓存结果
-	go func() {
-		if err := ts.cacheService.CacheHostTasks(hostID, cacheKey, result, int(total)); err != nil {
-			log.Printf("Failed to cache host tasks: %v", err)
-		}
-	}()
+	if cacheable {
+		go func() {
+			if err := ts.cacheService.CacheHostTasks(hostID, cacheKey, result, int(total)); err != nil {
+				log.Printf("Failed to cache host tasks: %v", err)
+			}
+		}()
+	}
 
 	return result, int(total), nil
 }
 
-// GetTasksByStatus 按状态筛选任务
-func (ts *TaskService) GetTasksByStatus(status string, page, size int) ([]*models.Task, int, error) {
+// GetTasksByStatus 按状态筛选任务，只返回目标主机都在 scope 内的任务
+func (ts *TaskService) GetTasksByStatus(status string, page, size int, scope models.HostScope) ([]*models.Task, int, error) {
 	var tasks []models.Task
 	var total int64
 
 	// 构建查询条件
-	query := ts.db.Model(&models.Task{}).Where("status = ?", status)
+	query, err := ts.scopeTasks(ts.db.Model(&models.Task{}), scope)
+	if err != nil {
+		return nil, 0, err
+	}
+	query = query.Where("status = ?", status)
 
 	// 获取总数
 	if err := query.Count(&total).Error; err != nil {
@@ -1852,14 +2431,17 @@ func (ts *TaskService) GetTasksByStatus(status string, page, size int) ([]*model
 	return result, int(total), nil
 }
 
-// GetTasksByDateRange 按日期范围筛选任务
-func (ts *TaskService) GetTasksByDateRange(startDate, endDate time.Time, page, size int, status string) ([]*models.Task, int, error) {
+// GetTasksByDateRange 按日期范围筛选任务，只返回目标主机都在 scope 内的任务
+func (ts *TaskService) GetTasksByDateRange(startDate, endDate time.Time, page, size int, status string, scope models.HostScope) ([]*models.Task, int, error) {
 	var tasks []models.Task
 	var total int64
 
 	// 构建查询条件
-	query := ts.db.Model(&models.Task{}).
-		Where("created_at >= ? AND created_at <= ?", startDate, endDate)
+	query, err := ts.scopeTasks(ts.db.Model(&models.Task{}), scope)
+	if err != nil {
+		return nil, 0, err
+	}
+	query = query.Where("created_at >= ? AND created_at <= ?", startDate, endDate)
 
 	// 状态过滤
 	if status != "" {
@@ -2096,47 +2678,42 @@ func (ts *TaskService) GetTaskExecutionSummary(taskID string) (map[string]interf
 	return summary, nil
 }
 
-// sendCancelCommandToAgent 向 Agent 发送取消命令
-func (ts *TaskService) sendCancelCommandToAgent(command models.Command) error {
-	if taskDispatcher == nil {
-		return fmt.Errorf("task dispatcher not available")
-	}
-
-	// 创建取消命令
-	cancelCommand := &models.Command{
-		CommandID:  "cancel-" + command.CommandID,
-		HostID:     command.HostID,
-		Command:    "cancel",
-		Parameters: command.CommandID, // 传递要取消的命令ID
-		Timeout:    30,                // 取消命令的超时时间
-		Status:     models.CommandStatusPending,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
-	}
-
-	// 发送取消命令到 Agent
-	return taskDispatcher.SendCommandToAgent(command.HostID, cancelCommand)
+// HandleAgentDisconnection 处理 Agent 断开连接，立即将该主机已下发的待执行和运行中命令标记为失败
+func (ts *TaskService) HandleAgentDisconnection(hostID string) error {
+	cancelDisconnectFailure(hostID)
+	return ts.failDisconnectedCommands(hostID, time.Now())
 }
 
-// HandleAgentDisconnection 处理 Agent 断开连接
-func (ts *TaskService) HandleAgentDisconnection(hostID string) error {
+// failDisconnectedCommands 将主机在 before 之前下发、仍处于待执行或运行中的命令标记为失败
+func (ts *TaskService) failDisconnectedCommands(hostID string, before time.Time) error {
 	return ts.db.Transaction(func(tx *gorm.DB) error {
 		now := time.Now()
 
-		// 标记该主机上所有运行中的命令为失败
+		// 只处理已启动任务中断开前下发的命令，重连后下发的命令不受影响
+		var commandIDs []string
+		err := tx.Model(&models.CommandHost{}).
+			Where("host_id = ? AND updated_at < ? AND command_id IN (?)", hostID, before, dispatchedCommandsQuery(tx)).
+			Where("status IN (?)", []string{
+				string(models.CommandHostStatusPending),
+				string(models.CommandHostStatusRunning),
+			}).
+			Pluck("command_id", &commandIDs).Error
+		if err != nil {
+			return fmt.Errorf("failed to get commands for disconnected agent: %w", err)
+		}
+		if len(commandIDs) == 0 {
+			return nil
+		}
+
+		// 标记命令为失败
 		cmdUpdates := map[string]interface{}{
 			"status":      models.CommandStatusFailed,
 			"finished_at": now,
-			"error_msg":   "Agent disconnected",
+			"error_msg":   errMsgAgentDisconnected,
 			"updated_at":  now,
 		}
 
-		err := tx.Model(&models.Command{}).
-			Where("host_id = ? AND status IN (?)", hostID, []models.CommandStatus{
-				models.CommandStatusPending,
-				models.CommandStatusRunning,
-			}).
-			Updates(cmdUpdates).Error
+		err = tx.Model(&models.Command{}).Where("command_id IN (?)", commandIDs).Updates(cmdUpdates).Error
 		if err != nil {
 			return fmt.Errorf("failed to update commands for disconnected agent: %w", err)
 		}
@@ -2145,15 +2722,12 @@ func (ts *TaskService) HandleAgentDisconnection(hostID string) error {
 		hostUpdates := map[string]interface{}{
 			"status":        string(models.CommandHostStatusFailed),
 			"finished_at":   now,
-			"error_message": "Agent disconnected",
+			"error_message": errMsgAgentDisconnected,
 			"updated_at":    now,
 		}
 
 		err = tx.Model(&models.CommandHost{}).
-			Where("host_id = ? AND status IN (?)", hostID, []string{
-				string(models.CommandHostStatusPending),
-				string(models.CommandHostStatusRunning),
-			}).
+			Where("command_id IN (?) AND host_id = ?", commandIDs, hostID).
 			Updates(hostUpdates).Error
 		if err != nil {
 			return fmt.Errorf("failed to update command hosts for disconnected agent: %w", err)
@@ -2163,7 +2737,7 @@ func (ts *TaskService) HandleAgentDisconnection(hostID string) error {
 		var affectedTaskIDs []string
 		err = tx.Model(&models.Command{}).
 			Select("DISTINCT task_id").
-			Where("host_id = ? AND status = ?", hostID, models.CommandStatusFailed).
+			Where("command_id IN (?)", commandIDs).
 			Pluck("task_id", &affectedTaskIDs).Error
 		if err != nil {
 			return fmt.Errorf("failed to get affected tasks: %w", err)
@@ -2179,7 +2753,7 @@ func (ts *TaskService) HandleAgentDisconnection(hostID string) error {
 			}
 		}
 
-		log.Printf("Handled agent disconnection for host %s, affected %d tasks", hostID, len(affectedTaskIDs))
+		log.Printf("Handled agent disconnection for host %s, failed %d commands in %d tasks", hostID, len(commandIDs), len(affectedTaskIDs))
 		return nil
 	})
 }
@@ -2234,8 +2808,8 @@ func (ts *TaskService) HandleCommandExecutionError(commandID, hostID, errorMessa
 	})
 }
 
-// RetryFailedCommand 重试失败的命令
-func (ts *TaskService) RetryFailedCommand(commandID string) error {
+// RetryFailedCommand 重试失败的命令，scope 为调用方可操作的主机范围
+func (ts *TaskService) RetryFailedCommand(commandID string, scope models.HostScope) error {
 	return ts.db.Transaction(func(tx *gorm.DB) error {
 		// 获取失败的命令
 		var command models.Command
@@ -2249,16 +2823,22 @@ func (ts *TaskService) RetryFailedCommand(commandID string) error {
 			}
 			return fmt.Errorf("failed to get command: %w", err)
 		}
+		if err := ts.checkHostScope([]string{command.HostID}, scope); err != nil {
+			return err
+		}
 
 		// 重置命令状态
 		now := time.Now()
+		// 递增执行次数，Agent 据此区分手动重试和重复投递
 		cmdUpdates := map[string]interface{}{
 			"status":      models.CommandStatusPending,
+			"attempt":     gorm.Expr("attempt + 1"),
 			"started_at":  nil,
 			"finished_at": nil,
 			"error_msg":   "",
 			"stdout":      "",
 			"stderr":      "",
+			"truncated":   false,
 			"exit_code":   nil,
 			"updated_at":  now,
 		}
@@ -2270,15 +2850,24 @@ func (ts *TaskService) RetryFailedCommand(commandID string) error {
 
 		// 重置 CommandHost 状态
 		hostUpdates := map[string]interface{}{
-			"status":         string(models.CommandHostStatusPending),
-			"started_at":     nil,
-			"finished_at":    nil,
-			"error_message":  "",
-			"stdout":         "",
-			"stderr":         "",
-			"exit_code":      0,
-			"execution_time": nil,
-			"updated_at":     now,
+			"status":             string(models.CommandHostStatusPending),
+			"started_at":         nil,
+			"finished_at":        nil,
+			"error_message":      "",
+			"stdout":             "",
+			"output_seq":         0,
+			"stderr":             "",
+			"exit_code":          0,
+			"execution_time":     nil,
+			"truncated":          false,
+			"stdout_size":        0,
+			"stderr_size":        0,
+			"stdout_spool":       "",
+			"stderr_spool":       "",
+			"termination_signal": "",
+			"oom_killed":         false,
+			"delivered_at":       nil,
+			"updated_at":         now,
 		}
 
 		err = tx.Model(&models.CommandHost{}).Where("command_id = ?", commandID).Updates(hostUpdates).Error
@@ -2286,6 +2875,16 @@ func (ts *TaskService) RetryFailedCommand(commandID string) error {
 			return fmt.Errorf("failed to reset command host status: %w", err)
 		}
 
+		// 删除上次执行转存的完整输出
+		if store := GetOutputStore(); store != nil {
+			for _, stream := range []string{models.OutputStreamStdout, models.OutputStreamStderr} {
+				if err := store.Remove(spoolKey(commandID, command.HostID, stream)); err != nil {
+					log.Printf("Failed to remove spooled output of command %s: %v", commandID, err)
+				}
+			}
+		}
+		removeCollectedFiles(&command)
+
 		// 重新发送命令到 Agent
 		if taskDispatcher != nil {
 			// 重新加载命令信息
@@ -2320,8 +2919,8 @@ func (ts *TaskService) RetryFailedCommand(commandID string) error {
 	})
 }
 
-// GetFailedCommands 获取失败的命令列表
-func (ts *TaskService) GetFailedCommands(page, size int, hostID string) ([]models.Command, int, error) {
+// GetFailedCommands 获取失败的命令列表，只返回 scope 内主机的命令
+func (ts *TaskService) GetFailedCommands(page, size int, hostID string, scope models.HostScope) ([]models.Command, int, error) {
 	var commands []models.Command
 	var total int64
 
@@ -2331,6 +2930,15 @@ func (ts *TaskService) GetFailedCommands(page, size int, hostID string) ([]model
 		models.CommandStatusTimeout,
 	})
 
+	// 主机范围过滤
+	if scope.IsRestricted() {
+		hostIDs, err := ts.scopeHostIDs(scope)
+		if err != nil {
+			return nil, 0, err
+		}
+		query = query.Where("host_id IN ?", hostIDs)
+	}
+
 	// 主机过滤
 	if hostID != "" {
 		query = query.Where("host_id = ?", hostID)
@@ -2873,6 +3481,22 @@ func (ts *TaskService) UpdateHostLoad(hostID string, cpuUsage, memoryUsage float
 	}
 }
 
+// UpdateHostLoadFromStatus 根据主机状态上报更新主机负载，包括资源使用率和 Agent 执行队列状态
+func (ts *TaskService) UpdateHostLoadFromStatus(status *protobuf.HostStatus) {
+	if ts.queueManager == nil {
+		return
+	}
+	ts.queueManager.UpdateHostLoad(status.HostId, status.GetCpu().GetUsagePercent(), status.GetMemory().GetUsagePercent(), true)
+	// 旧版本 Agent 不上报执行队列状态
+	if status.MaxConcurrentCommands > 0 {
+		ts.queueManager.UpdateAgentLoad(status.HostId,
+			int(status.RunningCommands),
+			int(status.QueuedCommands),
+			int(status.MaxConcurrentCommands),
+			int(status.CommandQueueCapacity))
+	}
+}
+
 // CancelQueuedTask 取消队列中的任务
 func (ts *TaskService) CancelQueuedTask(taskID string) error {
 	if ts.queueManager == nil {
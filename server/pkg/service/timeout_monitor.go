@@ -17,6 +17,7 @@ type TimeoutMonitor struct {
 	db            *gorm.DB
 	taskService   *TaskService
 	checkInterval time.Duration
+	gracePeriod   time.Duration // 超时后留给 Agent 终止进程树并上报结果的时间
 	ctx           context.Context
 	cancel        context.CancelFunc
 	wg            sync.WaitGroup
@@ -31,6 +32,7 @@ func NewTimeoutMonitor(db *gorm.DB, taskService *TaskService) *TimeoutMonitor {
 		db:            db,
 		taskService:   taskService,
 		checkInterval: 30 * time.Second, // 每30秒检查一次
+		gracePeriod:   30 * time.Second,
 		ctx:           ctx,
 		cancel:        cancel,
 		running:       false,
@@ -137,7 +139,7 @@ func (tm *TimeoutMonitor) isCommandTimeout(cmd models.Command, now time.Time) bo
 
 	// 计算执行时长
 	executionDuration := now.Sub(*cmd.StartedAt)
-	timeoutDuration := time.Duration(cmd.Timeout) * time.Second
+	timeoutDuration := time.Duration(cmd.Timeout)*time.Second + tm.gracePeriod
 
 	return executionDuration > timeoutDuration
 }
@@ -153,8 +155,9 @@ func (tm *TimeoutMonitor) handleTimeoutCommands(timeoutCommands []models.Command
 }
 
 // handleSingleTimeoutCommand 处理单个超时命令
+// Agent 未在宽限期内上报超时结果时，由 Server 判定超时并通知 Agent 终止进程树
 func (tm *TimeoutMonitor) handleSingleTimeoutCommand(cmd models.Command) error {
-	return tm.db.Transaction(func(tx *gorm.DB) error {
+	err := tm.db.Transaction(func(tx *gorm.DB) error {
 		now := time.Now()
 
 		// 更新命令状态为超时
@@ -194,6 +197,24 @@ func (tm *TimeoutMonitor) handleSingleTimeoutCommand(cmd models.Command) error {
 		log.Printf("Command %s marked as timeout for host %s", cmd.CommandID, cmd.HostID)
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := tm.taskService.sendCancelControl(cmd, ""); err != nil {
+			log.Printf("Failed to send cancel control for timeout command %s to agent %s: %v", cmd.CommandID, cmd.HostID, err)
+		}
+	}()
+
+	if cmd.TaskID != nil {
+		tm.taskService.publishCommandResult(*cmd.TaskID, &models.CommandResult{
+			CommandID: cmd.CommandID,
+			HostID:    cmd.HostID,
+			ExitCode:  -1,
+		}, string(models.CommandHostStatusTimeout))
+	}
+	return nil
 }
 
 // CheckCommandTimeout 手动检查特定命令的超时状态
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+
+	"devops-manager/api/ratelimit"
+)
+
+var (
+	// transferLimiter Server 所有文件传输共享的总带宽
+	transferLimiter = ratelimit.New(0)
+	// hostBandwidth 每台主机的文件传输带宽（字节/秒），为 0 时不限速
+	hostBandwidth int64
+)
+
+// HostBandwidth 主机文件传输的限速（字节/秒），任务指定的限速与配置的每主机限速取较小值，为 0 时不限速
+func HostBandwidth(taskLimit int64) int64 {
+	if taskLimit <= 0 {
+		return hostBandwidth
+	}
+	if hostBandwidth <= 0 || taskLimit < hostBandwidth {
+		return taskLimit
+	}
+	return hostBandwidth
+}
+
+// NewHostLimiter 创建一次文件传输的主机限速器，不限速时返回 nil
+func NewHostLimiter(taskLimit int64) *ratelimit.Limiter {
+	bandwidth := HostBandwidth(taskLimit)
+	if bandwidth <= 0 {
+		return nil
+	}
+	return ratelimit.New(bandwidth)
+}
+
+// WaitTransfer 传输 n 字节前等待主机限速和 Server 总带宽，ctx 结束时返回其错误
+func WaitTransfer(ctx context.Context, host *ratelimit.Limiter, n int) error {
+	return ratelimit.WaitAll(ctx, n, host, transferLimiter)
+}
@@ -15,11 +15,11 @@ request.interceptors.request.use(
     // 在发送请求之前做些什么
     console.log('Request:', config.method?.toUpperCase(), config.url)
     
-    // 可以在这里添加token等认证信息
-    // const token = localStorage.getItem('token')
-    // if (token) {
-    //   config.headers.Authorization = `Bearer ${token}`
-    // }
+    // 携带 API 令牌
+    const token = localStorage.getItem('token')
+    if (token) {
+      config.headers.Authorization = `Bearer ${token}`
+    }
     
     return config
   },